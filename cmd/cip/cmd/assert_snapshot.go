@@ -0,0 +1,75 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/cli"
+)
+
+var assertSnapshotOpts = &cli.AssertSnapshotOptions{}
+
+// assertSnapshotCmd is the command when calling `cip assert-snapshot`.
+var assertSnapshotCmd = &cobra.Command{
+	Use:   "assert-snapshot",
+	Short: "check that promoting manifests yields an expected snapshot",
+	Long: `assert-snapshot - check that promoting manifests yields an expected snapshot
+
+Compute the snapshot that promoting the given manifests into an empty
+destination registry would produce (like 'cip run --manifest-based-snapshot-of'),
+and compare it to an expected snapshot file. If they differ, the diff is printed
+and the command fails.`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cli.RunAssertSnapshotCmd(assertSnapshotOpts)
+	},
+}
+
+func init() {
+	assertSnapshotCmd.PersistentFlags().StringVar(
+		&assertSnapshotOpts.Manifest,
+		cli.PromoterManifestFlag,
+		assertSnapshotOpts.Manifest,
+		"the manifest file to load",
+	)
+
+	assertSnapshotCmd.PersistentFlags().StringVar(
+		&assertSnapshotOpts.ThinManifestDir,
+		cli.PromoterThinManifestDirFlag,
+		assertSnapshotOpts.ThinManifestDir,
+		"recursively read in all manifests within a folder",
+	)
+
+	assertSnapshotCmd.PersistentFlags().StringVar(
+		&assertSnapshotOpts.Registry,
+		cli.AssertSnapshotRegistryFlag,
+		assertSnapshotOpts.Registry,
+		`the destination registry to snapshot (default: the only destination
+registry of the manifests)`,
+	)
+
+	assertSnapshotCmd.PersistentFlags().StringVar(
+		&assertSnapshotOpts.Expected,
+		cli.AssertSnapshotExpectedFlag,
+		assertSnapshotOpts.Expected,
+		"the expected snapshot (in the YAML output format of 'cip run --snapshot')",
+	)
+
+	rootCmd.AddCommand(assertSnapshotCmd)
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/cli"
+)
+
+// copyCmd is the command when calling `cip copy`.
+var copyCmd = &cobra.Command{
+	Use:   "copy <src-image> <dest-image>",
+	Short: "promote a single image, without a manifest",
+	Long: `copy - promote a single image, without a manifest
+
+Promote a single image from one registry to another, e.g.
+
+  cip copy gcr.io/foo/bar@sha256:... gcr.io/baz/bar:1.0
+
+as if it were the only image of a manifest. The source image is given by
+digest, by tag (in which case the digest that the tag points to is promoted),
+or both. The destination image is given with the tag to promote to; without
+one, the tag of the source image (if any) is used. Both images must have the
+same name, but may be nested differently (e.g., gcr.io/foo/bar can be copied
+to registry.example.com/mirror/bar).`,
+	Args:          cobra.ExactArgs(2),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		copyOpts.Src = args[0]
+		copyOpts.Dest = args[1]
+		copyOpts.DryRun = rootOpts.DryRun
+		return errors.Wrap(
+			cli.RunCopyCmd(copyOpts),
+			"run `cip copy`",
+		)
+	},
+}
+
+var copyOpts = &cli.CopyOptions{}
+
+func init() {
+	copyCmd.PersistentFlags().StringVar(
+		&copyOpts.SrcSvcAcct,
+		cli.CopySrcSvcAcctFlag,
+		copyOpts.SrcSvcAcct,
+		"the service account to read the source image with",
+	)
+
+	copyCmd.PersistentFlags().StringVar(
+		&copyOpts.DestSvcAcct,
+		cli.CopyDestSvcAcctFlag,
+		copyOpts.DestSvcAcct,
+		"the service account to write the destination image with",
+	)
+
+	copyCmd.PersistentFlags().IntVar(
+		&copyOpts.Threads,
+		"threads",
+		cli.PromoterDefaultThreads,
+		"number of concurrent goroutines to use when talking to GCR",
+	)
+
+	copyCmd.PersistentFlags().IntVar(
+		&copyOpts.CopyRetries,
+		cli.PromoterCopyRetriesFlag,
+		copyOpts.CopyRetries,
+		"number of times that a failed image copy is retried",
+	)
+
+	copyCmd.PersistentFlags().IntVar(
+		&copyOpts.TagRetries,
+		cli.PromoterTagRetriesFlag,
+		cli.PromoterDefaultTagRetries,
+		`number of times that a failed tag write (of a digest that is already in
+the destination) is retried`,
+	)
+
+	copyCmd.PersistentFlags().IntVar(
+		&copyOpts.MinSignatures,
+		cli.PromoterMinSignaturesFlag,
+		copyOpts.MinSignatures,
+		"the minimum number of valid (cosign) signatures the image must have",
+	)
+
+	copyCmd.PersistentFlags().StringSliceVar(
+		&copyOpts.SignaturePublicKeys,
+		cli.PromoterSignaturePublicKeyFlag,
+		copyOpts.SignaturePublicKeys,
+		fmt.Sprintf(`(only works with '--%s') PEM file with a public key that
+image signatures are verified with (may be repeated)`,
+			cli.PromoterMinSignaturesFlag,
+		),
+	)
+
+	copyCmd.PersistentFlags().StringVar(
+		&copyOpts.KeyFiles,
+		"key-files",
+		copyOpts.KeyFiles,
+		`CSV of service account key files that must be activated for the
+promotion (<json-key-file-path>,...)`,
+	)
+
+	copyCmd.PersistentFlags().BoolVar(
+		&copyOpts.UseServiceAcct,
+		"use-service-account",
+		copyOpts.UseServiceAcct,
+		"pass '--account=...' to all gcloud calls",
+	)
+
+	rootCmd.AddCommand(copyCmd)
+}
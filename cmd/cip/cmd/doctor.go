@@ -0,0 +1,59 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/cli"
+)
+
+// doctorCmd is the command when calling `cip doctor`.
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "check that the environment is set up for the promoter",
+	Long: `doctor - check that the environment is set up for the promoter
+
+Check that the tools the promoter runs (gcloud, crane, skopeo) are installed,
+that gcloud has active credentials, that a registry can be reached, and that
+the environment variables of 'cip audit' are set. Print a checklist of the
+results, with hints on how to fix the failed checks. Fail if any check that is
+not optional fails.`,
+	Args:          cobra.NoArgs,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.Wrap(
+			cli.RunDoctorCmd(doctorOpts),
+			"run `cip doctor`",
+		)
+	},
+}
+
+var doctorOpts = &cli.DoctorOptions{}
+
+func init() {
+	doctorCmd.PersistentFlags().StringVar(
+		&doctorOpts.Registry,
+		"registry",
+		cli.DoctorDefaultRegistry,
+		"registry to check the reachability of",
+	)
+
+	rootCmd.AddCommand(doctorCmd)
+}
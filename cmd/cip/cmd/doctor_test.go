@@ -0,0 +1,195 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/cli"
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/stream"
+)
+
+// mkDoctorOptions returns DoctorOptions for an environment with the given
+// tools installed (by name, with the output of their version command), the
+// given active gcloud account, and the given environment variables. The
+// registry is reachable unless unreachable is set.
+func mkDoctorOptions(
+	t *testing.T,
+	tools map[string]string,
+	account string,
+	env map[string]string,
+	unreachable bool,
+) (*cli.DoctorOptions, *bytes.Buffer) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+	t.Cleanup(server.Close)
+	if unreachable {
+		server.Close()
+	}
+
+	out := &bytes.Buffer{}
+	opts := &cli.DoctorOptions{
+		Registry: "gcr.io/foo",
+		LookPath: func(file string) (string, error) {
+			if _, ok := tools[file]; !ok {
+				return "", exec.ErrNotFound
+			}
+			return "/usr/bin/" + file, nil
+		},
+		RunTool: func(name string, args ...string) (string, error) {
+			if name == "gcloud" && len(args) > 0 && args[0] == "auth" {
+				return account + "\n", nil
+			}
+			version, ok := tools[name]
+			if !ok {
+				return "", errors.New("not installed")
+			}
+			return version, nil
+		},
+		Getenv: func(key string) string {
+			return env[key]
+		},
+		MkPingCmd: func(
+			sc *reg.SyncContext,
+			rc reg.RegistryContext,
+		) stream.Producer {
+			require.Equal(t, reg.RegistryName("gcr.io/foo"), rc.Name)
+			req, err := http.NewRequest(http.MethodGet, server.URL+"/v2/", nil)
+			require.Nil(t, err)
+			return &stream.HTTP{Req: req}
+		},
+		Out: out,
+	}
+
+	return opts, out
+}
+
+func TestDoctor(t *testing.T) {
+	allTools := map[string]string{
+		"gcloud": "Google Cloud SDK 350.0.0\nbq 2.0.70\n",
+		"crane":  "v0.5.1\n",
+		"skopeo": "skopeo version 1.4.0\n",
+	}
+	auditEnv := map[string]string{}
+	for _, key := range cli.DoctorAuditEnvVars {
+		auditEnv[key] = "x"
+	}
+
+	tests := []struct {
+		name          string
+		tools         map[string]string
+		account       string
+		env           map[string]string
+		unreachable   bool
+		expected      []string
+		expectedError string
+	}{
+		{
+			"All good",
+			allTools,
+			"robot@example.com",
+			auditEnv,
+			false,
+			[]string{
+				"[PASS] gcloud: Google Cloud SDK 350.0.0 (/usr/bin/gcloud)",
+				"[PASS] crane (optional): v0.5.1 (/usr/bin/crane)",
+				"[PASS] skopeo (optional): skopeo version 1.4.0 (/usr/bin/skopeo)",
+				"[PASS] credentials: active account robot@example.com",
+				"[PASS] registry: gcr.io/foo is reachable",
+				"[PASS] audit environment (optional): all set",
+				"0 of 6 checks failed (0 optional)",
+			},
+			"",
+		},
+		{
+			"Missing optional tools and audit environment",
+			map[string]string{"gcloud": allTools["gcloud"]},
+			"robot@example.com",
+			map[string]string{"CIP_AUDIT_MANIFEST_REPO_URL": "x"},
+			false,
+			[]string{
+				"[FAIL] crane (optional): not found in $PATH\n" +
+					"       hint: install crane (only needed for '--copy-tool=crane')",
+				"[FAIL] skopeo (optional): not found in $PATH",
+				"[FAIL] audit environment (optional): not set: " +
+					"CIP_AUDIT_GCP_PROJECT_ID, CIP_AUDIT_MANIFEST_REPO_BRANCH, " +
+					"CIP_AUDIT_MANIFEST_REPO_MANIFEST_DIR",
+				"3 of 6 checks failed (3 optional)",
+			},
+			"",
+		},
+		{
+			"Missing gcloud",
+			map[string]string{},
+			"",
+			auditEnv,
+			false,
+			[]string{
+				"[FAIL] gcloud: not found in $PATH\n" +
+					"       hint: install the Google Cloud SDK",
+				"[FAIL] credentials: cannot be checked without gcloud",
+				"4 of 6 checks failed (2 optional)",
+			},
+			"2 required check(s) failed",
+		},
+		{
+			"No credentials and unreachable registry",
+			allTools,
+			"",
+			auditEnv,
+			true,
+			[]string{
+				"[FAIL] credentials: no active account\n" +
+					"       hint: run 'gcloud auth login'",
+				"[FAIL] registry: gcr.io/foo is unreachable: ",
+				"2 of 6 checks failed (0 optional)",
+			},
+			"2 required check(s) failed",
+		},
+	}
+
+	for _, test := range tests {
+		opts, out := mkDoctorOptions(
+			t,
+			test.tools,
+			test.account,
+			test.env,
+			test.unreachable)
+
+		err := cli.RunDoctorCmd(opts)
+		if test.expectedError == "" {
+			require.Nil(t, err, test.name)
+		} else {
+			require.NotNil(t, err, test.name)
+			require.Equal(t, test.expectedError, err.Error(), test.name)
+		}
+
+		for _, expected := range test.expected {
+			require.Contains(t, out.String(), expected, test.name)
+		}
+	}
+}
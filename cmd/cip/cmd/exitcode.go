@@ -0,0 +1,72 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/cli"
+)
+
+// Exit codes of cip, by failure class.
+const (
+	// ExitCodeOK means that the command succeeded.
+	ExitCodeOK = 0
+	// ExitCodeError is used for all failures that are not classified below
+	// (e.g., invalid flags).
+	ExitCodeError = 1
+	// ExitCodeParseError means that the manifests could not be parsed.
+	ExitCodeParseError = 2
+	// ExitCodeAuthError means that credentials or access tokens could not be
+	// obtained.
+	ExitCodeAuthError = 3
+	// ExitCodeVulnCheckError means that the vulnerability check rejected the
+	// images.
+	ExitCodeVulnCheckError = 4
+	// ExitCodePromotionError means that the images could not be promoted.
+	ExitCodePromotionError = 5
+	// ExitCodeTimeout means that the run did not finish within '--timeout'.
+	ExitCodeTimeout = 6
+)
+
+// exitCode maps the error returned by a command to the exit code of cip.
+func exitCode(err error) int {
+	var (
+		parseErr     *cli.ParseError
+		authErr      *cli.AuthError
+		vulnCheckErr *cli.VulnCheckError
+		promotionErr *cli.PromotionError
+		timeoutErr   *cli.TimeoutError
+	)
+
+	switch {
+	case err == nil:
+		return ExitCodeOK
+	case errors.As(err, &parseErr):
+		return ExitCodeParseError
+	case errors.As(err, &authErr):
+		return ExitCodeAuthError
+	case errors.As(err, &vulnCheckErr):
+		return ExitCodeVulnCheckError
+	case errors.As(err, &promotionErr):
+		return ExitCodePromotionError
+	case errors.As(err, &timeoutErr):
+		return ExitCodeTimeout
+	default:
+		return ExitCodeError
+	}
+}
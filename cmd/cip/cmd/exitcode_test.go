@@ -0,0 +1,90 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"testing"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/cli"
+)
+
+func TestExitCode(t *testing.T) {
+	baseErr := errors.New("foo")
+
+	tests := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{
+			"No error",
+			nil,
+			ExitCodeOK,
+		},
+		{
+			"Unclassified error",
+			baseErr,
+			ExitCodeError,
+		},
+		{
+			"Parse error",
+			&cli.ParseError{Err: baseErr},
+			ExitCodeParseError,
+		},
+		{
+			"Auth error",
+			&cli.AuthError{Err: baseErr},
+			ExitCodeAuthError,
+		},
+		{
+			"Vulnerability check error",
+			&cli.VulnCheckError{Err: baseErr},
+			ExitCodeVulnCheckError,
+		},
+		{
+			"Promotion error",
+			&cli.PromotionError{Err: baseErr},
+			ExitCodePromotionError,
+		},
+		{
+			"Timeout",
+			&cli.TimeoutError{Err: baseErr},
+			ExitCodeTimeout,
+		},
+		{
+			"Wrapped parse error",
+			pkgerrors.Wrap(&cli.ParseError{Err: baseErr}, "run `cip run`"),
+			ExitCodeParseError,
+		},
+		{
+			"Wrapped promotion error",
+			pkgerrors.Wrap(
+				&cli.PromotionError{Err: pkgerrors.Wrap(baseErr, "promoting images")},
+				"run `cip run`",
+			),
+			ExitCodePromotionError,
+		},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.expected, exitCode(test.err), test.name)
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/cli"
+)
+
+// fromK8sCmd is the command when calling `cip from-k8s`.
+var fromK8sCmd = &cobra.Command{
+	Use:   "from-k8s",
+	Short: "promote the images referenced by rendered Kubernetes YAML",
+	Long: `from-k8s - promote the images referenced by rendered Kubernetes YAML
+
+Find the images of all containers (including init containers and sidecars) in
+rendered Kubernetes YAML, such as the output of 'kustomize build', and promote
+those that are in the source registry to the destination registry. Images
+referenced only by tag are promoted with the digest that the tag currently
+points at.`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fromK8sOpts.DryRun = rootOpts.DryRun
+		return errors.Wrap(
+			cli.RunFromK8sCmd(fromK8sOpts),
+			"run `cip from-k8s`",
+		)
+	},
+}
+
+var fromK8sOpts = &cli.FromK8sOptions{}
+
+func init() {
+	fromK8sCmd.PersistentFlags().StringVar(
+		&fromK8sOpts.Input,
+		cli.FromK8sInputFlag,
+		fromK8sOpts.Input,
+		"the rendered Kubernetes YAML to read ('-' for stdin)",
+	)
+
+	fromK8sCmd.PersistentFlags().StringVar(
+		&fromK8sOpts.Src,
+		cli.FromK8sSrcFlag,
+		fromK8sOpts.Src,
+		"the registry to promote from (e.g., gcr.io/k8s-staging-foo)",
+	)
+
+	fromK8sCmd.PersistentFlags().StringVar(
+		&fromK8sOpts.Dest,
+		cli.FromK8sDestFlag,
+		fromK8sOpts.Dest,
+		"the registry to promote to",
+	)
+
+	fromK8sCmd.PersistentFlags().StringVar(
+		&fromK8sOpts.SrcSvcAcct,
+		cli.FromK8sSrcSvcAcctFlag,
+		fromK8sOpts.SrcSvcAcct,
+		"the service account to read the source registry with",
+	)
+
+	fromK8sCmd.PersistentFlags().StringVar(
+		&fromK8sOpts.DestSvcAcct,
+		cli.FromK8sDestSvcAcctFlag,
+		fromK8sOpts.DestSvcAcct,
+		"the service account to write to the destination registry with",
+	)
+
+	fromK8sCmd.PersistentFlags().BoolVar(
+		&fromK8sOpts.PrintEdges,
+		cli.FromK8sPrintEdgesFlag,
+		fromK8sOpts.PrintEdges,
+		"only print the promotions that would be done, instead of promoting",
+	)
+
+	fromK8sCmd.PersistentFlags().IntVar(
+		&fromK8sOpts.Threads,
+		"threads",
+		cli.PromoterDefaultThreads,
+		"number of concurrent goroutines to use when talking to GCR",
+	)
+
+	fromK8sCmd.PersistentFlags().StringVar(
+		&fromK8sOpts.KeyFiles,
+		"key-files",
+		fromK8sOpts.KeyFiles,
+		`CSV of service account key files that must be activated for the
+promotion (<json-key-file-path>,...)`,
+	)
+
+	fromK8sCmd.PersistentFlags().BoolVar(
+		&fromK8sOpts.UseServiceAcct,
+		"use-service-account",
+		fromK8sOpts.UseServiceAcct,
+		"pass '--account=...' to all gcloud calls",
+	)
+
+	rootCmd.AddCommand(fromK8sCmd)
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/cli"
+)
+
+// generateManifestCmd is the command when calling `cip generate-manifest`.
+var generateManifestCmd = &cobra.Command{
+	Use:   "generate-manifest --src <registry> --dest <registry>",
+	Short: "print a manifest that makes a registry match another one",
+	Long: `generate-manifest - print a manifest that makes a registry match another one
+
+Read the source and destination registries, and print a promoter manifest that
+promotes the images (digests and tags) of the source registry that are missing
+from the destination registry. Tags that point at another digest in the
+destination registry are included as well, with a warning.`,
+	Args:          cobra.NoArgs,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.Wrap(
+			cli.RunGenerateManifestCmd(generateManifestOpts),
+			"run `cip generate-manifest`",
+		)
+	},
+}
+
+var generateManifestOpts = &cli.GenerateManifestOptions{}
+
+func init() {
+	generateManifestCmd.PersistentFlags().StringVar(
+		&generateManifestOpts.Src,
+		cli.GenerateManifestSrcFlag,
+		generateManifestOpts.Src,
+		"the registry to promote images from (e.g., gcr.io/foo-staging)",
+	)
+
+	generateManifestCmd.PersistentFlags().StringVar(
+		&generateManifestOpts.Dest,
+		cli.GenerateManifestDestFlag,
+		generateManifestOpts.Dest,
+		"the registry to promote images to (e.g., gcr.io/foo)",
+	)
+
+	generateManifestCmd.PersistentFlags().StringVar(
+		&generateManifestOpts.SrcSvcAcct,
+		"src-service-account",
+		generateManifestOpts.SrcSvcAcct,
+		"the service account to read the source registry with",
+	)
+
+	generateManifestCmd.PersistentFlags().StringVar(
+		&generateManifestOpts.DestSvcAcct,
+		"dest-service-account",
+		generateManifestOpts.DestSvcAcct,
+		"the service account of the destination registry (written to the manifest)",
+	)
+
+	generateManifestCmd.PersistentFlags().StringVar(
+		&generateManifestOpts.OutputFile,
+		"output-file",
+		generateManifestOpts.OutputFile,
+		"write the manifest to this file instead of printing it",
+	)
+
+	generateManifestCmd.PersistentFlags().IntVar(
+		&generateManifestOpts.Threads,
+		"threads",
+		cli.PromoterDefaultThreads,
+		"number of concurrent goroutines to use when talking to GCR",
+	)
+
+	generateManifestCmd.PersistentFlags().BoolVar(
+		&generateManifestOpts.UseServiceAcct,
+		"use-service-account",
+		generateManifestOpts.UseServiceAcct,
+		"pass '--account=...' to all gcloud calls",
+	)
+
+	rootCmd.AddCommand(generateManifestCmd)
+}
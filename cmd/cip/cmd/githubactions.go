@@ -0,0 +1,115 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+// Fields of a log entry that carry the location of an error, to be used as
+// the location of its GitHub Actions annotation.
+const (
+	annotationFileField = "manifest"
+	annotationLineField = "line"
+)
+
+// gitHubActionsFormatter formats errors and warnings as GitHub Actions
+// workflow commands ("::error::" and "::warning::"), so that they show up as
+// annotations in the Actions UI. All other entries are formatted by the
+// fallback formatter.
+type gitHubActionsFormatter struct {
+	fallback logrus.Formatter
+}
+
+// Format implements logrus.Formatter.
+func (f *gitHubActionsFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	var command string
+	switch entry.Level {
+	case logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel:
+		command = "error"
+	case logrus.WarnLevel:
+		command = "warning"
+	default:
+		return f.fallback.Format(entry)
+	}
+
+	var properties []string
+	if file, ok := entry.Data[annotationFileField]; ok {
+		properties = append(
+			properties,
+			"file="+escapeAnnotationProperty(fmt.Sprint(file)))
+	}
+	if line, ok := entry.Data[annotationLineField]; ok {
+		properties = append(
+			properties,
+			"line="+escapeAnnotationProperty(fmt.Sprint(line)))
+	}
+
+	var b bytes.Buffer
+	b.WriteString("::" + command)
+	if len(properties) > 0 {
+		b.WriteString(" " + strings.Join(properties, ","))
+	}
+	b.WriteString("::" + escapeAnnotationData(entry.Message) + "\n")
+
+	return b.Bytes(), nil
+}
+
+// annotationFields returns the log fields holding the location of the given
+// error, if it (or any error it wraps) is a manifest error.
+func annotationFields(err error) logrus.Fields {
+	fields := logrus.Fields{}
+
+	var mErr *reg.ManifestError
+	if !errors.As(err, &mErr) {
+		return fields
+	}
+
+	fields[annotationFileField] = mErr.Filepath
+	if mErr.Line > 0 {
+		fields[annotationLineField] = mErr.Line
+	}
+
+	return fields
+}
+
+// escapeAnnotationData escapes the message of a workflow command.
+func escapeAnnotationData(s string) string {
+	return strings.NewReplacer(
+		"%", "%25",
+		"\r", "%0D",
+		"\n", "%0A",
+	).Replace(s)
+}
+
+// escapeAnnotationProperty escapes a property value of a workflow command.
+func escapeAnnotationProperty(s string) string {
+	return strings.NewReplacer(
+		"%", "%25",
+		"\r", "%0D",
+		"\n", "%0A",
+		":", "%3A",
+		",", "%2C",
+	).Replace(s)
+}
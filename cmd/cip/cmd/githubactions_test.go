@@ -0,0 +1,100 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/cli"
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+func TestGitHubActionsAnnotations(t *testing.T) {
+	manifest := filepath.Join(t.TempDir(), "promoter-manifest.yaml")
+	require.Nil(t, ioutil.WriteFile(manifest, []byte(`registries:
+- name: gcr.io/foo
+  src: true
+  bogus: 1
+`), 0o644))
+
+	_, parseErr := reg.ParseManifestFromFile(manifest)
+	require.NotNil(t, parseErr)
+
+	tests := []struct {
+		name     string
+		level    logrus.Level
+		err      error
+		expected string
+	}{
+		{
+			"Parse error with a known location",
+			logrus.ErrorLevel,
+			pkgerrors.Wrap(
+				&cli.ParseError{Err: pkgerrors.Wrap(parseErr, "parsing manifest")},
+				"run `cip run`"),
+			"::error file=" + manifest + ",line=4::run `cip run`: parsing manifest: " +
+				"yaml: unmarshal errors:%0A  line 4: field bogus not found in type inventory.RegistryContext\n",
+		},
+		{
+			"Manifest error without a line",
+			logrus.ErrorLevel,
+			&reg.ManifestError{
+				Filepath: "a,b:c.yaml",
+				Err:      errors.New("no source registry"),
+			},
+			"::error file=a%2Cb%3Ac.yaml::no source registry\n",
+		},
+		{
+			"Error without a location",
+			logrus.ErrorLevel,
+			errors.New("100% broken"),
+			"::error::100%25 broken\n",
+		},
+		{
+			"Warning",
+			logrus.WarnLevel,
+			errors.New("foo"),
+			"::warning::foo\n",
+		},
+	}
+
+	formatter := &gitHubActionsFormatter{fallback: &logrus.TextFormatter{}}
+	for _, test := range tests {
+		entry := logrus.WithFields(annotationFields(test.err))
+		entry.Level = test.level
+		entry.Message = test.err.Error()
+
+		got, err := formatter.Format(entry)
+		require.Nil(t, err, test.name)
+		require.Equal(t, test.expected, string(got), test.name)
+	}
+
+	// Entries below the warning level are left to the fallback formatter.
+	entry := logrus.NewEntry(logrus.StandardLogger())
+	entry.Level = logrus.InfoLevel
+	entry.Message = "foo"
+	got, err := formatter.Format(entry)
+	require.Nil(t, err)
+	require.NotContains(t, string(got), "::")
+}
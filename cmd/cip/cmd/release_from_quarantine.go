@@ -0,0 +1,117 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/cli"
+)
+
+// releaseFromQuarantineCmd is the command when calling
+// `cip release-from-quarantine`.
+var releaseFromQuarantineCmd = &cobra.Command{
+	Use:   "release-from-quarantine",
+	Short: "promote scanned images from the quarantine registry to production",
+	Long: `release-from-quarantine - promote scanned images from the quarantine registry to production
+
+For manifests that mark one of their registries with 'quarantine: true', 'cip
+run' only promotes images into that registry. Once the images there have been
+scanned, this command promotes those that were found clean to the other
+destination registries of the manifests. An image counts as clean if its image
+config has the '--clean-label' label, or its manifest has the '--clean-label'
+annotation, with the value "true"; all other images are held back.`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		releaseFromQuarantineOpts.DryRun = rootOpts.DryRun
+		return errors.Wrap(
+			cli.RunReleaseFromQuarantineCmd(releaseFromQuarantineOpts),
+			"run `cip release-from-quarantine`",
+		)
+	},
+}
+
+var releaseFromQuarantineOpts = &cli.ReleaseFromQuarantineOptions{}
+
+func init() {
+	releaseFromQuarantineCmd.PersistentFlags().StringVar(
+		&releaseFromQuarantineOpts.Manifest,
+		cli.PromoterManifestFlag,
+		releaseFromQuarantineOpts.Manifest,
+		"the manifest file to load (YAML, or JSON if its name ends in '.json')",
+	)
+
+	releaseFromQuarantineCmd.PersistentFlags().StringVar(
+		&releaseFromQuarantineOpts.ThinManifestDir,
+		cli.PromoterThinManifestDirFlag,
+		releaseFromQuarantineOpts.ThinManifestDir,
+		"recursively read in all thin manifests within a folder (see 'cip run --help')",
+	)
+
+	releaseFromQuarantineCmd.PersistentFlags().StringVar(
+		&releaseFromQuarantineOpts.CleanLabel,
+		cli.ReleaseFromQuarantineCleanLabelFlag,
+		cli.ReleaseFromQuarantineDefaultCleanLabel,
+		`the label (or annotation) that marks an image as scanned and clean,
+if its value is "true"`,
+	)
+
+	releaseFromQuarantineCmd.PersistentFlags().BoolVar(
+		&releaseFromQuarantineOpts.PrintEdges,
+		cli.PromoterPrintEdgesFlag,
+		releaseFromQuarantineOpts.PrintEdges,
+		fmt.Sprintf(
+			"only print the images that would be released as JSON (like 'cip run --%s'), and exit",
+			cli.PromoterPrintEdgesFlag,
+		),
+	)
+
+	releaseFromQuarantineCmd.PersistentFlags().IntVar(
+		&releaseFromQuarantineOpts.Threads,
+		"threads",
+		cli.PromoterDefaultThreads,
+		"number of concurrent goroutines to use when talking to GCR",
+	)
+
+	releaseFromQuarantineCmd.PersistentFlags().StringVar(
+		&releaseFromQuarantineOpts.KeyFiles,
+		"key-files",
+		releaseFromQuarantineOpts.KeyFiles,
+		`CSV of service account key files that must be activated for the
+promotion (<json-key-file-path>,...)`,
+	)
+
+	releaseFromQuarantineCmd.PersistentFlags().StringVar(
+		&releaseFromQuarantineOpts.ApprovalEndpoint,
+		cli.PromoterApprovalEndpointFlag,
+		releaseFromQuarantineOpts.ApprovalEndpoint,
+		"URL of the approval API for registries marked with 'requiresApproval: true' (see 'cip run --help')",
+	)
+
+	releaseFromQuarantineCmd.PersistentFlags().BoolVar(
+		&releaseFromQuarantineOpts.UseServiceAcct,
+		"use-service-account",
+		releaseFromQuarantineOpts.UseServiceAcct,
+		"pass '--account=...' to all gcloud calls",
+	)
+
+	rootCmd.AddCommand(releaseFromQuarantineCmd)
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/cli"
+)
+
+// restoreCmd is the command when calling `cip restore`.
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "promote the images of a snapshot, to restore a registry",
+	Long: `restore - promote the images of a snapshot, to restore a registry
+
+Promote every digest and tag listed in a snapshot (as written by
+'cip run --snapshot=...' in the default YAML format or with '--output=csv')
+from the source registry, e.g. a backup of the lost registry, to the
+destination registry, under the same image names.`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		restoreOpts.DryRun = rootOpts.DryRun
+		return errors.Wrap(
+			cli.RunRestoreCmd(restoreOpts),
+			"run `cip restore`",
+		)
+	},
+}
+
+var restoreOpts = &cli.RestoreOptions{}
+
+func init() {
+	restoreCmd.PersistentFlags().StringVar(
+		&restoreOpts.From,
+		cli.RestoreFromFlag,
+		restoreOpts.From,
+		"the snapshot to restore (.csv, .yaml or .yml)",
+	)
+
+	restoreCmd.PersistentFlags().StringVar(
+		&restoreOpts.Src,
+		cli.RestoreSrcFlag,
+		restoreOpts.Src,
+		"the registry that has the images of the snapshot (e.g., a backup)",
+	)
+
+	restoreCmd.PersistentFlags().StringVar(
+		&restoreOpts.Dest,
+		cli.RestoreDestFlag,
+		restoreOpts.Dest,
+		"the registry to restore",
+	)
+
+	restoreCmd.PersistentFlags().StringVar(
+		&restoreOpts.SrcSvcAcct,
+		cli.RestoreSrcSvcAcctFlag,
+		restoreOpts.SrcSvcAcct,
+		"the service account to read the source registry with",
+	)
+
+	restoreCmd.PersistentFlags().StringVar(
+		&restoreOpts.DestSvcAcct,
+		cli.RestoreDestSvcAcctFlag,
+		restoreOpts.DestSvcAcct,
+		"the service account to write to the destination registry with",
+	)
+
+	restoreCmd.PersistentFlags().BoolVar(
+		&restoreOpts.PrintEdges,
+		cli.RestorePrintEdgesFlag,
+		restoreOpts.PrintEdges,
+		"only print the promotions that would be done, instead of promoting",
+	)
+
+	restoreCmd.PersistentFlags().IntVar(
+		&restoreOpts.Threads,
+		"threads",
+		cli.PromoterDefaultThreads,
+		"number of concurrent goroutines to use when talking to GCR",
+	)
+
+	restoreCmd.PersistentFlags().StringVar(
+		&restoreOpts.KeyFiles,
+		"key-files",
+		restoreOpts.KeyFiles,
+		`CSV of service account key files that must be activated for the
+promotion (<json-key-file-path>,...)`,
+	)
+
+	restoreCmd.PersistentFlags().BoolVar(
+		&restoreOpts.UseServiceAcct,
+		"use-service-account",
+		restoreOpts.UseServiceAcct,
+		"pass '--account=...' to all gcloud calls",
+	)
+
+	rootCmd.AddCommand(restoreCmd)
+}
@@ -18,6 +18,7 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -39,9 +40,11 @@ var rootOpts = &cli.RootOptions{}
 
 // Execute adds all child commands to the root command and sets flags.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
+// On failure, the process exits with one of the ExitCode* constants.
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
-		logrus.Fatal(err)
+		logrus.WithFields(annotationFields(err)).Error(err)
+		os.Exit(exitCode(err))
 	}
 }
 
@@ -59,8 +62,41 @@ func init() {
 		rootOpts.DryRun,
 		"test run promotion without modifying any registry",
 	)
+
+	rootCmd.PersistentFlags().BoolVar(
+		&rootOpts.Quiet,
+		"quiet",
+		rootOpts.Quiet,
+		`only log warnings, errors and the final summary (e.g., with
+'--json-log-summary'), leaving out the START/FINISHED banners and other
+informational messages`,
+	)
+
+	rootCmd.PersistentFlags().BoolVar(
+		&rootOpts.GitHubActions,
+		"github-actions",
+		os.Getenv("GITHUB_ACTIONS") == "true",
+		`format errors and warnings as GitHub Actions workflow commands, so that
+they show up as annotations (default: true when running in GitHub Actions)`,
+	)
 }
 
 func initLogging(*cobra.Command, []string) error {
-	return log.SetupGlobalLogger(rootOpts.LogLevel)
+	if err := log.SetupGlobalLogger(rootOpts.LogLevel); err != nil {
+		return err
+	}
+
+	// Quiet runs log no more than warnings, but a stricter '--log-level' is
+	// kept.
+	if rootOpts.Quiet && logrus.IsLevelEnabled(logrus.InfoLevel) {
+		logrus.SetLevel(logrus.WarnLevel)
+	}
+
+	if rootOpts.GitHubActions {
+		logrus.SetFormatter(&gitHubActionsFormatter{
+			fallback: logrus.StandardLogger().Formatter,
+		})
+	}
+
+	return nil
 }
@@ -0,0 +1,108 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+func TestQuiet(t *testing.T) {
+	savedOpts := *rootOpts
+	defer func() {
+		*rootOpts = savedOpts
+		logrus.SetOutput(os.Stderr)
+		logrus.SetLevel(logrus.InfoLevel)
+	}()
+
+	tests := []struct {
+		name            string
+		logLevel        string
+		quiet           bool
+		expectedBanner  bool
+		expectedError   bool
+		expectedSummary bool
+	}{
+		{
+			"Not quiet",
+			"info",
+			false,
+			true,
+			true,
+			true,
+		},
+		{
+			"Quiet",
+			"info",
+			true,
+			false,
+			true,
+			true,
+		},
+		{
+			"Quiet keeps a stricter log level",
+			"fatal",
+			true,
+			false,
+			false,
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		rootOpts.LogLevel = test.logLevel
+		rootOpts.Quiet = test.quiet
+		require.Nil(t, initLogging(nil, nil), test.name)
+
+		var out bytes.Buffer
+		logrus.SetOutput(&out)
+
+		logrus.Info("********** START **********")
+		logrus.Error("some promotion failed")
+		sc := reg.SyncContext{
+			Logs: reg.CollectedLogs{
+				Promotions: reg.RequestSummary{Succeeded: 3, Failed: 1},
+			},
+		}
+		sc.LogJSONSummary()
+		logrus.Info("********** FINISHED **********")
+
+		got := out.String()
+		require.Equal(
+			t,
+			test.expectedBanner,
+			strings.Contains(got, "**********"),
+			test.name)
+		require.Equal(
+			t,
+			test.expectedError,
+			strings.Contains(got, "some promotion failed"),
+			test.name)
+		require.Equal(
+			t,
+			test.expectedSummary,
+			strings.Contains(got, "Succeeded"),
+			test.name)
+	}
+}
@@ -18,9 +18,11 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 
 	"sigs.k8s.io/k8s-container-image-promoter/legacy/cli"
 )
@@ -37,6 +39,13 @@ Promote images from a staging registry to production
 	SilenceUsage:  true,
 	SilenceErrors: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		runOpts.Quiet = rootOpts.Quiet
+		if runOpts.PrintConfig != "" {
+			return errors.Wrap(
+				printConfig(cmd, cmd.OutOrStdout()),
+				"run `cip run`",
+			)
+		}
 		return errors.Wrap(
 			cli.RunPromoteCmd(runOpts),
 			"run `cip run`",
@@ -46,75 +55,246 @@ Promote images from a staging registry to production
 
 var runOpts = &cli.RunOptions{}
 
-// TODO: Function 'init' is too long (171 > 60) (funlen)
-// nolint: funlen
 func init() {
+	addRunFlags(runCmd.PersistentFlags(), runOpts)
+
+	rootCmd.AddCommand(runCmd)
+}
+
+// addRunFlags defines the flags of 'cip run' for opts. 'cip watch' promotes
+// with the same options, so it defines them, too.
+// TODO: Function 'addRunFlags' is too long (171 > 60) (funlen)
+// nolint: funlen
+func addRunFlags(flags *pflag.FlagSet, opts *cli.RunOptions) {
 	// TODO: Move this into a default options function in pkg/promobot
-	runCmd.PersistentFlags().StringVar(
-		&runOpts.Manifest,
+	flags.StringVar(
+		&opts.Manifest,
 		cli.PromoterManifestFlag,
-		runOpts.Manifest,
-		"the manifest file to load",
+		opts.Manifest,
+		"the manifest file to load (YAML, or JSON if its name ends in '.json')",
 	)
 
-	runCmd.PersistentFlags().StringVar(
-		&runOpts.ThinManifestDir,
+	flags.StringVar(
+		&opts.ThinManifestDir,
 		cli.PromoterThinManifestDirFlag,
-		runOpts.ThinManifestDir,
-		`recursively read in all manifests within a folder, but all manifests
-MUST be 'thin' manifests named 'promoter-manifest.yaml', which are like regular
-manifests but instead of defining the 'images: ...' field directly, the
-'imagesPath' field must be defined that points to another YAML file containing
-the 'images: ...' contents`,
+		opts.ThinManifestDir,
+		fmt.Sprintf(`recursively read in all manifests within a folder, but all
+manifests MUST be 'thin' manifests named 'promoter-manifest.yaml' (see '--%s'),
+which are like regular manifests but instead of defining the 'images: ...'
+field directly, the 'imagesPath' field must be defined that points to another
+YAML file containing the 'images: ...' contents`,
+			cli.PromoterThinManifestFilenameFlag,
+		),
+	)
+
+	flags.StringVar(
+		&opts.ThinManifestFilename,
+		cli.PromoterThinManifestFilenameFlag,
+		cli.PromoterDefaultThinManifestFilename,
+		fmt.Sprintf(`(only works with '--%s' or '--%s') name of the thin
+manifest files within the subfolders of 'manifests'; other files are ignored`,
+			cli.PromoterThinManifestDirFlag,
+			cli.PromoterThinManifestArchiveFlag,
+		),
+	)
+
+	flags.StringVar(
+		&opts.ChangedManifests,
+		cli.PromoterChangedManifestsFlag,
+		opts.ChangedManifests,
+		fmt.Sprintf(`(only works with '--%s') path to a file listing changed files
+(one per line, e.g., the files changed by a pull request); only the thin
+manifests affected by these files are promoted`,
+			cli.PromoterThinManifestDirFlag,
+		),
+	)
+
+	flags.BoolVar(
+		&opts.SkipInvalidManifests,
+		cli.PromoterSkipInvalidManifestsFlag,
+		opts.SkipInvalidManifests,
+		fmt.Sprintf(`(only works with '--%s' or '--%s') skip the thin manifests
+that cannot be parsed (with a warning) and promote the valid ones; fail only if
+no valid manifest remains`,
+			cli.PromoterThinManifestDirFlag,
+			cli.PromoterThinManifestArchiveFlag,
+		),
+	)
+
+	flags.BoolVar(
+		&opts.StrictDigests,
+		cli.PromoterStrictDigestsFlag,
+		opts.StrictDigests,
+		fmt.Sprintf(`(only works with '--%s' or '--%s') reject the thin
+manifests whose images files have entries without a digest, as if they all set
+'strictDigests'`,
+			cli.PromoterThinManifestDirFlag,
+			cli.PromoterThinManifestArchiveFlag,
+		),
+	)
+
+	flags.StringVar(
+		&opts.SinceRef,
+		cli.PromoterSinceRefFlag,
+		opts.SinceRef,
+		fmt.Sprintf(`(only works with '--%s', which must be within a git
+checkout) git ref to diff the thin manifests against; only the thin manifests
+affected by the files changed since this ref are promoted`,
+			cli.PromoterThinManifestDirFlag,
+		),
 	)
 
-	runCmd.PersistentFlags().IntVar(
-		&runOpts.Threads,
+	flags.StringVar(
+		&opts.ThinManifestArchive,
+		cli.PromoterThinManifestArchiveFlag,
+		opts.ThinManifestArchive,
+		fmt.Sprintf(`like '--%s', but read the thin manifests from a .tar.gz
+archive of such a folder instead (the archive is extracted to a temporary
+folder that is removed after parsing)`,
+			cli.PromoterThinManifestDirFlag,
+		),
+	)
+
+	flags.StringVar(
+		&opts.ManifestSignature,
+		cli.PromoterManifestSignatureFlag,
+		opts.ManifestSignature,
+		fmt.Sprintf(`(only works with '--%s') detached GPG signature of the
+manifest; the promoter refuses to read the manifest unless the signature is
+valid for one of the keys in '--%s'`,
+			cli.PromoterManifestFlag,
+			cli.PromoterManifestGPGKeyringFlag,
+		),
+	)
+
+	flags.StringVar(
+		&opts.ManifestGPGKeyring,
+		cli.PromoterManifestGPGKeyringFlag,
+		opts.ManifestGPGKeyring,
+		fmt.Sprintf(`keyring with the public GPG keys (as exported by 'gpg
+--export') that '--%s' is verified against with 'gpgv'`,
+			cli.PromoterManifestSignatureFlag,
+		),
+	)
+
+	flags.BoolVar(
+		&opts.ExpandEnv,
+		cli.PromoterExpandEnvFlag,
+		opts.ExpandEnv,
+		fmt.Sprintf(`substitute environment variables in thin manifests (with
+'--%s' or '--%s') before parsing them; '${VAR}' must be set, while
+'${VAR:-default}' falls back to 'default' if VAR is unset or empty`,
+			cli.PromoterThinManifestDirFlag,
+			cli.PromoterThinManifestArchiveFlag,
+		),
+	)
+
+	flags.IntVar(
+		&opts.Threads,
 		"threads",
 		cli.PromoterDefaultThreads,
 		"number of concurrent goroutines to use when talking to GCR",
 	)
 
-	runCmd.PersistentFlags().BoolVar(
-		&runOpts.JSONLogSummary,
+	flags.BoolVar(
+		&opts.JSONLogSummary,
 		"json-log-summary",
-		runOpts.JSONLogSummary,
+		opts.JSONLogSummary,
 		"only log a JSON summary of important errors",
 	)
 
-	runCmd.PersistentFlags().BoolVar(
-		&runOpts.ParseOnly,
-		"parse-only",
-		runOpts.ParseOnly,
+	flags.BoolVar(
+		&opts.ParseOnly,
+		cli.PromoterParseOnlyFlag,
+		opts.ParseOnly,
 		"only check that the given manifest file is parsable as a Manifest",
 	)
 
-	runCmd.PersistentFlags().StringVar(
-		&runOpts.KeyFiles,
+	flags.BoolVar(
+		&opts.PrintEdges,
+		cli.PromoterPrintEdgesFlag,
+		opts.PrintEdges,
+		`only print the edges that would be promoted (after comparing the
+manifests with the registries) as JSON to stdout, with the source and
+destination registry, image, digest, tag and operation of each, and exit`,
+	)
+
+	flags.StringVar(
+		&opts.KeyFiles,
 		"key-files",
-		runOpts.KeyFiles,
+		opts.KeyFiles,
 		`CSV of service account key files that must be activated for the
 promotion (<json-key-file-path>,...)`,
 	)
 
-	runCmd.PersistentFlags().StringVar(
-		&runOpts.Snapshot,
+	flags.StringVar(
+		&opts.Snapshot,
 		cli.PromoterSnapshotFlag,
-		runOpts.Snapshot,
+		opts.Snapshot,
 		"read all images in a repository and print to stdout",
 	)
 
-	runCmd.PersistentFlags().StringVar(
-		&runOpts.SnapshotTag,
+	flags.StringVar(
+		&opts.SnapshotRegistry,
+		cli.PromoterSnapshotRegistryFlag,
+		opts.SnapshotRegistry,
+		fmt.Sprintf(`like '--%s', but for the registry of this name in the
+manifest(s), read with the service account that they give it`,
+			cli.PromoterSnapshotFlag,
+		),
+	)
+
+	flags.StringVar(
+		&opts.SnapshotTag,
 		"snapshot-tag",
-		runOpts.SnapshotTag,
+		opts.SnapshotTag,
 		"only snapshot images with the given tag",
 	)
 
-	runCmd.PersistentFlags().BoolVar(
-		&runOpts.MinimalSnapshot,
+	flags.StringVar(
+		&opts.SnapshotDigests,
+		cli.PromoterSnapshotDigestsFlag,
+		opts.SnapshotDigests,
+		`only snapshot images with one of the given digests, either as a
+comma-separated list or as '@<file>' to read the list from a file (one digest
+per line)`,
+	)
+
+	flags.StringVar(
+		&opts.SnapshotOnly,
+		cli.PromoterSnapshotOnlyFlag,
+		opts.SnapshotOnly,
+		fmt.Sprintf(`only snapshot manifest lists ('%s'), or only the images
+that are not manifest lists ('%s')`,
+			cli.PromoterSnapshotOnlyManifestLists,
+			cli.PromoterSnapshotOnlyImages,
+		),
+	)
+
+	flags.StringVar(
+		&opts.SnapshotExcludeDigests,
+		cli.PromoterSnapshotExcludeDigestsFlag,
+		opts.SnapshotExcludeDigests,
+		`file with digests to drop (along with their tags) from the snapshot, one
+digest per line`,
+	)
+
+	flags.IntVar(
+		&opts.KeepLatestN,
+		cli.PromoterKeepLatestNFlag,
+		opts.KeepLatestN,
+		fmt.Sprintf(`(only works with '--%s' or '--%s') only snapshot the N
+newest tags of each image, ranking semantic versions by version and other tags
+by upload time; 0 keeps all tags`,
+			cli.PromoterSnapshotFlag,
+			cli.PromoterManifestBasedSnapshotOfFlag,
+		),
+	)
+
+	flags.BoolVar(
+		&opts.MinimalSnapshot,
 		"minimal-snapshot",
-		runOpts.MinimalSnapshot,
+		opts.MinimalSnapshot,
 		fmt.Sprintf(`(only works with '--%s' or '--%s') discard tagless images
 from snapshot output if they are referenced by a manifest list`,
 			cli.PromoterSnapshotFlag,
@@ -122,32 +302,173 @@ from snapshot output if they are referenced by a manifest list`,
 		),
 	)
 
-	runCmd.PersistentFlags().StringVar(
-		&runOpts.OutputFormat,
+	flags.StringVar(
+		&opts.SnapshotFile,
+		cli.PromoterSnapshotFileFlag,
+		opts.SnapshotFile,
+		fmt.Sprintf(`(only works with '--%s' or '--%s') write the snapshot to
+this file instead of printing it`,
+			cli.PromoterSnapshotFlag,
+			cli.PromoterManifestBasedSnapshotOfFlag,
+		),
+	)
+
+	flags.BoolVar(
+		&opts.SignSnapshot,
+		cli.PromoterSignSnapshotFlag,
+		opts.SignSnapshot,
+		fmt.Sprintf(`sign the snapshot written to '--%s' with 'cosign sign-blob',
+and write the signature next to it (with a '.sig' suffix)`,
+			cli.PromoterSnapshotFileFlag,
+		),
+	)
+
+	flags.StringVar(
+		&opts.CosignKey,
+		cli.PromoterCosignKeyFlag,
+		opts.CosignKey,
+		fmt.Sprintf(`(only works with '--%s') the cosign key (a path or a KMS
+URI) to sign the snapshot with; its password is read from $COSIGN_PASSWORD`,
+			cli.PromoterSignSnapshotFlag,
+		),
+	)
+
+	flags.BoolVar(
+		&opts.StreamSnapshot,
+		cli.PromoterStreamSnapshotFlag,
+		opts.StreamSnapshot,
+		fmt.Sprintf(`(only works with '--%s' and '--%s=csv') write the snapshot
+one image at a time, instead of holding all promotion edges in memory; lines
+are only sorted per image, and images listed in several manifests are repeated`,
+			cli.PromoterManifestBasedSnapshotOfFlag,
+			cli.PromoterOutputFlag,
+		),
+	)
+
+	flags.BoolVar(
+		&opts.Canonical,
+		cli.PromoterCanonicalFlag,
+		opts.Canonical,
+		fmt.Sprintf(`(only works with '--%s' or '--%s') write the snapshot in
+a canonical form meant for diffing (lowercase digests, no repeated tags or
+empty images, one tag per line), as YAML or with '--%s=%s' as JSON`,
+			cli.PromoterSnapshotFlag,
+			cli.PromoterManifestBasedSnapshotOfFlag,
+			cli.PromoterOutputFlag,
+			cli.PromoterOutputFormatJSON,
+		),
+	)
+
+	flags.BoolVar(
+		&opts.ValidateSnapshot,
+		cli.PromoterValidateSnapshotFlag,
+		opts.ValidateSnapshot,
+		fmt.Sprintf(`(only works with '--%s' or '--%s') check the snapshot for
+internal inconsistencies (empty digests, tags pointing at multiple digests)
+before printing it, and fail if any are found`,
+			cli.PromoterSnapshotFlag,
+			cli.PromoterManifestBasedSnapshotOfFlag,
+		),
+	)
+
+	flags.StringVar(
+		&opts.OutputFormat,
 		cli.PromoterOutputFlag,
 		cli.PromoterDefaultOutputFormat,
-		fmt.Sprintf(`(only works with '--%s' or '--%s') choose output
-format of the snapshot (allowed values: %q)`,
+		fmt.Sprintf(`(only works with '--%s', '--%s' or '--%s') choose output
+format of the snapshot or projected inventory (allowed values: %q)`,
 			cli.PromoterSnapshotFlag,
 			cli.PromoterManifestBasedSnapshotOfFlag,
+			cli.PromoterProjectedInventoryFlag,
 			cli.PromoterAllowedOutputFormats,
 		),
 	)
 
-	runCmd.PersistentFlags().StringVar(
-		&runOpts.SnapshotSvcAcct,
+	flags.StringVar(
+		&opts.OutputTemplate,
+		cli.PromoterOutputTemplateFlag,
+		opts.OutputTemplate,
+		fmt.Sprintf(`(only works with '--%s=%s') file with the Go text/template
+that renders the snapshot or projected inventory`,
+			cli.PromoterOutputFlag,
+			cli.PromoterOutputFormatTemplate,
+		),
+	)
+
+	flags.StringVar(
+		&opts.SnapshotSvcAcct,
 		"snapshot-service-account",
-		runOpts.SnapshotSvcAcct,
+		opts.SnapshotSvcAcct,
 		fmt.Sprintf(
 			"service account to use for '--%s'",
 			cli.PromoterSnapshotFlag,
 		),
 	)
 
-	runCmd.PersistentFlags().StringVar(
-		&runOpts.ManifestBasedSnapshotOf,
+	flags.StringVar(
+		&opts.ReadCheckpoint,
+		cli.PromoterReadCheckpointFlag,
+		opts.ReadCheckpoint,
+		fmt.Sprintf(`(only works with '--%s') record the repositories read so
+far in the given file, so that an interrupted snapshot can be resumed by
+re-running with the same file; the file is removed once the snapshot completes`,
+			cli.PromoterSnapshotFlag,
+		),
+	)
+
+	flags.StringVar(
+		&opts.DryRunCommandsOut,
+		cli.PromoterDryRunCommandsOutFlag,
+		opts.DryRunCommandsOut,
+		`(only works with '--dry-run') write the commands that the promotion
+would run to the given file, as a shell script; images copied in-process are
+written as the equivalent 'crane' commands`,
+	)
+
+	flags.StringVar(
+		&opts.Changelog,
+		cli.PromoterChangelogFlag,
+		opts.Changelog,
+		`write the tags that the promotion added, moved or removed in the
+destination registries to the given file, as JSON if its name ends in '.json'
+and as Markdown otherwise; in a dry run, the tags that it would change`,
+	)
+
+	flags.StringVar(
+		&opts.ExpectedEdges,
+		cli.PromoterExpectedEdgesFlag,
+		opts.ExpectedEdges,
+		fmt.Sprintf(`(only works with '--dry-run') JSON file with the promotion
+edges that the run is expected to compute, as printed by '--%s'; the run fails
+if any edge is not expected, or if any expected edge is absent`,
+			cli.PromoterPrintEdgesFlag,
+		),
+	)
+
+	flags.StringVar(
+		&opts.RegistryCredentials,
+		cli.PromoterRegistryCredentialsFlag,
+		opts.RegistryCredentials,
+		`YAML file that names the service account of each registry (kept out of
+the manifests); its service accounts are given to the registries of the
+manifests with the same name`,
+	)
+
+	flags.StringVar(
+		&opts.InventoryFrom,
+		cli.PromoterInventoryFromFlag,
+		opts.InventoryFrom,
+		fmt.Sprintf(`(only works with '--dry-run' or '--%s') YAML or JSON file
+with a pre-captured inventory of the registries, mapping registry names to
+their images, which is used instead of reading the registries`,
+			cli.PromoterSnapshotFlag,
+		),
+	)
+
+	flags.StringVar(
+		&opts.ManifestBasedSnapshotOf,
 		cli.PromoterManifestBasedSnapshotOfFlag,
-		runOpts.ManifestBasedSnapshotOf,
+		opts.ManifestBasedSnapshotOf,
 		fmt.Sprintf(`read all images in either '--%s' or '--%s' and print all
 images that should be promoted to the given registry (assuming the given,
 registry is empty); this is like '--%s', but instead of reading over the
@@ -158,27 +479,280 @@ network from a registry, it reads from the local manifests only`,
 		),
 	)
 
-	runCmd.PersistentFlags().BoolVar(
-		&runOpts.UseServiceAcct,
+	flags.BoolVar(
+		&opts.UseServiceAcct,
 		"use-service-account",
-		runOpts.UseServiceAcct,
+		opts.UseServiceAcct,
 		"pass '--account=...' to all gcloud calls",
 	)
 
-	runCmd.PersistentFlags().IntVar(
-		&runOpts.MaxImageSize,
+	flags.StringSliceVar(
+		&opts.EnableChecks,
+		cli.PromoterEnableChecksFlag,
+		opts.EnableChecks,
+		fmt.Sprintf(`comma-separated list of prechecks to run against the images
+to be promoted (available checks: %q); the %q check is also enabled by
+'--vuln-severity-threshold'`,
+			cli.PromoterAvailableChecks(),
+			cli.PromoterVulnCheck,
+		),
+	)
+
+	flags.StringSliceVar(
+		&opts.PromoteGroups,
+		cli.PromoterPromoteGroupsFlag,
+		opts.PromoteGroups,
+		fmt.Sprintf(`comma-separated list of image groups to promote; images
+without a 'group' in the manifest belong to the %q group (default: promote all
+images)`,
+			cli.PromoterDefaultImageGroup,
+		),
+	)
+
+	flags.StringVar(
+		&opts.DestNamespace,
+		cli.PromoterDestNamespaceFlag,
+		opts.DestNamespace,
+		`path to place all promoted images under in the destination registries,
+e.g. 'mirror' promotes the image 'foo/bar' as 'mirror/foo/bar'; the source
+images are not affected`,
+	)
+
+	flags.StringVar(
+		&opts.DestPathPolicy,
+		cli.PromoterDestPathPolicyFlag,
+		opts.DestPathPolicy,
+		`regular expression that the name of every destination image
+('<registry>/<image>') must match in full; edges to any other image fail
+the run`,
+	)
+
+	flags.BoolVar(
+		&opts.FailOutsideWindow,
+		cli.PromoterFailOutsideWindowFlag,
+		opts.FailOutsideWindow,
+		`fail the run if an image is to be promoted outside of its promotion
+window ('window' in the manifest); by default, such images are skipped`,
+	)
+
+	flags.StringVar(
+		&opts.CopyTool,
+		cli.PromoterCopyToolFlag,
+		opts.CopyTool,
+		fmt.Sprintf(`copy images by running the given CLI tool instead of
+copying them in-process (allowed values: %q); in a dry run, the commands that
+would be run are printed`,
+			cli.PromoterAllowedCopyTools(),
+		),
+	)
+
+	flags.Int64Var(
+		&opts.BandwidthLimit,
+		cli.PromoterBandwidthLimitFlag,
+		opts.BandwidthLimit,
+		fmt.Sprintf(`cap the combined bandwidth (in bytes per second) of all image
+copies, to avoid starving other users of a shared link; 0 means no limit (does
+not work with '--%s')`,
+			cli.PromoterCopyToolFlag,
+		),
+	)
+
+	flags.IntVar(
+		&opts.BatchSize,
+		cli.PromoterBatchSizeFlag,
+		opts.BatchSize,
+		`promote in batches of this many images, starting each batch only once
+the previous one is done; 0 promotes everything at once`,
+	)
+
+	flags.DurationVar(
+		&opts.BatchPause,
+		cli.PromoterBatchPauseFlag,
+		opts.BatchPause,
+		fmt.Sprintf(`(only works with '--%s') time to wait between batches
+(e.g., '5m'), to let downstream systems react; the pause is cut short if the
+run is cancelled (not used in dry runs)`,
+			cli.PromoterBatchSizeFlag,
+		),
+	)
+
+	flags.DurationVar(
+		&opts.Timeout,
+		cli.PromoterTimeoutFlag,
+		opts.Timeout,
+		`stop after the given duration (e.g., '30m'), cancelling the requests that
+are still pending and printing a summary of the work that was done; the exit
+code is 6 in that case (0 means no timeout)`,
+	)
+
+	flags.StringVar(
+		&opts.PromotionLock,
+		cli.PromoterPromotionLockFlag,
+		opts.PromotionLock,
+		`GCS location (e.g., 'gs://bucket/locks') of the locks that keep
+concurrent runs from promoting into the same destination registry at the same
+time; a run waits for the locks held by others (not used in dry runs)`,
+	)
+
+	flags.DurationVar(
+		&opts.PromotionLockTTL,
+		cli.PromoterPromotionLockTTLFlag,
+		cli.PromoterDefaultPromotionLockTTL,
+		fmt.Sprintf(`time after which a lock of '--%s' that was not renewed
+(every third of this time) may be taken over by other runs (e.g., if the run
+holding it crashed)`,
+			cli.PromoterPromotionLockFlag,
+		),
+	)
+
+	flags.StringVar(
+		&opts.PublishEvents,
+		cli.PromoterPublishEventsFlag,
+		opts.PublishEvents,
+		`Pub/Sub topic (projects/<project>/topics/<topic>) to publish an event
+to for every image that was promoted successfully`,
+	)
+
+	flags.BoolVar(
+		&opts.FailOnPublishError,
+		cli.PromoterFailOnPublishErrorFlag,
+		opts.FailOnPublishError,
+		fmt.Sprintf(`fail promotions whose event could not be published to
+'--%s' (by default, publishing failures are only logged)`,
+			cli.PromoterPublishEventsFlag,
+		),
+	)
+
+	flags.BoolVar(
+		&opts.GenerateProvenance,
+		cli.PromoterGenerateProvenanceFlag,
+		opts.GenerateProvenance,
+		`attach a SLSA provenance statement (as an OCI referrer) to every image
+that was promoted successfully; promotions whose provenance could not be
+attached fail`,
+	)
+
+	flags.BoolVar(
+		&opts.SmokeVerify,
+		cli.PromoterSmokeVerifyFlag,
+		opts.SmokeVerify,
+		`pull the manifest of every image that was promoted successfully back
+from the destination; promotions whose image cannot be pulled, or is not the
+promoted digest, fail`,
+	)
+
+	flags.StringVar(
+		&opts.ApprovalEndpoint,
+		cli.PromoterApprovalEndpointFlag,
+		opts.ApprovalEndpoint,
+		`URL of the approval API that every promotion to a registry marked with
+'requiresApproval: true' is POSTed to (as JSON); promotions that are not
+approved are skipped`,
+	)
+
+	flags.StringVar(
+		&opts.DigestOracleURL,
+		cli.PromoterDigestOracleURLFlag,
+		opts.DigestOracleURL,
+		`URL of the digest API that is asked for the digest of every tag to be
+promoted (as GET <url>?image=<image>&tag=<tag>); the run fails if any tag of the
+manifests points at another digest than the API has for it`,
+	)
+
+	flags.StringVar(
+		&opts.CloudMonitoringProject,
+		cli.PromoterCloudMonitoringProjectFlag,
+		opts.CloudMonitoringProject,
+		`ID of the Google Cloud project to export the promotion metrics
+(counts and latencies of the promotion requests) to, as Cloud Monitoring custom
+metrics`,
+	)
+
+	flags.StringVar(
+		&opts.CloudMonitoringPrefix,
+		cli.PromoterCloudMonitoringPrefixFlag,
+		cli.PromoterDefaultCloudMonitoringPrefix,
+		fmt.Sprintf(`prefix of the types of the metrics exported to
+'--%s'`,
+			cli.PromoterCloudMonitoringProjectFlag,
+		),
+	)
+
+	flags.StringVar(
+		&opts.ForceOverwrite,
+		cli.PromoterForceOverwriteFlag,
+		opts.ForceOverwrite,
+		`comma-separated list of destination tags (<image>:<tag>, or
+<registry>/<image>:<tag> for a single registry) that may be moved to the digest
+given in the manifests; all other tag moves are rejected`,
+	)
+
+	flags.BoolVar(
+		&opts.AllowSelfPromotion,
+		cli.PromoterAllowSelfPromotionFlag,
+		opts.AllowSelfPromotion,
+		`allow manifests to list their source registry as a destination as well
+(e.g., to re-tag images in place); by default, this is rejected as a likely
+copy-paste error`,
+	)
+
+	flags.BoolVar(
+		&opts.Preflight,
+		cli.PromoterPreflightFlag,
+		cli.PromoterDefaultPreflight,
+		`check that every destination registry is reachable (by requesting its
+'/v2/' endpoint) before reading or promoting anything, and fail with a report
+of the unreachable ones otherwise`,
+	)
+
+	flags.BoolVar(
+		&opts.SkipExistingQuietly,
+		"skip-existing-quietly",
+		opts.SkipExistingQuietly,
+		`do not log each image that was already promoted; only log how many
+were skipped`,
+	)
+
+	flags.BoolVar(
+		&opts.LogSkippedEdges,
+		cli.PromoterLogSkippedEdgesFlag,
+		opts.LogSkippedEdges,
+		`list the images that were not promoted, by the reason why they were
+skipped (e.g., "already-promoted"), under 'Skipped' in the
+'--json-log-summary' output`,
+	)
+
+	flags.BoolVar(
+		&opts.ProjectedInventory,
+		cli.PromoterProjectedInventoryFlag,
+		opts.ProjectedInventory,
+		`(only works with dry run) print what each destination registry would
+look like after promotion, in the format given by '--output'`,
+	)
+
+	flags.StringVar(
+		&opts.CostTable,
+		cli.PromoterCostTableFlag,
+		opts.CostTable,
+		`(only works with dry run) YAML file with the prices of egress and
+storage per GiB; the estimated cost of the promotion is printed per
+destination registry`,
+	)
+
+	flags.IntVar(
+		&opts.MaxImageSize,
 		"max-image-size",
 		cli.PromoterDefaultMaxImageSize,
 		"the maximum image size (in MiB) allowed for promotion",
 	)
 
 	// TODO: Set this in a function instead
-	if runOpts.MaxImageSize <= 0 {
-		runOpts.MaxImageSize = 2048
+	if opts.MaxImageSize <= 0 {
+		opts.MaxImageSize = 2048
 	}
 
-	runCmd.PersistentFlags().IntVar(
-		&runOpts.SeverityThreshold,
+	flags.IntVar(
+		&opts.SeverityThreshold,
 		"vuln-severity-threshold",
 		cli.PromoterDefaultSeverityThreshold,
 		`Using this flag will cause the promoter to only run the vulnerability
@@ -187,5 +761,207 @@ vulnerability check failing [severity levels between 0 and 5; 0 - UNSPECIFIED,
 1 - MINIMAL, 2 - LOW, 3 - MEDIUM, 4 - HIGH, 5 - CRITICAL]`,
 	)
 
-	rootCmd.AddCommand(runCmd)
+	flags.IntVar(
+		&opts.VulnThreads,
+		cli.PromoterVulnThreadsFlag,
+		opts.VulnThreads,
+		`number of images the vulnerability check scans concurrently (defaults
+to the value of '--threads')`,
+	)
+
+	flags.DurationVar(
+		&opts.VulnWaitTimeout,
+		cli.PromoterVulnWaitTimeoutFlag,
+		opts.VulnWaitTimeout,
+		`how long the vulnerability check waits for the scan of each image to
+finish before reading its vulnerabilities (e.g., '10m'); images whose scan
+fails or does not finish in time fail the check. By default, the check does not
+wait, and reads whatever the scan has found so far`,
+	)
+
+	flags.IntVar(
+		&opts.MinSignatures,
+		cli.PromoterMinSignaturesFlag,
+		opts.MinSignatures,
+		`the minimum number of valid (cosign) signatures every image must have to
+be promoted; images may require more with 'minSignatures' in their manifests`,
+	)
+
+	flags.StringSliceVar(
+		&opts.SignaturePublicKeys,
+		cli.PromoterSignaturePublicKeyFlag,
+		opts.SignaturePublicKeys,
+		`PEM file with a public key that image signatures are verified with (may
+be repeated; a signature is valid if any of the keys verifies it)`,
+	)
+
+	flags.StringSliceVar(
+		&opts.AllowedRegistryHosts,
+		cli.PromoterAllowedRegistryHostsFlag,
+		opts.AllowedRegistryHosts,
+		`comma-separated globs of the registry hosts that manifests may name
+(e.g., 'gcr.io,*.pkg.dev'); manifests with registries on any other host are
+rejected. By default, all hosts are allowed`,
+	)
+
+	flags.StringVar(
+		&opts.VulnMode,
+		"vuln-mode",
+		cli.PromoterDefaultVulnMode,
+		fmt.Sprintf(`(only works with '--vuln-severity-threshold') what to do
+with vulnerabilities found at or above the threshold; %q fails the run without
+promoting, while %q only reports them and proceeds with the promotion (allowed
+values: %q)`,
+			cli.PromoterVulnModeEnforce,
+			cli.PromoterVulnModeWarn,
+			cli.PromoterAllowedVulnModes,
+		),
+	)
+
+	flags.DurationVar(
+		&opts.MaxImageAge,
+		cli.PromoterMaxImageAgeFlag,
+		opts.MaxImageAge,
+		`the maximum age of the images to promote (e.g. '2160h'), as given by the
+creation time in their image configs; older images are flagged as stale (0
+disables the check)`,
+	)
+
+	flags.StringVar(
+		&opts.ImageAgeMode,
+		cli.PromoterImageAgeModeFlag,
+		cli.PromoterDefaultImageAgeMode,
+		fmt.Sprintf(`(only works with '--%s') what to do with images that are
+older than the maximum age; %q fails the run without promoting, while %q only
+reports them and proceeds with the promotion (allowed values: %q)`,
+			cli.PromoterMaxImageAgeFlag,
+			cli.PromoterImageAgeModeEnforce,
+			cli.PromoterImageAgeModeWarn,
+			cli.PromoterAllowedImageAgeModes,
+		),
+	)
+
+	flags.StringVar(
+		&opts.DigestAllowlist,
+		cli.PromoterDigestAllowlistFlag,
+		opts.DigestAllowlist,
+		`file with the only digests that may be promoted, one digest per line;
+promoting any other digest fails the run (enables the 'digest-allowlist' check)`,
+	)
+
+	flags.StringVar(
+		&opts.HTTPSProxy,
+		cli.PromoterHTTPSProxyFlag,
+		opts.HTTPSProxy,
+		`proxy URL to use for all communication with registries, including
+the gcloud and crane subprocesses (takes precedence over the HTTPS_PROXY
+environment variable)`,
+	)
+
+	flags.StringVar(
+		&opts.NoProxy,
+		cli.PromoterNoProxyFlag,
+		opts.NoProxy,
+		`comma-separated list of hosts (and their subdomains) that should not
+be reached through the proxy (takes precedence over the NO_PROXY environment
+variable)`,
+	)
+
+	flags.StringToStringVar(
+		&opts.RegistryMirrors,
+		cli.PromoterRegistryMirrorFlag,
+		opts.RegistryMirrors,
+		`read source registries through a mirror (pull-through cache), given as
+'<registry host>=<mirror host>' mappings (e.g., 'gcr.io=mirror.example.com');
+this applies to reads and image pulls only, never to writes to destination
+registries`,
+	)
+
+	flags.StringToStringVar(
+		&opts.ClientCerts,
+		cli.PromoterClientCertFlag,
+		opts.ClientCerts,
+		`client certificate (PEM) to present to registries that require mutual
+TLS, given as '<registry host>=<file>' mappings (needs a '--client-key' for the
+same registry host)`,
+	)
+
+	flags.StringToStringVar(
+		&opts.ClientKeys,
+		cli.PromoterClientKeyFlag,
+		opts.ClientKeys,
+		`private key (PEM) of the '--client-cert' of a registry, given as
+'<registry host>=<file>' mappings`,
+	)
+
+	flags.StringToStringVar(
+		&opts.CABundles,
+		cli.PromoterCABundleFlag,
+		opts.CABundles,
+		`bundle of CA certificates (PEM) to verify the certificate of a
+registry against, in addition to the system roots, given as
+'<registry host>=<file>' mappings`,
+	)
+
+	flags.StringVar(
+		&opts.UserAgent,
+		cli.PromoterUserAgentFlag,
+		opts.UserAgent,
+		`User-Agent header to send with registry HTTP requests (default
+"cip/<version> (...)")`,
+	)
+
+	flags.IntSliceVar(
+		&opts.RetryStatusCodes,
+		cli.PromoterRetryStatusCodesFlag,
+		cli.PromoterDefaultRetryStatusCodes,
+		`HTTP status codes of failed registry reads that are retried (with
+exponential backoff); reads that fail with any other status code fail at once,
+while connection errors are always retried`,
+	)
+
+	flags.IntVar(
+		&opts.CopyRetries,
+		cli.PromoterCopyRetriesFlag,
+		opts.CopyRetries,
+		fmt.Sprintf(`number of times that a failed image copy is retried, if it
+failed with one of the '--%s'`,
+			cli.PromoterRetryStatusCodesFlag,
+		),
+	)
+
+	flags.IntVar(
+		&opts.TagRetries,
+		cli.PromoterTagRetriesFlag,
+		cli.PromoterDefaultTagRetries,
+		fmt.Sprintf(`number of times that a failed tag write (of a digest that
+is already in the destination) is retried, if it failed with one of the '--%s'`,
+			cli.PromoterRetryStatusCodesFlag,
+		),
+	)
+
+	flags.StringVar(
+		&opts.PrintConfig,
+		cli.PromoterPrintConfigFlag,
+		opts.PrintConfig,
+		`print the effective configuration (the manifest source, thread counts,
+authentication mode and the values of all flags, including the defaults) as
+'yaml' (the default) or 'json', and exit without doing anything else`,
+	)
+	flags.Lookup(cli.PromoterPrintConfigFlag).NoOptDefVal =
+		cli.PromoterDefaultPrintConfigFormat
+}
+
+// printConfig writes the effective configuration of 'cip run' (see
+// '--print-config') to w, with the values of all flags of cmd.
+func printConfig(cmd *cobra.Command, w io.Writer) error {
+	flags := make(map[string]string)
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		if flag.Name == "help" || flag.Name == cli.PromoterPrintConfigFlag {
+			return
+		}
+		flags[flag.Name] = flag.Value.String()
+	})
+
+	return cli.WriteEffectiveConfig(w, runOpts, flags)
 }
@@ -0,0 +1,145 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/cli"
+)
+
+func TestPrintConfig(t *testing.T) {
+	savedOpts := *runOpts
+	defer func() {
+		*runOpts = savedOpts
+	}()
+
+	require.Nil(t, runCmd.ParseFlags([]string{
+		"--thin-manifest-dir=manifests",
+		"--threads=7",
+		"--use-service-account",
+		"--key-files=key.json",
+		"--print-config=json",
+	}))
+
+	var out bytes.Buffer
+	require.Nil(t, printConfig(runCmd, &out))
+
+	var config cli.EffectiveConfig
+	require.Nil(t, json.Unmarshal(out.Bytes(), &config))
+	require.Equal(t, "--thin-manifest-dir=manifests", config.ManifestSource)
+	require.Equal(t, 7, config.Threads)
+	require.Equal(
+		t,
+		"service accounts (gcloud), activated from --key-files=key.json",
+		config.AuthMode)
+
+	// Flags that were given, and the defaults of the others.
+	require.Equal(t, "7", config.Flags["threads"])
+	require.Equal(t, "manifests", config.Flags[cli.PromoterThinManifestDirFlag])
+	require.Equal(
+		t,
+		cli.PromoterDefaultThinManifestFilename,
+		config.Flags[cli.PromoterThinManifestFilenameFlag])
+	require.NotContains(t, config.Flags, cli.PromoterPrintConfigFlag)
+
+	// Without a value, the configuration is printed as YAML.
+	require.Nil(t, runCmd.ParseFlags([]string{"--print-config"}))
+	require.Equal(t, cli.PromoterDefaultPrintConfigFormat, runOpts.PrintConfig)
+
+	out.Reset()
+	require.Nil(t, printConfig(runCmd, &out))
+	config = cli.EffectiveConfig{}
+	require.Nil(t, yaml.Unmarshal(out.Bytes(), &config))
+	require.Equal(t, 7, config.Threads)
+
+	runOpts.PrintConfig = "toml"
+	require.NotNil(t, printConfig(runCmd, &out))
+}
+
+func TestManifestBasedSnapshotFromInventory(t *testing.T) {
+	const digest = "sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "promoter-manifest.yaml")
+	require.Nil(t, ioutil.WriteFile(manifest, []byte(`registries:
+- name: registry.invalid/foo-staging
+  src: true
+- name: registry.invalid/foo
+  service-account: robot
+images:
+- name: a
+  dmap:
+    "`+digest+`": ["1.0"]
+`), 0o644))
+
+	// The registry does not exist (".invalid" never resolves), so the
+	// snapshot can only be made from the inventory.
+	inventory := filepath.Join(dir, "inventory.yaml")
+	require.Nil(t, ioutil.WriteFile(inventory, []byte(`registry.invalid/foo:
+  a:
+    "`+digest+`": ["1.0"]
+`), 0o644))
+
+	tests := []struct {
+		name           string
+		snapshotOnly   string
+		expectedDigest bool
+	}{
+		{
+			"Images",
+			cli.PromoterSnapshotOnlyImages,
+			true,
+		},
+		{
+			"Manifest lists",
+			cli.PromoterSnapshotOnlyManifestLists,
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		savedOpts := *runOpts
+		snapshot := filepath.Join(dir, "snapshot.yaml")
+
+		require.Nil(t, runCmd.ParseFlags([]string{
+			"--manifest=" + manifest,
+			"--manifest-based-snapshot-of=registry.invalid/foo",
+			"--snapshot-only=" + test.snapshotOnly,
+			"--inventory-from=" + inventory,
+			"--snapshot-file=" + snapshot,
+		}), test.name)
+		err := cli.RunPromoteCmd(runOpts)
+		*runOpts = savedOpts
+		require.Nil(t, err, test.name)
+
+		out, err := ioutil.ReadFile(snapshot)
+		require.Nil(t, err, test.name)
+		require.Equal(
+			t,
+			test.expectedDigest,
+			bytes.Contains(out, []byte(digest)),
+			test.name)
+	}
+}
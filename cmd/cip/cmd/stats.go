@@ -0,0 +1,42 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/cli"
+)
+
+var statsOpts = &cli.StatsOptions{}
+
+// statsCmd is the command when calling `cip stats`.
+var statsCmd = &cobra.Command{
+	Use:           "stats <snapshot.yaml>",
+	Short:         "print the number of images, digests and tags in a snapshot",
+	Args:          cobra.ExactArgs(1),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		statsOpts.Snapshot = args[0]
+		return cli.RunStatsCmd(statsOpts)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statsCmd)
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/cli"
+)
+
+// tagsCmd is the command when calling `cip tags`.
+var tagsCmd = &cobra.Command{
+	Use:   "tags <image>",
+	Short: "print the tags of an image and the digests they point at",
+	Long: `tags - print the tags of an image and the digests they point at
+
+Read a single image (e.g., gcr.io/foo/bar), without its child repositories or
+the rest of the registry, and print its tags along with the digests they point
+at. Tagless digests are not printed.`,
+	Args:          cobra.ExactArgs(1),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tagsOpts.Image = args[0]
+		return errors.Wrap(
+			cli.RunTagsCmd(tagsOpts),
+			"run `cip tags`",
+		)
+	},
+}
+
+var tagsOpts = &cli.TagsOptions{}
+
+func init() {
+	tagsCmd.PersistentFlags().StringVar(
+		&tagsOpts.OutputFormat,
+		cli.PromoterOutputFlag,
+		cli.TagsDefaultOutputFormat,
+		fmt.Sprintf(
+			"output format of the tags (allowed values: %q)",
+			cli.PromoterAllowedOutputFormats,
+		),
+	)
+
+	tagsCmd.PersistentFlags().StringVar(
+		&tagsOpts.OutputTemplate,
+		cli.PromoterOutputTemplateFlag,
+		tagsOpts.OutputTemplate,
+		fmt.Sprintf(
+			"(only works with '--%s=%s') file with the Go text/template that renders the tags",
+			cli.PromoterOutputFlag,
+			cli.PromoterOutputFormatTemplate,
+		),
+	)
+
+	tagsCmd.PersistentFlags().StringVar(
+		&tagsOpts.SvcAcct,
+		cli.TagsServiceAccountFlag,
+		tagsOpts.SvcAcct,
+		"the service account to read the image with",
+	)
+
+	tagsCmd.PersistentFlags().BoolVar(
+		&tagsOpts.UseServiceAcct,
+		"use-service-account",
+		tagsOpts.UseServiceAcct,
+		"pass '--account=...' to all gcloud calls",
+	)
+
+	rootCmd.AddCommand(tagsCmd)
+}
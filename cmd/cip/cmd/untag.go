@@ -0,0 +1,75 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/cli"
+)
+
+// untagCmd is the command when calling `cip untag`.
+var untagCmd = &cobra.Command{
+	Use:   "untag <registry>/<image>:<tag>",
+	Short: "remove a single tag from an image",
+	Long: `untag - remove a single tag from an image
+
+Remove a single tag (e.g., a bad release tag) from an image in a destination
+registry, without garbage collecting anything: the digest the tag points at,
+and the other tags of the image, are left intact. As this modifies the
+registry, '--confirm' is required, unless '--dry-run' is given to only print
+the command that would remove the tag.`,
+	Args:          cobra.ExactArgs(1),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		untagOpts.Image = args[0]
+		untagOpts.DryRun = rootOpts.DryRun
+		return errors.Wrap(
+			cli.RunUntagCmd(untagOpts),
+			"run `cip untag`",
+		)
+	},
+}
+
+var untagOpts = &cli.UntagOptions{}
+
+func init() {
+	untagCmd.PersistentFlags().BoolVar(
+		&untagOpts.Confirm,
+		cli.UntagConfirmFlag,
+		untagOpts.Confirm,
+		"actually remove the tag (required unless '--dry-run' is given)",
+	)
+
+	untagCmd.PersistentFlags().StringVar(
+		&untagOpts.SvcAcct,
+		cli.TagsServiceAccountFlag,
+		untagOpts.SvcAcct,
+		"the service account to remove the tag with",
+	)
+
+	untagCmd.PersistentFlags().BoolVar(
+		&untagOpts.UseServiceAcct,
+		"use-service-account",
+		untagOpts.UseServiceAcct,
+		"pass '--account=...' to all gcloud calls",
+	)
+
+	rootCmd.AddCommand(untagCmd)
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/cli"
+)
+
+// watchCmd represents the watch subcommand.
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Continuously promote images as they are pushed",
+	Long: `cip watch - Continuous image promotion
+
+Start a server that responds to GCR Pub/Sub push events of the source
+registries, and promotes newly pushed images that match the manifests. Each
+image is promoted with the same checks and options as 'cip run'.
+`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		watchOpts.DryRun = rootOpts.DryRun
+		watchOpts.Quiet = rootOpts.Quiet
+		return errors.Wrap(
+			cli.RunWatchCmd(watchOpts),
+			"run `cip watch`",
+		)
+	},
+}
+
+var watchOpts = &cli.RunOptions{}
+
+func init() {
+	// The watcher promotes like 'cip run', so it takes the same options. Those
+	// that make no sense for a long-running server are rejected by
+	// cli.RunWatchCmd.
+	addRunFlags(watchCmd.PersistentFlags(), watchOpts)
+
+	rootCmd.AddCommand(watchCmd)
+}
@@ -78,3 +78,16 @@ func (i *Info) JSONString() (string, error) {
 	}
 	return string(b), nil
 }
+
+// UserAgent returns the User-Agent string sent with registry HTTP requests.
+// Builds without version information identify themselves as "devel".
+func (i *Info) UserAgent() string {
+	v := i.GitVersion
+	if v == "" {
+		v = "devel"
+	}
+	return fmt.Sprintf(
+		"cip/%s (%s; +https://github.com/kubernetes-sigs/k8s-container-image-promoter)",
+		v,
+		i.Platform)
+}
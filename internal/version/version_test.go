@@ -32,3 +32,13 @@ func TestVersionJSON(t *testing.T) {
 	require.Nil(t, err)
 	require.NotEmpty(t, sut)
 }
+
+func TestVersionUserAgent(t *testing.T) {
+	defer func(v string) { gitVersion = v }(gitVersion)
+
+	gitVersion = "v1.2.3-4-gabcdef0"
+	require.Contains(t, Get().UserAgent(), "cip/v1.2.3-4-gabcdef0")
+
+	gitVersion = ""
+	require.Contains(t, Get().UserAgent(), "cip/devel")
+}
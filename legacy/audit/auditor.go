@@ -64,6 +64,7 @@ func InitRealServerContext(
 			ReadRepo:         reg.MkReadRepositoryCmdReal,
 			ReadManifestList: reg.MkReadManifestListCmdReal,
 		},
+		ManifestRepoStatus: &ManifestRepoStatus{},
 	}
 
 	return &serverContext, nil
@@ -79,12 +80,20 @@ func (s *ServerContext) RunAuditor() {
 	// nolint[errcheck]
 	defer s.ErrorReportingFacility.Close()
 
+	// Clone the manifest repo once up front, so that the readiness probe
+	// can succeed before the first Pub/Sub message arrives.
+	if _, err := s.fetchManifests(); err != nil {
+		logrus.Errorf("Could not fetch promoter manifests: %v", err)
+	}
+
 	http.HandleFunc(
 		"/",
 		func(w http.ResponseWriter, r *http.Request) {
 			s.Audit(w, r)
 		},
 	)
+	http.HandleFunc("/healthz", s.Healthz)
+	http.HandleFunc("/readyz", s.Readyz)
 
 	// Determine port for HTTP service.
 	port := os.Getenv("PORT")
@@ -97,6 +106,78 @@ func (s *ServerContext) RunAuditor() {
 	logrus.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", port), nil))
 }
 
+// fetchManifests fetches the promoter manifests and records the outcome in
+// the ManifestRepoStatus.
+func (s *ServerContext) fetchManifests() ([]reg.Manifest, error) {
+	manifests, err := s.RemoteManifestFacility.Fetch()
+	s.ManifestRepoStatus.record(err)
+
+	return manifests, err
+}
+
+// record stores the result of a fetch of the manifest repo.
+func (status *ManifestRepoStatus) record(err error) {
+	if status == nil {
+		return
+	}
+
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+
+	if err == nil {
+		status.cloned = true
+	}
+	status.err = err
+}
+
+// get returns whether the manifest repo was ever cloned, and the error of the
+// most recent fetch. A nil status is treated as never having been fetched.
+func (status *ManifestRepoStatus) get() (cloned bool, err error) {
+	if status == nil {
+		return false, fmt.Errorf("manifest repo has not been fetched")
+	}
+
+	status.mutex.RLock()
+	defer status.mutex.RUnlock()
+
+	if !status.cloned && status.err == nil {
+		return false, fmt.Errorf("manifest repo has not been fetched")
+	}
+
+	return status.cloned, status.err
+}
+
+// Healthz is the liveness probe handler. The auditor is alive as long as it
+// can serve HTTP requests, so this always responds with 200 OK; the body
+// reports whether the manifest repo has been cloned.
+func (s *ServerContext) Healthz(w http.ResponseWriter, r *http.Request) {
+	cloned, _ := s.ManifestRepoStatus.get()
+	if cloned {
+		_, _ = w.Write([]byte("ok: manifest repo cloned\n"))
+		return
+	}
+
+	_, _ = w.Write([]byte("ok: manifest repo not cloned yet\n"))
+}
+
+// Readyz is the readiness probe handler. The auditor is ready once it has
+// cloned the manifest repo, and stops being ready whenever a later fetch of
+// the manifest repo fails. While not ready, every probe retries the fetch so
+// that the auditor recovers once the manifest repo is reachable again.
+func (s *ServerContext) Readyz(w http.ResponseWriter, r *http.Request) {
+	if _, err := s.ManifestRepoStatus.get(); err != nil {
+		if _, err = s.fetchManifests(); err != nil {
+			http.Error(
+				w,
+				fmt.Sprintf("not ready: manifest repo unreachable: %v", err),
+				http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	_, _ = w.Write([]byte("ok\n"))
+}
+
 // ParsePubSubMessage parses an HTTP request body into a reg.GCRPubSubPayload.
 func ParsePubSubMessage(body io.Reader) (*reg.GCRPubSubPayload, error) {
 	// Handle basic errors (malformed requests).
@@ -215,7 +296,7 @@ func (s *ServerContext) Audit(w http.ResponseWriter, r *http.Request) {
 	logInfo.Println(msg)
 
 	// (2) Clone fresh repo (or use one already on disk).
-	manifests, err := s.RemoteManifestFacility.Fetch()
+	manifests, err := s.fetchManifests()
 	if err != nil {
 		logError.Println(err)
 		// If there is an error, return an HTTP error so that the Pub/Sub
@@ -303,7 +384,16 @@ func (s *ServerContext) Audit(w http.ResponseWriter, r *http.Request) {
 		s.GcrReadingFacility.ReadRepo,
 	)
 
-	sc.ReadGCRManifestLists(s.GcrReadingFacility.ReadManifestList)
+	// Without all manifest lists, a child manifest could be rejected for
+	// lack of a parent, so retry the Pub/Sub message instead.
+	if err := sc.ReadGCRManifestLists(
+		s.GcrReadingFacility.ReadManifestList,
+	); err != nil {
+		logError.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
 	if gcrPayload.Digest == "" {
 		msg := fmt.Sprintf("(%s) TRANSACTION REJECTED: digest missing from payload --- cannot check parent digest: %v", s.ID, gcrPayload.Digest)
 		_, _ = w.Write([]byte(msg))
@@ -946,7 +946,97 @@ func initFakeServerContext(
 			ReadRepo:         fakeReadRepo,
 			ReadManifestList: fakeReadManifestList,
 		},
+		ManifestRepoStatus: &audit.ManifestRepoStatus{},
 	}
 
 	return serverContext
 }
+
+// flakyRemote is a remotemanifest.Facility whose Fetch fails while err is set.
+type flakyRemote struct {
+	err error
+}
+
+func (remote *flakyRemote) Fetch() ([]reg.Manifest, error) {
+	if remote.err != nil {
+		return nil, remote.err
+	}
+
+	return []reg.Manifest{}, nil
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	type response struct {
+		code int
+		body string
+	}
+
+	probe := func(
+		handler func(http.ResponseWriter, *http.Request),
+		path string,
+	) response {
+		req, err := http.NewRequest("GET", path, nil)
+		require.Nil(t, err)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(handler).ServeHTTP(rr, req)
+
+		return response{rr.Code, rr.Body.String()}
+	}
+
+	remote := &flakyRemote{err: fmt.Errorf("connection refused")}
+	s := audit.ServerContext{
+		RemoteManifestFacility: remote,
+		ManifestRepoStatus:     &audit.ManifestRepoStatus{},
+	}
+
+	// Not ready: the manifest repo has never been cloned.
+	require.Equal(t,
+		response{http.StatusOK, "ok: manifest repo not cloned yet\n"},
+		probe(s.Healthz, "/healthz"))
+	require.Equal(t,
+		response{
+			http.StatusServiceUnavailable,
+			"not ready: manifest repo unreachable: connection refused\n",
+		},
+		probe(s.Readyz, "/readyz"))
+
+	// Ready: the readiness probe retries the fetch, which now succeeds.
+	remote.err = nil
+	require.Equal(t,
+		response{http.StatusOK, "ok\n"},
+		probe(s.Readyz, "/readyz"))
+	require.Equal(t,
+		response{http.StatusOK, "ok: manifest repo cloned\n"},
+		probe(s.Healthz, "/healthz"))
+
+	// Not ready again: a Pub/Sub message could not fetch the manifest repo.
+	remote.err = fmt.Errorf("repository not found")
+	s.LoggingFacility = logclient.NewFakeLogClient()
+	s.ErrorReportingFacility = report.NewFakeReportingClient()
+	payload, err := json.Marshal(reg.GCRPubSubPayload{
+		Action: "INSERT",
+		FQIN:   "gcr.io/foo/bar@sha256:000",
+	})
+	require.Nil(t, err)
+	psm, err := json.Marshal(audit.PubSubMessage{
+		Message: audit.PubSubMessageInner{Data: payload, ID: "1"},
+	})
+	require.Nil(t, err)
+	req, err := http.NewRequest("POST", "/", bytes.NewBuffer(psm))
+	require.Nil(t, err)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(s.Audit).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusInternalServerError, rr.Code)
+
+	require.Equal(t,
+		response{
+			http.StatusServiceUnavailable,
+			"not ready: manifest repo unreachable: repository not found\n",
+		},
+		probe(s.Readyz, "/readyz"))
+	// Liveness is unaffected; the repo was cloned before.
+	require.Equal(t,
+		response{http.StatusOK, "ok: manifest repo cloned\n"},
+		probe(s.Healthz, "/healthz"))
+}
@@ -17,6 +17,8 @@ limitations under the License.
 package audit
 
 import (
+	"sync"
+
 	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
 	"sigs.k8s.io/k8s-container-image-promoter/legacy/logclient"
 	"sigs.k8s.io/k8s-container-image-promoter/legacy/remotemanifest"
@@ -41,6 +43,19 @@ type ServerContext struct {
 	ErrorReportingFacility report.ReportingFacility
 	LoggingFacility        logclient.LoggingFacility
 	GcrReadingFacility     GcrReadingFacility
+	ManifestRepoStatus     *ManifestRepoStatus
+}
+
+// ManifestRepoStatus records the outcome of the most recent attempt to fetch
+// the promoter manifests from the manifest repo. It backs the /healthz and
+// /readyz endpoints of the auditor.
+type ManifestRepoStatus struct {
+	mutex sync.RWMutex
+	// cloned is true if the manifest repo has been fetched successfully at
+	// least once.
+	cloned bool
+	// err is the error of the most recent fetch, if any.
+	err error
 }
 
 // PubSubMessageInner is the inner struct that holds the actual Pub/Sub
@@ -0,0 +1,138 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+type AssertSnapshotOptions struct {
+	Manifest        string
+	ThinManifestDir string
+	Registry        string
+	Expected        string
+}
+
+const (
+	AssertSnapshotRegistryFlag = "registry"
+	AssertSnapshotExpectedFlag = "expected"
+)
+
+// RunAssertSnapshotCmd computes the snapshot that promoting the manifests into
+// an empty destination registry would produce (like
+// '--manifest-based-snapshot-of'), and compares it to the expected snapshot.
+func RunAssertSnapshotCmd(opts *AssertSnapshotOptions) error {
+	if err := validateAssertSnapshotOptions(opts); err != nil {
+		return errors.Wrap(err, "validating assert-snapshot options")
+	}
+
+	var mfests []reg.Manifest
+	if opts.Manifest != "" {
+		mfest, err := reg.ParseManifestFromFile(opts.Manifest)
+		if err != nil {
+			return &ParseError{errors.Wrap(err, "parsing manifest")}
+		}
+		mfests = []reg.Manifest{mfest}
+	} else {
+		var err error
+		mfests, err = reg.ParseThinManifestsFromDir(opts.ThinManifestDir)
+		if err != nil {
+			return &ParseError{errors.Wrap(err, "parsing thin manifest directory")}
+		}
+	}
+
+	edges, err := reg.ToPromotionEdges(mfests)
+	if err != nil {
+		return &ParseError{errors.Wrap(
+			err,
+			"converting list of manifests to edges for promotion",
+		)}
+	}
+
+	registry := opts.Registry
+	if registry == "" {
+		registry, err = onlyDestinationRegistry(edges)
+		if err != nil {
+			return err
+		}
+	}
+
+	expected, err := readSnapshot(opts.Expected)
+	if err != nil {
+		return err
+	}
+
+	got := reg.EdgesToRegInvImage(edges, registry)
+	if diff := reg.DiffSnapshots(expected, got); diff != "" {
+		fmt.Print(diff)
+		return errors.Errorf(
+			"snapshot of %s does not match %s (see diff above)",
+			registry,
+			opts.Expected)
+	}
+
+	fmt.Printf("snapshot of %s matches %s\n", registry, opts.Expected)
+	return nil
+}
+
+// onlyDestinationRegistry returns the destination registry of the edges, if
+// there is exactly one.
+func onlyDestinationRegistry(
+	edges map[reg.PromotionEdge]interface{},
+) (string, error) {
+	registries := make(map[reg.RegistryName]bool)
+	for edge := range edges {
+		registries[edge.DstRegistry.Name] = true
+	}
+
+	names := make([]string, 0, len(registries))
+	for name := range registries {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+
+	if len(names) != 1 {
+		return "", errors.Errorf(
+			"the manifests promote into %d registries %q; use '--%s' to pick one",
+			len(names),
+			names,
+			AssertSnapshotRegistryFlag)
+	}
+
+	return names[0], nil
+}
+
+func validateAssertSnapshotOptions(o *AssertSnapshotOptions) error {
+	if (o.Manifest == "") == (o.ThinManifestDir == "") {
+		return errors.Errorf(
+			"exactly one of the '--%s' and '--%s' flags is required",
+			PromoterManifestFlag,
+			PromoterThinManifestDirFlag)
+	}
+
+	if o.Expected == "" {
+		return errors.Errorf(
+			"the '--%s' flag is required", AssertSnapshotExpectedFlag)
+	}
+
+	return nil
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	PromoterPrintConfigFlag = "print-config"
+	// PromoterDefaultPrintConfigFormat is the format of '--print-config'
+	// when it is given without a value.
+	PromoterDefaultPrintConfigFormat = "yaml"
+)
+
+// EffectiveConfig is the configuration of 'cip run' once all of its flags
+// have been resolved, as printed by '--print-config'.
+type EffectiveConfig struct {
+	// ManifestSource is where the manifests are read from, e.g.
+	// "--thin-manifest-dir=manifests".
+	ManifestSource string `json:"manifestSource" yaml:"manifestSource"`
+	DryRun         bool   `json:"dryRun" yaml:"dryRun"`
+	Threads        int    `json:"threads" yaml:"threads"`
+	VulnThreads    int    `json:"vulnThreads" yaml:"vulnThreads"`
+	// AuthMode is how registries are authenticated with.
+	AuthMode string `json:"authMode" yaml:"authMode"`
+	// Flags holds the value of every flag, including the defaults.
+	Flags map[string]string `json:"flags" yaml:"flags"`
+}
+
+// EffectiveConfig returns the configuration that opts resolve to, with the
+// values of all flags (by name).
+func (o *RunOptions) EffectiveConfig(flags map[string]string) EffectiveConfig {
+	return EffectiveConfig{
+		ManifestSource: o.manifestSource(),
+		DryRun:         o.DryRun,
+		Threads:        o.Threads,
+		VulnThreads:    o.VulnThreads,
+		AuthMode:       o.authMode(),
+		Flags:          flags,
+	}
+}
+
+// manifestSource describes where the manifests are read from, in terms of the
+// flag that selects them (see RunPromoteCmd()).
+func (o *RunOptions) manifestSource() string {
+	switch {
+	case o.SnapshotRegistry != "":
+		return fmt.Sprintf("--%s=%s", PromoterSnapshotRegistryFlag, o.SnapshotRegistry)
+	case o.Snapshot != "":
+		return fmt.Sprintf("--%s=%s", PromoterSnapshotFlag, o.Snapshot)
+	case o.ManifestBasedSnapshotOf != "":
+		return fmt.Sprintf(
+			"--%s=%s",
+			PromoterManifestBasedSnapshotOfFlag,
+			o.ManifestBasedSnapshotOf)
+	case o.manifests != nil:
+		return fmt.Sprintf("%d generated manifest(s)", len(o.manifests))
+	case o.Manifest != "":
+		return fmt.Sprintf("--%s=%s", PromoterManifestFlag, o.Manifest)
+	case o.ThinManifestArchive != "":
+		return fmt.Sprintf(
+			"--%s=%s",
+			PromoterThinManifestArchiveFlag,
+			o.ThinManifestArchive)
+	case o.ThinManifestDir != "":
+		return fmt.Sprintf("--%s=%s", PromoterThinManifestDirFlag, o.ThinManifestDir)
+	default:
+		return "none"
+	}
+}
+
+// authMode describes how the registries are authenticated with.
+func (o *RunOptions) authMode() string {
+	mode := "default credentials"
+	if o.UseServiceAcct {
+		mode = "service accounts (gcloud)"
+		if o.KeyFiles != "" {
+			mode += fmt.Sprintf(", activated from --key-files=%s", o.KeyFiles)
+		}
+	}
+	if o.RegistryCredentials != "" {
+		mode += fmt.Sprintf(
+			", with the registries of --%s=%s",
+			PromoterRegistryCredentialsFlag,
+			o.RegistryCredentials)
+	}
+
+	return mode
+}
+
+// WriteEffectiveConfig writes the effective configuration of opts (see
+// EffectiveConfig()) to w, in the format of '--print-config' ("yaml" or
+// "json").
+func WriteEffectiveConfig(
+	w io.Writer,
+	opts *RunOptions,
+	flags map[string]string,
+) error {
+	config := opts.EffectiveConfig(flags)
+
+	var (
+		b   []byte
+		err error
+	)
+	switch strings.ToLower(opts.PrintConfig) {
+	case "yaml":
+		b, err = yaml.Marshal(config)
+	case "json":
+		b, err = json.MarshalIndent(config, "", "  ")
+		b = append(b, '\n')
+	default:
+		return errors.Errorf(
+			"invalid value %q for '--%s' (must be yaml or json)",
+			opts.PrintConfig,
+			PromoterPrintConfigFlag,
+		)
+	}
+	if err != nil {
+		return errors.Wrap(err, "marshalling the effective configuration")
+	}
+
+	_, err = w.Write(b)
+	return err
+}
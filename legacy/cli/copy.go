@@ -0,0 +1,122 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/gcloud"
+)
+
+type CopyOptions struct {
+	Src                 string
+	Dest                string
+	SrcSvcAcct          string
+	DestSvcAcct         string
+	KeyFiles            string
+	SignaturePublicKeys []string
+	Threads             int
+	CopyRetries         int
+	TagRetries          int
+	MinSignatures       int
+	DryRun              bool
+	UseServiceAcct      bool
+}
+
+const (
+	CopySrcSvcAcctFlag  = "src-service-account"
+	CopyDestSvcAcctFlag = "dest-service-account"
+)
+
+// RunCopyCmd promotes a single image (e.g., "gcr.io/foo/bar@sha256:...") to
+// another registry (e.g., "gcr.io/baz/bar:1.0"), like 'cip run' would for a
+// manifest that only lists this image. A source image that is only given by
+// tag is promoted with the digest that the tag points to.
+func RunCopyCmd(opts *CopyOptions) error {
+	src, err := reg.ParseImageRef(opts.Src)
+	if err != nil {
+		return &ParseError{errors.Wrap(err, "parsing source image")}
+	}
+	dest, err := reg.ParseImageRef(opts.Dest)
+	if err != nil {
+		return &ParseError{errors.Wrap(err, "parsing destination image")}
+	}
+
+	if src.Digest == "" {
+		src, err = resolveCopySrc(opts, src)
+		if err != nil {
+			return err
+		}
+	}
+
+	mfest, err := reg.CopyToManifest(src, dest, opts.SrcSvcAcct, opts.DestSvcAcct)
+	if err != nil {
+		return &ParseError{errors.Wrap(err, "building promotion manifest")}
+	}
+
+	runOpts := defaultRunOptions()
+	runOpts.KeyFiles = opts.KeyFiles
+	runOpts.SignaturePublicKeys = opts.SignaturePublicKeys
+	runOpts.Threads = opts.Threads
+	runOpts.MinSignatures = opts.MinSignatures
+	runOpts.CopyRetries = opts.CopyRetries
+	runOpts.TagRetries = opts.TagRetries
+	runOpts.DryRun = opts.DryRun
+	runOpts.UseServiceAcct = opts.UseServiceAcct
+	runOpts.manifests = []reg.Manifest{mfest}
+
+	return RunPromoteCmd(runOpts)
+}
+
+// resolveCopySrc looks up the digest that the tag of the source image points
+// to, with the source service account.
+func resolveCopySrc(opts *CopyOptions, src reg.ImageRef) (reg.ImageRef, error) {
+	if opts.UseServiceAcct && opts.KeyFiles != "" {
+		if err := gcloud.ActivateServiceAccounts(opts.KeyFiles); err != nil {
+			return reg.ImageRef{}, &AuthError{
+				errors.Wrap(err, "activating service accounts"),
+			}
+		}
+	}
+
+	sc, err := reg.MakeSyncContext(
+		[]reg.Manifest{{
+			Registries: []reg.RegistryContext{{
+				Name:           src.Registry,
+				ServiceAccount: opts.SrcSvcAcct,
+			}},
+		}},
+		1,
+		opts.DryRun,
+		opts.UseServiceAcct,
+	)
+	if err != nil {
+		return reg.ImageRef{}, &AuthError{
+			errors.Wrap(err, "creating sync context"),
+		}
+	}
+
+	resolved, err := sc.ResolveImageRef(src)
+	if err != nil {
+		return reg.ImageRef{}, err
+	}
+	logrus.Infof("resolved %s to %s", src, resolved.Digest)
+
+	return resolved, nil
+}
@@ -0,0 +1,333 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/release-utils/command"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/stream"
+)
+
+type DoctorOptions struct {
+	// Registry is the registry whose reachability is checked.
+	Registry string
+
+	// The fields below replace the real environment (e.g., in tests). Any
+	// that are nil use the real one.
+
+	// LookPath finds the executable of a tool, like exec.LookPath().
+	LookPath func(file string) (string, error)
+	// RunTool runs a tool and returns its (standard) output.
+	RunTool func(name string, args ...string) (string, error)
+	// Getenv reads an environment variable, like os.Getenv().
+	Getenv func(key string) string
+	// MkPingCmd makes the request that checks if the registry is reachable.
+	MkPingCmd func(*reg.SyncContext, reg.RegistryContext) stream.Producer
+	// Out is where the checklist is printed (os.Stdout if nil).
+	Out io.Writer
+}
+
+// DoctorDefaultRegistry is the registry whose reachability 'cip doctor'
+// checks by default.
+const DoctorDefaultRegistry = "gcr.io/k8s-artifacts-prod"
+
+// DoctorAuditEnvVars are the environment variables that 'cip audit' reads its
+// options from.
+var DoctorAuditEnvVars = []string{
+	"CIP_AUDIT_GCP_PROJECT_ID",
+	"CIP_AUDIT_MANIFEST_REPO_URL",
+	"CIP_AUDIT_MANIFEST_REPO_BRANCH",
+	"CIP_AUDIT_MANIFEST_REPO_MANIFEST_DIR",
+}
+
+// DoctorCheck is the outcome of one check of 'cip doctor'.
+type DoctorCheck struct {
+	Name   string
+	Passed bool
+	// Optional checks only matter for some features, so their failure does
+	// not fail the run.
+	Optional bool
+	// Detail describes what was found.
+	Detail string
+	// Hint says how to fix a failed check.
+	Hint string
+}
+
+func (c DoctorCheck) String() string {
+	status := "PASS"
+	if !c.Passed {
+		status = "FAIL"
+	}
+
+	name := c.Name
+	if c.Optional {
+		name += " (optional)"
+	}
+
+	s := fmt.Sprintf("[%s] %s: %s", status, name, c.Detail)
+	if !c.Passed && c.Hint != "" {
+		s += "\n       hint: " + c.Hint
+	}
+
+	return s
+}
+
+// RunDoctorCmd checks that the tools, credentials and network access that
+// the promoter needs are in place, and prints a checklist of the results. It
+// fails if any check that is not optional fails.
+func RunDoctorCmd(opts *DoctorOptions) error {
+	checks := opts.checks()
+
+	out := opts.out()
+	failed, failedOptional := 0, 0
+	for _, check := range checks {
+		fmt.Fprintln(out, check)
+		if check.Passed {
+			continue
+		}
+
+		if check.Optional {
+			failedOptional++
+		} else {
+			failed++
+		}
+	}
+
+	fmt.Fprintf(
+		out,
+		"\n%d of %d checks failed (%d optional)\n",
+		failed+failedOptional,
+		len(checks),
+		failedOptional)
+
+	if failed > 0 {
+		return errors.Errorf("%d required check(s) failed", failed)
+	}
+
+	return nil
+}
+
+// checks runs all checks, in the order they are printed.
+func (o *DoctorOptions) checks() []DoctorCheck {
+	gcloud := o.checkTool(
+		"gcloud",
+		[]string{"version"},
+		false,
+		"install the Google Cloud SDK (https://cloud.google.com/sdk/docs/install)")
+
+	checks := []DoctorCheck{
+		gcloud,
+		o.checkTool(
+			"crane",
+			[]string{"version"},
+			true,
+			fmt.Sprintf(
+				"install crane (only needed for '--%s=crane')",
+				PromoterCopyToolFlag)),
+		o.checkTool(
+			"skopeo",
+			[]string{"--version"},
+			true,
+			fmt.Sprintf(
+				"install skopeo (only needed for '--%s=skopeo')",
+				PromoterCopyToolFlag)),
+		o.checkCredentials(gcloud.Passed),
+		o.checkRegistry(),
+		o.checkAuditEnv(),
+	}
+
+	return checks
+}
+
+// checkTool checks that the tool is installed, and reports its version (the
+// first line of the output of the tool run with versionArgs).
+func (o *DoctorOptions) checkTool(
+	name string,
+	versionArgs []string,
+	optional bool,
+	hint string,
+) DoctorCheck {
+	check := DoctorCheck{Name: name, Optional: optional, Hint: hint}
+
+	path, err := o.lookPath()(name)
+	if err != nil {
+		check.Detail = "not found in $PATH"
+		return check
+	}
+
+	output, err := o.runTool()(name, versionArgs...)
+	if err != nil {
+		check.Detail = fmt.Sprintf("%s does not run: %v", path, err)
+		check.Hint = fmt.Sprintf(
+			"check that '%s %s' works",
+			name,
+			strings.Join(versionArgs, " "))
+		return check
+	}
+
+	version := strings.TrimSpace(strings.SplitN(output, "\n", 2)[0])
+	if version == "" {
+		version = "unknown version"
+	}
+
+	check.Passed = true
+	check.Detail = fmt.Sprintf("%s (%s)", version, path)
+	return check
+}
+
+// checkCredentials checks that gcloud has an active account.
+func (o *DoctorOptions) checkCredentials(haveGcloud bool) DoctorCheck {
+	check := DoctorCheck{
+		Name: "credentials",
+		Hint: "run 'gcloud auth login', or 'gcloud auth activate-service-account'",
+	}
+
+	if !haveGcloud {
+		check.Detail = "cannot be checked without gcloud"
+		check.Hint = "install gcloud first"
+		return check
+	}
+
+	output, err := o.runTool()(
+		"gcloud",
+		"auth",
+		"list",
+		"--filter=status:ACTIVE",
+		"--format=value(account)")
+	if err != nil {
+		check.Detail = fmt.Sprintf("could not list the accounts: %v", err)
+		return check
+	}
+
+	account := strings.TrimSpace(output)
+	if account == "" {
+		check.Detail = "no active account"
+		return check
+	}
+
+	check.Passed = true
+	check.Detail = "active account " + account
+	return check
+}
+
+// checkRegistry checks that the registry answers requests.
+func (o *DoctorOptions) checkRegistry() DoctorCheck {
+	registry := o.Registry
+	if registry == "" {
+		registry = DoctorDefaultRegistry
+	}
+
+	check := DoctorCheck{
+		Name: "registry",
+		Hint: "check the network, proxy and firewall settings",
+	}
+
+	sc := reg.SyncContext{}
+	rc := reg.RegistryContext{Name: reg.RegistryName(registry)}
+	if err := reg.Ping(o.mkPingCmd()(&sc, rc)); err != nil {
+		check.Detail = fmt.Sprintf("%s is unreachable: %v", registry, err)
+		return check
+	}
+
+	check.Passed = true
+	check.Detail = registry + " is reachable"
+	return check
+}
+
+// checkAuditEnv checks that the environment variables of 'cip audit' are
+// set.
+func (o *DoctorOptions) checkAuditEnv() DoctorCheck {
+	check := DoctorCheck{
+		Name:     "audit environment",
+		Optional: true,
+		Hint:     "set them (only needed for 'cip audit')",
+	}
+
+	missing := make([]string, 0)
+	for _, key := range DoctorAuditEnvVars {
+		if o.getenv()(key) == "" {
+			missing = append(missing, key)
+		}
+	}
+
+	if len(missing) > 0 {
+		check.Detail = "not set: " + strings.Join(missing, ", ")
+		return check
+	}
+
+	check.Passed = true
+	check.Detail = "all set"
+	return check
+}
+
+func (o *DoctorOptions) lookPath() func(string) (string, error) {
+	if o.LookPath != nil {
+		return o.LookPath
+	}
+
+	return exec.LookPath
+}
+
+func (o *DoctorOptions) runTool() func(string, ...string) (string, error) {
+	if o.RunTool != nil {
+		return o.RunTool
+	}
+
+	return func(name string, args ...string) (string, error) {
+		std, err := command.New(name, args...).RunSilentSuccessOutput()
+		if err != nil {
+			return "", err
+		}
+
+		return std.Output(), nil
+	}
+}
+
+func (o *DoctorOptions) getenv() func(string) string {
+	if o.Getenv != nil {
+		return o.Getenv
+	}
+
+	return os.Getenv
+}
+
+func (o *DoctorOptions) mkPingCmd() func(
+	*reg.SyncContext,
+	reg.RegistryContext,
+) stream.Producer {
+	if o.MkPingCmd != nil {
+		return o.MkPingCmd
+	}
+
+	return reg.MkPingCmdReal
+}
+
+func (o *DoctorOptions) out() io.Writer {
+	if o.Out != nil {
+		return o.Out
+	}
+
+	return os.Stdout
+}
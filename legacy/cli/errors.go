@@ -0,0 +1,65 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+// The error types below classify why a command failed, so that callers can
+// tell failure classes apart (e.g., to pick an exit code). They can be
+// retrieved from a wrapped error with errors.As().
+
+// ParseError is returned when the promoter manifests could not be parsed or
+// are invalid.
+type ParseError struct {
+	Err error
+}
+
+func (e *ParseError) Error() string { return e.Err.Error() }
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// AuthError is returned when credentials could not be activated or access
+// tokens could not be obtained.
+type AuthError struct {
+	Err error
+}
+
+func (e *AuthError) Error() string { return e.Err.Error() }
+func (e *AuthError) Unwrap() error { return e.Err }
+
+// VulnCheckError is returned when the vulnerability check rejects the images
+// to be promoted.
+type VulnCheckError struct {
+	Err error
+}
+
+func (e *VulnCheckError) Error() string { return e.Err.Error() }
+func (e *VulnCheckError) Unwrap() error { return e.Err }
+
+// PromotionError is returned when the images could not be promoted, either
+// because the registries are in an unexpected state or because copying failed.
+type PromotionError struct {
+	Err error
+}
+
+func (e *PromotionError) Error() string { return e.Err.Error() }
+func (e *PromotionError) Unwrap() error { return e.Err }
+
+// TimeoutError is returned when the run did not finish within its timeout.
+type TimeoutError struct {
+	Err error
+}
+
+func (e *TimeoutError) Error() string { return e.Err.Error() }
+func (e *TimeoutError) Unwrap() error { return e.Err }
@@ -0,0 +1,168 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+type FromK8sOptions struct {
+	Input          string
+	Src            string
+	Dest           string
+	SrcSvcAcct     string
+	DestSvcAcct    string
+	KeyFiles       string
+	Threads        int
+	DryRun         bool
+	UseServiceAcct bool
+	PrintEdges     bool
+}
+
+const (
+	FromK8sInputFlag       = "input"
+	FromK8sSrcFlag         = "src"
+	FromK8sDestFlag        = "dest"
+	FromK8sSrcSvcAcctFlag  = "src-service-account"
+	FromK8sDestSvcAcctFlag = "dest-service-account"
+	FromK8sPrintEdgesFlag  = "print-edges"
+)
+
+// RunFromK8sCmd promotes the images referenced by the containers of a rendered
+// Kubernetes YAML (such as the output of 'kustomize build') from the source
+// registry to the destination registry, like 'cip run' would for a manifest
+// that lists them.
+func RunFromK8sCmd(opts *FromK8sOptions) error {
+	if err := validateFromK8sOptions(opts); err != nil {
+		return errors.Wrap(err, "validating from-k8s options")
+	}
+
+	var input io.Reader = os.Stdin
+	if opts.Input != "-" {
+		f, err := os.Open(opts.Input)
+		if err != nil {
+			return &ParseError{errors.Wrap(err, "opening Kubernetes YAML")}
+		}
+		defer f.Close()
+		input = f
+	}
+
+	refs, err := reg.ParseK8sImageRefs(input)
+	if err != nil {
+		return &ParseError{errors.Wrap(err, "parsing Kubernetes YAML")}
+	}
+	logrus.Infof("found %d image reference(s) in %s", len(refs), opts.Input)
+
+	sc := reg.SyncContext{UseServiceAccount: opts.UseServiceAcct}
+	mfest, err := reg.K8sImagesToManifest(
+		refs,
+		reg.RegistryContext{
+			Name:           reg.RegistryName(opts.Src),
+			ServiceAccount: opts.SrcSvcAcct,
+		},
+		reg.RegistryContext{
+			Name:           reg.RegistryName(opts.Dest),
+			ServiceAccount: opts.DestSvcAcct,
+		},
+		reg.MkTagResolverReal(&sc),
+	)
+	if err != nil {
+		return &ParseError{errors.Wrap(err, "building promotion manifest")}
+	}
+
+	if opts.PrintEdges {
+		edges, err := reg.ToPromotionEdges([]reg.Manifest{mfest})
+		if err != nil {
+			return &ParseError{errors.Wrap(
+				err,
+				"converting manifest to edges for promotion",
+			)}
+		}
+
+		for _, edge := range reg.SortedPromotionEdges(edges) {
+			fmt.Println(formatPromotionEdge(edge))
+		}
+		return nil
+	}
+
+	runOpts := defaultRunOptions()
+	runOpts.KeyFiles = opts.KeyFiles
+	runOpts.Threads = opts.Threads
+	runOpts.DryRun = opts.DryRun
+	runOpts.UseServiceAcct = opts.UseServiceAcct
+	runOpts.manifests = []reg.Manifest{mfest}
+
+	return RunPromoteCmd(runOpts)
+}
+
+// formatPromotionEdge formats an edge as
+// "<src>@<digest> -> <dest>[:<tag>]@<digest>".
+func formatPromotionEdge(edge reg.PromotionEdge) string {
+	dest := reg.ToFQIN(
+		edge.DstRegistry.Name,
+		edge.DstImageTag.ImageName,
+		edge.Digest,
+	)
+	if edge.DstImageTag.Tag != "" {
+		dest = reg.ToPQIN(
+			edge.DstRegistry.Name,
+			edge.DstImageTag.ImageName,
+			edge.DstImageTag.Tag,
+		) + "@" + string(edge.Digest)
+	}
+
+	return fmt.Sprintf(
+		"%s -> %s",
+		reg.ToFQIN(
+			edge.SrcRegistry.Name,
+			edge.SrcImageTag.ImageName,
+			edge.Digest,
+		),
+		dest,
+	)
+}
+
+func validateFromK8sOptions(o *FromK8sOptions) error {
+	if o.Input == "" {
+		return errors.Errorf("the '--%s' flag is required", FromK8sInputFlag)
+	}
+
+	if o.Src == "" || o.Dest == "" {
+		return errors.Errorf(
+			"the '--%s' and '--%s' flags are required",
+			FromK8sSrcFlag,
+			FromK8sDestFlag,
+		)
+	}
+
+	if o.Src == o.Dest {
+		return errors.Errorf(
+			"'--%s' and '--%s' must be different registries",
+			FromK8sSrcFlag,
+			FromK8sDestFlag,
+		)
+	}
+
+	return nil
+}
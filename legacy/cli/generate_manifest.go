@@ -0,0 +1,133 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+type GenerateManifestOptions struct {
+	Src            string
+	Dest           string
+	SrcSvcAcct     string
+	DestSvcAcct    string
+	OutputFile     string
+	Threads        int
+	UseServiceAcct bool
+}
+
+const (
+	GenerateManifestSrcFlag  = "src"
+	GenerateManifestDestFlag = "dest"
+)
+
+// RunGenerateManifestCmd reads the source and destination registries, and
+// prints a promoter manifest that promotes all images (digests and tags) of
+// the source registry that the destination registry lacks.
+func RunGenerateManifestCmd(opts *GenerateManifestOptions) error {
+	if err := validateGenerateManifestOptions(opts); err != nil {
+		return errors.Wrap(err, "validating generate-manifest options")
+	}
+
+	srcRC := reg.RegistryContext{
+		Name:           reg.RegistryName(opts.Src),
+		ServiceAccount: opts.SrcSvcAcct,
+		Src:            true,
+	}
+	destRC := reg.RegistryContext{
+		Name:           reg.RegistryName(opts.Dest),
+		ServiceAccount: opts.DestSvcAcct,
+	}
+
+	sc, err := reg.MakeSyncContext(
+		[]reg.Manifest{{Registries: []reg.RegistryContext{srcRC, destRC}}},
+		opts.Threads,
+		true,
+		opts.UseServiceAcct,
+	)
+	if err != nil {
+		return &AuthError{errors.Wrap(err, "creating sync context")}
+	}
+
+	sc.ReadRegistries(
+		[]reg.RegistryContext{srcRC, destRC},
+		true,
+		reg.MkReadRepositoryCmdReal,
+	)
+	if len(sc.Logs.Errors) > 0 {
+		return errors.Errorf(
+			"could not read %d repositories",
+			len(sc.Logs.Errors))
+	}
+
+	src, dest := sc.Inv[srcRC.Name], sc.Inv[destRC.Name]
+	for _, conflict := range reg.FindTagConflicts(src, dest) {
+		logrus.Warnf(
+			"%s:%s points at %s in the source registry, but at %s in the destination registry; promoting it needs '--%s'",
+			conflict.ImageName,
+			conflict.Tag,
+			conflict.DigestA,
+			conflict.DigestB,
+			PromoterForceOverwriteFlag)
+	}
+
+	mfest, err := reg.GenerateManifestYAML(srcRC, destRC, src, dest)
+	if err != nil {
+		return errors.Wrap(err, "generating manifest")
+	}
+
+	if opts.OutputFile == "" {
+		fmt.Print(mfest)
+		return nil
+	}
+
+	return errors.Wrap(
+		ioutil.WriteFile(opts.OutputFile, []byte(mfest), 0o644),
+		"writing manifest")
+}
+
+func validateGenerateManifestOptions(o *GenerateManifestOptions) error {
+	for flag, registry := range map[string]string{
+		GenerateManifestSrcFlag:  o.Src,
+		GenerateManifestDestFlag: o.Dest,
+	} {
+		if registry == "" {
+			return errors.Errorf("'--%s' is required", flag)
+		}
+
+		if err := reg.ValidateRegistryImagePath(
+			reg.RegistryImagePath(registry),
+		); err != nil {
+			return errors.Wrapf(err, "invalid value for '--%s'", flag)
+		}
+	}
+
+	if o.Src == o.Dest {
+		return errors.Errorf(
+			"'--%s' and '--%s' must be different registries",
+			GenerateManifestSrcFlag,
+			GenerateManifestDestFlag)
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"github.com/pkg/errors"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+type ReleaseFromQuarantineOptions struct {
+	Manifest         string
+	ThinManifestDir  string
+	CleanLabel       string
+	KeyFiles         string
+	ApprovalEndpoint string
+	Threads          int
+	DryRun           bool
+	UseServiceAcct   bool
+	PrintEdges       bool
+}
+
+const (
+	ReleaseFromQuarantineDefaultCleanLabel = reg.DefaultQuarantineCleanLabel
+
+	ReleaseFromQuarantineCleanLabelFlag = "clean-label"
+)
+
+// RunReleaseFromQuarantineCmd promotes the images of the manifests from their
+// quarantine registries to their other destination registries, like 'cip run'
+// would from the source registries, but only those images that carry the clean
+// label (or annotation) with the value "true".
+func RunReleaseFromQuarantineCmd(opts *ReleaseFromQuarantineOptions) error {
+	if err := validateReleaseFromQuarantineOptions(opts); err != nil {
+		return errors.Wrap(err, "validating release-from-quarantine options")
+	}
+
+	runOpts := defaultRunOptions()
+	runOpts.Manifest = opts.Manifest
+	runOpts.ThinManifestDir = opts.ThinManifestDir
+	runOpts.KeyFiles = opts.KeyFiles
+	runOpts.ApprovalEndpoint = opts.ApprovalEndpoint
+	runOpts.Threads = opts.Threads
+	runOpts.DryRun = opts.DryRun
+	runOpts.UseServiceAcct = opts.UseServiceAcct
+	runOpts.PrintEdges = opts.PrintEdges
+	runOpts.quarantineCleanLabel = opts.CleanLabel
+
+	return RunPromoteCmd(runOpts)
+}
+
+func validateReleaseFromQuarantineOptions(
+	o *ReleaseFromQuarantineOptions,
+) error {
+	if (o.Manifest == "") == (o.ThinManifestDir == "") {
+		return errors.Errorf(
+			"exactly one of the '--%s' and '--%s' flags is required",
+			PromoterManifestFlag,
+			PromoterThinManifestDirFlag,
+		)
+	}
+
+	if o.CleanLabel == "" {
+		return errors.Errorf(
+			"'--%s' must not be empty",
+			ReleaseFromQuarantineCleanLabelFlag,
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+type RestoreOptions struct {
+	From           string
+	Src            string
+	Dest           string
+	SrcSvcAcct     string
+	DestSvcAcct    string
+	KeyFiles       string
+	Threads        int
+	DryRun         bool
+	UseServiceAcct bool
+	PrintEdges     bool
+}
+
+const (
+	RestoreFromFlag        = "from"
+	RestoreSrcFlag         = "src"
+	RestoreDestFlag        = "dest"
+	RestoreSrcSvcAcctFlag  = "src-service-account"
+	RestoreDestSvcAcctFlag = "dest-service-account"
+	RestorePrintEdgesFlag  = "print-edges"
+)
+
+// RunRestoreCmd promotes every image of a snapshot (as written by
+// 'cip run --snapshot=...', in the CSV or the default YAML format) from the
+// source registry to the destination registry, like 'cip run' would for a
+// manifest that lists them. This restores a registry from a snapshot of it,
+// e.g. from a backup registry.
+func RunRestoreCmd(opts *RestoreOptions) error {
+	if err := validateRestoreOptions(opts); err != nil {
+		return errors.Wrap(err, "validating restore options")
+	}
+
+	f, err := os.Open(opts.From)
+	if err != nil {
+		return &ParseError{errors.Wrap(err, "opening snapshot")}
+	}
+	defer f.Close()
+
+	parse := reg.ParseRegInvImageFromYAML
+	if filepath.Ext(opts.From) == ".csv" {
+		parse = reg.ParseRegInvImageFromCSV
+	}
+	rii, err := parse(f)
+	if err != nil {
+		return &ParseError{errors.Wrapf(err, "parsing snapshot %q", opts.From)}
+	}
+	stats := rii.Stats()
+	logrus.Infof(
+		"found %d image(s) with %d digest(s) and %d tag(s) in %s",
+		stats.Images,
+		stats.Digests,
+		stats.Tags,
+		opts.From)
+
+	mfest, err := reg.SnapshotToManifest(
+		rii,
+		reg.RegistryContext{
+			Name:           reg.RegistryName(opts.Src),
+			ServiceAccount: opts.SrcSvcAcct,
+		},
+		reg.RegistryContext{
+			Name:           reg.RegistryName(opts.Dest),
+			ServiceAccount: opts.DestSvcAcct,
+		},
+	)
+	if err != nil {
+		return &ParseError{errors.Wrap(err, "building promotion manifest")}
+	}
+
+	if opts.PrintEdges {
+		edges, err := reg.ToPromotionEdges([]reg.Manifest{mfest})
+		if err != nil {
+			return &ParseError{errors.Wrap(
+				err,
+				"converting manifest to edges for promotion",
+			)}
+		}
+
+		for _, edge := range reg.SortedPromotionEdges(edges) {
+			fmt.Println(formatPromotionEdge(edge))
+		}
+		return nil
+	}
+
+	runOpts := defaultRunOptions()
+	runOpts.KeyFiles = opts.KeyFiles
+	runOpts.Threads = opts.Threads
+	runOpts.DryRun = opts.DryRun
+	runOpts.UseServiceAcct = opts.UseServiceAcct
+	runOpts.manifests = []reg.Manifest{mfest}
+
+	return RunPromoteCmd(runOpts)
+}
+
+func validateRestoreOptions(o *RestoreOptions) error {
+	if o.From == "" {
+		return errors.Errorf("the '--%s' flag is required", RestoreFromFlag)
+	}
+
+	switch filepath.Ext(o.From) {
+	case ".csv", ".yaml", ".yml":
+	default:
+		return errors.Errorf(
+			"'--%s' must be a .csv, .yaml or .yml snapshot: %s",
+			RestoreFromFlag,
+			o.From,
+		)
+	}
+
+	if o.Src == "" || o.Dest == "" {
+		return errors.Errorf(
+			"the '--%s' and '--%s' flags are required",
+			RestoreSrcFlag,
+			RestoreDestFlag,
+		)
+	}
+
+	if o.Src == o.Dest {
+		return errors.Errorf(
+			"'--%s' and '--%s' must be different registries",
+			RestoreSrcFlag,
+			RestoreDestFlag,
+		)
+	}
+
+	return nil
+}
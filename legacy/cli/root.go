@@ -23,8 +23,10 @@ import (
 )
 
 type RootOptions struct {
-	LogLevel string
-	DryRun   bool
+	LogLevel      string
+	DryRun        bool
+	GitHubActions bool
+	Quiet         bool
 }
 
 func printVersion() {
@@ -17,8 +17,17 @@ limitations under the License.
 package cli
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -31,52 +40,322 @@ import (
 type RunOptions struct {
 	Manifest                string
 	ThinManifestDir         string
+	ThinManifestArchive     string
+	ThinManifestFilename    string
+	ManifestSignature       string
+	ManifestGPGKeyring      string
+	ChangedManifests        string
+	SinceRef                string
+	SnapshotFile            string
+	CosignKey               string
 	KeyFiles                string
 	Snapshot                string
+	SnapshotRegistry        string
 	SnapshotTag             string
+	SnapshotOnly            string
+	SnapshotDigests         string
+	SnapshotExcludeDigests  string
+	DigestAllowlist         string
+	CopyTool                string
+	PromotionLock           string
+	PublishEvents           string
+	ApprovalEndpoint        string
+	DigestOracleURL         string
+	CloudMonitoringProject  string
+	CloudMonitoringPrefix   string
+	ForceOverwrite          string
 	OutputFormat            string
+	OutputTemplate          string
 	SnapshotSvcAcct         string
 	ManifestBasedSnapshotOf string
+	ReadCheckpoint          string
+	VulnMode                string
+	ImageAgeMode            string
+	HTTPSProxy              string
+	NoProxy                 string
+	UserAgent               string
+	DestNamespace           string
+	DestPathPolicy          string
+	InventoryFrom           string
+	DryRunCommandsOut       string
+	ExpectedEdges           string
+	RegistryCredentials     string
+	CostTable               string
+	Changelog               string
+	PrintConfig             string
+	EnableChecks            []string
+	RegistryMirrors         map[string]string
+	ClientCerts             map[string]string
+	ClientKeys              map[string]string
+	CABundles               map[string]string
+	PromoteGroups           []string
+	SignaturePublicKeys     []string
+	AllowedRegistryHosts    []string
+	RetryStatusCodes        []int
+	Timeout                 time.Duration
+	BandwidthLimit          int64
+	PromotionLockTTL        time.Duration
+	BatchPause              time.Duration
+	MaxImageAge             time.Duration
+	VulnWaitTimeout         time.Duration
 	Threads                 int
+	VulnThreads             int
 	MaxImageSize            int
 	SeverityThreshold       int
+	MinSignatures           int
+	KeepLatestN             int
+	BatchSize               int
+	CopyRetries             int
+	TagRetries              int
 	DryRun                  bool
 	JSONLogSummary          bool
 	ParseOnly               bool
+	PrintEdges              bool
 	MinimalSnapshot         bool
+	StreamSnapshot          bool
+	Canonical               bool
 	UseServiceAcct          bool
+	SkipExistingQuietly     bool
+	FailOutsideWindow       bool
+	ProjectedInventory      bool
+	AllowSelfPromotion      bool
+	FailOnPublishError      bool
+	ExpandEnv               bool
+	ValidateSnapshot        bool
+	SignSnapshot            bool
+	SkipInvalidManifests    bool
+	StrictDigests           bool
+	Preflight               bool
+	GenerateProvenance      bool
+	SmokeVerify             bool
+	LogSkippedEdges         bool
+	// Quiet leaves out the START/FINISHED banners and the version (see
+	// '--quiet').
+	Quiet bool
+
+	// manifests, if set, are promoted instead of reading a manifest from a
+	// file (see 'cip from-k8s').
+	manifests []reg.Manifest
+	// quarantineCleanLabel, if set, releases the images that carry this label
+	// from the quarantine registries, instead of promoting from the source
+	// registries (see 'cip release-from-quarantine').
+	quarantineCleanLabel string
 }
 
 const (
-	PromoterDefaultThreads           = 10
-	PromoterDefaultOutputFormat      = "yaml"
-	PromoterDefaultMaxImageSize      = 2048
-	PromoterDefaultSeverityThreshold = -1
+	PromoterDefaultThreads               = 10
+	PromoterDefaultOutputFormat          = "yaml"
+	PromoterDefaultMaxImageSize          = 2048
+	PromoterDefaultSeverityThreshold     = -1
+	PromoterDefaultVulnMode              = PromoterVulnModeEnforce
+	PromoterDefaultImageAgeMode          = PromoterImageAgeModeEnforce
+	PromoterDefaultPreflight             = true
+	PromoterDefaultPromotionLockTTL      = reg.DefaultPromotionLockTTL
+	PromoterDefaultTagRetries            = reg.DefaultTagRetries
+	PromoterDefaultCloudMonitoringPrefix = reg.DefaultCloudMonitoringPrefix
+	PromoterDefaultThinManifestFilename  = reg.ThinManifestDefaultFilename
+
+	// PromoterOutputFormatTemplate renders inventories with the Go template
+	// given with '--output-template'.
+	PromoterOutputFormatTemplate = "template"
+	// PromoterOutputFormatJSON writes snapshots as JSON. It only works with
+	// '--canonical'.
+	PromoterOutputFormatJSON = "json"
+
+	// '--snapshot-only' values.
+	PromoterSnapshotOnlyManifestLists = "manifest-lists"
+	PromoterSnapshotOnlyImages        = "images"
+
+	// vulnerability check modes.
+	PromoterVulnModeEnforce = "enforce"
+	PromoterVulnModeWarn    = "warn"
+
+	// image age check modes.
+	PromoterImageAgeModeEnforce = "enforce"
+	PromoterImageAgeModeWarn    = "warn"
 
 	// flags.
 	PromoterManifestFlag                = "manifest"
 	PromoterThinManifestDirFlag         = "thin-manifest-dir"
+	PromoterThinManifestArchiveFlag     = "thin-manifest-archive"
+	PromoterThinManifestFilenameFlag    = "thin-manifest-filename"
+	PromoterChangedManifestsFlag        = "changed-manifests"
+	PromoterSinceRefFlag                = "since-ref"
 	PromoterSnapshotFlag                = "snapshot"
 	PromoterManifestBasedSnapshotOfFlag = "manifest-based-snapshot-of"
+	PromoterSnapshotRegistryFlag        = "snapshot-registry"
 	PromoterOutputFlag                  = "output"
+	PromoterOutputTemplateFlag          = "output-template"
+	PromoterReadCheckpointFlag          = "read-checkpoint"
+	PromoterHTTPSProxyFlag              = "https-proxy"
+	PromoterNoProxyFlag                 = "no-proxy"
+	PromoterRegistryMirrorFlag          = "registry-mirror"
+	PromoterEnableChecksFlag            = "enable-checks"
+	PromoterProjectedInventoryFlag      = "projected-inventory"
+	PromoterSnapshotDigestsFlag         = "snapshot-digests"
+	PromoterSnapshotOnlyFlag            = "snapshot-only"
+	PromoterSnapshotExcludeDigestsFlag  = "snapshot-exclude-digests"
+	PromoterCopyToolFlag                = "copy-tool"
+	PromoterAllowSelfPromotionFlag      = "allow-self-promotion"
+	PromoterPromoteGroupsFlag           = "promote-groups"
+	PromoterTimeoutFlag                 = "timeout"
+	PromoterPromotionLockFlag           = "promotion-lock"
+	PromoterPromotionLockTTLFlag        = "promotion-lock-ttl"
+	PromoterUserAgentFlag               = "user-agent"
+	PromoterMinSignaturesFlag           = "min-signatures"
+	PromoterSignaturePublicKeyFlag      = "signature-public-key"
+	PromoterPublishEventsFlag           = "publish-events"
+	PromoterApprovalEndpointFlag        = "approval-endpoint"
+	PromoterDigestOracleURLFlag         = "digest-oracle-url"
+	PromoterFailOnPublishErrorFlag      = "fail-on-publish-error"
+	PromoterGenerateProvenanceFlag      = "generate-provenance"
+	PromoterSmokeVerifyFlag             = "smoke-verify"
+	PromoterLogSkippedEdgesFlag         = "log-skipped-edges"
+	PromoterExpandEnvFlag               = "expand-env"
+	PromoterManifestSignatureFlag       = "manifest-signature"
+	PromoterManifestGPGKeyringFlag      = "manifest-gpg-keyring"
+	PromoterValidateSnapshotFlag        = "validate-snapshot"
+	PromoterStreamSnapshotFlag          = "stream-snapshot"
+	PromoterCanonicalFlag               = "canonical"
+	PromoterKeepLatestNFlag             = "keep-latest-n"
+	PromoterRetryStatusCodesFlag        = "retry-status-codes"
+	PromoterCopyRetriesFlag             = "copy-retries"
+	PromoterTagRetriesFlag              = "tag-retries"
+	PromoterVulnThreadsFlag             = "vuln-threads"
+	PromoterVulnWaitTimeoutFlag         = "vuln-wait-timeout"
+	PromoterSnapshotFileFlag            = "snapshot-file"
+	PromoterSignSnapshotFlag            = "sign-snapshot"
+	PromoterCosignKeyFlag               = "cosign-key"
+	PromoterSkipInvalidManifestsFlag    = "skip-invalid-manifests"
+	PromoterBandwidthLimitFlag          = "bandwidth-limit"
+	PromoterStrictDigestsFlag           = "strict-digests"
+	PromoterCloudMonitoringProjectFlag  = "cloud-monitoring-project"
+	PromoterCloudMonitoringPrefixFlag   = "cloud-monitoring-prefix"
+	PromoterForceOverwriteFlag          = "force-overwrite"
+	PromoterClientCertFlag              = "client-cert"
+	PromoterClientKeyFlag               = "client-key"
+	PromoterCABundleFlag                = "ca-bundle"
+	PromoterParseOnlyFlag               = "parse-only"
+	PromoterPrintEdgesFlag              = "print-edges"
+	PromoterMaxImageAgeFlag             = "max-image-age"
+	PromoterImageAgeModeFlag            = "image-age-mode"
+	PromoterDestNamespaceFlag           = "dest-namespace"
+	PromoterDestPathPolicyFlag          = "dest-path-policy"
+	PromoterFailOutsideWindowFlag       = "fail-outside-window"
+	PromoterBatchSizeFlag               = "batch-size"
+	PromoterBatchPauseFlag              = "batch-pause"
+	PromoterInventoryFromFlag           = "inventory-from"
+	PromoterDryRunCommandsOutFlag       = "dry-run-commands-out"
+	PromoterExpectedEdgesFlag           = "expected-edges"
+	PromoterRegistryCredentialsFlag     = "registry-credentials"
+	PromoterCostTableFlag               = "cost-table"
+	PromoterChangelogFlag               = "changelog"
+	PromoterPreflightFlag               = "preflight"
+	PromoterAllowedRegistryHostsFlag    = "allowed-registry-hosts"
+	PromoterDigestAllowlistFlag         = "digest-allowlist"
 )
 
 var PromoterAllowedOutputFormats = []string{
 	"csv",
 	"yaml",
+	"table",
+	PromoterOutputFormatTemplate,
+}
+
+// errEdgeFiltering is returned (as a PromotionError) if the manifests disagree
+// with the state of the registries, e.g. because a promotion would move a tag.
+var errEdgeFiltering = errors.New("encountered errors during edge filtering")
+
+// PromoterDefaultRetryStatusCodes are the HTTP status codes of failed registry
+// reads that are retried by default.
+var PromoterDefaultRetryStatusCodes = stream.DefaultRetryStatusCodes
+
+var PromoterAllowedVulnModes = []string{
+	PromoterVulnModeEnforce,
+	PromoterVulnModeWarn,
+}
+
+var PromoterAllowedImageAgeModes = []string{
+	PromoterImageAgeModeEnforce,
+	PromoterImageAgeModeWarn,
+}
+
+// PromoterVulnCheck is the name of the vulnerability precheck.
+const PromoterVulnCheck = reg.PreCheckVuln
+
+// PromoterSignatureCheck is the name of the signature precheck.
+const PromoterSignatureCheck = reg.PreCheckSignatures
+
+// PromoterImageAgeCheck is the name of the image age precheck.
+const PromoterImageAgeCheck = reg.PreCheckImageAge
+
+// PromoterDigestAllowlistCheck is the name of the digest allowlist precheck.
+const PromoterDigestAllowlistCheck = reg.PreCheckDigestAllowlist
+
+// PromoterDefaultImageGroup is the group of images that do not name a group.
+const PromoterDefaultImageGroup = reg.DefaultImageGroup
+
+// PromoterAvailableChecks returns the names of all prechecks that can be
+// enabled.
+func PromoterAvailableChecks() []string {
+	return reg.RegisteredPreChecks()
+}
+
+// PromoterAllowedCopyTools returns the names of the tools that can be used to
+// copy images.
+func PromoterAllowedCopyTools() []string {
+	return reg.CopyTools()
+}
+
+// defaultRunOptions returns the options of 'cip run' with all flags at their
+// defaults. Subcommands that promote through RunPromoteCmd start from these
+// and override what they need, so that they get the same defaults as 'cip run'.
+func defaultRunOptions() *RunOptions {
+	return &RunOptions{
+		ThinManifestFilename:  PromoterDefaultThinManifestFilename,
+		OutputFormat:          PromoterDefaultOutputFormat,
+		VulnMode:              PromoterDefaultVulnMode,
+		ImageAgeMode:          PromoterDefaultImageAgeMode,
+		CloudMonitoringPrefix: PromoterDefaultCloudMonitoringPrefix,
+		RetryStatusCodes:      PromoterDefaultRetryStatusCodes,
+		PromotionLockTTL:      PromoterDefaultPromotionLockTTL,
+		Threads:               PromoterDefaultThreads,
+		MaxImageSize:          PromoterDefaultMaxImageSize,
+		SeverityThreshold:     PromoterDefaultSeverityThreshold,
+		TagRetries:            PromoterDefaultTagRetries,
+		Preflight:             PromoterDefaultPreflight,
+	}
 }
 
 // TODO: Function 'runPromoteCmd' has too many statements (97 > 40) (funlen)
 // nolint: funlen,gocognit,gocyclo
 func RunPromoteCmd(opts *RunOptions) error {
+	if opts.SnapshotRegistry != "" {
+		snapshotOpts, err := opts.snapshotRegistryOptions()
+		if err != nil {
+			return err
+		}
+
+		return RunPromoteCmd(snapshotOpts)
+	}
+
 	if err := validateImageOptions(opts); err != nil {
 		return errors.Wrap(err, "validating image options")
 	}
 
+	// Bound the whole run, so that we can stop cleanly (and report what was
+	// done) instead of being killed from the outside.
+	ctx := context.Background()
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
 	// Activate service accounts.
 	if opts.UseServiceAcct && opts.KeyFiles != "" {
 		if err := gcloud.ActivateServiceAccounts(opts.KeyFiles); err != nil {
-			return errors.Wrap(err, "activating service accounts")
+			return &AuthError{errors.Wrap(err, "activating service accounts")}
 		}
 	}
 
@@ -116,11 +395,15 @@ func RunPromoteCmd(opts *RunOptions) error {
 			},
 		}
 		// TODO: Move this into the validation function
-	} else if opts.Manifest == "" && opts.ThinManifestDir == "" {
+	} else if opts.Manifest == "" &&
+		opts.manifests == nil &&
+		opts.ThinManifestDir == "" &&
+		opts.ThinManifestArchive == "" {
 		logrus.Fatalf(
-			"either %s or %s flag is required",
+			"one of the %s, %s or %s flags is required",
 			PromoterManifestFlag,
 			PromoterThinManifestDirFlag,
+			PromoterThinManifestArchiveFlag,
 		)
 	}
 
@@ -128,46 +411,117 @@ func RunPromoteCmd(opts *RunOptions) error {
 
 	// TODO: is deeply nested (complexity: 5) (nestif)
 	// nolint: nestif
-	if opts.Manifest != "" {
-		mfest, err = reg.ParseManifestFromFile(opts.Manifest)
-		if err != nil {
-			logrus.Fatal(err)
+	if opts.Manifest != "" || opts.manifests != nil {
+		if opts.manifests != nil {
+			mfests = append(mfests, opts.manifests...)
+		} else {
+			mfest, err = opts.parseManifest()
+			if err != nil {
+				return &ParseError{errors.Wrap(err, "parsing manifest")}
+			}
+
+			mfests = append(mfests, mfest)
 		}
 
-		mfests = append(mfests, mfest)
-		for _, registry := range mfest.Registries {
-			mi[registry.Name] = nil
+		for _, mfest := range mfests {
+			for _, registry := range mfest.Registries {
+				mi[registry.Name] = nil
+			}
 		}
 
-		sc, err = reg.MakeSyncContext(
-			mfests,
-			opts.Threads,
-			opts.DryRun,
-			opts.UseServiceAcct,
-		)
+		mfests, err = opts.applyRegistryCredentials(mfests)
+		if err != nil {
+			return &ParseError{err}
+		}
+
+		sc, err = opts.makeSyncContext(mfests)
 		if err != nil {
-			logrus.Fatal(err)
+			return err
 		}
 
 		doingPromotion = true
-	} else if opts.ThinManifestDir != "" {
-		mfests, err = reg.ParseThinManifestsFromDir(opts.ThinManifestDir)
+	} else if opts.ThinManifestDir != "" || opts.ThinManifestArchive != "" {
+		if opts.ThinManifestArchive != "" {
+			mfests, err = reg.ParseThinManifestsFromArchive(
+				opts.ThinManifestArchive,
+				opts.thinManifestOptions(),
+			)
+			err = allowSkippedManifests(mfests, err)
+			if err != nil {
+				return &ParseError{
+					errors.Wrap(err, "parsing thin manifest archive"),
+				}
+			}
+		} else if opts.ChangedManifests != "" {
+			changed, err := readChangedManifests(opts.ChangedManifests)
+			if err != nil {
+				return &ParseError{err}
+			}
+
+			mfests, err = reg.ParseChangedThinManifestsFromDir(
+				opts.ThinManifestDir,
+				changed,
+				opts.thinManifestOptions(),
+			)
+			err = allowSkippedManifests(mfests, err)
+			if err != nil {
+				return &ParseError{
+					errors.Wrap(err, "parsing changed thin manifests"),
+				}
+			}
+			logrus.Infof(
+				"%d thin manifest(s) affected by the changed files in %q",
+				len(mfests),
+				opts.ChangedManifests,
+			)
+		} else if opts.SinceRef != "" {
+			mfests, err = reg.ParseThinManifestsFromDirSinceRef(
+				opts.ThinManifestDir,
+				opts.SinceRef,
+				reg.MkGitDiffCmdReal,
+				opts.thinManifestOptions(),
+			)
+			err = allowSkippedManifests(mfests, err)
+			if err != nil {
+				return &ParseError{
+					errors.Wrap(err, "parsing thin manifests changed since ref"),
+				}
+			}
+			logrus.Infof(
+				"%d thin manifest(s) affected by the changes since %q",
+				len(mfests),
+				opts.SinceRef,
+			)
+		} else {
+			mfests, err = reg.ParseThinManifestsFromDirWithOptions(
+				opts.ThinManifestDir,
+				opts.thinManifestOptions(),
+			)
+			err = allowSkippedManifests(mfests, err)
+			if err != nil {
+				return &ParseError{
+					errors.Wrap(err, "parsing thin manifest directory"),
+				}
+			}
+		}
+
+		mfests, err = opts.applyRegistryCredentials(mfests)
 		if err != nil {
-			return errors.Wrap(err, "parsing thin manifest directory")
+			return &ParseError{err}
 		}
 
-		sc, err = reg.MakeSyncContext(
-			mfests,
-			opts.Threads,
-			opts.DryRun,
-			opts.UseServiceAcct)
+		sc, err = opts.makeSyncContext(mfests)
 		if err != nil {
-			logrus.Fatal(err)
+			return err
 		}
 
 		doingPromotion = true
 	}
 
+	if err := opts.configureSyncContext(ctx, &sc); err != nil {
+		return err
+	}
+
 	if opts.ParseOnly {
 		return nil
 	}
@@ -178,12 +532,12 @@ func RunPromoteCmd(opts *RunOptions) error {
 	// TODO: is deeply nested (complexity: 6) (nestif)
 	// nolint: nestif
 	if doingPromotion && opts.ManifestBasedSnapshotOf == "" {
-		promotionEdges, err = reg.ToPromotionEdges(mfests)
+		promotionEdges, err = opts.toPromotionEdges(mfests)
 		if err != nil {
-			return errors.Wrap(
+			return &ParseError{errors.Wrap(
 				err,
 				"converting list of manifests to edges for promotion",
-			)
+			)}
 		}
 
 		imagesInManifests := false
@@ -195,40 +549,42 @@ func RunPromoteCmd(opts *RunOptions) error {
 		}
 		if !imagesInManifests {
 			logrus.Info("No images in manifest(s) --- nothing to do.")
+			if opts.PrintEdges {
+				return printPromotionEdges(&sc, nil)
+			}
 			return nil
 		}
 
-		// Print version to make Prow logs more self-explanatory.
-		printVersion()
+		if len(promotionEdges) == 0 && len(opts.PromoteGroups) > 0 {
+			logrus.Infof(
+				"No images in group(s) %q --- nothing to do.",
+				opts.PromoteGroups)
+			if opts.PrintEdges {
+				return printPromotionEdges(&sc, nil)
+			}
+			return nil
+		}
 
-		// nolint: gocritic
-		if opts.SeverityThreshold >= 0 {
-			logrus.Info("********** START (VULN CHECK) **********")
-			logrus.Info(
-				`DISCLAIMER: Vulnerabilities are found as issues with package
-binaries within image layers, not necessarily with the image layers themselves.
-So a 'fixable' vulnerability may not necessarily be immediately actionable. For
-example, even though a fixed version of the binary is available, it doesn't
-necessarily mean that a new version of the image layer is available.`,
-			)
-		} else if opts.DryRun {
-			logrus.Info("********** START (DRY RUN) **********")
-		} else {
-			logrus.Info("********** START **********")
+		if !opts.Quiet {
+			logStartBanner(opts)
 		}
 	}
 
 	// TODO: is deeply nested (complexity: 12) (nestif)
 	// nolint: nestif
 	if len(opts.Snapshot) > 0 || len(opts.ManifestBasedSnapshotOf) > 0 {
+		if opts.StreamSnapshot {
+			return opts.streamManifestBasedSnapshot(mfests)
+		}
+
 		rii := make(reg.RegInvImage)
 		if len(opts.ManifestBasedSnapshotOf) > 0 {
-			promotionEdges, err = reg.ToPromotionEdges(mfests)
+			promotionEdges, err = opts.toPromotionEdges(mfests)
 			if err != nil {
-				return errors.Wrap(
+				return &ParseError{errors.Wrap(
 					err,
 					"converting list of manifests to edges for promotion",
-				)
+				)}
 			}
 
 			rii = reg.EdgesToRegInvImage(
@@ -236,25 +592,59 @@ necessarily mean that a new version of the image layer is available.`,
 				opts.ManifestBasedSnapshotOf,
 			)
 
-			if opts.MinimalSnapshot {
+			// Manifests do not record upload times, so only semantic
+			// versions (and tag names) decide which tags are the latest.
+			if opts.KeepLatestN > 0 {
+				rii = reg.FilterLatestTags(rii, opts.KeepLatestN, nil)
+			}
+
+			// The manifest lists are only known from the registry.
+			if opts.MinimalSnapshot || opts.SnapshotOnly != "" {
 				sc.ReadRegistries(
 					[]reg.RegistryContext{*srcRegistry},
 					true,
-					reg.MkReadRepositoryCmdReal,
+					sc.MkReadRepositoryCmd,
 				)
 
-				sc.ReadGCRManifestLists(reg.MkReadManifestListCmdReal)
+				// Without them, manifest lists would be taken for images.
+				if len(sc.Logs.Errors) > 0 {
+					return errors.Errorf(
+						"could not read %d repositories of %q",
+						len(sc.Logs.Errors),
+						opts.ManifestBasedSnapshotOf,
+					)
+				}
+			}
+
+			if opts.MinimalSnapshot {
+				if err := sc.ReadGCRManifestLists(
+					reg.MkReadManifestListCmdReal,
+				); err != nil {
+					return errors.Wrap(err, "reading manifest lists")
+				}
 				rii = sc.RemoveChildDigestEntries(rii)
 			}
 		} else {
-			sc, err = reg.MakeSyncContext(
-				mfests,
-				opts.Threads,
-				opts.DryRun,
-				opts.UseServiceAcct,
-			)
+			sc, err = opts.makeSyncContext(mfests)
 			if err != nil {
-				logrus.Fatal(err)
+				return err
+			}
+			if err := opts.configureSyncContext(ctx, &sc); err != nil {
+				return err
+			}
+
+			var checkpoint *reg.ReadCheckpoint
+			if opts.ReadCheckpoint != "" {
+				checkpoint, err = reg.LoadReadCheckpoint(opts.ReadCheckpoint)
+				if err != nil {
+					return errors.Wrap(err, "loading read checkpoint")
+				}
+				logrus.Infof(
+					"resuming from read checkpoint %q (%d repositories already read)",
+					opts.ReadCheckpoint,
+					len(checkpoint.Repos),
+				)
+				sc.UseReadCheckpoint(checkpoint)
 			}
 
 			sc.ReadRegistries(
@@ -262,119 +652,2128 @@ necessarily mean that a new version of the image layer is available.`,
 				// Read all registries recursively, because we want to produce a
 				// complete snapshot.
 				true,
-				reg.MkReadRepositoryCmdReal,
+				sc.MkReadRepositoryCmd,
 			)
 
+			if err := ctx.Err(); err != nil {
+				return &TimeoutError{errors.Wrap(err, "reading registries")}
+			}
+
+			if checkpoint != nil {
+				// Do not print an incomplete snapshot; the repositories that
+				// could not be read will be retried when resuming.
+				if len(sc.Logs.Errors) > 0 {
+					return errors.Errorf(
+						"could not read %d repositories; progress was saved to %q, re-run with the same '--%s' to resume",
+						len(sc.Logs.Errors),
+						opts.ReadCheckpoint,
+						PromoterReadCheckpointFlag,
+					)
+				}
+
+				if err := checkpoint.Remove(); err != nil {
+					return errors.Wrap(err, "removing read checkpoint")
+				}
+			}
+
 			rii = sc.Inv[mfests[0].Registries[0].Name]
 			if opts.SnapshotTag != "" {
 				rii = reg.FilterByTag(rii, opts.SnapshotTag)
 			}
 
+			if opts.SnapshotDigests != "" {
+				digests, err := parseDigestList(opts.SnapshotDigests)
+				if err != nil {
+					return errors.Wrapf(
+						err,
+						"parsing '--%s'",
+						PromoterSnapshotDigestsFlag,
+					)
+				}
+				rii = reg.FilterByDigest(rii, digests)
+			}
+
+			if opts.KeepLatestN > 0 {
+				rii = reg.FilterLatestTags(
+					rii,
+					opts.KeepLatestN,
+					sc.DigestUploadTime,
+				)
+			}
+
 			if opts.MinimalSnapshot {
 				logrus.Info("removing tagless child digests of manifest lists")
-				sc.ReadGCRManifestLists(reg.MkReadManifestListCmdReal)
+				if err := sc.ReadGCRManifestLists(
+					reg.MkReadManifestListCmdReal,
+				); err != nil {
+					return errors.Wrap(err, "reading manifest lists")
+				}
 				rii = sc.RemoveChildDigestEntries(rii)
 			}
 		}
 
-		var snapshot string
-		switch strings.ToLower(opts.OutputFormat) {
-		case "csv":
-			snapshot = rii.ToCSV()
-		case "yaml":
-			snapshot = rii.ToYAML(reg.YamlMarshalingOpts{})
-		default:
-			logrus.Errorf(
-				"invalid value %s for '--%s'; defaulting to %s",
-				opts.OutputFormat,
-				PromoterOutputFlag,
-				PromoterDefaultOutputFormat,
+		if opts.SnapshotOnly != "" {
+			rii = sc.FilterManifestLists(
+				rii,
+				opts.SnapshotOnly == PromoterSnapshotOnlyManifestLists,
 			)
+		}
 
-			snapshot = rii.ToYAML(reg.YamlMarshalingOpts{})
+		if opts.SnapshotExcludeDigests != "" {
+			digests, err := parseDigestList("@" + opts.SnapshotExcludeDigests)
+			if err != nil {
+				return errors.Wrapf(
+					err,
+					"parsing '--%s'",
+					PromoterSnapshotExcludeDigestsFlag,
+				)
+			}
+			rii = reg.ExcludeDigests(rii, digests)
 		}
 
-		fmt.Print(snapshot)
-		return nil
+		if opts.ValidateSnapshot {
+			if err := rii.Validate(); err != nil {
+				return errors.Wrap(err, "validating snapshot")
+			}
+		}
+
+		tmpl, err := loadOutputTemplate(opts.OutputTemplate)
+		if err != nil {
+			return err
+		}
+
+		var snapshot string
+		if opts.Canonical {
+			snapshot, err = formatCanonicalInventory(rii, opts.OutputFormat)
+		} else {
+			snapshot, err = formatInventory(rii, opts.OutputFormat, tmpl)
+		}
+		if err != nil {
+			return errors.Wrap(err, "formatting snapshot")
+		}
+
+		if opts.SnapshotFile == "" {
+			fmt.Print(snapshot)
+			return nil
+		}
+
+		if err := ioutil.WriteFile(
+			opts.SnapshotFile,
+			[]byte(snapshot),
+			0o644,
+		); err != nil {
+			return errors.Wrap(err, "writing snapshot")
+		}
+
+		return opts.signSnapshot()
 	}
 
 	if opts.JSONLogSummary {
 		defer sc.LogJSONSummary()
 	}
 
+	if err := opts.promoteEdges(ctx, &sc, promotionEdges); err != nil {
+		return err
+	}
+
+	// Printing the edges is all that '--print-edges' does.
+	if !opts.Quiet && !opts.PrintEdges {
+		logFinishBanner(opts)
+	}
+
+	return nil
+}
+
+// configureSyncContext sets up sc (made by makeSyncContext()) with all options
+// that affect how the registries are read and written.
+// nolint: gocyclo
+func (o *RunOptions) configureSyncContext(
+	ctx context.Context,
+	sc *reg.SyncContext,
+) error {
+	var err error
+
+	sc.Proxy = o.proxy()
+	sc.RegistryMirrors = o.RegistryMirrors
+	sc.RegistryTLS, err = o.registryTLS()
+	if err != nil {
+		return err
+	}
+	sc.UserAgent = o.UserAgent
+	sc.BandwidthLimiter = o.bandwidthLimiter()
+	sc.RetryPolicy = o.retryPolicy()
+	sc.WriteRetryPolicy = reg.WriteRetryPolicy{
+		CopyRetries: o.CopyRetries,
+		TagRetries:  o.TagRetries,
+	}
+	sc.Context = ctx
+	sc.MkReadRepositoryCmd, err = o.mkReadRepositoryCmd()
+	if err != nil {
+		return err
+	}
+	sc.BatchSize = o.BatchSize
+	sc.BatchPause = o.BatchPause
+	sc.SkipExistingQuietly = o.SkipExistingQuietly
+	sc.LogSkippedEdges = o.LogSkippedEdges
+	if o.ForceOverwrite != "" {
+		sc.ForceOverwrite, err = parseForceOverwrite(o.ForceOverwrite)
+		if err != nil {
+			return errors.Wrapf(err, "parsing '--%s'", PromoterForceOverwriteFlag)
+		}
+	}
+	if o.DestPathPolicy != "" {
+		sc.DestPathPolicy, err = reg.MkDestPathPolicy(o.DestPathPolicy)
+		if err != nil {
+			return errors.Wrapf(err, "parsing '--%s'", PromoterDestPathPolicyFlag)
+		}
+	}
+	sc.FailOutsideWindow = o.FailOutsideWindow
+	if o.CopyTool != "" {
+		sc.CopyTool, err = reg.MkCopyTool(o.CopyTool)
+		if err != nil {
+			return errors.Wrapf(err, "parsing '--%s'", PromoterCopyToolFlag)
+		}
+		if tool, ok := sc.CopyTool.(reg.SkopeoCopyTool); ok {
+			tool.CertDirs, err = reg.SkopeoCertDirs(sc.RegistryTLS)
+			if err != nil {
+				return errors.Wrapf(
+					err,
+					"using the TLS settings with '--%s=%s'",
+					PromoterCopyToolFlag,
+					o.CopyTool)
+			}
+			sc.CopyTool = tool
+		}
+	}
+	if o.PromotionLock != "" {
+		sc.PromotionLock, err = o.promotionLock(ctx)
+		if err != nil {
+			return &AuthError{err}
+		}
+	}
+	// Nothing is promoted in a dry run, so there is nothing to publish.
+	if o.PublishEvents != "" && !o.DryRun {
+		sc.EventPublisher, err = reg.MkPubSubEventPublisher(
+			ctx,
+			o.PublishEvents)
+		if err != nil {
+			return &AuthError{
+				errors.Wrapf(err, "parsing '--%s'", PromoterPublishEventsFlag),
+			}
+		}
+		sc.FailOnPublishError = o.FailOnPublishError
+	}
+	if o.GenerateProvenance && !o.DryRun {
+		sc.ProvenanceGenerator = sc.MkOCIProvenanceGenerator(
+			reg.ProvenanceDefaultBuilderID)
+	}
+	sc.SmokeVerify = o.SmokeVerify
+	if o.CloudMonitoringProject != "" && !o.DryRun {
+		sc.MetricsRecorder, err = o.cloudMonitoringRecorder(ctx)
+		if err != nil {
+			return &AuthError{err}
+		}
+	}
+
+	return nil
+}
+
+// promoteEdges checks and promotes the promotion edges (as read from the
+// manifests) with sc (see configureSyncContext()). Both 'cip run' and 'cip
+// watch' promote through here, so that they promote with the same checks.
+// TODO: Function 'promoteEdges' has too many statements (funlen)
+// nolint: funlen,gocognit,gocyclo
+func (o *RunOptions) promoteEdges(
+	ctx context.Context,
+	sc *reg.SyncContext,
+	promotionEdges map[reg.PromotionEdge]interface{},
+) error {
+	var err error
+
 	// Check the pull request
-	if opts.DryRun {
+	if o.DryRun {
 		err = sc.RunChecks([]reg.PreCheck{})
 		if err != nil {
 			return errors.Wrap(err, "running prechecks before promotion")
 		}
 	}
 
-	// Promote.
-	mkProducer := func(
-		srcRegistry reg.RegistryName,
-		srcImageName reg.ImageName,
-		destRC reg.RegistryContext,
-		imageName reg.ImageName,
-		digest reg.Digest, tag reg.Tag, tp reg.TagOp,
-	) stream.Producer {
-		var sp stream.Subprocess
-		sp.CmdInvocation = reg.GetWriteCmd(
-			destRC,
-			sc.UseServiceAccount,
-			srcRegistry,
-			srcImageName,
-			imageName,
-			digest,
-			tag,
-			tp,
-		)
+	// Fail early if a destination registry cannot be reached at all, rather
+	// than deep into the promotion. There is nothing to reach with
+	// '--inventory-from'.
+	if o.Preflight && o.InventoryFrom == "" {
+		if err := sc.CheckDestinationsReachable(
+			promotionEdges,
+			reg.MkPingCmdReal,
+		); err != nil {
+			return &PromotionError{errors.Wrap(err, "preflight check")}
+		}
+	}
 
-		return &sp
+	// Promote.
+	mkProducer := mkPromotionProducer(sc)
+	if o.DryRunCommandsOut != "" {
+		sc.CommandRecorder = &reg.CommandRecorder{}
 	}
 
 	promotionEdges, ok := sc.FilterPromotionEdges(promotionEdges, true)
+	if err := ctx.Err(); err != nil {
+		return &TimeoutError{errors.Wrap(err, "filtering promotion edges")}
+	}
+
 	// If any funny business was detected during a comparison of the manifests
 	// with the state of the registries, then exit immediately.
 	if !ok {
-		return errors.New("encountered errors during edge filtering")
+		return &PromotionError{errEdgeFiltering}
 	}
 
-	if opts.SeverityThreshold >= 0 {
-		err = sc.RunChecks(
-			[]reg.PreCheck{
-				reg.MKImageVulnCheck(
-					sc,
-					promotionEdges,
-					opts.SeverityThreshold,
-					nil,
-				),
-			},
-		)
+	if o.quarantineCleanLabel != "" {
+		promotionEdges, err = sc.FilterCleanEdges(
+			promotionEdges,
+			o.quarantineCleanLabel,
+			nil)
 		if err != nil {
-			return errors.Wrap(err, "checking image vulnerabilities")
+			return &PromotionError{
+				errors.Wrap(err, "checking images in quarantine"),
+			}
 		}
-	} else {
-		err = sc.Promote(promotionEdges, mkProducer, nil)
+	}
+
+	if o.DigestOracleURL != "" {
+		oracle, err := o.digestOracle()
 		if err != nil {
-			return errors.Wrap(err, "promoting images")
+			return err
+		}
+
+		if err := sc.CheckDigestOracle(promotionEdges, oracle); err != nil {
+			return &PromotionError{
+				errors.Wrap(err, "checking digests against the digest oracle"),
+			}
 		}
+		logrus.Infof(
+			"promotion edges match '--%s'",
+			PromoterDigestOracleURLFlag)
 	}
 
-	// nolint: gocritic
-	if opts.SeverityThreshold >= 0 {
-		logrus.Info("********** FINISHED (VULN CHECK) **********")
-	} else if opts.DryRun {
-		logrus.Info("********** FINISHED (DRY RUN) **********")
-	} else {
-		logrus.Info("********** FINISHED **********")
+	if o.ExpectedEdges != "" {
+		if err := checkExpectedEdges(
+			sc,
+			promotionEdges,
+			o.ExpectedEdges,
+		); err != nil {
+			return err
+		}
+		logrus.Infof(
+			"promotion edges match '--%s'",
+			PromoterExpectedEdgesFlag)
 	}
 
-	return nil
-}
+	if o.PrintEdges {
+		return printPromotionEdges(sc, promotionEdges)
+	}
+
+	if o.ProjectedInventory {
+		tmpl, err := loadOutputTemplate(o.OutputTemplate)
+		if err != nil {
+			return err
+		}
+
+		if err := printProjectedInventories(
+			sc,
+			promotionEdges,
+			o.OutputFormat,
+			tmpl,
+		); err != nil {
+			return errors.Wrap(err, "printing projected inventories")
+		}
+	}
+
+	if o.CostTable != "" {
+		table, err := reg.ParseCostTableFromFile(o.CostTable)
+		if err != nil {
+			return errors.Wrapf(err, "reading '--%s'", PromoterCostTableFlag)
+		}
+
+		logrus.Info("estimated cost of the promotion:")
+		fmt.Print(reg.EstimateCost(
+			promotionEdges,
+			sc.Inv,
+			sc.DigestImageSize,
+			table,
+		).ToTable())
+	}
+
+	if checkNames := o.enabledChecks(promotionEdges); len(checkNames) > 0 {
+		publicKeys, err := reg.LoadPublicKeys(o.SignaturePublicKeys)
+		if err != nil {
+			return errors.Wrapf(
+				err,
+				"reading '--%s'",
+				PromoterSignaturePublicKeyFlag)
+		}
+
+		var digestAllowlist []reg.Digest
+		if o.DigestAllowlist != "" {
+			digestAllowlist, err = parseDigestList("@" + o.DigestAllowlist)
+			if err != nil {
+				return errors.Wrapf(
+					err,
+					"reading '--%s'",
+					PromoterDigestAllowlistFlag)
+			}
+		}
+
+		preChecks, err := reg.MkPreChecks(
+			checkNames,
+			sc,
+			promotionEdges,
+			reg.PreCheckOptions{
+				MaxImageSize:        o.MaxImageSize,
+				SeverityThreshold:   o.SeverityThreshold,
+				VulnMode:            o.vulnMode(),
+				VulnThreads:         o.VulnThreads,
+				VulnWaitTimeout:     o.VulnWaitTimeout,
+				MinSignatures:       o.MinSignatures,
+				SignaturePublicKeys: publicKeys,
+				MaxImageAge:         o.MaxImageAge,
+				ImageAgeMode:        o.imageAgeMode(),
+				DigestAllowlist:     digestAllowlist,
+			},
+		)
+		if err != nil {
+			return errors.Wrap(err, "creating prechecks")
+		}
+
+		// Run the vulnerability check separately from the other prechecks, so
+		// that its failures can be told apart.
+		var vulnChecks, otherChecks []reg.PreCheck
+		for _, preCheck := range preChecks {
+			if _, ok := preCheck.(*reg.ImageVulnCheck); ok {
+				vulnChecks = append(vulnChecks, preCheck)
+			} else {
+				otherChecks = append(otherChecks, preCheck)
+			}
+		}
+
+		errOther := sc.RunChecks(otherChecks)
+		errVuln := sc.RunChecks(vulnChecks)
+		if errOther != nil {
+			return errors.Wrap(errOther, "running prechecks")
+		}
+		if errVuln != nil {
+			return &VulnCheckError{
+				errors.Wrap(errVuln, "running vulnerability check"),
+			}
+		}
+
+		// In warn mode, the vulnerability and image age checks pass
+		// regardless of what they find, so record the findings in the summary
+		// instead.
+		for _, preCheck := range preChecks {
+			switch check := preCheck.(type) {
+			case *reg.ImageVulnCheck:
+				sc.Logs.Warnings = append(sc.Logs.Warnings, check.Findings...)
+			case *reg.ImageAgeCheck:
+				if check.Mode == reg.ImageAgeModeWarn {
+					sc.Logs.Warnings = append(
+						sc.Logs.Warnings,
+						check.Findings...,
+					)
+				}
+			}
+		}
+	}
+
+	// Nothing is promoted in a dry run, so there is nothing to approve.
+	if !o.vulnCheckOnly() && !o.DryRun {
+		approvalClient, err := o.approvalClient()
+		if err != nil {
+			return err
+		}
+
+		promotionEdges, err = sc.FilterApprovedEdges(
+			promotionEdges,
+			approvalClient)
+		if err != nil {
+			return &PromotionError{errors.Wrap(err, "requesting approvals")}
+		}
+	}
+
+	if !o.vulnCheckOnly() {
+		err = sc.Promote(promotionEdges, mkProducer, nil)
+		if err != nil {
+			return promotionFailure(ctx, err, sc.Logs.Promotions, o.Quiet)
+		}
+
+		if sc.CommandRecorder != nil {
+			if err := sc.CommandRecorder.WriteScript(
+				o.DryRunCommandsOut,
+			); err != nil {
+				return errors.Wrapf(
+					err,
+					"writing '--%s'",
+					PromoterDryRunCommandsOutFlag,
+				)
+			}
+		}
+
+		if o.Changelog != "" {
+			if err := writeChangelog(
+				o.Changelog,
+				reg.MkChangelog(sc.Inv, promotionEdges),
+			); err != nil {
+				return errors.Wrapf(err, "writing '--%s'", PromoterChangelogFlag)
+			}
+		}
+	}
+
+	return nil
+}
+
+// logStartBanner logs the version and the banner for the start of the run.
+func logStartBanner(opts *RunOptions) {
+	// Print version to make Prow logs more self-explanatory.
+	printVersion()
+
+	// nolint: gocritic
+	if opts.SeverityThreshold >= 0 {
+		if opts.vulnCheckOnly() {
+			logrus.Info("********** START (VULN CHECK) **********")
+		} else if opts.DryRun {
+			logrus.Info("********** START (DRY RUN, VULN WARN) **********")
+		} else {
+			logrus.Info("********** START (VULN WARN) **********")
+		}
+		logrus.Info(
+			`DISCLAIMER: Vulnerabilities are found as issues with package
+binaries within image layers, not necessarily with the image layers themselves.
+So a 'fixable' vulnerability may not necessarily be immediately actionable. For
+example, even though a fixed version of the binary is available, it doesn't
+necessarily mean that a new version of the image layer is available.`,
+		)
+	} else if opts.DryRun {
+		logrus.Info("********** START (DRY RUN) **********")
+	} else {
+		logrus.Info("********** START **********")
+	}
+}
+
+// logFinishBanner logs the banner for a run that finished without errors.
+func logFinishBanner(opts *RunOptions) {
+	// nolint: gocritic
+	if opts.vulnCheckOnly() {
+		logrus.Info("********** FINISHED (VULN CHECK) **********")
+	} else if opts.DryRun {
+		logrus.Info("********** FINISHED (DRY RUN) **********")
+	} else {
+		logrus.Info("********** FINISHED **********")
+	}
+}
+
+// logPromotionFailures logs the banner for a promotion where some (or all) of
+// the requests failed (unless quiet), along with how many of them did.
+func logPromotionFailures(summary reg.RequestSummary, quiet bool) {
+	if !quiet {
+		logrus.Error("********** FINISHED WITH ERRORS **********")
+	}
+	logrus.Errorf(
+		"%d of %d promotion request(s) failed (%d succeeded, %d cancelled)",
+		summary.Failed,
+		summary.Succeeded+summary.Failed+summary.Cancelled,
+		summary.Succeeded,
+		summary.Cancelled)
+}
+
+// promotionFailure logs the summary of a failed promotion (see
+// logPromotionFailures()) and returns err as a TimeoutError if ctx is done, or
+// as a PromotionError otherwise. The summary is logged either way, because
+// after a timeout it tells which requests never started.
+func promotionFailure(
+	ctx context.Context,
+	err error,
+	summary reg.RequestSummary,
+	quiet bool,
+) error {
+	logPromotionFailures(summary, quiet)
+	if ctx.Err() != nil {
+		return &TimeoutError{errors.Wrap(err, "promoting images")}
+	}
+
+	return &PromotionError{errors.Wrap(err, "promoting images")}
+}
+
+// streamManifestBasedSnapshot writes the manifest-based snapshot to stdout (or
+// the snapshot file) as CSV, one image at a time, without holding all promotion
+// edges of the manifests in memory.
+func (o *RunOptions) streamManifestBasedSnapshot(mfests []reg.Manifest) error {
+	if len(o.PromoteGroups) > 0 {
+		mfests = reg.FilterManifestsByGroup(mfests, o.PromoteGroups)
+	}
+
+	if o.SnapshotFile == "" {
+		if err := reg.WriteManifestBasedSnapshot(
+			os.Stdout,
+			mfests,
+			o.ManifestBasedSnapshotOf,
+			o.DestNamespace,
+			o.AllowSelfPromotion,
+		); err != nil {
+			return &ParseError{errors.Wrap(err, "streaming snapshot")}
+		}
+
+		return nil
+	}
+
+	f, err := os.Create(o.SnapshotFile)
+	if err != nil {
+		return errors.Wrap(err, "creating snapshot file")
+	}
+
+	if err := reg.WriteManifestBasedSnapshot(
+		f,
+		mfests,
+		o.ManifestBasedSnapshotOf,
+		o.DestNamespace,
+		o.AllowSelfPromotion,
+	); err != nil {
+		f.Close()
+		return &ParseError{errors.Wrap(err, "streaming snapshot")}
+	}
+
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "writing snapshot")
+	}
+
+	return o.signSnapshot()
+}
+
+// signSnapshot signs the snapshot file with cosign, if '--sign-snapshot' is
+// given.
+func (o *RunOptions) signSnapshot() error {
+	if !o.SignSnapshot {
+		return nil
+	}
+
+	sigPath, err := reg.SignFile(
+		o.SnapshotFile,
+		reg.MkCosignBlobSignerReal(o.CosignKey),
+	)
+	if err != nil {
+		return errors.Wrap(err, "signing snapshot")
+	}
+	logrus.Infof("wrote signature of snapshot to %s", sigPath)
+
+	return nil
+}
+
+// formatInventory renders rii in the given output format, falling back to YAML
+// for unknown formats.
+func formatInventory(
+	rii reg.RegInvImage,
+	format string,
+	tmpl *template.Template,
+) (string, error) {
+	switch strings.ToLower(format) {
+	case "csv":
+		return rii.ToCSV(), nil
+	case "yaml":
+		return rii.ToYAML(reg.YamlMarshalingOpts{}), nil
+	case "table":
+		return rii.ToTable(), nil
+	case PromoterOutputFormatTemplate:
+		if tmpl == nil {
+			return "", errors.Errorf(
+				"'--%s=%s' requires '--%s'",
+				PromoterOutputFlag,
+				PromoterOutputFormatTemplate,
+				PromoterOutputTemplateFlag,
+			)
+		}
+
+		return rii.ToTemplate(tmpl)
+	default:
+		logrus.Errorf(
+			"invalid value %s for '--%s'; defaulting to %s",
+			format,
+			PromoterOutputFlag,
+			PromoterDefaultOutputFormat,
+		)
+
+		return rii.ToYAML(reg.YamlMarshalingOpts{}), nil
+	}
+}
+
+// formatCanonicalInventory renders the canonical form of rii (see
+// '--canonical') as YAML, or as JSON with '--output=json'.
+func formatCanonicalInventory(
+	rii reg.RegInvImage,
+	format string,
+) (string, error) {
+	if strings.ToLower(format) == PromoterOutputFormatJSON {
+		return rii.ToCanonicalJSON()
+	}
+
+	return rii.ToCanonicalYAML(), nil
+}
+
+// loadOutputTemplate parses the Go template in the given file, which renders
+// inventories with '--output=template'. It returns nil if no file is given.
+func loadOutputTemplate(path string) (*template.Template, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(
+			err,
+			"reading '--%s'",
+			PromoterOutputTemplateFlag,
+		)
+	}
+
+	tmpl, err := reg.ParseRegInvTemplate(filepath.Base(path), string(b))
+	if err != nil {
+		return nil, errors.Wrapf(
+			err,
+			"parsing '--%s'",
+			PromoterOutputTemplateFlag,
+		)
+	}
+
+	return tmpl, nil
+}
+
+// printProjectedInventories prints, for each destination registry, what its
+// inventory would look like after the given edges have been promoted.
+func printProjectedInventories(
+	sc *reg.SyncContext,
+	edges map[reg.PromotionEdge]interface{},
+	format string,
+	tmpl *template.Template,
+) error {
+	edgesByDst := make(map[reg.RegistryName][]reg.PromotionEdge)
+	for _, registry := range sc.RegistryContexts {
+		if !registry.Src {
+			edgesByDst[registry.Name] = nil
+		}
+	}
+	for edge := range edges {
+		edgesByDst[edge.DstRegistry.Name] = append(
+			edgesByDst[edge.DstRegistry.Name],
+			edge,
+		)
+	}
+
+	dsts := make([]string, 0, len(edgesByDst))
+	for dst := range edgesByDst {
+		dsts = append(dsts, string(dst))
+	}
+	sort.Strings(dsts)
+
+	for _, dst := range dsts {
+		rii := reg.ProjectInventory(
+			sc.Inv[reg.RegistryName(dst)],
+			edgesByDst[reg.RegistryName(dst)],
+		)
+
+		inventory, err := formatInventory(rii, format, tmpl)
+		if err != nil {
+			return errors.Wrapf(err, "formatting projected inventory of %s", dst)
+		}
+
+		logrus.Infof("projected inventory of %s after promotion:", dst)
+		fmt.Print(inventory)
+	}
+
+	return nil
+}
+
+// printPromotionEdges prints the edges that would be promoted, along with the
+// operations that promote them, as a JSON list to stdout.
+func printPromotionEdges(
+	sc *reg.SyncContext,
+	edges map[reg.PromotionEdge]interface{},
+) error {
+	b, err := json.MarshalIndent(sc.PromotionEdgesJSON(edges), "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "serializing promotion edges")
+	}
+	fmt.Println(string(b))
+
+	return nil
+}
+
+// writeChangelog writes the changelog to the file at path, as JSON if its name
+// ends in ".json", and as Markdown otherwise.
+func writeChangelog(path string, changelog reg.Changelog) error {
+	out := changelog.ToMarkdown()
+	if strings.HasSuffix(path, ".json") {
+		var err error
+		out, err = changelog.ToJSON()
+		if err != nil {
+			return err
+		}
+	}
+
+	return ioutil.WriteFile(path, []byte(out), 0o644)
+}
+
+// readExpectedEdges reads the edges of '--expected-edges', in the JSON format
+// of '--print-edges'.
+func readExpectedEdges(path string) ([]reg.PromotionEdgeJSON, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading expected edges")
+	}
+
+	expected, err := reg.ParsePromotionEdgesJSON(b)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing expected edges %q", path)
+	}
+
+	return expected, nil
+}
+
+// checkExpectedEdges fails unless the edges to promote are exactly those of
+// the expected edges file, so that a manifest change cannot promote anything
+// that was not reviewed.
+func checkExpectedEdges(
+	sc *reg.SyncContext,
+	edges map[reg.PromotionEdge]interface{},
+	path string,
+) error {
+	expected, err := readExpectedEdges(path)
+	if err != nil {
+		return err
+	}
+
+	return errors.Wrapf(
+		reg.CompareEdgesJSON(expected, sc.PromotionEdgesJSON(edges)),
+		"checking '--%s'",
+		PromoterExpectedEdgesFlag,
+	)
+}
+
+// makeSyncContext creates the SyncContext for the registries of mfests, after
+// checking that they are all on the '--allowed-registry-hosts' (if given), and
+// that the manifests agree on the groups of their images.
+func (o *RunOptions) makeSyncContext(
+	mfests []reg.Manifest,
+) (reg.SyncContext, error) {
+	if len(o.AllowedRegistryHosts) > 0 {
+		if err := reg.CheckRegistryHosts(mfests, o.AllowedRegistryHosts); err != nil {
+			return reg.SyncContext{}, &ParseError{errors.Wrapf(
+				err,
+				"checking '--%s'",
+				PromoterAllowedRegistryHostsFlag,
+			)}
+		}
+	}
+
+	if err := reg.CheckImageGroups(mfests); err != nil {
+		return reg.SyncContext{}, &ParseError{err}
+	}
+
+	sc, err := reg.MakeSyncContext(
+		mfests,
+		o.Threads,
+		o.DryRun,
+		o.UseServiceAcct,
+	)
+	if err != nil {
+		return reg.SyncContext{}, &AuthError{
+			errors.Wrap(err, "creating sync context"),
+		}
+	}
+
+	return sc, nil
+}
+
+// applyRegistryCredentials gives the registries of the manifests the service
+// accounts of '--registry-credentials' (if given).
+func (o *RunOptions) applyRegistryCredentials(
+	mfests []reg.Manifest,
+) ([]reg.Manifest, error) {
+	if o.RegistryCredentials == "" {
+		return mfests, nil
+	}
+
+	creds, err := reg.ParseRegistryCredentialsFromFile(o.RegistryCredentials)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing registry credentials")
+	}
+
+	mfests, err = creds.Apply(mfests)
+	if err != nil {
+		return nil, errors.Wrap(err, "applying registry credentials")
+	}
+
+	return mfests, nil
+}
+
+// allowSkippedManifests returns nil if err only reports thin manifests that
+// were skipped as invalid (see '--skip-invalid-manifests'), as long as valid
+// manifests remain to be promoted. The skipped manifests are logged. Otherwise,
+// err is returned as is.
+func allowSkippedManifests(mfests []reg.Manifest, err error) error {
+	var invalid *reg.InvalidManifestsError
+	if err == nil || len(mfests) == 0 || !errors.As(err, &invalid) {
+		return err
+	}
+
+	logrus.Warnf(
+		"continuing with %d valid manifest(s); %v",
+		len(mfests),
+		invalid,
+	)
+	return nil
+}
+
+// readChangedManifests reads the list of changed files (one per line) from the
+// given file. Blank lines and lines starting with "#" are ignored.
+func readChangedManifests(path string) ([]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading list of changed manifests")
+	}
+
+	changed := make([]string, 0)
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		changed = append(changed, line)
+	}
+
+	return changed, nil
+}
+
+// parseForceOverwrite parses a comma-separated list of tags that may be
+// overwritten, each given as "<image>:<tag>" or "<registry>/<image>:<tag>".
+func parseForceOverwrite(value string) (map[string]bool, error) {
+	imageTags := make(map[string]bool)
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		i := strings.LastIndex(field, ":")
+		if i <= 0 || i < strings.LastIndex(field, "/") {
+			return nil, errors.Errorf(
+				"invalid tag %q (expected <image>:<tag>)",
+				field,
+			)
+		}
+		if err := reg.ValidateTag(reg.Tag(field[i+1:])); err != nil {
+			return nil, errors.Wrapf(err, "invalid tag %q", field)
+		}
+
+		imageTags[field] = true
+	}
+
+	if len(imageTags) == 0 {
+		return nil, errors.New("no tags given")
+	}
+
+	return imageTags, nil
+}
+
+// parseDigestList parses a comma-separated list of digests. If the value starts
+// with "@", the rest of it is the path of a file that holds the list instead;
+// in the file, digests may also be separated by newlines, and lines starting
+// with "#" are ignored.
+func parseDigestList(value string) ([]reg.Digest, error) {
+	if strings.HasPrefix(value, "@") {
+		b, err := ioutil.ReadFile(strings.TrimPrefix(value, "@"))
+		if err != nil {
+			return nil, errors.Wrap(err, "reading digest list")
+		}
+
+		lines := make([]string, 0)
+		for _, line := range strings.Split(string(b), "\n") {
+			if !strings.HasPrefix(strings.TrimSpace(line), "#") {
+				lines = append(lines, line)
+			}
+		}
+		value = strings.Join(lines, ",")
+	}
+
+	digests := make([]reg.Digest, 0)
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		digest := reg.Digest(field)
+		if err := reg.ValidateDigest(digest); err != nil {
+			return nil, err
+		}
+		digests = append(digests, digest)
+	}
+
+	if len(digests) == 0 {
+		return nil, errors.New("no digests given")
+	}
+
+	return digests, nil
+}
+
+// mkPromotionProducer returns a function that creates the (real) subprocesses
+// that write images to the destination registries.
+func mkPromotionProducer(sc *reg.SyncContext) func(
+	reg.RegistryName,
+	reg.ImageName,
+	reg.RegistryContext,
+	reg.ImageName,
+	reg.Digest,
+	reg.Tag,
+	reg.TagOp,
+) stream.Producer {
+	return func(
+		srcRegistry reg.RegistryName,
+		srcImageName reg.ImageName,
+		destRC reg.RegistryContext,
+		imageName reg.ImageName,
+		digest reg.Digest, tag reg.Tag, tp reg.TagOp,
+	) stream.Producer {
+		var sp stream.Subprocess
+		sp.CmdInvocation = reg.GetWriteCmd(
+			destRC,
+			sc.UseServiceAccount,
+			srcRegistry,
+			srcImageName,
+			imageName,
+			digest,
+			tag,
+			tp,
+			sc.CopyTool,
+		)
+		sp.Proxy = sc.Proxy
+		sp.Context = sc.Context
+
+		return &sp
+	}
+}
+
+// toPromotionEdges converts the manifests to promotion edges, allowing
+// self-promotion only if asked to. If '--promote-groups' is given, only the
+// images in those groups are considered. If '--dest-namespace' is given, the
+// destination images are placed under it.
+func (o *RunOptions) toPromotionEdges(
+	mfests []reg.Manifest,
+) (map[reg.PromotionEdge]interface{}, error) {
+	if len(o.PromoteGroups) > 0 {
+		mfests = reg.FilterManifestsByGroup(mfests, o.PromoteGroups)
+	}
+
+	if o.quarantineCleanLabel != "" {
+		for _, mfest := range mfests {
+			if mfest.QuarantineRegistry() != nil {
+				return reg.ToQuarantineReleaseEdges(mfests)
+			}
+		}
+
+		return nil, errors.New("no manifest has a quarantine registry")
+	}
+
+	var (
+		edges map[reg.PromotionEdge]interface{}
+		err   error
+	)
+	if o.AllowSelfPromotion {
+		edges, err = reg.ToPromotionEdgesAllowSelfPromotion(mfests)
+	} else {
+		edges, err = reg.ToPromotionEdges(mfests)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return reg.ToDestNamespace(edges, o.DestNamespace)
+}
+
+// vulnCheckOnly is true if only the vulnerability check should be run (and
+// no promotion should take place).
+func (o *RunOptions) vulnCheckOnly() bool {
+	return o.SeverityThreshold >= 0 && o.vulnMode() == reg.VulnModeEnforce
+}
+
+// vulnMode converts the VulnMode option into a reg.VulnMode. Unknown values are
+// rejected by validateImageOptions().
+func (o *RunOptions) vulnMode() reg.VulnMode {
+	if strings.ToLower(o.VulnMode) == PromoterVulnModeWarn {
+		return reg.VulnModeWarn
+	}
+
+	return reg.VulnModeEnforce
+}
+
+// parseManifest parses the '--manifest' file, after verifying its GPG signature
+// if '--manifest-signature' is given.
+func (o *RunOptions) parseManifest() (reg.Manifest, error) {
+	if o.ManifestSignature == "" {
+		return reg.ParseManifestFromFile(o.Manifest)
+	}
+
+	return reg.ParseSignedManifestFromFile(
+		o.Manifest,
+		o.ManifestSignature,
+		reg.MkGPGVerifierReal(o.ManifestGPGKeyring),
+	)
+}
+
+// snapshotRegistryOptions returns the options of a '--snapshot' run for the
+// registry that '--snapshot-registry' selects from the manifests, read with
+// the service account that the manifests give it (unless
+// '--snapshot-service-account' overrides it).
+func (o *RunOptions) snapshotRegistryOptions() (*RunOptions, error) {
+	if o.Manifest == "" &&
+		o.manifests == nil &&
+		o.ThinManifestDir == "" &&
+		o.ThinManifestArchive == "" {
+		return nil, errors.Errorf(
+			"'--%s' requires one of '--%s', '--%s' or '--%s'",
+			PromoterSnapshotRegistryFlag,
+			PromoterManifestFlag,
+			PromoterThinManifestDirFlag,
+			PromoterThinManifestArchiveFlag,
+		)
+	}
+
+	if o.Snapshot != "" || o.ManifestBasedSnapshotOf != "" {
+		return nil, errors.Errorf(
+			"'--%s' and '--%s' or '--%s' are mutually exclusive",
+			PromoterSnapshotRegistryFlag,
+			PromoterSnapshotFlag,
+			PromoterManifestBasedSnapshotOfFlag,
+		)
+	}
+
+	var (
+		mfests []reg.Manifest
+		err    error
+	)
+	switch {
+	case o.manifests != nil:
+		mfests = o.manifests
+	case o.Manifest != "":
+		var mfest reg.Manifest
+		mfest, err = o.parseManifest()
+		mfests = []reg.Manifest{mfest}
+	case o.ThinManifestArchive != "":
+		mfests, err = reg.ParseThinManifestsFromArchive(
+			o.ThinManifestArchive,
+			o.thinManifestOptions(),
+		)
+	default:
+		mfests, err = reg.ParseThinManifestsFromDirWithOptions(
+			o.ThinManifestDir,
+			o.thinManifestOptions(),
+		)
+	}
+	if err != nil {
+		return nil, &ParseError{errors.Wrap(err, "parsing manifests")}
+	}
+
+	registry, err := reg.GetRegistry(
+		mfests,
+		reg.RegistryName(o.SnapshotRegistry),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(
+			err,
+			"invalid value for '--%s'",
+			PromoterSnapshotRegistryFlag,
+		)
+	}
+
+	snapshotOpts := *o
+	snapshotOpts.Snapshot = string(registry.Name)
+	if snapshotOpts.SnapshotSvcAcct == "" {
+		snapshotOpts.SnapshotSvcAcct = registry.ServiceAccount
+	}
+	snapshotOpts.SnapshotRegistry = ""
+	snapshotOpts.Manifest = ""
+	snapshotOpts.manifests = nil
+	snapshotOpts.ThinManifestDir = ""
+	snapshotOpts.ThinManifestArchive = ""
+
+	return &snapshotOpts, nil
+}
+
+// approvalClient returns the client for '--approval-endpoint', or nil if it is
+// not given.
+func (o *RunOptions) approvalClient() (reg.ApprovalClient, error) {
+	if o.ApprovalEndpoint == "" {
+		return nil, nil
+	}
+
+	client, err := reg.MkHTTPApprovalClient(o.ApprovalEndpoint)
+	if err != nil {
+		return nil, errors.Wrapf(
+			err,
+			"parsing '--%s'",
+			PromoterApprovalEndpointFlag,
+		)
+	}
+
+	return client, nil
+}
+
+// digestOracle returns the oracle for '--digest-oracle-url', or nil if it is
+// not given.
+func (o *RunOptions) digestOracle() (reg.DigestOracle, error) {
+	if o.DigestOracleURL == "" {
+		return nil, nil
+	}
+
+	oracle, err := reg.MkHTTPDigestOracle(o.DigestOracleURL)
+	if err != nil {
+		return nil, errors.Wrapf(
+			err,
+			"parsing '--%s'",
+			PromoterDigestOracleURLFlag,
+		)
+	}
+
+	return oracle, nil
+}
+
+// imageAgeMode converts the ImageAgeMode option into a reg.ImageAgeMode.
+// Unknown values are rejected by validateImageOptions().
+func (o *RunOptions) imageAgeMode() reg.ImageAgeMode {
+	if strings.ToLower(o.ImageAgeMode) == PromoterImageAgeModeWarn {
+		return reg.ImageAgeModeWarn
+	}
+
+	return reg.ImageAgeModeEnforce
+}
+
+// enabledChecks returns the names of the prechecks to run after edge
+// filtering. The vulnerability check is implicitly enabled by a severity
+// threshold, the image age check by a maximum image age, the digest allowlist
+// check by a digest allowlist, and the signature check by a minimum number of
+// signatures (given globally, or by any of the images to promote).
+func (o *RunOptions) enabledChecks(
+	edges map[reg.PromotionEdge]interface{},
+) []string {
+	names := make([]string, 0, len(o.EnableChecks)+4)
+	seen := make(map[string]bool)
+	for _, name := range o.EnableChecks {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+
+	if o.SeverityThreshold >= 0 && !seen[PromoterVulnCheck] {
+		names = append(names, PromoterVulnCheck)
+	}
+
+	if o.MaxImageAge > 0 && !seen[PromoterImageAgeCheck] {
+		names = append(names, PromoterImageAgeCheck)
+	}
+
+	if o.DigestAllowlist != "" && !seen[PromoterDigestAllowlistCheck] {
+		names = append(names, PromoterDigestAllowlistCheck)
+	}
+
+	if !seen[PromoterSignatureCheck] && requireSignatures(o.MinSignatures, edges) {
+		names = append(names, PromoterSignatureCheck)
+	}
+
+	return names
+}
+
+// requireSignatures returns true if any of the edges needs signatures, given
+// the global minimum.
+func requireSignatures(
+	minSignatures int,
+	edges map[reg.PromotionEdge]interface{},
+) bool {
+	if minSignatures > 0 {
+		return true
+	}
+
+	for edge := range edges {
+		if edge.MinSignatures > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// promotionLock creates the lock that serializes promotions into the same
+// destination registries across concurrent runs.
+func (o *RunOptions) promotionLock(
+	ctx context.Context,
+) (*reg.PromotionLock, error) {
+	backend, err := reg.MkGCSLockBackend(ctx, o.PromotionLock)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing '--%s'", PromoterPromotionLockFlag)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	return &reg.PromotionLock{
+		Backend: backend,
+		Holder:  fmt.Sprintf("%s/%d", hostname, os.Getpid()),
+		TTL:     o.PromotionLockTTL,
+	}, nil
+}
+
+// thinManifestOptions returns the options that thin manifests are parsed with.
+func (o *RunOptions) thinManifestOptions() reg.ThinManifestOptions {
+	return reg.ThinManifestOptions{
+		ExpandEnv:     o.ExpandEnv,
+		SkipInvalid:   o.SkipInvalidManifests,
+		StrictDigests: o.StrictDigests,
+		Filename:      o.ThinManifestFilename,
+	}
+}
+
+// cloudMonitoringRecorder returns the recorder that exports the promotion
+// metrics to the project of '--cloud-monitoring-project'.
+func (o *RunOptions) cloudMonitoringRecorder(
+	ctx context.Context,
+) (*reg.CloudMonitoringRecorder, error) {
+	prefix := o.CloudMonitoringPrefix
+	if prefix == "" {
+		prefix = PromoterDefaultCloudMonitoringPrefix
+	}
+
+	write, err := reg.MkTimeSeriesWriterReal(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return reg.MkCloudMonitoringRecorder(
+		o.CloudMonitoringProject,
+		prefix,
+		write,
+	)
+}
+
+// bandwidthLimiter returns the limiter for '--bandwidth-limit', or nil if
+// there is no limit.
+func (o *RunOptions) bandwidthLimiter() *reg.BandwidthLimiter {
+	if o.BandwidthLimit <= 0 {
+		return nil
+	}
+
+	return reg.MkBandwidthLimiter(o.BandwidthLimit)
+}
+
+// retryPolicy returns the policy for retrying failed registry reads, which
+// retries the status codes given with '--retry-status-codes'.
+func (o *RunOptions) retryPolicy() stream.RetryPolicy {
+	return stream.RetryPolicy{StatusCodes: o.RetryStatusCodes}
+}
+
+// registryTLS loads the TLS settings given with '--client-cert',
+// '--client-key' and '--ca-bundle', keyed by registry host.
+func (o *RunOptions) registryTLS() (map[string]*reg.RegistryTLS, error) {
+	registryTLS, err := reg.MkRegistryTLSMap(
+		o.ClientCerts,
+		o.ClientKeys,
+		o.CABundles)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading registry TLS settings")
+	}
+
+	return registryTLS, nil
+}
+
+// mkReadRepositoryCmd returns the reader of the registry inventories: the
+// fixture given with '--inventory-from', or the registries themselves.
+func (o *RunOptions) mkReadRepositoryCmd() (
+	func(*reg.SyncContext, reg.RegistryContext) stream.Producer,
+	error,
+) {
+	if o.InventoryFrom == "" {
+		return reg.MkReadRepositoryCmdReal, nil
+	}
+
+	mi, err := reg.LoadInventoryFixture(o.InventoryFrom)
+	if err != nil {
+		return nil, &ParseError{errors.Wrapf(
+			err,
+			"loading '--%s'",
+			PromoterInventoryFromFlag,
+		)}
+	}
+
+	return reg.MkReadRepositoryCmdFromInventory(mi), nil
+}
+
+// proxy returns the proxy configuration for all registry communication.
+func (o *RunOptions) proxy() stream.Proxy {
+	return stream.Proxy{
+		HTTPSProxy: o.HTTPSProxy,
+		NoProxy:    o.NoProxy,
+	}
+}
+
+// validateImageOptions checks the options of RunPromoteCmd (and RunWatchCmd)
+// before anything is read.
+func validateImageOptions(o *RunOptions) error {
+	if err := validateVulnMode(o.VulnMode); err != nil {
+		return err
+	}
+
+	if err := validateOutputTemplate(o.OutputFormat, o.OutputTemplate); err != nil {
+		return err
+	}
+
+	if err := validateImageAgeMode(o.ImageAgeMode); err != nil {
+		return err
+	}
+
+	if _, err := o.approvalClient(); err != nil {
+		return err
+	}
+
+	if _, err := o.digestOracle(); err != nil {
+		return err
+	}
+
+	if o.MaxImageAge < 0 {
+		return errors.Errorf(
+			"invalid value %v for '--%s' (must not be negative)",
+			o.MaxImageAge,
+			PromoterMaxImageAgeFlag,
+		)
+	}
+
+	if err := validateHTTPSProxy(o.HTTPSProxy); err != nil {
+		return err
+	}
+
+	if err := validateRetryStatusCodes(o.RetryStatusCodes); err != nil {
+		return err
+	}
+
+	if err := validateRegistryMirrors(o.RegistryMirrors); err != nil {
+		return err
+	}
+
+	if err := validateRegistryTLS(o); err != nil {
+		return err
+	}
+
+	if o.PromotionLock != "" && !strings.HasPrefix(o.PromotionLock, "gs://") {
+		return errors.Errorf(
+			"invalid value %q for '--%s' (expected a GCS URL such as gs://bucket/locks)",
+			o.PromotionLock,
+			PromoterPromotionLockFlag,
+		)
+	}
+
+	if o.PublishEvents != "" &&
+		!strings.HasPrefix(o.PublishEvents, "projects/") {
+		return errors.Errorf(
+			"invalid value %q for '--%s' (expected a Pub/Sub topic such as projects/<project>/topics/<topic>)",
+			o.PublishEvents,
+			PromoterPublishEventsFlag,
+		)
+	}
+
+	if o.CloudMonitoringPrefix != "" &&
+		o.CloudMonitoringPrefix != PromoterDefaultCloudMonitoringPrefix &&
+		o.CloudMonitoringProject == "" {
+		return errors.Errorf(
+			"'--%s' requires '--%s'",
+			PromoterCloudMonitoringPrefixFlag,
+			PromoterCloudMonitoringProjectFlag,
+		)
+	}
+
+	if o.CloudMonitoringPrefix != "" &&
+		!strings.HasPrefix(o.CloudMonitoringPrefix, "custom.googleapis.com/") {
+		return errors.Errorf(
+			"invalid value %q for '--%s' (expected a custom metric prefix such as custom.googleapis.com/cip)",
+			o.CloudMonitoringPrefix,
+			PromoterCloudMonitoringPrefixFlag,
+		)
+	}
+
+	if o.ForceOverwrite != "" {
+		if _, err := parseForceOverwrite(o.ForceOverwrite); err != nil {
+			return errors.Wrapf(err, "parsing '--%s'", PromoterForceOverwriteFlag)
+		}
+	}
+
+	if err := validateEnableChecks(o.EnableChecks); err != nil {
+		return err
+	}
+
+	if o.SignSnapshot && (o.SnapshotFile == "" || o.CosignKey == "") {
+		return errors.Errorf(
+			"'--%s' requires '--%s' and '--%s'",
+			PromoterSignSnapshotFlag,
+			PromoterSnapshotFileFlag,
+			PromoterCosignKeyFlag,
+		)
+	}
+
+	if o.CosignKey != "" && !o.SignSnapshot {
+		return errors.Errorf(
+			"'--%s' only works with '--%s'",
+			PromoterCosignKeyFlag,
+			PromoterSignSnapshotFlag,
+		)
+	}
+
+	if o.VulnThreads < 0 {
+		return errors.Errorf(
+			"invalid value %d for '--%s' (must not be negative)",
+			o.VulnThreads,
+			PromoterVulnThreadsFlag,
+		)
+	}
+
+	if o.VulnWaitTimeout < 0 {
+		return errors.Errorf(
+			"invalid value %v for '--%s' (must not be negative)",
+			o.VulnWaitTimeout,
+			PromoterVulnWaitTimeoutFlag,
+		)
+	}
+
+	if o.MinSignatures < 0 {
+		return errors.Errorf(
+			"invalid value %d for '--%s' (must not be negative)",
+			o.MinSignatures,
+			PromoterMinSignaturesFlag,
+		)
+	}
+
+	if err := reg.ValidateRegistryHostPatterns(o.AllowedRegistryHosts); err != nil {
+		return errors.Wrapf(err, "parsing '--%s'", PromoterAllowedRegistryHostsFlag)
+	}
+
+	for flag, retries := range map[string]int{
+		PromoterCopyRetriesFlag: o.CopyRetries,
+		PromoterTagRetriesFlag:  o.TagRetries,
+	} {
+		if retries < 0 {
+			return errors.Errorf(
+				"invalid value %d for '--%s' (must not be negative)",
+				retries,
+				flag,
+			)
+		}
+	}
+
+	if o.KeepLatestN < 0 {
+		return errors.Errorf(
+			"invalid value %d for '--%s' (must not be negative)",
+			o.KeepLatestN,
+			PromoterKeepLatestNFlag,
+		)
+	}
+
+	if o.KeepLatestN > 0 && o.Snapshot == "" && o.ManifestBasedSnapshotOf == "" {
+		return errors.Errorf(
+			"'--%s' requires '--%s' or '--%s'",
+			PromoterKeepLatestNFlag,
+			PromoterSnapshotFlag,
+			PromoterManifestBasedSnapshotOfFlag,
+		)
+	}
+
+	if o.BatchSize < 0 {
+		return errors.Errorf(
+			"invalid value %d for '--%s' (must not be negative)",
+			o.BatchSize,
+			PromoterBatchSizeFlag,
+		)
+	}
+
+	if o.BatchPause < 0 {
+		return errors.Errorf(
+			"invalid value %v for '--%s' (must not be negative)",
+			o.BatchPause,
+			PromoterBatchPauseFlag,
+		)
+	}
+
+	if o.BatchPause > 0 && o.BatchSize == 0 {
+		return errors.Errorf(
+			"'--%s' requires '--%s'",
+			PromoterBatchPauseFlag,
+			PromoterBatchSizeFlag,
+		)
+	}
+
+	if o.DryRunCommandsOut != "" && !o.DryRun {
+		return errors.Errorf(
+			"'--%s' requires '--dry-run'",
+			PromoterDryRunCommandsOutFlag,
+		)
+	}
+
+	if o.ExpectedEdges != "" {
+		if !o.DryRun {
+			return errors.Errorf(
+				"'--%s' requires '--dry-run'",
+				PromoterExpectedEdgesFlag,
+			)
+		}
+
+		if _, err := readExpectedEdges(o.ExpectedEdges); err != nil {
+			return errors.Wrapf(
+				err,
+				"invalid value for '--%s'",
+				PromoterExpectedEdgesFlag,
+			)
+		}
+	}
+
+	if o.RegistryCredentials != "" {
+		_, err := reg.ParseRegistryCredentialsFromFile(o.RegistryCredentials)
+		if err != nil {
+			return errors.Wrapf(
+				err,
+				"invalid value for '--%s'",
+				PromoterRegistryCredentialsFlag,
+			)
+		}
+	}
+
+	if o.CostTable != "" {
+		if !o.DryRun {
+			return errors.Errorf(
+				"'--%s' requires '--dry-run'",
+				PromoterCostTableFlag,
+			)
+		}
+
+		if _, err := reg.ParseCostTableFromFile(o.CostTable); err != nil {
+			return errors.Wrapf(
+				err,
+				"invalid value for '--%s'",
+				PromoterCostTableFlag,
+			)
+		}
+	}
+
+	if o.InventoryFrom != "" {
+		// Promotions would write to the real registries, based on an
+		// inventory that may not match them.
+		if !o.DryRun && o.Snapshot == "" && o.ManifestBasedSnapshotOf == "" {
+			return errors.Errorf(
+				"'--%s' requires '--dry-run', '--%s' or '--%s'",
+				PromoterInventoryFromFlag,
+				PromoterSnapshotFlag,
+				PromoterManifestBasedSnapshotOfFlag,
+			)
+		}
+
+		// The manifest lists are read from the registries.
+		if o.MinimalSnapshot {
+			return errors.Errorf(
+				"'--%s' and '--minimal-snapshot' are mutually exclusive",
+				PromoterInventoryFromFlag,
+			)
+		}
+	}
+
+	if o.DestNamespace != "" {
+		namespace := strings.TrimSuffix(o.DestNamespace, "/")
+		if err := reg.ValidateDestNamespace(namespace); err != nil {
+			return errors.Wrapf(
+				err,
+				"invalid value for '--%s'",
+				PromoterDestNamespaceFlag,
+			)
+		}
+	}
+
+	if o.DestPathPolicy != "" {
+		if _, err := reg.MkDestPathPolicy(o.DestPathPolicy); err != nil {
+			return errors.Wrapf(
+				err,
+				"invalid value for '--%s'",
+				PromoterDestPathPolicyFlag,
+			)
+		}
+	}
+
+	if o.CopyTool != "" {
+		if _, err := reg.MkCopyTool(o.CopyTool); err != nil {
+			return errors.Wrapf(
+				err,
+				"invalid value for '--%s'",
+				PromoterCopyToolFlag,
+			)
+		}
+	}
+
+	if o.BandwidthLimit < 0 {
+		return errors.Errorf(
+			"invalid value %d for '--%s' (must not be negative)",
+			o.BandwidthLimit,
+			PromoterBandwidthLimitFlag,
+		)
+	}
+
+	if o.BandwidthLimit > 0 && o.CopyTool != "" {
+		return errors.Errorf(
+			"'--%s' only applies to images copied in-process, so it cannot be used with '--%s'",
+			PromoterBandwidthLimitFlag,
+			PromoterCopyToolFlag,
+		)
+	}
+
+	if o.SnapshotDigests != "" {
+		if _, err := parseDigestList(o.SnapshotDigests); err != nil {
+			return errors.Wrapf(
+				err,
+				"invalid value for '--%s'",
+				PromoterSnapshotDigestsFlag,
+			)
+		}
+	}
+
+	if o.SnapshotOnly != "" {
+		if o.Snapshot == "" && o.ManifestBasedSnapshotOf == "" {
+			return errors.Errorf(
+				"'--%s' requires '--%s' or '--%s'",
+				PromoterSnapshotOnlyFlag,
+				PromoterSnapshotFlag,
+				PromoterManifestBasedSnapshotOfFlag,
+			)
+		}
+
+		// Streamed snapshots do not read the registry, so they cannot tell
+		// manifest lists apart.
+		if o.StreamSnapshot {
+			return errors.Errorf(
+				"'--%s' and '--%s' are mutually exclusive",
+				PromoterSnapshotOnlyFlag,
+				PromoterStreamSnapshotFlag,
+			)
+		}
+
+		switch o.SnapshotOnly {
+		case PromoterSnapshotOnlyManifestLists, PromoterSnapshotOnlyImages:
+		default:
+			return errors.Errorf(
+				"invalid value %q for '--%s' (allowed values: %q)",
+				o.SnapshotOnly,
+				PromoterSnapshotOnlyFlag,
+				[]string{
+					PromoterSnapshotOnlyManifestLists,
+					PromoterSnapshotOnlyImages,
+				},
+			)
+		}
+	}
+
+	if o.SnapshotExcludeDigests != "" {
+		_, err := parseDigestList("@" + o.SnapshotExcludeDigests)
+		if err != nil {
+			return errors.Wrapf(
+				err,
+				"invalid value for '--%s'",
+				PromoterSnapshotExcludeDigestsFlag,
+			)
+		}
+	}
+
+	if o.DigestAllowlist != "" {
+		_, err := parseDigestList("@" + o.DigestAllowlist)
+		if err != nil {
+			return errors.Wrapf(
+				err,
+				"invalid value for '--%s'",
+				PromoterDigestAllowlistFlag,
+			)
+		}
+	}
+
+	// The filename is matched against the base names of the files within
+	// "manifests/<dir>", so it cannot be a path.
+	if o.ThinManifestFilename != "" &&
+		(filepath.Base(o.ThinManifestFilename) != o.ThinManifestFilename ||
+			o.ThinManifestFilename == "." || o.ThinManifestFilename == "..") {
+		return errors.Errorf(
+			"'--%s' must be a file name without a directory: %q",
+			PromoterThinManifestFilenameFlag,
+			o.ThinManifestFilename,
+		)
+	}
+
+	if o.ChangedManifests != "" && o.ThinManifestDir == "" {
+		return errors.Errorf(
+			"'--%s' requires '--%s'",
+			PromoterChangedManifestsFlag,
+			PromoterThinManifestDirFlag,
+		)
+	}
+
+	if o.SkipInvalidManifests &&
+		o.ThinManifestDir == "" && o.ThinManifestArchive == "" {
+		return errors.Errorf(
+			"'--%s' requires '--%s' or '--%s'",
+			PromoterSkipInvalidManifestsFlag,
+			PromoterThinManifestDirFlag,
+			PromoterThinManifestArchiveFlag,
+		)
+	}
+
+	// Plain manifests always require digests, so there is nothing to enforce
+	// for them.
+	if o.StrictDigests &&
+		o.ThinManifestDir == "" && o.ThinManifestArchive == "" {
+		return errors.Errorf(
+			"'--%s' requires '--%s' or '--%s'",
+			PromoterStrictDigestsFlag,
+			PromoterThinManifestDirFlag,
+			PromoterThinManifestArchiveFlag,
+		)
+	}
+
+	if o.SinceRef != "" {
+		if o.ThinManifestDir == "" {
+			return errors.Errorf(
+				"'--%s' requires '--%s'",
+				PromoterSinceRefFlag,
+				PromoterThinManifestDirFlag,
+			)
+		}
+
+		if o.ChangedManifests != "" {
+			return errors.Errorf(
+				"'--%s' and '--%s' are mutually exclusive",
+				PromoterSinceRefFlag,
+				PromoterChangedManifestsFlag,
+			)
+		}
+
+		if strings.HasPrefix(o.SinceRef, "-") {
+			return errors.Errorf(
+				"invalid value for '--%s': %q is not a git ref",
+				PromoterSinceRefFlag,
+				o.SinceRef,
+			)
+		}
+	}
+
+	if (o.ManifestSignature == "") != (o.ManifestGPGKeyring == "") {
+		return errors.Errorf(
+			"'--%s' and '--%s' must be given together",
+			PromoterManifestSignatureFlag,
+			PromoterManifestGPGKeyringFlag,
+		)
+	}
+
+	if o.ManifestSignature != "" && o.Manifest == "" {
+		return errors.Errorf(
+			"'--%s' requires '--%s'",
+			PromoterManifestSignatureFlag,
+			PromoterManifestFlag,
+		)
+	}
+
+	if o.ThinManifestDir != "" && o.ThinManifestArchive != "" {
+		return errors.Errorf(
+			"'--%s' and '--%s' are mutually exclusive",
+			PromoterThinManifestDirFlag,
+			PromoterThinManifestArchiveFlag,
+		)
+	}
+
+	if o.ProjectedInventory && !o.DryRun {
+		return errors.Errorf(
+			"'--%s' can only be used in dry-run mode",
+			PromoterProjectedInventoryFlag,
+		)
+	}
+
+	if o.Canonical {
+		if o.Snapshot == "" && o.ManifestBasedSnapshotOf == "" {
+			return errors.Errorf(
+				"'--%s' requires '--%s' or '--%s'",
+				PromoterCanonicalFlag,
+				PromoterSnapshotFlag,
+				PromoterManifestBasedSnapshotOfFlag,
+			)
+		}
+
+		switch strings.ToLower(o.OutputFormat) {
+		case "yaml", PromoterOutputFormatJSON:
+		default:
+			return errors.Errorf(
+				"'--%s' requires '--%s=yaml' or '--%s=%s'",
+				PromoterCanonicalFlag,
+				PromoterOutputFlag,
+				PromoterOutputFlag,
+				PromoterOutputFormatJSON,
+			)
+		}
+	}
+
+	if o.StreamSnapshot {
+		if o.ManifestBasedSnapshotOf == "" {
+			return errors.Errorf(
+				"'--%s' requires '--%s'",
+				PromoterStreamSnapshotFlag,
+				PromoterManifestBasedSnapshotOfFlag,
+			)
+		}
+
+		if strings.ToLower(o.OutputFormat) != "csv" {
+			return errors.Errorf(
+				"'--%s' requires '--%s=csv'",
+				PromoterStreamSnapshotFlag,
+				PromoterOutputFlag,
+			)
+		}
+
+		// These need the whole snapshot at once.
+		for flag, set := range map[string]bool{
+			"minimal-snapshot":           o.MinimalSnapshot,
+			PromoterValidateSnapshotFlag: o.ValidateSnapshot,
+			PromoterKeepLatestNFlag:      o.KeepLatestN > 0,
+			// The excluded digests are only dropped from whole snapshots.
+			PromoterSnapshotExcludeDigestsFlag: o.SnapshotExcludeDigests != "",
+		} {
+			if set {
+				return errors.Errorf(
+					"'--%s' and '--%s' are mutually exclusive",
+					PromoterStreamSnapshotFlag,
+					flag,
+				)
+			}
+		}
+	}
+
+	if o.PrintEdges {
+		// Only the edges may be printed to stdout.
+		for flag, set := range map[string]bool{
+			PromoterParseOnlyFlag:               o.ParseOnly,
+			PromoterSnapshotFlag:                o.Snapshot != "",
+			PromoterManifestBasedSnapshotOfFlag: o.ManifestBasedSnapshotOf != "",
+			PromoterProjectedInventoryFlag:      o.ProjectedInventory,
+			PromoterCostTableFlag:               o.CostTable != "",
+		} {
+			if set {
+				return errors.Errorf(
+					"'--%s' and '--%s' are mutually exclusive",
+					PromoterPrintEdgesFlag,
+					flag,
+				)
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateVulnMode(vulnMode string) error {
+	// An empty mode falls back to the default.
+	if vulnMode == "" {
+		return nil
+	}
+
+	for _, allowed := range PromoterAllowedVulnModes {
+		if strings.ToLower(vulnMode) == allowed {
+			return nil
+		}
+	}
+
+	return errors.Errorf(
+		"invalid value %q for '--vuln-mode' (allowed values: %q)",
+		vulnMode,
+		PromoterAllowedVulnModes,
+	)
+}
+
+// validateOutputTemplate checks that '--output-template' is given if, and only
+// if, the output format is "template".
+func validateOutputTemplate(format, templatePath string) error {
+	isTemplate := strings.ToLower(format) == PromoterOutputFormatTemplate
+	if isTemplate && templatePath == "" {
+		return errors.Errorf(
+			"'--%s=%s' requires '--%s'",
+			PromoterOutputFlag,
+			PromoterOutputFormatTemplate,
+			PromoterOutputTemplateFlag,
+		)
+	}
+
+	if !isTemplate && templatePath != "" {
+		return errors.Errorf(
+			"'--%s' requires '--%s=%s'",
+			PromoterOutputTemplateFlag,
+			PromoterOutputFlag,
+			PromoterOutputFormatTemplate,
+		)
+	}
+
+	return nil
+}
+
+func validateImageAgeMode(imageAgeMode string) error {
+	// An empty mode falls back to the default.
+	if imageAgeMode == "" {
+		return nil
+	}
+
+	for _, allowed := range PromoterAllowedImageAgeModes {
+		if strings.ToLower(imageAgeMode) == allowed {
+			return nil
+		}
+	}
+
+	return errors.Errorf(
+		"invalid value %q for '--%s' (allowed values: %q)",
+		imageAgeMode,
+		PromoterImageAgeModeFlag,
+		PromoterAllowedImageAgeModes,
+	)
+}
+
+func validateHTTPSProxy(httpsProxy string) error {
+	if httpsProxy == "" {
+		return nil
+	}
+
+	u, err := url.Parse(httpsProxy)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return errors.Errorf(
+			"invalid value %q for '--%s' (expected a URL such as http://proxy.example.com:3128)",
+			httpsProxy,
+			PromoterHTTPSProxyFlag,
+		)
+	}
+
+	return nil
+}
+
+func validateRetryStatusCodes(codes []int) error {
+	for _, code := range codes {
+		if code < 100 || code > 599 {
+			return errors.Errorf(
+				"invalid value %d for '--%s' (not an HTTP status code)",
+				code,
+				PromoterRetryStatusCodesFlag,
+			)
+		}
+	}
+
+	return nil
+}
+
+func validateRegistryMirrors(mirrors map[string]string) error {
+	for host, mirror := range mirrors {
+		for _, h := range []string{host, mirror} {
+			if h == "" || strings.ContainsAny(h, "/ ") {
+				return errors.Errorf(
+					"invalid value %q for '--%s' (expected a mapping of registry hosts such as gcr.io=mirror.example.com)",
+					host+"="+mirror,
+					PromoterRegistryMirrorFlag,
+				)
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateRegistryTLS(o *RunOptions) error {
+	for flag, files := range map[string]map[string]string{
+		PromoterClientCertFlag: o.ClientCerts,
+		PromoterClientKeyFlag:  o.ClientKeys,
+		PromoterCABundleFlag:   o.CABundles,
+	} {
+		for host, path := range files {
+			if host == "" || path == "" || strings.ContainsAny(host, "/ ") {
+				return errors.Errorf(
+					"invalid value %q for '--%s' (expected a mapping of a registry host to a file such as registry.example.com=/path/to/file.pem)",
+					host+"="+path,
+					flag,
+				)
+			}
+		}
+	}
+
+	for host := range o.ClientCerts {
+		if _, ok := o.ClientKeys[host]; !ok {
+			return errors.Errorf(
+				"'--%s' for %s needs a '--%s' for the same registry",
+				PromoterClientCertFlag,
+				host,
+				PromoterClientKeyFlag,
+			)
+		}
+	}
+	for host := range o.ClientKeys {
+		if _, ok := o.ClientCerts[host]; !ok {
+			return errors.Errorf(
+				"'--%s' for %s needs a '--%s' for the same registry",
+				PromoterClientKeyFlag,
+				host,
+				PromoterClientCertFlag,
+			)
+		}
+	}
+
+	// Only skopeo can be given client certificates and CAs.
+	hasTLS := len(o.ClientCerts) > 0 || len(o.CABundles) > 0
+	if hasTLS && o.CopyTool != "" &&
+		strings.ToLower(o.CopyTool) != reg.CopyToolSkopeo {
+		return errors.Errorf(
+			"'--%s' and '--%s' cannot be used with '--%s=%s', which has no options for them (use '--%s=%s', or copy in-process)",
+			PromoterClientCertFlag,
+			PromoterCABundleFlag,
+			PromoterCopyToolFlag,
+			o.CopyTool,
+			PromoterCopyToolFlag,
+			reg.CopyToolSkopeo,
+		)
+	}
+
+	return nil
+}
+
+func validateEnableChecks(names []string) error {
+	registered := PromoterAvailableChecks()
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		found := false
+		for _, allowed := range registered {
+			if name == allowed {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return errors.Errorf(
+				"invalid value %q for '--%s' (allowed values: %q)",
+				name,
+				PromoterEnableChecksFlag,
+				registered,
+			)
+		}
+	}
 
-// nolint: unused
-func validateImageOptions(o *RunOptions) error {
-	// TODO: Validate options
 	return nil
 }
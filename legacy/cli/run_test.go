@@ -0,0 +1,98 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+func TestPromotionFailure(t *testing.T) {
+	timedOut, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	summary := reg.RequestSummary{
+		Succeeded: 1,
+		Failed:    1,
+		Cancelled: 2,
+	}
+
+	tests := []struct {
+		name            string
+		ctx             context.Context
+		expectedTimeout bool
+	}{
+		{
+			"Failure",
+			context.Background(),
+			false,
+		},
+		{
+			"Timeout",
+			timedOut,
+			true,
+		},
+	}
+
+	logger := logrus.StandardLogger()
+	defer logger.ReplaceHooks(logger.ReplaceHooks(make(logrus.LevelHooks)))
+	hook := logtest.NewLocal(logger)
+
+	for _, test := range tests {
+		hook.Reset()
+
+		err := promotionFailure(
+			test.ctx,
+			errors.New("could not copy image"),
+			summary,
+			false)
+
+		var timeoutErr *TimeoutError
+		var promotionErr *PromotionError
+		require.Equal(
+			t,
+			test.expectedTimeout,
+			errors.As(err, &timeoutErr),
+			test.name)
+		require.Equal(
+			t,
+			!test.expectedTimeout,
+			errors.As(err, &promotionErr),
+			test.name)
+
+		// The summary is logged either way.
+		messages := []string{}
+		for _, entry := range hook.AllEntries() {
+			messages = append(messages, entry.Message)
+		}
+		require.Equal(
+			t,
+			[]string{
+				"********** FINISHED WITH ERRORS **********",
+				"1 of 4 promotion request(s) failed (1 succeeded, 2 cancelled)",
+			},
+			messages,
+			test.name)
+	}
+}
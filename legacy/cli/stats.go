@@ -0,0 +1,61 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+type StatsOptions struct {
+	Snapshot string
+}
+
+// RunStatsCmd prints the number of images, digests and tags in a snapshot
+// file (as written by 'cip run --snapshot=...').
+func RunStatsCmd(opts *StatsOptions) error {
+	rii, err := readSnapshot(opts.Snapshot)
+	if err != nil {
+		return err
+	}
+
+	stats := rii.Stats()
+	fmt.Printf("images: %d\n", stats.Images)
+	fmt.Printf("digests: %d\n", stats.Digests)
+	fmt.Printf("tags: %d\n", stats.Tags)
+	fmt.Printf("tagless digests: %d\n", stats.TaglessDigests)
+
+	return nil
+}
+
+// readSnapshot reads a snapshot file in the default (YAML) output format.
+func readSnapshot(path string) (reg.RegInvImage, error) {
+	images, err := reg.ParseImagesFromFile(path)
+	if err != nil {
+		return nil, &ParseError{errors.Wrapf(err, "parsing snapshot %q", path)}
+	}
+
+	rii := make(reg.RegInvImage)
+	for _, image := range images {
+		rii[image.ImageName] = image.Dmap
+	}
+
+	return rii, nil
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+type TagsOptions struct {
+	Image          string
+	SvcAcct        string
+	OutputFormat   string
+	OutputTemplate string
+	UseServiceAcct bool
+}
+
+const (
+	TagsServiceAccountFlag  = "service-account"
+	TagsDefaultOutputFormat = "table"
+)
+
+// RunTagsCmd prints the tags of a single image (e.g., "gcr.io/foo/bar") along
+// with the digests they point at, without reading the rest of the registry.
+func RunTagsCmd(opts *TagsOptions) error {
+	if err := validateTagsOptions(opts); err != nil {
+		return errors.Wrap(err, "validating tags options")
+	}
+
+	rc := reg.RegistryContext{
+		Name:           reg.RegistryName(opts.Image),
+		ServiceAccount: opts.SvcAcct,
+		Src:            true,
+	}
+
+	sc, err := reg.MakeSyncContext(
+		[]reg.Manifest{{Registries: []reg.RegistryContext{rc}}},
+		1,
+		false,
+		opts.UseServiceAcct,
+	)
+	if err != nil {
+		return &AuthError{errors.Wrap(err, "creating sync context")}
+	}
+
+	rii, err := sc.ReadImageTags(rc, reg.MkReadRepositoryCmdReal)
+	if err != nil {
+		return errors.Wrap(err, "reading tags")
+	}
+
+	tmpl, err := loadOutputTemplate(opts.OutputTemplate)
+	if err != nil {
+		return err
+	}
+
+	tags, err := formatInventory(rii, opts.OutputFormat, tmpl)
+	if err != nil {
+		return errors.Wrap(err, "formatting tags")
+	}
+
+	fmt.Print(tags)
+
+	return nil
+}
+
+func validateTagsOptions(o *TagsOptions) error {
+	if err := reg.ValidateRegistryImagePath(
+		reg.RegistryImagePath(o.Image),
+	); err != nil {
+		return errors.Wrap(err, "expected an image such as gcr.io/foo/bar")
+	}
+
+	for _, format := range PromoterAllowedOutputFormats {
+		if o.OutputFormat == format {
+			return validateOutputTemplate(o.OutputFormat, o.OutputTemplate)
+		}
+	}
+
+	return errors.Errorf(
+		"invalid value %q for '--%s' (allowed values: %q)",
+		o.OutputFormat,
+		PromoterOutputFlag,
+		PromoterAllowedOutputFormats,
+	)
+}
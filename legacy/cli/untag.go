@@ -0,0 +1,81 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"github.com/pkg/errors"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/stream"
+)
+
+type UntagOptions struct {
+	Image          string
+	SvcAcct        string
+	DryRun         bool
+	Confirm        bool
+	UseServiceAcct bool
+}
+
+const UntagConfirmFlag = "confirm"
+
+// RunUntagCmd removes a single tag (e.g., "gcr.io/foo/bar:1.0") from an image,
+// leaving the digest it points at and the other tags of the image intact.
+func RunUntagCmd(opts *UntagOptions) error {
+	if err := validateUntagOptions(opts); err != nil {
+		return errors.Wrap(err, "validating untag options")
+	}
+
+	registry, imageName, tag, err := reg.ParseTaggedImage(opts.Image)
+	if err != nil {
+		return errors.Wrap(err, "parsing image")
+	}
+
+	rc := reg.RegistryContext{
+		Name:           registry,
+		ServiceAccount: opts.SvcAcct,
+	}
+
+	sc, err := reg.MakeSyncContext(
+		[]reg.Manifest{{Registries: []reg.RegistryContext{rc}}},
+		1,
+		opts.DryRun,
+		opts.UseServiceAcct,
+	)
+	if err != nil {
+		return &AuthError{errors.Wrap(err, "creating sync context")}
+	}
+
+	return sc.Untag(rc, imageName, tag, func(cmd []string) stream.Producer {
+		return &stream.Subprocess{
+			CmdInvocation: cmd,
+			Proxy:         sc.Proxy,
+			Context:       sc.Context,
+		}
+	})
+}
+
+func validateUntagOptions(o *UntagOptions) error {
+	if !o.DryRun && !o.Confirm {
+		return errors.Errorf(
+			"removing a tag requires '--%s' (or '--dry-run' to only print the command)",
+			UntagConfirmFlag,
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,201 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"context"
+	"os"
+
+	guuid "github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/gcloud"
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/watch"
+)
+
+// RunWatchCmd starts a watcher that promotes the images of the manifests as
+// they are pushed, with the same options and checks as RunPromoteCmd.
+func RunWatchCmd(opts *RunOptions) error {
+	if err := validateWatchOptions(opts); err != nil {
+		return errors.Wrap(err, "validating watch options")
+	}
+
+	if err := validateImageOptions(opts); err != nil {
+		return errors.Wrap(err, "validating image options")
+	}
+
+	// Activate service accounts.
+	if opts.UseServiceAcct && opts.KeyFiles != "" {
+		if err := gcloud.ActivateServiceAccounts(opts.KeyFiles); err != nil {
+			return &AuthError{errors.Wrap(err, "activating service accounts")}
+		}
+	}
+
+	var mfests []reg.Manifest
+	if opts.Manifest != "" {
+		mfest, err := opts.parseManifest()
+		if err != nil {
+			return &ParseError{errors.Wrap(err, "parsing manifest")}
+		}
+
+		mfests = []reg.Manifest{mfest}
+	} else {
+		var err error
+		mfests, err = reg.ParseThinManifestsFromDirWithOptions(
+			opts.ThinManifestDir,
+			opts.thinManifestOptions(),
+		)
+		err = allowSkippedManifests(mfests, err)
+		if err != nil {
+			return &ParseError{
+				errors.Wrap(err, "parsing thin manifest directory"),
+			}
+		}
+	}
+
+	mfests, err := opts.applyRegistryCredentials(mfests)
+	if err != nil {
+		return &ParseError{err}
+	}
+
+	edges, err := opts.toPromotionEdges(mfests)
+	if err != nil {
+		return &ParseError{errors.Wrap(
+			err,
+			"converting list of manifests to edges for promotion",
+		)}
+	}
+
+	// Set up a sync context once, so that bad options stop the watcher from
+	// starting, instead of failing every promotion.
+	sc, err := opts.makeSyncContext(mfests)
+	if err != nil {
+		return err
+	}
+
+	if err := opts.configureSyncContext(context.Background(), &sc); err != nil {
+		return err
+	}
+
+	id := os.Getenv("CIP_WATCH_TESTCASE_UUID")
+	if id == "" {
+		id = guuid.NewString()
+	}
+
+	if opts.DryRun {
+		logrus.Infof("Starting watcher in dry run mode (%s)", id)
+	} else {
+		logrus.Infof("Starting watcher (%s)", id)
+	}
+
+	watcherContext := watch.ServerContext{
+		ID:                id,
+		Manifests:         mfests,
+		Edges:             edges,
+		PromotionFacility: opts.promoteWatchedEdges(mfests),
+		CanApprove:        opts.ApprovalEndpoint != "",
+	}
+
+	if err := watcherContext.Validate(); err != nil {
+		return &ParseError{errors.Wrap(err, "validating manifests")}
+	}
+
+	watcherContext.RunWatcher()
+
+	return nil
+}
+
+// promoteWatchedEdges returns the watch.PromotionFacility that promotes the
+// edges of a pushed image like RunPromoteCmd does. Each promotion gets its own
+// sync context, bounded by '--timeout'.
+func (o *RunOptions) promoteWatchedEdges(
+	mfests []reg.Manifest,
+) watch.PromotionFacility {
+	return func(edges map[reg.PromotionEdge]interface{}) error {
+		ctx := context.Background()
+		if o.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, o.Timeout)
+			defer cancel()
+		}
+
+		sc, err := o.makeSyncContext(mfests)
+		if err != nil {
+			return err
+		}
+
+		if err := o.configureSyncContext(ctx, &sc); err != nil {
+			return err
+		}
+
+		err = o.promoteEdges(ctx, &sc, edges)
+		if errors.Is(err, errEdgeFiltering) {
+			// The registries disagree with the manifests (e.g., a tag would
+			// move), which redelivering the push does not change.
+			return &watch.RejectedError{Err: err}
+		}
+
+		return err
+	}
+}
+
+// validateWatchOptions validates the options of 'cip watch'. The watcher
+// promotes like 'cip run', but only the pushed images, for as long as it runs;
+// the options that select what a single run reads, or that make it print or
+// write its outcome instead of (or besides) promoting, are rejected.
+func validateWatchOptions(o *RunOptions) error {
+	if (o.Manifest == "") == (o.ThinManifestDir == "") {
+		return errors.Errorf(
+			"exactly one of '--%s' or '--%s' is required",
+			PromoterManifestFlag,
+			PromoterThinManifestDirFlag,
+		)
+	}
+
+	for flag, set := range map[string]bool{
+		PromoterThinManifestArchiveFlag:     o.ThinManifestArchive != "",
+		PromoterChangedManifestsFlag:        o.ChangedManifests != "",
+		PromoterSinceRefFlag:                o.SinceRef != "",
+		PromoterSnapshotFlag:                o.Snapshot != "",
+		PromoterSnapshotRegistryFlag:        o.SnapshotRegistry != "",
+		PromoterManifestBasedSnapshotOfFlag: o.ManifestBasedSnapshotOf != "",
+		// A static inventory would go stale while the watcher runs.
+		PromoterInventoryFromFlag:      o.InventoryFrom != "",
+		PromoterParseOnlyFlag:          o.ParseOnly,
+		PromoterPrintEdgesFlag:         o.PrintEdges,
+		PromoterPrintConfigFlag:        o.PrintConfig != "",
+		PromoterProjectedInventoryFlag: o.ProjectedInventory,
+		PromoterCostTableFlag:          o.CostTable != "",
+		PromoterExpectedEdgesFlag:      o.ExpectedEdges != "",
+		PromoterDryRunCommandsOutFlag:  o.DryRunCommandsOut != "",
+		PromoterChangelogFlag:          o.Changelog != "",
+		"json-log-summary":             o.JSONLogSummary,
+		// Enforcing a severity threshold only checks, and never promotes.
+		"vuln-severity-threshold": o.vulnCheckOnly(),
+	} {
+		if set {
+			return errors.Errorf(
+				"'--%s' cannot be used with 'cip watch'",
+				flag,
+			)
+		}
+	}
+
+	return nil
+}
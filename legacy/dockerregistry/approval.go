@@ -0,0 +1,223 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultApprovalTimeout is how long HTTPApprovalClient waits for the answer
+// to each request, unless it is given a Client of its own.
+const DefaultApprovalTimeout = 30 * time.Second
+
+// Approval is the answer of an ApprovalClient for a single promotion.
+type Approval struct {
+	Approved bool `json:"approved"`
+	// Reason optionally explains the decision (e.g., who approved it).
+	Reason string `json:"reason,omitempty"`
+}
+
+// ApprovalClient asks an external system whether promotions to registries
+// that are marked with 'requiresApproval: true' may proceed.
+type ApprovalClient interface {
+	Approve(ctx context.Context, edge PromotionEdgeJSON) (Approval, error)
+}
+
+// FilterApprovedEdges returns those edges that may be promoted: the edges to
+// registries that do not require approval, and the edges to registries that do
+// which the ApprovalClient approved. Denied edges are skipped and recorded as
+// warnings. It is an error if approval is required but there is no client, or
+// if the client fails.
+func (sc *SyncContext) FilterApprovedEdges(
+	edges map[PromotionEdge]interface{},
+	client ApprovalClient,
+) (map[PromotionEdge]interface{}, error) {
+	approved := make(map[PromotionEdge]interface{})
+	for _, edge := range SortedPromotionEdges(edges) {
+		if !edge.DstRegistry.RequiresApproval {
+			approved[edge] = nil
+			continue
+		}
+
+		// Edges that Promote() leaves out do not need to be approved.
+		edgeJSON, ok := sc.promotionEdgeJSON(edge)
+		if !ok {
+			approved[edge] = nil
+			continue
+		}
+
+		if client == nil {
+			return nil, fmt.Errorf(
+				"registry %q requires approval, but no approval endpoint is configured",
+				edge.DstRegistry.Name)
+		}
+
+		dst := ToFQIN(edge.DstRegistry.Name, edge.DstImageTag.ImageName, edge.Digest)
+		if edge.DstImageTag.Tag != "" {
+			dst = ToPQIN(edge.DstRegistry.Name, edge.DstImageTag.ImageName, edge.DstImageTag.Tag)
+		}
+
+		approval, err := client.Approve(sc.ctx(), edgeJSON)
+		if err != nil {
+			return nil, fmt.Errorf("requesting approval for %s: %w", dst, err)
+		}
+
+		if !approval.Approved {
+			err := fmt.Errorf("promotion to %s was not approved", dst)
+			if approval.Reason != "" {
+				err = fmt.Errorf("%w: %s", err, approval.Reason)
+			}
+
+			logrus.Warnf("skipping: %v", err)
+			sc.Logs.Warnings = append(sc.Logs.Warnings, Error{
+				Context: "approving promotions",
+				Error:   err,
+			})
+
+			continue
+		}
+
+		logrus.Infof("promotion to %s was approved", dst)
+		approved[edge] = nil
+	}
+
+	return approved, nil
+}
+
+// FakeApprovalClient approves the promotions of the images in Approved, and
+// denies all others, for testing.
+type FakeApprovalClient struct {
+	mutex     sync.Mutex
+	requested []PromotionEdgeJSON
+	// Approved holds the (destination) names of the images whose promotions
+	// are approved.
+	Approved map[ImageName]bool
+	// Err, if set, is returned by every call to Approve.
+	Err error
+}
+
+// Approve implements ApprovalClient.
+func (c *FakeApprovalClient) Approve(
+	ctx context.Context,
+	edge PromotionEdgeJSON,
+) (Approval, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.requested = append(c.requested, edge)
+	if c.Err != nil {
+		return Approval{}, c.Err
+	}
+
+	return Approval{Approved: c.Approved[edge.Image]}, nil
+}
+
+// Requested returns the edges whose approval was requested so far.
+func (c *FakeApprovalClient) Requested() []PromotionEdgeJSON {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	requested := make([]PromotionEdgeJSON, len(c.requested))
+	copy(requested, c.requested)
+
+	return requested
+}
+
+// HTTPApprovalClient asks an approval API for each promotion, by POSTing the
+// edge as JSON (see PromotionEdgeJSON) to the endpoint. The API is expected to
+// reply with "200 OK" and an Approval as JSON, e.g. {"approved": true}.
+type HTTPApprovalClient struct {
+	Endpoint string
+	// Client is the HTTP client to use; a client that times out after
+	// DefaultApprovalTimeout is used if it is not set.
+	Client *http.Client
+}
+
+// MkHTTPApprovalClient creates an HTTPApprovalClient for the given endpoint,
+// which must be an absolute http(s) URL.
+func MkHTTPApprovalClient(endpoint string) (*HTTPApprovalClient, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("parsing approval endpoint: %w", err)
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf(
+			"invalid approval endpoint %q (expected an http(s) URL)",
+			endpoint)
+	}
+
+	return &HTTPApprovalClient{
+		Endpoint: endpoint,
+		Client:   &http.Client{Timeout: DefaultApprovalTimeout},
+	}, nil
+}
+
+// Approve implements ApprovalClient.
+func (c *HTTPApprovalClient) Approve(
+	ctx context.Context,
+	edge PromotionEdgeJSON,
+) (Approval, error) {
+	body, err := json.Marshal(edge)
+	if err != nil {
+		return Approval{}, err
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		c.Endpoint,
+		bytes.NewReader(body),
+	)
+	if err != nil {
+		return Approval{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := c.Client
+	if client == nil {
+		client = &http.Client{Timeout: DefaultApprovalTimeout}
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return Approval{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return Approval{}, fmt.Errorf(
+			"approval endpoint returned %s",
+			res.Status)
+	}
+
+	var approval Approval
+	if err := json.NewDecoder(res.Body).Decode(&approval); err != nil {
+		return Approval{}, fmt.Errorf("parsing approval: %w", err)
+	}
+
+	return approval, nil
+}
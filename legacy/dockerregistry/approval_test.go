@@ -0,0 +1,222 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+func TestFilterApprovedEdges(t *testing.T) {
+	srcRC := reg.RegistryContext{
+		Name: "gcr.io/staging",
+		Src:  true,
+	}
+	testRC := reg.RegistryContext{
+		Name: "gcr.io/test",
+	}
+	prodRC := reg.RegistryContext{
+		Name:             "gcr.io/prod",
+		RequiresApproval: true,
+	}
+
+	mkEdge := func(
+		dst reg.RegistryContext,
+		image reg.ImageName,
+	) reg.PromotionEdge {
+		return reg.PromotionEdge{
+			SrcRegistry: srcRC,
+			SrcImageTag: reg.ImageTag{ImageName: image, Tag: "1.0"},
+			Digest:      "sha256:000",
+			DstRegistry: dst,
+			DstImageTag: reg.ImageTag{ImageName: image, Tag: "1.0"},
+		}
+	}
+
+	edges := map[reg.PromotionEdge]interface{}{
+		mkEdge(testRC, "a"): nil,
+		mkEdge(testRC, "b"): nil,
+		mkEdge(prodRC, "a"): nil,
+		mkEdge(prodRC, "b"): nil,
+	}
+
+	tests := []struct {
+		name              string
+		client            *reg.FakeApprovalClient
+		expected          map[reg.PromotionEdge]interface{}
+		expectedRequested int
+		expectedWarnings  int
+		expectedErr       bool
+	}{
+		{
+			"Approved and denied edges",
+			&reg.FakeApprovalClient{
+				Approved: map[reg.ImageName]bool{"a": true},
+			},
+			map[reg.PromotionEdge]interface{}{
+				mkEdge(testRC, "a"): nil,
+				mkEdge(testRC, "b"): nil,
+				mkEdge(prodRC, "a"): nil,
+			},
+			2,
+			1,
+			false,
+		},
+		{
+			"All denied",
+			&reg.FakeApprovalClient{},
+			map[reg.PromotionEdge]interface{}{
+				mkEdge(testRC, "a"): nil,
+				mkEdge(testRC, "b"): nil,
+			},
+			2,
+			2,
+			false,
+		},
+		{
+			"Approval API failure",
+			&reg.FakeApprovalClient{
+				Err: errors.New("unavailable"),
+			},
+			nil,
+			1,
+			0,
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		sc := reg.SyncContext{Inv: reg.MasterInventory{}}
+
+		got, err := sc.FilterApprovedEdges(edges, test.client)
+		require.Len(t, test.client.Requested(), test.expectedRequested, test.name)
+		if test.expectedErr {
+			require.NotNil(t, err, test.name)
+			continue
+		}
+
+		require.Nil(t, err, test.name)
+		require.Equal(t, test.expected, got, test.name)
+		require.Len(t, sc.Logs.Warnings, test.expectedWarnings, test.name)
+	}
+
+	// Without a client, edges that require approval are an error, while all
+	// other edges pass.
+	sc := reg.SyncContext{Inv: reg.MasterInventory{}}
+	_, err := sc.FilterApprovedEdges(edges, nil)
+	require.NotNil(t, err)
+
+	got, err := sc.FilterApprovedEdges(
+		map[reg.PromotionEdge]interface{}{mkEdge(testRC, "a"): nil},
+		nil,
+	)
+	require.Nil(t, err)
+	require.Len(t, got, 1)
+}
+
+func TestHTTPApprovalClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			var edge reg.PromotionEdgeJSON
+			if err := json.NewDecoder(r.Body).Decode(&edge); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			switch edge.Image {
+			case "approved":
+				fmt.Fprint(w, `{"approved": true}`)
+			case "denied":
+				fmt.Fprint(w, `{"approved": false, "reason": "no ticket"}`)
+			case "hung":
+				// Never answer.
+				<-r.Context().Done()
+			default:
+				http.Error(w, "unknown image", http.StatusInternalServerError)
+			}
+		},
+	))
+	defer server.Close()
+
+	client, err := reg.MkHTTPApprovalClient(server.URL)
+	require.Nil(t, err)
+	require.Equal(t, reg.DefaultApprovalTimeout, client.Client.Timeout)
+
+	tests := []struct {
+		name        string
+		image       reg.ImageName
+		expected    reg.Approval
+		expectedErr bool
+	}{
+		{
+			"Approved",
+			"approved",
+			reg.Approval{Approved: true},
+			false,
+		},
+		{
+			"Denied",
+			"denied",
+			reg.Approval{Approved: false, Reason: "no ticket"},
+			false,
+		},
+		{
+			"Error status",
+			"other",
+			reg.Approval{},
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		got, err := client.Approve(
+			context.Background(),
+			reg.PromotionEdgeJSON{Image: test.image},
+		)
+		if test.expectedErr {
+			require.NotNil(t, err, test.name)
+			continue
+		}
+
+		require.Nil(t, err, test.name)
+		require.Equal(t, test.expected, got, test.name)
+	}
+
+	// An API that does not answer times out.
+	client.Client.Timeout = 50 * time.Millisecond
+	_, err = client.Approve(
+		context.Background(),
+		reg.PromotionEdgeJSON{Image: "hung"},
+	)
+	require.NotNil(t, err)
+	var netErr net.Error
+	require.True(t, errors.As(err, &netErr) && netErr.Timeout(), err.Error())
+
+	_, err = reg.MkHTTPApprovalClient("gcr.io/not-a-url")
+	require.NotNil(t, err)
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ParseThinManifestsFromArchive parses all thin Manifest files within a
+// gzipped tarball. The tarball is extracted into a temporary directory (which
+// is removed again before returning) and must have the same layout as a
+// directory passed to ParseThinManifestsFromDir, either at its root or inside
+// a single toplevel folder. The manifests are parsed with the given options.
+func ParseThinManifestsFromArchive(
+	archive string,
+	opts ThinManifestOptions,
+) ([]Manifest, error) {
+	tmpDir, err := ioutil.TempDir("", "cip-thin-manifests-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := extractTarGz(archive, tmpDir); err != nil {
+		return nil, fmt.Errorf("could not extract %q: %v", archive, err)
+	}
+
+	dir, err := findThinManifestRoot(tmpDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not find thin manifests in %q: %v",
+			archive, err)
+	}
+
+	mfests, err := ParseThinManifestsFromDirWithOptions(dir, opts)
+	var invalid *InvalidManifestsError
+	if err != nil && !errors.As(err, &invalid) {
+		return nil, err
+	}
+
+	// The extracted files are about to be removed, so point to the manifests
+	// (and to those that were skipped) within the archive instead.
+	for i := range mfests {
+		rel, err := filepath.Rel(dir, mfests[i].Filepath)
+		if err != nil {
+			return nil, err
+		}
+		mfests[i].Filepath = filepath.Join(archive, rel)
+	}
+
+	if invalid != nil {
+		for _, mErr := range invalid.Skipped {
+			if rel, err := filepath.Rel(dir, mErr.Filepath); err == nil {
+				mErr.Filepath = filepath.Join(archive, rel)
+			}
+		}
+	}
+
+	return mfests, err
+}
+
+// extractTarGz extracts the regular files and directories of a gzipped
+// tarball into dst. Entries that would end up outside of dst, as well as links
+// and other special files, are rejected.
+func extractTarGz(archive, dst string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := filepath.Clean(hdr.Name)
+		if filepath.IsAbs(name) ||
+			name == ".." ||
+			strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("illegal path %q", hdr.Name)
+		}
+		target := filepath.Join(dst, name)
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			if err := writeFileFrom(target, tr); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported entry %q (type %q)",
+				hdr.Name, hdr.Typeflag)
+		}
+	}
+}
+
+func writeFileFrom(path string, r io.Reader) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// findThinManifestRoot returns dir if it has a "manifests" subfolder. Otherwise,
+// if dir contains exactly one folder, it returns that folder instead (tarballs
+// are often created from the parent of the directory to archive).
+func findThinManifestRoot(dir string) (string, error) {
+	if _, err := os.Stat(filepath.Join(dir, "manifests")); err == nil {
+		return dir, nil
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	if len(entries) == 1 && entries[0].IsDir() {
+		return filepath.Join(dir, entries[0].Name()), nil
+	}
+
+	return "", fmt.Errorf("no %q folder found", "manifests")
+}
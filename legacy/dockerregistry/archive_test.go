@@ -0,0 +1,150 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+// writeTarGz archives the contents of srcDir into a gzipped tarball at
+// archive, placing every entry under prefix.
+func writeTarGz(t *testing.T, srcDir, prefix, archive string) {
+	f, err := os.Create(archive)
+	require.Nil(t, err)
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	err = filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(filepath.Join(prefix, rel))
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(b)
+		return err
+	})
+	require.Nil(t, err)
+
+	require.Nil(t, tw.Close())
+	require.Nil(t, gz.Close())
+}
+
+func TestParseThinManifestsFromArchive(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		prefix string
+	}{
+		{
+			"Singleton at archive root",
+			"singleton",
+			"",
+		},
+		{
+			"Nested manifests at archive root",
+			"multiple-rebases",
+			"",
+		},
+		{
+			"Nested manifests in toplevel folder",
+			"multiple-rebases",
+			"thin-manifests",
+		},
+		{
+			"Defaults in toplevel folder",
+			"defaults",
+			"thin-manifests",
+		},
+	}
+
+	for _, test := range tests {
+		dir := getTestPath("TestParseThinManifestsFromDir", test.input)
+		archive := filepath.Join(t.TempDir(), "manifests.tar.gz")
+		writeTarGz(t, dir, test.prefix, archive)
+
+		expected, err := reg.ParseThinManifestsFromDir(dir)
+		require.Nil(t, err, test.name)
+		for i := range expected {
+			rel, err := filepath.Rel(dir, expected[i].Filepath)
+			require.Nil(t, err)
+			expected[i].Filepath = filepath.Join(archive, rel)
+		}
+
+		got, err := reg.ParseThinManifestsFromArchive(archive, reg.ThinManifestOptions{})
+		require.Nil(t, err, test.name)
+		require.Equal(t, expected, got, test.name)
+	}
+}
+
+func TestParseThinManifestsFromArchiveInvalid(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// An archive without any thin manifests.
+	empty := filepath.Join(tmpDir, "empty.tar.gz")
+	writeTarGz(t, t.TempDir(), "", empty)
+	_, err := reg.ParseThinManifestsFromArchive(empty, reg.ThinManifestOptions{})
+	require.NotNil(t, err)
+
+	// A file that is not a gzipped tarball.
+	notAnArchive := filepath.Join(tmpDir, "not-an-archive.tar.gz")
+	require.Nil(t, os.WriteFile(notAnArchive, []byte("foo"), 0o644))
+	_, err = reg.ParseThinManifestsFromArchive(notAnArchive, reg.ThinManifestOptions{})
+	require.NotNil(t, err)
+
+	// An archive with entries outside of the extraction directory.
+	escaping := filepath.Join(tmpDir, "escaping.tar.gz")
+	writeTarGz(
+		t,
+		getTestPath("TestParseThinManifestsFromDir", "singleton"),
+		"../escaped",
+		escaping,
+	)
+	_, err = reg.ParseThinManifestsFromArchive(escaping, reg.ThinManifestOptions{})
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "illegal path")
+}
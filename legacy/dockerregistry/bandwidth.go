@@ -0,0 +1,132 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BandwidthLimiter caps the rate at which bytes are transferred through the
+// HTTP transports it wraps (see Transport()). The limit is shared by all of
+// them, so that concurrent copies together stay within it.
+type BandwidthLimiter struct {
+	bytesPerSecond int64
+
+	mutex sync.Mutex
+	// next is the time at which the bytes transferred so far may be done.
+	next time.Time
+}
+
+// MkBandwidthLimiter returns a BandwidthLimiter that allows the given number of
+// bytes per second, which must be positive.
+func MkBandwidthLimiter(bytesPerSecond int64) *BandwidthLimiter {
+	return &BandwidthLimiter{bytesPerSecond: bytesPerSecond}
+}
+
+// String returns the limit in a human-readable form.
+func (l *BandwidthLimiter) String() string {
+	return fmt.Sprintf("%d bytes/s", l.bytesPerSecond)
+}
+
+// Transport wraps base so that the bodies of its requests (uploads) and
+// responses (downloads) are throttled.
+func (l *BandwidthLimiter) Transport(base http.RoundTripper) http.RoundTripper {
+	return &throttledTransport{limiter: l, base: base}
+}
+
+// wait blocks until n more bytes may be transferred, or the context is done.
+func (l *BandwidthLimiter) wait(ctx context.Context, n int) error {
+	if n <= 0 {
+		return nil
+	}
+
+	l.mutex.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(
+		time.Duration(int64(n) * int64(time.Second) / l.bytesPerSecond))
+	delay := l.next.Sub(now)
+	l.mutex.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// throttledTransport is an http.RoundTripper whose request and response
+// bodies are throttled by a BandwidthLimiter.
+type throttledTransport struct {
+	limiter *BandwidthLimiter
+	base    http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *throttledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if req.Body != nil {
+		req = req.Clone(ctx)
+		req.Body = &throttledReadCloser{
+			ctx:     ctx,
+			limiter: t.limiter,
+			rc:      req.Body,
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body = &throttledReadCloser{
+		ctx:     ctx,
+		limiter: t.limiter,
+		rc:      resp.Body,
+	}
+	return resp, nil
+}
+
+// throttledReadCloser waits for the BandwidthLimiter after every read.
+type throttledReadCloser struct {
+	ctx     context.Context
+	limiter *BandwidthLimiter
+	rc      io.ReadCloser
+}
+
+func (r *throttledReadCloser) Read(p []byte) (int, error) {
+	n, err := r.rc.Read(p)
+	if errWait := r.limiter.wait(r.ctx, n); errWait != nil && err == nil {
+		err = errWait
+	}
+
+	return n, err
+}
+
+func (r *throttledReadCloser) Close() error {
+	return r.rc.Close()
+}
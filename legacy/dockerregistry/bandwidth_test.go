@@ -0,0 +1,150 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+func TestBandwidthLimiter(t *testing.T) {
+	const size = 20000
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				// Consume the upload, and respond with nothing.
+				_, _ = ioutil.ReadAll(r.Body)
+				return
+			}
+			_, _ = w.Write(bytes.Repeat([]byte("x"), size))
+		}))
+	defer server.Close()
+
+	// At 100000 bytes/s, transferring 20000 bytes takes at least 200ms.
+	const bytesPerSecond = 100000
+	minDuration := 190 * time.Millisecond
+
+	tests := []struct {
+		name     string
+		transfer func(client *http.Client) error
+	}{
+		{
+			"Download",
+			func(client *http.Client) error {
+				resp, err := client.Get(server.URL)
+				if err != nil {
+					return err
+				}
+				defer resp.Body.Close()
+
+				b, err := ioutil.ReadAll(resp.Body)
+				if err == nil && len(b) != size {
+					return context.Canceled
+				}
+				return err
+			},
+		},
+		{
+			"Upload",
+			func(client *http.Client) error {
+				resp, err := client.Post(
+					server.URL,
+					"application/octet-stream",
+					bytes.NewReader(bytes.Repeat([]byte("x"), size)))
+				if err != nil {
+					return err
+				}
+				return resp.Body.Close()
+			},
+		},
+		{
+			"Concurrent downloads share the limit",
+			func(client *http.Client) error {
+				errs := make([]error, 2)
+				var wg sync.WaitGroup
+				for i := range errs {
+					wg.Add(1)
+					go func(i int) {
+						defer wg.Done()
+						resp, err := client.Get(server.URL + "/half")
+						if err != nil {
+							errs[i] = err
+							return
+						}
+						defer resp.Body.Close()
+						_, errs[i] = ioutil.ReadAll(resp.Body)
+					}(i)
+				}
+				wg.Wait()
+
+				for _, err := range errs {
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		limiter := reg.MkBandwidthLimiter(bytesPerSecond)
+		client := &http.Client{Transport: limiter.Transport(http.DefaultTransport)}
+
+		start := time.Now()
+		require.Nil(t, test.transfer(client), test.name)
+		require.GreaterOrEqual(t, time.Since(start), minDuration, test.name)
+	}
+}
+
+func TestBandwidthLimiterCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write(bytes.Repeat([]byte("x"), 100000))
+		}))
+	defer server.Close()
+
+	// The whole body would take 10s; the context must cut that short.
+	limiter := reg.MkBandwidthLimiter(10000)
+	client := &http.Client{Transport: limiter.Transport(http.DefaultTransport)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	require.Nil(t, err)
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+
+	_, err = ioutil.ReadAll(resp.Body)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), 5*time.Second)
+	require.Equal(t, "10000 bytes/s", limiter.String())
+}
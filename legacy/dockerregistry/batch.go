@@ -0,0 +1,51 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// batchDone is true if the given number of dispatched promotion requests ends
+// a batch (see SyncContext.BatchSize).
+func (sc *SyncContext) batchDone(dispatched int) bool {
+	return sc.BatchSize > 0 && dispatched > 0 && dispatched%sc.BatchSize == 0
+}
+
+// awaitBatch waits for the requests of the batch that was just dispatched to
+// finish, and then pauses for sc.BatchPause before the next batch. The pause
+// ends early once the context of sc is done; the requests that are left are
+// then cancelled as usual. There is no pause in dry runs.
+func (sc *SyncContext) awaitBatch(wg *sync.WaitGroup, batch int) {
+	wg.Wait()
+
+	if sc.DryRun || sc.BatchPause <= 0 {
+		return
+	}
+
+	logrus.Infof(
+		"Batch %d done; pausing for %v before the next batch",
+		batch,
+		sc.BatchPause)
+
+	select {
+	case <-sc.clock().After(sc.BatchPause):
+	case <-sc.ctx().Done():
+	}
+}
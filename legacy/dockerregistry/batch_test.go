@@ -0,0 +1,134 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/testregistry"
+)
+
+// mkBatchSyncContext pushes the images "a" to "e" to the "staging" registry of
+// r, and returns the edges that promote them to "prod", along with a
+// SyncContext that promotes them in batches.
+func mkBatchSyncContext(
+	t *testing.T,
+	r *testregistry.Registry,
+	batchSize int,
+	batchPause time.Duration,
+) (*reg.SyncContext, map[reg.PromotionEdge]interface{}) {
+	mfest := reg.Manifest{
+		Registries: []reg.RegistryContext{
+			{Name: r.Name("staging"), Src: true},
+			{Name: r.Name("prod")},
+		},
+	}
+	mfest.SrcRegistry = &mfest.Registries[0]
+
+	for _, image := range []reg.ImageName{"a", "b", "c", "d", "e"} {
+		digest, err := r.PushRandom("staging/"+string(image), "1.0")
+		require.Nil(t, err)
+		mfest.Images = append(mfest.Images, reg.Image{
+			ImageName: image,
+			Dmap:      reg.DigestTags{digest: {"1.0"}},
+		})
+	}
+
+	sc, err := reg.MakeSyncContext([]reg.Manifest{mfest}, 2, false, false)
+	require.Nil(t, err)
+	sc.BatchSize = batchSize
+	sc.BatchPause = batchPause
+
+	edges, err := reg.ToPromotionEdges([]reg.Manifest{mfest})
+	require.Nil(t, err)
+
+	sc.ReadRegistries(sc.RegistryContexts, true, r.MkReadRepositoryCmd)
+	require.Empty(t, sc.Logs.Errors)
+
+	edges, ok := sc.FilterPromotionEdges(edges, false)
+	require.True(t, ok)
+
+	return &sc, edges
+}
+
+// pauseClock is a Clock whose pauses are made by after.
+type pauseClock struct {
+	reg.FakeClock
+	after func(d time.Duration) <-chan time.Time
+}
+
+func (c pauseClock) After(d time.Duration) <-chan time.Time {
+	return c.after(d)
+}
+
+func TestPromoteBatches(t *testing.T) {
+	r := testregistry.New()
+	defer r.Close()
+
+	sc, edges := mkBatchSyncContext(t, r, 2, time.Minute)
+
+	// The fake clock records the pauses, along with the images that were
+	// promoted by then, and returns at once.
+	pauses := []time.Duration{}
+	promoted := []int{}
+	sc.Clock = pauseClock{after: func(d time.Duration) <-chan time.Time {
+		pauses = append(pauses, d)
+		promoted = append(promoted, len(r.Inventory("prod")))
+
+		c := make(chan time.Time, 1)
+		c <- time.Time{}
+		return c
+	}}
+
+	require.Nil(t, sc.Promote(edges, nil, nil))
+	require.Equal(t, 5, sc.Logs.Promotions.Succeeded)
+
+	// There is no pause after the last batch.
+	require.Equal(t, []time.Duration{time.Minute, time.Minute}, pauses)
+	require.Equal(t, []int{2, 4}, promoted)
+	require.Len(t, r.Inventory("prod"), 5)
+}
+
+func TestPromoteBatchesCancel(t *testing.T) {
+	r := testregistry.New()
+	defer r.Close()
+
+	sc, edges := mkBatchSyncContext(t, r, 2, time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sc.Context = ctx
+
+	// The pause never ends by itself, so only the cancellation can end it.
+	sc.Clock = pauseClock{after: func(d time.Duration) <-chan time.Time {
+		cancel()
+		return make(chan time.Time)
+	}}
+
+	err := sc.Promote(edges, nil, nil)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(
+		t,
+		reg.RequestSummary{Succeeded: 2, Cancelled: 3},
+		sc.Logs.Promotions)
+	require.Len(t, r.Inventory("prod"), 2)
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Canonicalize returns a copy of the RegInvImage in which the same logical
+// state is always written the same way: digests are lowercase (digests that
+// only differed in case are merged), every tag is listed once, and images
+// without any digests are left out.
+func (rii RegInvImage) Canonicalize() RegInvImage {
+	canonical := make(RegInvImage)
+	for imageName, digestTags := range rii {
+		for digest, tags := range digestTags {
+			if canonical[imageName] == nil {
+				canonical[imageName] = make(DigestTags)
+			}
+
+			digest = Digest(strings.ToLower(string(digest)))
+			merged, ok := canonical[imageName][digest]
+			if !ok {
+				merged = TagSlice{}
+			}
+			canonical[imageName][digest] = mergeTags(merged, tags)
+		}
+	}
+
+	return canonical
+}
+
+// mergeTags appends those tags to a that it does not already have.
+func mergeTags(a, b TagSlice) TagSlice {
+	seen := a.ToTagSet()
+	for _, tag := range b {
+		if _, ok := seen[tag]; ok {
+			continue
+		}
+		seen[tag] = nil
+		a = append(a, tag)
+	}
+
+	return a
+}
+
+// ToCanonicalYAML is like ToYAML, but writes the canonical form of the
+// RegInvImage (see Canonicalize()), with every tag on its own line, so that
+// snapshots of the same state are identical and those of different states
+// differ line by line.
+func (rii RegInvImage) ToCanonicalYAML() string {
+	canonical := rii.Canonicalize()
+	if len(canonical) == 0 {
+		return "[]\n"
+	}
+
+	return canonical.ToYAML(YamlMarshalingOpts{SplitTagsOverMultipleLines: true})
+}
+
+// canonicalImage is an image of the JSON written by ToCanonicalJSON().
+type canonicalImage struct {
+	Name ImageName           `json:"name"`
+	Dmap map[Digest][]string `json:"dmap"`
+}
+
+// ToCanonicalJSON is like ToCanonicalYAML, but writes the images as an
+// indented JSON list (in the same order, with the same fields).
+func (rii RegInvImage) ToCanonicalJSON() (string, error) {
+	canonical := rii.Canonicalize()
+
+	images := make([]canonicalImage, 0, len(canonical))
+	for _, image := range canonical.ToSorted() {
+		dmap := make(map[Digest][]string)
+		for _, digestEntry := range image.digests {
+			tags := digestEntry.tags
+			if tags == nil {
+				tags = []string{}
+			}
+			dmap[Digest(digestEntry.hash)] = tags
+		}
+
+		images = append(images, canonicalImage{
+			Name: ImageName(image.name),
+			Dmap: dmap,
+		})
+	}
+
+	// Maps are written with their keys sorted.
+	b, err := json.MarshalIndent(images, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return string(b) + "\n", nil
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+func TestToCanonical(t *testing.T) {
+	// nolint[lll]
+	tests := []struct {
+		name   string
+		inputs []reg.RegInvImage
+		golden string
+	}{
+		{
+			"Same state, written differently",
+			[]reg.RegInvImage{
+				{
+					"foo": {
+						"sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa": {"latest", "1.0"},
+						"sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb": {},
+					},
+					"bar": {
+						"sha256:cccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccc": {"v0.1.0"},
+					},
+				},
+				// Uppercase digests, repeated tags and images without digests
+				// do not change the state.
+				{
+					"bar": {
+						"sha256:CCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCC": {"v0.1.0", "v0.1.0"},
+					},
+					"foo": {
+						"sha256:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA": {"1.0", "latest"},
+						"sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa": {"latest"},
+						"sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb": nil,
+					},
+					"empty": {},
+				},
+			},
+			"snapshot",
+		},
+		{
+			"Empty",
+			[]reg.RegInvImage{
+				{},
+				{"empty": {}},
+			},
+			"empty",
+		},
+	}
+
+	for _, test := range tests {
+		expectedYAML, err := os.ReadFile(
+			getTestPath("TestToCanonical", test.golden+".yaml"))
+		require.Nil(t, err)
+		expectedJSON, err := os.ReadFile(
+			getTestPath("TestToCanonical", test.golden+".json"))
+		require.Nil(t, err)
+
+		for _, input := range test.inputs {
+			require.Equal(
+				t,
+				string(expectedYAML),
+				input.ToCanonicalYAML(),
+				test.name)
+
+			gotJSON, err := input.ToCanonicalJSON()
+			require.Nil(t, err)
+			require.Equal(t, string(expectedJSON), gotJSON, test.name)
+		}
+	}
+
+	// The canonical YAML is a valid snapshot of the same state.
+	input := reg.RegInvImage{
+		"foo": {
+			"sha256:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA": {"1.0"},
+		},
+	}
+	images, err := reg.ParseImagesYAML([]byte(input.ToCanonicalYAML()))
+	require.Nil(t, err)
+	require.Equal(
+		t,
+		reg.Images{
+			{
+				ImageName: "foo",
+				Dmap: reg.DigestTags{
+					"sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa": {"1.0"},
+				},
+			},
+		},
+		images)
+}
@@ -0,0 +1,236 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The kinds of changes in a Changelog.
+const (
+	ChangeAdded   = "added"
+	ChangeMoved   = "moved"
+	ChangeRemoved = "removed"
+)
+
+// Change is a change to a tag of a destination image. Changes without a Tag
+// are digests that were added without any tags.
+type Change struct {
+	Registry RegistryName `json:"registry"`
+	Image    ImageName    `json:"image"`
+	Tag      Tag          `json:"tag,omitempty"`
+	// Kind is one of ChangeAdded, ChangeMoved and ChangeRemoved.
+	Kind string `json:"change"`
+	// Digest is the digest that the tag points to after the change (empty if
+	// it was removed).
+	Digest Digest `json:"digest,omitempty"`
+	// PreviousDigest is the digest that the tag pointed to before the change
+	// (empty if it was added).
+	PreviousDigest Digest `json:"previousDigest,omitempty"`
+}
+
+// Changelog is a list of changes, sorted by registry, image and tag.
+type Changelog []Change
+
+// MkChangelog returns the changes that promoting the edges makes to the
+// destination registries, by comparing their inventories in inv to those
+// projected after promotion (see ProjectInventory()).
+func MkChangelog(
+	inv MasterInventory,
+	edges map[PromotionEdge]interface{},
+) Changelog {
+	edgesByDst := make(map[RegistryName][]PromotionEdge)
+	for edge := range edges {
+		edgesByDst[edge.DstRegistry.Name] = append(
+			edgesByDst[edge.DstRegistry.Name],
+			edge)
+	}
+
+	changelog := Changelog{}
+	for dst, dstEdges := range edgesByDst {
+		changelog = append(
+			changelog,
+			DiffInventory(dst, inv[dst], ProjectInventory(inv[dst], dstEdges))...)
+	}
+	changelog.sortChanges()
+
+	return changelog
+}
+
+// DiffInventory returns the changes to the tags of the registry between two
+// of its inventories. Digests that were added without any tags are listed as
+// well.
+func DiffInventory(registry RegistryName, before, after RegInvImage) Changelog {
+	changelog := Changelog{}
+
+	imageNames := make(map[ImageName]interface{})
+	for imageName := range before {
+		imageNames[imageName] = nil
+	}
+	for imageName := range after {
+		imageNames[imageName] = nil
+	}
+
+	for imageName := range imageNames {
+		tagsBefore := before[imageName].ToTagDigests()
+		tagsAfter := after[imageName].ToTagDigests()
+
+		for tag, digest := range tagsAfter {
+			change := Change{
+				Registry: registry,
+				Image:    imageName,
+				Tag:      tag,
+				Digest:   digest,
+			}
+
+			previous, ok := tagsBefore[tag]
+			switch {
+			case !ok:
+				change.Kind = ChangeAdded
+			case previous != digest:
+				change.Kind = ChangeMoved
+				change.PreviousDigest = previous
+			default:
+				continue
+			}
+			changelog = append(changelog, change)
+		}
+
+		for tag, previous := range tagsBefore {
+			if _, ok := tagsAfter[tag]; ok {
+				continue
+			}
+			changelog = append(changelog, Change{
+				Registry:       registry,
+				Image:          imageName,
+				Tag:            tag,
+				Kind:           ChangeRemoved,
+				PreviousDigest: previous,
+			})
+		}
+
+		for digest, tags := range after[imageName] {
+			if _, ok := before[imageName][digest]; ok || len(tags) > 0 {
+				continue
+			}
+			changelog = append(changelog, Change{
+				Registry: registry,
+				Image:    imageName,
+				Kind:     ChangeAdded,
+				Digest:   digest,
+			})
+		}
+	}
+	changelog.sortChanges()
+
+	return changelog
+}
+
+// ToTagDigests returns the digest that each tag of the image points to.
+func (dt DigestTags) ToTagDigests() map[Tag]Digest {
+	tagDigests := make(map[Tag]Digest)
+	for digest, tags := range dt {
+		for _, tag := range tags {
+			tagDigests[tag] = digest
+		}
+	}
+
+	return tagDigests
+}
+
+// sortChanges sorts the changes by registry, image, tag and digest.
+func (c Changelog) sortChanges() {
+	sort.Slice(c, func(i, j int) bool {
+		if c[i].Registry != c[j].Registry {
+			return c[i].Registry < c[j].Registry
+		}
+		if c[i].Image != c[j].Image {
+			return c[i].Image < c[j].Image
+		}
+		if c[i].Tag != c[j].Tag {
+			return c[i].Tag < c[j].Tag
+		}
+		return c[i].Digest < c[j].Digest
+	})
+}
+
+// String describes the change in a sentence, e.g. "image foo: tag latest
+// moved from sha256:a to sha256:b", with the names and digests in backticks.
+func (change Change) String() string {
+	prefix := fmt.Sprintf("image `%s`: ", change.Image)
+
+	if change.Tag == "" {
+		return prefix + fmt.Sprintf(
+			"digest `%s` added without tags",
+			change.Digest)
+	}
+
+	switch change.Kind {
+	case ChangeAdded:
+		return prefix + fmt.Sprintf(
+			"tag `%s` added pointing to `%s`",
+			change.Tag,
+			change.Digest)
+	case ChangeMoved:
+		return prefix + fmt.Sprintf(
+			"tag `%s` moved from `%s` to `%s`",
+			change.Tag,
+			change.PreviousDigest,
+			change.Digest)
+	default:
+		return prefix + fmt.Sprintf(
+			"tag `%s` removed (was pointing to `%s`)",
+			change.Tag,
+			change.PreviousDigest)
+	}
+}
+
+// ToMarkdown writes the changelog as a Markdown document, with a section and a
+// list of changes for every registry that changed.
+func (c Changelog) ToMarkdown() string {
+	var b strings.Builder
+	b.WriteString("# Changelog\n")
+
+	if len(c) == 0 {
+		b.WriteString("\nNo changes.\n")
+		return b.String()
+	}
+
+	var registry RegistryName
+	for _, change := range c {
+		if change.Registry != registry {
+			registry = change.Registry
+			fmt.Fprintf(&b, "\n## %s\n\n", registry)
+		}
+		fmt.Fprintf(&b, "- %s\n", change)
+	}
+
+	return b.String()
+}
+
+// ToJSON writes the changelog as an indented JSON list.
+func (c Changelog) ToJSON() (string, error) {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return string(b) + "\n", nil
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+func TestChangelog(t *testing.T) {
+	srcRC := reg.RegistryContext{
+		Name: "gcr.io/foo",
+		Src:  true,
+	}
+	mkEdge := func(
+		dst reg.RegistryName,
+		imageName reg.ImageName,
+		digest reg.Digest,
+		tag reg.Tag,
+	) reg.PromotionEdge {
+		return reg.PromotionEdge{
+			SrcRegistry: srcRC,
+			SrcImageTag: reg.ImageTag{ImageName: imageName, Tag: tag},
+			Digest:      digest,
+			DstRegistry: reg.RegistryContext{Name: dst},
+			DstImageTag: reg.ImageTag{ImageName: imageName, Tag: tag},
+		}
+	}
+
+	inv := reg.MasterInventory{
+		"gcr.io/bar": {
+			"a": {"sha256:aaa1": {"1.0", "latest"}},
+			"b": {"sha256:bbb1": {"1.0"}},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		edges  map[reg.PromotionEdge]interface{}
+		golden string
+	}{
+		{
+			"Added and moved tags, and an untagged digest",
+			map[reg.PromotionEdge]interface{}{
+				mkEdge("gcr.io/bar", "a", "sha256:aaa1", "1.0"):    nil,
+				mkEdge("gcr.io/bar", "a", "sha256:aaa2", "2.0"):    nil,
+				mkEdge("gcr.io/bar", "a", "sha256:aaa2", "latest"): nil,
+				mkEdge("gcr.io/bar", "c", "sha256:ccc1", ""):       nil,
+				mkEdge("eu.gcr.io/bar", "a", "sha256:aaa2", "2.0"): nil,
+			},
+			"changelog",
+		},
+		{
+			"Nothing changes",
+			map[reg.PromotionEdge]interface{}{
+				mkEdge("gcr.io/bar", "b", "sha256:bbb1", "1.0"): nil,
+			},
+			"empty",
+		},
+	}
+
+	for _, test := range tests {
+		changelog := reg.MkChangelog(inv, test.edges)
+
+		expectedMarkdown, err := os.ReadFile(
+			getTestPath("TestChangelog", test.golden+".md"))
+		require.Nil(t, err)
+		require.Equal(
+			t,
+			string(expectedMarkdown),
+			changelog.ToMarkdown(),
+			test.name)
+
+		expectedJSON, err := os.ReadFile(
+			getTestPath("TestChangelog", test.golden+".json"))
+		require.Nil(t, err)
+		gotJSON, err := changelog.ToJSON()
+		require.Nil(t, err)
+		require.Equal(t, string(expectedJSON), gotJSON, test.name)
+	}
+
+	// The inventory itself is left as it is.
+	require.Equal(
+		t,
+		reg.DigestTags{"sha256:aaa1": {"1.0", "latest"}},
+		inv["gcr.io/bar"]["a"])
+}
+
+func TestDiffInventory(t *testing.T) {
+	before := reg.RegInvImage{
+		"a": {
+			"sha256:aaa1": {"1.0"},
+			"sha256:aaa2": {"old"},
+		},
+	}
+	after := reg.RegInvImage{
+		"a": {
+			"sha256:aaa1": {"1.0"},
+			"sha256:aaa2": {},
+		},
+	}
+
+	require.Equal(
+		t,
+		reg.Changelog{
+			{
+				Registry:       "gcr.io/bar",
+				Image:          "a",
+				Tag:            "old",
+				Kind:           reg.ChangeRemoved,
+				PreviousDigest: "sha256:aaa2",
+			},
+		},
+		reg.DiffInventory("gcr.io/bar", before, after))
+	require.Equal(
+		t,
+		"image `a`: tag `old` removed (was pointing to `sha256:aaa2`)",
+		reg.DiffInventory("gcr.io/bar", before, after)[0].String())
+
+	require.Empty(t, reg.DiffInventory("gcr.io/bar", before, before))
+}
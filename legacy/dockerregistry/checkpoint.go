@@ -0,0 +1,142 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// LoadReadCheckpoint loads a ReadCheckpoint from the given path. If there is
+// no file at the path yet, an empty ReadCheckpoint (which will be persisted to
+// the path) is returned.
+func LoadReadCheckpoint(path string) (*ReadCheckpoint, error) {
+	c := &ReadCheckpoint{
+		Path:             path,
+		Repos:            make(map[RegistryName][]string),
+		Inv:              make(MasterInventory),
+		DigestMediaType:  make(DigestMediaType),
+		DigestImageSize:  make(DigestImageSize),
+		DigestUploadTime: make(DigestUploadTime),
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Save persists the ReadCheckpoint to its Path. The file is replaced
+// atomically, so that an interruption while saving cannot corrupt an earlier
+// checkpoint.
+func (c *ReadCheckpoint) Save() error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(c.Path), filepath.Base(c.Path))
+	if err != nil {
+		return err
+	}
+
+	// nolint[errcheck]
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		// nolint[errcheck]
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), c.Path)
+}
+
+// Remove deletes the persisted ReadCheckpoint. It should be called once all
+// repositories have been read, so that the next read starts from scratch.
+func (c *ReadCheckpoint) Remove() error {
+	err := os.Remove(c.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// UseReadCheckpoint makes ReadRegistries() record its progress in the given
+// ReadCheckpoint. The partial inventory already recorded in the checkpoint is
+// merged into the SyncContext, and the repositories it lists as read are
+// skipped.
+func (sc *SyncContext) UseReadCheckpoint(c *ReadCheckpoint) {
+	for registryName, rii := range c.Inv {
+		if len(sc.Inv[registryName]) == 0 {
+			sc.Inv[registryName] = make(RegInvImage)
+		}
+		for imageName, digestTags := range rii {
+			sc.Inv[registryName][imageName] = digestTags
+		}
+	}
+
+	for digest, mediaType := range c.DigestMediaType {
+		sc.DigestMediaType[digest] = mediaType
+	}
+
+	for digest, size := range c.DigestImageSize {
+		sc.DigestImageSize[digest] = size
+	}
+
+	if sc.DigestUploadTime == nil {
+		sc.DigestUploadTime = make(DigestUploadTime)
+	}
+	for digest, uploaded := range c.DigestUploadTime {
+		sc.DigestUploadTime[digest] = uploaded
+	}
+
+	sc.ReadCheckpoint = c
+}
+
+// recordReadCheckpoint marks the repository as read, and persists the
+// checkpoint along with the inventory read so far. It must be called with the
+// mutex guarding the SyncContext held.
+func (sc *SyncContext) recordReadCheckpoint(
+	rName RegistryName,
+	children []string,
+) error {
+	c := sc.ReadCheckpoint
+	c.Repos[rName] = children
+	c.Inv = sc.Inv
+	c.DigestMediaType = sc.DigestMediaType
+	c.DigestImageSize = sc.DigestImageSize
+	c.DigestUploadTime = sc.DigestUploadTime
+
+	return c.Save()
+}
@@ -0,0 +1,239 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/stream"
+)
+
+// recordingFake is a stream.Fake that records the repositories that were
+// actually read.
+type recordingFake struct {
+	stream.Fake
+	repo  string
+	mutex *sync.Mutex
+	read  map[string]int
+}
+
+func (producer *recordingFake) Produce() (io.Reader, io.Reader, error) {
+	producer.mutex.Lock()
+	producer.read[producer.repo]++
+	producer.mutex.Unlock()
+	return producer.Fake.Produce()
+}
+
+func TestLoadReadCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	// A missing checkpoint is empty.
+	got, err := reg.LoadReadCheckpoint(path)
+	require.Nil(t, err)
+	require.Empty(t, got.Repos)
+	require.Empty(t, got.Inv)
+
+	got.Repos["gcr.io/foo"] = []string{"bar"}
+	got.Inv["gcr.io/foo"] = reg.RegInvImage{
+		"bar": {
+			"sha256:0000000000000000000000000000000000000000000000000000000000000000": {"1.0"},
+		},
+	}
+	got.DigestMediaType["sha256:0000000000000000000000000000000000000000000000000000000000000000"] = "application/vnd.docker.distribution.manifest.v2+json"
+	got.DigestImageSize["sha256:0000000000000000000000000000000000000000000000000000000000000000"] = 123
+	require.Nil(t, got.Save())
+
+	reloaded, err := reg.LoadReadCheckpoint(path)
+	require.Nil(t, err)
+	require.Equal(t, got, reloaded)
+
+	require.Nil(t, reloaded.Remove())
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+
+	// Removing an already removed checkpoint is not an error.
+	require.Nil(t, reloaded.Remove())
+
+	// A corrupt checkpoint is an error.
+	require.Nil(t, os.WriteFile(path, []byte("{"), 0o644))
+	_, err = reg.LoadReadCheckpoint(path)
+	require.NotNil(t, err)
+}
+
+func TestReadRegistriesResumeFromCheckpoint(t *testing.T) {
+	const fakeRegName reg.RegistryName = "gcr.io/foo"
+
+	input := map[string]string{
+		"gcr.io/foo": `{
+  "child": [
+    "addon-resizer",
+    "pause"
+  ],
+  "manifest": {},
+  "name": "foo",
+  "tags": []
+}`,
+		"gcr.io/foo/addon-resizer": `{
+  "child": [],
+  "manifest": {
+    "sha256:b5b2d91319f049143806baeacc886f82f621e9a2550df856b11b5c22db4570a7": {
+      "imageSizeBytes": "12875324",
+      "layerId": "",
+      "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+      "tag": [
+        "latest"
+      ],
+      "timeCreatedMs": "1501774217070",
+      "timeUploadedMs": "1552917295327"
+    }
+  },
+  "name": "foo/addon-resizer",
+  "tags": [
+    "latest"
+  ]
+}`,
+		"gcr.io/foo/pause": `{
+  "child": [
+    "childLevel1"
+  ],
+  "manifest": {
+    "sha256:06fdf10aae2eeeac5a82c213e4693f82ab05b3b09b820fce95a7cac0bbdad534": {
+      "imageSizeBytes": "12875324",
+      "layerId": "",
+      "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+      "tag": [
+        "v1.2.3"
+      ],
+      "timeCreatedMs": "1501774217070",
+      "timeUploadedMs": "1552917295327"
+    }
+  },
+  "name": "foo/pause",
+  "tags": [
+    "v1.2.3"
+  ]
+}`,
+		"gcr.io/foo/pause/childLevel1": `{
+  "child": [],
+  "manifest": {
+    "sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa": {
+      "imageSizeBytes": "12875324",
+      "layerId": "",
+      "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+      "tag": [
+        "aaa"
+      ],
+      "timeCreatedMs": "1501774217070",
+      "timeUploadedMs": "1552917295327"
+    }
+  },
+  "name": "foo/pause/childLevel1",
+  "tags": [
+    "aaa"
+  ]
+}`,
+	}
+
+	expectedOutput := reg.RegInvImage{
+		"addon-resizer": {
+			"sha256:b5b2d91319f049143806baeacc886f82f621e9a2550df856b11b5c22db4570a7": {"latest"},
+		},
+		"pause": {
+			"sha256:06fdf10aae2eeeac5a82c213e4693f82ab05b3b09b820fce95a7cac0bbdad534": {"v1.2.3"},
+		},
+		"pause/childLevel1": {
+			"sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa": {"aaa"},
+		},
+	}
+
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	rcs := []reg.RegistryContext{
+		{
+			Name:           fakeRegName,
+			ServiceAccount: "robot",
+		},
+	}
+
+	mkSyncContext := func() reg.SyncContext {
+		return reg.SyncContext{
+			RegistryContexts: rcs,
+			Inv:              map[reg.RegistryName]reg.RegInvImage{fakeRegName: nil},
+			DigestMediaType:  make(reg.DigestMediaType),
+			DigestImageSize:  make(reg.DigestImageSize),
+		}
+	}
+
+	var mutex sync.Mutex
+	read := make(map[string]int)
+	mkFakeStream := func(sc *reg.SyncContext, rc reg.RegistryContext) stream.Producer {
+		_, domain, repoPath := reg.GetTokenKeyDomainRepoPath(rc.Name)
+		repo := domain + "/" + repoPath
+		fakeHTTPBody, ok := input[repo]
+		require.True(t, ok)
+
+		return &recordingFake{
+			Fake:  stream.Fake{Bytes: []byte(fakeHTTPBody)},
+			repo:  repo,
+			mutex: &mutex,
+			read:  read,
+		}
+	}
+
+	// Simulate an interrupted run, which only got as far as reading the
+	// "pause" repo (but not its child).
+	checkpoint, err := reg.LoadReadCheckpoint(path)
+	require.Nil(t, err)
+	sc := mkSyncContext()
+	sc.UseReadCheckpoint(checkpoint)
+	sc.ReadRegistries(
+		[]reg.RegistryContext{{Name: "gcr.io/foo/pause", ServiceAccount: "robot"}},
+		false,
+		mkFakeStream)
+	require.Equal(t, map[string]int{"gcr.io/foo/pause": 1}, read)
+
+	// Resume. The "pause" repo must not be read again, but its child must
+	// still be read.
+	read = make(map[string]int)
+	checkpoint, err = reg.LoadReadCheckpoint(path)
+	require.Nil(t, err)
+	require.Contains(t, checkpoint.Repos, reg.RegistryName("gcr.io/foo/pause"))
+	sc = mkSyncContext()
+	sc.UseReadCheckpoint(checkpoint)
+	sc.ReadRegistries(rcs, true, mkFakeStream)
+
+	require.Equal(
+		t,
+		map[string]int{
+			"gcr.io/foo":                   1,
+			"gcr.io/foo/addon-resizer":     1,
+			"gcr.io/foo/pause/childLevel1": 1,
+		},
+		read)
+	require.Equal(t, expectedOutput, sc.Inv[fakeRegName])
+	require.Equal(
+		t,
+		12875324,
+		sc.DigestImageSize["sha256:06fdf10aae2eeeac5a82c213e4693f82ab05b3b09b820fce95a7cac0bbdad534"])
+}
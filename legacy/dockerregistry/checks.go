@@ -18,16 +18,33 @@ package inventory
 
 import (
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"path"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	containeranalysis "cloud.google.com/go/containeranalysis/apiv1"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/api/iterator"
 	grafeaspb "google.golang.org/genproto/googleapis/grafeas/v1"
@@ -37,6 +54,189 @@ import (
 	"sigs.k8s.io/k8s-container-image-promoter/legacy/stream"
 )
 
+// Names of the built-in PreChecks.
+const (
+	PreCheckImageSize       = "image-size"
+	PreCheckVuln            = "vuln"
+	PreCheckSignatures      = "signatures"
+	PreCheckImageAge        = "image-age"
+	PreCheckSourceExists    = "source-exists"
+	PreCheckDigestAllowlist = "digest-allowlist"
+)
+
+// DefaultVulnScanPollInterval is how often ImageVulnCheck reads the status of
+// a vulnerability scan that it waits for.
+const DefaultVulnScanPollInterval = 5 * time.Second
+
+var (
+	preCheckFactoriesMutex sync.Mutex
+	preCheckFactories      = make(map[string]PreCheckFactory)
+)
+
+func init() {
+	RegisterPreCheck(
+		PreCheckImageSize,
+		func(
+			sc *SyncContext,
+			edges map[PromotionEdge]interface{},
+			opts PreCheckOptions,
+		) (PreCheck, error) {
+			return MKRealImageSizeCheck(
+				opts.MaxImageSize,
+				edges,
+				sc.DigestImageSize,
+			), nil
+		})
+
+	RegisterPreCheck(
+		PreCheckVuln,
+		func(
+			sc *SyncContext,
+			edges map[PromotionEdge]interface{},
+			opts PreCheckOptions,
+		) (PreCheck, error) {
+			if opts.SeverityThreshold < 0 {
+				return nil, fmt.Errorf(
+					"check %q requires a severity threshold", PreCheckVuln)
+			}
+
+			check := MKImageVulnCheck(
+				*sc,
+				edges,
+				opts.SeverityThreshold,
+				nil,
+				opts.VulnMode,
+			)
+			check.Threads = opts.VulnThreads
+			check.WaitTimeout = opts.VulnWaitTimeout
+
+			return check, nil
+		})
+
+	RegisterPreCheck(
+		PreCheckSignatures,
+		func(
+			sc *SyncContext,
+			edges map[PromotionEdge]interface{},
+			opts PreCheckOptions,
+		) (PreCheck, error) {
+			return MKImageSignatureCheck(
+				*sc,
+				edges,
+				opts.MinSignatures,
+				opts.SignaturePublicKeys,
+				nil,
+			), nil
+		})
+
+	RegisterPreCheck(
+		PreCheckImageAge,
+		func(
+			sc *SyncContext,
+			edges map[PromotionEdge]interface{},
+			opts PreCheckOptions,
+		) (PreCheck, error) {
+			if opts.MaxImageAge <= 0 {
+				return nil, fmt.Errorf(
+					"check %q requires a maximum image age", PreCheckImageAge)
+			}
+
+			return MKImageAgeCheck(
+				*sc,
+				edges,
+				opts.MaxImageAge,
+				nil,
+				opts.ImageAgeMode,
+			), nil
+		})
+
+	RegisterPreCheck(
+		PreCheckSourceExists,
+		func(
+			sc *SyncContext,
+			edges map[PromotionEdge]interface{},
+			opts PreCheckOptions,
+		) (PreCheck, error) {
+			return MKImageSourceExistsCheck(*sc, edges, nil), nil
+		})
+
+	RegisterPreCheck(
+		PreCheckDigestAllowlist,
+		func(
+			sc *SyncContext,
+			edges map[PromotionEdge]interface{},
+			opts PreCheckOptions,
+		) (PreCheck, error) {
+			if len(opts.DigestAllowlist) == 0 {
+				return nil, fmt.Errorf(
+					"check %q requires a digest allowlist",
+					PreCheckDigestAllowlist)
+			}
+
+			return MKDigestAllowlistCheck(edges, opts.DigestAllowlist), nil
+		})
+}
+
+// RegisterPreCheck makes a PreCheck available under the given name, so that it
+// can be enabled with MkPreChecks(). It panics if the name is already taken or
+// the factory is nil (like database/sql.Register()), as this is a programming
+// error.
+func RegisterPreCheck(name string, factory PreCheckFactory) {
+	preCheckFactoriesMutex.Lock()
+	defer preCheckFactoriesMutex.Unlock()
+
+	if factory == nil {
+		panic(fmt.Sprintf("precheck %q: factory is nil", name))
+	}
+	if _, ok := preCheckFactories[name]; ok {
+		panic(fmt.Sprintf("precheck %q: already registered", name))
+	}
+
+	preCheckFactories[name] = factory
+}
+
+// RegisteredPreChecks returns the (sorted) names of all registered PreChecks.
+func RegisteredPreChecks() []string {
+	preCheckFactoriesMutex.Lock()
+	defer preCheckFactoriesMutex.Unlock()
+
+	names := make([]string, 0, len(preCheckFactories))
+	for name := range preCheckFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// MkPreChecks creates the PreChecks registered under the given names, in the
+// given order.
+func MkPreChecks(
+	names []string,
+	sc *SyncContext,
+	edges map[PromotionEdge]interface{},
+	opts PreCheckOptions,
+) ([]PreCheck, error) {
+	preChecks := make([]PreCheck, 0, len(names))
+	for _, name := range names {
+		preCheckFactoriesMutex.Lock()
+		factory, ok := preCheckFactories[name]
+		preCheckFactoriesMutex.Unlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown precheck %q", name)
+		}
+
+		preCheck, err := factory(sc, edges, opts)
+		if err != nil {
+			return nil, fmt.Errorf("precheck %q: %v", name, err)
+		}
+
+		preChecks = append(preChecks, preCheck)
+	}
+
+	return preChecks, nil
+}
+
 // MBToBytes converts a value from MiB to Bytes.
 func MBToBytes(value int) int {
 	const mbToBytesShift = 20
@@ -265,12 +465,14 @@ func MKImageVulnCheck(
 	newPullEdges map[PromotionEdge]interface{},
 	severityThreshold int,
 	fakeVulnProducer ImageVulnProducer,
+	vulnMode VulnMode,
 ) *ImageVulnCheck {
 	return &ImageVulnCheck{
-		syncContext,
-		newPullEdges,
-		severityThreshold,
-		fakeVulnProducer,
+		SyncContext:       syncContext,
+		PullEdges:         newPullEdges,
+		SeverityThreshold: severityThreshold,
+		FakeVulnProducer:  fakeVulnProducer,
+		Mode:              vulnMode,
 	}
 }
 
@@ -304,20 +506,29 @@ func (check *ImageVulnCheck) Run() error {
 	// If no custom ImageVulnProducer is provided, we use the default producer
 	// which is simply a call to the Container Analysis API which lists out
 	// the vulnerability occurrences for a given image
-	var vulnProducer ImageVulnProducer
-	if check.FakeVulnProducer != nil {
-		vulnProducer = check.FakeVulnProducer
-	} else {
+	vulnProducer := check.FakeVulnProducer
+	scanStatusProducer := check.FakeScanStatusProducer
+	if vulnProducer == nil ||
+		(check.WaitTimeout > 0 && scanStatusProducer == nil) {
 		ctx := context.Background()
 		client, err := containeranalysis.NewClient(ctx)
 		if err != nil {
 			return fmt.Errorf("NewClient: %v", err)
 		}
 		defer client.Close()
-		vulnProducer = mkRealVulnProducer(client)
+		if vulnProducer == nil {
+			vulnProducer = mkRealVulnProducer(client)
+		}
+		if scanStatusProducer == nil {
+			scanStatusProducer = mkRealScanStatusProducer(client)
+		}
 	}
 
-	vulnerableImages := make([]string, 0)
+	// The scans only collect the vulnerability occurrences of each image. They
+	// are compared against the severity threshold once all scans are done, so
+	// that the report covers every image regardless of the order in which the
+	// scans finish.
+	scans := make(map[PromotionEdge][]*grafeaspb.Occurrence)
 	var processRequest ProcessRequest = func(
 		sc *SyncContext,
 		reqs chan stream.ExternalRequest,
@@ -328,64 +539,217 @@ func (check *ImageVulnCheck) Run() error {
 			reqRes := RequestResult{Context: req}
 			errors := make(Errors, 0)
 			edge := req.RequestParams.(PromotionEdge)
-			occurrences, err := vulnProducer(edge)
+			var occurrences []*grafeaspb.Occurrence
+			var err error
+			if check.WaitTimeout > 0 {
+				err = check.waitForScan(edge, scanStatusProducer)
+			}
 			if err != nil {
 				errors = append(errors, Error{
-					Context: "error getting vulnerabilities",
+					Context: "error waiting for the vulnerability scan",
 					Error:   err})
-			}
-
-			fixableSevereOccurrences := 0
-			for _, occ := range occurrences {
-				vuln := occ.GetVulnerability()
-				vulnErr := ImageVulnError{
-					edge.SrcImageTag.ImageName,
-					edge.Digest,
-					occ.GetName(),
-					vuln,
-				}
-				// The vulnerability check should only reject a PR if it finds
-				// vulnerabilities that are both fixable and severe
-				if vuln.GetFixAvailable() &&
-					IsSevereOccurrence(vuln, check.SeverityThreshold) {
+			} else {
+				occurrences, err = vulnProducer(edge)
+				if err != nil {
 					errors = append(errors, Error{
-						Context: "Vulnerability Occurrence w/ Fix Available",
-						Error:   vulnErr,
-					})
-					fixableSevereOccurrences++
-				} else {
-					logrus.Error(vulnErr)
+						Context: "error getting vulnerabilities",
+						Error:   err})
 				}
 			}
 
-			if fixableSevereOccurrences > 0 {
-				vulnerableImages = append(vulnerableImages,
-					fmt.Sprintf("%v@%v [%v fixable severe vulnerabilities, "+
-						"%v total]",
-						edge.SrcImageTag.ImageName,
-						edge.Digest,
-						fixableSevereOccurrences,
-						len(occurrences)))
-			}
+			mutex.Lock()
+			scans[edge] = occurrences
+			mutex.Unlock()
 
 			reqRes.Errors = errors
 			requestResults <- reqRes
 		}
 	}
 
+	if check.Threads > 0 {
+		check.SyncContext.Threads = check.Threads
+	}
+
 	err := check.SyncContext.ExecRequests(
 		populateRequests,
 		processRequest,
 	)
-	if err != nil {
-		sort.Strings(vulnerableImages)
+
+	vulnerableImages := check.collectFindings(scans)
+	if err != nil || (check.Mode != VulnModeWarn && len(vulnerableImages) > 0) {
 		return fmt.Errorf("VulnerabilityCheck: "+
 			"The following vulnerable images were found:\n    %v",
 			strings.Join(vulnerableImages, "\n    "))
 	}
+
+	if len(vulnerableImages) > 0 {
+		logrus.Warnf("VulnerabilityCheck (warn mode): "+
+			"The following vulnerable images were found:\n    %v",
+			strings.Join(vulnerableImages, "\n    "))
+	}
 	return nil
 }
 
+// waitForScan reads the status of the vulnerability scan of the source image of
+// edge until the scan is done, or until the WaitTimeout is over. It fails if
+// the scan failed or did not finish in time, as the vulnerabilities of the
+// image would then be incomplete.
+func (check *ImageVulnCheck) waitForScan(
+	edge PromotionEdge,
+	scanStatusProducer ScanStatusProducer,
+) error {
+	pollInterval := check.PollInterval
+	if pollInterval == 0 {
+		pollInterval = DefaultVulnScanPollInterval
+	}
+
+	image := fmt.Sprintf("%s@%s", edge.SrcImageTag.ImageName, edge.Digest)
+	deadline := time.Now().Add(check.WaitTimeout)
+	for {
+		status, err := scanStatusProducer(edge)
+		if err != nil {
+			return fmt.Errorf("reading the scan status of %s: %v", image, err)
+		}
+
+		switch status {
+		case grafeaspb.DiscoveryOccurrence_FINISHED_SUCCESS:
+			return nil
+		case grafeaspb.DiscoveryOccurrence_FINISHED_UNSUPPORTED:
+			logrus.Warnf("the vulnerability scan of %s does not support the image", image)
+			return nil
+		case grafeaspb.DiscoveryOccurrence_FINISHED_FAILED:
+			return fmt.Errorf("the vulnerability scan of %s failed", image)
+		}
+
+		// Scans that have not started yet have no status at all.
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return fmt.Errorf(
+				"the vulnerability scan of %s did not finish within %v (status: %s)",
+				image,
+				check.WaitTimeout,
+				status)
+		}
+
+		logrus.Infof(
+			"waiting for the vulnerability scan of %s (status: %s)",
+			image,
+			status)
+		if remaining < pollInterval {
+			time.Sleep(remaining)
+		} else {
+			time.Sleep(pollInterval)
+		}
+	}
+}
+
+// collectFindings compares the scanned vulnerability occurrences against the
+// severity threshold. It records the fixable and severe ones as Findings, and
+// returns a sorted summary line for each image that has any.
+func (check *ImageVulnCheck) collectFindings(
+	scans map[PromotionEdge][]*grafeaspb.Occurrence,
+) []string {
+	edges := make([]PromotionEdge, 0, len(scans))
+	for edge := range scans {
+		edges = append(edges, edge)
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].SrcImageTag.ImageName != edges[j].SrcImageTag.ImageName {
+			return edges[i].SrcImageTag.ImageName < edges[j].SrcImageTag.ImageName
+		}
+		return edges[i].Digest < edges[j].Digest
+	})
+
+	vulnerableImages := make([]string, 0)
+	for _, edge := range edges {
+		occurrences := scans[edge]
+		fixableSevereOccurrences := 0
+		var maxCVSSScore float32
+		for _, occ := range occurrences {
+			vuln := occ.GetVulnerability()
+			vulnErr := MkImageVulnError(edge, occ)
+			// The vulnerability check should only reject a PR if it finds
+			// vulnerabilities that are both fixable and severe
+			if vuln.GetFixAvailable() &&
+				IsSevereOccurrence(vuln, check.SeverityThreshold) {
+				check.Findings = append(check.Findings, Error{
+					Context: "Vulnerability Occurrence w/ Fix Available",
+					Error:   vulnErr,
+				})
+
+				// In warn mode, the finding does not fail the check.
+				if check.Mode == VulnModeWarn {
+					logrus.Warn(vulnErr)
+				} else {
+					logrus.Error(vulnErr)
+				}
+				fixableSevereOccurrences++
+				if vulnErr.CVSSScore > maxCVSSScore {
+					maxCVSSScore = vulnErr.CVSSScore
+				}
+			} else {
+				logrus.Error(vulnErr)
+			}
+		}
+
+		if fixableSevereOccurrences > 0 {
+			summary := fmt.Sprintf("%v fixable severe vulnerabilities, %v total",
+				fixableSevereOccurrences,
+				len(occurrences))
+			// Occurrences without a CVSS score have a score of 0.
+			if maxCVSSScore > 0 {
+				summary += fmt.Sprintf(", highest CVSS score %.1f", maxCVSSScore)
+			}
+			vulnerableImages = append(vulnerableImages,
+				fmt.Sprintf("%v@%v [%v]",
+					edge.SrcImageTag.ImageName,
+					edge.Digest,
+					summary))
+		}
+	}
+
+	sort.Strings(vulnerableImages)
+	return vulnerableImages
+}
+
+// MkImageVulnError describes a vulnerability occurrence of the source image
+// of edge, with the CVSS score and fix status of the occurrence.
+func MkImageVulnError(edge PromotionEdge, occ *grafeaspb.Occurrence) ImageVulnError {
+	vuln := occ.GetVulnerability()
+	vulnErr := ImageVulnError{
+		ImageName:      edge.SrcImageTag.ImageName,
+		Digest:         edge.Digest,
+		OccurrenceName: occ.GetName(),
+		Vulnerability:  vuln,
+		NoteName:       occ.GetNoteName(),
+		Severity:       vuln.GetSeverity().String(),
+		CVSSScore:      vuln.GetCvssScore(),
+		FixAvailable:   vuln.GetFixAvailable(),
+	}
+
+	effectiveSeverity := vuln.GetEffectiveSeverity()
+	if effectiveSeverity != grafeaspb.Severity_SEVERITY_UNSPECIFIED {
+		vulnErr.EffectiveSeverity = effectiveSeverity.String()
+	}
+
+	for _, issue := range vuln.GetPackageIssue() {
+		fixedVersion := issue.GetFixedVersion().GetFullName()
+		if fixedVersion == "" {
+			continue
+		}
+
+		fixedPackage := issue.GetFixedPackage()
+		if fixedPackage == "" {
+			fixedPackage = issue.GetAffectedPackage()
+		}
+		vulnErr.FixedPackages = append(
+			vulnErr.FixedPackages,
+			fixedPackage+" "+fixedVersion)
+	}
+
+	return vulnErr
+}
+
 // Error is a function of ImageSizeError and implements the error interface.
 func (err ImageVulnError) Error() string {
 	// TODO: Why are we not checking errors here?
@@ -418,41 +782,791 @@ func parseImageProjectID(edge *PromotionEdge) (string, error) {
 // mkRealVulnProducer returns an ImageVulnProducer that gets all vulnerability
 // Occurrences associated with the image represented in the PromotionEdge
 // using the Container Analysis Service client library.
-// nolint[errcheck]
 func mkRealVulnProducer(client *containeranalysis.Client) ImageVulnProducer {
 	return func(
 		edge PromotionEdge,
 	) ([]*grafeaspb.Occurrence, error) {
-		// resourceURL is of the form https://gcr.io/[projectID]/my-image
-		resourceURL := "https://" + path.Join(string(edge.SrcRegistry.Name),
-			string(edge.SrcImageTag.ImageName)) + "@" + string(edge.Digest)
+		return listOccurrences(client, edge, "VULNERABILITY")
+	}
+}
 
-		projectID, err := parseImageProjectID(&edge)
+// mkRealScanStatusProducer returns a ScanStatusProducer that reads the status
+// of the vulnerability scan of the image represented in the PromotionEdge
+// from its discovery Occurrence. Images without one (whose scan has not
+// started yet) have the status ANALYSIS_STATUS_UNSPECIFIED.
+func mkRealScanStatusProducer(
+	client *containeranalysis.Client,
+) ScanStatusProducer {
+	return func(
+		edge PromotionEdge,
+	) (grafeaspb.DiscoveryOccurrence_AnalysisStatus, error) {
+		occurrences, err := listOccurrences(client, edge, "DISCOVERY")
 		if err != nil {
-			return nil, fmt.Errorf("ParsingProjectID: %v", err)
+			return grafeaspb.DiscoveryOccurrence_ANALYSIS_STATUS_UNSPECIFIED, err
 		}
 
-		ctx := context.Background()
+		for _, occ := range occurrences {
+			if discovery := occ.GetDiscovery(); discovery != nil {
+				return discovery.GetAnalysisStatus(), nil
+			}
+		}
+
+		return grafeaspb.DiscoveryOccurrence_ANALYSIS_STATUS_UNSPECIFIED, nil
+	}
+}
+
+// listOccurrences lists the Occurrences of the given kind (e.g.,
+// "VULNERABILITY") of the source image of edge.
+// nolint[errcheck]
+func listOccurrences(
+	client *containeranalysis.Client,
+	edge PromotionEdge,
+	kind string,
+) ([]*grafeaspb.Occurrence, error) {
+	// resourceURL is of the form https://gcr.io/[projectID]/my-image
+	resourceURL := "https://" + path.Join(string(edge.SrcRegistry.Name),
+		string(edge.SrcImageTag.ImageName)) + "@" + string(edge.Digest)
+
+	projectID, err := parseImageProjectID(&edge)
+	if err != nil {
+		return nil, fmt.Errorf("ParsingProjectID: %v", err)
+	}
+
+	ctx := context.Background()
+
+	req := &grafeaspb.ListOccurrencesRequest{
+		Parent: fmt.Sprintf("projects/%s", projectID),
+		Filter: fmt.Sprintf("resourceUrl = %q kind = %q",
+			resourceURL, kind),
+	}
+
+	var occurrenceList []*grafeaspb.Occurrence
+	it := client.GetGrafeasClient().ListOccurrences(ctx, req)
+	for {
+		occ, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("occurrence iteration error: %v", err)
+		}
+		occurrenceList = append(occurrenceList, occ)
+	}
+
+	return occurrenceList, nil
+}
+
+// MKImageSignatureCheck returns an instance of ImageSignatureCheck which
+// checks that the images to be promoted have at least minSignatures valid
+// signatures (or more, if their manifests require it).
+func MKImageSignatureCheck(
+	syncContext SyncContext,
+	newPullEdges map[PromotionEdge]interface{},
+	minSignatures int,
+	publicKeys []crypto.PublicKey,
+	fakeSignatureProducer SignatureProducer,
+) *ImageSignatureCheck {
+	return &ImageSignatureCheck{
+		SyncContext:           syncContext,
+		PullEdges:             newPullEdges,
+		MinSignatures:         minSignatures,
+		PublicKeys:            publicKeys,
+		FakeSignatureProducer: fakeSignatureProducer,
+	}
+}
+
+// requiredSignatures returns the number of valid signatures that the source
+// image of edge must have: the larger of the global and per-image minimums.
+func (check *ImageSignatureCheck) requiredSignatures(edge PromotionEdge) int {
+	if edge.MinSignatures > check.MinSignatures {
+		return edge.MinSignatures
+	}
+
+	return check.MinSignatures
+}
+
+// Run is a function of ImageSignatureCheck and checks that all images to be
+// promoted have enough signatures that can be verified with one of the
+// PublicKeys.
+func (check *ImageSignatureCheck) Run() error {
+	// Every source image only needs to be checked once, against the largest
+	// minimum of all of its edges.
+	type srcImage struct {
+		registry  RegistryName
+		imageName ImageName
+		digest    Digest
+	}
+	srcEdges := make(map[srcImage]PromotionEdge)
+	required := make(map[srcImage]int)
+	for edge := range check.PullEdges {
+		need := check.requiredSignatures(edge)
+		if need <= 0 {
+			continue
+		}
+
+		key := srcImage{
+			edge.SrcRegistry.Name,
+			edge.SrcImageTag.ImageName,
+			edge.Digest,
+		}
+		srcEdges[key] = edge
+		if need > required[key] {
+			required[key] = need
+		}
+	}
+
+	if len(srcEdges) == 0 {
+		return nil
+	}
+
+	if len(check.PublicKeys) == 0 {
+		return fmt.Errorf("SignatureCheck: %d image(s) require signatures, "+
+			"but no public keys to verify them with were given",
+			len(srcEdges))
+	}
+
+	var populateRequests PopulateRequests = func(
+		sc *SyncContext,
+		reqs chan<- stream.ExternalRequest,
+		wg *sync.WaitGroup) {
+		for key := range srcEdges {
+			var req stream.ExternalRequest
+			req.RequestParams = key
+			wg.Add(1)
+			reqs <- req
+		}
+	}
+
+	signatureProducer := check.FakeSignatureProducer
+	if signatureProducer == nil {
+		signatureProducer = mkRealSignatureProducer(&check.SyncContext)
+	}
+
+	unsignedImages := make([]string, 0)
+	var processRequest ProcessRequest = func(
+		sc *SyncContext,
+		reqs chan stream.ExternalRequest,
+		requestResults chan<- RequestResult,
+		wg *sync.WaitGroup,
+		mutex *sync.Mutex) {
+		for req := range reqs {
+			reqRes := RequestResult{Context: req}
+			key := req.RequestParams.(srcImage)
+			edge := srcEdges[key]
+
+			signatures, err := signatureProducer(edge)
+			if err != nil {
+				reqRes.Errors = Errors{{
+					Context: "error getting signatures",
+					Error:   err}}
+			}
+
+			valid := countValidSignatures(
+				signatures,
+				edge.Digest,
+				check.PublicKeys)
+			if need := required[key]; valid < need {
+				mutex.Lock()
+				unsignedImages = append(unsignedImages,
+					fmt.Sprintf("%v/%v@%v [%d of %d required signatures]",
+						key.registry,
+						key.imageName,
+						key.digest,
+						valid,
+						need))
+				mutex.Unlock()
+
+				reqRes.Errors = append(reqRes.Errors, Error{
+					Context: "not enough valid signatures",
+					Error: fmt.Errorf("%v/%v@%v: %d valid signature(s), "+
+						"%d required",
+						key.registry,
+						key.imageName,
+						key.digest,
+						valid,
+						need)})
+			}
+
+			requestResults <- reqRes
+		}
+	}
+
+	err := check.SyncContext.ExecRequests(
+		populateRequests,
+		processRequest,
+	)
+	if err != nil {
+		sort.Strings(unsignedImages)
+		return fmt.Errorf("SignatureCheck: "+
+			"The following images do not have enough valid signatures:\n    %v",
+			strings.Join(unsignedImages, "\n    "))
+	}
+
+	return nil
+}
+
+// simpleSigningPayload is the part of a cosign signature payload ("simple
+// signing" format) that names the signed image.
+type simpleSigningPayload struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+	} `json:"critical"`
+}
+
+// countValidSignatures returns the number of distinct signers of digest: the
+// number of the given public keys that verify at least one of the signatures.
+// Signatures can be randomized, so a single key that signs the same digest
+// several times still only counts once.
+func countValidSignatures(
+	signatures []CosignSignature,
+	digest Digest,
+	publicKeys []crypto.PublicKey,
+) int {
+	signers := make(map[int]bool)
+	for _, signature := range signatures {
+		if i := verifySignature(signature, digest, publicKeys); i >= 0 {
+			signers[i] = true
+		}
+	}
+
+	return len(signers)
+}
+
+// verifySignature checks that signature signs digest, and returns the index of
+// the first of the given public keys whose private key made it, or -1 if there
+// is none. A key that is given more than once is thus always matched at the
+// same index.
+func verifySignature(
+	signature CosignSignature,
+	digest Digest,
+	publicKeys []crypto.PublicKey,
+) int {
+	var payload simpleSigningPayload
+	if err := json.Unmarshal(signature.Payload, &payload); err != nil {
+		return -1
+	}
+	if payload.Critical.Image.DockerManifestDigest != string(digest) {
+		return -1
+	}
+
+	hash := sha256.Sum256(signature.Payload)
+	for i, publicKey := range publicKeys {
+		switch key := publicKey.(type) {
+		case *ecdsa.PublicKey:
+			if ecdsa.VerifyASN1(key, hash[:], signature.Signature) {
+				return i
+			}
+		case *rsa.PublicKey:
+			err := rsa.VerifyPKCS1v15(
+				key,
+				crypto.SHA256,
+				hash[:],
+				signature.Signature)
+			if err == nil {
+				return i
+			}
+		case ed25519.PublicKey:
+			if ed25519.Verify(key, signature.Payload, signature.Signature) {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// LoadPublicKeys reads the PEM-encoded ("PUBLIC KEY") public keys that
+// signatures are verified with from the given files.
+func LoadPublicKeys(paths []string) ([]crypto.PublicKey, error) {
+	publicKeys := make([]crypto.PublicKey, 0, len(paths))
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		block, _ := pem.Decode(b)
+		if block == nil || block.Type != "PUBLIC KEY" {
+			return nil, fmt.Errorf("%s: no PEM-encoded public key found", path)
+		}
+
+		publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+
+		publicKeys = append(publicKeys, publicKey)
+	}
+
+	return publicKeys, nil
+}
+
+// cosignSignatureAnnotation is the annotation of the layers of a signature
+// image that holds the (base64-encoded) signature of the layer.
+const cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+
+// CosignSignatureTag returns the tag under which cosign stores the signatures
+// of the image with the given digest (in the same repository as the image).
+func CosignSignatureTag(digest Digest) Tag {
+	return Tag(strings.Replace(string(digest), ":", "-", 1) + ".sig")
+}
+
+// mkRealSignatureProducer returns a SignatureProducer that reads the cosign
+// signatures of the source image of an edge from the source registry (through
+// its mirror, if any). An image without signatures has none, rather than
+// being an error.
+func mkRealSignatureProducer(sc *SyncContext) SignatureProducer {
+	return func(edge PromotionEdge) ([]CosignSignature, error) {
+		ref, err := name.ParseReference(ToPQIN(
+			sc.MirroredRegistry(edge.SrcRegistry.Name),
+			edge.SrcImageTag.ImageName,
+			CosignSignatureTag(edge.Digest)))
+		if err != nil {
+			return nil, err
+		}
+
+		opts := []remote.Option{
+			remote.WithContext(sc.ctx()),
+			remote.WithUserAgent(sc.userAgent()),
+		}
+		if sc.UseServiceAccount {
+			opts = append(opts, remote.WithAuthFromKeychain(sc.Keychain()))
+		}
+
+		img, err := remote.Image(ref, opts...)
+		if err != nil {
+			var terr *transport.Error
+			if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		manifest, err := img.Manifest()
+		if err != nil {
+			return nil, err
+		}
+
+		signatures := make([]CosignSignature, 0, len(manifest.Layers))
+		for _, desc := range manifest.Layers {
+			encoded, ok := desc.Annotations[cosignSignatureAnnotation]
+			if !ok {
+				continue
+			}
+
+			signature, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				logrus.Warnf("%v: invalid signature in layer %v: %v",
+					ref, desc.Digest, err)
+				continue
+			}
+
+			payload, err := readLayer(img, desc.Digest)
+			if err != nil {
+				return nil, err
+			}
+
+			signatures = append(signatures, CosignSignature{
+				Payload:   payload,
+				Signature: signature,
+			})
+		}
+
+		return signatures, nil
+	}
+}
+
+// readLayer reads the (raw) contents of the layer of img with the given
+// digest.
+func readLayer(img v1.Image, digest v1.Hash) ([]byte, error) {
+	layer, err := img.LayerByDigest(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return ioutil.ReadAll(rc)
+}
+
+// MKImageAgeCheck returns an instance of ImageAgeCheck which checks that the
+// images to be promoted were created at most maxAge ago.
+func MKImageAgeCheck(
+	syncContext SyncContext,
+	newPullEdges map[PromotionEdge]interface{},
+	maxAge time.Duration,
+	fakeCreationTimeProducer CreationTimeProducer,
+	mode ImageAgeMode,
+) *ImageAgeCheck {
+	return &ImageAgeCheck{
+		SyncContext:              syncContext,
+		PullEdges:                newPullEdges,
+		MaxAge:                   maxAge,
+		FakeCreationTimeProducer: fakeCreationTimeProducer,
+		Mode:                     mode,
+	}
+}
+
+// Run is a function of ImageAgeCheck and checks that none of the images to be
+// promoted are older than MaxAge. Images without a creation time (such as
+// those of reproducible builds, which are dated to the Unix epoch) are only
+// logged, as their age is unknown.
+func (check *ImageAgeCheck) Run() error {
+	type srcImage struct {
+		registry  RegistryName
+		imageName ImageName
+		digest    Digest
+	}
+	srcEdges := make(map[srcImage]PromotionEdge)
+	for edge := range check.PullEdges {
+		srcEdges[srcImage{
+			edge.SrcRegistry.Name,
+			edge.SrcImageTag.ImageName,
+			edge.Digest,
+		}] = edge
+	}
+
+	var populateRequests PopulateRequests = func(
+		sc *SyncContext,
+		reqs chan<- stream.ExternalRequest,
+		wg *sync.WaitGroup) {
+		for key := range srcEdges {
+			var req stream.ExternalRequest
+			req.RequestParams = key
+			wg.Add(1)
+			reqs <- req
+		}
+	}
+
+	creationTimeProducer := check.FakeCreationTimeProducer
+	if creationTimeProducer == nil {
+		creationTimeProducer = mkRealCreationTimeProducer(&check.SyncContext)
+	}
+
+	now := check.SyncContext.clock().Now()
+
+	staleImages := make([]string, 0)
+	findings := make(Errors, 0)
+	var processRequest ProcessRequest = func(
+		sc *SyncContext,
+		reqs chan stream.ExternalRequest,
+		requestResults chan<- RequestResult,
+		wg *sync.WaitGroup,
+		mutex *sync.Mutex) {
+		for req := range reqs {
+			reqRes := RequestResult{Context: req}
+			key := req.RequestParams.(srcImage)
+
+			created, err := creationTimeProducer(srcEdges[key])
+			if err != nil {
+				reqRes.Errors = Errors{{
+					Context: "error getting image creation time",
+					Error:   err}}
+				requestResults <- reqRes
+				continue
+			}
+
+			if created.IsZero() || created.Unix() <= 0 {
+				logrus.Infof("ImageAgeCheck: %v/%v@%v has no creation time",
+					key.registry, key.imageName, key.digest)
+				requestResults <- reqRes
+				continue
+			}
+
+			if age := now.Sub(created); age > check.MaxAge {
+				mutex.Lock()
+				staleImages = append(staleImages,
+					fmt.Sprintf("%v/%v@%v [created %v, %v old]",
+						key.registry,
+						key.imageName,
+						key.digest,
+						created.UTC().Format(time.RFC3339),
+						age.Truncate(time.Hour)))
+				findings = append(findings, Error{
+					Context: "Stale image",
+					Error: fmt.Errorf("%v/%v@%v was created %v "+
+						"(more than %v ago)",
+						key.registry,
+						key.imageName,
+						key.digest,
+						created.UTC().Format(time.RFC3339),
+						check.MaxAge)})
+				mutex.Unlock()
+			}
+
+			requestResults <- reqRes
+		}
+	}
+
+	err := check.SyncContext.ExecRequests(
+		populateRequests,
+		processRequest,
+	)
+	if err != nil {
+		return fmt.Errorf("ImageAgeCheck: "+
+			"could not read the creation time of all images: %v", err)
+	}
+
+	sort.Strings(staleImages)
+	sort.Slice(findings, func(i, j int) bool {
+		return findings[i].Error.Error() < findings[j].Error.Error()
+	})
+	check.Findings = findings
+
+	if len(staleImages) == 0 {
+		return nil
+	}
+
+	if check.Mode != ImageAgeModeWarn {
+		return fmt.Errorf("ImageAgeCheck: "+
+			"The following images are older than %v:\n    %v",
+			check.MaxAge,
+			strings.Join(staleImages, "\n    "))
+	}
+
+	logrus.Warnf("ImageAgeCheck (warn mode): "+
+		"The following images are older than %v:\n    %v",
+		check.MaxAge,
+		strings.Join(staleImages, "\n    "))
+	return nil
+}
+
+// mkRealCreationTimeProducer returns a CreationTimeProducer that reads the
+// creation time ("created") from the image config of the source image of an
+// edge. Manifest lists have no image config, so their creation time is that
+// of the newest image that they list.
+func mkRealCreationTimeProducer(sc *SyncContext) CreationTimeProducer {
+	return func(edge PromotionEdge) (time.Time, error) {
+		ref := ToFQIN(
+			edge.SrcRegistry.Name,
+			edge.SrcImageTag.ImageName,
+			edge.Digest)
+		opts := sc.craneOptions()
 
-		req := &grafeaspb.ListOccurrencesRequest{
-			Parent: fmt.Sprintf("projects/%s", projectID),
-			Filter: fmt.Sprintf("resourceUrl = %q kind = %q",
-				resourceURL, "VULNERABILITY"),
+		b, err := crane.Manifest(ref, opts...)
+		if err != nil {
+			return time.Time{}, err
 		}
 
-		var occurrenceList []*grafeaspb.Occurrence
-		it := client.GetGrafeasClient().ListOccurrences(ctx, req)
-		for {
-			occ, err := it.Next()
-			if err == iterator.Done {
-				break
+		var manifest struct {
+			Manifests []struct {
+				Digest string `json:"digest"`
+			} `json:"manifests"`
+		}
+		if err := json.Unmarshal(b, &manifest); err != nil {
+			return time.Time{}, fmt.Errorf("parsing manifest: %v", err)
+		}
+
+		refs := []string{ref}
+		if len(manifest.Manifests) > 0 {
+			refs = refs[:0]
+			for _, child := range manifest.Manifests {
+				refs = append(refs, ToFQIN(
+					edge.SrcRegistry.Name,
+					edge.SrcImageTag.ImageName,
+					Digest(child.Digest)))
 			}
+		}
+
+		var newest time.Time
+		for _, ref := range refs {
+			b, err := crane.Config(ref, opts...)
 			if err != nil {
-				return nil, fmt.Errorf("occurrence iteration error: %v", err)
+				return time.Time{}, err
+			}
+
+			var config struct {
+				Created time.Time `json:"created"`
+			}
+			if err := json.Unmarshal(b, &config); err != nil {
+				return time.Time{}, fmt.Errorf("parsing image config: %v", err)
+			}
+
+			if config.Created.After(newest) {
+				newest = config.Created
 			}
-			occurrenceList = append(occurrenceList, occ)
 		}
 
-		return occurrenceList, nil
+		return newest, nil
+	}
+}
+
+// MKImageSourceExistsCheck returns an instance of ImageSourceExistsCheck which
+// checks that the source images of the edges still exist.
+func MKImageSourceExistsCheck(
+	syncContext SyncContext,
+	newPullEdges map[PromotionEdge]interface{},
+	fakeSourceExistsProducer SourceExistsProducer,
+) *ImageSourceExistsCheck {
+	return &ImageSourceExistsCheck{
+		SyncContext:              syncContext,
+		PullEdges:                newPullEdges,
+		FakeSourceExistsProducer: fakeSourceExistsProducer,
+	}
+}
+
+// Run is a function of ImageSourceExistsCheck and checks that the source image
+// of every edge can still be found in its source registry. The inventories
+// that the edges were filtered with may have been read a while ago, so an
+// image that was deleted since then would otherwise only fail the promotion
+// half-way through.
+func (check *ImageSourceExistsCheck) Run() error {
+	type srcImage struct {
+		registry  RegistryName
+		imageName ImageName
+		digest    Digest
+	}
+	srcEdges := make(map[srcImage]PromotionEdge)
+	for edge := range check.PullEdges {
+		srcEdges[srcImage{
+			edge.SrcRegistry.Name,
+			edge.SrcImageTag.ImageName,
+			edge.Digest,
+		}] = edge
+	}
+
+	var populateRequests PopulateRequests = func(
+		sc *SyncContext,
+		reqs chan<- stream.ExternalRequest,
+		wg *sync.WaitGroup) {
+		for key := range srcEdges {
+			var req stream.ExternalRequest
+			req.RequestParams = key
+			wg.Add(1)
+			reqs <- req
+		}
+	}
+
+	sourceExistsProducer := check.FakeSourceExistsProducer
+	if sourceExistsProducer == nil {
+		sourceExistsProducer = mkRealSourceExistsProducer(&check.SyncContext)
 	}
+
+	missingImages := make([]string, 0)
+	var processRequest ProcessRequest = func(
+		sc *SyncContext,
+		reqs chan stream.ExternalRequest,
+		requestResults chan<- RequestResult,
+		wg *sync.WaitGroup,
+		mutex *sync.Mutex) {
+		for req := range reqs {
+			reqRes := RequestResult{Context: req}
+			key := req.RequestParams.(srcImage)
+
+			exists, err := sourceExistsProducer(srcEdges[key])
+			if err != nil {
+				reqRes.Errors = Errors{{
+					Context: "error looking up source image",
+					Error:   err}}
+			} else if !exists {
+				mutex.Lock()
+				missingImages = append(missingImages,
+					fmt.Sprintf("%v/%v@%v",
+						key.registry,
+						key.imageName,
+						key.digest))
+				mutex.Unlock()
+			}
+
+			requestResults <- reqRes
+		}
+	}
+
+	err := check.SyncContext.ExecRequests(
+		populateRequests,
+		processRequest,
+	)
+	if err != nil {
+		return fmt.Errorf("ImageSourceExistsCheck: "+
+			"could not look up all source images: %v", err)
+	}
+
+	if len(missingImages) > 0 {
+		sort.Strings(missingImages)
+		return fmt.Errorf("ImageSourceExistsCheck: "+
+			"The following source images do not exist:\n    %v",
+			strings.Join(missingImages, "\n    "))
+	}
+
+	return nil
+}
+
+// mkRealSourceExistsProducer returns a SourceExistsProducer that looks up the
+// source image of an edge in the source registry (through its mirror, if any).
+func mkRealSourceExistsProducer(sc *SyncContext) SourceExistsProducer {
+	return func(edge PromotionEdge) (bool, error) {
+		ref, err := name.ParseReference(ToFQIN(
+			sc.MirroredRegistry(edge.SrcRegistry.Name),
+			edge.SrcImageTag.ImageName,
+			edge.Digest))
+		if err != nil {
+			return false, err
+		}
+
+		_, err = remote.Head(
+			ref,
+			append(sc.remoteOptions(), remote.WithContext(sc.ctx()))...)
+		if err != nil {
+			var terr *transport.Error
+			if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+				return false, nil
+			}
+			return false, err
+		}
+
+		return true, nil
+	}
+}
+
+// MKDigestAllowlistCheck returns an instance of DigestAllowlistCheck which
+// checks that only the given digests are promoted.
+func MKDigestAllowlistCheck(
+	newPullEdges map[PromotionEdge]interface{},
+	allowlist []Digest,
+) *DigestAllowlistCheck {
+	allowed := make(map[Digest]bool, len(allowlist))
+	for _, digest := range allowlist {
+		allowed[digest] = true
+	}
+
+	return &DigestAllowlistCheck{
+		PullEdges: newPullEdges,
+		Allowlist: allowed,
+	}
+}
+
+// Run is a function of DigestAllowlistCheck and checks that the digest of
+// every edge is on the Allowlist.
+func (check *DigestAllowlistCheck) Run() error {
+	seen := make(map[string]bool)
+	for edge := range check.PullEdges {
+		if check.Allowlist[edge.Digest] {
+			continue
+		}
+
+		seen[fmt.Sprintf("%v/%v@%v",
+			edge.SrcRegistry.Name,
+			edge.SrcImageTag.ImageName,
+			edge.Digest)] = true
+	}
+
+	if len(seen) == 0 {
+		return nil
+	}
+
+	unlisted := make([]string, 0, len(seen))
+	for image := range seen {
+		unlisted = append(unlisted, image)
+	}
+	sort.Strings(unlisted)
+
+	return fmt.Errorf("DigestAllowlistCheck: "+
+		"The following images are not on the digest allowlist:\n    %v",
+		strings.Join(unlisted, "\n    "))
 }
@@ -17,9 +17,30 @@ limitations under the License.
 package inventory_test
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/stretchr/testify/require"
 	grafeaspb "google.golang.org/genproto/googleapis/grafeas/v1"
 
@@ -566,9 +587,1061 @@ func TestImageVulnCheck(t *testing.T) {
 			test.edges,
 			test.severityThreshold,
 			mkVulnProducerFake(test.vulnerabilities),
+			reg.VulnModeEnforce,
 		)
 
 		got := check.Run()
 		require.Equal(t, got, test.expected)
 	}
+
+	// In warn mode, the same findings must not fail the check, but must still
+	// be recorded.
+	for _, test := range tests {
+		sc := reg.SyncContext{}
+		check := reg.MKImageVulnCheck(
+			sc,
+			test.edges,
+			test.severityThreshold,
+			mkVulnProducerFake(test.vulnerabilities),
+			reg.VulnModeWarn,
+		)
+
+		got := check.Run()
+		require.Nil(t, got)
+		if test.expected != nil {
+			require.NotEmpty(t, check.Findings)
+		} else {
+			require.Empty(t, check.Findings)
+		}
+	}
+}
+
+func TestImageVulnCheckReport(t *testing.T) {
+	edge := reg.PromotionEdge{
+		SrcImageTag: reg.ImageTag{
+			ImageName: "foo",
+		},
+		Digest: "sha256:000",
+	}
+
+	// The occurrences as the Container Analysis API reports them.
+	occurrences := []*grafeaspb.Occurrence{
+		{
+			Name:     "projects/foo/occurrences/1",
+			NoteName: "projects/goog-vulnz/notes/CVE-2021-3711",
+			Details: &grafeaspb.Occurrence_Vulnerability{
+				Vulnerability: &grafeaspb.VulnerabilityOccurrence{
+					Severity:          grafeaspb.Severity_CRITICAL,
+					EffectiveSeverity: grafeaspb.Severity_HIGH,
+					CvssScore:         9.8,
+					FixAvailable:      true,
+					PackageIssue: []*grafeaspb.VulnerabilityOccurrence_PackageIssue{
+						{
+							AffectedPackage: "openssl",
+							FixedVersion: &grafeaspb.Version{
+								FullName: "1.1.1l-r0",
+							},
+						},
+						{
+							AffectedPackage: "libssl1.1",
+							FixedPackage:    "libssl1.1",
+							FixedVersion: &grafeaspb.Version{
+								FullName: "1.1.1l-r0",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:     "projects/foo/occurrences/2",
+			NoteName: "projects/goog-vulnz/notes/CVE-2021-3449",
+			Details: &grafeaspb.Occurrence_Vulnerability{
+				Vulnerability: &grafeaspb.VulnerabilityOccurrence{
+					Severity:     grafeaspb.Severity_HIGH,
+					CvssScore:    5.9,
+					FixAvailable: true,
+				},
+			},
+		},
+		// No fix, so it does not count, even with a higher score.
+		{
+			Name:     "projects/foo/occurrences/3",
+			NoteName: "projects/goog-vulnz/notes/CVE-2022-0001",
+			Details: &grafeaspb.Occurrence_Vulnerability{
+				Vulnerability: &grafeaspb.VulnerabilityOccurrence{
+					Severity:  grafeaspb.Severity_CRITICAL,
+					CvssScore: 10,
+				},
+			},
+		},
+	}
+
+	check := reg.MKImageVulnCheck(
+		reg.SyncContext{},
+		map[reg.PromotionEdge]interface{}{edge: nil},
+		int(grafeaspb.Severity_HIGH),
+		func(reg.PromotionEdge) ([]*grafeaspb.Occurrence, error) {
+			return occurrences, nil
+		},
+		reg.VulnModeEnforce,
+	)
+
+	require.Equal(
+		t,
+		fmt.Errorf("VulnerabilityCheck: The following vulnerable images were found:\n"+
+			"    foo@sha256:000 [2 fixable severe vulnerabilities, 3 total, highest CVSS score 9.8]"),
+		check.Run())
+
+	require.Len(t, check.Findings, 2)
+	vulnErr, ok := check.Findings[0].Error.(reg.ImageVulnError)
+	require.True(t, ok)
+	require.Equal(t, "projects/goog-vulnz/notes/CVE-2021-3711", vulnErr.NoteName)
+	require.Equal(t, "CRITICAL", vulnErr.Severity)
+	require.Equal(t, "HIGH", vulnErr.EffectiveSeverity)
+	require.Equal(t, float32(9.8), vulnErr.CVSSScore)
+	require.True(t, vulnErr.FixAvailable)
+	require.Equal(
+		t,
+		[]string{"openssl 1.1.1l-r0", "libssl1.1 1.1.1l-r0"},
+		vulnErr.FixedPackages)
+
+	// The report carries the new fields.
+	require.Contains(t, vulnErr.Error(), `"CVSSScore": 9.8`)
+	require.Contains(t, vulnErr.Error(), `"FixAvailable": true`)
+
+	vulnErr, ok = check.Findings[1].Error.(reg.ImageVulnError)
+	require.True(t, ok)
+	require.Equal(t, "HIGH", vulnErr.Severity)
+	require.Equal(t, "", vulnErr.EffectiveSeverity)
+	require.Empty(t, vulnErr.FixedPackages)
+}
+
+func TestImageVulnCheckWaitForScan(t *testing.T) {
+	edge := reg.PromotionEdge{
+		SrcImageTag: reg.ImageTag{
+			ImageName: "foo",
+		},
+		Digest: "sha256:000",
+	}
+
+	// The scan finishes once its status was read scanDoneAfter times; only
+	// then are its vulnerabilities known.
+	mkFakes := func(
+		scanDoneAfter int,
+		finalStatus grafeaspb.DiscoveryOccurrence_AnalysisStatus,
+	) (reg.ImageVulnProducer, reg.ScanStatusProducer, *int) {
+		var mutex sync.Mutex
+		reads := 0
+		vulnReads := 0
+		scanStatusProducer := func(
+			reg.PromotionEdge,
+		) (grafeaspb.DiscoveryOccurrence_AnalysisStatus, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			reads++
+			switch {
+			case reads == 1:
+				return grafeaspb.DiscoveryOccurrence_ANALYSIS_STATUS_UNSPECIFIED, nil
+			case reads <= scanDoneAfter:
+				return grafeaspb.DiscoveryOccurrence_SCANNING, nil
+			default:
+				return finalStatus, nil
+			}
+		}
+		vulnProducer := func(
+			reg.PromotionEdge,
+		) ([]*grafeaspb.Occurrence, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			vulnReads++
+			if reads <= scanDoneAfter {
+				return nil, nil
+			}
+			return []*grafeaspb.Occurrence{
+				{
+					Details: &grafeaspb.Occurrence_Vulnerability{
+						Vulnerability: &grafeaspb.VulnerabilityOccurrence{
+							Severity:     grafeaspb.Severity_CRITICAL,
+							FixAvailable: true,
+						},
+					},
+				},
+			}, nil
+		}
+
+		return vulnProducer, scanStatusProducer, &vulnReads
+	}
+
+	mkCheck := func(
+		vulnProducer reg.ImageVulnProducer,
+		scanStatusProducer reg.ScanStatusProducer,
+		waitTimeout time.Duration,
+	) *reg.ImageVulnCheck {
+		check := reg.MKImageVulnCheck(
+			reg.SyncContext{},
+			map[reg.PromotionEdge]interface{}{edge: nil},
+			int(grafeaspb.Severity_HIGH),
+			vulnProducer,
+			reg.VulnModeEnforce,
+		)
+		check.FakeScanStatusProducer = scanStatusProducer
+		check.WaitTimeout = waitTimeout
+		check.PollInterval = time.Millisecond
+
+		return check
+	}
+
+	vulnerable := fmt.Errorf("VulnerabilityCheck: " +
+		"The following vulnerable images were found:\n" +
+		"    foo@sha256:000 [1 fixable severe vulnerabilities, 1 total]")
+
+	// Without waiting, the image passes while it is still being scanned.
+	vulnProducer, scanStatusProducer, _ := mkFakes(
+		3,
+		grafeaspb.DiscoveryOccurrence_FINISHED_SUCCESS)
+	check := mkCheck(vulnProducer, scanStatusProducer, 0)
+	require.Nil(t, check.Run())
+
+	// Waiting for the scan finds its vulnerabilities.
+	vulnProducer, scanStatusProducer, vulnReads := mkFakes(
+		3,
+		grafeaspb.DiscoveryOccurrence_FINISHED_SUCCESS)
+	check = mkCheck(vulnProducer, scanStatusProducer, time.Minute)
+	require.Equal(t, vulnerable, check.Run())
+	require.Equal(t, 1, *vulnReads)
+
+	// A scan that does not finish in time fails the check, without reading
+	// the (incomplete) vulnerabilities.
+	vulnProducer, scanStatusProducer, vulnReads = mkFakes(
+		1000000,
+		grafeaspb.DiscoveryOccurrence_FINISHED_SUCCESS)
+	check = mkCheck(vulnProducer, scanStatusProducer, 20*time.Millisecond)
+	require.NotNil(t, check.Run())
+	require.Equal(t, 0, *vulnReads)
+	require.Len(t, check.SyncContext.Logs.Errors, 1)
+	require.Equal(
+		t,
+		"error waiting for the vulnerability scan",
+		check.SyncContext.Logs.Errors[0].Context)
+	require.Contains(
+		t,
+		check.SyncContext.Logs.Errors[0].Error.Error(),
+		"the vulnerability scan of foo@sha256:000 did not finish within 20ms (status: SCANNING)")
+
+	// So does a scan that failed, even in warn mode.
+	vulnProducer, scanStatusProducer, vulnReads = mkFakes(
+		2,
+		grafeaspb.DiscoveryOccurrence_FINISHED_FAILED)
+	check = mkCheck(vulnProducer, scanStatusProducer, time.Minute)
+	check.Mode = reg.VulnModeWarn
+	require.NotNil(t, check.Run())
+	require.Equal(t, 0, *vulnReads)
+	require.Equal(
+		t,
+		"the vulnerability scan of foo@sha256:000 failed",
+		check.SyncContext.Logs.Errors[0].Error.Error())
+}
+
+func TestImageVulnCheckConcurrent(t *testing.T) {
+	const numEdges = 200
+	const threads = 8
+
+	edges := make(map[reg.PromotionEdge]interface{})
+	vulnerable := make(map[reg.Digest]bool)
+	expectedImages := make([]string, 0)
+	for i := 0; i < numEdges; i++ {
+		edge := reg.PromotionEdge{
+			SrcImageTag: reg.ImageTag{
+				ImageName: reg.ImageName(fmt.Sprintf("img-%03d", i)),
+			},
+			Digest: reg.Digest(fmt.Sprintf("sha256:%03d", i)),
+		}
+		edges[edge] = nil
+		if i%2 == 0 {
+			vulnerable[edge.Digest] = true
+			expectedImages = append(
+				expectedImages,
+				fmt.Sprintf(
+					"%v@%v [1 fixable severe vulnerabilities, 1 total]",
+					edge.SrcImageTag.ImageName,
+					edge.Digest,
+				),
+			)
+		}
+	}
+
+	// Track how many scans run at the same time.
+	var inFlight, maxInFlight int32
+	vulnProducer := func(
+		edge reg.PromotionEdge,
+	) ([]*grafeaspb.Occurrence, error) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			prev := atomic.LoadInt32(&maxInFlight)
+			if n <= prev || atomic.CompareAndSwapInt32(&maxInFlight, prev, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+
+		if !vulnerable[edge.Digest] {
+			return nil, nil
+		}
+		return []*grafeaspb.Occurrence{
+			{
+				Details: &grafeaspb.Occurrence_Vulnerability{
+					Vulnerability: &grafeaspb.VulnerabilityOccurrence{
+						Severity:     grafeaspb.Severity_CRITICAL,
+						FixAvailable: true,
+					},
+				},
+			},
+		}, nil
+	}
+
+	check := reg.MKImageVulnCheck(
+		reg.SyncContext{Threads: 1},
+		edges,
+		int(grafeaspb.Severity_HIGH),
+		vulnProducer,
+		reg.VulnModeEnforce,
+	)
+	// The dedicated thread count takes precedence over the SyncContext's.
+	check.Threads = threads
+
+	got := check.Run()
+	require.NotNil(t, got)
+	require.Equal(
+		t,
+		fmt.Errorf("VulnerabilityCheck: "+
+			"The following vulnerable images were found:\n    %v",
+			strings.Join(expectedImages, "\n    ")),
+		got,
+	)
+	require.Len(t, check.Findings, numEdges/2)
+	require.LessOrEqual(t, int(maxInFlight), threads)
+	require.Greater(t, int(maxInFlight), 1)
+}
+
+// fakePreCheck is a PreCheck that records the edges it was created for.
+type fakePreCheck struct {
+	edges map[reg.PromotionEdge]interface{}
+	err   error
+	ran   bool
+}
+
+func (check *fakePreCheck) Run() error {
+	check.ran = true
+	return check.err
+}
+
+func TestRegisterPreCheck(t *testing.T) {
+	var created []*fakePreCheck
+	reg.RegisterPreCheck(
+		"fake-precheck",
+		func(
+			sc *reg.SyncContext,
+			edges map[reg.PromotionEdge]interface{},
+			opts reg.PreCheckOptions,
+		) (reg.PreCheck, error) {
+			check := &fakePreCheck{edges: edges}
+			if opts.MaxImageSize == 0 {
+				check.err = fmt.Errorf("fake failure")
+			}
+			created = append(created, check)
+			return check, nil
+		})
+
+	require.Contains(t, reg.RegisteredPreChecks(), "fake-precheck")
+	require.Contains(t, reg.RegisteredPreChecks(), reg.PreCheckVuln)
+	require.Contains(t, reg.RegisteredPreChecks(), reg.PreCheckImageSize)
+	require.Contains(t, reg.RegisteredPreChecks(), reg.PreCheckSourceExists)
+	require.Contains(t, reg.RegisteredPreChecks(), reg.PreCheckDigestAllowlist)
+
+	// Names must be unique.
+	require.Panics(t, func() {
+		reg.RegisterPreCheck(
+			"fake-precheck",
+			func(
+				*reg.SyncContext,
+				map[reg.PromotionEdge]interface{},
+				reg.PreCheckOptions,
+			) (reg.PreCheck, error) {
+				return nil, nil
+			})
+	})
+
+	edges := map[reg.PromotionEdge]interface{}{
+		{
+			Digest: "sha256:000",
+			DstImageTag: reg.ImageTag{
+				ImageName: "a",
+				Tag:       "0.9",
+			},
+		}: nil,
+	}
+
+	tests := []struct {
+		name        string
+		checks      []string
+		opts        reg.PreCheckOptions
+		expectedErr bool
+		expectedRun bool
+	}{
+		{
+			"Enabled fake check passes",
+			[]string{"fake-precheck"},
+			reg.PreCheckOptions{MaxImageSize: 1},
+			false,
+			true,
+		},
+		{
+			"Enabled fake check fails",
+			[]string{"fake-precheck"},
+			reg.PreCheckOptions{},
+			true,
+			true,
+		},
+		{
+			"Not enabled",
+			[]string{},
+			reg.PreCheckOptions{},
+			false,
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		created = nil
+		sc := reg.SyncContext{}
+
+		preChecks, err := reg.MkPreChecks(test.checks, &sc, edges, test.opts)
+		require.Nil(t, err, test.name)
+		require.Len(t, preChecks, len(test.checks), test.name)
+
+		err = sc.RunChecks(preChecks)
+		require.Equal(t, test.expectedErr, err != nil, test.name)
+
+		require.Equal(t, test.expectedRun, len(created) == 1, test.name)
+		if test.expectedRun {
+			require.True(t, created[0].ran, test.name)
+			require.Equal(t, edges, created[0].edges, test.name)
+		}
+	}
+
+	// Unknown checks are rejected.
+	_, err := reg.MkPreChecks(
+		[]string{"does-not-exist"},
+		&reg.SyncContext{},
+		edges,
+		reg.PreCheckOptions{})
+	require.NotNil(t, err)
+
+	// The vuln check requires a severity threshold.
+	_, err = reg.MkPreChecks(
+		[]string{reg.PreCheckVuln},
+		&reg.SyncContext{},
+		edges,
+		reg.PreCheckOptions{SeverityThreshold: -1})
+	require.NotNil(t, err)
+
+	preChecks, err := reg.MkPreChecks(
+		[]string{reg.PreCheckVuln},
+		&reg.SyncContext{},
+		edges,
+		reg.PreCheckOptions{SeverityThreshold: 2, VulnMode: reg.VulnModeWarn})
+	require.Nil(t, err)
+	require.Len(t, preChecks, 1)
+	vulnCheck, ok := preChecks[0].(*reg.ImageVulnCheck)
+	require.True(t, ok)
+	require.Equal(t, 2, vulnCheck.SeverityThreshold)
+	require.Equal(t, reg.VulnModeWarn, vulnCheck.Mode)
+}
+
+func TestImageSignatureCheck(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	key2, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	publicKeys := []crypto.PublicKey{&key.PublicKey, &key2.PublicKey}
+
+	sign := func(key *ecdsa.PrivateKey, digest reg.Digest) reg.CosignSignature {
+		payload := []byte(fmt.Sprintf(
+			`{"critical":{"identity":{"docker-reference":"gcr.io/foo/a"},`+
+				`"image":{"docker-manifest-digest":%q},`+
+				`"type":"cosign container image signature"},"optional":null}`,
+			digest))
+		hash := sha256.Sum256(payload)
+		signature, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+		require.Nil(t, err)
+
+		return reg.CosignSignature{Payload: payload, Signature: signature}
+	}
+
+	srcRC := reg.RegistryContext{
+		Name:           "gcr.io/foo",
+		ServiceAccount: "robot",
+		Src:            true,
+	}
+	destRC := reg.RegistryContext{
+		Name:           "gcr.io/bar",
+		ServiceAccount: "robot",
+	}
+
+	// Image "a" requires 2 signatures in its manifest; image "b" does not
+	// require any (unless required globally).
+	edges, err := reg.ToPromotionEdges([]reg.Manifest{
+		{
+			Registries: []reg.RegistryContext{srcRC, destRC},
+			Images: []reg.Image{
+				{
+					ImageName:     "a",
+					Dmap:          reg.DigestTags{"sha256:000": {"1.0"}},
+					MinSignatures: 2,
+				},
+				{
+					ImageName: "b",
+					Dmap:      reg.DigestTags{"sha256:111": {"1.0"}},
+				},
+			},
+			SrcRegistry: &srcRC,
+		},
+	})
+	require.Nil(t, err)
+
+	tests := []struct {
+		name          string
+		minSignatures int
+		publicKeys    []crypto.PublicKey
+		signatures    map[reg.Digest][]reg.CosignSignature
+		expectedErr   bool
+	}{
+		{
+			"0 signatures",
+			0,
+			publicKeys,
+			nil,
+			true,
+		},
+		{
+			"1 signature",
+			0,
+			publicKeys,
+			map[reg.Digest][]reg.CosignSignature{
+				"sha256:000": {sign(key, "sha256:000")},
+			},
+			true,
+		},
+		{
+			"2 signatures",
+			0,
+			publicKeys,
+			map[reg.Digest][]reg.CosignSignature{
+				"sha256:000": {
+					sign(key, "sha256:000"),
+					sign(key2, "sha256:000"),
+				},
+			},
+			false,
+		},
+		{
+			"2 signatures, but both are made with the same key",
+			0,
+			publicKeys,
+			map[reg.Digest][]reg.CosignSignature{
+				"sha256:000": {
+					sign(key, "sha256:000"),
+					sign(key, "sha256:000"),
+				},
+			},
+			true,
+		},
+		{
+			"2 signatures with the same key, which is given twice",
+			0,
+			[]crypto.PublicKey{&key.PublicKey, &key.PublicKey},
+			map[reg.Digest][]reg.CosignSignature{
+				"sha256:000": {
+					sign(key, "sha256:000"),
+					sign(key, "sha256:000"),
+				},
+			},
+			true,
+		},
+		{
+			"2 signatures, but one is made with an unknown key",
+			0,
+			publicKeys,
+			map[reg.Digest][]reg.CosignSignature{
+				"sha256:000": {
+					sign(key, "sha256:000"),
+					sign(otherKey, "sha256:000"),
+				},
+			},
+			true,
+		},
+		{
+			"2 signatures, but one is of another digest",
+			0,
+			publicKeys,
+			map[reg.Digest][]reg.CosignSignature{
+				"sha256:000": {
+					sign(key, "sha256:000"),
+					sign(key2, "sha256:111"),
+				},
+			},
+			true,
+		},
+		{
+			"2 copies of the same signature",
+			0,
+			publicKeys,
+			func() map[reg.Digest][]reg.CosignSignature {
+				signature := sign(key, "sha256:000")
+				return map[reg.Digest][]reg.CosignSignature{
+					"sha256:000": {signature, signature},
+				}
+			}(),
+			true,
+		},
+		{
+			"Global minimum applies to images without their own",
+			1,
+			publicKeys,
+			map[reg.Digest][]reg.CosignSignature{
+				"sha256:000": {
+					sign(key, "sha256:000"),
+					sign(key2, "sha256:000"),
+				},
+			},
+			true,
+		},
+		{
+			"Global minimum is met",
+			1,
+			publicKeys,
+			map[reg.Digest][]reg.CosignSignature{
+				"sha256:000": {
+					sign(key, "sha256:000"),
+					sign(key2, "sha256:000"),
+				},
+				"sha256:111": {sign(key, "sha256:111")},
+			},
+			false,
+		},
+		{
+			"No public keys",
+			0,
+			nil,
+			map[reg.Digest][]reg.CosignSignature{
+				"sha256:000": {
+					sign(key, "sha256:000"),
+					sign(key2, "sha256:000"),
+				},
+			},
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		signatures := test.signatures
+		check := reg.MKImageSignatureCheck(
+			reg.SyncContext{},
+			edges,
+			test.minSignatures,
+			test.publicKeys,
+			func(edge reg.PromotionEdge) ([]reg.CosignSignature, error) {
+				return signatures[edge.Digest], nil
+			},
+		)
+
+		err := check.Run()
+		require.Equal(t, test.expectedErr, err != nil, test.name)
+	}
+
+	// No signatures are needed if neither the images nor the check require
+	// any.
+	check := reg.MKImageSignatureCheck(
+		reg.SyncContext{},
+		map[reg.PromotionEdge]interface{}{
+			{Digest: "sha256:111"}: nil,
+		},
+		0,
+		nil,
+		func(edge reg.PromotionEdge) ([]reg.CosignSignature, error) {
+			return nil, fmt.Errorf("signatures must not be read")
+		},
+	)
+	require.Nil(t, check.Run())
+
+	require.Equal(t,
+		reg.Tag("sha256-000.sig"),
+		reg.CosignSignatureTag("sha256:000"))
+}
+
+func TestLoadPublicKeys(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.Nil(t, err)
+
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "cosign.pub")
+	require.Nil(t, ioutil.WriteFile(
+		keyPath,
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}),
+		0o644))
+	badPath := filepath.Join(dir, "bad.pub")
+	require.Nil(t, ioutil.WriteFile(badPath, []byte("not a key"), 0o644))
+
+	publicKeys, err := reg.LoadPublicKeys([]string{keyPath})
+	require.Nil(t, err)
+	require.Len(t, publicKeys, 1)
+	require.True(t, key.PublicKey.Equal(publicKeys[0]))
+
+	_, err = reg.LoadPublicKeys([]string{keyPath, badPath})
+	require.NotNil(t, err)
+
+	_, err = reg.LoadPublicKeys([]string{filepath.Join(dir, "missing.pub")})
+	require.NotNil(t, err)
+}
+
+func TestImageAgeCheck(t *testing.T) {
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	srcRC := reg.RegistryContext{
+		Name:           "gcr.io/foo",
+		ServiceAccount: "robot",
+		Src:            true,
+	}
+	destRC := reg.RegistryContext{
+		Name:           "gcr.io/bar",
+		ServiceAccount: "robot",
+	}
+	destRC2 := reg.RegistryContext{
+		Name:           "gcr.io/baz",
+		ServiceAccount: "robot",
+	}
+
+	// Every image is promoted to 2 registries, but only read once.
+	edges, err := reg.ToPromotionEdges([]reg.Manifest{
+		{
+			Registries: []reg.RegistryContext{srcRC, destRC, destRC2},
+			Images: []reg.Image{
+				{
+					ImageName: "a",
+					Dmap:      reg.DigestTags{"sha256:000": {"1.0"}},
+				},
+				{
+					ImageName: "b",
+					Dmap:      reg.DigestTags{"sha256:111": {"1.0"}},
+				},
+			},
+			SrcRegistry: &srcRC,
+		},
+	})
+	require.Nil(t, err)
+
+	tests := []struct {
+		name             string
+		created          map[reg.Digest]time.Time
+		mode             reg.ImageAgeMode
+		expectedErr      bool
+		expectedFindings int
+	}{
+		{
+			"All images are new",
+			map[reg.Digest]time.Time{
+				"sha256:000": now.Add(-1 * day),
+				"sha256:111": now.Add(-29 * day),
+			},
+			reg.ImageAgeModeEnforce,
+			false,
+			0,
+		},
+		{
+			"One image is stale",
+			map[reg.Digest]time.Time{
+				"sha256:000": now.Add(-1 * day),
+				"sha256:111": now.Add(-31 * day),
+			},
+			reg.ImageAgeModeEnforce,
+			true,
+			1,
+		},
+		{
+			"All images are stale",
+			map[reg.Digest]time.Time{
+				"sha256:000": now.Add(-365 * day),
+				"sha256:111": now.Add(-31 * day),
+			},
+			reg.ImageAgeModeEnforce,
+			true,
+			2,
+		},
+		{
+			"Stale images only warn in warn mode",
+			map[reg.Digest]time.Time{
+				"sha256:000": now.Add(-365 * day),
+				"sha256:111": now.Add(-31 * day),
+			},
+			reg.ImageAgeModeWarn,
+			false,
+			2,
+		},
+		{
+			"Images without a creation time are not stale",
+			map[reg.Digest]time.Time{
+				"sha256:000": {},
+				"sha256:111": time.Unix(0, 0),
+			},
+			reg.ImageAgeModeEnforce,
+			false,
+			0,
+		},
+	}
+
+	for _, test := range tests {
+		created := test.created
+		var reads int32
+		check := reg.MKImageAgeCheck(
+			reg.SyncContext{Clock: reg.FakeClock{Time: now}},
+			edges,
+			30*day,
+			func(edge reg.PromotionEdge) (time.Time, error) {
+				atomic.AddInt32(&reads, 1)
+				return created[edge.Digest], nil
+			},
+			test.mode,
+		)
+
+		err := check.Run()
+		require.Equal(t, test.expectedErr, err != nil, test.name)
+		require.Len(t, check.Findings, test.expectedFindings, test.name)
+		require.Equal(t, int32(2), reads, test.name)
+	}
+
+	// Failing to read the creation time fails the check, even in warn mode.
+	check := reg.MKImageAgeCheck(
+		reg.SyncContext{},
+		edges,
+		30*day,
+		func(edge reg.PromotionEdge) (time.Time, error) {
+			return time.Time{}, fmt.Errorf("no such image")
+		},
+		reg.ImageAgeModeWarn,
+	)
+	require.NotNil(t, check.Run())
+}
+
+func TestImageSourceExistsCheck(t *testing.T) {
+	srcRC := reg.RegistryContext{
+		Name:           "gcr.io/foo",
+		ServiceAccount: "robot",
+		Src:            true,
+	}
+	destRC := reg.RegistryContext{
+		Name:           "gcr.io/bar",
+		ServiceAccount: "robot",
+	}
+	destRC2 := reg.RegistryContext{
+		Name:           "gcr.io/baz",
+		ServiceAccount: "robot",
+	}
+
+	// Every image is promoted to 2 registries, but only looked up once.
+	edges, err := reg.ToPromotionEdges([]reg.Manifest{
+		{
+			Registries: []reg.RegistryContext{srcRC, destRC, destRC2},
+			Images: []reg.Image{
+				{
+					ImageName: "a",
+					Dmap:      reg.DigestTags{"sha256:000": {"1.0"}},
+				},
+				{
+					ImageName: "b",
+					Dmap:      reg.DigestTags{"sha256:111": {"1.0"}},
+				},
+			},
+			SrcRegistry: &srcRC,
+		},
+	})
+	require.Nil(t, err)
+
+	tests := []struct {
+		name        string
+		existing    map[reg.Digest]bool
+		lookupErr   error
+		expectedErr bool
+	}{
+		{
+			"All source images exist",
+			map[reg.Digest]bool{"sha256:000": true, "sha256:111": true},
+			nil,
+			false,
+		},
+		{
+			"One source image is missing",
+			map[reg.Digest]bool{"sha256:000": true},
+			nil,
+			true,
+		},
+		{
+			"Source images cannot be looked up",
+			map[reg.Digest]bool{"sha256:000": true, "sha256:111": true},
+			fmt.Errorf("registry unavailable"),
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		existing := test.existing
+		lookupErr := test.lookupErr
+		var lookups int32
+		check := reg.MKImageSourceExistsCheck(
+			reg.SyncContext{},
+			edges,
+			func(edge reg.PromotionEdge) (bool, error) {
+				atomic.AddInt32(&lookups, 1)
+				return existing[edge.Digest], lookupErr
+			},
+		)
+
+		err := check.Run()
+		require.Equal(t, test.expectedErr, err != nil, test.name)
+		require.Equal(t, int32(2), lookups, test.name)
+	}
+}
+
+func TestDigestAllowlistCheck(t *testing.T) {
+	srcRC := reg.RegistryContext{
+		Name:           "gcr.io/foo",
+		ServiceAccount: "robot",
+		Src:            true,
+	}
+	destRC := reg.RegistryContext{
+		Name:           "gcr.io/bar",
+		ServiceAccount: "robot",
+	}
+
+	edges, err := reg.ToPromotionEdges([]reg.Manifest{
+		{
+			Registries: []reg.RegistryContext{srcRC, destRC},
+			Images: []reg.Image{
+				{
+					ImageName: "a",
+					Dmap:      reg.DigestTags{"sha256:000": {"1.0"}},
+				},
+				{
+					ImageName: "b",
+					Dmap:      reg.DigestTags{"sha256:111": {"1.0"}},
+				},
+			},
+			SrcRegistry: &srcRC,
+		},
+	})
+	require.Nil(t, err)
+
+	tests := []struct {
+		name        string
+		allowlist   []reg.Digest
+		expectedErr bool
+	}{
+		{
+			"All digests are allowed",
+			[]reg.Digest{"sha256:000", "sha256:111", "sha256:222"},
+			false,
+		},
+		{
+			"One digest is not allowed",
+			[]reg.Digest{"sha256:000"},
+			true,
+		},
+		{
+			"No digest is allowed",
+			[]reg.Digest{},
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		err := reg.MKDigestAllowlistCheck(edges, test.allowlist).Run()
+		require.Equal(t, test.expectedErr, err != nil, test.name)
+	}
+
+	// The registered check refuses to run without an allowlist.
+	_, err = reg.MkPreChecks(
+		[]string{reg.PreCheckDigestAllowlist},
+		&reg.SyncContext{},
+		edges,
+		reg.PreCheckOptions{},
+	)
+	require.NotNil(t, err)
+}
+
+func TestImageAgeCheckReadsCreationTime(t *testing.T) {
+	srv := httptest.NewServer(
+		registry.New(registry.Logger(log.New(ioutil.Discard, "", 0))))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	srcRC := reg.RegistryContext{
+		Name: reg.RegistryName(host + "/staging"),
+		Src:  true,
+	}
+	destRC := reg.RegistryContext{Name: reg.RegistryName(host + "/prod")}
+
+	// push writes an image that was created at the given time to the source
+	// registry, and returns the edge that promotes it.
+	push := func(tag reg.Tag, created time.Time) reg.PromotionEdge {
+		img, err := random.Image(64, 1)
+		require.Nil(t, err)
+		img, err = mutate.CreatedAt(img, v1.Time{Time: created})
+		require.Nil(t, err)
+
+		ref, err := name.ParseReference(
+			fmt.Sprintf("%s/staging/a:%s", host, tag))
+		require.Nil(t, err)
+		require.Nil(t, remote.Write(ref, img))
+
+		digest, err := img.Digest()
+		require.Nil(t, err)
+
+		return reg.PromotionEdge{
+			SrcRegistry: srcRC,
+			SrcImageTag: reg.ImageTag{ImageName: "a", Tag: tag},
+			Digest:      reg.Digest(digest.String()),
+			DstRegistry: destRC,
+			DstImageTag: reg.ImageTag{ImageName: "a", Tag: tag},
+		}
+	}
+
+	fresh := push("1.0", time.Now().Add(-time.Hour))
+	stale := push("0.1", time.Now().Add(-1000*time.Hour))
+
+	check := reg.MKImageAgeCheck(
+		reg.SyncContext{},
+		map[reg.PromotionEdge]interface{}{fresh: nil},
+		100*time.Hour,
+		nil,
+		reg.VulnModeEnforce,
+	)
+	require.Nil(t, check.Run())
+
+	check = reg.MKImageAgeCheck(
+		reg.SyncContext{},
+		map[reg.PromotionEdge]interface{}{fresh: nil, stale: nil},
+		100*time.Hour,
+		nil,
+		reg.VulnModeEnforce,
+	)
+	err := check.Run()
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), string(stale.Digest))
+	require.NotContains(t, err.Error(), string(fresh.Digest))
 }
@@ -0,0 +1,71 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"time"
+)
+
+// Clock tells the time to the promoter (see SyncContext.Clock). It is used to
+// check promotion windows and image ages, to date provenance, and to pause
+// between batches of promotions.
+type Clock interface {
+	Now() time.Time
+	// After sends the time on the returned channel once d has passed (like
+	// time.After()).
+	After(d time.Duration) <-chan time.Time
+}
+
+// RealClock is the Clock of the system.
+type RealClock struct{}
+
+// Now implements Clock.
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+// After implements Clock.
+func (RealClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// FakeClock is a Clock that is stopped at Time, for testing. It does not wait
+// for anything.
+type FakeClock struct {
+	Time time.Time
+}
+
+// Now implements Clock.
+func (c FakeClock) Now() time.Time {
+	return c.Time
+}
+
+// After implements Clock. The channel receives Time at once.
+func (c FakeClock) After(d time.Duration) <-chan time.Time {
+	after := make(chan time.Time, 1)
+	after <- c.Time
+	return after
+}
+
+// clock returns the Clock of sc, or RealClock if it has none.
+func (sc *SyncContext) clock() Clock {
+	if sc.Clock == nil {
+		return RealClock{}
+	}
+
+	return sc.Clock
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// decompressManifest returns the contents b of the manifest file at filePath,
+// decompressed if the file is compressed. Compression is detected by the
+// extension (".gz" or ".zst") or, failing that, by the magic bytes of the
+// contents. The returned name is filePath without the compression extension,
+// so that the format of the manifest can still be told by its extension
+// (e.g., "manifest.json.gz").
+func decompressManifest(filePath string, b []byte) ([]byte, string, error) {
+	ext := strings.ToLower(filepath.Ext(filePath))
+	name := filePath
+	if ext == ".gz" || ext == ".zst" {
+		name = strings.TrimSuffix(filePath, filepath.Ext(filePath))
+	}
+
+	switch {
+	case ext == ".gz" || bytes.HasPrefix(b, gzipMagic):
+		gz, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, name, fmt.Errorf("decompressing gzip: %w", err)
+		}
+		defer gz.Close()
+
+		decompressed, err := ioutil.ReadAll(gz)
+		if err != nil {
+			return nil, name, fmt.Errorf("decompressing gzip: %w", err)
+		}
+
+		return decompressed, name, nil
+	case ext == ".zst" || bytes.HasPrefix(b, zstdMagic):
+		return nil, name, errors.New(
+			"zstd-compressed manifests are not supported; please use gzip")
+	default:
+		return b, name, nil
+	}
+}
@@ -0,0 +1,222 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/stream"
+)
+
+// defaultMaxConcurrentRequests is the number of requests that are run at the
+// same time if SyncContext.Threads is not set.
+const defaultMaxConcurrentRequests = 10
+
+// ValidateGroupThreads checks that every group of a manifest's GroupThreads
+// is allowed at least 1 thread.
+func ValidateGroupThreads(groupThreads map[string]int) error {
+	groups := make([]string, 0, len(groupThreads))
+	for group := range groupThreads {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	for _, group := range groups {
+		if groupThreads[group] < 1 {
+			return fmt.Errorf(
+				"groupThreads: group %q must have at least 1 thread: %d",
+				group,
+				groupThreads[group])
+		}
+	}
+
+	return nil
+}
+
+// maxConcurrentRequests is the number of requests that ExecRequests() runs at
+// the same time.
+func (sc *SyncContext) maxConcurrentRequests() int {
+	if sc.Threads > 0 {
+		return sc.Threads
+	}
+
+	return defaultMaxConcurrentRequests
+}
+
+// GroupConcurrency is the number of promotion requests for the images of the
+// group that Promote() runs at the same time (at most). It is the group's
+// GroupThreads, unless that is more than the threads of the whole run.
+func (sc *SyncContext) GroupConcurrency(group string) int {
+	threads := sc.maxConcurrentRequests()
+	if limit, ok := sc.GroupThreads[group]; ok && limit < threads {
+		return limit
+	}
+
+	return threads
+}
+
+// addImageGroups records the group of every image of the manifests (see
+// CheckImageGroups()), and the lowest GroupThreads that the manifests give
+// each group.
+func (sc *SyncContext) addImageGroups(mfests []Manifest) error {
+	imageGroups, err := mkImageGroups(mfests)
+	if err != nil {
+		return err
+	}
+	if len(imageGroups) > 0 {
+		sc.imageGroups = imageGroups
+	}
+
+	for _, mfest := range mfests {
+		for group, limit := range mfest.GroupThreads {
+			if sc.GroupThreads == nil {
+				sc.GroupThreads = make(map[string]int)
+			}
+			if current, ok := sc.GroupThreads[group]; !ok || limit < current {
+				sc.GroupThreads[group] = limit
+			}
+		}
+	}
+
+	return nil
+}
+
+// CheckImageGroups checks that the manifests do not put the same source image
+// into different groups. Promotion requests are told apart by their source
+// image, so such an image would otherwise be limited by the GroupThreads of
+// whichever manifest came last.
+func CheckImageGroups(mfests []Manifest) error {
+	_, err := mkImageGroups(mfests)
+	return err
+}
+
+// mkImageGroups maps the source image of every image of the manifests to its
+// group.
+func mkImageGroups(mfests []Manifest) (map[RegistryImagePath]string, error) {
+	// Source image -> the groups it is given.
+	groups := make(map[RegistryImagePath]map[string]bool)
+	for _, mfest := range mfests {
+		srcRegistry := mfest.SrcRegistry
+		if srcRegistry == nil {
+			var err error
+			if srcRegistry, err = GetSrcRegistry(mfest.Registries); err != nil {
+				continue
+			}
+		}
+
+		for i := range mfest.Images {
+			path := RegistryImagePath(
+				ToLQIN(srcRegistry.Name, mfest.Images[i].ImageName))
+			if groups[path] == nil {
+				groups[path] = make(map[string]bool)
+			}
+			groups[path][mfest.Images[i].GroupName()] = true
+		}
+	}
+
+	imageGroups := make(map[RegistryImagePath]string)
+	conflicts := make([]string, 0)
+	for path, pathGroups := range groups {
+		sorted := make([]string, 0, len(pathGroups))
+		for group := range pathGroups {
+			sorted = append(sorted, group)
+		}
+		sort.Strings(sorted)
+
+		if len(sorted) > 1 {
+			conflicts = append(conflicts, fmt.Sprintf(
+				"%s: conflicting groups (%s)",
+				path,
+				strings.Join(sorted, ", ")))
+			continue
+		}
+		imageGroups[path] = sorted[0]
+	}
+
+	if len(conflicts) == 0 {
+		return imageGroups, nil
+	}
+	sort.Strings(conflicts)
+
+	return nil, fmt.Errorf(
+		"%d image(s) are put into conflicting groups:\n  %s",
+		len(conflicts),
+		strings.Join(conflicts, "\n  "))
+}
+
+// requestGroup is the group of the image that the promotion request copies.
+// Images that are not in any manifest belong to DefaultImageGroup.
+func (sc *SyncContext) requestGroup(req stream.ExternalRequest) string {
+	rpr, ok := req.RequestParams.(PromotionRequest)
+	if !ok {
+		return DefaultImageGroup
+	}
+
+	path := ToLQIN(rpr.RegistrySrc, rpr.ImageNameSrc)
+	if group, ok := sc.imageGroups[RegistryImagePath(path)]; ok {
+		return group
+	}
+
+	return DefaultImageGroup
+}
+
+// limitGroupConcurrency wraps processRequest, so that no more than
+// GroupConcurrency() requests of each group with GroupThreads are processed
+// at the same time. Each worker hands its requests to processRequest one by
+// one; a worker that waits for its group to free up still counts towards
+// the threads of the run.
+func (sc *SyncContext) limitGroupConcurrency(
+	processRequest ProcessRequest,
+) ProcessRequest {
+	slots := make(map[string]chan struct{})
+	for group := range sc.GroupThreads {
+		slots[group] = make(chan struct{}, sc.GroupConcurrency(group))
+	}
+
+	return func(
+		sc *SyncContext,
+		reqs chan stream.ExternalRequest,
+		requestResults chan<- RequestResult,
+		wg *sync.WaitGroup,
+		mutex *sync.Mutex,
+	) {
+		workerReqs := make(chan stream.ExternalRequest)
+		workerResults := make(chan RequestResult)
+		go processRequest(sc, workerReqs, workerResults, wg, mutex)
+
+		for req := range reqs {
+			slot, limited := slots[sc.requestGroup(req)]
+			if limited {
+				slot <- struct{}{}
+			}
+
+			// processRequest answers every request with exactly one result.
+			workerReqs <- req
+			reqRes := <-workerResults
+
+			if limited {
+				<-slot
+			}
+			requestResults <- reqRes
+		}
+
+		close(workerReqs)
+	}
+}
@@ -0,0 +1,214 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/stream"
+)
+
+// mkGroupManifest returns a manifest that promotes n images of the group
+// (named "<group>-<i>") from "gcr.io/<group>-src".
+func mkGroupManifest(
+	group string,
+	n int,
+	groupThreads map[string]int,
+) reg.Manifest {
+	srcRC := reg.RegistryContext{
+		Name: reg.RegistryName("gcr.io/" + group + "-src"),
+		Src:  true,
+	}
+	mfest := reg.Manifest{
+		Registries: []reg.RegistryContext{
+			srcRC,
+			{Name: "gcr.io/prod"},
+		},
+		GroupThreads: groupThreads,
+		SrcRegistry:  &srcRC,
+	}
+
+	for i := 0; i < n; i++ {
+		mfest.Images = append(mfest.Images, reg.Image{
+			ImageName: reg.ImageName(fmt.Sprintf("%s-%d", group, i)),
+			Dmap:      reg.DigestTags{"sha256:000": {"1.0"}},
+			Group:     group,
+		})
+	}
+
+	return mfest
+}
+
+func TestGroupConcurrency(t *testing.T) {
+	mfests := []reg.Manifest{
+		mkGroupManifest("large", 1, map[string]int{"large": 3, "small": 20}),
+		mkGroupManifest("small", 1, map[string]int{"large": 2}),
+	}
+
+	sc, err := reg.MakeSyncContext(mfests, 8, true, false)
+	require.Nil(t, err)
+
+	// The lowest limit of each group wins, and no group gets more than the
+	// threads of the run.
+	require.Equal(t, map[string]int{"large": 2, "small": 20}, sc.GroupThreads)
+	require.Equal(t, 2, sc.GroupConcurrency("large"))
+	require.Equal(t, 8, sc.GroupConcurrency("small"))
+	require.Equal(t, 8, sc.GroupConcurrency(reg.DefaultImageGroup))
+
+	// Without threads, the run has the default number of threads.
+	sc.Threads = 0
+	require.Equal(t, 10, sc.GroupConcurrency("small"))
+}
+
+func TestPromoteGroupThreads(t *testing.T) {
+	mfests := []reg.Manifest{
+		mkGroupManifest("large", 6, map[string]int{"large": 2}),
+		mkGroupManifest("small", 6, nil),
+	}
+
+	sc, err := reg.MakeSyncContext(mfests, 5, false, false)
+	require.Nil(t, err)
+
+	edges, err := reg.ToPromotionEdges(mfests)
+	require.Nil(t, err)
+
+	// Record the most requests of each group that were processed at once.
+	var (
+		mutex       sync.Mutex
+		inFlight    = make(map[reg.RegistryName]int)
+		maxInFlight = make(map[reg.RegistryName]int)
+	)
+	var processRequest reg.ProcessRequest = func(
+		sc *reg.SyncContext,
+		reqs chan stream.ExternalRequest,
+		requestResults chan<- reg.RequestResult,
+		wg *sync.WaitGroup,
+		_ *sync.Mutex,
+	) {
+		for req := range reqs {
+			src := req.RequestParams.(reg.PromotionRequest).RegistrySrc
+
+			mutex.Lock()
+			inFlight[src]++
+			if inFlight[src] > maxInFlight[src] {
+				maxInFlight[src] = inFlight[src]
+			}
+			mutex.Unlock()
+
+			time.Sleep(20 * time.Millisecond)
+
+			mutex.Lock()
+			inFlight[src]--
+			mutex.Unlock()
+
+			requestResults <- reg.RequestResult{Context: req}
+		}
+	}
+
+	require.Nil(t, sc.Promote(edges, nil, &processRequest))
+	require.Equal(t, 12, sc.Logs.Promotions.Succeeded)
+
+	require.Equal(t, 2, maxInFlight["gcr.io/large-src"])
+	require.LessOrEqual(t, maxInFlight["gcr.io/small-src"], 5)
+}
+
+func TestParseManifestGroupThreads(t *testing.T) {
+	_, err := reg.ParseManifestYAML([]byte(`registries:
+- name: gcr.io/foo
+  src: true
+- name: gcr.io/bar
+  service-account: sa@robot.com
+images:
+- name: a
+  group: large
+  dmap:
+    "sha256:0000000000000000000000000000000000000000000000000000000000000000": ["1.0"]
+groupThreads:
+  large: 0
+`))
+	require.NotNil(t, err)
+	require.Equal(
+		t,
+		`groupThreads: group "large" must have at least 1 thread: 0`,
+		err.Error())
+}
+
+func TestConflictingImageGroups(t *testing.T) {
+	srcRC := reg.RegistryContext{
+		Name: "gcr.io/foo-src",
+		Src:  true,
+	}
+	mkManifest := func(group string) reg.Manifest {
+		return reg.Manifest{
+			Registries: []reg.RegistryContext{
+				srcRC,
+				{Name: "gcr.io/prod"},
+			},
+			Images: []reg.Image{
+				{
+					ImageName: "a",
+					Dmap:      reg.DigestTags{"sha256:000": {"1.0"}},
+					Group:     group,
+				},
+			},
+			SrcRegistry: &srcRC,
+		}
+	}
+
+	tests := []struct {
+		name        string
+		mfests      []reg.Manifest
+		expectedErr string
+	}{
+		{
+			"Same group in both manifests",
+			[]reg.Manifest{mkManifest("large"), mkManifest("large")},
+			"",
+		},
+		{
+			"Different groups",
+			[]reg.Manifest{mkManifest("large"), mkManifest("small")},
+			"1 image(s) are put into conflicting groups:\n" +
+				"  gcr.io/foo-src/a: conflicting groups (large, small)",
+		},
+		{
+			"Group and no group",
+			[]reg.Manifest{mkManifest(""), mkManifest("large")},
+			"1 image(s) are put into conflicting groups:\n" +
+				"  gcr.io/foo-src/a: conflicting groups (" +
+				reg.DefaultImageGroup + ", large)",
+		},
+	}
+
+	for _, test := range tests {
+		err := reg.CheckImageGroups(test.mfests)
+		_, errSync := reg.MakeSyncContext(test.mfests, 1, true, false)
+		if test.expectedErr == "" {
+			require.Nil(t, err, test.name)
+			require.Nil(t, errSync, test.name)
+		} else {
+			require.EqualError(t, err, test.expectedErr, test.name)
+			require.EqualError(t, errSync, test.expectedErr, test.name)
+		}
+	}
+}
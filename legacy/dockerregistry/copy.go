@@ -0,0 +1,212 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// ImageRef is a reference to an image by tag, by digest, or both, such as
+// "gcr.io/foo/bar:1.0@sha256:...".
+type ImageRef struct {
+	Registry  RegistryName
+	ImageName ImageName
+	// Tag is empty if the image is only referenced by digest.
+	Tag Tag
+	// Digest is empty if the image is only referenced by tag.
+	Digest Digest
+}
+
+// ParseImageRef parses a reference of the form
+// "<registry>/<image>[:<tag>][@<digest>]" (see ParseContainerParts() for how
+// the registry is told apart from the image).
+func ParseImageRef(s string) (ImageRef, error) {
+	var ref ImageRef
+
+	rest := s
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		ref.Digest = Digest(rest[i+1:])
+		if err := ValidateDigest(ref.Digest); err != nil {
+			return ImageRef{}, err
+		}
+		rest = rest[:i]
+	}
+
+	if i := strings.LastIndex(rest, ":"); i > strings.LastIndex(rest, "/") {
+		ref.Tag = Tag(rest[i+1:])
+		if err := ValidateTag(ref.Tag); err != nil {
+			return ImageRef{}, err
+		}
+		rest = rest[:i]
+	}
+
+	registry, imageName, err := ParseContainerParts(rest)
+	if err != nil {
+		return ImageRef{}, fmt.Errorf("invalid image reference %q: %w", s, err)
+	}
+	ref.Registry = RegistryName(registry)
+	ref.ImageName = ImageName(imageName)
+
+	return ref, nil
+}
+
+// String returns the reference in the form that ParseImageRef() parses.
+func (ref ImageRef) String() string {
+	s := string(ref.Registry) + "/" + string(ref.ImageName)
+	if ref.Tag != "" {
+		s += ":" + string(ref.Tag)
+	}
+	if ref.Digest != "" {
+		s += "@" + string(ref.Digest)
+	}
+
+	return s
+}
+
+// ResolveImageRef returns ref with the digest that its tag points to in the
+// registry, if it is only referenced by tag.
+func (sc *SyncContext) ResolveImageRef(ref ImageRef) (ImageRef, error) {
+	if ref.Digest != "" {
+		return ref, nil
+	}
+	if ref.Tag == "" {
+		return ImageRef{}, fmt.Errorf("%s has neither a tag nor a digest", ref)
+	}
+
+	parsed, err := name.ParseReference(
+		ToPQIN(ref.Registry, ref.ImageName, ref.Tag))
+	if err != nil {
+		return ImageRef{}, err
+	}
+
+	desc, err := remote.Head(
+		parsed,
+		append(sc.remoteOptions(), remote.WithContext(sc.ctx()))...)
+	if err != nil {
+		return ImageRef{}, fmt.Errorf("resolving %s: %w", ref, err)
+	}
+	ref.Digest = Digest(desc.Digest.String())
+
+	return ref, nil
+}
+
+// CopyToManifest builds the Manifest that promotes the single image src to
+// dest, e.g. for an ad hoc copy of "gcr.io/foo/bar@sha256:..." to
+// "gcr.io/baz/bar:1.0". The image must be referenced by digest in src (see
+// ResolveImageRef()); if dest has no tag, the tag of src is used (if any).
+//
+// Manifests cannot rename images, so both references must end in the same
+// image name. The rest of their paths become the registries, so that e.g.
+// "gcr.io/foo/bar" may be copied to "registry.example.com/mirror/bar".
+func CopyToManifest(
+	src ImageRef,
+	dest ImageRef,
+	srcSvcAcct string,
+	destSvcAcct string,
+) (Manifest, error) {
+	if src.Digest == "" {
+		return Manifest{}, fmt.Errorf(
+			"the source image %s must be referenced by digest",
+			src)
+	}
+	if dest.Digest != "" && dest.Digest != src.Digest {
+		return Manifest{}, fmt.Errorf(
+			"the destination digest %s differs from the source digest %s",
+			dest.Digest,
+			src.Digest)
+	}
+
+	srcRegistry, destRegistry, imageName, err := splitCommonImageName(
+		string(src.Registry)+"/"+string(src.ImageName),
+		string(dest.Registry)+"/"+string(dest.ImageName))
+	if err != nil {
+		return Manifest{}, err
+	}
+	if srcRegistry == destRegistry {
+		return Manifest{}, fmt.Errorf(
+			"cannot copy %s/%s onto itself",
+			srcRegistry,
+			imageName)
+	}
+
+	tag := dest.Tag
+	if tag == "" {
+		tag = src.Tag
+	}
+	tags := TagSlice{}
+	if tag != "" {
+		tags = TagSlice{tag}
+	}
+
+	mfest := Manifest{
+		Registries: []RegistryContext{
+			{
+				Name:           srcRegistry,
+				ServiceAccount: srcSvcAcct,
+				Src:            true,
+			},
+			{
+				Name:           destRegistry,
+				ServiceAccount: destSvcAcct,
+			},
+		},
+		Images: []Image{
+			{
+				ImageName: imageName,
+				Dmap:      DigestTags{src.Digest: tags},
+			},
+		},
+	}
+	if err := mfest.Finalize(); err != nil {
+		return Manifest{}, err
+	}
+
+	return mfest, nil
+}
+
+// splitCommonImageName splits the image paths src and dest at the start of
+// their longest common suffix (of whole path components), which becomes the
+// image name. At least the first path component of each is kept for its
+// registry.
+func splitCommonImageName(
+	src string,
+	dest string,
+) (RegistryName, RegistryName, ImageName, error) {
+	srcParts := strings.Split(src, "/")
+	destParts := strings.Split(dest, "/")
+
+	common := 0
+	for common < len(srcParts)-1 && common < len(destParts)-1 &&
+		srcParts[len(srcParts)-1-common] == destParts[len(destParts)-1-common] {
+		common++
+	}
+	if common == 0 {
+		return "", "", "", fmt.Errorf(
+			"cannot copy %s to %s: the image name must stay the same",
+			src,
+			dest)
+	}
+
+	return RegistryName(strings.Join(srcParts[:len(srcParts)-common], "/")),
+		RegistryName(strings.Join(destParts[:len(destParts)-common], "/")),
+		ImageName(strings.Join(srcParts[len(srcParts)-common:], "/")),
+		nil
+}
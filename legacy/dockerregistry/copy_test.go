@@ -0,0 +1,264 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/testregistry"
+)
+
+var copyDigest = reg.Digest("sha256:" + strings.Repeat("c", 64))
+
+func TestParseImageRef(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		expected    reg.ImageRef
+		expectedErr bool
+	}{
+		{
+			"By digest",
+			"gcr.io/foo/bar@" + string(copyDigest),
+			reg.ImageRef{
+				Registry:  "gcr.io/foo",
+				ImageName: "bar",
+				Digest:    copyDigest,
+			},
+			false,
+		},
+		{
+			"By tag",
+			"gcr.io/foo/a/b:1.0",
+			reg.ImageRef{
+				Registry:  "gcr.io/foo",
+				ImageName: "a/b",
+				Tag:       "1.0",
+			},
+			false,
+		},
+		{
+			"By tag and digest, with a port",
+			"localhost:5000/bar:1.0@" + string(copyDigest),
+			reg.ImageRef{
+				Registry:  "localhost:5000",
+				ImageName: "bar",
+				Tag:       "1.0",
+				Digest:    copyDigest,
+			},
+			false,
+		},
+		{
+			"Neither tag nor digest",
+			"localhost:5000/bar",
+			reg.ImageRef{
+				Registry:  "localhost:5000",
+				ImageName: "bar",
+			},
+			false,
+		},
+		{
+			"Invalid digest",
+			"gcr.io/foo/bar@sha256:123",
+			reg.ImageRef{},
+			true,
+		},
+		{
+			"Invalid tag",
+			"gcr.io/foo/bar:-1.0",
+			reg.ImageRef{},
+			true,
+		},
+		{
+			"Missing image",
+			"gcr.io/foo:1.0",
+			reg.ImageRef{},
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		got, err := reg.ParseImageRef(test.input)
+		if test.expectedErr {
+			require.NotNil(t, err, test.name)
+			continue
+		}
+
+		require.Nil(t, err, test.name)
+		require.Equal(t, test.expected, got, test.name)
+		require.Equal(t, test.input, got.String(), test.name)
+	}
+}
+
+func TestCopyToManifest(t *testing.T) {
+	mkEdge := func(
+		src reg.RegistryName,
+		dest reg.RegistryName,
+		imageName reg.ImageName,
+		tag reg.Tag,
+	) reg.PromotionEdge {
+		return reg.PromotionEdge{
+			SrcRegistry: reg.RegistryContext{
+				Name:           src,
+				ServiceAccount: "src-robot",
+				Src:            true,
+			},
+			SrcImageTag: reg.ImageTag{ImageName: imageName, Tag: tag},
+			Digest:      copyDigest,
+			DstRegistry: reg.RegistryContext{
+				Name:           dest,
+				ServiceAccount: "dest-robot",
+			},
+			DstImageTag: reg.ImageTag{ImageName: imageName, Tag: tag},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		src         string
+		dest        string
+		expected    reg.PromotionEdge
+		expectedErr string
+	}{
+		{
+			"Digest to digest (tagless)",
+			"gcr.io/foo/bar@" + string(copyDigest),
+			"gcr.io/baz/bar",
+			mkEdge("gcr.io/foo", "gcr.io/baz", "bar", ""),
+			"",
+		},
+		{
+			"Digest to tag",
+			"gcr.io/foo/bar@" + string(copyDigest),
+			"gcr.io/baz/bar:1.0",
+			mkEdge("gcr.io/foo", "gcr.io/baz", "bar", "1.0"),
+			"",
+		},
+		{
+			"The tag of the source is kept",
+			"gcr.io/foo/bar:1.0@" + string(copyDigest),
+			"gcr.io/baz/bar",
+			mkEdge("gcr.io/foo", "gcr.io/baz", "bar", "1.0"),
+			"",
+		},
+		{
+			"The destination tag wins",
+			"gcr.io/foo/bar:1.0@" + string(copyDigest),
+			"gcr.io/baz/bar:stable@" + string(copyDigest),
+			mkEdge("gcr.io/foo", "gcr.io/baz", "bar", "stable"),
+			"",
+		},
+		{
+			"Nested images in other registries",
+			"gcr.io/foo/a/bar@" + string(copyDigest),
+			"registry.example.com/mirror/a/bar:1.0",
+			mkEdge("gcr.io/foo", "registry.example.com/mirror", "a/bar", "1.0"),
+			"",
+		},
+		{
+			"Source by tag only",
+			"gcr.io/foo/bar:1.0",
+			"gcr.io/baz/bar",
+			reg.PromotionEdge{},
+			"the source image gcr.io/foo/bar:1.0 must be referenced by digest",
+		},
+		{
+			"Mismatching digests",
+			"gcr.io/foo/bar@" + string(copyDigest),
+			"gcr.io/baz/bar@" + string(restoreDigestA),
+			reg.PromotionEdge{},
+			"the destination digest " + string(restoreDigestA) +
+				" differs from the source digest " + string(copyDigest),
+		},
+		{
+			"Renamed image",
+			"gcr.io/foo/bar@" + string(copyDigest),
+			"gcr.io/baz/qux",
+			reg.PromotionEdge{},
+			"cannot copy gcr.io/foo/bar to gcr.io/baz/qux: the image name must stay the same",
+		},
+		{
+			"Onto itself",
+			"gcr.io/foo/bar@" + string(copyDigest),
+			"gcr.io/foo/bar:1.0",
+			reg.PromotionEdge{},
+			"cannot copy gcr.io/foo/bar onto itself",
+		},
+	}
+
+	for _, test := range tests {
+		src, err := reg.ParseImageRef(test.src)
+		require.Nil(t, err, test.name)
+		dest, err := reg.ParseImageRef(test.dest)
+		require.Nil(t, err, test.name)
+
+		mfest, err := reg.CopyToManifest(src, dest, "src-robot", "dest-robot")
+		if test.expectedErr != "" {
+			require.NotNil(t, err, test.name)
+			require.Equal(t, test.expectedErr, err.Error(), test.name)
+			continue
+		}
+		require.Nil(t, err, test.name)
+
+		edges, err := reg.ToPromotionEdges([]reg.Manifest{mfest})
+		require.Nil(t, err, test.name)
+		require.Equal(
+			t,
+			map[reg.PromotionEdge]interface{}{test.expected: nil},
+			edges,
+			test.name)
+	}
+}
+
+func TestResolveImageRef(t *testing.T) {
+	r := testregistry.New()
+	defer r.Close()
+
+	digest, err := r.PushRandom("prod/foo", "1.0")
+	require.Nil(t, err)
+
+	sc := reg.SyncContext{}
+
+	// By tag.
+	ref, err := reg.ParseImageRef(reg.ToPQIN(r.Name("prod"), "foo", "1.0"))
+	require.Nil(t, err)
+	got, err := sc.ResolveImageRef(ref)
+	require.Nil(t, err)
+	require.Equal(t, digest, got.Digest)
+	require.Equal(t, reg.Tag("1.0"), got.Tag)
+
+	// By digest, which is kept as is.
+	ref.Digest = copyDigest
+	got, err = sc.ResolveImageRef(ref)
+	require.Nil(t, err)
+	require.Equal(t, ref, got)
+
+	// Unknown tag.
+	ref, err = reg.ParseImageRef(reg.ToPQIN(r.Name("prod"), "foo", "2.0"))
+	require.Nil(t, err)
+	_, err = sc.ResolveImageRef(ref)
+	require.NotNil(t, err)
+
+	// Neither tag nor digest.
+	ref.Tag = ""
+	_, err = sc.ResolveImageRef(ref)
+	require.NotNil(t, err)
+}
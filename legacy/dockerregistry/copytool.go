@@ -0,0 +1,255 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/gcloud"
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/stream"
+)
+
+// Names of the supported copy tools.
+const (
+	CopyToolCrane  = "crane"
+	CopyToolSkopeo = "skopeo"
+	CopyToolGcloud = "gcloud"
+)
+
+var copyTools = map[string]CopyTool{
+	CopyToolCrane:  CraneCopyTool{},
+	CopyToolSkopeo: SkopeoCopyTool{},
+	CopyToolGcloud: GcloudCopyTool{},
+}
+
+// CopyTools returns the names of all supported copy tools, sorted.
+func CopyTools() []string {
+	names := make([]string, 0, len(copyTools))
+	for name := range copyTools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// MkCopyTool returns the CopyTool with the given name.
+func MkCopyTool(name string) (CopyTool, error) {
+	tool, ok := copyTools[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf(
+			"unknown copy tool %q (supported tools: %q)", name, CopyTools())
+	}
+
+	return tool, nil
+}
+
+// CraneCopyTool copies images with "crane copy". Credentials are taken from
+// the Docker config (e.g., a gcloud credential helper).
+type CraneCopyTool struct{}
+
+// Name implements CopyTool.
+func (CraneCopyTool) Name() string {
+	return CopyToolCrane
+}
+
+// CopyCmd implements CopyTool.
+func (CraneCopyTool) CopyCmd(
+	dest RegistryContext,
+	useServiceAccount bool,
+	srcImage string,
+	dstImage string,
+) []string {
+	return []string{
+		"crane",
+		"copy",
+		srcImage,
+		dstImage,
+	}
+}
+
+// TagCmd implements CopyTool.
+func (CraneCopyTool) TagCmd(
+	dest RegistryContext,
+	useServiceAccount bool,
+	imageName ImageName,
+	digest Digest,
+	tag Tag,
+) []string {
+	return []string{
+		"crane",
+		"tag",
+		ToFQIN(dest.Name, imageName, digest),
+		string(tag),
+	}
+}
+
+// SkopeoCopyTool copies images (including all images of a manifest list) with
+// "skopeo copy". Credentials are taken from the Docker config.
+type SkopeoCopyTool struct {
+	// CertDirs maps registry hosts to the directory with their client
+	// certificates and CAs (see RegistryTLS.CertDir()).
+	CertDirs map[string]string
+}
+
+// Name implements CopyTool.
+func (SkopeoCopyTool) Name() string {
+	return CopyToolSkopeo
+}
+
+// CopyCmd implements CopyTool.
+func (t SkopeoCopyTool) CopyCmd(
+	dest RegistryContext,
+	useServiceAccount bool,
+	srcImage string,
+	dstImage string,
+) []string {
+	cmd := []string{
+		"skopeo",
+		"copy",
+		"--all",
+	}
+
+	srcHost := strings.SplitN(srcImage, "/", 2)[0]
+	if dir, ok := t.CertDirs[srcHost]; ok {
+		cmd = append(cmd, "--src-cert-dir", dir)
+	}
+	dstHost := strings.SplitN(dstImage, "/", 2)[0]
+	if dir, ok := t.CertDirs[dstHost]; ok {
+		cmd = append(cmd, "--dest-cert-dir", dir)
+	}
+
+	return append(
+		cmd,
+		"docker://"+srcImage,
+		"docker://"+dstImage,
+	)
+}
+
+// TagCmd implements CopyTool. Skopeo cannot tag images, but as all of the
+// blobs are already in dest, copying the image onto itself only writes the
+// manifest under the new tag.
+func (t SkopeoCopyTool) TagCmd(
+	dest RegistryContext,
+	useServiceAccount bool,
+	imageName ImageName,
+	digest Digest,
+	tag Tag,
+) []string {
+	return t.CopyCmd(
+		dest,
+		useServiceAccount,
+		ToFQIN(dest.Name, imageName, digest),
+		ToPQIN(dest.Name, imageName, tag),
+	)
+}
+
+// GcloudCopyTool copies images with "gcloud container images add-tag", using
+// the service account of the destination registry if desired.
+type GcloudCopyTool struct{}
+
+// Name implements CopyTool.
+func (GcloudCopyTool) Name() string {
+	return CopyToolGcloud
+}
+
+// CopyCmd implements CopyTool.
+func (GcloudCopyTool) CopyCmd(
+	dest RegistryContext,
+	useServiceAccount bool,
+	srcImage string,
+	dstImage string,
+) []string {
+	cmd := []string{
+		"gcloud",
+		"--quiet",
+		"container",
+		"images",
+		"add-tag",
+		srcImage,
+		dstImage,
+	}
+
+	return gcloud.MaybeUseServiceAccount(
+		dest.ServiceAccount,
+		useServiceAccount,
+		cmd,
+	)
+}
+
+// TagCmd implements CopyTool. "add-tag" within the same registry only writes
+// the tag.
+func (GcloudCopyTool) TagCmd(
+	dest RegistryContext,
+	useServiceAccount bool,
+	imageName ImageName,
+	digest Digest,
+	tag Tag,
+) []string {
+	return GcloudCopyTool{}.CopyCmd(
+		dest,
+		useServiceAccount,
+		ToFQIN(dest.Name, imageName, digest),
+		ToPQIN(dest.Name, imageName, tag),
+	)
+}
+
+// runCopyProcess runs the copy command of a request to completion. Unlike
+// getJSONSFromProcess(), output on stderr is not treated as an error, because
+// copy tools print their progress there.
+func runCopyProcess(req stream.ExternalRequest) Errors {
+	errs := make(Errors, 0)
+
+	stdoutReader, stderrReader, err := req.StreamProducer.Produce()
+	if err != nil {
+		return append(errs, Error{
+			Context: "running copy process",
+			Error:   err,
+		})
+	}
+
+	stderr := make(chan []byte)
+	go func() {
+		be, _ := ioutil.ReadAll(stderrReader)
+		stderr <- be
+	}()
+
+	bo, err := ioutil.ReadAll(stdoutReader)
+	if err != nil {
+		errs = append(errs, Error{
+			Context: "reading copy process stdout",
+			Error:   err,
+		})
+	}
+	be := <-stderr
+
+	log := requestLogger(req.RequestParams)
+	log.Debugf("copy process stdout: %s", bo)
+	log.Debugf("copy process stderr: %s", be)
+
+	if err := req.StreamProducer.Close(); err != nil {
+		errs = append(errs, Error{
+			Context: "closing copy process",
+			Error:   fmt.Errorf("%v: %s", err, be),
+		})
+	}
+
+	return errs
+}
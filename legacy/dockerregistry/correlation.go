@@ -0,0 +1,101 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CorrelationIDField is the log field that carries the correlation ID of the
+// promotion edge a log line is about.
+const CorrelationIDField = "edge"
+
+// correlationIDLen is the number of hex characters in a correlation ID.
+const correlationIDLen = 8
+
+// mkCorrelationID derives a short ID from the parts of a promotion edge. The
+// ID is stable across runs, so that the logs of a retried promotion can be
+// compared with those of the original one.
+func mkCorrelationID(
+	srcRegistry RegistryName,
+	srcImageName ImageName,
+	dstRegistry RegistryName,
+	dstImageName ImageName,
+	digest Digest,
+	tag Tag) string {
+
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		string(srcRegistry),
+		string(srcImageName),
+		string(dstRegistry),
+		string(dstImageName),
+		string(digest),
+		string(tag),
+	}, "\x00")))
+
+	return hex.EncodeToString(sum[:])[:correlationIDLen]
+}
+
+// CorrelationID returns the ID that tags all log lines about this edge.
+func (edge *PromotionEdge) CorrelationID() string {
+	return mkCorrelationID(
+		edge.SrcRegistry.Name,
+		edge.SrcImageTag.ImageName,
+		edge.DstRegistry.Name,
+		edge.DstImageTag.ImageName,
+		edge.Digest,
+		edge.DstImageTag.Tag)
+}
+
+// CorrelationID returns the ID of the edge this request was made for.
+func (pr *PromotionRequest) CorrelationID() string {
+	return mkCorrelationID(
+		pr.RegistrySrc,
+		pr.ImageNameSrc,
+		pr.RegistryDest,
+		pr.ImageNameDest,
+		pr.Digest,
+		pr.Tag)
+}
+
+// edgeLogger returns a logger whose lines carry the edge's correlation ID.
+func edgeLogger(edge *PromotionEdge) *logrus.Entry {
+	return logrus.WithField(CorrelationIDField, edge.CorrelationID())
+}
+
+// requestLogger returns a logger for lines about the given request. Lines
+// about promotion requests carry the correlation ID of their edge.
+func requestLogger(requestParams interface{}) *logrus.Entry {
+	if pr, ok := requestParams.(PromotionRequest); ok {
+		return logrus.WithField(CorrelationIDField, pr.CorrelationID())
+	}
+
+	return logrus.NewEntry(logrus.StandardLogger())
+}
+
+// logCorrelationIDs logs which edge each correlation ID stands for.
+func logCorrelationIDs(edges map[PromotionEdge]interface{}) {
+	logrus.Info("Correlation IDs:")
+	for _, edge := range SortedPromotionEdges(edges) {
+		edge := edge
+		edgeLogger(&edge).Infof("  %s: %v", edge.CorrelationID(), edge)
+	}
+}
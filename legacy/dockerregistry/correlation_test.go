@@ -0,0 +1,140 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+func TestCorrelationID(t *testing.T) {
+	edge := reg.PromotionEdge{
+		SrcRegistry: reg.RegistryContext{Name: "gcr.io/foo"},
+		SrcImageTag: reg.ImageTag{ImageName: "a", Tag: "1.0"},
+		Digest:      "sha256:000",
+		DstRegistry: reg.RegistryContext{Name: "gcr.io/bar"},
+		DstImageTag: reg.ImageTag{ImageName: "a", Tag: "1.0"},
+	}
+	pr := reg.PromotionRequest{
+		TagOp:         reg.Add,
+		RegistrySrc:   "gcr.io/foo",
+		RegistryDest:  "gcr.io/bar",
+		ImageNameSrc:  "a",
+		ImageNameDest: "a",
+		Digest:        "sha256:000",
+		Tag:           "1.0",
+	}
+
+	id := edge.CorrelationID()
+	require.Len(t, id, 8)
+	require.Equal(t, id, edge.CorrelationID())
+	require.Equal(t, id, pr.CorrelationID())
+
+	// Any change to the edge changes its ID.
+	other := edge
+	other.DstImageTag.Tag = "1.1"
+	require.NotEqual(t, id, other.CorrelationID())
+	other = edge
+	other.Digest = "sha256:111"
+	require.NotEqual(t, id, other.CorrelationID())
+}
+
+func TestPromotionLogsCorrelationIDs(t *testing.T) {
+	srcRC := reg.RegistryContext{
+		Name:           "gcr.io/foo",
+		ServiceAccount: "robot",
+		Src:            true,
+	}
+	destRC := reg.RegistryContext{
+		Name:           "gcr.io/bar",
+		ServiceAccount: "robot",
+	}
+
+	edges, err := reg.ToPromotionEdges([]reg.Manifest{
+		{
+			Registries: []reg.RegistryContext{srcRC, destRC},
+			Images: []reg.Image{
+				{
+					ImageName: "a",
+					Dmap: reg.DigestTags{
+						"sha256:000": {"tag-alpha", "tag-beta"},
+						"sha256:111": {"tag-gamma"},
+					},
+				},
+			},
+			SrcRegistry: &srcRC,
+		},
+	})
+	require.Nil(t, err)
+
+	// The copy for one of the edges fails, so that errors are logged too.
+	mkProducer := mkFailingTagProducer("tag-gamma")
+
+	logger := logrus.StandardLogger()
+	defer logger.ReplaceHooks(logger.ReplaceHooks(make(logrus.LevelHooks)))
+	hook := logtest.NewLocal(logger)
+
+	sc := reg.SyncContext{
+		Inv:      reg.MasterInventory{},
+		CopyTool: reg.CraneCopyTool{},
+	}
+	err = sc.Promote(edges, mkProducer, nil)
+	require.NotNil(t, err)
+
+	tags := make(map[string]reg.Tag)
+	for edge := range edges {
+		edge := edge
+		tags[edge.CorrelationID()] = edge.DstImageTag.Tag
+	}
+	require.Len(t, tags, len(edges))
+
+	// Every line about an edge carries its ID, and only mentions that edge.
+	entries := make(map[string][]string)
+	for _, entry := range hook.AllEntries() {
+		id, ok := entry.Data[reg.CorrelationIDField].(string)
+		if !ok {
+			continue
+		}
+
+		tag, ok := tags[id]
+		require.True(t, ok, "unknown correlation ID %q: %s", id, entry.Message)
+		for otherID, otherTag := range tags {
+			if otherID != id {
+				require.NotContains(t, entry.Message, string(otherTag))
+			}
+		}
+		if strings.Contains(entry.Message, string(tag)) {
+			entries[id] = append(entries[id], entry.Message)
+		}
+	}
+
+	for id, tag := range tags {
+		var result, summary bool
+		for _, msg := range entries[id] {
+			result = result || strings.HasPrefix(msg, "Request ")
+			summary = summary || strings.HasPrefix(msg, "  "+id+": ")
+		}
+		require.True(t, result, "no result logged for %s", tag)
+		require.True(t, summary, "no summary logged for %s", tag)
+	}
+}
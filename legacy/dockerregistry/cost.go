@@ -0,0 +1,263 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v2"
+)
+
+// bytesPerGiB is the number of bytes that the prices of a CostTable are given
+// for.
+const bytesPerGiB = 1 << 30
+
+// CostTable holds the prices that EstimateCost() estimates the cost of a
+// promotion with. The prices of a destination registry are looked up by its
+// name (e.g., "eu.gcr.io/k8s-artifacts-prod"), then by its host (e.g.,
+// "eu.gcr.io"); registries without either use the Default prices. For example:
+//
+//	default:
+//	  egressPerGiB: 0.12
+//	  storagePerGiBMonth: 0.026
+//	registries:
+//	  us.gcr.io:
+//	    egressPerGiB: 0.01
+type CostTable struct {
+	Default    Prices            `yaml:"default"`
+	Registries map[string]Prices `yaml:"registries,omitempty"`
+}
+
+// Prices are the prices of copying images to a registry (per GiB copied) and
+// of storing them there (per GiB and month), in any currency.
+type Prices struct {
+	EgressPerGiB       float64 `yaml:"egressPerGiB"`
+	StoragePerGiBMonth float64 `yaml:"storagePerGiBMonth"`
+}
+
+// ParseCostTableFromFile parses a CostTable from a file.
+func ParseCostTableFromFile(filePath string) (CostTable, error) {
+	b, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return CostTable{}, err
+	}
+
+	table, err := ParseCostTableYAML(b)
+	if err != nil {
+		return CostTable{}, fmt.Errorf("%s: %v", filePath, err)
+	}
+
+	return table, nil
+}
+
+// ParseCostTableYAML parses a CostTable from a byteslice.
+func ParseCostTableYAML(b []byte) (CostTable, error) {
+	var t CostTable
+	if err := yaml.UnmarshalStrict(b, &t); err != nil {
+		return t, err
+	}
+
+	return t, t.Validate()
+}
+
+// Validate checks that no price is negative.
+func (t CostTable) Validate() error {
+	if err := t.Default.validate("default"); err != nil {
+		return err
+	}
+
+	registries := make([]string, 0, len(t.Registries))
+	for registry := range t.Registries {
+		registries = append(registries, registry)
+	}
+	sort.Strings(registries)
+
+	for _, registry := range registries {
+		if err := t.Registries[registry].validate(
+			fmt.Sprintf("registry %q", registry),
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p Prices) validate(context string) error {
+	if p.EgressPerGiB < 0 || p.StoragePerGiBMonth < 0 {
+		return fmt.Errorf("%s: prices cannot be negative", context)
+	}
+
+	return nil
+}
+
+// PricesOf returns the prices of the destination registry.
+func (t CostTable) PricesOf(registry RegistryName) Prices {
+	if prices, ok := t.Registries[string(registry)]; ok {
+		return prices
+	}
+
+	host := strings.SplitN(string(registry), "/", 2)[0]
+	if prices, ok := t.Registries[host]; ok {
+		return prices
+	}
+
+	return t.Default
+}
+
+// DestinationCost is the estimated cost of the images that a promotion copies
+// to one destination registry.
+type DestinationCost struct {
+	Registry RegistryName
+	// Digests is the number of images that are copied to the registry. Every
+	// digest is only counted once per registry, however many images and tags
+	// it is promoted to.
+	Digests int
+	// UnknownSizes is the number of those Digests whose size is unknown (and
+	// is left out of Bytes).
+	UnknownSizes int
+	// Bytes is the combined size of the Digests.
+	Bytes               int64
+	EgressCost          float64
+	StorageCostPerMonth float64
+}
+
+// CostEstimate is the estimated cost of a promotion, per destination
+// registry (sorted by name).
+type CostEstimate struct {
+	Destinations []DestinationCost
+}
+
+// EstimateCost estimates the egress and storage cost of promoting the edges,
+// given the sizes of their images. Images whose digest already is in the
+// destination registry (according to inv) cost nothing, as they are not copied
+// again. Layers that images share are counted once for each image, so the
+// estimate errs on the high side.
+func EstimateCost(
+	edges map[PromotionEdge]interface{},
+	inv MasterInventory,
+	sizes DigestImageSize,
+	table CostTable,
+) CostEstimate {
+	copied := make(map[RegistryName]map[Digest]interface{})
+	for edge := range edges {
+		dst := edge.DstRegistry.Name
+		if copied[dst] == nil {
+			copied[dst] = make(map[Digest]interface{})
+		}
+
+		if hasDigest(inv[dst], edge.Digest) {
+			continue
+		}
+		copied[dst][edge.Digest] = nil
+	}
+
+	estimate := CostEstimate{
+		Destinations: make([]DestinationCost, 0, len(copied)),
+	}
+	for dst, digests := range copied {
+		cost := DestinationCost{Registry: dst, Digests: len(digests)}
+		for digest := range digests {
+			size, ok := sizes[digest]
+			if !ok {
+				cost.UnknownSizes++
+				continue
+			}
+			cost.Bytes += int64(size)
+		}
+
+		prices := table.PricesOf(dst)
+		gib := float64(cost.Bytes) / bytesPerGiB
+		cost.EgressCost = gib * prices.EgressPerGiB
+		cost.StorageCostPerMonth = gib * prices.StoragePerGiBMonth
+
+		estimate.Destinations = append(estimate.Destinations, cost)
+	}
+
+	sort.Slice(estimate.Destinations, func(i, j int) bool {
+		return estimate.Destinations[i].Registry <
+			estimate.Destinations[j].Registry
+	})
+
+	return estimate
+}
+
+// hasDigest returns true if any image of rii has the digest.
+func hasDigest(rii RegInvImage, digest Digest) bool {
+	for _, digestTags := range rii {
+		if _, ok := digestTags[digest]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Total adds up the costs of all destinations. Its Registry is empty.
+func (e CostEstimate) Total() DestinationCost {
+	var total DestinationCost
+	for _, cost := range e.Destinations {
+		total.Digests += cost.Digests
+		total.UnknownSizes += cost.UnknownSizes
+		total.Bytes += cost.Bytes
+		total.EgressCost += cost.EgressCost
+		total.StorageCostPerMonth += cost.StorageCostPerMonth
+	}
+
+	return total
+}
+
+// ToTable prints the estimate as an aligned table, with a line for each
+// destination registry and one for the total.
+//
+// E.g.
+//
+// DESTINATION  DIGESTS  UNKNOWN SIZE  SIZE (GiB)  EGRESS  STORAGE/MONTH
+// gcr.io/prod  2        0             1.50        0.18    0.03
+// TOTAL        2        0             1.50        0.18    0.03
+func (e CostEstimate) ToTable() string {
+	var b strings.Builder
+	// nolint[gomnd]
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "DESTINATION\tDIGESTS\tUNKNOWN SIZE\tSIZE (GiB)\tEGRESS\tSTORAGE/MONTH\n")
+
+	printCost := func(name string, cost DestinationCost) {
+		fmt.Fprintf(
+			w,
+			"%s\t%d\t%d\t%.2f\t%.2f\t%.2f\n",
+			name,
+			cost.Digests,
+			cost.UnknownSizes,
+			float64(cost.Bytes)/bytesPerGiB,
+			cost.EgressCost,
+			cost.StorageCostPerMonth)
+	}
+	for _, cost := range e.Destinations {
+		printCost(string(cost.Registry), cost)
+	}
+	printCost("TOTAL", e.Total())
+
+	// Writing to a strings.Builder cannot fail.
+	// nolint[errcheck]
+	w.Flush()
+
+	return b.String()
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+func TestParseCostTableYAML(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expectedTable reg.CostTable
+		expectedErr   error
+	}{
+		{
+			"Valid table",
+			`default:
+  egressPerGiB: 0.12
+  storagePerGiBMonth: 0.026
+registries:
+  us.gcr.io:
+    egressPerGiB: 0.01
+`,
+			reg.CostTable{
+				Default: reg.Prices{
+					EgressPerGiB:       0.12,
+					StoragePerGiBMonth: 0.026,
+				},
+				Registries: map[string]reg.Prices{
+					"us.gcr.io": {EgressPerGiB: 0.01},
+				},
+			},
+			nil,
+		},
+		{
+			"Negative default price",
+			`default:
+  egressPerGiB: -0.12
+`,
+			reg.CostTable{},
+			fmt.Errorf("default: prices cannot be negative"),
+		},
+		{
+			"Negative registry price",
+			`registries:
+  us.gcr.io:
+    storagePerGiBMonth: -1
+`,
+			reg.CostTable{},
+			fmt.Errorf(`registry "us.gcr.io": prices cannot be negative`),
+		},
+	}
+
+	for _, test := range tests {
+		table, err := reg.ParseCostTableYAML([]byte(test.input))
+		if test.expectedErr != nil {
+			require.NotNil(t, err, test.name)
+			require.Equal(t, test.expectedErr.Error(), err.Error(), test.name)
+			continue
+		}
+		require.Nil(t, err, test.name)
+		require.Equal(t, test.expectedTable, table, test.name)
+	}
+
+	// Unknown fields are rejected.
+	_, err := reg.ParseCostTableYAML([]byte(`default:
+  ingressPerGiB: 0.12
+`))
+	require.NotNil(t, err)
+}
+
+func TestEstimateCost(t *testing.T) {
+	const (
+		d1 = reg.Digest("sha256:1111111111111111111111111111111111111111111111111111111111111111")
+		d2 = reg.Digest("sha256:2222222222222222222222222222222222222222222222222222222222222222")
+		d3 = reg.Digest("sha256:3333333333333333333333333333333333333333333333333333333333333333")
+	)
+
+	srcRC := reg.RegistryContext{
+		Name: "gcr.io/staging",
+		Src:  true,
+	}
+	mkEdge := func(
+		dst reg.RegistryName,
+		imageName reg.ImageName,
+		digest reg.Digest,
+		tag reg.Tag,
+	) reg.PromotionEdge {
+		return reg.PromotionEdge{
+			SrcRegistry: srcRC,
+			SrcImageTag: reg.ImageTag{
+				ImageName: imageName,
+				Tag:       tag,
+			},
+			Digest:      digest,
+			DstRegistry: reg.RegistryContext{Name: dst},
+			DstImageTag: reg.ImageTag{
+				ImageName: imageName,
+				Tag:       tag,
+			},
+		}
+	}
+
+	edges := map[reg.PromotionEdge]interface{}{
+		// The same digest is only copied once to a registry.
+		mkEdge("gcr.io/prod", "a", d1, "1.0"):    nil,
+		mkEdge("gcr.io/prod", "b", d1, "1.0"):    nil,
+		mkEdge("gcr.io/prod", "a", d2, "2.0"):    nil,
+		mkEdge("us.gcr.io/prod", "a", d1, "1.0"): nil,
+		mkEdge("us.gcr.io/prod", "a", d3, "3.0"): nil,
+		mkEdge("eu.gcr.io/prod", "a", d1, "1.0"): nil,
+		mkEdge("eu.gcr.io/prod", "a", d2, "2.0"): nil,
+	}
+	inv := reg.MasterInventory{
+		// d2 already is in the registry (under another name), so it is not
+		// copied again.
+		"eu.gcr.io/prod": reg.RegInvImage{
+			"old": reg.DigestTags{d2: {"2.0"}},
+		},
+	}
+	sizes := reg.DigestImageSize{
+		d1: 1 << 30,
+		d2: 1 << 29,
+	}
+	table := reg.CostTable{
+		Default: reg.Prices{EgressPerGiB: 0.12, StoragePerGiBMonth: 0.02},
+		Registries: map[string]reg.Prices{
+			// Prices of a registry...
+			"eu.gcr.io/prod": {EgressPerGiB: 0.08, StoragePerGiBMonth: 0.026},
+			// ... and of a host.
+			"us.gcr.io": {EgressPerGiB: 0.01, StoragePerGiBMonth: 0.02},
+		},
+	}
+
+	estimate := reg.EstimateCost(edges, inv, sizes, table)
+	expected := []reg.DestinationCost{
+		{
+			Registry:            "eu.gcr.io/prod",
+			Digests:             1,
+			Bytes:               1 << 30,
+			EgressCost:          0.08,
+			StorageCostPerMonth: 0.026,
+		},
+		{
+			Registry:            "gcr.io/prod",
+			Digests:             2,
+			Bytes:               1<<30 + 1<<29,
+			EgressCost:          0.18,
+			StorageCostPerMonth: 0.03,
+		},
+		{
+			Registry:            "us.gcr.io/prod",
+			Digests:             2,
+			UnknownSizes:        1,
+			Bytes:               1 << 30,
+			EgressCost:          0.01,
+			StorageCostPerMonth: 0.02,
+		},
+	}
+	require.Len(t, estimate.Destinations, len(expected))
+	for i, cost := range estimate.Destinations {
+		require.Equal(t, expected[i].Registry, cost.Registry)
+		require.Equal(t, expected[i].Digests, cost.Digests)
+		require.Equal(t, expected[i].UnknownSizes, cost.UnknownSizes)
+		require.Equal(t, expected[i].Bytes, cost.Bytes)
+		require.InDelta(t, expected[i].EgressCost, cost.EgressCost, 1e-9)
+		require.InDelta(
+			t,
+			expected[i].StorageCostPerMonth,
+			cost.StorageCostPerMonth,
+			1e-9)
+	}
+
+	require.Equal(
+		t,
+		`DESTINATION     DIGESTS  UNKNOWN SIZE  SIZE (GiB)  EGRESS  STORAGE/MONTH
+eu.gcr.io/prod  1        0             1.00        0.08    0.03
+gcr.io/prod     2        0             1.50        0.18    0.03
+us.gcr.io/prod  2        1             1.00        0.01    0.02
+TOTAL           5        1             3.50        0.27    0.08
+`,
+		estimate.ToTable())
+
+	// Nothing to promote costs nothing.
+	require.Equal(
+		t,
+		`DESTINATION  DIGESTS  UNKNOWN SIZE  SIZE (GiB)  EGRESS  STORAGE/MONTH
+TOTAL        0        0             0.00        0.00    0.00
+`,
+		reg.EstimateCost(nil, inv, sizes, table).ToTable())
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// RegistryCredentials names the service accounts that the registries are
+// accessed with. It is read from its own file (kept out of the manifest
+// repository), so that manifests only need to name their registries. For
+// example:
+//
+//	registries:
+//	- name: gcr.io/k8s-staging-foo
+//	  service-account: sa@robot.com
+type RegistryCredentials struct {
+	Registries []RegistryCredential `yaml:"registries"`
+}
+
+// RegistryCredential is the credential source of one registry.
+type RegistryCredential struct {
+	Name           RegistryName `yaml:"name"`
+	ServiceAccount string       `yaml:"service-account"`
+}
+
+// ParseRegistryCredentialsFromFile parses RegistryCredentials from a file.
+func ParseRegistryCredentialsFromFile(
+	filePath string,
+) (RegistryCredentials, error) {
+	b, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return RegistryCredentials{}, err
+	}
+
+	creds, err := ParseRegistryCredentialsYAML(b)
+	if err != nil {
+		return RegistryCredentials{}, fmt.Errorf("%s: %v", filePath, err)
+	}
+
+	return creds, nil
+}
+
+// ParseRegistryCredentialsYAML parses RegistryCredentials from a byteslice.
+func ParseRegistryCredentialsYAML(b []byte) (RegistryCredentials, error) {
+	var c RegistryCredentials
+	if err := yaml.UnmarshalStrict(b, &c); err != nil {
+		return c, err
+	}
+
+	return c, c.Validate()
+}
+
+// Validate checks that every registry is named once, with a service account.
+func (c RegistryCredentials) Validate() error {
+	errs := make([]string, 0)
+	seen := make(map[RegistryName]interface{})
+
+	for _, cred := range c.Registries {
+		if len(cred.Name) == 0 {
+			errs = append(
+				errs,
+				"registries: 'name' field cannot be empty")
+			continue
+		}
+
+		if _, ok := seen[cred.Name]; ok {
+			errs = append(
+				errs,
+				fmt.Sprintf("duplicate registry %q", cred.Name))
+		}
+		seen[cred.Name] = nil
+
+		if len(cred.ServiceAccount) == 0 {
+			errs = append(
+				errs,
+				fmt.Sprintf(
+					"registry %q: 'service-account' field cannot be empty",
+					cred.Name))
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf(strings.Join(errs, "\n"))
+}
+
+// Apply returns the manifests with the service accounts of the credentials
+// given to their registries. Registries without credentials are left as they
+// are, and credentials of registries that no manifest names are ignored. It
+// is an error for a manifest to give a registry another service account than
+// the credentials do.
+func (c RegistryCredentials) Apply(mfests []Manifest) ([]Manifest, error) {
+	serviceAccounts := make(map[RegistryName]string)
+	for _, cred := range c.Registries {
+		serviceAccounts[cred.Name] = cred.ServiceAccount
+	}
+
+	applied := make([]Manifest, 0, len(mfests))
+	for _, mfest := range mfests {
+		registries := make([]RegistryContext, 0, len(mfest.Registries))
+		for _, rc := range mfest.Registries {
+			serviceAccount, ok := serviceAccounts[rc.Name]
+			if !ok {
+				registries = append(registries, rc)
+				continue
+			}
+
+			if rc.ServiceAccount != "" && rc.ServiceAccount != serviceAccount {
+				return nil, mkManifestError(mfest.Filepath, fmt.Errorf(
+					"registry %q has service account %q, but its credentials name %q",
+					rc.Name,
+					rc.ServiceAccount,
+					serviceAccount))
+			}
+
+			rc.ServiceAccount = serviceAccount
+			registries = append(registries, rc)
+		}
+
+		mfest.Registries = registries
+		// The source registry is a copy of one of the registries.
+		if mfest.SrcRegistry != nil {
+			if err := mfest.Finalize(); err != nil {
+				return nil, err
+			}
+		}
+
+		applied = append(applied, mfest)
+	}
+
+	return applied, nil
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+func TestParseRegistryCredentialsYAML(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		expectedErr error
+	}{
+		{
+			"Valid credentials",
+			`registries:
+- name: gcr.io/foo-staging
+  service-account: staging@robot.com
+- name: us.gcr.io/some-prod
+  service-account: prod@robot.com
+`,
+			nil,
+		},
+		{
+			"Duplicate registry",
+			`registries:
+- name: us.gcr.io/some-prod
+  service-account: prod@robot.com
+- name: us.gcr.io/some-prod
+  service-account: prod@robot.com
+`,
+			fmt.Errorf(`duplicate registry "us.gcr.io/some-prod"`),
+		},
+		{
+			"Missing service account",
+			`registries:
+- name: us.gcr.io/some-prod
+`,
+			fmt.Errorf(
+				`registry "us.gcr.io/some-prod": 'service-account' field cannot be empty`),
+		},
+		{
+			"Missing name",
+			`registries:
+- service-account: prod@robot.com
+`,
+			fmt.Errorf("registries: 'name' field cannot be empty"),
+		},
+	}
+
+	for _, test := range tests {
+		_, err := reg.ParseRegistryCredentialsYAML([]byte(test.input))
+		if test.expectedErr != nil {
+			require.NotNil(t, err, test.name)
+			require.Equal(t, test.expectedErr.Error(), err.Error(), test.name)
+			continue
+		}
+		require.Nil(t, err, test.name)
+	}
+
+	// Only credentials may be given, not the rest of a registry.
+	_, err := reg.ParseRegistryCredentialsYAML([]byte(`registries:
+- name: gcr.io/foo-staging
+  service-account: staging@robot.com
+  src: true
+`))
+	require.NotNil(t, err)
+}
+
+func TestApplyRegistryCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.yaml")
+	require.Nil(t, ioutil.WriteFile(path, []byte(`registries:
+- name: gcr.io/foo-staging
+  service-account: staging@robot.com
+- name: us.gcr.io/some-prod
+  service-account: us-prod@robot.com
+- name: gcr.io/unused
+  service-account: unused@robot.com
+`), 0o644))
+
+	creds, err := reg.ParseRegistryCredentialsFromFile(path)
+	require.Nil(t, err)
+
+	mfest, err := reg.ParseManifestYAML([]byte(`registries:
+- name: gcr.io/foo-staging
+  src: true
+- name: us.gcr.io/some-prod
+- name: eu.gcr.io/some-prod
+  service-account: eu-prod@robot.com
+images:
+- name: a
+  dmap:
+    "sha256:0000000000000000000000000000000000000000000000000000000000000000": ["1.0"]
+`))
+	require.Nil(t, err)
+	require.Nil(t, mfest.Finalize())
+
+	mfests, err := creds.Apply([]reg.Manifest{mfest})
+	require.Nil(t, err)
+	require.Len(t, mfests, 1)
+
+	// Each registry gets its own service account; registries without
+	// credentials keep the one of the manifest.
+	require.Equal(
+		t,
+		[]reg.RegistryContext{
+			{
+				Name:           "gcr.io/foo-staging",
+				ServiceAccount: "staging@robot.com",
+				Src:            true,
+			},
+			{
+				Name:           "us.gcr.io/some-prod",
+				ServiceAccount: "us-prod@robot.com",
+			},
+			{
+				Name:           "eu.gcr.io/some-prod",
+				ServiceAccount: "eu-prod@robot.com",
+			},
+		},
+		mfests[0].Registries)
+	require.Equal(t, "staging@robot.com", mfests[0].SrcRegistry.ServiceAccount)
+
+	// The manifest that was given is left as it is.
+	require.Equal(t, "", mfest.Registries[1].ServiceAccount)
+
+	// The promotions use the service accounts of the credentials.
+	edges, err := reg.ToPromotionEdges(mfests)
+	require.Nil(t, err)
+	serviceAccounts := make(map[reg.RegistryName]string)
+	for edge := range edges {
+		require.Equal(t, "staging@robot.com", edge.SrcRegistry.ServiceAccount)
+		serviceAccounts[edge.DstRegistry.Name] = edge.DstRegistry.ServiceAccount
+	}
+	require.Equal(
+		t,
+		map[reg.RegistryName]string{
+			"us.gcr.io/some-prod": "us-prod@robot.com",
+			"eu.gcr.io/some-prod": "eu-prod@robot.com",
+		},
+		serviceAccounts)
+
+	// A manifest may not name another service account than the credentials.
+	mfest.Registries[1].ServiceAccount = "other@robot.com"
+	_, err = creds.Apply([]reg.Manifest{mfest})
+	require.NotNil(t, err)
+	require.Equal(
+		t,
+		`registry "us.gcr.io/some-prod" has service account "other@robot.com", but its credentials name "us-prod@robot.com"`,
+		err.Error())
+}
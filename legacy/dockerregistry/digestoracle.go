@@ -0,0 +1,238 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDigestOracleTimeout is how long HTTPDigestOracle waits for the answer
+// to each request, unless it is given a Client of its own.
+const DefaultDigestOracleTimeout = 30 * time.Second
+
+// DigestOracle is an external source of truth for the digests that the tags of
+// images must point to.
+type DigestOracle interface {
+	// Digest returns the digest that the tag of the image must point to, or
+	// an empty Digest if the oracle does not know the tag.
+	Digest(ctx context.Context, image ImageName, tag Tag) (Digest, error)
+}
+
+// CheckDigestOracle asks the DigestOracle about the tag of every edge, as
+// written in the manifest (that is, the source image name), and fails if the
+// digest of any edge is not the one the oracle has for its tag. All mismatches
+// are reported together. Tagless edges are not checked, as the oracle only
+// knows the digests of tags.
+func (sc *SyncContext) CheckDigestOracle(
+	edges map[PromotionEdge]interface{},
+	oracle DigestOracle,
+) error {
+	known := make(map[ImageTag]Digest)
+	mismatches := make(map[string]interface{})
+	for _, edge := range SortedPromotionEdges(edges) {
+		imageTag := edge.SrcImageTag
+		if imageTag.Tag == "" {
+			continue
+		}
+
+		digest, ok := known[imageTag]
+		if !ok {
+			var err error
+			digest, err = oracle.Digest(
+				sc.ctx(),
+				imageTag.ImageName,
+				imageTag.Tag)
+			if err != nil {
+				return fmt.Errorf(
+					"asking the digest oracle about %s:%s: %w",
+					imageTag.ImageName,
+					imageTag.Tag,
+					err)
+			}
+			known[imageTag] = digest
+		}
+
+		switch digest {
+		case edge.Digest:
+			continue
+		case "":
+			mismatches[fmt.Sprintf(
+				"%s:%s: %s is not known to the digest oracle",
+				imageTag.ImageName,
+				imageTag.Tag,
+				edge.Digest)] = nil
+		default:
+			mismatches[fmt.Sprintf(
+				"%s:%s: manifest has %s, digest oracle has %s",
+				imageTag.ImageName,
+				imageTag.Tag,
+				edge.Digest,
+				digest)] = nil
+		}
+	}
+
+	if len(mismatches) == 0 {
+		return nil
+	}
+
+	lines := make([]string, 0, len(mismatches))
+	for mismatch := range mismatches {
+		lines = append(lines, mismatch)
+	}
+	sort.Strings(lines)
+
+	return fmt.Errorf(
+		"%d tag(s) do not match the digest oracle:\n  %s",
+		len(lines),
+		strings.Join(lines, "\n  "))
+}
+
+// FakeDigestOracle answers with the digests in Digests, for testing.
+type FakeDigestOracle struct {
+	mutex     sync.Mutex
+	requested []ImageTag
+	// Digests holds the digest of every tag that the oracle knows.
+	Digests map[ImageTag]Digest
+	// Err, if set, is returned by every call to Digest.
+	Err error
+}
+
+// Digest implements DigestOracle.
+func (o *FakeDigestOracle) Digest(
+	ctx context.Context,
+	image ImageName,
+	tag Tag,
+) (Digest, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	imageTag := ImageTag{ImageName: image, Tag: tag}
+	o.requested = append(o.requested, imageTag)
+	if o.Err != nil {
+		return "", o.Err
+	}
+
+	return o.Digests[imageTag], nil
+}
+
+// Requested returns the tags that the oracle was asked about so far.
+func (o *FakeDigestOracle) Requested() []ImageTag {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	requested := make([]ImageTag, len(o.requested))
+	copy(requested, o.requested)
+
+	return requested
+}
+
+// HTTPDigestOracle asks a digest API for the digest of each tag, with a GET
+// request to the endpoint with the "image" and "tag" query parameters. The API
+// is expected to reply with "200 OK" and the digest as JSON, e.g.
+// {"digest": "sha256:..."}, or with "404 Not Found" if it does not know the
+// tag.
+type HTTPDigestOracle struct {
+	Endpoint string
+	// Client is the HTTP client to use; a client that times out after
+	// DefaultDigestOracleTimeout is used if it is not set.
+	Client *http.Client
+}
+
+// MkHTTPDigestOracle creates an HTTPDigestOracle for the given endpoint, which
+// must be an absolute http(s) URL.
+func MkHTTPDigestOracle(endpoint string) (*HTTPDigestOracle, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("parsing digest oracle URL: %w", err)
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf(
+			"invalid digest oracle URL %q (expected an http(s) URL)",
+			endpoint)
+	}
+
+	return &HTTPDigestOracle{
+		Endpoint: endpoint,
+		Client:   &http.Client{Timeout: DefaultDigestOracleTimeout},
+	}, nil
+}
+
+// Digest implements DigestOracle.
+func (o *HTTPDigestOracle) Digest(
+	ctx context.Context,
+	image ImageName,
+	tag Tag,
+) (Digest, error) {
+	u, err := url.Parse(o.Endpoint)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Set("image", string(image))
+	query.Set("tag", string(tag))
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet,
+		u.String(),
+		nil,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	client := o.Client
+	if client == nil {
+		client = &http.Client{Timeout: DefaultDigestOracleTimeout}
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", nil
+	default:
+		return "", fmt.Errorf("digest oracle returned %s", res.Status)
+	}
+
+	var answer struct {
+		Digest Digest `json:"digest"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&answer); err != nil {
+		return "", fmt.Errorf("parsing digest: %w", err)
+	}
+	if err := ValidateDigest(answer.Digest); err != nil {
+		return "", fmt.Errorf("digest oracle returned an %w", err)
+	}
+
+	return answer.Digest, nil
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+var (
+	oracleDigestA = reg.Digest("sha256:" + strings.Repeat("a", 64))
+	oracleDigestB = reg.Digest("sha256:" + strings.Repeat("b", 64))
+)
+
+func TestCheckDigestOracle(t *testing.T) {
+	srcRC := reg.RegistryContext{
+		Name: "gcr.io/staging",
+		Src:  true,
+	}
+	mkEdge := func(
+		dst reg.RegistryName,
+		image reg.ImageName,
+		digest reg.Digest,
+		tag reg.Tag,
+	) reg.PromotionEdge {
+		return reg.PromotionEdge{
+			SrcRegistry: srcRC,
+			SrcImageTag: reg.ImageTag{ImageName: image, Tag: tag},
+			Digest:      digest,
+			DstRegistry: reg.RegistryContext{Name: dst},
+			DstImageTag: reg.ImageTag{ImageName: image, Tag: tag},
+		}
+	}
+
+	edges := map[reg.PromotionEdge]interface{}{
+		mkEdge("us.gcr.io/prod", "a", oracleDigestA, "1.0"): nil,
+		mkEdge("eu.gcr.io/prod", "a", oracleDigestA, "1.0"): nil,
+		mkEdge("us.gcr.io/prod", "b", oracleDigestA, "1.0"): nil,
+		mkEdge("us.gcr.io/prod", "c", oracleDigestA, "1.0"): nil,
+		// Tagless edges are not checked.
+		mkEdge("us.gcr.io/prod", "d", oracleDigestA, ""): nil,
+	}
+
+	tests := []struct {
+		name              string
+		oracle            *reg.FakeDigestOracle
+		expectedRequested int
+		expectedErr       string
+	}{
+		{
+			"All tags match",
+			&reg.FakeDigestOracle{
+				Digests: map[reg.ImageTag]reg.Digest{
+					{ImageName: "a", Tag: "1.0"}: oracleDigestA,
+					{ImageName: "b", Tag: "1.0"}: oracleDigestA,
+					{ImageName: "c", Tag: "1.0"}: oracleDigestA,
+				},
+			},
+			3,
+			"",
+		},
+		{
+			"Mismatching and unknown tags",
+			&reg.FakeDigestOracle{
+				Digests: map[reg.ImageTag]reg.Digest{
+					{ImageName: "a", Tag: "1.0"}: oracleDigestB,
+					{ImageName: "b", Tag: "1.0"}: oracleDigestA,
+				},
+			},
+			3,
+			fmt.Sprintf(`2 tag(s) do not match the digest oracle:
+  a:1.0: manifest has %s, digest oracle has %s
+  c:1.0: %s is not known to the digest oracle`,
+				oracleDigestA,
+				oracleDigestB,
+				oracleDigestA),
+		},
+		{
+			"Oracle failure",
+			&reg.FakeDigestOracle{
+				Err: errors.New("unavailable"),
+			},
+			1,
+			"asking the digest oracle about a:1.0: unavailable",
+		},
+	}
+
+	for _, test := range tests {
+		sc := reg.SyncContext{}
+
+		err := sc.CheckDigestOracle(edges, test.oracle)
+		require.Len(t, test.oracle.Requested(), test.expectedRequested, test.name)
+		if test.expectedErr == "" {
+			require.Nil(t, err, test.name)
+			continue
+		}
+		require.NotNil(t, err, test.name)
+		require.Equal(t, test.expectedErr, err.Error(), test.name)
+	}
+}
+
+func TestHTTPDigestOracle(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			image := r.URL.Query().Get("image")
+			tag := r.URL.Query().Get("tag")
+
+			switch image + ":" + tag {
+			case "foo/a:1.0":
+				fmt.Fprintf(w, `{"digest": %q}`, oracleDigestA)
+			case "foo/b:1.0":
+				fmt.Fprint(w, `{"digest": "latest"}`)
+			case "foo/c:1.0":
+				http.Error(w, "unknown tag", http.StatusNotFound)
+			case "foo/e:1.0":
+				// Never answer.
+				<-r.Context().Done()
+			default:
+				http.Error(w, "database down", http.StatusInternalServerError)
+			}
+		},
+	))
+	defer server.Close()
+
+	oracle, err := reg.MkHTTPDigestOracle(server.URL + "/digests")
+	require.Nil(t, err)
+	require.Equal(t, reg.DefaultDigestOracleTimeout, oracle.Client.Timeout)
+
+	tests := []struct {
+		name        string
+		image       reg.ImageName
+		expected    reg.Digest
+		expectedErr string
+	}{
+		{
+			"Known tag",
+			"foo/a",
+			oracleDigestA,
+			"",
+		},
+		{
+			"Invalid digest",
+			"foo/b",
+			"",
+			"digest oracle returned an invalid digest: latest",
+		},
+		{
+			"Unknown tag",
+			"foo/c",
+			"",
+			"",
+		},
+		{
+			"Error status",
+			"foo/d",
+			"",
+			"digest oracle returned 500 Internal Server Error",
+		},
+	}
+
+	for _, test := range tests {
+		got, err := oracle.Digest(context.Background(), test.image, "1.0")
+		if test.expectedErr != "" {
+			require.NotNil(t, err, test.name)
+			require.Equal(t, test.expectedErr, err.Error(), test.name)
+			continue
+		}
+
+		require.Nil(t, err, test.name)
+		require.Equal(t, test.expected, got, test.name)
+	}
+
+	// An API that does not answer times out.
+	oracle.Client.Timeout = 50 * time.Millisecond
+	_, err = oracle.Digest(context.Background(), "foo/e", "1.0")
+	require.NotNil(t, err)
+	var netErr net.Error
+	require.True(t, errors.As(err, &netErr) && netErr.Timeout(), err.Error())
+
+	_, err = reg.MkHTTPDigestOracle("gcr.io/not-a-url")
+	require.NotNil(t, err)
+}
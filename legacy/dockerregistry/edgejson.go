@@ -0,0 +1,169 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// PromotionEdgeJSON is the machine-readable form of a promotion edge, along
+// with the operation that promotes it.
+type PromotionEdgeJSON struct {
+	Source RegistryName `json:"source"`
+	Dest   RegistryName `json:"dest"`
+	Image  ImageName    `json:"image"`
+	Digest Digest       `json:"digest"`
+	// Tag is empty for tagless promotions.
+	Tag Tag `json:"tag"`
+	// Op is "ADD" (the image is copied) or "RETAG" (the digest is already in
+	// the destination, so only the tag is written).
+	Op string `json:"op"`
+	// OldDigest is the digest that the tag is moved away from, if it is
+	// forcibly overwritten.
+	OldDigest Digest `json:"oldDigest,omitempty"`
+}
+
+// PromotionEdgesJSON returns the given edges in the order that Promote()
+// dispatches them, with the operations it would perform for them. Like
+// Promote(), it leaves out the edges whose tag would have to be moved without
+// being allowed to.
+func (sc *SyncContext) PromotionEdgesJSON(
+	edges map[PromotionEdge]interface{},
+) []PromotionEdgeJSON {
+	edgesJSON := make([]PromotionEdgeJSON, 0, len(edges))
+	for _, edge := range SortedPromotionEdges(edges) {
+		if edgeJSON, ok := sc.promotionEdgeJSON(edge); ok {
+			edgesJSON = append(edgesJSON, edgeJSON)
+		}
+	}
+
+	return edgesJSON
+}
+
+// promotionEdgeJSON returns the machine-readable form of a single edge, or
+// false if Promote() would leave it out.
+func (sc *SyncContext) promotionEdgeJSON(
+	edge PromotionEdge,
+) (PromotionEdgeJSON, bool) {
+	tagOp, oldDigest, ok := sc.promotionTagOp(&edge)
+	if !ok {
+		return PromotionEdgeJSON{}, false
+	}
+
+	return PromotionEdgeJSON{
+		Source:    edge.SrcRegistry.Name,
+		Dest:      edge.DstRegistry.Name,
+		Image:     edge.DstImageTag.ImageName,
+		Digest:    edge.Digest,
+		Tag:       edge.DstImageTag.Tag,
+		Op:        tagOp.PrettyValue(),
+		OldDigest: oldDigest,
+	}, true
+}
+
+// ParsePromotionEdgesJSON parses a list of edges in the form that
+// PromotionEdgesJSON() returns (e.g., as printed by 'cip run --print-edges').
+func ParsePromotionEdgesJSON(b []byte) ([]PromotionEdgeJSON, error) {
+	edgesJSON := make([]PromotionEdgeJSON, 0)
+	if err := json.Unmarshal(b, &edgesJSON); err != nil {
+		return nil, err
+	}
+
+	return edgesJSON, nil
+}
+
+// UnexpectedEdgesError is returned by CompareEdgesJSON() if the edges differ
+// from the expected ones.
+type UnexpectedEdgesError struct {
+	// Unexpected are the edges that were not expected.
+	Unexpected []PromotionEdgeJSON
+	// Missing are the expected edges that are absent.
+	Missing []PromotionEdgeJSON
+}
+
+func (e *UnexpectedEdgesError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(
+		&b,
+		"promotion edges differ from the expected edges: %d unexpected, %d missing",
+		len(e.Unexpected),
+		len(e.Missing))
+	for _, edgeJSON := range e.Unexpected {
+		fmt.Fprintf(&b, "\n  unexpected: %s", describeEdgeJSON(edgeJSON))
+	}
+	for _, edgeJSON := range e.Missing {
+		fmt.Fprintf(&b, "\n  missing: %s", describeEdgeJSON(edgeJSON))
+	}
+
+	return b.String()
+}
+
+// CompareEdgesJSON checks that edges are exactly the expected ones, in any
+// order. Otherwise, it returns an *UnexpectedEdgesError with the differences
+// (in the order of the lists they come from).
+func CompareEdgesJSON(expected, edges []PromotionEdgeJSON) error {
+	expectedSet := make(map[PromotionEdgeJSON]bool, len(expected))
+	for _, edgeJSON := range expected {
+		expectedSet[edgeJSON] = true
+	}
+
+	edgeSet := make(map[PromotionEdgeJSON]bool, len(edges))
+	for _, edgeJSON := range edges {
+		edgeSet[edgeJSON] = true
+	}
+
+	mismatch := &UnexpectedEdgesError{}
+	for _, edgeJSON := range edges {
+		if !expectedSet[edgeJSON] {
+			mismatch.Unexpected = append(mismatch.Unexpected, edgeJSON)
+		}
+	}
+	for _, edgeJSON := range expected {
+		if !edgeSet[edgeJSON] {
+			mismatch.Missing = append(mismatch.Missing, edgeJSON)
+		}
+	}
+
+	if len(mismatch.Unexpected) > 0 || len(mismatch.Missing) > 0 {
+		return mismatch
+	}
+
+	return nil
+}
+
+// describeEdgeJSON is the human-readable form of an edge, e.g.
+// "ADD gcr.io/foo/a@sha256:000 to gcr.io/bar/a:1.0".
+func describeEdgeJSON(edgeJSON PromotionEdgeJSON) string {
+	dest := ToLQIN(edgeJSON.Dest, edgeJSON.Image)
+	if edgeJSON.Tag != "" {
+		dest += ":" + string(edgeJSON.Tag)
+	}
+
+	s := fmt.Sprintf(
+		"%s %s@%s to %s",
+		edgeJSON.Op,
+		ToLQIN(edgeJSON.Source, edgeJSON.Image),
+		edgeJSON.Digest,
+		dest)
+	if edgeJSON.OldDigest != "" {
+		s += fmt.Sprintf(" (overwriting %s)", edgeJSON.OldDigest)
+	}
+
+	return s
+}
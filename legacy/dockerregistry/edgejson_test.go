@@ -0,0 +1,224 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+func TestPromotionEdgesJSON(t *testing.T) {
+	srcRC := reg.RegistryContext{
+		Name:           "gcr.io/foo",
+		ServiceAccount: "robot",
+		Src:            true,
+	}
+	destRC := reg.RegistryContext{
+		Name:           "gcr.io/bar",
+		ServiceAccount: "robot",
+	}
+
+	edges, err := reg.ToPromotionEdges([]reg.Manifest{
+		{
+			Registries: []reg.RegistryContext{srcRC, destRC},
+			Images: []reg.Image{
+				{
+					ImageName: "a",
+					Dmap: reg.DigestTags{
+						"sha256:000": {"1.0"},
+						"sha256:111": {},
+						"sha256:222": {"2.0"},
+						"sha256:333": {"3.0"},
+					},
+				},
+			},
+			SrcRegistry: &srcRC,
+		},
+	})
+	require.Nil(t, err)
+
+	inv := reg.MasterInventory{
+		"gcr.io/foo": {
+			"a": {
+				"sha256:000": {"1.0"},
+				"sha256:111": {},
+				"sha256:222": {"2.0"},
+				"sha256:333": {"3.0"},
+			},
+		},
+		"gcr.io/bar": {
+			"a": {
+				"sha256:222": {"other"},
+				"sha256:999": {"3.0"},
+			},
+		},
+	}
+
+	var tests = []struct {
+		name           string
+		forceOverwrite map[string]bool
+		expectedJSON   string
+	}{
+		{
+			"Tag moves are left out",
+			nil,
+			`[
+				{"source": "gcr.io/foo", "dest": "gcr.io/bar", "image": "a", "digest": "sha256:111", "tag": "", "op": "ADD"},
+				{"source": "gcr.io/foo", "dest": "gcr.io/bar", "image": "a", "digest": "sha256:000", "tag": "1.0", "op": "ADD"},
+				{"source": "gcr.io/foo", "dest": "gcr.io/bar", "image": "a", "digest": "sha256:222", "tag": "2.0", "op": "RETAG"}
+			]`,
+		},
+		{
+			"Forced tag moves overwrite the old digest",
+			map[string]bool{"a:3.0": true},
+			`[
+				{"source": "gcr.io/foo", "dest": "gcr.io/bar", "image": "a", "digest": "sha256:111", "tag": "", "op": "ADD"},
+				{"source": "gcr.io/foo", "dest": "gcr.io/bar", "image": "a", "digest": "sha256:000", "tag": "1.0", "op": "ADD"},
+				{"source": "gcr.io/foo", "dest": "gcr.io/bar", "image": "a", "digest": "sha256:222", "tag": "2.0", "op": "RETAG"},
+				{"source": "gcr.io/foo", "dest": "gcr.io/bar", "image": "a", "digest": "sha256:333", "tag": "3.0", "op": "ADD", "oldDigest": "sha256:999"}
+			]`,
+		},
+	}
+
+	for _, test := range tests {
+		sc := reg.SyncContext{
+			Inv:            inv,
+			ForceOverwrite: test.forceOverwrite,
+		}
+
+		// Overwrites are forced when the edges are filtered.
+		filtered, clean := sc.GetPromotionCandidates(edges)
+		require.True(t, clean, test.name)
+
+		got, err := json.Marshal(sc.PromotionEdgesJSON(filtered))
+		require.Nil(t, err, test.name)
+		require.JSONEq(t, test.expectedJSON, string(got), test.name)
+	}
+
+	// No edges are printed as an empty list, not as null.
+	sc := reg.SyncContext{}
+	got, err := json.Marshal(sc.PromotionEdgesJSON(nil))
+	require.Nil(t, err)
+	require.Equal(t, "[]", string(got))
+}
+
+func TestCompareEdgesJSON(t *testing.T) {
+	expected, err := reg.ParsePromotionEdgesJSON([]byte(`[
+		{"source": "gcr.io/foo", "dest": "gcr.io/bar", "image": "a", "digest": "sha256:000", "tag": "1.0", "op": "ADD"},
+		{"source": "gcr.io/foo", "dest": "gcr.io/bar", "image": "a", "digest": "sha256:111", "tag": "", "op": "ADD"}
+	]`))
+	require.Nil(t, err)
+
+	tagged := reg.PromotionEdgeJSON{
+		Source: "gcr.io/foo",
+		Dest:   "gcr.io/bar",
+		Image:  "a",
+		Digest: "sha256:000",
+		Tag:    "1.0",
+		Op:     "ADD",
+	}
+	tagless := reg.PromotionEdgeJSON{
+		Source: "gcr.io/foo",
+		Dest:   "gcr.io/bar",
+		Image:  "a",
+		Digest: "sha256:111",
+		Op:     "ADD",
+	}
+	moved := reg.PromotionEdgeJSON{
+		Source:    "gcr.io/foo",
+		Dest:      "gcr.io/bar",
+		Image:     "b",
+		Digest:    "sha256:222",
+		Tag:       "2.0",
+		Op:        "ADD",
+		OldDigest: "sha256:999",
+	}
+
+	var tests = []struct {
+		name          string
+		edges         []reg.PromotionEdgeJSON
+		expectedError string
+	}{
+		{
+			"Matching edges, in another order",
+			[]reg.PromotionEdgeJSON{tagless, tagged},
+			"",
+		},
+		{
+			"Extra edge",
+			[]reg.PromotionEdgeJSON{tagged, moved, tagless},
+			`promotion edges differ from the expected edges: 1 unexpected, 0 missing
+  unexpected: ADD gcr.io/foo/b@sha256:222 to gcr.io/bar/b:2.0 (overwriting sha256:999)`,
+		},
+		{
+			"Missing edge",
+			[]reg.PromotionEdgeJSON{tagged},
+			`promotion edges differ from the expected edges: 0 unexpected, 1 missing
+  missing: ADD gcr.io/foo/a@sha256:111 to gcr.io/bar/a`,
+		},
+		{
+			"Changed operation",
+			[]reg.PromotionEdgeJSON{
+				tagless,
+				{
+					Source: "gcr.io/foo",
+					Dest:   "gcr.io/bar",
+					Image:  "a",
+					Digest: "sha256:000",
+					Tag:    "1.0",
+					Op:     "RETAG",
+				},
+			},
+			`promotion edges differ from the expected edges: 1 unexpected, 1 missing
+  unexpected: RETAG gcr.io/foo/a@sha256:000 to gcr.io/bar/a:1.0
+  missing: ADD gcr.io/foo/a@sha256:000 to gcr.io/bar/a:1.0`,
+		},
+		{
+			"No edges",
+			nil,
+			`promotion edges differ from the expected edges: 0 unexpected, 2 missing
+  missing: ADD gcr.io/foo/a@sha256:000 to gcr.io/bar/a:1.0
+  missing: ADD gcr.io/foo/a@sha256:111 to gcr.io/bar/a`,
+		},
+	}
+
+	for _, test := range tests {
+		err := reg.CompareEdgesJSON(expected, test.edges)
+		if test.expectedError == "" {
+			require.Nil(t, err, test.name)
+			continue
+		}
+		require.NotNil(t, err, test.name)
+		require.Equal(t, test.expectedError, err.Error(), test.name)
+
+		var mismatch *reg.UnexpectedEdgesError
+		require.True(t, errors.As(err, &mismatch), test.name)
+	}
+
+	// An empty list expects no edges at all.
+	none, err := reg.ParsePromotionEdgesJSON([]byte("[]"))
+	require.Nil(t, err)
+	require.Nil(t, reg.CompareEdgesJSON(none, nil))
+
+	_, err = reg.ParsePromotionEdgesJSON([]byte("{"))
+	require.NotNil(t, err)
+}
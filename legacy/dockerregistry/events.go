@@ -0,0 +1,180 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	pubsub "google.golang.org/api/pubsub/v1"
+)
+
+// PromotionEvent describes an image that was promoted. It is published (as
+// JSON) after every successful promotion, so that downstream systems can react
+// to it.
+type PromotionEvent struct {
+	// Source is the image that was promoted, referenced by digest.
+	Source string `json:"source"`
+	// Registry is the destination registry.
+	Registry RegistryName `json:"registry"`
+	// Image is the name of the image in the destination registry.
+	Image  ImageName `json:"image"`
+	Digest Digest    `json:"digest"`
+	// Tag is the tag that was added, if any (tagless promotions have none).
+	Tag Tag `json:"tag,omitempty"`
+}
+
+// EventPublisher publishes PromotionEvents.
+type EventPublisher interface {
+	Publish(ctx context.Context, event PromotionEvent) error
+}
+
+// mkPromotionEvent creates the event for the given (successful) promotion.
+func mkPromotionEvent(pr PromotionRequest) PromotionEvent {
+	return PromotionEvent{
+		Source:   ToFQIN(pr.RegistrySrc, pr.ImageNameSrc, pr.Digest),
+		Registry: pr.RegistryDest,
+		Image:    pr.ImageNameDest,
+		Digest:   pr.Digest,
+		Tag:      pr.Tag,
+	}
+}
+
+// publishPromotionEvent publishes the event of the given (successful)
+// promotion with the EventPublisher of sc, if any. Failures are only logged,
+// unless sc.FailOnPublishError is set, in which case they are returned.
+func (sc *SyncContext) publishPromotionEvent(pr PromotionRequest) Errors {
+	if sc.EventPublisher == nil {
+		return nil
+	}
+
+	event := mkPromotionEvent(pr)
+	err := sc.EventPublisher.Publish(sc.ctx(), event)
+	if err == nil {
+		return nil
+	}
+
+	if !sc.FailOnPublishError {
+		logrus.Warnf("could not publish event for %s: %v", event.Source, err)
+		return nil
+	}
+
+	return Errors{{
+		Context: "publishing promotion event",
+		Error:   err,
+	}}
+}
+
+// FakeEventPublisher is an in-memory EventPublisher, for testing.
+type FakeEventPublisher struct {
+	mutex  sync.Mutex
+	events []PromotionEvent
+	// Err, if set, is returned by every call to Publish (and the event is not
+	// recorded).
+	Err error
+}
+
+// Publish implements EventPublisher.
+func (p *FakeEventPublisher) Publish(
+	ctx context.Context,
+	event PromotionEvent,
+) error {
+	if p.Err != nil {
+		return p.Err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.events = append(p.events, event)
+
+	return nil
+}
+
+// Events returns the events that were published so far.
+func (p *FakeEventPublisher) Events() []PromotionEvent {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	events := make([]PromotionEvent, len(p.events))
+	copy(events, p.events)
+
+	return events
+}
+
+// pubSubTopicRegex matches the full resource names of Pub/Sub topics.
+var pubSubTopicRegex = regexp.MustCompile(`^projects/[^/]+/topics/[^/]+$`)
+
+// PubSubEventPublisher publishes PromotionEvents to a Google Cloud Pub/Sub
+// topic, using the application default credentials.
+type PubSubEventPublisher struct {
+	Service *pubsub.Service
+	// Topic is the full resource name of the topic, i.e.,
+	// "projects/<project>/topics/<topic>".
+	Topic string
+}
+
+// MkPubSubEventPublisher creates a PubSubEventPublisher for the given topic
+// ("projects/<project>/topics/<topic>").
+func MkPubSubEventPublisher(
+	ctx context.Context,
+	topic string,
+) (*PubSubEventPublisher, error) {
+	if !pubSubTopicRegex.MatchString(topic) {
+		return nil, fmt.Errorf(
+			"invalid Pub/Sub topic %q (expected projects/<project>/topics/<topic>)",
+			topic)
+	}
+
+	service, err := pubsub.NewService(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("creating Pub/Sub client: %w", err)
+	}
+
+	return &PubSubEventPublisher{
+		Service: service,
+		Topic:   topic,
+	}, nil
+}
+
+// Publish implements EventPublisher.
+func (p *PubSubEventPublisher) Publish(
+	ctx context.Context,
+	event PromotionEvent,
+) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req := pubsub.PublishRequest{
+		Messages: []*pubsub.PubsubMessage{
+			{Data: base64.StdEncoding.EncodeToString(data)},
+		},
+	}
+
+	_, err = p.Service.Projects.Topics.Publish(p.Topic, &req).
+		Context(ctx).
+		Do()
+
+	return err
+}
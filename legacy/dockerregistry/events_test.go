@@ -0,0 +1,190 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/stream"
+)
+
+// failingCopy is a copy process that fails.
+type failingCopy struct {
+	stream.Fake
+}
+
+func (*failingCopy) Close() error {
+	return fmt.Errorf("copy failed")
+}
+
+// mkFailingTagProducer returns a PromotionContext whose (faked) copy processes
+// succeed, except for those that write failTag (if given).
+func mkFailingTagProducer(failTag reg.Tag) reg.PromotionContext {
+	return func(
+		srcRegistry reg.RegistryName,
+		srcImageName reg.ImageName,
+		destRC reg.RegistryContext,
+		destImageName reg.ImageName,
+		digest reg.Digest,
+		tag reg.Tag,
+		tp reg.TagOp,
+	) stream.Producer {
+		if failTag != "" && tag == failTag {
+			return &failingCopy{}
+		}
+
+		return &stream.Fake{}
+	}
+}
+
+func TestPromotionEvents(t *testing.T) {
+	srcRC := reg.RegistryContext{
+		Name:           "gcr.io/foo",
+		ServiceAccount: "robot",
+		Src:            true,
+	}
+	destRC := reg.RegistryContext{
+		Name:           "gcr.io/bar",
+		ServiceAccount: "robot",
+	}
+
+	edges, err := reg.ToPromotionEdges([]reg.Manifest{
+		{
+			Registries: []reg.RegistryContext{srcRC, destRC},
+			Images: []reg.Image{
+				{
+					ImageName: "a",
+					Dmap: reg.DigestTags{
+						"sha256:000": {"0.9", "1.0"},
+						"sha256:111": {},
+					},
+				},
+			},
+			SrcRegistry: &srcRC,
+		},
+	})
+	require.Nil(t, err)
+
+	event := func(digest reg.Digest, tag reg.Tag) reg.PromotionEvent {
+		return reg.PromotionEvent{
+			Source:   "gcr.io/foo/a@" + string(digest),
+			Registry: "gcr.io/bar",
+			Image:    "a",
+			Digest:   digest,
+			Tag:      tag,
+		}
+	}
+
+	var tests = []struct {
+		name               string
+		dryRun             bool
+		failTag            reg.Tag
+		publishErr         error
+		failOnPublishError bool
+		expectedErr        bool
+		expectedEvents     []reg.PromotionEvent
+	}{
+		{
+			"One event per promoted edge",
+			false,
+			"",
+			nil,
+			false,
+			false,
+			[]reg.PromotionEvent{
+				event("sha256:000", "0.9"),
+				event("sha256:000", "1.0"),
+				event("sha256:111", ""),
+			},
+		},
+		{
+			"No event for a failed promotion",
+			false,
+			"1.0",
+			nil,
+			false,
+			true,
+			[]reg.PromotionEvent{
+				event("sha256:000", "0.9"),
+				event("sha256:111", ""),
+			},
+		},
+		{
+			"No events in a dry run",
+			true,
+			"",
+			nil,
+			false,
+			false,
+			[]reg.PromotionEvent{},
+		},
+		{
+			"Publishing failures are only logged by default",
+			false,
+			"",
+			fmt.Errorf("topic not found"),
+			false,
+			false,
+			[]reg.PromotionEvent{},
+		},
+		{
+			"Publishing failures fail the promotion if asked to",
+			false,
+			"",
+			fmt.Errorf("topic not found"),
+			true,
+			true,
+			[]reg.PromotionEvent{},
+		},
+	}
+
+	for _, test := range tests {
+		publisher := &reg.FakeEventPublisher{Err: test.publishErr}
+		sc := reg.SyncContext{
+			Inv:                reg.MasterInventory{},
+			DryRun:             test.dryRun,
+			CopyTool:           reg.CraneCopyTool{},
+			EventPublisher:     publisher,
+			FailOnPublishError: test.failOnPublishError,
+		}
+
+		err := sc.Promote(edges, mkFailingTagProducer(test.failTag), nil)
+		require.Equal(t, test.expectedErr, err != nil, test.name)
+		require.ElementsMatch(t,
+			test.expectedEvents,
+			publisher.Events(),
+			test.name)
+	}
+}
+
+func TestMkPubSubEventPublisher(t *testing.T) {
+	for _, topic := range []string{
+		"",
+		"my-topic",
+		"projects/foo",
+		"projects/foo/topics/",
+		"projects/foo/subscriptions/bar",
+	} {
+		_, err := reg.MkPubSubEventPublisher(context.Background(), topic)
+		require.NotNil(t, err, topic)
+	}
+}
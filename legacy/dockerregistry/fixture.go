@@ -0,0 +1,149 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	ggcrV1Types "github.com/google/go-containerregistry/pkg/v1/types"
+	yaml "gopkg.in/yaml.v2"
+
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/stream"
+)
+
+// LoadInventoryFixture reads a pre-captured inventory of registries from a
+// YAML (or JSON) file, which maps registry names to their images, e.g.
+//
+//	gcr.io/foo:
+//	  bar:
+//	    sha256:...: ["1.0", "latest"]
+//
+// See MkReadRepositoryCmdFromInventory().
+func LoadInventoryFixture(path string) (MasterInventory, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	mi := make(MasterInventory)
+	if err := yaml.UnmarshalStrict(b, &mi); err != nil {
+		return nil, fmt.Errorf("parsing inventory fixture %q: %w", path, err)
+	}
+
+	for registry, rii := range mi {
+		if err := rii.Validate(); err != nil {
+			return nil, fmt.Errorf(
+				"inventory fixture %q: registry %q: %w",
+				path,
+				registry,
+				err)
+		}
+	}
+
+	return mi, nil
+}
+
+// MkReadRepositoryCmdFromInventory is like MkReadRepositoryCmdReal(), but
+// answers the reads of ReadRegistries() from mi instead of a registry, so that
+// promotions and snapshots can be computed offline. Registries that are not in
+// mi are empty. All images are taken to be Docker v2 images.
+func MkReadRepositoryCmdFromInventory(
+	mi MasterInventory,
+) func(*SyncContext, RegistryContext) stream.Producer {
+	return func(sc *SyncContext, rc RegistryContext) stream.Producer {
+		b, err := json.Marshal(fixtureTags(mi, rc.Name))
+		if err != nil {
+			// The listing is always valid JSON.
+			panic(err)
+		}
+
+		return &stream.Fake{Bytes: b}
+	}
+}
+
+// fixtureListing is the JSON form of a ggcrV1Google.Tags listing, without
+// the fields that a fixture does not have (sizes and times). Unlike
+// ggcrV1Google.ManifestInfo, it does not write zero times as timestamps.
+type fixtureListing struct {
+	Children  []string                   `json:"child"`
+	Manifests map[string]fixtureManifest `json:"manifest"`
+	Name      string                     `json:"name"`
+	Tags      []string                   `json:"tags"`
+}
+
+type fixtureManifest struct {
+	MediaType string   `json:"mediaType"`
+	Tags      []string `json:"tag"`
+}
+
+// fixtureTags returns the GCR listing of the repository r, as given by mi.
+func fixtureTags(mi MasterInventory, r RegistryName) fixtureListing {
+	tags := fixtureListing{
+		Children:  []string{},
+		Manifests: make(map[string]fixtureManifest),
+		Name:      string(r),
+		Tags:      []string{},
+	}
+
+	// Find the (longest) registry of mi that holds r.
+	var root RegistryName
+	for registry := range mi {
+		if (r == registry || strings.HasPrefix(string(r), string(registry)+"/")) &&
+			len(registry) > len(root) {
+			root = registry
+		}
+	}
+	if root == "" {
+		return tags
+	}
+
+	path := strings.TrimPrefix(strings.TrimPrefix(string(r), string(root)), "/")
+	for digest, tagSlice := range mi[root][ImageName(path)] {
+		info := fixtureManifest{
+			MediaType: string(ggcrV1Types.DockerManifestSchema2),
+			Tags:      []string{},
+		}
+		for _, tag := range tagSlice {
+			info.Tags = append(info.Tags, string(tag))
+		}
+		tags.Manifests[string(digest)] = info
+		tags.Tags = append(tags.Tags, info.Tags...)
+	}
+	sort.Strings(tags.Tags)
+
+	children := make(map[string]bool)
+	for imageName := range mi[root] {
+		rest := string(imageName)
+		if path != "" {
+			if !strings.HasPrefix(rest, path+"/") {
+				continue
+			}
+			rest = strings.TrimPrefix(rest, path+"/")
+		}
+		children[strings.SplitN(rest, "/", 2)[0]] = true
+	}
+	for child := range children {
+		tags.Children = append(tags.Children, child)
+	}
+	sort.Strings(tags.Children)
+
+	return tags
+}
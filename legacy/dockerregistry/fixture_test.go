@@ -0,0 +1,166 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+var (
+	fixtureDigestA = reg.Digest("sha256:" + strings.Repeat("a", 64))
+	fixtureDigestB = reg.Digest("sha256:" + strings.Repeat("b", 64))
+	fixtureDigestC = reg.Digest("sha256:" + strings.Repeat("c", 64))
+)
+
+func fixturePath(name string) string {
+	return filepath.Join("inventory_test", "TestLoadInventoryFixture", name)
+}
+
+func TestLoadInventoryFixture(t *testing.T) {
+	expected := reg.MasterInventory{
+		"gcr.io/foo": {
+			"bar": {
+				fixtureDigestA: {"1.0", "latest"},
+				fixtureDigestB: {},
+			},
+			"baz/qux": {
+				fixtureDigestC: {"2.0"},
+			},
+		},
+		"gcr.io/prod": {
+			"bar": {
+				fixtureDigestA: {"1.0"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		path          string
+		expected      reg.MasterInventory
+		expectedError string
+	}{
+		{
+			"YAML",
+			fixturePath("inventory.yaml"),
+			expected,
+			"",
+		},
+		{
+			"JSON",
+			fixturePath("inventory.json"),
+			expected,
+			"",
+		},
+		{
+			"Tag pointing at multiple digests",
+			fixturePath("invalid.yaml"),
+			nil,
+			"invalid snapshot",
+		},
+		{
+			"Missing file",
+			fixturePath("missing.yaml"),
+			nil,
+			"no such file or directory",
+		},
+	}
+
+	for _, test := range tests {
+		got, err := reg.LoadInventoryFixture(test.path)
+		if test.expectedError != "" {
+			require.NotNil(t, err, test.name)
+			require.Contains(t, err.Error(), test.expectedError, test.name)
+			continue
+		}
+
+		require.Nil(t, err, test.name)
+		require.Equal(t, test.expected, got, test.name)
+	}
+}
+
+func TestReadRegistriesFromInventoryFixture(t *testing.T) {
+	mi, err := reg.LoadInventoryFixture(fixturePath("inventory.yaml"))
+	require.Nil(t, err)
+
+	rc := reg.RegistryContext{Name: "gcr.io/foo", Src: true}
+	sc := reg.SyncContext{
+		RegistryContexts: []reg.RegistryContext{rc},
+		Inv:              reg.MasterInventory{},
+		DigestMediaType:  make(reg.DigestMediaType),
+		DigestImageSize:  make(reg.DigestImageSize),
+	}
+	sc.ReadRegistries(
+		[]reg.RegistryContext{rc},
+		true,
+		reg.MkReadRepositoryCmdFromInventory(mi))
+
+	require.Empty(t, sc.Logs.Errors)
+	require.Equal(t, mi["gcr.io/foo"], sc.Inv["gcr.io/foo"])
+}
+
+func TestPromoteFromInventoryFixture(t *testing.T) {
+	mi, err := reg.LoadInventoryFixture(fixturePath("inventory.yaml"))
+	require.Nil(t, err)
+
+	mfest := reg.Manifest{
+		Registries: []reg.RegistryContext{
+			{Name: "gcr.io/foo", Src: true},
+			{Name: "gcr.io/prod"},
+		},
+		Images: []reg.Image{
+			{
+				ImageName: "bar",
+				Dmap:      reg.DigestTags{fixtureDigestA: {"1.0", "latest"}},
+			},
+			{
+				ImageName: "baz/qux",
+				Dmap:      reg.DigestTags{fixtureDigestC: {"2.0"}},
+			},
+		},
+	}
+	mfest.SrcRegistry = &mfest.Registries[0]
+
+	sc, err := reg.MakeSyncContext([]reg.Manifest{mfest}, 1, true, false)
+	require.Nil(t, err)
+	sc.MkReadRepositoryCmd = reg.MkReadRepositoryCmdFromInventory(mi)
+
+	edges, err := reg.ToPromotionEdges([]reg.Manifest{mfest})
+	require.Nil(t, err)
+
+	// "bar:1.0" is already in prod, according to the fixture.
+	edges, ok := sc.FilterPromotionEdges(edges, true)
+	require.True(t, ok)
+	require.Empty(t, sc.Logs.Errors)
+
+	got := []string{}
+	for _, edge := range reg.SortedPromotionEdges(edges) {
+		got = append(
+			got,
+			string(edge.DstImageTag.ImageName)+":"+string(edge.DstImageTag.Tag))
+	}
+	require.Equal(t, []string{"bar:latest", "baz/qux:2.0"}, got)
+
+	require.Nil(t, sc.Promote(edges, nil, nil))
+	require.Equal(t, 2, sc.Logs.Promotions.Succeeded)
+}
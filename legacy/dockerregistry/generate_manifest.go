@@ -0,0 +1,91 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// MissingImages returns the digests of src that are not in dst, along with
+// their tags, and the tags of src that do not point at the same digest in
+// dst. That is, what would have to be promoted from src to make dst match
+// it. Tags that point at another digest in dst are included (see
+// FindTagConflicts()), even though promoting them moves the tags.
+func MissingImages(src, dst RegInvImage) RegInvImage {
+	missing := make(RegInvImage)
+	for imageName, digestTags := range src {
+		for digest, tags := range digestTags {
+			dstTags, digestExists := dst[imageName][digest]
+			dstTagSet := dstTags.ToTagSet()
+
+			missingTags := TagSlice{}
+			for _, tag := range tags {
+				if _, ok := dstTagSet[tag]; !ok {
+					missingTags = append(missingTags, tag)
+				}
+			}
+
+			// Already promoted, with all of its tags.
+			if digestExists && len(missingTags) == 0 {
+				continue
+			}
+
+			if missing[imageName] == nil {
+				missing[imageName] = make(DigestTags)
+			}
+			missing[imageName][digest] = missingTags
+		}
+	}
+
+	return missing
+}
+
+// GenerateManifestYAML returns a promoter manifest (as YAML) that promotes
+// everything that dst lacks from src (see MissingImages()), where src and dst
+// are the inventories of the registries srcRC and dstRC.
+func GenerateManifestYAML(
+	srcRC, dstRC RegistryContext,
+	src, dst RegInvImage,
+) (string, error) {
+	srcRC.Src = true
+	dstRC.Src = false
+
+	registries, err := yaml.Marshal(struct {
+		Registries []RegistryContext `yaml:"registries"`
+	}{
+		Registries: []RegistryContext{srcRC, dstRC},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.Write(registries)
+
+	missing := MissingImages(src, dst)
+	if len(missing) == 0 {
+		b.WriteString("images: []\n")
+		return b.String(), nil
+	}
+
+	b.WriteString("images:\n")
+	b.WriteString(missing.ToYAML(YamlMarshalingOpts{}))
+
+	return b.String(), nil
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+func TestMissingImages(t *testing.T) {
+	src := reg.RegInvImage{
+		"foo": {
+			"sha256:000": {"1.0", "latest"},
+			"sha256:111": {"0.9"},
+			"sha256:222": {},
+		},
+		"bar": {
+			"sha256:333": {"2.0"},
+		},
+		"baz": {
+			"sha256:444": {"3.0"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		dst      reg.RegInvImage
+		expected reg.RegInvImage
+	}{
+		{
+			"Empty destination",
+			reg.RegInvImage{},
+			src,
+		},
+		{
+			"Destination matches",
+			src,
+			reg.RegInvImage{},
+		},
+		{
+			"Missing and different",
+			reg.RegInvImage{
+				"foo": {
+					// "latest" is missing.
+					"sha256:000": {"1.0"},
+					// "0.9" points at another digest.
+					"sha256:555": {"0.9"},
+					// The tagless digest is there.
+					"sha256:222": {},
+				},
+				"baz": {
+					"sha256:444": {"3.0"},
+				},
+				// Images that are only in the destination are left alone.
+				"qux": {
+					"sha256:666": {"1.0"},
+				},
+			},
+			reg.RegInvImage{
+				"foo": {
+					"sha256:000": {"latest"},
+					"sha256:111": {"0.9"},
+				},
+				"bar": {
+					"sha256:333": {"2.0"},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		got := reg.MissingImages(src, test.dst)
+		require.Equal(t, test.expected, got, test.name)
+	}
+}
+
+func TestGenerateManifestYAML(t *testing.T) {
+	digest := func(c string) reg.Digest {
+		return reg.Digest("sha256:" + strings.Repeat(c, 64))
+	}
+
+	srcRC := reg.RegistryContext{Name: "gcr.io/foo-staging"}
+	dstRC := reg.RegistryContext{
+		Name:           "gcr.io/foo",
+		ServiceAccount: "robot@example.com",
+	}
+	src := reg.RegInvImage{
+		"a": {
+			digest("0"): {"1.0", "1.1"},
+			digest("1"): {},
+		},
+		"b": {
+			digest("2"): {"2.0"},
+		},
+	}
+	dst := reg.RegInvImage{
+		"a": {
+			digest("0"): {"1.0"},
+		},
+		"b": {
+			digest("2"): {"2.0"},
+		},
+	}
+
+	got, err := reg.GenerateManifestYAML(srcRC, dstRC, src, dst)
+	require.Nil(t, err)
+	require.Equal(
+		t,
+		`registries:
+- name: gcr.io/foo-staging
+  src: true
+- name: gcr.io/foo
+  service-account: robot@example.com
+images:
+- name: a
+  dmap:
+    "`+string(digest("0"))+`": ["1.1"]
+    "`+string(digest("1"))+`": []
+`,
+		got)
+
+	// The manifest is valid, and promotes what the destination lacks.
+	mfest, err := reg.ParseManifestYAML([]byte(got))
+	require.Nil(t, err)
+	require.Nil(t, mfest.Finalize())
+	require.Equal(t, srcRC.Name, mfest.SrcRegistry.Name)
+	require.Equal(t, reg.MissingImages(src, dst), mfest.ToRegInvImage())
+
+	// Nothing is missing.
+	got, err = reg.GenerateManifestYAML(srcRC, dstRC, src, src)
+	require.Nil(t, err)
+	require.True(t, strings.HasSuffix(got, "images: []\n"), got)
+
+	_, err = reg.ParseManifestYAML([]byte(got))
+	require.Nil(t, err)
+}
@@ -217,7 +217,7 @@ func ApplyFilters(o *GrowManifestOptions, rii RegInvImage) (RegInvImage, error)
 	}
 
 	if len(o.FilterDigest) > 0 {
-		rii = FilterByDigest(rii, o.FilterDigest)
+		rii = FilterByDigest(rii, []Digest{o.FilterDigest})
 	}
 
 	// Remove any other tags that should still be filtered.
@@ -245,13 +245,19 @@ func FilterByImage(rii RegInvImage, filterImage ImageName) RegInvImage {
 	return filtered
 }
 
-// FilterByDigest removes all images in RegInvImage that do not match the
-// filterDigest.
-func FilterByDigest(rii RegInvImage, filterDigest Digest) RegInvImage {
+// FilterByDigest removes all images in RegInvImage whose digest is not one of
+// the given digests. Requested digests that are not in the RegInvImage are
+// ignored.
+func FilterByDigest(rii RegInvImage, digests []Digest) RegInvImage {
+	wanted := make(map[Digest]interface{})
+	for _, digest := range digests {
+		wanted[digest] = nil
+	}
+
 	filtered := make(RegInvImage)
 	for imageName, digestTags := range rii {
 		for digest, tags := range digestTags {
-			if digest == filterDigest {
+			if _, ok := wanted[digest]; ok {
 				if filtered[imageName] == nil {
 					filtered[imageName] = make(DigestTags)
 				}
@@ -262,6 +268,30 @@ func FilterByDigest(rii RegInvImage, filterDigest Digest) RegInvImage {
 	return filtered
 }
 
+// ExcludeDigests removes the given digests (along with all of their tags)
+// from all images in RegInvImage. Images that are left without digests are
+// removed as well. Digests that are not in the RegInvImage are ignored.
+func ExcludeDigests(rii RegInvImage, digests []Digest) RegInvImage {
+	excluded := make(map[Digest]interface{})
+	for _, digest := range digests {
+		excluded[digest] = nil
+	}
+
+	filtered := make(RegInvImage)
+	for imageName, digestTags := range rii {
+		for digest, tags := range digestTags {
+			if _, ok := excluded[digest]; ok {
+				continue
+			}
+			if filtered[imageName] == nil {
+				filtered[imageName] = make(DigestTags)
+			}
+			filtered[imageName][digest] = tags
+		}
+	}
+	return filtered
+}
+
 // ExcludeTags removes tags in rii that match excludedTags.
 func ExcludeTags(rii RegInvImage, excludedTags map[Tag]bool) RegInvImage {
 	filtered := make(RegInvImage)
@@ -17,7 +17,10 @@ limitations under the License.
 package inventory
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -25,12 +28,17 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"text/tabwriter"
+	"text/template"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
+	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/crane"
 	ggcrV1 "github.com/google/go-containerregistry/pkg/v1"
 	ggcrV1Google "github.com/google/go-containerregistry/pkg/v1/google"
@@ -38,6 +46,7 @@ import (
 	"github.com/sirupsen/logrus"
 	yaml "gopkg.in/yaml.v2"
 
+	"sigs.k8s.io/k8s-container-image-promoter/internal/version"
 	"sigs.k8s.io/k8s-container-image-promoter/legacy/gcloud"
 	cipJson "sigs.k8s.io/k8s-container-image-promoter/legacy/json"
 	"sigs.k8s.io/k8s-container-image-promoter/legacy/stream"
@@ -55,6 +64,33 @@ func GetSrcRegistry(rcs []RegistryContext) (*RegistryContext, error) {
 	return nil, fmt.Errorf("could not find source registry")
 }
 
+// GetRegistry returns the registry with the given name, among the registries of
+// all manifests. The service account of the first manifest that declares it is
+// used.
+func GetRegistry(mfests []Manifest, name RegistryName) (*RegistryContext, error) {
+	known := make(map[RegistryName]interface{})
+	for _, mfest := range mfests {
+		for _, registry := range mfest.Registries {
+			registry := registry
+			if registry.Name == name {
+				return &registry, nil
+			}
+			known[registry.Name] = nil
+		}
+	}
+
+	names := make([]string, 0, len(known))
+	for registryName := range known {
+		names = append(names, string(registryName))
+	}
+	sort.Strings(names)
+
+	return nil, fmt.Errorf(
+		"registry %q is not in the manifest(s) (registries: %s)",
+		name,
+		strings.Join(names, ", "))
+}
+
 // MakeSyncContext creates a SyncContext.
 func MakeSyncContext(
 	mfests []Manifest,
@@ -71,7 +107,9 @@ func MakeSyncContext(
 		RegistryContexts:  make([]RegistryContext, 0),
 		DigestMediaType:   make(DigestMediaType),
 		DigestImageSize:   make(DigestImageSize),
+		DigestUploadTime:  make(DigestUploadTime),
 		ParentDigest:      make(ParentDigest),
+		tokensLock:        &sync.RWMutex{},
 	}
 
 	registriesSeen := make(map[RegistryContext]interface{})
@@ -105,6 +143,10 @@ func MakeSyncContext(
 		},
 	)
 
+	if err := sc.addImageGroups(mfests); err != nil {
+		return SyncContext{}, err
+	}
+
 	// Populate access tokens for all registries listed in the manifest.
 	if useSvcAcc {
 		err := sc.PopulateTokens()
@@ -116,37 +158,83 @@ func MakeSyncContext(
 	return sc, nil
 }
 
-// LogJSONSummary logs the SyncContext's Logs as a prettified JSON.
+// LogJSONSummary logs the SyncContext's Logs as a prettified JSON. If
+// informational messages are not logged (e.g., with '--quiet'), the summary is
+// still written to the output of the logger, as it is the outcome of the run.
 func (sc *SyncContext) LogJSONSummary() {
 	marshalled, err := json.MarshalIndent(sc.Logs, "", "  ")
 	if err != nil {
-		logrus.Infof("There was a problem generating the JSON summary: %v",
+		logrus.Warnf("There was a problem generating the JSON summary: %v",
 			err)
-	} else {
+	} else if logrus.IsLevelEnabled(logrus.InfoLevel) {
 		logrus.Info(string(marshalled))
+	} else {
+		fmt.Fprintln(logrus.StandardLogger().Out, string(marshalled))
 	}
 }
 
-// ParseManifestFromFile parses a Manifest from a filepath.
+// ParseManifestFromFile parses a Manifest from a filepath. Gzip-compressed
+// manifests are decompressed transparently.
 func ParseManifestFromFile(filePath string) (Manifest, error) {
+	b, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	return parseManifestBytes(filePath, b)
+}
+
+// ParseSignedManifestFromFile is like ParseManifestFromFile, but refuses to
+// parse the manifest unless verify accepts the detached signature at
+// signaturePath for it. The manifest is only read once, so it cannot change
+// between being verified and being parsed.
+func ParseSignedManifestFromFile(
+	filePath string,
+	signaturePath string,
+	verify SignatureVerifier,
+) (Manifest, error) {
+	b, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	if err := verify(b, signaturePath); err != nil {
+		return Manifest{}, fmt.Errorf(
+			"verifying signature %s of manifest %s: %w",
+			signaturePath,
+			filePath,
+			err,
+		)
+	}
+
+	return parseManifestBytes(filePath, b)
+}
+
+// parseManifestBytes parses the contents of the manifest file at filePath,
+// which may be compressed (see decompressManifest()).
+func parseManifestBytes(filePath string, b []byte) (Manifest, error) {
 	var mfest Manifest
 	var empty Manifest
 
-	b, err := ioutil.ReadFile(filePath)
+	b, name, err := decompressManifest(filePath, b)
 	if err != nil {
-		return empty, err
+		return empty, mkManifestError(filePath, err)
 	}
 
-	mfest, err = ParseManifestYAML(b)
+	if strings.EqualFold(filepath.Ext(name), ".json") {
+		mfest, err = ParseManifestJSON(b)
+	} else {
+		mfest, err = ParseManifestYAML(b)
+	}
 	if err != nil {
-		return empty, err
+		return empty, mkManifestError(filePath, err)
 	}
 
 	mfest.Filepath = filePath
 
 	err = mfest.Finalize()
 	if err != nil {
-		return empty, err
+		return empty, mkManifestError(filePath, err)
 	}
 
 	return mfest, nil
@@ -155,18 +243,31 @@ func ParseManifestFromFile(filePath string) (Manifest, error) {
 // ParseThinManifestFromFile parses a ThinManifest from a filepath and generates
 // a Manifest.
 func ParseThinManifestFromFile(filePath string) (Manifest, error) {
+	return parseThinManifestFromFile(
+		filePath,
+		ThinManifestDefaults{},
+		ThinManifestOptions{})
+}
+
+// parseThinManifestFromFile is like ParseThinManifestFromFile, but merges the
+// given defaults into the ThinManifest before generating the Manifest.
+func parseThinManifestFromFile(
+	filePath string,
+	defaults ThinManifestDefaults,
+	opts ThinManifestOptions,
+) (Manifest, error) {
 	var thinManifest ThinManifest
 	var mfest Manifest
 	var empty Manifest
 
-	b, err := ioutil.ReadFile(filePath)
+	b, err := opts.readFile(filePath)
 	if err != nil {
 		return empty, err
 	}
 
 	thinManifest, err = ParseThinManifestYAML(b)
 	if err != nil {
-		return empty, err
+		return empty, mkManifestError(filePath, err)
 	}
 
 	// Get directory name holding this thin manifest.
@@ -180,18 +281,161 @@ func ParseThinManifestFromFile(filePath string) (Manifest, error) {
 		return empty, err
 	}
 
+	strictDigests := thinManifest.StrictDigests || opts.StrictDigests
+	if strictDigests {
+		if err := ValidateStrictDigests(images); err != nil {
+			return empty, mkManifestError(imagesPath, err)
+		}
+	}
+
+	registries, err := defaults.Merge(thinManifest.Registries)
+	if err != nil {
+		return empty, fmt.Errorf("%s: %v", filePath, err)
+	}
+
 	mfest.Filepath = filePath
 	mfest.Images = images
-	mfest.Registries = thinManifest.Registries
+	mfest.Registries = registries
+	mfest.StrictDigests = strictDigests
+	mfest.GroupThreads = thinManifest.GroupThreads
 
 	err = mfest.Finalize()
 	if err != nil {
-		return empty, err
+		return empty, mkManifestError(filePath, err)
 	}
 
 	return mfest, nil
 }
 
+// ParseThinManifestDefaultsFromDir parses the ThinManifestDefaultsFile found at
+// the root of a thin manifest directory. The file is optional; if it does not
+// exist, empty defaults are returned.
+func ParseThinManifestDefaultsFromDir(dir string) (ThinManifestDefaults, error) {
+	return parseThinManifestDefaultsFromDir(dir, ThinManifestOptions{})
+}
+
+// parseThinManifestDefaultsFromDir is like ParseThinManifestDefaultsFromDir,
+// but reads the defaults file with the given options.
+func parseThinManifestDefaultsFromDir(
+	dir string,
+	opts ThinManifestOptions,
+) (ThinManifestDefaults, error) {
+	var empty ThinManifestDefaults
+
+	b, err := opts.readFile(filepath.Join(dir, ThinManifestDefaultsFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return empty, nil
+		}
+		return empty, err
+	}
+
+	return ParseThinManifestDefaultsYAML(b)
+}
+
+// filename is the name of the thin manifest files.
+func (o ThinManifestOptions) filename() string {
+	if o.Filename != "" {
+		return o.Filename
+	}
+
+	return ThinManifestDefaultFilename
+}
+
+// readFile reads the given thin manifest (or defaults) file, expanding the
+// references to environment variables in it if asked to.
+func (o ThinManifestOptions) readFile(filePath string) ([]byte, error) {
+	b, err := ioutil.ReadFile(filePath)
+	if err != nil || !o.ExpandEnv {
+		return b, err
+	}
+
+	b, err = ExpandEnv(b)
+	if err != nil {
+		return nil, mkManifestError(filePath, err)
+	}
+
+	return b, nil
+}
+
+// envVarRegex matches references to environment variables: ${VAR}, or
+// ${VAR:-default}.
+var envVarRegex = regexp.MustCompile(
+	`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// ExpandEnv substitutes the references to environment variables in b. ${VAR}
+// is replaced by the value of VAR, which must be set. ${VAR:-default} is
+// replaced by the value of VAR, or by default if VAR is unset or empty (as in
+// the shell). Anything else, including "$VAR", is left as is.
+func ExpandEnv(b []byte) ([]byte, error) {
+	undefined := make([]string, 0)
+	expanded := envVarRegex.ReplaceAllFunc(b, func(ref []byte) []byte {
+		m := envVarRegex.FindSubmatch(ref)
+		name := string(m[1])
+		value, ok := os.LookupEnv(name)
+
+		// m[2] is only set if there is a default.
+		if len(m[2]) > 0 {
+			if value == "" {
+				return m[3]
+			}
+			return []byte(value)
+		}
+
+		if !ok {
+			undefined = append(undefined, name)
+		}
+		return []byte(value)
+	})
+
+	if len(undefined) > 0 {
+		return nil, fmt.Errorf(
+			"undefined environment variable(s) %q (use ${VAR:-default} for a default)",
+			undefined)
+	}
+
+	return expanded, nil
+}
+
+// Merge returns the registries of a ThinManifest, with all default registries
+// that the ThinManifest does not override appended to it. See
+// ThinManifestDefaults for the override semantics.
+func (d ThinManifestDefaults) Merge(
+	registries []RegistryContext,
+) ([]RegistryContext, error) {
+	if len(d.Registries) == 0 {
+		return registries, nil
+	}
+
+	merged := make([]RegistryContext, 0, len(registries)+len(d.Registries))
+	overridden := make(map[RegistryName]interface{})
+	var srcRegistryName RegistryName
+	for _, rc := range registries {
+		overridden[rc.Name] = nil
+		if rc.Src {
+			srcRegistryName = rc.Name
+		}
+		merged = append(merged, rc)
+	}
+
+	for _, rc := range d.Registries {
+		if _, ok := overridden[rc.Name]; ok {
+			continue
+		}
+
+		if rc.Src && len(srcRegistryName) > 0 {
+			return nil, fmt.Errorf(
+				"default source registry %q conflicts with source registry %q",
+				rc.Name,
+				srcRegistryName)
+		}
+
+		merged = append(merged, rc)
+	}
+
+	return merged, nil
+}
+
 // ParseImagesFromFile parses an Images type from a file.
 func ParseImagesFromFile(filePath string) (Images, error) {
 	var images Images
@@ -204,12 +448,54 @@ func ParseImagesFromFile(filePath string) (Images, error) {
 
 	images, err = ParseImagesYAML(b)
 	if err != nil {
-		return empty, err
+		return empty, mkManifestError(filePath, err)
 	}
 
 	return images, nil
 }
 
+// yamlErrorLine matches the line number in the errors of the YAML parser,
+// e.g. "yaml: line 3: did not find expected key", and in those of
+// ParseManifestJSON(), e.g. "json: line 3, column 5: unknown field "foo"".
+var yamlErrorLine = regexp.MustCompile(`line ([0-9]+)[:,]`)
+
+// mkManifestError wraps an error found in the given file into a ManifestError,
+// picking up the line number from YAML (or JSON) parse errors.
+func mkManifestError(filePath string, err error) *ManifestError {
+	mErr := ManifestError{
+		Filepath: filePath,
+		Err:      err,
+	}
+
+	if m := yamlErrorLine.FindStringSubmatch(err.Error()); m != nil {
+		// The regexp guarantees a valid number.
+		mErr.Line, _ = strconv.Atoi(m[1])
+	}
+
+	return &mErr
+}
+
+func (e *ManifestError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ManifestError) Unwrap() error {
+	return e.Err
+}
+
+func (e *InvalidManifestsError) Error() string {
+	lines := make([]string, 0, len(e.Skipped))
+	for _, mErr := range e.Skipped {
+		lines = append(lines, fmt.Sprintf("%s: %v", mErr.Filepath, mErr.Err))
+	}
+
+	return fmt.Sprintf(
+		"skipped %d invalid manifest(s):\n  %s",
+		len(e.Skipped),
+		strings.Join(lines, "\n  "),
+	)
+}
+
 // Finalize finalizes a Manifest by populating extra fields.
 // TODO: ST1016: methods on the same type should have the same receiver name
 // nolint: stylecheck
@@ -227,20 +513,236 @@ func (m *Manifest) Finalize() error {
 // ParseThinManifestsFromDir parses all thin Manifest files within a directory.
 // We effectively have to create a map of manifests, keyed by the source
 // registry (there can only be 1 source registry).
+func ParseThinManifestsFromDir(
+	dir string,
+) ([]Manifest, error) {
+	return ParseThinManifestsFromDirWithOptions(dir, ThinManifestOptions{})
+}
+
+// ParseThinManifestsFromDirWithOptions is like ParseThinManifestsFromDir, but
+// parses the thin manifests with the given options.
+func ParseThinManifestsFromDirWithOptions(
+	dir string,
+	opts ThinManifestOptions,
+) ([]Manifest, error) {
+	return parseThinManifestsFromDir(dir, nil, opts)
+}
+
+// ParseChangedThinManifestsFromDir is like ParseThinManifestsFromDir, but only
+// parses the thin manifests affected by the given changed files (e.g., the
+// files changed by a pull request). A manifest is affected if either it or its
+// images file changed; if the defaults file changed, all manifests are.
+//
+// The changed files may be given relative to dir, or as paths that lead into
+// dir (relative to the current directory, or absolute). Files that do not
+// exist (e.g., because they were deleted) or that are not part of the thin
+// manifests are skipped. Unlike ParseThinManifestsFromDir, it is not an error
+// if no manifest is affected.
+func ParseChangedThinManifestsFromDir(
+	dir string,
+	changed []string,
+	opts ThinManifestOptions,
+) ([]Manifest, error) {
+	only, err := changedThinManifests(dir, changed, opts.filename())
+	if err != nil {
+		return nil, err
+	}
+
+	if only == nil {
+		return ParseThinManifestsFromDirWithOptions(dir, opts)
+	}
+
+	if len(only) == 0 {
+		return []Manifest{}, nil
+	}
+
+	return parseThinManifestsFromDir(dir, only, opts)
+}
+
+// ParseThinManifestsFromDirSinceRef is like ParseChangedThinManifestsFromDir,
+// but derives the changed files itself by diffing dir (which must be within a
+// git checkout) against the given git ref. The diff is run by the producer
+// that mkGitDiffCmd returns (normally MkGitDiffCmdReal).
+func ParseThinManifestsFromDirSinceRef(
+	dir string,
+	ref string,
+	mkGitDiffCmd func(dir, ref string) stream.Producer,
+	opts ThinManifestOptions,
+) ([]Manifest, error) {
+	changed, err := ChangedFilesSinceRef(dir, ref, mkGitDiffCmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseChangedThinManifestsFromDir(dir, changed, opts)
+}
+
+// ChangedFilesSinceRef returns the files within dir that changed since the
+// given git ref, as paths that lead into dir. The producer that mkGitDiffCmd
+// returns is expected to print one path (relative to dir) per line.
+func ChangedFilesSinceRef(
+	dir string,
+	ref string,
+	mkGitDiffCmd func(dir, ref string) stream.Producer,
+) ([]string, error) {
+	stdout, err := readProducer(mkGitDiffCmd(dir, ref))
+	if err != nil {
+		return nil, fmt.Errorf("diffing %q against %q: %w", dir, ref, err)
+	}
+
+	changed := make([]string, 0)
+	for _, line := range strings.Split(string(stdout), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		changed = append(changed, filepath.Join(dir, line))
+	}
+
+	return changed, nil
+}
+
+// readProducer runs the producer to completion and returns its stdout. If it
+// fails, the error includes its stderr.
+func readProducer(producer stream.Producer) ([]byte, error) {
+	stdoutReader, stderrReader, err := producer.Produce()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := ioutil.ReadAll(stdoutReader)
+	if err != nil {
+		return nil, err
+	}
+
+	stderr, _ := ioutil.ReadAll(stderrReader)
+	if err := producer.Close(); err != nil {
+		return nil, fmt.Errorf(
+			"%w: %s",
+			err,
+			strings.TrimSpace(string(stderr)),
+		)
+	}
+
+	return stdout, nil
+}
+
+// MkGitDiffCmdReal lists the files within dir that differ between the given
+// git ref and the working tree, relative to dir.
+func MkGitDiffCmdReal(dir, ref string) stream.Producer {
+	var sp stream.Subprocess
+	sp.CmdInvocation = []string{
+		"git",
+		"-C",
+		dir,
+		"diff",
+		"--name-only",
+		"--relative",
+		ref,
+		"--",
+	}
+
+	return &sp
+}
+
+// changedThinManifests returns the set of manifests (named filename, relative
+// to dir) that are affected by the changed files. It returns nil if all
+// manifests are affected.
+func changedThinManifests(
+	dir string,
+	changed []string,
+	filename string,
+) (map[string]bool, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	only := make(map[string]bool)
+	for _, path := range changed {
+		rel, ok := relToDir(absDir, path)
+		if !ok {
+			logrus.Debugf("skipping changed file %q: not in %q", path, dir)
+			continue
+		}
+
+		if rel == ThinManifestDefaultsFile {
+			return nil, nil
+		}
+
+		// An images file affects the manifest of the same name.
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if len(parts) == 3 && parts[0] == "images" && parts[2] == "images.yaml" {
+			rel = filepath.Join("manifests", parts[1], filename)
+		}
+
+		if filepath.Base(rel) != filename {
+			logrus.Debugf("skipping changed file %q: not a thin manifest", path)
+			continue
+		}
+
+		if _, err := os.Stat(filepath.Join(absDir, rel)); err != nil {
+			if os.IsNotExist(err) {
+				logrus.Infof("skipping changed file %q: it no longer exists", path)
+				continue
+			}
+			return nil, err
+		}
+
+		only[rel] = true
+	}
+
+	return only, nil
+}
+
+// relToDir returns path relative to absDir, if path is within absDir. The path
+// is first interpreted relative to the current directory, then relative to
+// absDir itself.
+func relToDir(absDir, path string) (string, bool) {
+	candidates := []string{filepath.Join(absDir, path)}
+	if absPath, err := filepath.Abs(path); err == nil {
+		candidates = append([]string{absPath}, candidates...)
+	}
+
+	for _, candidate := range candidates {
+		rel, err := filepath.Rel(absDir, candidate)
+		if err != nil || rel == ".." ||
+			strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+
+		return rel, true
+	}
+
+	return "", false
+}
+
+// parseThinManifestsFromDir parses the thin manifests within dir with the
+// given options. If only is not nil, only the manifests in it (relative to
+// dir) are parsed.
 //
 // nolint[funlen]
-func ParseThinManifestsFromDir(
+func parseThinManifestsFromDir(
 	dir string,
+	only map[string]bool,
+	opts ThinManifestOptions,
 ) ([]Manifest, error) {
 	mfests := make([]Manifest, 0)
 
 	// Check that the thin manifests dir follows a regular, predefined format.
 	// This is to ensure that there isn't any funny business going on around
 	// paths.
-	if err := ValidateThinManifestDirectoryStructure(dir); err != nil {
+	filename := opts.filename()
+	if err := validateThinManifestDirectoryStructure(dir, filename); err != nil {
 		return mfests, err
 	}
 
+	defaults, err := parseThinManifestDefaultsFromDir(dir, opts)
+	if err != nil {
+		return mfests, err
+	}
+
+	invalid := &InvalidManifestsError{}
 	var parseAsManifest filepath.WalkFunc = func(path string,
 		info os.FileInfo,
 		err error) error {
@@ -256,13 +758,21 @@ func ParseThinManifestsFromDir(
 		}
 
 		// First try to parse the path as a manifest file, which must be named
-		// "promoter-manifest.yaml". This restriction is in place to limit the
-		// scope of what is read in as a promoter manifest.
-		if filepath.Base(path) != "promoter-manifest.yaml" {
+		// filename ("promoter-manifest.yaml" by default). This restriction is
+		// in place to limit the scope of what is read in as a promoter
+		// manifest.
+		if filepath.Base(path) != filename {
 			return nil
 		}
 
-		// If there are any files named "promoter-manifest.yaml", they must be
+		if only != nil {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil || !only[rel] {
+				return nil
+			}
+		}
+
+		// If there are any files named filename, they must be
 		// inside a subfolder within "manifests/<dir>" --- any other paths are
 		// forbidden.
 		shortened := strings.TrimPrefix(path, dir)
@@ -272,8 +782,17 @@ func ParseThinManifestsFromDir(
 				path)
 		}
 
-		mfest, errParse := ParseThinManifestFromFile(path)
+		mfest, errParse := parseThinManifestFromFile(path, defaults, opts)
 		if errParse != nil {
+			if opts.SkipInvalid {
+				logrus.Warnf(
+					"skipping invalid manifest file '%s': %v", path, errParse)
+				mErr := &ManifestError{Filepath: path, Err: errParse}
+				errors.As(errParse, &mErr)
+				invalid.Skipped = append(invalid.Skipped, mErr)
+				return nil
+			}
+
 			logrus.Errorf("could not parse manifest file '%s'\n", path)
 			return errParse
 		}
@@ -290,6 +809,15 @@ func ParseThinManifestsFromDir(
 		return mfests, err
 	}
 
+	if len(invalid.Skipped) > 0 {
+		if len(mfests) == 0 {
+			return nil, fmt.Errorf(
+				"no valid manifests found in dir: %s: %w", dir, invalid)
+		}
+
+		return mfests, invalid
+	}
+
 	if len(mfests) == 0 {
 		return nil, fmt.Errorf("no manifests found in dir: %s", dir)
 	}
@@ -301,10 +829,22 @@ func ParseThinManifestsFromDir(
 // structure for thin manifests. Most importantly, it requires that if a file
 // named "foo/manifests/bar/promoter-manifest.yaml" exists, that a corresponding
 // file named "foo/images/bar/promoter-manifest.yaml" must also exist.
+func ValidateThinManifestDirectoryStructure(
+	dir string,
+) error {
+	return validateThinManifestDirectoryStructure(
+		dir,
+		ThinManifestDefaultFilename)
+}
+
+// validateThinManifestDirectoryStructure is like
+// ValidateThinManifestDirectoryStructure, but for thin manifests named
+// filename.
 //
 // nolint[gocyclo]
-func ValidateThinManifestDirectoryStructure(
+func validateThinManifestDirectoryStructure(
 	dir string,
+	filename string,
 ) error {
 	// First, enforce that there are directories named "images" and "manifests".
 	if err := validateIsDirectory(filepath.Join(dir, "images")); err != nil {
@@ -316,8 +856,8 @@ func ValidateThinManifestDirectoryStructure(
 		return err
 	}
 
-	// For every subfolder in <dir>/manifests, ensure that a
-	// "promoter-manifest.yaml" file exists, and also that a corresponding file
+	// For every subfolder in <dir>/manifests, ensure that a filename
+	// ("promoter-manifest.yaml") file exists, and also that a corresponding file
 	// exists in the "images" folder.
 	files, err := ioutil.ReadDir(manifestDir)
 	if err != nil {
@@ -336,11 +876,11 @@ func ValidateThinManifestDirectoryStructure(
 			continue
 		}
 
-		// Search for a "promoter-manifest.yaml" file under this directory.
+		// Search for a filename file under this directory.
 		manifestInfo, err := os.Stat(
 			filepath.Join(manifestDir,
 				file.Name(),
-				"promoter-manifest.yaml"))
+				filename))
 		if err != nil {
 			logrus.Warningln(err)
 			continue
@@ -350,7 +890,7 @@ func ValidateThinManifestDirectoryStructure(
 			continue
 		}
 
-		// "promoter-manifest.yaml" exists, so check for corresponding images
+		// The manifest exists, so check for corresponding images
 		// file, which MUST exist. This is why we fail early if we detect an
 		// error here.
 		imagesPath := filepath.Join(dir,
@@ -388,46 +928,432 @@ func validateIsDirectory(dir string) error {
 }
 
 // ToPromotionEdges converts a list of manifests to a set of edges we want to
-// try promoting.
+// try promoting. Manifests with a quarantine registry only promote to it.
+//
+// It is an error for a manifest to list its source registry again as a
+// destination registry (e.g., with a different service account), because the
+// resulting edges would promote images onto themselves. Use
+// ToPromotionEdgesAllowSelfPromotion() to allow such edges. It is also an
+// error for the manifests to promote an image around a cycle of registries.
 func ToPromotionEdges(mfests []Manifest) (map[PromotionEdge]interface{}, error) {
-	edges := make(map[PromotionEdge]interface{})
+	return toPromotionEdges(mfests, false)
+}
+
+// ToPromotionEdgesAllowSelfPromotion is like ToPromotionEdges, but allows edges
+// whose destination registry is the source registry.
+func ToPromotionEdgesAllowSelfPromotion(
+	mfests []Manifest,
+) (map[PromotionEdge]interface{}, error) {
+	return toPromotionEdges(mfests, true)
+}
+
+// GroupName returns the group the image belongs to.
+func (image *Image) GroupName() string {
+	if image.Group == "" {
+		return DefaultImageGroup
+	}
+
+	return image.Group
+}
+
+// FilterManifestsByGroup returns copies of the given manifests that only hold
+// the images belonging to one of the given groups. Passing the result to
+// ToPromotionEdges scopes the promotion to those groups.
+func FilterManifestsByGroup(mfests []Manifest, groups []string) []Manifest {
+	wanted := make(map[string]bool)
+	for _, group := range groups {
+		wanted[group] = true
+	}
+
+	filtered := make([]Manifest, 0, len(mfests))
 	for _, mfest := range mfests {
-		for _, image := range mfest.Images {
-			for digest, tagArray := range image.Dmap {
-				for _, destRC := range mfest.Registries {
-					if destRC == *mfest.SrcRegistry {
-						continue
-					}
+		images := make([]Image, 0)
+		for i := range mfest.Images {
+			if wanted[mfest.Images[i].GroupName()] {
+				images = append(images, mfest.Images[i])
+			}
+		}
+		mfest.Images = images
+		filtered = append(filtered, mfest)
+	}
 
-					if len(tagArray) > 0 {
-						for _, tag := range tagArray {
-							edge := mkPromotionEdge(
-								*mfest.SrcRegistry,
-								destRC,
-								image.ImageName,
-								digest,
-								tag)
-							edges[edge] = nil
-						}
-					} else {
-						// If this digest does not have any associated tags, still create
-						// a promotion edge for it (tagless promotion).
-						edge := mkPromotionEdge(
-							*mfest.SrcRegistry,
-							destRC,
-							image.ImageName,
-							digest,
-							"",
-						)
+	return filtered
+}
+
+// ToDestNamespace returns copies of the given edges that place their
+// destination images under namespace, e.g. "teams/foo/bar" (or
+// "teams/foo/bar/baz") for the image "bar" (or "bar/baz") in the namespace
+// "teams/foo". The source images are left as they are. An empty namespace
+// leaves the edges unchanged.
+func ToDestNamespace(
+	edges map[PromotionEdge]interface{},
+	namespace string,
+) (map[PromotionEdge]interface{}, error) {
+	if namespace == "" {
+		return edges, nil
+	}
+
+	namespace = strings.TrimSuffix(namespace, "/")
+	if err := ValidateDestNamespace(namespace); err != nil {
+		return nil, err
+	}
+
+	relocated := make(map[PromotionEdge]interface{})
+	for edge := range edges {
+		edge.DstImageTag.ImageName = ImageName(
+			namespace + "/" + string(edge.DstImageTag.ImageName))
+		relocated[edge] = nil
+	}
+
+	return relocated, nil
+}
+
+func toPromotionEdges(
+	mfests []Manifest,
+	allowSelfPromotion bool,
+) (map[PromotionEdge]interface{}, error) {
+	// The manifests are independent of each other, so their edges are
+	// converted concurrently (by at most as many goroutines as there are
+	// CPUs), and merged in the order of the manifests.
+	var (
+		wg      sync.WaitGroup
+		slots   = make(chan struct{}, runtime.GOMAXPROCS(0))
+		results = make([]map[PromotionEdge]interface{}, len(mfests))
+		errs    = make([]error, len(mfests))
+	)
+	for i := range mfests {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			results[i], errs[i] = manifestPromotionEdges(
+				&mfests[i],
+				allowSelfPromotion)
+		}(i)
+	}
+	wg.Wait()
+
+	if err := joinManifestErrors(errs); err != nil {
+		return nil, err
+	}
+
+	edges := make(map[PromotionEdge]interface{})
+	for _, result := range results {
+		for edge := range result {
+			edges[edge] = nil
+		}
+	}
+
+	if err := checkDestImagePolicies(edges); err != nil {
+		return nil, err
+	}
+
+	if err := checkPromotionCycles(edges); err != nil {
+		return nil, err
+	}
+
+	return CheckOverlappingEdges(edges)
+}
+
+// checkDestImagePolicies fails if the edges to the same destination image do
+// not agree on the policies of the image (see destImagePolicies()), e.g.
+// because two manifests promote it with different caps. The policies are part
+// of each edge, so conflicting values would yield several edges for the same
+// promotion, of which CheckOverlappingEdges() keeps an arbitrary one.
+func checkDestImagePolicies(edges map[PromotionEdge]interface{}) error {
+	// Destination image -> policy -> the values it is given.
+	values := make(map[string]map[string]map[string]interface{})
+	for edge := range edges {
+		dstImage := fmt.Sprintf(
+			"%s/%s",
+			edge.DstRegistry.Name,
+			edge.DstImageTag.ImageName)
+		if values[dstImage] == nil {
+			values[dstImage] = make(map[string]map[string]interface{})
+		}
+
+		for policy, value := range destImagePolicies(edge) {
+			if values[dstImage][policy] == nil {
+				values[dstImage][policy] = make(map[string]interface{})
+			}
+			values[dstImage][policy][value] = nil
+		}
+	}
+
+	conflicts := make([]string, 0)
+	for dstImage, policies := range values {
+		for policy, policyValues := range policies {
+			if len(policyValues) < 2 {
+				continue
+			}
+
+			sorted := make([]string, 0, len(policyValues))
+			for value := range policyValues {
+				sorted = append(sorted, value)
+			}
+			sort.Strings(sorted)
+
+			conflicts = append(conflicts, fmt.Sprintf(
+				"%s: conflicting %s (%s)",
+				dstImage,
+				policy,
+				strings.Join(sorted, ", ")))
+		}
+	}
 
-						edges[edge] = nil
+	if len(conflicts) == 0 {
+		return nil
+	}
+	sort.Strings(conflicts)
+
+	return fmt.Errorf(
+		"%d image(s) are promoted with conflicting policies:\n  %s",
+		len(conflicts),
+		strings.Join(conflicts, "\n  "))
+}
+
+// destImagePolicies returns the policies of the destination image that the
+// edge carries, by their name in the manifest.
+func destImagePolicies(edge PromotionEdge) map[string]string {
+	return map[string]string{
+		"maxTags":       strconv.Itoa(edge.MaxTags),
+		"minSignatures": strconv.Itoa(edge.MinSignatures),
+		"window":        strconv.Quote(edge.Window),
+	}
+}
+
+// manifestPromotionEdges converts a single manifest to the edges it promotes
+// (see toPromotionEdges).
+func manifestPromotionEdges(
+	mfest *Manifest,
+	allowSelfPromotion bool,
+) (map[PromotionEdge]interface{}, error) {
+	edges := make(map[PromotionEdge]interface{})
+	quarantine := mfest.QuarantineRegistry()
+	for i := range mfest.Images {
+		image := &mfest.Images[i]
+		for _, destRC := range mfest.Registries {
+			if destRC == *mfest.SrcRegistry {
+				continue
+			}
+
+			// The other registries are only promoted to from quarantine.
+			if quarantine != nil && destRC != *quarantine {
+				continue
+			}
+
+			if destRC.Name == mfest.SrcRegistry.Name && !allowSelfPromotion {
+				return nil, fmt.Errorf(
+					"manifest %q: registry %q is both the source and a destination of image %q (self-promotion)",
+					mfest.Filepath,
+					destRC.Name,
+					image.ImageName)
+			}
+
+			addPromotionEdges(edges, *mfest.SrcRegistry, destRC, image)
+		}
+	}
+
+	return edges, nil
+}
+
+// joinManifestErrors returns the error of the only manifest that failed, or
+// one error that lists the errors of all manifests that failed, in their
+// order.
+func joinManifestErrors(errs []error) error {
+	failed := make([]error, 0)
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+
+	switch len(failed) {
+	case 0:
+		return nil
+	case 1:
+		return failed[0]
+	}
+
+	lines := make([]string, 0, len(failed))
+	for _, err := range failed {
+		lines = append(lines, err.Error())
+	}
+
+	return fmt.Errorf(
+		"%d manifest(s) could not be converted to promotion edges:\n  %s",
+		len(failed),
+		strings.Join(lines, "\n  "))
+}
+
+// checkPromotionCycles rejects edges that, taken together, promote an image out
+// of a registry and back into it, e.g. gcr.io/a -> gcr.io/b -> gcr.io/a (which
+// can only happen across manifests). Self-promotion edges are not considered,
+// as they are only allowed on request.
+func checkPromotionCycles(edges map[PromotionEdge]interface{}) error {
+	// For each image, the registries it is promoted to from each registry.
+	graphs := make(map[ImageName]map[RegistryName]map[RegistryName]bool)
+	for edge := range edges {
+		src := edge.SrcRegistry.Name
+		dst := edge.DstRegistry.Name
+		if src == dst {
+			continue
+		}
+
+		graph, ok := graphs[edge.SrcImageTag.ImageName]
+		if !ok {
+			graph = make(map[RegistryName]map[RegistryName]bool)
+			graphs[edge.SrcImageTag.ImageName] = graph
+		}
+
+		if graph[src] == nil {
+			graph[src] = make(map[RegistryName]bool)
+		}
+
+		graph[src][dst] = true
+	}
+
+	images := make([]string, 0, len(graphs))
+	for image := range graphs {
+		images = append(images, string(image))
+	}
+	sort.Strings(images)
+
+	for _, image := range images {
+		cycle := findPromotionCycle(graphs[ImageName(image)])
+		if cycle == nil {
+			continue
+		}
+
+		registries := make([]string, 0, len(cycle))
+		for _, registry := range cycle {
+			registries = append(registries, string(registry))
+		}
+
+		return fmt.Errorf(
+			"promotion cycle for image %q: %s",
+			image,
+			strings.Join(registries, " -> "),
+		)
+	}
+
+	return nil
+}
+
+// findPromotionCycle returns the registries along a cycle of the given graph
+// (starting and ending with the same registry), or nil if there is none. The
+// graph is walked in sorted order, so that the same cycle is always reported.
+func findPromotionCycle(
+	graph map[RegistryName]map[RegistryName]bool,
+) []RegistryName {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	sortedNames := func(names map[RegistryName]bool) []RegistryName {
+		sorted := make([]RegistryName, 0, len(names))
+		for name := range names {
+			sorted = append(sorted, name)
+		}
+		sort.Slice(sorted, func(i, j int) bool {
+			return sorted[i] < sorted[j]
+		})
+
+		return sorted
+	}
+
+	state := make(map[RegistryName]int)
+	path := make([]RegistryName, 0)
+
+	var visit func(from RegistryName) []RegistryName
+	visit = func(from RegistryName) []RegistryName {
+		state[from] = visiting
+		path = append(path, from)
+
+		for _, to := range sortedNames(graph[from]) {
+			switch state[to] {
+			case visiting:
+				// The path leads back to a registry that is still being
+				// visited, so the cycle is the path from that registry on.
+				for i := range path {
+					if path[i] == to {
+						cycle := append([]RegistryName{}, path[i:]...)
+						return append(cycle, to)
 					}
 				}
+			case unvisited:
+				if cycle := visit(to); cycle != nil {
+					return cycle
+				}
 			}
 		}
+
+		path = path[:len(path)-1]
+		state[from] = visited
+
+		return nil
 	}
 
-	return CheckOverlappingEdges(edges)
+	sources := make(map[RegistryName]bool)
+	for src := range graph {
+		sources[src] = true
+	}
+
+	for _, src := range sortedNames(sources) {
+		if state[src] != unvisited {
+			continue
+		}
+
+		if cycle := visit(src); cycle != nil {
+			return cycle
+		}
+	}
+
+	return nil
+}
+
+// addPromotionEdges adds the edges that promote every digest of the image
+// (under each of its tags, or tagless if it has none) from srcRC to destRC.
+func addPromotionEdges(
+	edges map[PromotionEdge]interface{},
+	srcRC, destRC RegistryContext,
+	image *Image,
+) {
+	for digest, tagArray := range image.Dmap {
+		if len(tagArray) > 0 {
+			for _, tag := range tagArray {
+				edge := mkPromotionEdge(
+					srcRC,
+					destRC,
+					image.ImageName,
+					digest,
+					tag)
+				edge.MaxTags = image.MaxTags
+				edge.MinSignatures = image.MinSignatures
+				edge.Window = image.Window
+				edges[edge] = nil
+			}
+		} else {
+			// If this digest does not have any associated tags, still create
+			// a promotion edge for it (tagless promotion).
+			edge := mkPromotionEdge(
+				srcRC,
+				destRC,
+				image.ImageName,
+				digest,
+				"",
+			)
+			edge.MaxTags = image.MaxTags
+			edge.MinSignatures = image.MinSignatures
+			edge.Window = image.Window
+
+			edges[edge] = nil
+		}
+	}
 }
 
 func mkPromotionEdge(
@@ -473,12 +1399,31 @@ func (sc *SyncContext) GetPromotionCandidates(edges map[PromotionEdge]interface{
 		ignoreMap[ignoreMe] = nil
 	}
 
+	// logSkipped logs edges that are skipped because they were already
+	// promoted, unless we're asked to be quiet about it.
+	skipped := 0
+	logSkipped := func(format string, edge PromotionEdge) {
+		skipped++
+		if !sc.SkipExistingQuietly {
+			logrus.Infof(format, edge)
+		}
+	}
+
+	if sc.LogSkippedEdges {
+		sc.Logs.Skipped = nil
+	}
+
 	toPromote := make(map[PromotionEdge]interface{})
 	// nolint[lll]
 	for edge := range edges {
+		// Whether the tag is overwritten is decided anew (see
+		// recheckPromotionEdges()).
+		edge.OldDigest = ""
+
 		// If the edge should be ignored because of a bad read in sc.Inv, drop it
 		if img, ok := ignoreMap[edge.SrcImageTag.ImageName]; ok {
 			logrus.Warnf("edge %v: ignoring because src image could not be read: %s\n", edge, img)
+			sc.recordSkipped(edge, SkipUnreadableSource)
 			continue
 		}
 
@@ -486,7 +1431,8 @@ func (sc *SyncContext) GetPromotionCandidates(edges map[PromotionEdge]interface{
 
 		// If dst vertex exists, NOP.
 		if dp.PqinDigestMatch {
-			logrus.Infof("edge %v: skipping because it was already promoted (case 1)\n", edge)
+			logSkipped("edge %v: skipping because it was already promoted (case 1)\n", edge)
+			sc.recordSkipped(edge, SkipAlreadyPromoted)
 			continue
 		}
 
@@ -496,7 +1442,10 @@ func (sc *SyncContext) GetPromotionCandidates(edges map[PromotionEdge]interface{
 			// Still, log a warning if the source is missing the image.
 			if !sp.DigestExists {
 				logrus.Errorf("edge %v: skipping %s/%s@%s because it was already promoted, but it is still _LOST_ (can't find it in src registry! please backfill it!)\n", edge, edge.SrcRegistry.Name, edge.SrcImageTag.ImageName, edge.Digest)
+			} else {
+				skipped++
 			}
+			sc.recordSkipped(edge, SkipAlreadyPromoted)
 			continue
 		}
 
@@ -504,6 +1453,7 @@ func (sc *SyncContext) GetPromotionCandidates(edges map[PromotionEdge]interface{
 		// in src (we don't care if it points to the wrong tag).
 		if !sp.DigestExists {
 			logrus.Errorf("edge %v: skipping %s/%s@%s because it is _LOST_ (can't find it in src registry!)\n", edge, edge.SrcRegistry.Name, edge.SrcImageTag.ImageName, edge.Digest)
+			sc.recordSkipped(edge, SkipLost)
 			continue
 		}
 
@@ -513,16 +1463,25 @@ func (sc *SyncContext) GetPromotionCandidates(edges map[PromotionEdge]interface{
 				// a different tag, then it's an error.
 				if dp.PqinDigestMatch {
 					// NOP (already promoted).
-					logrus.Infof("edge %v: skipping because it was already promoted (case 2)\n", edge)
+					logSkipped("edge %v: skipping because it was already promoted (case 2)\n", edge)
+					sc.recordSkipped(edge, SkipAlreadyPromoted)
 					continue
-				} else {
+				} else if !sc.forceOverwrite(edge, dp.BadDigest) {
 					logrus.Errorf("edge %v: tag %s: ERROR: tag move detected from %s to %s", edge, edge.DstImageTag.Tag, edge.Digest, *sc.getDigestForTag(edge.DstImageTag.Tag))
 					clean = false
+					sc.recordSkipped(edge, SkipTagMove)
 					// We continue instead of returning early, because we want
 					// to see and log as many errors as possible as we go
 					// through each promotion edge.
 					continue
 				}
+
+				// Forced, so the tag is moved with a retag (as the digest is
+				// already in the destination).
+				edge.OldDigest = dp.BadDigest
+			} else if sc.forceOverwrite(edge, dp.BadDigest) {
+				// Forced, so the image is copied over the tag.
+				edge.OldDigest = dp.BadDigest
 			} else {
 				// Pqin points to the wrong digest.
 				logrus.Warnf("edge %v: tag %s points to the wrong digest; moving\n", edge, dp.BadDigest)
@@ -536,12 +1495,186 @@ func (sc *SyncContext) GetPromotionCandidates(edges map[PromotionEdge]interface{
 				// Neither the digest nor the pqin exists in dst.
 				logrus.Infof("edge %v: regular promotion (neither digest nor pqin exists in dst)\n", edge)
 			}
-		}
+		}
+
+		// Nothing may be written to a read-only registry, so an edge that
+		// still needs promoting there is a misconfiguration.
+		if edge.DstRegistry.ReadOnly {
+			logrus.Errorf("edge %v: ERROR: destination registry %s is read-only", edge, edge.DstRegistry.Name)
+			clean = false
+			sc.recordSkipped(edge, SkipReadOnly)
+			continue
+		}
+
+		if !sc.destPathAllowed(edge) {
+			logrus.Errorf("edge %v: ERROR: destination image %s/%s does not match the destination path policy %q", edge, edge.DstRegistry.Name, edge.DstImageTag.ImageName, sc.DestPathPolicy)
+			clean = false
+			sc.recordSkipped(edge, SkipDestPathPolicy)
+			continue
+		}
+
+		inWindow, err := sc.inPromotionWindow(edge)
+		if err != nil {
+			logrus.Errorf("edge %v: ERROR: %v", edge, err)
+			clean = false
+			sc.recordSkipped(edge, SkipInvalidWindow)
+			continue
+		}
+		if !inWindow {
+			if sc.FailOutsideWindow {
+				logrus.Errorf("edge %v: ERROR: outside of the promotion window %q of image %s", edge, edge.Window, edge.SrcImageTag.ImageName)
+				clean = false
+			} else {
+				logrus.Warnf("edge %v: skipping because it is outside of the promotion window %q of image %s", edge, edge.Window, edge.SrcImageTag.ImageName)
+			}
+			sc.recordSkipped(edge, SkipOutsideWindow)
+			continue
+		}
+
+		toPromote[edge] = nil
+	}
+
+	if sc.SkipExistingQuietly && skipped > 0 {
+		logrus.Infof("skipped %d edges because they were already promoted", skipped)
+	}
+
+	if !sc.enforceMaxTags(toPromote) {
+		clean = false
+	}
+
+	sc.Logs.Skipped.sort()
+
+	return toPromote, clean
+}
+
+// MkDestPathPolicy compiles the regular expression of a destination path
+// policy (see SyncContext.DestPathPolicy). The expression is anchored at both
+// ends, so that it must match the whole name of the destination image.
+func MkDestPathPolicy(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, errors.New("destination path policy cannot be empty")
+	}
+
+	// Report errors in terms of the expression as given.
+	if _, err := regexp.Compile(expr); err != nil {
+		return nil, err
+	}
+
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
+// destPathAllowed returns true if the destination image of edge matches the
+// DestPathPolicy (or if there is none).
+func (sc *SyncContext) destPathAllowed(edge PromotionEdge) bool {
+	if sc.DestPathPolicy == nil {
+		return true
+	}
+
+	return sc.DestPathPolicy.MatchString(
+		string(edge.DstRegistry.Name) + "/" + string(edge.DstImageTag.ImageName))
+}
+
+// forceOverwrite returns true if the destination tag of edge, which currently
+// points at oldDigest, may be moved to the digest of edge (see
+// SyncContext.ForceOverwrite). Every forced overwrite is logged as a warning.
+func (sc *SyncContext) forceOverwrite(edge PromotionEdge, oldDigest Digest) bool {
+	if edge.DstImageTag.Tag == "" {
+		return false
+	}
+
+	imageTag := fmt.Sprintf(
+		"%s:%s",
+		edge.DstImageTag.ImageName,
+		edge.DstImageTag.Tag)
+	if !sc.ForceOverwrite[imageTag] &&
+		!sc.ForceOverwrite[string(edge.DstRegistry.Name)+"/"+imageTag] {
+		return false
+	}
+
+	edgeLogger(&edge).Warnf(
+		"edge %v: FORCED OVERWRITE: moving tag %s/%s from %s to %s, as allowed by the force-overwrite list",
+		edge,
+		edge.DstRegistry.Name,
+		imageTag,
+		oldDigest,
+		edge.Digest)
+
+	return true
+}
+
+// enforceMaxTags removes those edges from toPromote that would push the number
+// of tags of their destination image over the image's MaxTags. It returns false
+// if any edge had to be removed.
+func (sc *SyncContext) enforceMaxTags(
+	toPromote map[PromotionEdge]interface{},
+) bool {
+	type dstImage struct {
+		registryName RegistryName
+		imageName    ImageName
+	}
+
+	// Collect the tags each capped destination image would receive.
+	incomingTags := make(map[dstImage]map[Tag]interface{})
+	maxTags := make(map[dstImage]int)
+	for edge := range toPromote {
+		if edge.MaxTags <= 0 || edge.DstImageTag.Tag == "" {
+			continue
+		}
+
+		key := dstImage{edge.DstRegistry.Name, edge.DstImageTag.ImageName}
+		if incomingTags[key] == nil {
+			incomingTags[key] = make(map[Tag]interface{})
+		}
+		incomingTags[key][edge.DstImageTag.Tag] = nil
+		maxTags[key] = edge.MaxTags
+	}
+
+	clean := true
+	for key, tags := range incomingTags {
+		currentTags := make(map[Tag]interface{})
+		for _, tagSlice := range sc.Inv[key.registryName][key.imageName] {
+			for _, tag := range tagSlice {
+				currentTags[tag] = nil
+			}
+		}
+
+		// Tags that already exist (e.g., tag moves) do not add to the count.
+		newTags := make(map[Tag]interface{})
+		for tag := range tags {
+			if _, ok := currentTags[tag]; !ok {
+				newTags[tag] = nil
+			}
+		}
+
+		wouldBe := len(currentTags) + len(newTags)
+		if wouldBe <= maxTags[key] {
+			continue
+		}
+
+		clean = false
+		for edge := range toPromote {
+			if edge.DstRegistry.Name != key.registryName ||
+				edge.DstImageTag.ImageName != key.imageName {
+				continue
+			}
+			if _, ok := newTags[edge.DstImageTag.Tag]; !ok {
+				continue
+			}
 
-		toPromote[edge] = nil
+			logrus.Errorf(
+				"edge %v: ERROR: image %s/%s would have %d tags (currently %d), exceeding maxTags of %d",
+				edge,
+				key.registryName,
+				key.imageName,
+				wouldBe,
+				len(currentTags),
+				maxTags[key])
+			delete(toPromote, edge)
+			sc.recordSkipped(edge, SkipMaxTags)
+		}
 	}
 
-	return toPromote, clean
+	return clean
 }
 
 func (sc *SyncContext) getDigestForTag(inputTag Tag) *Digest {
@@ -714,6 +1847,78 @@ func ParseManifestYAML(b []byte) (Manifest, error) {
 	return m, m.Validate()
 }
 
+// ParseManifestJSON is like ParseManifestYAML, but parses a Manifest written in
+// JSON (as chosen by ParseManifestFromFile() for ".json" files). The JSON is
+// decoded strictly: unknown fields and trailing data are rejected, and errors
+// name the line and column they were found at.
+func ParseManifestJSON(b []byte) (Manifest, error) {
+	var m Manifest
+
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&m); err != nil {
+		return Manifest{}, mkJSONError(b, decoder.InputOffset(), err)
+	}
+
+	// Only whitespace may follow the manifest.
+	offset := decoder.InputOffset()
+	rest := bytes.TrimLeft(b[offset:], " \t\r\n")
+	if len(rest) > 0 {
+		return Manifest{}, mkJSONError(
+			b,
+			int64(len(b)-len(rest))+1,
+			errors.New("unexpected data after the manifest"))
+	}
+
+	return m, m.Validate()
+}
+
+// mkJSONError rewrites an error of the JSON decoder to name the line and column
+// of the JSON document b that it was found at. The offset (the number of bytes
+// read before the error) is taken from the error if it has one.
+func mkJSONError(b []byte, offset int64, err error) error {
+	msg := strings.TrimPrefix(err.Error(), "json: ")
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+		msg = fmt.Sprintf(
+			"field %q: cannot use a JSON %s as a %s",
+			typeErr.Field,
+			typeErr.Value,
+			typeErr.Type.Kind())
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		offset = int64(len(b))
+		msg = "unexpected end of JSON"
+	case strings.HasPrefix(msg, "unknown field "):
+		// The decoder only reports unknown fields after reading the whole
+		// document, so point at the (first) key with that name instead.
+		key := regexp.MustCompile(
+			regexp.QuoteMeta(strings.TrimPrefix(msg, "unknown field ")) +
+				`\s*:`)
+		if loc := key.FindIndex(b); loc != nil {
+			offset = int64(loc[0]) + 1
+		}
+	}
+
+	// The error is at the last byte that was read.
+	pos := int(offset) - 1
+	if pos < 0 {
+		pos = 0
+	}
+	if pos > len(b) {
+		pos = len(b)
+	}
+	line := 1 + bytes.Count(b[:pos], []byte("\n"))
+	column := pos - bytes.LastIndexByte(b[:pos], '\n')
+
+	return fmt.Errorf("json: line %d, column %d: %s", line, column, msg)
+}
+
 // ParseThinManifestYAML parses a ThinManifest from a byteslice.
 func ParseThinManifestYAML(b []byte) (ThinManifest, error) {
 	var m ThinManifest
@@ -721,7 +1926,54 @@ func ParseThinManifestYAML(b []byte) (ThinManifest, error) {
 		return m, err
 	}
 
-	return m, nil
+	return m, ValidateGroupThreads(m.GroupThreads)
+}
+
+// ParseThinManifestDefaultsYAML parses ThinManifestDefaults from a byteslice.
+func ParseThinManifestDefaultsYAML(b []byte) (ThinManifestDefaults, error) {
+	var d ThinManifestDefaults
+	if err := yaml.UnmarshalStrict(b, &d); err != nil {
+		return d, err
+	}
+
+	return d, d.Validate()
+}
+
+// Validate checks that the default registries are well-formed on their own.
+func (d ThinManifestDefaults) Validate() error {
+	errs := make([]string, 0)
+	seen := make(map[RegistryName]interface{})
+	srcCount := 0
+
+	for _, registry := range d.Registries {
+		if len(registry.Name) == 0 {
+			errs = append(
+				errs,
+				"defaults: registries: 'name' field cannot be empty")
+			continue
+		}
+
+		if _, ok := seen[registry.Name]; ok {
+			errs = append(
+				errs,
+				fmt.Sprintf("defaults: duplicate registry %q", registry.Name))
+		}
+		seen[registry.Name] = nil
+
+		if registry.Src {
+			srcCount++
+		}
+	}
+
+	if srcCount > 1 {
+		errs = append(errs, "defaults: cannot have more than 1 source registry")
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf(strings.Join(errs, "\n"))
 }
 
 // ParseImagesYAML parses Images from a byteslice.
@@ -737,14 +1989,75 @@ func ParseImagesYAML(b []byte) (Images, error) {
 // Validate checks for semantic errors in the yaml fields (the structure of the
 // yaml is checked during unmarshaling).
 func (m Manifest) Validate() error {
+	if m.StrictDigests {
+		if err := ValidateStrictDigests(m.Images); err != nil {
+			return err
+		}
+	}
 	if err := validateRequiredComponents(m); err != nil {
 		return err
 	}
+	if err := ValidateGroupThreads(m.GroupThreads); err != nil {
+		return err
+	}
 	return validateImages(m.Images)
 }
 
+// ValidateStrictDigests checks that every image entry pins a digest. Entries
+// that only name tags (with an empty or otherwise invalid digest in their
+// dmap, or with no dmap at all) are all listed in the error, as
+// "<image>:<tag>" (or just "<image>" if there are no tags).
+func ValidateStrictDigests(images []Image) error {
+	offending := make([]string, 0)
+	for _, image := range images {
+		if len(image.Dmap) == 0 {
+			offending = append(offending, string(image.ImageName))
+			continue
+		}
+
+		for digest, tagSlice := range image.Dmap {
+			if ValidateDigest(digest) == nil {
+				continue
+			}
+
+			if len(tagSlice) == 0 {
+				offending = append(offending, string(image.ImageName))
+			}
+			for _, tag := range tagSlice {
+				offending = append(
+					offending,
+					fmt.Sprintf("%s:%s", image.ImageName, tag))
+			}
+		}
+	}
+
+	if len(offending) == 0 {
+		return nil
+	}
+
+	sort.Strings(offending)
+	return fmt.Errorf(
+		"strict digests: %d image entr(ies) without a digest:\n  %s",
+		len(offending),
+		strings.Join(offending, "\n  "),
+	)
+}
+
 func validateImages(images []Image) error {
 	for _, image := range images {
+		if image.MaxTags < 0 {
+			return fmt.Errorf(
+				"image %s: maxTags must not be negative: %d",
+				image.ImageName,
+				image.MaxTags)
+		}
+
+		if image.Window != "" {
+			if _, err := ParsePromotionWindow(image.Window); err != nil {
+				return fmt.Errorf("image %s: %v", image.ImageName, err)
+			}
+		}
+
 		for digest, tagSlice := range image.Dmap {
 			if err := ValidateDigest(digest); err != nil {
 				return err
@@ -780,6 +2093,20 @@ func ValidateTag(tag Tag) error {
 	return nil
 }
 
+// ValidateDestNamespace validates a namespace for destination images (see
+// ToDestNamespace()). Like the path of a repository, it is made of one or more
+// components separated by "/", each of which consists of lowercase letters
+// and digits, optionally separated by ".", "_", "__" or dashes.
+func ValidateDestNamespace(namespace string) error {
+	validNamespace := regexp.MustCompile(
+		`^[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*(/[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*)*$`)
+	if !validNamespace.MatchString(namespace) {
+		return fmt.Errorf("invalid destination namespace: %q", namespace)
+	}
+
+	return nil
+}
+
 // ValidateRegistryImagePath validates the RegistryImagePath.
 func ValidateRegistryImagePath(rip RegistryImagePath) error {
 	// \w is [0-9a-zA-Z_]
@@ -803,6 +2130,18 @@ func (m Manifest) srcRegistryCount() int {
 	return count
 }
 
+// QuarantineRegistry returns the quarantine registry of the manifest, or nil if
+// it has none.
+func (m Manifest) QuarantineRegistry() *RegistryContext {
+	for i := range m.Registries {
+		if m.Registries[i].Quarantine {
+			return &m.Registries[i]
+		}
+	}
+
+	return nil
+}
+
 func (m Manifest) srcRegistryName() RegistryName {
 	for _, registry := range m.Registries {
 		if registry.Src {
@@ -834,6 +2173,7 @@ func validateRequiredComponents(m Manifest) error {
 		errs = append(errs, fmt.Sprintf("'registries' field cannot be empty"))
 	}
 
+	quarantineCount := 0
 	for _, registry := range m.Registries {
 		if len(registry.Name) == 0 {
 			errs = append(
@@ -841,6 +2181,18 @@ func validateRequiredComponents(m Manifest) error {
 				fmt.Sprintf("registries: 'name' field cannot be empty"))
 		}
 		knownRegistries = append(knownRegistries, registry.Name)
+
+		if registry.Quarantine {
+			quarantineCount++
+			if registry.Src {
+				errs = append(
+					errs,
+					fmt.Sprintf("registries: %s cannot be both the source and the quarantine registry", registry.Name))
+			}
+		}
+	}
+	if quarantineCount > 1 {
+		errs = append(errs, fmt.Sprintf("cannot have more than 1 quarantine registry"))
 	}
 
 	for _, image := range m.Images {
@@ -966,7 +2318,9 @@ func (riid *RegInvImageDigest) PrettyValue() string {
 }
 
 func getRegistryTagsWrapper(
+	ctx context.Context,
 	req stream.ExternalRequest,
+	retryPolicy stream.RetryPolicy,
 ) (*ggcrV1Google.Tags, error) {
 	var googleTags *ggcrV1Google.Tags
 
@@ -978,12 +2332,13 @@ func getRegistryTagsWrapper(
 		return retryErr
 	}
 
-	b := stream.BackoffDefault()
+	// Stop retrying once the context is done.
+	b := backoff.WithContext(stream.BackoffDefault(), ctx)
 	notify := func(err error, t time.Duration) {
 		logrus.Errorf("error: %v happened at time: %v", err, t)
 	}
 
-	err := backoff.RetryNotify(
+	err := retryPolicy.Retry(
 		retryFn,
 		b,
 		notify,
@@ -1021,6 +2376,7 @@ func getRegistryTagsFrom(req stream.ExternalRequest,
 
 func getGCRManifestListWrapper(
 	req stream.ExternalRequest,
+	retryPolicy stream.RetryPolicy,
 ) (*ggcrV1.IndexManifest, error) {
 	var gcrManifestList *ggcrV1.IndexManifest
 
@@ -1037,7 +2393,7 @@ func getGCRManifestListWrapper(
 		logrus.Errorf("error: %v happened at time: %v", err, t)
 	}
 
-	err := backoff.RetryNotify(
+	err := retryPolicy.Retry(
 		retryFn,
 		b,
 		notify,
@@ -1208,6 +2564,38 @@ func (sc *SyncContext) PopulateTokens() error {
 	return nil
 }
 
+// registryKeychain is an authn.Keychain that authenticates each repository
+// with the service account token of the registry it belongs to (see
+// PopulateTokens()). This way, the source and destination of a copy can use
+// different service accounts.
+type registryKeychain struct {
+	sc *SyncContext
+}
+
+// Resolve implements authn.Keychain. Repositories of registries without a token
+// fall back to the default keychain.
+func (k *registryKeychain) Resolve(
+	target authn.Resource,
+) (authn.Authenticator, error) {
+	tokenKey, _, _ := GetTokenKeyDomainRepoPath(RegistryName(target.String()))
+	token, ok := k.sc.token(RootRepo(tokenKey))
+	if !ok {
+		return authn.DefaultKeychain.Resolve(target)
+	}
+
+	// This is how GCR expects OAuth2 access tokens to be presented.
+	return &authn.Basic{
+		Username: "oauth2accesstoken",
+		Password: string(token),
+	}, nil
+}
+
+// Keychain returns an authn.Keychain that uses the service account of each
+// registry (as given in the manifests) for the repositories under it.
+func (sc *SyncContext) Keychain() authn.Keychain {
+	return &registryKeychain{sc: sc}
+}
+
 // GetTokenKeyDomainRepoPath splits a string by '/'. It's OK to do this because
 // the RegistryName is already parsed against a Regex. (Maybe we should store
 // the repo path separately when we do the initial parse...).
@@ -1259,6 +2647,10 @@ func (sc *SyncContext) ReadRegistries(
 	recurse bool,
 	mkProducer func(*SyncContext, RegistryContext) stream.Producer,
 ) {
+	if sc.DigestUploadTime == nil {
+		sc.DigestUploadTime = make(DigestUploadTime)
+	}
+
 	// Collect all images in sc.Inv (the src and dest registry names found in
 	// the manifest).
 	var populateRequests PopulateRequests = func(
@@ -1284,12 +2676,82 @@ func (sc *SyncContext) ReadRegistries(
 		wg *sync.WaitGroup,
 		mutex *sync.Mutex) {
 
+		// enqueueChildren descends into the child repos of the given parent.
+		enqueueChildren := func(parentRC RegistryContext, children []string) {
+			for _, childRepoName := range children {
+				childRc := RegistryContext{
+					Name: RegistryName(
+						string(parentRC.Name) + "/" + childRepoName),
+					// Inherit the service account used at the parent
+					// (cascades down from toplevel to all subrepos). In the
+					// future if the token exchange fails, we can refresh the
+					// token here instead of using the one we inherit below.
+					ServiceAccount: parentRC.ServiceAccount,
+					// Inherit the token as well.
+					Token: parentRC.Token,
+					// Inherit src as well, so that child repos of a source
+					// registry are read through its mirror (if any).
+					Src: parentRC.Src,
+				}
+
+				var childReq stream.ExternalRequest
+				childReq.RequestParams = childRc
+				childReq.StreamProducer = mkProducer(sc, childRc)
+
+				// Every time we "descend" into child nodes, increment the
+				// semaphore.
+				wg.Add(1)
+				reqs <- childReq
+			}
+		}
+
 		for req := range reqs {
+			if reqRes, cancelled := sc.cancelledResult(req); cancelled {
+				requestResults <- reqRes
+				continue
+			}
+
 			reqRes := RequestResult{Context: req}
 
+			// Skip repos that were already read, as recorded by a previous
+			// (interrupted) run. Their inventory is already in sc.Inv, but we
+			// still have to descend into their children.
+			if sc.ReadCheckpoint != nil {
+				rc := req.RequestParams.(RegistryContext)
+				mutex.Lock()
+				children, done := sc.ReadCheckpoint.Repos[rc.Name]
+				mutex.Unlock()
+				if done {
+					if recurse {
+						enqueueChildren(rc, children)
+					}
+					reqRes.Errors = Errors{}
+					requestResults <- reqRes
+					continue
+				}
+			}
+
 			// Now run the request (make network HTTP call with
-			// ExponentialBackoff()).
-			tagsStruct, err := getRegistryTagsWrapper(req)
+			// ExponentialBackoff()). If the token of the registry is
+			// rejected, retry once with a new token (and so, a new request).
+			rc := req.RequestParams.(RegistryContext)
+			var tagsStruct *ggcrV1Google.Tags
+			attempted := false
+			err := sc.withTokenRefresh(
+				[]RegistryContext{rc},
+				func() error {
+					if attempted {
+						req.StreamProducer = mkProducer(sc, rc)
+					}
+					attempted = true
+
+					var err error
+					tagsStruct, err = getRegistryTagsWrapper(
+						sc.ctx(),
+						req,
+						sc.RetryPolicy)
+					return err
+				})
 			if err != nil {
 				// Skip this request if it has unrecoverable errors (even after
 				// ExponentialBackoff).
@@ -1332,6 +2794,9 @@ func (sc *SyncContext) ReadRegistries(
 
 				// Store ImageSize
 				sc.DigestImageSize[Digest(digest)] = int(mfestInfo.Size)
+
+				// Store the upload time (see FilterLatestTags()).
+				sc.DigestUploadTime[Digest(digest)] = mfestInfo.Uploaded
 				mutex.Unlock()
 			}
 
@@ -1359,35 +2824,22 @@ func (sc *SyncContext) ReadRegistries(
 				mutex.Unlock()
 			}
 
+			// Record the progress, so that this repo is skipped if we have
+			// to resume after an interruption.
+			if sc.ReadCheckpoint != nil {
+				mutex.Lock()
+				err := sc.recordReadCheckpoint(rName, tagsStruct.Children)
+				mutex.Unlock()
+				if err != nil {
+					logrus.Errorf("could not save read checkpoint %q: %v",
+						sc.ReadCheckpoint.Path, err)
+				}
+			}
+
 			// Process child repos.
 			if recurse {
-				for _, childRepoName := range tagsStruct.Children {
-					parentRC, _ := req.RequestParams.(RegistryContext)
-
-					childRc := RegistryContext{
-						Name: RegistryName(
-							string(parentRC.Name) + "/" + childRepoName),
-						// Inherit the service account used at the parent
-						// (cascades down from toplevel to all subrepos). In the
-						// future if the token exchange fails, we can refresh
-						// the token here instead of using the one we inherit
-						// below.
-						ServiceAccount: parentRC.ServiceAccount,
-						// Inherit the token as well.
-						Token: parentRC.Token,
-						// Don't need src, because we are just reading data
-						// (don't care if it's the source reg or not).
-					}
-
-					var childReq stream.ExternalRequest
-					childReq.RequestParams = childRc
-					childReq.StreamProducer = mkProducer(sc, childRc)
-
-					// Every time we "descend" into child nodes, increment the
-					// semaphore.
-					wg.Add(1)
-					reqs <- childReq
-				}
+				parentRC, _ := req.RequestParams.(RegistryContext)
+				enqueueChildren(parentRC, tagsStruct.Children)
 			}
 			// When we're done processing this node (req), decrement the
 			// semaphore.
@@ -1403,11 +2855,19 @@ func (sc *SyncContext) ReadRegistries(
 // map[ChildDigest]ParentDigest; and so, if a digest has an entry in this map,
 // it is referenced by a parent DockerManifestList.
 //
+// The manifest lists are read by up to sc.Threads workers at the same time
+// (like the repositories in ReadRegistries()). Manifest lists that could not
+// be read are all reported in the returned error, after the others were read.
+//
 // TODO: Combine this function with ReadRegistries().
 //
 // nolint[gocyclo]
 func (sc *SyncContext) ReadGCRManifestLists(
-	mkProducer func(*SyncContext, *GCRManifestListContext) stream.Producer) {
+	mkProducer func(*SyncContext, *GCRManifestListContext) stream.Producer,
+) error {
+	// failures holds the manifest lists that could not be read, guarded by
+	// the mutex of the workers.
+	failures := make([]string, 0)
 
 	// Collect all images in sc.Inv (the src and dest registry names found in
 	// the manifest).
@@ -1427,7 +2887,7 @@ func (sc *SyncContext) ReadGCRManifestLists(
 			}
 			for imageName, digestTags := range rii {
 				for digest, tagSlice := range digestTags {
-					if sc.DigestMediaType[digest] == ggcrV1Types.DockerManifestList {
+					if sc.isManifestList(digest) {
 						// Create the request.
 						var req stream.ExternalRequest
 						var tag Tag
@@ -1460,10 +2920,11 @@ func (sc *SyncContext) ReadGCRManifestLists(
 	) {
 		for req := range reqs {
 			reqRes := RequestResult{Context: req}
+			gmlc := req.RequestParams.(GCRManifestListContext)
 
 			// Now run the request (make network HTTP call with
 			// ExponentialBackoff()).
-			gcrManifestList, err := getGCRManifestListWrapper(req)
+			gcrManifestList, err := getGCRManifestListWrapper(req, sc.RetryPolicy)
 			if err != nil {
 				// Skip this request if it has unrecoverable errors (even after
 				// ExponentialBackoff).
@@ -1473,12 +2934,20 @@ func (sc *SyncContext) ReadGCRManifestLists(
 						Error:   err,
 					},
 				}
+
+				mutex.Lock()
+				failures = append(failures, fmt.Sprintf(
+					"%s/%s@%s: %v",
+					gmlc.RegistryContext.Name,
+					gmlc.ImageName,
+					gmlc.Digest,
+					err))
+				mutex.Unlock()
+
 				requestResults <- reqRes
 				continue
 			}
 
-			gmlc := req.RequestParams.(GCRManifestListContext)
-
 			for _, gManifest := range gcrManifestList.Manifests {
 				mutex.Lock()
 				sc.ParentDigest[(Digest)((gManifest.Digest.Algorithm)+":"+(gManifest.Digest.Hex))] = gmlc.Digest
@@ -1490,7 +2959,19 @@ func (sc *SyncContext) ReadGCRManifestLists(
 		}
 	}
 
+	// The failures are reported below, with more detail.
+	// nolint[errcheck]
 	sc.ExecRequests(populateRequests, processRequest)
+
+	if len(failures) == 0 {
+		return nil
+	}
+
+	sort.Strings(failures)
+	return fmt.Errorf(
+		"could not read %d manifest list(s):\n  %s",
+		len(failures),
+		strings.Join(failures, "\n  "))
 }
 
 // FilterByTag removes all images in RegInvImage that do not match the
@@ -1543,6 +3024,38 @@ func (sc *SyncContext) RemoveChildDigestEntries(rii RegInvImage) RegInvImage {
 	return filtered
 }
 
+// isManifestList returns true if the digest is a Docker manifest list,
+// according to sc.DigestMediaType.
+func (sc *SyncContext) isManifestList(digest Digest) bool {
+	return sc.DigestMediaType[digest] == ggcrV1Types.DockerManifestList
+}
+
+// FilterManifestLists keeps only the manifest lists in rii (if lists is true),
+// or only the other images (if lists is false). Manifest lists are told apart
+// by their media type, as ReadGCRManifestLists() does, so the registries of rii
+// must have been read into sc first. Images left without digests are dropped.
+func (sc *SyncContext) FilterManifestLists(
+	rii RegInvImage,
+	lists bool,
+) RegInvImage {
+	filtered := make(RegInvImage)
+	for imageName, digestTags := range rii {
+		for digest, tagSlice := range digestTags {
+			if sc.isManifestList(digest) != lists {
+				continue
+			}
+
+			if filtered[imageName] == nil {
+				filtered[imageName] = make(DigestTags)
+			}
+
+			filtered[imageName][digest] = tagSlice
+		}
+	}
+
+	return filtered
+}
+
 // SplitByKnownRegistries splits a registry name into a RegistryName and
 // ImageName. The purpose of this function is to split a long image path into 2
 // pieces --- the repository and the image name. We can't just split by the last
@@ -1585,6 +3098,19 @@ func SplitByKnownRegistries(
 	return "", "", fmt.Errorf("unknown registry %q", r)
 }
 
+// mkReadRepositoryCmd returns sc.MkReadRepositoryCmd, or
+// MkReadRepositoryCmdReal() if it is not set.
+func (sc *SyncContext) mkReadRepositoryCmd() func(
+	*SyncContext,
+	RegistryContext,
+) stream.Producer {
+	if sc.MkReadRepositoryCmd == nil {
+		return MkReadRepositoryCmdReal
+	}
+
+	return sc.MkReadRepositoryCmd
+}
+
 // MkReadRepositoryCmdReal creates a stream.Producer which makes a real call
 // over the network.
 func MkReadRepositoryCmdReal(
@@ -1594,8 +3120,12 @@ func MkReadRepositoryCmdReal(
 	var sh stream.HTTP
 
 	tokenKey, domain, repoPath := GetTokenKeyDomainRepoPath(rc.Name)
+	if rc.Src {
+		domain = sc.mirrorHost(domain)
+	}
 
-	httpReq, err := http.NewRequest(
+	httpReq, err := http.NewRequestWithContext(
+		sc.ctx(),
 		"GET",
 		fmt.Sprintf("https://%s/v2/%s/tags/list", domain, repoPath),
 		nil,
@@ -1608,7 +3138,7 @@ func MkReadRepositoryCmdReal(
 	}
 
 	if sc.UseServiceAccount {
-		token, ok := sc.Tokens[RootRepo(tokenKey)]
+		token, ok := sc.token(RootRepo(tokenKey))
 		if !ok {
 			logrus.Fatalf("access token for key '%s' not found\n", tokenKey)
 		}
@@ -1618,8 +3148,11 @@ func MkReadRepositoryCmdReal(
 
 		httpReq.Header.Add("Authorization", bearer)
 	}
+	httpReq.Header.Set("User-Agent", sc.userAgent())
 
 	sh.Req = httpReq
+	sh.Proxy = sc.Proxy
+	sh.TLSConfig = sc.tlsConfig(domain)
 	return &sh
 }
 
@@ -1632,6 +3165,9 @@ func MkReadManifestListCmdReal(sc *SyncContext, gmlc *GCRManifestListContext) st
 	var sh stream.HTTP
 
 	tokenKey, domain, repoPath := GetTokenKeyDomainRepoPath(gmlc.RegistryContext.Name)
+	if gmlc.RegistryContext.Src {
+		domain = sc.mirrorHost(domain)
+	}
 
 	endpoint := fmt.Sprintf(
 		"https://%s/v2/%s/%s/manifests/%s",
@@ -1660,7 +3196,7 @@ func MkReadManifestListCmdReal(sc *SyncContext, gmlc *GCRManifestListContext) st
 	}
 
 	if sc.UseServiceAccount {
-		token, ok := sc.Tokens[RootRepo(tokenKey)]
+		token, ok := sc.token(RootRepo(tokenKey))
 		if !ok {
 			logrus.Fatalf("access token for key '%s' not found\n", tokenKey)
 		}
@@ -1668,25 +3204,33 @@ func MkReadManifestListCmdReal(sc *SyncContext, gmlc *GCRManifestListContext) st
 		bearer := "Bearer " + string(token)
 		httpReq.Header.Add("Authorization", bearer)
 	}
+	httpReq.Header.Set("User-Agent", sc.userAgent())
 
 	sh.Req = httpReq
+	sh.Proxy = sc.Proxy
+	sh.TLSConfig = sc.tlsConfig(domain)
 	return &sh
 }
 
 // ExecRequests uses the Worker Pool pattern, where MaxConcurrentRequests
 // determines the number of workers to spawn.
-//
-// nolint[funlen]
 func (sc *SyncContext) ExecRequests(
 	populateRequests PopulateRequests,
 	processRequest ProcessRequest,
 ) error {
-	// Run requests.
-	MaxConcurrentRequests := 10
+	_, err := sc.execRequests(populateRequests, processRequest)
+	return err
+}
 
-	if sc.Threads > 0 {
-		MaxConcurrentRequests = sc.Threads
-	}
+// execRequests is ExecRequests(), but also returns how the requests went.
+//
+// nolint[funlen]
+func (sc *SyncContext) execRequests(
+	populateRequests PopulateRequests,
+	processRequest ProcessRequest,
+) (RequestSummary, error) {
+	// Run requests.
+	MaxConcurrentRequests := sc.maxConcurrentRequests()
 
 	mutex := &sync.Mutex{}
 	reqs := make(chan stream.ExternalRequest, MaxConcurrentRequests)
@@ -1698,22 +3242,41 @@ func (sc *SyncContext) ExecRequests(
 
 	var err error
 
+	// Keep count of how the requests went, for a summary in case we get
+	// cancelled.
+	var succeeded, failed, cancelled int
+
 	// Log any errors encountered.
 	go func() {
 		for reqRes := range requestResults {
-			if len(reqRes.Errors) > 0 {
+			// nolint: gocritic
+			if isCancelled(reqRes) {
+				(*mutex).Lock()
+				cancelled++
+				sc.Logs.Errors = append(sc.Logs.Errors, reqRes.Errors...)
+				(*mutex).Unlock()
+
+				requestLogger(reqRes.Context.RequestParams).Debugf(
+					"Request %v: cancelled\n", reqRes.Context.RequestParams)
+			} else if len(reqRes.Errors) > 0 {
 				(*mutex).Lock()
+				failed++
 				err = fmt.Errorf("Encountered an error while executing requests")
 				sc.Logs.Errors = append(sc.Logs.Errors, reqRes.Errors...)
 				(*mutex).Unlock()
 
-				logrus.Errorf(
+				requestLogger(reqRes.Context.RequestParams).Errorf(
 					"Request %v: error(s) encountered: %v\n",
 					reqRes.Context,
 					reqRes.Errors,
 				)
 			} else {
-				logrus.Infof("Request %v: OK\n", reqRes.Context.RequestParams)
+				(*mutex).Lock()
+				succeeded++
+				(*mutex).Unlock()
+
+				requestLogger(reqRes.Context.RequestParams).Infof(
+					"Request %v: OK\n", reqRes.Context.RequestParams)
 			}
 
 			wg.Add(-1)
@@ -1746,7 +3309,158 @@ func (sc *SyncContext) ExecRequests(
 	// single point).
 	close(requestResults)
 
-	return err
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	summary := RequestSummary{
+		Succeeded: succeeded,
+		Failed:    failed,
+		Cancelled: cancelled,
+	}
+
+	if ctxErr := sc.ctx().Err(); ctxErr != nil {
+		logrus.Warnf(
+			"%v: %d request(s) completed (%d succeeded, %d failed), %d cancelled",
+			ctxErr,
+			succeeded+failed,
+			succeeded,
+			failed,
+			cancelled)
+
+		return summary, fmt.Errorf("executing requests: %w", ctxErr)
+	}
+
+	return summary, err
+}
+
+// mirrorHost returns the mirror configured for the given registry host, or the
+// host itself if there is none.
+func (sc *SyncContext) mirrorHost(host string) string {
+	if mirror, ok := sc.RegistryMirrors[host]; ok {
+		return mirror
+	}
+
+	return host
+}
+
+// MirroredRegistry returns the name under which the given source registry is
+// read, i.e., with its host replaced by the mirror configured for it in
+// RegistryMirrors (if any).
+func (sc *SyncContext) MirroredRegistry(name RegistryName) RegistryName {
+	parts := strings.SplitN(string(name), "/", 2)
+	mirror := sc.mirrorHost(parts[0])
+	if len(parts) == 1 {
+		return RegistryName(mirror)
+	}
+
+	return RegistryName(mirror + "/" + parts[1])
+}
+
+// craneOptions returns the options for the in-process (crane) writes of
+// images made with sc.
+func (sc *SyncContext) craneOptions() []crane.Option {
+	opts := []crane.Option{crane.WithUserAgent(sc.userAgent())}
+	if sc.UseServiceAccount {
+		opts = append(
+			opts,
+			crane.WithAuthFromKeychain(sc.Keychain()))
+	}
+
+	if transport := sc.transport(); transport != nil {
+		opts = append(opts, crane.WithTransport(transport))
+	}
+
+	return opts
+}
+
+// transport returns the transport for the in-process writes of images made
+// with sc, or nil if the default transport will do.
+func (sc *SyncContext) transport() http.RoundTripper {
+	var transport http.RoundTripper = http.DefaultTransport
+	if !sc.Proxy.IsZero() {
+		// http.DefaultTransport only knows the proxy of the environment.
+		proxied := http.DefaultTransport.(*http.Transport).Clone()
+		proxied.Proxy = sc.Proxy.ProxyFunc()
+		transport = proxied
+	}
+	if len(sc.RegistryTLS) > 0 {
+		transport = mkRegistryTLSTransport(sc.RegistryTLS, sc.Proxy, transport)
+	}
+	if sc.BandwidthLimiter != nil {
+		transport = sc.BandwidthLimiter.Transport(transport)
+	}
+	if sc.Context != nil {
+		transport = &contextTransport{
+			ctx:  sc.Context,
+			base: transport,
+		}
+	}
+	if transport == http.DefaultTransport {
+		return nil
+	}
+
+	return transport
+}
+
+// userAgent returns the User-Agent header value for registry requests made
+// with sc.
+func (sc *SyncContext) userAgent() string {
+	if sc.UserAgent != "" {
+		return sc.UserAgent
+	}
+
+	return version.Get().UserAgent()
+}
+
+// ctx returns the context of sc, or context.Background() if it has none.
+func (sc *SyncContext) ctx() context.Context {
+	if sc.Context == nil {
+		return context.Background()
+	}
+
+	return sc.Context
+}
+
+// cancelledResult returns a RequestResult that marks req as cancelled, if the
+// context of sc is done. Requests that have not been started yet are cancelled
+// this way; those already in flight are cancelled through the context itself.
+func (sc *SyncContext) cancelledResult(
+	req stream.ExternalRequest,
+) (RequestResult, bool) {
+	err := sc.ctx().Err()
+	if err == nil {
+		return RequestResult{}, false
+	}
+
+	return RequestResult{
+		Context: req,
+		Errors: Errors{
+			Error{
+				Context: requestCancelled,
+				Error:   err,
+			},
+		},
+	}, true
+}
+
+// requestCancelled is the Error.Context of requests that were cancelled.
+const requestCancelled = "request cancelled"
+
+func isCancelled(reqRes RequestResult) bool {
+	return len(reqRes.Errors) == 1 &&
+		reqRes.Errors[0].Context == requestCancelled
+}
+
+// contextTransport is an http.RoundTripper that makes all requests under a
+// context, so that they are cancelled with it.
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(req.WithContext(t.ctx))
 }
 
 func extractRegistryTags(reader io.Reader) (*ggcrV1Google.Tags, error) {
@@ -1919,6 +3633,278 @@ func (rii *RegInvImage) ToCSV() string {
 	return b.String()
 }
 
+// ToTable is like ToCSV, but instead of printing machine-readable lines, it
+// prints an aligned table meant for humans, with digests shortened.
+//
+// E.g.
+//
+// IMAGE  TAG     DIGEST
+// a      1.0     sha256:000000000000
+// a      latest  sha256:000000000000
+// b      -       sha256:111111111111
+func (rii *RegInvImage) ToTable() string {
+	images := rii.ToSorted()
+
+	var b strings.Builder
+	// nolint[gomnd]
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "IMAGE\tTAG\tDIGEST\n")
+	for _, image := range images {
+		for _, digestEntry := range image.digests {
+			hash := ShortDigest(Digest(digestEntry.hash))
+			if len(digestEntry.tags) > 0 {
+				for _, tag := range digestEntry.tags {
+					fmt.Fprintf(w, "%s\t%s\t%s\n", image.name, tag, hash)
+				}
+			} else {
+				fmt.Fprintf(w, "%s\t-\t%s\n", image.name, hash)
+			}
+		}
+	}
+
+	// Writing to a strings.Builder cannot fail.
+	// nolint[errcheck]
+	w.Flush()
+
+	return b.String()
+}
+
+// regInvTemplateFuncs are the functions that are available to the templates
+// parsed by ParseRegInvTemplate(), on top of the builtin ones.
+var regInvTemplateFuncs = template.FuncMap{
+	"shortDigest": func(digest Digest) string {
+		return ShortDigest(digest)
+	},
+	"sortedTags": func(tags TagSlice) []string {
+		sorted := make([]string, 0, len(tags))
+		for _, tag := range tags {
+			sorted = append(sorted, string(tag))
+		}
+		sort.Strings(sorted)
+
+		return sorted
+	},
+	"join": strings.Join,
+}
+
+// ParseRegInvTemplate parses a Go text/template that renders a RegInvImage
+// (see ToTemplate()). Besides the builtin functions, the template can use
+// shortDigest (e.g. "sha256:000000000000"), sortedTags (the tags of a digest,
+// as a sorted list of strings) and join (strings.Join()).
+func ParseRegInvTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).
+		Option("missingkey=error").
+		Funcs(regInvTemplateFuncs).
+		Parse(text)
+}
+
+// ToTemplate renders a RegInvImage with a template that was parsed with
+// ParseRegInvTemplate(). The template is executed against the RegInvImage
+// itself, so it can range over its images and their digests (which
+// text/template visits in sorted order), and call its Stats method.
+//
+// E.g.
+//
+// {{ .Stats.Images }} images
+// {{ range $image, $dmap := . }}{{ $image }}: {{ len $dmap }} digests
+// {{ end }}
+func (rii RegInvImage) ToTemplate(tmpl *template.Template) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, rii); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// Stats counts the images, digests and tags of a RegInvImage. A digest that is
+// shared by several images is counted once per image.
+func (rii RegInvImage) Stats() RegInvStats {
+	stats := RegInvStats{Images: len(rii)}
+	for _, digestTags := range rii {
+		stats.Digests += len(digestTags)
+		for _, tags := range digestTags {
+			stats.Tags += len(tags)
+			if len(tags) == 0 {
+				stats.TaglessDigests++
+			}
+		}
+	}
+
+	return stats
+}
+
+// Validate checks a RegInvImage for internal inconsistencies: empty image
+// names, empty digests, empty tags, and tags that point at more than one digest
+// within the same image. All problems found are reported together in a single
+// error, sorted for stable output; nil is returned if there are none.
+func (rii RegInvImage) Validate() error {
+	problems := make([]string, 0)
+	for imageName, digestTags := range rii {
+		if imageName == "" {
+			problems = append(problems, "image with an empty name")
+		}
+
+		tagDigests := make(map[Tag][]string)
+		for digest, tags := range digestTags {
+			if digest == "" {
+				problems = append(
+					problems,
+					fmt.Sprintf("image %q has an empty digest", imageName),
+				)
+			}
+
+			for _, tag := range tags {
+				if tag == "" {
+					problems = append(
+						problems,
+						fmt.Sprintf(
+							"image %q has an empty tag for digest %q",
+							imageName,
+							digest,
+						),
+					)
+					continue
+				}
+
+				tagDigests[tag] = append(tagDigests[tag], string(digest))
+			}
+		}
+
+		for tag, digests := range tagDigests {
+			if len(digests) < 2 {
+				continue
+			}
+
+			sort.Strings(digests)
+			problems = append(
+				problems,
+				fmt.Sprintf(
+					"image %q has tag %q pointing at multiple digests: %s",
+					imageName,
+					tag,
+					strings.Join(digests, ", "),
+				),
+			)
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	sort.Strings(problems)
+	return fmt.Errorf(
+		"invalid snapshot: %d problem(s) found:\n  %s",
+		len(problems),
+		strings.Join(problems, "\n  "),
+	)
+}
+
+// DiffSnapshots compares two snapshots by their YAML form (as printed by
+// ToYAML). It returns an empty string if they are equal. Otherwise, it returns
+// a line diff, where lines that are only in the expected snapshot are prefixed
+// with "-", and lines that are only in the actual one with "+".
+func DiffSnapshots(expected, got RegInvImage) string {
+	expectedYAML := expected.ToYAML(YamlMarshalingOpts{})
+	gotYAML := got.ToYAML(YamlMarshalingOpts{})
+	if expectedYAML == gotYAML {
+		return ""
+	}
+
+	var sb strings.Builder
+	for _, line := range diffLines(
+		strings.SplitAfter(expectedYAML, "\n"),
+		strings.SplitAfter(gotYAML, "\n"),
+	) {
+		sb.WriteString(line)
+	}
+
+	return sb.String()
+}
+
+// diffLines computes a line diff of a and b, based on their longest common
+// subsequence. Every line is prefixed with "-" (only in a), "+" (only in b) or
+// " " (in both).
+func diffLines(a, b []string) []string {
+	// Lines in a common prefix or suffix need not be part of the (quadratic)
+	// search.
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	midA := a[prefix : len(a)-suffix]
+	midB := b[prefix : len(b)-suffix]
+
+	// lcs[i][j] is the length of the longest common subsequence of midA[i:]
+	// and midB[j:].
+	lcs := make([][]int, len(midA)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(midB)+1)
+	}
+	for i := len(midA) - 1; i >= 0; i-- {
+		for j := len(midB) - 1; j >= 0; j-- {
+			switch {
+			case midA[i] == midB[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	diff := make([]string, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		diff = append(diff, " "+line)
+	}
+	i, j := 0, 0
+	for i < len(midA) || j < len(midB) {
+		switch {
+		case i < len(midA) && j < len(midB) && midA[i] == midB[j]:
+			diff = append(diff, " "+midA[i])
+			i++
+			j++
+		case j == len(midB) || (i < len(midA) && lcs[i+1][j] >= lcs[i][j+1]):
+			diff = append(diff, "-"+midA[i])
+			i++
+		default:
+			diff = append(diff, "+"+midB[j])
+			j++
+		}
+	}
+	for _, line := range a[len(a)-suffix:] {
+		diff = append(diff, " "+line)
+	}
+
+	// SplitAfter leaves an empty last element after the final newline.
+	if n := len(diff); n > 0 && diff[n-1] == " " {
+		diff = diff[:n-1]
+	}
+
+	return diff
+}
+
+// ShortDigest truncates the hex part of a digest to 12 characters (like
+// "docker images" does), which is plenty to tell digests apart when reading.
+func ShortDigest(digest Digest) string {
+	const shortLen = 12
+
+	parts := strings.SplitN(string(digest), ":", 2)
+	// nolint[gomnd]
+	if len(parts) != 2 || len(parts[1]) <= shortLen {
+		return string(digest)
+	}
+
+	return parts[0] + ":" + parts[1][:shortLen]
+}
+
 // ToLQIN converts a RegistryName and ImangeName to form a loosely-qualified
 // image name (LQIN). Notice that it is missing tag information --- hence
 // "loosely-qualified".
@@ -1946,6 +3932,73 @@ func SplitRegistryImagePath(
 		fmt.Errorf("could not determine registry name for '%v'", registryImagePath)
 }
 
+// SortedPromotionEdges returns the given edges sorted by destination registry,
+// destination image, tag and digest (and then by source, so that the order is
+// total).
+func SortedPromotionEdges(edges map[PromotionEdge]interface{}) []PromotionEdge {
+	sorted := make([]PromotionEdge, 0, len(edges))
+	for edge := range edges {
+		sorted = append(sorted, edge)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		switch {
+		case a.DstRegistry.Name != b.DstRegistry.Name:
+			return a.DstRegistry.Name < b.DstRegistry.Name
+		case a.DstImageTag.ImageName != b.DstImageTag.ImageName:
+			return a.DstImageTag.ImageName < b.DstImageTag.ImageName
+		case a.DstImageTag.Tag != b.DstImageTag.Tag:
+			return a.DstImageTag.Tag < b.DstImageTag.Tag
+		case a.Digest != b.Digest:
+			return a.Digest < b.Digest
+		case a.SrcRegistry.Name != b.SrcRegistry.Name:
+			return a.SrcRegistry.Name < b.SrcRegistry.Name
+		case a.SrcImageTag.ImageName != b.SrcImageTag.ImageName:
+			return a.SrcImageTag.ImageName < b.SrcImageTag.ImageName
+		default:
+			return a.SrcImageTag.Tag < b.SrcImageTag.Tag
+		}
+	})
+
+	return sorted
+}
+
+// promotionTagOp returns the operation that promotes the edge into the
+// destination (as known from sc.Inv), along with the digest that its tag is
+// moved away from (for forced overwrites, see PromotionEdge.OldDigest). If the
+// tag points at another digest in the destination and may not be moved, ok is
+// false and oldDigest is that other digest.
+func (sc *SyncContext) promotionTagOp(
+	edge *PromotionEdge,
+) (tagOp TagOp, oldDigest Digest, ok bool) {
+	_, dp := edge.VertexProps(&sc.Inv)
+
+	if dp.PqinExists && !dp.DigestExists {
+		// Pqin points to the wrong digest.
+		if edge.OldDigest == "" {
+			return Add, dp.BadDigest, false
+		}
+
+		// Copy the image over the tag.
+		return Add, edge.OldDigest, true
+	}
+
+	// Only support adding new tags during a promotion run. Tag moves and
+	// deletions are not supported.
+	//
+	// Although disallowing tag moves sounds a bit draconian, it does make
+	// protect production from a malformed set of promoter manifests with
+	// incorrect tag information.
+	if dp.DigestExists && len(edge.DstImageTag.Tag) > 0 {
+		// The image is already in the destination (under another tag, or
+		// untagged), so there is nothing to copy.
+		return Retag, edge.OldDigest, true
+	}
+
+	return Add, "", true
+}
+
 // MKPopulateRequestsForPromotionEdges takes in a map of PromotionEdges to promote
 // and a PromotionContext and returns a PopulateRequests which can generate
 // requests to be processed
@@ -1965,37 +4018,44 @@ func MKPopulateRequestsForPromotionEdges(
 			logrus.Info("---------- BEGIN PROMOTION ----------")
 		}
 
-		for promoteMe := range toPromote {
+		// Dispatch the requests in a deterministic order, so that runs over
+		// the same edges can be compared (the requests are still executed
+		// concurrently).
+		dispatched := 0
+		for _, promoteMe := range SortedPromotionEdges(toPromote) {
 			var req stream.ExternalRequest
-			oldDigest := Digest("")
-
-			_, dp := promoteMe.VertexProps(&sc.Inv)
-
-			if dp.PqinExists {
-				if !dp.DigestExists {
-					// Pqin points to the wrong digest.
-					logrus.Errorf(
-						"edge %v: tag '%s' in dest points to %s, not %s (as per the manifest), but tag moves are not supported; skipping\n",
-						promoteMe,
-						promoteMe.DstImageTag.Tag,
-						dp.BadDigest,
-						promoteMe.Digest,
-					)
 
-					continue
-				}
+			tagOp, oldDigest, ok := sc.promotionTagOp(&promoteMe)
+			if !ok {
+				edgeLogger(&promoteMe).Errorf(
+					"edge %v: tag '%s' in dest points to %s, not %s (as per the manifest), but tag moves are not supported; skipping\n",
+					promoteMe,
+					promoteMe.DstImageTag.Tag,
+					oldDigest,
+					promoteMe.Digest,
+				)
+
+				continue
 			}
 
 			// Save some information about this request. It's a bit like
 			// HTTP "headers".
+			// The request's process is only needed if images are copied with
+			// an external tool.
+			if sc.CopyTool != nil {
+				req.StreamProducer = mkProducer(
+					sc.MirroredRegistry(promoteMe.SrcRegistry.Name),
+					promoteMe.SrcImageTag.ImageName,
+					promoteMe.DstRegistry,
+					promoteMe.DstImageTag.ImageName,
+					promoteMe.Digest,
+					promoteMe.DstImageTag.Tag,
+					tagOp,
+				)
+			}
+
 			req.RequestParams = PromotionRequest{
-				// Only support adding new tags during a promotion run. Tag
-				// moves and deletions are not supported.
-				//
-				// Although disallowing tag moves sounds a bit draconian, it
-				// does make protect production from a malformed set of promoter
-				// manifests with incorrect tag information.
-				Add,
+				tagOp,
 				// TODO: Clean up types to avoid having to split up promoteMe
 				// prematurely like this.
 				promoteMe.SrcRegistry.Name,
@@ -2007,8 +4067,16 @@ func MKPopulateRequestsForPromotionEdges(
 				oldDigest,
 				promoteMe.DstImageTag.Tag,
 			}
+
+			// Let the previous batch finish (and pause) before starting the
+			// next one.
+			if sc.batchDone(dispatched) {
+				sc.awaitBatch(wg, dispatched/sc.BatchSize)
+			}
+
 			wg.Add(1)
 			reqs <- req
+			dispatched++
 		}
 	}
 }
@@ -2047,12 +4115,51 @@ func (sc *SyncContext) FilterPromotionEdges(
 			// Do not read these registries recursively, because we already know
 			// exactly which repositories to read (getRegistriesToRead()).
 			false,
-			MkReadRepositoryCmdReal)
+			sc.mkReadRepositoryCmd())
 	}
 
 	return sc.GetPromotionCandidates(edges)
 }
 
+// recheckPromotionEdges re-reads the destination repositories of the edges,
+// and filters the edges (see GetPromotionCandidates()) against them again.
+// The edges were filtered against destinations that were read before the
+// promotion lock was acquired, so another run may have written to them in
+// the meantime.
+func (sc *SyncContext) recheckPromotionEdges(
+	edges map[PromotionEdge]interface{},
+) (map[PromotionEdge]interface{}, error) {
+	seen := make(map[RegistryContext]bool)
+	regs := make([]RegistryContext, 0)
+	for edge := range edges {
+		dstReg := edge.DstRegistry
+		dstReg.Name = dstReg.Name +
+			"/" +
+			RegistryName(edge.DstImageTag.ImageName)
+		if !seen[dstReg] {
+			seen[dstReg] = true
+			regs = append(regs, dstReg)
+		}
+	}
+
+	sc.ReadRegistries(regs, false, sc.mkReadRepositoryCmd())
+
+	// Keep the edges skipped by the first filtering in the logs.
+	skipped := sc.Logs.Skipped
+	rechecked, ok := sc.GetPromotionCandidates(edges)
+	if sc.LogSkippedEdges {
+		sc.Logs.Skipped = append(skipped, sc.Logs.Skipped...)
+	}
+
+	if !ok {
+		return nil, fmt.Errorf(
+			"the destinations changed while waiting for the promotion lock; " +
+				"the edges cannot be promoted anymore")
+	}
+
+	return rechecked, nil
+}
+
 // EdgesToRegInvImage takes the destination endpoints of all edges and converts
 // their information to a RegInvImage type. It uses only those edges that are
 // trying to promote to the given destination registry.
@@ -2101,6 +4208,155 @@ func EdgesToRegInvImage(
 	return rii
 }
 
+// WriteManifestBasedSnapshot writes the snapshot of destRegistry that the
+// given manifests would produce (like EdgesToRegInvImage() does with their
+// promotion edges, moved into destNamespace by ToDestNamespace()) to w, as CSV
+// (see ToCSV()). Unlike ToPromotionEdges(), it converts the manifests to edges
+// one image at a time, so that only the edges of a single image (and the
+// destinations written so far) are held in memory. As a result, the lines are
+// sorted per image (in the order of the manifests) instead of globally.
+//
+// Destinations that were already written (e.g. by an image that is listed in
+// several manifests) are not written again. Like ToPromotionEdges(), it fails
+// if the manifests promote different digests to the same tag, or promote an
+// image in a cycle; as these are only found along the way, whatever was
+// written to w before the error must be discarded.
+func WriteManifestBasedSnapshot(
+	w io.Writer,
+	mfests []Manifest,
+	destRegistry string,
+	destNamespace string,
+	allowSelfPromotion bool,
+) error {
+	// The destinations written so far (tagged ones by their PQIN, and all of
+	// them by their FQIN), with the digests they were written with.
+	written := make(map[string]Digest)
+	// Only the registries and image names of the edges, as that is all that
+	// checkPromotionCycles() looks at.
+	registryEdges := make(map[PromotionEdge]interface{})
+	for m := range mfests {
+		for i := range mfests[m].Images {
+			single := mfests[m]
+			single.Images = mfests[m].Images[i : i+1]
+
+			edges, err := manifestPromotionEdges(&single, allowSelfPromotion)
+			if err != nil {
+				return err
+			}
+
+			edges, err = ToDestNamespace(edges, destNamespace)
+			if err != nil {
+				return err
+			}
+
+			// Tagged edges go first, so that a tagless edge is dropped if its
+			// digest is written with a tag anyway.
+			var tagged, tagless []PromotionEdge
+			for edge := range edges {
+				registryEdges[PromotionEdge{
+					SrcRegistry: RegistryContext{Name: edge.SrcRegistry.Name},
+					SrcImageTag: ImageTag{ImageName: edge.SrcImageTag.ImageName},
+					DstRegistry: RegistryContext{Name: edge.DstRegistry.Name},
+				}] = nil
+
+				if edge.DstImageTag.Tag == "" {
+					tagless = append(tagless, edge)
+				} else {
+					tagged = append(tagged, edge)
+				}
+			}
+
+			fresh := make(map[PromotionEdge]interface{})
+			for _, edge := range append(tagged, tagless...) {
+				fqin := ToFQIN(
+					edge.DstRegistry.Name,
+					edge.DstImageTag.ImageName,
+					edge.Digest)
+				if edge.DstImageTag.Tag == "" {
+					if _, ok := written[fqin]; !ok {
+						written[fqin] = edge.Digest
+						fresh[edge] = nil
+					}
+					continue
+				}
+
+				pqin := ToPQIN(
+					edge.DstRegistry.Name,
+					edge.DstImageTag.ImageName,
+					edge.DstImageTag.Tag)
+				digest, ok := written[pqin]
+				if ok && digest != edge.Digest {
+					return fmt.Errorf(
+						"overlapping edges detected: %s is promoted from both %s and %s",
+						pqin,
+						digest,
+						edge.Digest)
+				}
+
+				if !ok {
+					written[pqin] = edge.Digest
+					written[fqin] = edge.Digest
+					fresh[edge] = nil
+				}
+			}
+
+			rii := EdgesToRegInvImage(fresh, destRegistry)
+			if _, err := io.WriteString(w, rii.ToCSV()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return checkPromotionCycles(registryEdges)
+}
+
+// ProjectInventory returns the inventory that a destination registry would
+// have after promoting the given edges into it. The edges are assumed to all
+// target the same destination registry as the current inventory; the current
+// inventory itself is not modified.
+func ProjectInventory(current RegInvImage, edges []PromotionEdge) RegInvImage {
+	projected := make(RegInvImage)
+	for imageName, digestTags := range current {
+		projected[imageName] = make(DigestTags)
+		for digest, tags := range digestTags {
+			projected[imageName][digest] = append(TagSlice{}, tags...)
+		}
+	}
+
+	for _, edge := range edges {
+		imageName := edge.DstImageTag.ImageName
+		if projected[imageName] == nil {
+			projected[imageName] = make(DigestTags)
+		}
+
+		digestTags := projected[imageName]
+		if _, ok := digestTags[edge.Digest]; !ok {
+			digestTags[edge.Digest] = TagSlice{}
+		}
+
+		tag := edge.DstImageTag.Tag
+		if tag == "" {
+			continue
+		}
+
+		// A tag can only point to a single digest, so applying it to this
+		// digest moves it away from any other digest.
+		for digest, tags := range digestTags {
+			remaining := TagSlice{}
+			for _, t := range tags {
+				if t != tag {
+					remaining = append(remaining, t)
+				}
+			}
+			digestTags[digest] = remaining
+		}
+
+		digestTags[edge.Digest] = append(digestTags[edge.Digest], tag)
+	}
+
+	return projected
+}
+
 // getRegistriesToRead collects all unique Docker repositories we want to read
 // from. This way, we don't have to read the entire Docker registry, but only
 // those paths that we are thinking of modifying.
@@ -2156,14 +4412,57 @@ func (sc *SyncContext) Promote(
 	}
 
 	logrus.Info("Pending promotions:")
-	for edge := range edges {
+	for _, edge := range SortedPromotionEdges(edges) {
 		logrus.Infof("  %v\n", edge)
 	}
 
+	// Keep concurrent runs from writing to the same destination registries.
+	var lockCtx, parentCtx context.Context
+	if sc.PromotionLock != nil && !sc.DryRun {
+		dstRegistries := make([]RegistryName, 0)
+		for edge := range edges {
+			dstRegistries = append(dstRegistries, edge.DstRegistry.Name)
+		}
+
+		lock := *sc.PromotionLock
+		if lock.Clock == nil {
+			lock.Clock = sc.clock()
+		}
+
+		var (
+			release func()
+			err     error
+		)
+		lockCtx, release, err = lock.Acquire(sc.ctx(), dstRegistries)
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		// The pending writes are cancelled if a lock is lost.
+		parentCtx = sc.Context
+		sc.Context = lockCtx
+		defer func() { sc.Context = parentCtx }()
+
+		edges, err = sc.recheckPromotionEdges(edges)
+		if err != nil {
+			return err
+		}
+
+		if len(edges) == 0 {
+			logrus.Info("Nothing to promote (anymore).")
+			return nil
+		}
+	}
+
 	var populateRequests = MKPopulateRequestsForPromotionEdges(
 		edges,
 		mkProducer)
 
+	if sc.layerMounts == nil {
+		sc.layerMounts = newLayerMounts()
+	}
+
 	var processRequest ProcessRequest
 	var processRequestReal ProcessRequest = func(
 		sc *SyncContext,
@@ -2173,6 +4472,12 @@ func (sc *SyncContext) Promote(
 		mutex *sync.Mutex) {
 
 		for req := range reqs {
+			if reqRes, cancelled := sc.cancelledResult(req); cancelled {
+				requestResults <- reqRes
+				continue
+			}
+
+			start := time.Now()
 			reqRes := RequestResult{Context: req}
 			errors := make(Errors, 0)
 			// If we're adding or moving (i.e., creating a new image or
@@ -2180,9 +4485,52 @@ func (sc *SyncContext) Promote(
 			// use the gcrane.doCopy() method directly.
 
 			rpr := req.RequestParams.(PromotionRequest)
+			log := requestLogger(rpr)
 			switch rpr.TagOp {
-			case Add:
-				srcVertex := ToFQIN(rpr.RegistrySrc, rpr.ImageNameSrc, rpr.Digest)
+			case Add, Retag:
+				if sc.CopyTool != nil {
+					errors = append(errors, runCopyProcess(req)...)
+					for _, e := range errors {
+						log.Error(e.Error)
+					}
+					break
+				}
+
+				// Authenticate the source and destination with their own
+				// service accounts.
+				opts := sc.craneOptions()
+
+				// The digest is already in the destination, so only the tag
+				// has to be written; no image data is copied.
+				if rpr.TagOp == Retag {
+					err := sc.RetryWrite(Retag, func() error {
+						return sc.withTokenRefresh(
+							sc.promotionRegistries(&rpr),
+							func() error {
+								return crane.Tag(
+									ToFQIN(
+										rpr.RegistryDest,
+										rpr.ImageNameDest,
+										rpr.Digest),
+									string(rpr.Tag),
+									opts...)
+							})
+					})
+					if err != nil {
+						log.Error(err)
+						errors = append(errors, Error{
+							Context: "running tagImage()",
+							Error:   err})
+					}
+					break
+				}
+
+				// Pull the image through the mirror of the source registry
+				// (if any).
+				srcVertex := ToFQIN(
+					sc.MirroredRegistry(rpr.RegistrySrc),
+					rpr.ImageNameSrc,
+					rpr.Digest)
 
 				var dstVertex string
 
@@ -2201,17 +4549,42 @@ func (sc *SyncContext) Promote(
 						rpr.Digest)
 				}
 
-				if err := crane.Copy(srcVertex, dstVertex); err != nil {
-					logrus.Error(err)
+				// Layers that the destination registry already holds in
+				// other repositories are mounted rather than uploaded. The
+				// copy is retried once if a registry rejects its (expired)
+				// token, and as sc.WriteRetryPolicy allows otherwise.
+				err := sc.RetryWrite(Add, func() error {
+					return sc.withTokenRefresh(
+						sc.promotionRegistries(&rpr),
+						func() error {
+							return sc.copyImage(
+								srcVertex,
+								dstVertex,
+								sc.holders(&rpr))
+						})
+				})
+				if err != nil {
+					log.Error(err)
 					errors = append(errors, Error{
 						Context: "running writeImage()",
 						Error:   err})
 				}
 			case Move:
-				logrus.Infof("tag moves are no longer supported")
+				log.Infof("tag moves are no longer supported")
 			case Delete:
-				logrus.Infof("deletions are no longer supported")
+				log.Infof("deletions are no longer supported")
+			}
+
+			// Only promotions that the destination actually serves are
+			// announced.
+			if len(errors) == 0 && (rpr.TagOp == Add || rpr.TagOp == Retag) {
+				errors = append(errors, sc.smokeVerify(rpr)...)
+			}
+			if len(errors) == 0 && (rpr.TagOp == Add || rpr.TagOp == Retag) {
+				errors = append(errors, sc.publishPromotionEvent(rpr)...)
+				errors = append(errors, sc.generateProvenance(rpr)...)
 			}
+			sc.recordPromotion(rpr, len(errors) == 0, time.Since(start))
 
 			reqRes.Errors = errors
 			requestResults <- reqRes
@@ -2231,17 +4604,33 @@ func (sc *SyncContext) Promote(
 		processRequest = *customProcessRequest
 	}
 
-	err := sc.ExecRequests(populateRequests, processRequest)
+	if len(sc.GroupThreads) > 0 {
+		processRequest = sc.limitGroupConcurrency(processRequest)
+	}
+
+	summary, err := sc.execRequests(populateRequests, processRequest)
+	sc.Logs.Promotions = summary
+	logCorrelationIDs(edges)
+
+	// Tell a lost lock apart from the end of the run.
+	if err != nil && lockCtx != nil && lockCtx.Err() != nil &&
+		(parentCtx == nil || parentCtx.Err() == nil) {
+		err = fmt.Errorf("promotion lock lost while promoting: %w", err)
+	}
 
 	if sc.DryRun {
 		sc.PrintCapturedRequests(&captured)
+		sc.recordCommands(&captured)
+	} else {
+		sc.flushMetrics()
 	}
 
 	return err
 }
 
-// PrintCapturedRequests pretty-prints all given PromotionRequests.
-func (sc *SyncContext) PrintCapturedRequests(capReqs *CapturedRequests) {
+// sortedCapturedRequests returns the captured PromotionRequests (each as many
+// times as it was captured), sorted by their pretty value.
+func sortedCapturedRequests(capReqs *CapturedRequests) []PromotionRequest {
 	prs := make([]PromotionRequest, 0)
 
 	for req, count := range *capReqs {
@@ -2253,6 +4642,13 @@ func (sc *SyncContext) PrintCapturedRequests(capReqs *CapturedRequests) {
 	sort.Slice(prs, func(i, j int) bool {
 		return prs[i].PrettyValue() < prs[j].PrettyValue()
 	})
+
+	return prs
+}
+
+// PrintCapturedRequests pretty-prints all given PromotionRequests.
+func (sc *SyncContext) PrintCapturedRequests(capReqs *CapturedRequests) {
+	prs := sortedCapturedRequests(capReqs)
 	if len(prs) > 0 {
 		fmt.Println("")
 		fmt.Println("captured reqs summary:")
@@ -2261,6 +4657,25 @@ func (sc *SyncContext) PrintCapturedRequests(capReqs *CapturedRequests) {
 		// nolint: gocritic
 		for _, pr := range prs {
 			fmt.Printf("captured req: %v", pr.PrettyValue())
+			if sc.CopyTool != nil && (pr.TagOp == Add || pr.TagOp == Retag) {
+				cmd := GetWriteCmd(
+					RegistryContext{
+						Name:           pr.RegistryDest,
+						ServiceAccount: pr.ServiceAccount,
+					},
+					sc.UseServiceAccount,
+					sc.MirroredRegistry(pr.RegistrySrc),
+					pr.ImageNameSrc,
+					pr.ImageNameDest,
+					pr.Digest,
+					pr.Tag,
+					pr.TagOp,
+					sc.CopyTool,
+				)
+				fmt.Printf("  %s: %s\n", sc.CopyTool.Name(), strings.Join(cmd, " "))
+			} else if sc.BandwidthLimiter != nil && pr.TagOp == Add {
+				fmt.Printf("  bandwidth limit: %s\n", sc.BandwidthLimiter)
+			}
 		}
 		fmt.Println("")
 	} else {
@@ -2278,6 +4693,8 @@ func (op *TagOp) PrettyValue() string {
 		tagOpPretty = "MOVE"
 	case Delete:
 		tagOpPretty = "DELETE"
+	case Retag:
+		tagOpPretty = "RETAG"
 	}
 
 	return tagOpPretty
@@ -2436,6 +4853,10 @@ func supportedMediaType(v string) (ggcrV1Types.MediaType, error) {
 		return ggcrV1Types.DockerManifestSchema1Signed, nil
 	case ggcrV1Types.DockerManifestSchema2:
 		return ggcrV1Types.DockerManifestSchema2, nil
+	case ggcrV1Types.OCIImageIndex:
+		return ggcrV1Types.OCIImageIndex, nil
+	case ggcrV1Types.OCIManifestSchema1:
+		return ggcrV1Types.OCIManifestSchema1, nil
 	default:
 		return ggcrV1Types.MediaType(""),
 			fmt.Errorf("unsupported MediaType %s", v)
@@ -2571,8 +4992,9 @@ func (sc *SyncContext) ClearRepository(
 	}
 }
 
-// GetWriteCmd generates a gcloud command that is used to make modifications to
-// a Docker Registry.
+// GetWriteCmd generates the command that is used to make modifications to a
+// Docker Registry. Images are added (or retagged) with the given CopyTool (or
+// crane, if it is nil); everything else is done with gcloud.
 func GetWriteCmd(
 	dest RegistryContext,
 	useServiceAccount bool,
@@ -2582,10 +5004,35 @@ func GetWriteCmd(
 	digest Digest,
 	tag Tag,
 	tp TagOp,
+	tool CopyTool,
 ) []string {
 	var cmd []string
 
 	switch tp {
+	case Add:
+		if tool == nil {
+			tool = CraneCopyTool{}
+		}
+
+		// Tagless promotions must reference the destination by digest.
+		dstImage := ToFQIN(dest.Name, destImageName, digest)
+		if len(tag) > 0 {
+			dstImage = ToPQIN(dest.Name, destImageName, tag)
+		}
+
+		// The tool takes care of the service account itself.
+		return tool.CopyCmd(
+			dest,
+			useServiceAccount,
+			ToFQIN(srcRegistry, srcImageName, digest),
+			dstImage,
+		)
+	case Retag:
+		if tool == nil {
+			tool = CraneCopyTool{}
+		}
+
+		return tool.TagCmd(dest, useServiceAccount, destImageName, digest, tag)
 	case Delete:
 		cmd = []string{
 			"gcloud",
@@ -17,17 +17,30 @@ limitations under the License.
 package inventory_test
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
 	cr "github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/require"
 
+	"sigs.k8s.io/k8s-container-image-promoter/internal/version"
 	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/gcloud"
 	"sigs.k8s.io/k8s-container-image-promoter/legacy/json"
 	"sigs.k8s.io/k8s-container-image-promoter/legacy/stream"
 )
@@ -232,6 +245,160 @@ images:
 	}
 }
 
+func TestParseManifestFromFile(t *testing.T) {
+	// The same manifest, written in JSON and YAML.
+	fromJSON, err := reg.ParseManifestFromFile(
+		getTestPath("TestParseManifestFromFile", "manifest.json"))
+	require.Nil(t, err)
+	fromYAML, err := reg.ParseManifestFromFile(
+		getTestPath("TestParseManifestFromFile", "manifest.yaml"))
+	require.Nil(t, err)
+
+	require.Len(t, fromJSON.Images, 2)
+	require.Equal(t, 3, fromJSON.Images[0].MaxTags)
+	require.Equal(t, "fruit", fromJSON.Images[1].Group)
+	require.Equal(t, reg.RegistryName("gcr.io/foo"), fromJSON.SrcRegistry.Name)
+
+	fromJSON.Filepath = ""
+	fromYAML.Filepath = ""
+	require.Equal(t, fromYAML, fromJSON)
+
+	// Errors point at the offending line of the JSON file.
+	_, err = reg.ParseManifestFromFile(
+		getTestPath("TestParseManifestFromFile", "unknown-field.json"))
+	require.NotNil(t, err)
+
+	var mErr *reg.ManifestError
+	require.True(t, errors.As(err, &mErr))
+	require.Equal(t, 9, mErr.Line)
+	require.Equal(
+		t,
+		`json: line 9, column 7: unknown field "serviceAccount"`,
+		err.Error())
+}
+
+func TestParseManifestFromFileCompressed(t *testing.T) {
+	expected, err := reg.ParseManifestFromFile(
+		getTestPath("TestParseManifestFromFile", "manifest.yaml"))
+	require.Nil(t, err)
+	expected.Filepath = ""
+
+	// Told by the extension (which also tells JSON from YAML), or by the
+	// magic bytes.
+	for _, name := range []string{
+		"manifest.yaml.gz",
+		"manifest.json.gz",
+		"gzipped-manifest",
+	} {
+		filePath := getTestPath("TestParseManifestFromFile", name)
+		got, err := reg.ParseManifestFromFile(filePath)
+		require.Nil(t, err, name)
+		require.Equal(t, filePath, got.Filepath, name)
+
+		got.Filepath = ""
+		require.Equal(t, expected, got, name)
+	}
+
+	dir := t.TempDir()
+	for name, contents := range map[string][]byte{
+		"truncated.yaml.gz": {0x1f, 0x8b, 0x08},
+		"manifest.yaml.zst": {0x28, 0xb5, 0x2f, 0xfd},
+	} {
+		filePath := filepath.Join(dir, name)
+		require.Nil(t, os.WriteFile(filePath, contents, 0o644))
+
+		_, err := reg.ParseManifestFromFile(filePath)
+		require.NotNil(t, err, name)
+
+		var mErr *reg.ManifestError
+		require.True(t, errors.As(err, &mErr), name)
+		require.Equal(t, filePath, mErr.Filepath, name)
+	}
+}
+
+func TestParseManifestJSON(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expectedError string
+	}{
+		{
+			"Valid manifest",
+			`{
+  "registries": [
+    {"name": "gcr.io/foo", "src": true},
+    {"name": "gcr.io/bar"}
+  ],
+  "images": [
+    {
+      "name": "agave",
+      "dmap": {
+        "sha256:aab34c5841987a1b133388fa9f27e7960c4b1307e2f9147dca407ba26af48a54": ["latest"]
+      }
+    }
+  ]
+}`,
+			"",
+		},
+		{
+			"Unknown field",
+			`{
+  "registries": [
+    {"name": "gcr.io/foo", "src": true, "source": true}
+  ]
+}`,
+			`json: line 3, column 41: unknown field "source"`,
+		},
+		{
+			"Wrong type",
+			`{
+  "registries": [
+    {"name": "gcr.io/foo", "src": "yes"}
+  ]
+}`,
+			// The error is reported at the end of the value. The field path
+			// depends on the Go version ("registries.0.src" in newer ones).
+			`json: line 3, column 39: field "registries.`,
+		},
+		{
+			"Syntax error",
+			`{
+  "registries": [
+    {"name": "gcr.io/foo",}
+  ]
+}`,
+			`json: line 3, column 27: invalid character '}' looking for beginning of object key string`,
+		},
+		{
+			"Truncated",
+			`{
+  "registries": [`,
+			"json: line 2, column 17: unexpected end of JSON",
+		},
+		{
+			"Trailing data",
+			`{"registries": [{"name": "gcr.io/foo", "src": true}, {"name": "gcr.io/bar"}]}
+{}`,
+			"json: line 2, column 1: unexpected data after the manifest",
+		},
+		{
+			"Semantic error",
+			`{"registries": [{"name": "gcr.io/foo"}, {"name": "gcr.io/bar"}]}`,
+			"source registry must be set",
+		},
+	}
+
+	for _, test := range tests {
+		_, err := reg.ParseManifestJSON([]byte(test.input))
+		if test.expectedError == "" {
+			require.Nil(t, err, test.name)
+			continue
+		}
+		require.NotNil(t, err, test.name)
+		require.Contains(t, err.Error(), test.expectedError, test.name)
+	}
+}
+
 func TestParseThinManifestsFromDir(t *testing.T) {
 	pwd := getTestPath("TestParseThinManifestsFromDir")
 
@@ -483,6 +650,83 @@ func TestParseThinManifestsFromDir(t *testing.T) {
 			},
 			nil,
 		},
+		{
+			"Defaults (inherited and overridden registries)",
+			"defaults",
+			[]reg.Manifest{
+				{
+					Registries: []reg.RegistryContext{
+						{
+							Name:           "gcr.io/foo-staging",
+							ServiceAccount: "sa@robot.com",
+							Src:            true,
+						},
+						{
+							Name:           "us.gcr.io/some-prod",
+							ServiceAccount: "sa@robot.com",
+						},
+						{
+							Name:           "eu.gcr.io/some-prod",
+							ServiceAccount: "sa@robot.com",
+						},
+						{
+							Name:           "asia.gcr.io/some-prod",
+							ServiceAccount: "sa@robot.com",
+						},
+					},
+					Images: []reg.Image{
+						{
+							ImageName: "foo-controller",
+							Dmap: reg.DigestTags{
+								"sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa": {"1.0"},
+							},
+						},
+					},
+					Filepath: "manifests/a/promoter-manifest.yaml",
+				},
+				{
+					Registries: []reg.RegistryContext{
+						{
+							Name:           "gcr.io/bar-staging",
+							ServiceAccount: "sa@robot.com",
+							Src:            true,
+						},
+						{
+							Name:           "us.gcr.io/some-prod",
+							ServiceAccount: "bar@robot.com",
+						},
+						{
+							Name:           "eu.gcr.io/some-prod",
+							ServiceAccount: "sa@robot.com",
+						},
+						{
+							Name:           "asia.gcr.io/some-prod",
+							ServiceAccount: "sa@robot.com",
+						},
+					},
+					Images: []reg.Image{
+						{
+							ImageName: "bar-controller",
+							Dmap: reg.DigestTags{
+								"sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb": {"1.0"},
+							},
+						},
+					},
+					Filepath: "manifests/b/promoter-manifest.yaml",
+				},
+			},
+			nil,
+		},
+		{
+			"Defaults with a source registry that conflicts (invalid)",
+			"defaults-conflicting-src",
+			[]reg.Manifest{},
+			fmt.Errorf(
+				"default source registry %q conflicts with source registry %q",
+				"gcr.io/foo-staging",
+				"gcr.io/bar-staging",
+			),
+		},
 	}
 
 	for _, test := range tests {
@@ -519,1290 +763,3590 @@ func TestParseThinManifestsFromDir(t *testing.T) {
 	}
 }
 
-func TestValidateThinManifestsFromDir(t *testing.T) {
-	shouldBeValid := []string{
-		"singleton",
-		"multiple-rebases",
-		"overlapping-src-registries",
-		"overlapping-destination-vertices-same-digest",
-		"malformed-directory-tree-structure-bad-prefix-is-ignored",
+func TestGetRegistry(t *testing.T) {
+	mfests := []reg.Manifest{
+		{
+			Registries: []reg.RegistryContext{
+				{Name: "gcr.io/foo", ServiceAccount: "src@robot", Src: true},
+				{Name: "us.gcr.io/bar", ServiceAccount: "us@robot"},
+				{Name: "eu.gcr.io/bar", ServiceAccount: "eu@robot"},
+			},
+		},
+		{
+			Registries: []reg.RegistryContext{
+				{Name: "gcr.io/foo", ServiceAccount: "other@robot", Src: true},
+				{Name: "asia.gcr.io/bar", ServiceAccount: "asia@robot"},
+			},
+		},
 	}
 
-	pwd := getTestPath("TestValidateThinManifestsFromDir")
-
-	for _, testInput := range shouldBeValid {
-		fixtureDir := filepath.Join(pwd, "valid", testInput)
-
-		mfests, errParse := reg.ParseThinManifestsFromDir(fixtureDir)
-		require.Nil(t, errParse)
+	tests := []struct {
+		name        string
+		expected    *reg.RegistryContext
+		expectedErr string
+	}{
+		{
+			"eu.gcr.io/bar",
+			&reg.RegistryContext{Name: "eu.gcr.io/bar", ServiceAccount: "eu@robot"},
+			"",
+		},
+		{
+			"asia.gcr.io/bar",
+			&reg.RegistryContext{
+				Name:           "asia.gcr.io/bar",
+				ServiceAccount: "asia@robot",
+			},
+			"",
+		},
+		{
+			// The first manifest that declares the registry wins.
+			"gcr.io/foo",
+			&reg.RegistryContext{
+				Name:           "gcr.io/foo",
+				ServiceAccount: "src@robot",
+				Src:            true,
+			},
+			"",
+		},
+		{
+			"gcr.io/bar",
+			nil,
+			`registry "gcr.io/bar" is not in the manifest(s) (registries: asia.gcr.io/bar, eu.gcr.io/bar, gcr.io/foo, us.gcr.io/bar)`,
+		},
+	}
 
-		_, edgeErr := reg.ToPromotionEdges(mfests)
-		require.Nil(t, edgeErr)
+	for _, test := range tests {
+		got, err := reg.GetRegistry(mfests, reg.RegistryName(test.name))
+		if test.expectedErr != "" {
+			require.NotNil(t, err, test.name)
+			require.Equal(t, test.expectedErr, err.Error(), test.name)
+			continue
+		}
+		require.Nil(t, err, test.name)
+		require.Equal(t, test.expected, got, test.name)
 	}
+}
 
-	shouldBeInvalid := []struct {
-		dirName            string
-		expectedParseError error
-		expectedEdgeError  error
+func TestExpandEnv(t *testing.T) {
+	require.Nil(t, os.Setenv("CIP_TEST_DEFINED", "foo"))
+	defer os.Unsetenv("CIP_TEST_DEFINED")
+	require.Nil(t, os.Setenv("CIP_TEST_EMPTY", ""))
+	defer os.Unsetenv("CIP_TEST_EMPTY")
+	require.Nil(t, os.Unsetenv("CIP_TEST_UNDEFINED"))
+
+	tests := []struct {
+		name          string
+		input         string
+		expected      string
+		expectedError bool
 	}{
 		{
-			"empty",
-			&os.PathError{
-				Op:   "stat",
-				Path: filepath.Join(pwd, "invalid/empty/images"),
-				Err:  fmt.Errorf("no such file or directory"),
-			},
-			nil,
+			"Defined variable",
+			"name: gcr.io/${CIP_TEST_DEFINED}-staging",
+			"name: gcr.io/foo-staging",
+			false,
 		},
 		{
-			"overlapping-destination-vertices-different-digest",
-			nil,
-			fmt.Errorf("overlapping edges detected"),
+			"Undefined variable",
+			"name: gcr.io/${CIP_TEST_UNDEFINED}",
+			"",
+			true,
 		},
 		{
-			"malformed-directory-tree-structure",
-			fmt.Errorf(
-				"corresponding file %q does not exist",
-				filepath.Join(pwd, "invalid/malformed-directory-tree-structure/images/b/images.yaml"),
-			),
-			nil,
+			"Defined (but empty) variable",
+			"name: gcr.io/foo${CIP_TEST_EMPTY}",
+			"name: gcr.io/foo",
+			false,
 		},
 		{
-			"malformed-directory-tree-structure-nested",
-			fmt.Errorf(
-				"unexpected manifest path %q",
-				filepath.Join(pwd, "invalid/malformed-directory-tree-structure-nested/manifests/b/c/promoter-manifest.yaml"),
-			),
-			nil,
+			"Defaulted variable (undefined)",
+			"name: gcr.io/${CIP_TEST_UNDEFINED:-bar}",
+			"name: gcr.io/bar",
+			false,
+		},
+		{
+			"Defaulted variable (empty)",
+			"name: gcr.io/${CIP_TEST_EMPTY:-bar}",
+			"name: gcr.io/bar",
+			false,
+		},
+		{
+			"Defaulted variable (defined)",
+			"name: gcr.io/${CIP_TEST_DEFINED:-bar}",
+			"name: gcr.io/foo",
+			false,
+		},
+		{
+			"Empty default",
+			"name: gcr.io/foo${CIP_TEST_UNDEFINED:-}",
+			"name: gcr.io/foo",
+			false,
+		},
+		{
+			"Other uses of '$' are left alone",
+			"name: $CIP_TEST_DEFINED ${} $${",
+			"name: $CIP_TEST_DEFINED ${} $${",
+			false,
 		},
 	}
 
-	for _, test := range shouldBeInvalid {
-		fixtureDir := getTestPath("TestValidateThinManifestsFromDir", "invalid", test.dirName)
-
-		// It could be that a manifest, taken individually, failed on its own,
-		// before we even get to ValidateThinManifestsFromDir(). So handle these
-		// cases as well.
-		mfests, errParse := reg.ParseThinManifestsFromDir(fixtureDir)
-		if test.expectedParseError != nil {
-			require.NotNil(t, errParse)
-			require.Error(t, errParse, test.expectedParseError)
-		} else {
-			require.Nil(t, errParse)
+	for _, test := range tests {
+		got, err := reg.ExpandEnv([]byte(test.input))
+		if test.expectedError {
+			require.NotNil(t, err, test.name)
+			require.Contains(t, err.Error(), "CIP_TEST_UNDEFINED", test.name)
+			continue
 		}
 
-		_, edgeErr := reg.ToPromotionEdges(mfests)
-		if test.expectedEdgeError != nil {
-			require.NotNil(t, edgeErr)
-			require.Error(t, edgeErr, test.expectedEdgeError)
-		} else {
-			require.Nil(t, edgeErr)
-		}
+		require.Nil(t, err, test.name)
+		require.Equal(t, test.expected, string(got), test.name)
 	}
 }
 
-func TestParseImageDigest(t *testing.T) {
-	shouldBeValid := []string{
-		`sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef`,
-		`sha256:0000000000000000000000000000000000000000000000000000000000000000`,
-		`sha256:ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff`,
-		`sha256:3243f6a8885a308d313198a2e03707344a4093822299f31d0082efa98ec4e6c8`,
-	}
+func TestParseThinManifestsFromDirExpandEnv(t *testing.T) {
+	fixtureDir := getTestPath("TestParseThinManifestsFromDirExpandEnv", "env")
+	opts := reg.ThinManifestOptions{ExpandEnv: true}
 
-	for _, testInput := range shouldBeValid {
-		d := reg.Digest(testInput)
-		require.NotEmpty(t, d)
+	require.Nil(t, os.Unsetenv("CIP_TEST_STAGING_PROJECT"))
+	require.Nil(t, os.Unsetenv("CIP_TEST_IMAGES_DIR"))
+	require.Nil(t, os.Unsetenv("CIP_TEST_PROD_PROJECT"))
 
-		err := reg.ValidateDigest(d)
-		require.Nil(t, err)
-	}
+	// The defaults file references an undefined variable.
+	_, err := reg.ParseThinManifestsFromDirWithOptions(fixtureDir, opts)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "CIP_TEST_PROD_PROJECT")
 
-	shouldBeInvalid := []string{
-		// Empty.
-		``,
-		// Too short.
-		`sha256:0`,
-		// Too long.
-		`sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef1`,
-		// Invalid character 'x'.
-		`sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdex`,
-		// No prefix 'sha256'.
-		`0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef`,
-	}
+	require.Nil(t, os.Setenv("CIP_TEST_PROD_PROJECT", "some-prod"))
+	defer os.Unsetenv("CIP_TEST_PROD_PROJECT")
 
-	for _, testInput := range shouldBeInvalid {
-		d := reg.Digest(testInput)
-		err := reg.ValidateDigest(d)
-		require.NotNil(t, err)
+	expectedRegistries := func(staging string) []reg.RegistryContext {
+		return []reg.RegistryContext{
+			{
+				Name:           reg.RegistryName("gcr.io/" + staging),
+				ServiceAccount: "sa@robot.com",
+				Src:            true,
+			},
+			{
+				Name:           "us.gcr.io/some-prod",
+				ServiceAccount: "sa@some-prod.iam.gserviceaccount.com",
+			},
+		}
 	}
-}
 
-func TestParseImageTag(t *testing.T) {
-	shouldBeValid := []string{
-		`a`,
-		`_`,
-		`latest`,
-		`_latest`,
-		// Awkward, but valid.
-		`_____----hello........`,
-		// Longest tag is 128 chars.
-		`this-is-exactly-128-chars-this-is-exactly-128-chars-this-is-exactly-128-chars-this-is-exactly-128-chars-this-is-exactly-128-char`,
-	}
+	// Defaulted variables.
+	got, err := reg.ParseThinManifestsFromDirWithOptions(fixtureDir, opts)
+	require.Nil(t, err)
+	require.Len(t, got, 1)
+	require.Equal(t, expectedRegistries("foo-staging"), got[0].Registries)
+	require.Len(t, got[0].Images, 1)
 
-	for _, testInput := range shouldBeValid {
-		tag := reg.Tag(testInput)
-		err := reg.ValidateTag(tag)
-		require.Nil(t, err)
-	}
+	// Defined variables override the defaults.
+	require.Nil(t, os.Setenv("CIP_TEST_STAGING_PROJECT", "bar-staging"))
+	defer os.Unsetenv("CIP_TEST_STAGING_PROJECT")
 
-	shouldBeInvalid := []string{
-		// Empty.
-		``,
-		// Does not begin with an ASCII word character.
-		`.`,
-		// Does not begin with an ASCII word character.
-		`-`,
-		// Unicode not allowed.
-		`안녕`,
-		// No spaces allowed.
-		`a b`,
-		// Too long (>128 ASCII chars).
-		`this-is-longer-than-128-chars-this-is-longer-than-128-chars-this-is-longer-than-128-chars-this-is-longer-than-128-chars-this-is-l`,
-	}
+	got, err = reg.ParseThinManifestsFromDirWithOptions(fixtureDir, opts)
+	require.Nil(t, err)
+	require.Len(t, got, 1)
+	require.Equal(t, expectedRegistries("bar-staging"), got[0].Registries)
 
-	for _, testInput := range shouldBeInvalid {
-		tag := reg.Tag(testInput)
-		err := reg.ValidateTag(tag)
-		require.NotNil(t, err)
-	}
+	// Without the option, nothing is expanded.
+	got, err = reg.ParseThinManifestsFromDir(fixtureDir)
+	require.Nil(t, err)
+	require.Len(t, got, 1)
+	require.Equal(t,
+		reg.RegistryName("gcr.io/${CIP_TEST_STAGING_PROJECT:-foo-staging}"),
+		got[0].Registries[0].Name)
 }
 
-func TestValidateRegistryImagePath(t *testing.T) {
-	shouldBeValid := []string{
-		`gcr.io/foo/bar`,
-		`k8s.gcr.io/foo`,
-		`staging-k8s.gcr.io/foo`,
-		`staging-k8s.gcr.io/foo/bar/nested/path/image`,
+func TestParseThinManifestsFromDirSkipInvalid(t *testing.T) {
+	tests := []struct {
+		name             string
+		input            string
+		skipInvalid      bool
+		expectedPaths    []string
+		expectedSkipped  []string
+		expectedErrorMsg string
+	}{
+		{
+			"Invalid manifest fails the whole directory by default",
+			"skip-invalid",
+			false,
+			nil,
+			nil,
+			"yaml:",
+		},
+		{
+			"Invalid manifest is skipped",
+			"skip-invalid",
+			true,
+			[]string{"manifests/a/promoter-manifest.yaml"},
+			[]string{"manifests/b/promoter-manifest.yaml"},
+			"skipped 1 invalid manifest(s)",
+		},
+		{
+			"No valid manifest remains",
+			"all-invalid",
+			true,
+			[]string{},
+			[]string{"manifests/b/promoter-manifest.yaml"},
+			"no valid manifests found in dir",
+		},
+		{
+			"Nothing to skip",
+			"multiple-rebases",
+			true,
+			[]string{
+				"manifests/a/promoter-manifest.yaml",
+				"manifests/b/promoter-manifest.yaml",
+			},
+			nil,
+			"",
+		},
 	}
 
-	for _, testInput := range shouldBeValid {
-		rip := reg.RegistryImagePath(testInput)
-		require.NotEmpty(t, rip)
+	for _, test := range tests {
+		fixtureDir := getTestPath("TestParseThinManifestsFromDir", test.input)
 
-		err := reg.ValidateRegistryImagePath(rip)
-		require.Nil(t, err)
-	}
+		got, err := reg.ParseThinManifestsFromDirWithOptions(
+			fixtureDir,
+			reg.ThinManifestOptions{SkipInvalid: test.skipInvalid})
 
-	shouldBeInvalid := []string{
-		// Empty.
-		``,
-		// No dot.
-		`gcrio`,
-		// Too many dots.
-		`gcr..io`,
-		// Leading dot.
-		`.gcr.io`,
-		// Trailing dot.
-		`gcr.io.`,
-		// Too many slashes.
-		`gcr.io//foo`,
-		// Leading slash.
-		`/gcr.io`,
-		// Trailing slash (1).
-		`gcr.io/`,
-		// Trailing slash (2).
-		`gcr.io/foo/`,
-	}
+		gotPaths := make([]string, 0)
+		for _, mfest := range got {
+			rel, err := filepath.Rel(fixtureDir, mfest.Filepath)
+			require.Nil(t, err)
+			gotPaths = append(gotPaths, rel)
+		}
+		if test.skipInvalid {
+			require.ElementsMatch(t, test.expectedPaths, gotPaths, test.name)
+		}
 
-	for _, testInput := range shouldBeInvalid {
-		rip := reg.RegistryImagePath(testInput)
-		err := reg.ValidateRegistryImagePath(rip)
-		require.NotNil(t, err)
+		if test.expectedErrorMsg == "" {
+			require.Nil(t, err, test.name)
+			continue
+		}
+		require.NotNil(t, err, test.name)
+		require.Contains(t, err.Error(), test.expectedErrorMsg, test.name)
+
+		var invalid *reg.InvalidManifestsError
+		if test.expectedSkipped == nil {
+			require.False(t, errors.As(err, &invalid), test.name)
+			continue
+		}
+		require.True(t, errors.As(err, &invalid), test.name)
+
+		gotSkipped := make([]string, 0)
+		for _, mErr := range invalid.Skipped {
+			rel, err := filepath.Rel(fixtureDir, mErr.Filepath)
+			require.Nil(t, err)
+			gotSkipped = append(gotSkipped, rel)
+			require.Greater(t, mErr.Line, 0, test.name)
+		}
+		require.Equal(t, test.expectedSkipped, gotSkipped, test.name)
 	}
 }
 
-func TestSplitRegistryImagePath(t *testing.T) {
-	knownRegistryNames := []reg.RegistryName{
-		`gcr.io/foo`,
-		`us.gcr.io/foo`,
-		`k8s.gcr.io`,
-		`eu.gcr.io/foo/d`,
-	}
+func TestParseThinManifestsFromDirStrictDigests(t *testing.T) {
+	const offending = `strict digests: 3 image entr(ies) without a digest:
+  foo-controller:1.1
+  foo-controller:latest
+  foo-webhook`
 
 	tests := []struct {
-		name                 string
-		input                reg.RegistryImagePath
-		expectedRegistryName reg.RegistryName
-		expectedImageName    reg.ImageName
-		expectedErr          error
+		name             string
+		input            string
+		strictDigests    bool
+		expectedErrorMsg string
 	}{
 		{
-			`basic gcr.io`,
-			`gcr.io/foo/a/b/c`,
-			`gcr.io/foo`,
-			`a/b/c`,
-			nil,
+			"Tag-only entries are allowed by default",
+			"strict-digests-option",
+			false,
+			"",
 		},
 		{
-			`regional GCR`,
-			`us.gcr.io/foo/a/b/c`,
-			`us.gcr.io/foo`,
-			`a/b/c`,
-			nil,
+			"Tag-only entries are rejected with the option",
+			"strict-digests-option",
+			true,
+			offending,
 		},
 		{
-			`regional GCR (extra level of nesting)`,
-			`eu.gcr.io/foo/d/e/f`,
-			`eu.gcr.io/foo/d`,
-			`e/f`,
-			nil,
+			"Tag-only entries are rejected by the manifest",
+			"strict-digests",
+			false,
+			offending,
 		},
 		{
-			`vanity GCR`,
-			`k8s.gcr.io/a/b/c`,
-			`k8s.gcr.io`,
-			`a/b/c`,
-			nil,
+			"Digests pinned everywhere",
+			"multiple-rebases",
+			true,
+			"",
 		},
 	}
+
 	for _, test := range tests {
-		rName, iName, err := reg.SplitRegistryImagePath(test.input, knownRegistryNames)
-		if test.expectedErr != nil {
-			require.NotNil(t, err)
-			require.Error(t, err, test.expectedErr)
-		} else {
-			require.Nil(t, err)
-		}
+		fixtureDir := getTestPath("TestParseThinManifestsFromDir", test.input)
 
-		require.Equal(t, rName, test.expectedRegistryName)
-		require.Equal(t, iName, test.expectedImageName)
-	}
-}
+		got, err := reg.ParseThinManifestsFromDirWithOptions(
+			fixtureDir,
+			reg.ThinManifestOptions{StrictDigests: test.strictDigests})
 
-func TestSplitByKnownRegistries(t *testing.T) {
-	knownRegistryNames := []reg.RegistryName{
-		// See
-		// https://github.com/kubernetes-sigs/k8s-container-image-promoter/issues/188.
-		`us.gcr.io/k8s-artifacts-prod/kube-state-metrics`,
-		`us.gcr.io/k8s-artifacts-prod/metrics-server`,
-		`us.gcr.io/k8s-artifacts-prod`,
-	}
-	knownRegistryContexts := make([]reg.RegistryContext, 0)
-	for _, knownRegistryName := range knownRegistryNames {
-		rc := reg.RegistryContext{}
-		rc.Name = knownRegistryName
-		knownRegistryContexts = append(knownRegistryContexts, rc)
+		if test.expectedErrorMsg == "" {
+			require.Nil(t, err, test.name)
+			for _, mfest := range got {
+				require.Equal(t, test.strictDigests, mfest.StrictDigests, test.name)
+			}
+			continue
+		}
+		require.NotNil(t, err, test.name)
+		require.Contains(t, err.Error(), test.expectedErrorMsg, test.name)
+
+		var mErr *reg.ManifestError
+		require.True(t, errors.As(err, &mErr), test.name)
+		require.Equal(
+			t,
+			filepath.Join(fixtureDir, "images/a/images.yaml"),
+			filepath.Clean(mErr.Filepath),
+			test.name)
 	}
+}
 
+func TestParseThinManifestsFromDirFilename(t *testing.T) {
 	tests := []struct {
-		name                 string
-		input                reg.RegistryName
-		expectedRegistryName reg.RegistryName
-		expectedImageName    reg.ImageName
-		expectedErr          error
+		name     string
+		filename string
+		changed  []string
+		expected []string
 	}{
 		{
-			`image at toplevel root path`,
-			`us.gcr.io/k8s-artifacts-prod/kube-state-metrics`,
-			`us.gcr.io/k8s-artifacts-prod`,
-			`kube-state-metrics`,
+			"Default filename",
+			"",
 			nil,
+			[]string{"manifests/b/promoter-manifest.yaml"},
 		},
 		{
-			`unclean split (known repo cuts into middle of image name)`,
-			`us.gcr.io/k8s-artifacts-prod/metrics-server-amd64`,
-			`us.gcr.io/k8s-artifacts-prod`,
-			`metrics-server-amd64`,
+			"Explicit default filename",
+			"promoter-manifest.yaml",
+			nil,
+			[]string{"manifests/b/promoter-manifest.yaml"},
+		},
+		{
+			"Custom filename",
+			"cip.yaml",
 			nil,
+			[]string{"manifests/a/cip.yaml"},
+		},
+		{
+			"Custom filename of a changed images file",
+			"cip.yaml",
+			[]string{"images/a/images.yaml", "images/b/images.yaml"},
+			[]string{"manifests/a/cip.yaml"},
 		},
 	}
+
 	for _, test := range tests {
-		rootReg, imageName, err := reg.SplitByKnownRegistries(test.input, knownRegistryContexts)
-		if test.expectedErr != nil {
-			require.NotNil(t, err)
-			require.Error(t, err, test.expectedErr)
+		fixtureDir := getTestPath("TestParseThinManifestsFromDir", "custom-filename")
+		opts := reg.ThinManifestOptions{Filename: test.filename}
+
+		var got []reg.Manifest
+		var err error
+		if test.changed != nil {
+			got, err = reg.ParseChangedThinManifestsFromDir(
+				fixtureDir,
+				test.changed,
+				opts)
 		} else {
-			require.Nil(t, err)
+			got, err = reg.ParseThinManifestsFromDirWithOptions(fixtureDir, opts)
 		}
+		require.Nil(t, err, test.name)
 
-		require.Equal(t, rootReg, test.expectedRegistryName)
-		require.Equal(t, imageName, test.expectedImageName)
-	}
-}
-
-func TestCommandGeneration(t *testing.T) {
-	destRC := reg.RegistryContext{
-		Name:           "gcr.io/foo",
-		ServiceAccount: "robot",
+		gotPaths := make([]string, 0)
+		for _, mfest := range got {
+			rel, err := filepath.Rel(fixtureDir, mfest.Filepath)
+			require.Nil(t, err)
+			gotPaths = append(gotPaths, rel)
+		}
+		require.ElementsMatch(t, test.expected, gotPaths, test.name)
 	}
 
-	var (
-		srcRegName    reg.RegistryName = "gcr.io/bar"
-		srcImageName  reg.ImageName    = "baz"
-		destImageName reg.ImageName    = "baz"
-		digest        reg.Digest       = "sha256:000"
-		tag           reg.Tag          = "1.0"
-		tp            reg.TagOp
-	)
-
-	t.Run(
-		"GetDeleteCmd",
-		func(t *testing.T) {
-			got := reg.GetDeleteCmd(
-				destRC,
-				true,
-				destImageName,
-				digest,
-				false)
+	// No manifest has the name.
+	_, err := reg.ParseThinManifestsFromDirWithOptions(
+		getTestPath("TestParseThinManifestsFromDir", "custom-filename"),
+		reg.ThinManifestOptions{Filename: "nope.yaml"})
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "no manifests found in dir")
+}
 
-			expected := []string{
-				"gcloud",
-				"--account=robot",
-				"container",
-				"images",
-				"delete",
-				reg.ToFQIN(destRC.Name, destImageName, digest),
-				"--format=json",
-			}
+func TestValidateStrictDigests(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expectedError error
+	}{
+		{
+			"Digests pinned everywhere",
+			`registries:
+- name: gcr.io/foo
+  src: true
+- name: gcr.io/bar
+strictDigests: true
+images:
+- name: agave
+  dmap:
+    "sha256:aab34c5841987a1b133388fa9f27e7960c4b1307e2f9147dca407ba26af48a54": ["latest"]
+`,
+			nil,
+		},
+		{
+			"Tag-only entries",
+			`registries:
+- name: gcr.io/foo
+  src: true
+- name: gcr.io/bar
+strictDigests: true
+images:
+- name: agave
+  dmap:
+    "": ["1.0"]
+- name: banana
+  dmap:
+    "sha256:07353f7b26327f0d933515a22b1de587b040d3d85c464ea299c1b9f242529326": ["1.8.3"]
+    "1.9.0": []
+`,
+			fmt.Errorf(`strict digests: 2 image entr(ies) without a digest:
+  agave:1.0
+  banana`),
+		},
+		{
+			"Tag-only entry without strict digests",
+			`registries:
+- name: gcr.io/foo
+  src: true
+- name: gcr.io/bar
+images:
+- name: agave
+  dmap:
+    "": ["1.0"]
+`,
+			fmt.Errorf("invalid digest: "),
+		},
+	}
 
-			require.Equal(t, got, expected)
+	for _, test := range tests {
+		_, err := reg.ParseManifestYAML([]byte(test.input))
+		if test.expectedError == nil {
+			require.Nil(t, err, test.name)
+			continue
+		}
+		require.NotNil(t, err, test.name)
+		require.Equal(t, test.expectedError.Error(), err.Error(), test.name)
+	}
+}
 
-			got = reg.GetDeleteCmd(
-				destRC,
-				false,
-				destImageName,
-				digest,
-				false,
-			)
+func TestParseChangedThinManifestsFromDir(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		changed  []string
+		expected []string
+	}{
+		{
+			"Subset of manifests",
+			"multiple-rebases",
+			[]string{"manifests/b/promoter-manifest.yaml"},
+			[]string{"manifests/b/promoter-manifest.yaml"},
+		},
+		{
+			"Changed images file",
+			"multiple-rebases",
+			[]string{"images/a/images.yaml"},
+			[]string{"manifests/a/promoter-manifest.yaml"},
+		},
+		{
+			"Paths leading into the directory",
+			"multiple-rebases",
+			[]string{
+				getTestPath(
+					"TestParseThinManifestsFromDir",
+					"multiple-rebases",
+					"manifests/a/promoter-manifest.yaml"),
+			},
+			[]string{"manifests/a/promoter-manifest.yaml"},
+		},
+		{
+			"Nonexistent and unrelated files are skipped",
+			"multiple-rebases",
+			[]string{
+				"manifests/deleted/promoter-manifest.yaml",
+				"images/deleted/images.yaml",
+				"README.md",
+				"../elsewhere/manifests/a/promoter-manifest.yaml",
+				"images/b/images.yaml",
+			},
+			[]string{"manifests/b/promoter-manifest.yaml"},
+		},
+		{
+			"Only nonexistent files",
+			"multiple-rebases",
+			[]string{"manifests/deleted/promoter-manifest.yaml"},
+			[]string{},
+		},
+		{
+			"Changed defaults affect all manifests",
+			"defaults",
+			[]string{"defaults.yaml"},
+			[]string{
+				"manifests/a/promoter-manifest.yaml",
+				"manifests/b/promoter-manifest.yaml",
+			},
+		},
+	}
 
-			expected = []string{
-				"gcloud",
-				"container",
-				"images",
-				"delete",
-				reg.ToFQIN(destRC.Name, destImageName, digest),
-				"--format=json",
-			}
+	for _, test := range tests {
+		fixtureDir := getTestPath("TestParseThinManifestsFromDir", test.input)
 
-			require.Equal(t, got, expected)
-		},
-	)
+		got, err := reg.ParseChangedThinManifestsFromDir(
+			fixtureDir,
+			test.changed,
+			reg.ThinManifestOptions{})
+		require.Nil(t, err, test.name)
 
-	t.Run(
-		"GetWriteCmd (Delete)",
-		func(t *testing.T) {
-			tp = reg.Delete
+		gotPaths := make([]string, 0)
+		for _, mfest := range got {
+			rel, err := filepath.Rel(fixtureDir, mfest.Filepath)
+			require.Nil(t, err)
+			gotPaths = append(gotPaths, rel)
+		}
+		require.ElementsMatch(t, test.expected, gotPaths, test.name)
+	}
+}
 
-			got := reg.GetWriteCmd(
-				destRC,
-				true,
-				srcRegName,
-				srcImageName,
-				destImageName,
-				digest,
-				tag,
-				tp,
-			)
+// failingGitDiff is a git diff process that fails, e.g. because of an unknown
+// ref.
+type failingGitDiff struct {
+	stream.Fake
+}
 
-			expected := []string{
-				"gcloud",
-				"--account=robot",
-				"--quiet",
-				"container",
-				"images",
-				"untag",
-				reg.ToPQIN(destRC.Name, destImageName, tag),
-			}
+func (*failingGitDiff) Close() error {
+	return fmt.Errorf("exit status 128")
+}
 
-			require.Equal(t, got, expected)
+func TestParseThinManifestsFromDirSinceRef(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		diff          string
+		failDiff      bool
+		expected      []string
+		expectedError string
+	}{
+		{
+			"Changed manifest",
+			"multiple-rebases",
+			"manifests/b/promoter-manifest.yaml\n",
+			false,
+			[]string{"manifests/b/promoter-manifest.yaml"},
+			"",
+		},
+		{
+			"Changed images file and unrelated files",
+			"multiple-rebases",
+			"README.md\nimages/a/images.yaml\n\nmanifests/deleted/promoter-manifest.yaml\n",
+			false,
+			[]string{"manifests/a/promoter-manifest.yaml"},
+			"",
+		},
+		{
+			"No changes",
+			"multiple-rebases",
+			"",
+			false,
+			[]string{},
+			"",
+		},
+		{
+			"Changed defaults affect all manifests",
+			"defaults",
+			"defaults.yaml\n",
+			false,
+			[]string{
+				"manifests/a/promoter-manifest.yaml",
+				"manifests/b/promoter-manifest.yaml",
+			},
+			"",
+		},
+		{
+			"Failing diff",
+			"multiple-rebases",
+			"",
+			true,
+			nil,
+			"exit status 128",
+		},
+	}
 
-			got = reg.GetWriteCmd(
-				destRC,
-				false,
-				srcRegName,
-				srcImageName,
-				destImageName,
-				digest,
-				tag,
-				tp,
-			)
+	for _, test := range tests {
+		fixtureDir := getTestPath("TestParseThinManifestsFromDir", test.input)
 
-			expected = []string{
-				"gcloud",
-				"--quiet",
-				"container",
-				"images",
-				"untag",
-				reg.ToPQIN(destRC.Name, destImageName, tag),
+		var gotDir, gotRef string
+		mkGitDiffCmd := func(dir, ref string) stream.Producer {
+			gotDir, gotRef = dir, ref
+			if test.failDiff {
+				return &failingGitDiff{}
 			}
+			return &stream.Fake{Bytes: []byte(test.diff)}
+		}
 
-			require.Equal(t, got, expected)
-		},
-	)
+		got, err := reg.ParseThinManifestsFromDirSinceRef(
+			fixtureDir,
+			"origin/main",
+			mkGitDiffCmd,
+			reg.ThinManifestOptions{})
+		require.Equal(t, fixtureDir, gotDir, test.name)
+		require.Equal(t, "origin/main", gotRef, test.name)
+		if test.expectedError != "" {
+			require.NotNil(t, err, test.name)
+			require.Contains(t, err.Error(), test.expectedError, test.name)
+			continue
+		}
+		require.Nil(t, err, test.name)
+
+		gotPaths := make([]string, 0)
+		for _, mfest := range got {
+			rel, err := filepath.Rel(fixtureDir, mfest.Filepath)
+			require.Nil(t, err)
+			gotPaths = append(gotPaths, rel)
+		}
+		require.ElementsMatch(t, test.expected, gotPaths, test.name)
+	}
 }
 
-// TestReadRegistries tests reading images and tags from a registry.
-func TestReadRegistries(t *testing.T) {
-	const fakeRegName reg.RegistryName = "gcr.io/foo"
+func TestThinManifestDefaultsMerge(t *testing.T) {
+	srcRC := reg.RegistryContext{
+		Name: "gcr.io/foo-staging",
+		Src:  true,
+	}
+	destRC := reg.RegistryContext{
+		Name:           "us.gcr.io/some-prod",
+		ServiceAccount: "sa@robot.com",
+	}
+	destRCOverride := reg.RegistryContext{
+		Name:           "us.gcr.io/some-prod",
+		ServiceAccount: "override@robot.com",
+	}
 
 	tests := []struct {
 		name           string
-		input          map[string]string
-		expectedOutput reg.RegInvImage
+		defaults       reg.ThinManifestDefaults
+		input          []reg.RegistryContext
+		expectedOutput []reg.RegistryContext
+		expectedErr    error
 	}{
 		{
-			"Only toplevel repos (no child repos)",
-			map[string]string{
-				"gcr.io/foo": `{
-  "child": [
-    "addon-resizer",
-    "pause"
-  ],
-  "manifest": {},
-  "name": "foo",
-  "tags": []
-}`,
-				"gcr.io/foo/addon-resizer": `{
-  "child": [],
-  "manifest": {
-    "sha256:b5b2d91319f049143806baeacc886f82f621e9a2550df856b11b5c22db4570a7": {
-      "imageSizeBytes": "12875324",
-      "layerId": "",
-      "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
-      "tag": [
-        "latest"
-      ],
-      "timeCreatedMs": "1501774217070",
-      "timeUploadedMs": "1552917295327"
-    },
-    "sha256:0519a83e8f217e33dd06fe7a7347444cfda5e2e29cf52aaa24755999cb104a4d": {
-      "imageSizeBytes": "12875324",
-      "layerId": "",
-      "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
-      "tag": [
-        "1.0"
-      ],
-      "timeCreatedMs": "1501774217070",
-      "timeUploadedMs": "1552917295327"
-    }
-  },
-  "name": "foo/addon-resizer",
-  "tags": [
-    "latest",
-    "1.0"
-  ]
-}`,
-				"gcr.io/foo/pause": `{
-  "child": [],
-  "manifest": {
-    "sha256:06fdf10aae2eeeac5a82c213e4693f82ab05b3b09b820fce95a7cac0bbdad534": {
-      "imageSizeBytes": "12875324",
-      "layerId": "",
-      "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
-      "tag": [
-        "v1.2.3"
-      ],
-      "timeCreatedMs": "1501774217070",
-      "timeUploadedMs": "1552917295327"
-    }
-  },
-  "name": "foo/pause",
-  "tags": [
-    "v1.2.3"
-  ]
-}`,
+			"No defaults",
+			reg.ThinManifestDefaults{},
+			[]reg.RegistryContext{srcRC, destRC},
+			[]reg.RegistryContext{srcRC, destRC},
+			nil,
+		},
+		{
+			"Inherit all defaults",
+			reg.ThinManifestDefaults{
+				Registries: []reg.RegistryContext{destRC},
 			},
-			reg.RegInvImage{
-				"addon-resizer": {
-					"sha256:b5b2d91319f049143806baeacc886f82f621e9a2550df856b11b5c22db4570a7": {"latest"},
-					"sha256:0519a83e8f217e33dd06fe7a7347444cfda5e2e29cf52aaa24755999cb104a4d": {"1.0"},
-				},
-				"pause": {
-					"sha256:06fdf10aae2eeeac5a82c213e4693f82ab05b3b09b820fce95a7cac0bbdad534": {"v1.2.3"},
+			[]reg.RegistryContext{srcRC},
+			[]reg.RegistryContext{srcRC, destRC},
+			nil,
+		},
+		{
+			"Override a default",
+			reg.ThinManifestDefaults{
+				Registries: []reg.RegistryContext{destRC},
+			},
+			[]reg.RegistryContext{srcRC, destRCOverride},
+			[]reg.RegistryContext{srcRC, destRCOverride},
+			nil,
+		},
+		{
+			"Inherit a default source registry",
+			reg.ThinManifestDefaults{
+				Registries: []reg.RegistryContext{srcRC, destRC},
+			},
+			[]reg.RegistryContext{},
+			[]reg.RegistryContext{srcRC, destRC},
+			nil,
+		},
+		{
+			"Conflicting source registries",
+			reg.ThinManifestDefaults{
+				Registries: []reg.RegistryContext{srcRC, destRC},
+			},
+			[]reg.RegistryContext{
+				{
+					Name: "gcr.io/bar-staging",
+					Src:  true,
 				},
 			},
+			nil,
+			fmt.Errorf(
+				"default source registry %q conflicts with source registry %q",
+				"gcr.io/foo-staging",
+				"gcr.io/bar-staging",
+			),
 		},
+	}
+
+	for _, test := range tests {
+		got, err := test.defaults.Merge(test.input)
+		if test.expectedErr != nil {
+			require.Equal(t, test.expectedErr, err, test.name)
+			continue
+		}
+
+		require.Nil(t, err, test.name)
+		require.Equal(t, test.expectedOutput, got, test.name)
+	}
+}
+
+func TestParseThinManifestDefaultsYAML(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		expectedErr error
+	}{
 		{
-			"Recursive repos (child repos)",
-			map[string]string{
-				"gcr.io/foo": `{
-  "child": [
-    "addon-resizer",
-    "pause"
-  ],
-  "manifest": {},
-  "name": "foo",
-  "tags": []
-}`,
-				"gcr.io/foo/addon-resizer": `{
-  "child": [],
-  "manifest": {
-    "sha256:b5b2d91319f049143806baeacc886f82f621e9a2550df856b11b5c22db4570a7": {
-      "imageSizeBytes": "12875324",
-      "layerId": "",
-      "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
-      "tag": [
-        "latest"
-      ],
-      "timeCreatedMs": "1501774217070",
-      "timeUploadedMs": "1552917295327"
-    },
-    "sha256:0519a83e8f217e33dd06fe7a7347444cfda5e2e29cf52aaa24755999cb104a4d": {
-      "imageSizeBytes": "12875324",
-      "layerId": "",
-      "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
-      "tag": [
-        "1.0"
-      ],
-      "timeCreatedMs": "1501774217070",
-      "timeUploadedMs": "1552917295327"
-    }
-  },
-  "name": "foo/addon-resizer",
-  "tags": [
-    "latest",
-    "1.0"
-  ]
-}`,
-				"gcr.io/foo/pause": `{
-  "child": [
-    "childLevel1"
-  ],
-  "manifest": {
-    "sha256:06fdf10aae2eeeac5a82c213e4693f82ab05b3b09b820fce95a7cac0bbdad534": {
-      "imageSizeBytes": "12875324",
-      "layerId": "",
-      "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
-      "tag": [
-        "v1.2.3"
-      ],
-      "timeCreatedMs": "1501774217070",
-      "timeUploadedMs": "1552917295327"
-    }
-  },
-  "name": "foo/pause",
-  "tags": [
-    "v1.2.3"
-  ]
-}`,
-				"gcr.io/foo/pause/childLevel1": `{
-  "child": [
-    "childLevel2"
-  ],
-  "manifest": {
-    "sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa": {
-      "imageSizeBytes": "12875324",
-      "layerId": "",
-      "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
-      "tag": [
-        "aaa"
-      ],
-      "timeCreatedMs": "1501774217070",
-      "timeUploadedMs": "1552917295327"
-    }
-  },
-  "name": "foo/pause/childLevel1",
-  "tags": [
-    "aaa"
-  ]
-}`,
-				"gcr.io/foo/pause/childLevel1/childLevel2": `{
-  "child": [],
-  "manifest": {
-    "sha256:ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff": {
-      "imageSizeBytes": "12875324",
-      "layerId": "",
-      "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
-      "tag": [
-        "fff"
-      ],
-      "timeCreatedMs": "1501774217070",
-      "timeUploadedMs": "1552917295327"
-    }
-  },
-  "name": "foo/pause/childLevel1/childLevel2",
-  "tags": [
-    "fff"
-  ]
-}`,
+			"Valid defaults",
+			`registries:
+- name: us.gcr.io/some-prod
+  service-account: sa@robot.com
+- name: eu.gcr.io/some-prod
+  service-account: sa@robot.com
+`,
+			nil,
+		},
+		{
+			"Duplicate registry",
+			`registries:
+- name: us.gcr.io/some-prod
+- name: us.gcr.io/some-prod
+`,
+			fmt.Errorf(`defaults: duplicate registry "us.gcr.io/some-prod"`),
+		},
+		{
+			"Multiple source registries",
+			`registries:
+- name: gcr.io/foo-staging
+  src: true
+- name: gcr.io/bar-staging
+  src: true
+`,
+			fmt.Errorf("defaults: cannot have more than 1 source registry"),
+		},
+	}
+
+	for _, test := range tests {
+		_, err := reg.ParseThinManifestDefaultsYAML([]byte(test.input))
+		if test.expectedErr != nil {
+			require.Equal(t, test.expectedErr.Error(), err.Error(), test.name)
+			continue
+		}
+
+		require.Nil(t, err, test.name)
+	}
+}
+
+func TestValidateThinManifestsFromDir(t *testing.T) {
+	shouldBeValid := []string{
+		"singleton",
+		"multiple-rebases",
+		"overlapping-src-registries",
+		"overlapping-destination-vertices-same-digest",
+		"malformed-directory-tree-structure-bad-prefix-is-ignored",
+	}
+
+	pwd := getTestPath("TestValidateThinManifestsFromDir")
+
+	for _, testInput := range shouldBeValid {
+		fixtureDir := filepath.Join(pwd, "valid", testInput)
+
+		mfests, errParse := reg.ParseThinManifestsFromDir(fixtureDir)
+		require.Nil(t, errParse)
+
+		_, edgeErr := reg.ToPromotionEdges(mfests)
+		require.Nil(t, edgeErr)
+	}
+
+	shouldBeInvalid := []struct {
+		dirName            string
+		expectedParseError error
+		expectedEdgeError  error
+	}{
+		{
+			"empty",
+			&os.PathError{
+				Op:   "stat",
+				Path: filepath.Join(pwd, "invalid/empty/images"),
+				Err:  fmt.Errorf("no such file or directory"),
+			},
+			nil,
+		},
+		{
+			"overlapping-destination-vertices-different-digest",
+			nil,
+			fmt.Errorf("overlapping edges detected"),
+		},
+		{
+			"malformed-directory-tree-structure",
+			fmt.Errorf(
+				"corresponding file %q does not exist",
+				filepath.Join(pwd, "invalid/malformed-directory-tree-structure/images/b/images.yaml"),
+			),
+			nil,
+		},
+		{
+			"malformed-directory-tree-structure-nested",
+			fmt.Errorf(
+				"unexpected manifest path %q",
+				filepath.Join(pwd, "invalid/malformed-directory-tree-structure-nested/manifests/b/c/promoter-manifest.yaml"),
+			),
+			nil,
+		},
+	}
+
+	for _, test := range shouldBeInvalid {
+		fixtureDir := getTestPath("TestValidateThinManifestsFromDir", "invalid", test.dirName)
+
+		// It could be that a manifest, taken individually, failed on its own,
+		// before we even get to ValidateThinManifestsFromDir(). So handle these
+		// cases as well.
+		mfests, errParse := reg.ParseThinManifestsFromDir(fixtureDir)
+		if test.expectedParseError != nil {
+			require.NotNil(t, errParse)
+			require.Error(t, errParse, test.expectedParseError)
+		} else {
+			require.Nil(t, errParse)
+		}
+
+		_, edgeErr := reg.ToPromotionEdges(mfests)
+		if test.expectedEdgeError != nil {
+			require.NotNil(t, edgeErr)
+			require.Error(t, edgeErr, test.expectedEdgeError)
+		} else {
+			require.Nil(t, edgeErr)
+		}
+	}
+}
+
+func TestParseImageDigest(t *testing.T) {
+	shouldBeValid := []string{
+		`sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef`,
+		`sha256:0000000000000000000000000000000000000000000000000000000000000000`,
+		`sha256:ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff`,
+		`sha256:3243f6a8885a308d313198a2e03707344a4093822299f31d0082efa98ec4e6c8`,
+	}
+
+	for _, testInput := range shouldBeValid {
+		d := reg.Digest(testInput)
+		require.NotEmpty(t, d)
+
+		err := reg.ValidateDigest(d)
+		require.Nil(t, err)
+	}
+
+	shouldBeInvalid := []string{
+		// Empty.
+		``,
+		// Too short.
+		`sha256:0`,
+		// Too long.
+		`sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef1`,
+		// Invalid character 'x'.
+		`sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdex`,
+		// No prefix 'sha256'.
+		`0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef`,
+	}
+
+	for _, testInput := range shouldBeInvalid {
+		d := reg.Digest(testInput)
+		err := reg.ValidateDigest(d)
+		require.NotNil(t, err)
+	}
+}
+
+func TestParseImageTag(t *testing.T) {
+	shouldBeValid := []string{
+		`a`,
+		`_`,
+		`latest`,
+		`_latest`,
+		// Awkward, but valid.
+		`_____----hello........`,
+		// Longest tag is 128 chars.
+		`this-is-exactly-128-chars-this-is-exactly-128-chars-this-is-exactly-128-chars-this-is-exactly-128-chars-this-is-exactly-128-char`,
+	}
+
+	for _, testInput := range shouldBeValid {
+		tag := reg.Tag(testInput)
+		err := reg.ValidateTag(tag)
+		require.Nil(t, err)
+	}
+
+	shouldBeInvalid := []string{
+		// Empty.
+		``,
+		// Does not begin with an ASCII word character.
+		`.`,
+		// Does not begin with an ASCII word character.
+		`-`,
+		// Unicode not allowed.
+		`안녕`,
+		// No spaces allowed.
+		`a b`,
+		// Too long (>128 ASCII chars).
+		`this-is-longer-than-128-chars-this-is-longer-than-128-chars-this-is-longer-than-128-chars-this-is-longer-than-128-chars-this-is-l`,
+	}
+
+	for _, testInput := range shouldBeInvalid {
+		tag := reg.Tag(testInput)
+		err := reg.ValidateTag(tag)
+		require.NotNil(t, err)
+	}
+}
+
+func TestValidateRegistryImagePath(t *testing.T) {
+	shouldBeValid := []string{
+		`gcr.io/foo/bar`,
+		`k8s.gcr.io/foo`,
+		`staging-k8s.gcr.io/foo`,
+		`staging-k8s.gcr.io/foo/bar/nested/path/image`,
+	}
+
+	for _, testInput := range shouldBeValid {
+		rip := reg.RegistryImagePath(testInput)
+		require.NotEmpty(t, rip)
+
+		err := reg.ValidateRegistryImagePath(rip)
+		require.Nil(t, err)
+	}
+
+	shouldBeInvalid := []string{
+		// Empty.
+		``,
+		// No dot.
+		`gcrio`,
+		// Too many dots.
+		`gcr..io`,
+		// Leading dot.
+		`.gcr.io`,
+		// Trailing dot.
+		`gcr.io.`,
+		// Too many slashes.
+		`gcr.io//foo`,
+		// Leading slash.
+		`/gcr.io`,
+		// Trailing slash (1).
+		`gcr.io/`,
+		// Trailing slash (2).
+		`gcr.io/foo/`,
+	}
+
+	for _, testInput := range shouldBeInvalid {
+		rip := reg.RegistryImagePath(testInput)
+		err := reg.ValidateRegistryImagePath(rip)
+		require.NotNil(t, err)
+	}
+}
+
+func TestSplitRegistryImagePath(t *testing.T) {
+	knownRegistryNames := []reg.RegistryName{
+		`gcr.io/foo`,
+		`us.gcr.io/foo`,
+		`k8s.gcr.io`,
+		`eu.gcr.io/foo/d`,
+	}
+
+	tests := []struct {
+		name                 string
+		input                reg.RegistryImagePath
+		expectedRegistryName reg.RegistryName
+		expectedImageName    reg.ImageName
+		expectedErr          error
+	}{
+		{
+			`basic gcr.io`,
+			`gcr.io/foo/a/b/c`,
+			`gcr.io/foo`,
+			`a/b/c`,
+			nil,
+		},
+		{
+			`regional GCR`,
+			`us.gcr.io/foo/a/b/c`,
+			`us.gcr.io/foo`,
+			`a/b/c`,
+			nil,
+		},
+		{
+			`regional GCR (extra level of nesting)`,
+			`eu.gcr.io/foo/d/e/f`,
+			`eu.gcr.io/foo/d`,
+			`e/f`,
+			nil,
+		},
+		{
+			`vanity GCR`,
+			`k8s.gcr.io/a/b/c`,
+			`k8s.gcr.io`,
+			`a/b/c`,
+			nil,
+		},
+	}
+	for _, test := range tests {
+		rName, iName, err := reg.SplitRegistryImagePath(test.input, knownRegistryNames)
+		if test.expectedErr != nil {
+			require.NotNil(t, err)
+			require.Error(t, err, test.expectedErr)
+		} else {
+			require.Nil(t, err)
+		}
+
+		require.Equal(t, rName, test.expectedRegistryName)
+		require.Equal(t, iName, test.expectedImageName)
+	}
+}
+
+func TestSplitByKnownRegistries(t *testing.T) {
+	knownRegistryNames := []reg.RegistryName{
+		// See
+		// https://github.com/kubernetes-sigs/k8s-container-image-promoter/issues/188.
+		`us.gcr.io/k8s-artifacts-prod/kube-state-metrics`,
+		`us.gcr.io/k8s-artifacts-prod/metrics-server`,
+		`us.gcr.io/k8s-artifacts-prod`,
+	}
+	knownRegistryContexts := make([]reg.RegistryContext, 0)
+	for _, knownRegistryName := range knownRegistryNames {
+		rc := reg.RegistryContext{}
+		rc.Name = knownRegistryName
+		knownRegistryContexts = append(knownRegistryContexts, rc)
+	}
+
+	tests := []struct {
+		name                 string
+		input                reg.RegistryName
+		expectedRegistryName reg.RegistryName
+		expectedImageName    reg.ImageName
+		expectedErr          error
+	}{
+		{
+			`image at toplevel root path`,
+			`us.gcr.io/k8s-artifacts-prod/kube-state-metrics`,
+			`us.gcr.io/k8s-artifacts-prod`,
+			`kube-state-metrics`,
+			nil,
+		},
+		{
+			`unclean split (known repo cuts into middle of image name)`,
+			`us.gcr.io/k8s-artifacts-prod/metrics-server-amd64`,
+			`us.gcr.io/k8s-artifacts-prod`,
+			`metrics-server-amd64`,
+			nil,
+		},
+	}
+	for _, test := range tests {
+		rootReg, imageName, err := reg.SplitByKnownRegistries(test.input, knownRegistryContexts)
+		if test.expectedErr != nil {
+			require.NotNil(t, err)
+			require.Error(t, err, test.expectedErr)
+		} else {
+			require.Nil(t, err)
+		}
+
+		require.Equal(t, rootReg, test.expectedRegistryName)
+		require.Equal(t, imageName, test.expectedImageName)
+	}
+}
+
+func TestCommandGeneration(t *testing.T) {
+	destRC := reg.RegistryContext{
+		Name:           "gcr.io/foo",
+		ServiceAccount: "robot",
+	}
+
+	var (
+		srcRegName    reg.RegistryName = "gcr.io/bar"
+		srcImageName  reg.ImageName    = "baz"
+		destImageName reg.ImageName    = "baz"
+		digest        reg.Digest       = "sha256:000"
+		tag           reg.Tag          = "1.0"
+		tp            reg.TagOp
+	)
+
+	t.Run(
+		"GetDeleteCmd",
+		func(t *testing.T) {
+			got := reg.GetDeleteCmd(
+				destRC,
+				true,
+				destImageName,
+				digest,
+				false)
+
+			expected := []string{
+				"gcloud",
+				"--account=robot",
+				"container",
+				"images",
+				"delete",
+				reg.ToFQIN(destRC.Name, destImageName, digest),
+				"--format=json",
+			}
+
+			require.Equal(t, got, expected)
+
+			got = reg.GetDeleteCmd(
+				destRC,
+				false,
+				destImageName,
+				digest,
+				false,
+			)
+
+			expected = []string{
+				"gcloud",
+				"container",
+				"images",
+				"delete",
+				reg.ToFQIN(destRC.Name, destImageName, digest),
+				"--format=json",
+			}
+
+			require.Equal(t, got, expected)
+		},
+	)
+
+	t.Run(
+		"GetWriteCmd (Delete)",
+		func(t *testing.T) {
+			tp = reg.Delete
+
+			got := reg.GetWriteCmd(
+				destRC,
+				true,
+				srcRegName,
+				srcImageName,
+				destImageName,
+				digest,
+				tag,
+				tp,
+				nil,
+			)
+
+			expected := []string{
+				"gcloud",
+				"--account=robot",
+				"--quiet",
+				"container",
+				"images",
+				"untag",
+				reg.ToPQIN(destRC.Name, destImageName, tag),
+			}
+
+			require.Equal(t, got, expected)
+
+			got = reg.GetWriteCmd(
+				destRC,
+				false,
+				srcRegName,
+				srcImageName,
+				destImageName,
+				digest,
+				tag,
+				tp,
+				nil,
+			)
+
+			expected = []string{
+				"gcloud",
+				"--quiet",
+				"container",
+				"images",
+				"untag",
+				reg.ToPQIN(destRC.Name, destImageName, tag),
+			}
+
+			require.Equal(t, got, expected)
+		},
+	)
+
+	t.Run(
+		"GetWriteCmd (Add)",
+		func(t *testing.T) {
+			srcImage := reg.ToFQIN(srcRegName, srcImageName, digest)
+			dstImage := reg.ToPQIN(destRC.Name, destImageName, tag)
+
+			tests := []struct {
+				name              string
+				tool              string
+				useServiceAccount bool
+				tag               reg.Tag
+				expected          []string
+			}{
+				{
+					"crane",
+					reg.CopyToolCrane,
+					true,
+					tag,
+					[]string{"crane", "copy", srcImage, dstImage},
+				},
+				{
+					"skopeo",
+					reg.CopyToolSkopeo,
+					true,
+					tag,
+					[]string{
+						"skopeo",
+						"copy",
+						"--all",
+						"docker://" + srcImage,
+						"docker://" + dstImage,
+					},
+				},
+				{
+					"gcloud",
+					reg.CopyToolGcloud,
+					true,
+					tag,
+					[]string{
+						"gcloud",
+						"--account=robot",
+						"--quiet",
+						"container",
+						"images",
+						"add-tag",
+						srcImage,
+						dstImage,
+					},
+				},
+				{
+					"gcloud without service account",
+					reg.CopyToolGcloud,
+					false,
+					tag,
+					[]string{
+						"gcloud",
+						"--quiet",
+						"container",
+						"images",
+						"add-tag",
+						srcImage,
+						dstImage,
+					},
+				},
+				{
+					"tagless",
+					reg.CopyToolCrane,
+					true,
+					"",
+					[]string{
+						"crane",
+						"copy",
+						srcImage,
+						reg.ToFQIN(destRC.Name, destImageName, digest),
+					},
+				},
+			}
+
+			for _, test := range tests {
+				tool, err := reg.MkCopyTool(test.tool)
+				require.Nil(t, err)
+				require.Equal(t, test.tool, tool.Name())
+
+				got := reg.GetWriteCmd(
+					destRC,
+					test.useServiceAccount,
+					srcRegName,
+					srcImageName,
+					destImageName,
+					digest,
+					test.tag,
+					reg.Add,
+					tool,
+				)
+				require.Equal(t, test.expected, got, test.name)
+			}
+
+			// Without a tool, the commands are rendered for crane.
+			got := reg.GetWriteCmd(
+				destRC,
+				true,
+				srcRegName,
+				srcImageName,
+				destImageName,
+				digest,
+				tag,
+				reg.Add,
+				nil,
+			)
+			require.Equal(t, []string{"crane", "copy", srcImage, dstImage}, got)
+
+			_, err := reg.MkCopyTool("docker")
+			require.NotNil(t, err)
+		},
+	)
+
+	t.Run(
+		"GetWriteCmd (Retag)",
+		func(t *testing.T) {
+			// The image is already in the destination, so it is the source of
+			// the (tag-only) write.
+			image := reg.ToFQIN(destRC.Name, destImageName, digest)
+			dstImage := reg.ToPQIN(destRC.Name, destImageName, tag)
+
+			tests := []struct {
+				name     string
+				tool     string
+				expected []string
+			}{
+				{
+					"crane",
+					reg.CopyToolCrane,
+					[]string{"crane", "tag", image, string(tag)},
+				},
+				{
+					"skopeo",
+					reg.CopyToolSkopeo,
+					[]string{
+						"skopeo",
+						"copy",
+						"--all",
+						"docker://" + image,
+						"docker://" + dstImage,
+					},
+				},
+				{
+					"gcloud",
+					reg.CopyToolGcloud,
+					[]string{
+						"gcloud",
+						"--account=robot",
+						"--quiet",
+						"container",
+						"images",
+						"add-tag",
+						image,
+						dstImage,
+					},
+				},
+			}
+
+			for _, test := range tests {
+				tool, err := reg.MkCopyTool(test.tool)
+				require.Nil(t, err)
+
+				got := reg.GetWriteCmd(
+					destRC,
+					true,
+					srcRegName,
+					srcImageName,
+					destImageName,
+					digest,
+					tag,
+					reg.Retag,
+					tool,
+				)
+				require.Equal(t, test.expected, got, test.name)
+			}
+
+			// Without a tool, the commands are rendered for crane.
+			got := reg.GetWriteCmd(
+				destRC,
+				true,
+				srcRegName,
+				srcImageName,
+				destImageName,
+				digest,
+				tag,
+				reg.Retag,
+				nil,
+			)
+			require.Equal(t, []string{"crane", "tag", image, string(tag)}, got)
+		},
+	)
+
+	t.Run(
+		"Per-registry service accounts",
+		func(t *testing.T) {
+			destRC2 := reg.RegistryContext{
+				Name:           "gcr.io/other-project",
+				ServiceAccount: "other-robot",
+			}
+
+			for _, rc := range []reg.RegistryContext{destRC, destRC2} {
+				got := reg.GetWriteCmd(
+					rc,
+					true,
+					srcRegName,
+					srcImageName,
+					destImageName,
+					digest,
+					tag,
+					reg.Delete,
+					nil,
+				)
+				require.Equal(t, "--account="+rc.ServiceAccount, got[1])
+
+				got = reg.GetDeleteCmd(
+					rc,
+					true,
+					destImageName,
+					digest,
+					false,
+				)
+				require.Equal(t, "--account="+rc.ServiceAccount, got[1])
+			}
+		},
+	)
+}
+
+func TestKeychain(t *testing.T) {
+	sc := reg.SyncContext{
+		Tokens: map[reg.RootRepo]gcloud.Token{
+			"gcr.io/foo-staging": "staging-token",
+			"gcr.io/foo":         "prod-token",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		ref      string
+		expected authn.Authenticator
+	}{
+		{
+			"Source registry",
+			"gcr.io/foo-staging/bar/baz:1.0",
+			&authn.Basic{
+				Username: "oauth2accesstoken",
+				Password: "staging-token",
+			},
+		},
+		{
+			"Destination registry",
+			"gcr.io/foo/bar/baz@sha256:0000000000000000000000000000000000000000000000000000000000000000",
+			&authn.Basic{
+				Username: "oauth2accesstoken",
+				Password: "prod-token",
+			},
+		},
+	}
+
+	keychain := sc.Keychain()
+	for _, test := range tests {
+		ref, err := name.ParseReference(test.ref)
+		require.Nil(t, err)
+
+		got, err := keychain.Resolve(ref.Context())
+		require.Nil(t, err)
+		require.Equal(t, test.expected, got, test.name)
+	}
+
+	// Registries without a token fall back to the default keychain.
+	ref, err := name.ParseReference("gcr.io/unknown/baz:1.0")
+	require.Nil(t, err)
+	_, err = keychain.Resolve(ref.Context())
+	require.Nil(t, err)
+}
+
+// TestReadRegistries tests reading images and tags from a registry.
+func TestReadRegistries(t *testing.T) {
+	const fakeRegName reg.RegistryName = "gcr.io/foo"
+
+	tests := []struct {
+		name           string
+		input          map[string]string
+		expectedOutput reg.RegInvImage
+	}{
+		{
+			"Only toplevel repos (no child repos)",
+			map[string]string{
+				"gcr.io/foo": `{
+  "child": [
+    "addon-resizer",
+    "pause"
+  ],
+  "manifest": {},
+  "name": "foo",
+  "tags": []
+}`,
+				"gcr.io/foo/addon-resizer": `{
+  "child": [],
+  "manifest": {
+    "sha256:b5b2d91319f049143806baeacc886f82f621e9a2550df856b11b5c22db4570a7": {
+      "imageSizeBytes": "12875324",
+      "layerId": "",
+      "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+      "tag": [
+        "latest"
+      ],
+      "timeCreatedMs": "1501774217070",
+      "timeUploadedMs": "1552917295327"
+    },
+    "sha256:0519a83e8f217e33dd06fe7a7347444cfda5e2e29cf52aaa24755999cb104a4d": {
+      "imageSizeBytes": "12875324",
+      "layerId": "",
+      "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+      "tag": [
+        "1.0"
+      ],
+      "timeCreatedMs": "1501774217070",
+      "timeUploadedMs": "1552917295327"
+    }
+  },
+  "name": "foo/addon-resizer",
+  "tags": [
+    "latest",
+    "1.0"
+  ]
+}`,
+				"gcr.io/foo/pause": `{
+  "child": [],
+  "manifest": {
+    "sha256:06fdf10aae2eeeac5a82c213e4693f82ab05b3b09b820fce95a7cac0bbdad534": {
+      "imageSizeBytes": "12875324",
+      "layerId": "",
+      "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+      "tag": [
+        "v1.2.3"
+      ],
+      "timeCreatedMs": "1501774217070",
+      "timeUploadedMs": "1552917295327"
+    }
+  },
+  "name": "foo/pause",
+  "tags": [
+    "v1.2.3"
+  ]
+}`,
+			},
+			reg.RegInvImage{
+				"addon-resizer": {
+					"sha256:b5b2d91319f049143806baeacc886f82f621e9a2550df856b11b5c22db4570a7": {"latest"},
+					"sha256:0519a83e8f217e33dd06fe7a7347444cfda5e2e29cf52aaa24755999cb104a4d": {"1.0"},
+				},
+				"pause": {
+					"sha256:06fdf10aae2eeeac5a82c213e4693f82ab05b3b09b820fce95a7cac0bbdad534": {"v1.2.3"},
+				},
+			},
+		},
+		{
+			"Recursive repos (child repos)",
+			map[string]string{
+				"gcr.io/foo": `{
+  "child": [
+    "addon-resizer",
+    "pause"
+  ],
+  "manifest": {},
+  "name": "foo",
+  "tags": []
+}`,
+				"gcr.io/foo/addon-resizer": `{
+  "child": [],
+  "manifest": {
+    "sha256:b5b2d91319f049143806baeacc886f82f621e9a2550df856b11b5c22db4570a7": {
+      "imageSizeBytes": "12875324",
+      "layerId": "",
+      "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+      "tag": [
+        "latest"
+      ],
+      "timeCreatedMs": "1501774217070",
+      "timeUploadedMs": "1552917295327"
+    },
+    "sha256:0519a83e8f217e33dd06fe7a7347444cfda5e2e29cf52aaa24755999cb104a4d": {
+      "imageSizeBytes": "12875324",
+      "layerId": "",
+      "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+      "tag": [
+        "1.0"
+      ],
+      "timeCreatedMs": "1501774217070",
+      "timeUploadedMs": "1552917295327"
+    }
+  },
+  "name": "foo/addon-resizer",
+  "tags": [
+    "latest",
+    "1.0"
+  ]
+}`,
+				"gcr.io/foo/pause": `{
+  "child": [
+    "childLevel1"
+  ],
+  "manifest": {
+    "sha256:06fdf10aae2eeeac5a82c213e4693f82ab05b3b09b820fce95a7cac0bbdad534": {
+      "imageSizeBytes": "12875324",
+      "layerId": "",
+      "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+      "tag": [
+        "v1.2.3"
+      ],
+      "timeCreatedMs": "1501774217070",
+      "timeUploadedMs": "1552917295327"
+    }
+  },
+  "name": "foo/pause",
+  "tags": [
+    "v1.2.3"
+  ]
+}`,
+				"gcr.io/foo/pause/childLevel1": `{
+  "child": [
+    "childLevel2"
+  ],
+  "manifest": {
+    "sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa": {
+      "imageSizeBytes": "12875324",
+      "layerId": "",
+      "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+      "tag": [
+        "aaa"
+      ],
+      "timeCreatedMs": "1501774217070",
+      "timeUploadedMs": "1552917295327"
+    }
+  },
+  "name": "foo/pause/childLevel1",
+  "tags": [
+    "aaa"
+  ]
+}`,
+				"gcr.io/foo/pause/childLevel1/childLevel2": `{
+  "child": [],
+  "manifest": {
+    "sha256:ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff": {
+      "imageSizeBytes": "12875324",
+      "layerId": "",
+      "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+      "tag": [
+        "fff"
+      ],
+      "timeCreatedMs": "1501774217070",
+      "timeUploadedMs": "1552917295327"
+    }
+  },
+  "name": "foo/pause/childLevel1/childLevel2",
+  "tags": [
+    "fff"
+  ]
+}`,
+			},
+			reg.RegInvImage{
+				"addon-resizer": {
+					"sha256:b5b2d91319f049143806baeacc886f82f621e9a2550df856b11b5c22db4570a7": {"latest"},
+					"sha256:0519a83e8f217e33dd06fe7a7347444cfda5e2e29cf52aaa24755999cb104a4d": {"1.0"},
+				},
+				"pause": {
+					"sha256:06fdf10aae2eeeac5a82c213e4693f82ab05b3b09b820fce95a7cac0bbdad534": {"v1.2.3"},
+				},
+				"pause/childLevel1": {
+					"sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa": {"aaa"},
+				},
+				"pause/childLevel1/childLevel2": reg.DigestTags{
+					"sha256:ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff": {"fff"},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		// Destination registry is a placeholder, because ReadImageNames acts on
+		// 2 registries (src and dest) at once.
+		rcs := []reg.RegistryContext{
+			{
+				Name:           fakeRegName,
+				ServiceAccount: "robot",
+			},
+		}
+
+		sc := reg.SyncContext{
+			RegistryContexts: rcs,
+			Inv:              map[reg.RegistryName]reg.RegInvImage{fakeRegName: nil},
+			DigestMediaType:  make(reg.DigestMediaType),
+			DigestImageSize:  make(reg.DigestImageSize),
+		}
+
+		// test is used to pin the "test" variable from the outer "range"
+		// scope (see scopelint).
+		test := test
+		mkFakeStream1 := func(sc *reg.SyncContext, rc reg.RegistryContext) stream.Producer {
+			var sr stream.Fake
+
+			_, domain, repoPath := reg.GetTokenKeyDomainRepoPath(rc.Name)
+			fakeHTTPBody, ok := test.input[domain+"/"+repoPath]
+			if !ok {
+				require.False(t, ok)
+			}
+
+			sr.Bytes = []byte(fakeHTTPBody)
+			return &sr
+		}
+
+		sc.ReadRegistries(rcs, true, mkFakeStream1)
+		got := sc.Inv[fakeRegName]
+		require.Equal(t, got, test.expectedOutput)
+	}
+}
+
+// TestReadGManifestLists tests reading ManifestList information from GCR.
+func TestReadGManifestLists(t *testing.T) {
+	const fakeRegName reg.RegistryName = "gcr.io/foo"
+
+	tests := []struct {
+		name           string
+		input          map[string]string
+		expectedOutput reg.ParentDigest
+	}{
+		{
+			"Basic example",
+			map[string]string{
+				"gcr.io/foo/someImage": `{
+   "schemaVersion": 2,
+   "mediaType": "application/vnd.docker.distribution.manifest.list.v2+json",
+   "manifests": [
+      {
+         "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+         "size": 739,
+         "digest": "sha256:0bd88bcba94f800715fca33ffc4bde430646a7c797237313cbccdcdef9f80f2d",
+         "platform": {
+            "architecture": "amd64",
+            "os": "linux"
+         }
+      },
+      {
+         "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+         "size": 739,
+         "digest": "sha256:0ad4f92011b2fa5de88a6e6a2d8b97f38371246021c974760e5fc54b9b7069e5",
+         "platform": {
+            "architecture": "s390x",
+            "os": "linux"
+         }
+      }
+   ]
+}`,
+			},
+			reg.ParentDigest{
+				"sha256:0bd88bcba94f800715fca33ffc4bde430646a7c797237313cbccdcdef9f80f2d": "sha256:0000000000000000000000000000000000000000000000000000000000000000",
+				"sha256:0ad4f92011b2fa5de88a6e6a2d8b97f38371246021c974760e5fc54b9b7069e5": "sha256:0000000000000000000000000000000000000000000000000000000000000000",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		// Destination registry is a placeholder, because ReadImageNames acts on
+		// 2 registries (src and dest) at once.
+		rcs := []reg.RegistryContext{
+			{
+				Name:           fakeRegName,
+				ServiceAccount: "robot",
+			},
+		}
+		sc := reg.SyncContext{
+			RegistryContexts: rcs,
+			Inv: map[reg.RegistryName]reg.RegInvImage{
+				"gcr.io/foo": {
+					"someImage": reg.DigestTags{
+						"sha256:0000000000000000000000000000000000000000000000000000000000000000": {"1.0"},
+					},
+				},
+			},
+			DigestMediaType: reg.DigestMediaType{
+				"sha256:0000000000000000000000000000000000000000000000000000000000000000": cr.DockerManifestList,
+			},
+			DigestImageSize: make(reg.DigestImageSize),
+			ParentDigest:    make(reg.ParentDigest),
+		}
+
+		// test is used to pin the "test" variable from the outer "range"
+		// scope (see scopelint).
+		test := test
+		mkFakeStream1 := func(sc *reg.SyncContext, gmlc *reg.GCRManifestListContext) stream.Producer {
+			var sr stream.Fake
+
+			_, domain, repoPath := reg.GetTokenKeyDomainRepoPath(gmlc.RegistryContext.Name)
+			fakeHTTPBody, ok := test.input[domain+"/"+repoPath+"/"+string(gmlc.ImageName)]
+			if !ok {
+				require.False(t, ok)
+			}
+
+			sr.Bytes = []byte(fakeHTTPBody)
+			return &sr
+		}
+
+		require.Nil(t, sc.ReadGCRManifestLists(mkFakeStream1))
+		got := sc.ParentDigest
+		require.Equal(t, got, test.expectedOutput)
+	}
+}
+
+// concurrencyFake is a stream.Fake that records how many streams are being
+// produced at the same time, and fails with err (if set).
+type concurrencyFake struct {
+	stream.Fake
+	err        error
+	mutex      *sync.Mutex
+	running    *int
+	maxRunning *int
+}
+
+func (producer *concurrencyFake) Produce() (io.Reader, io.Reader, error) {
+	producer.mutex.Lock()
+	*producer.running++
+	if *producer.running > *producer.maxRunning {
+		*producer.maxRunning = *producer.running
+	}
+	producer.mutex.Unlock()
+
+	time.Sleep(time.Millisecond)
+
+	producer.mutex.Lock()
+	*producer.running--
+	producer.mutex.Unlock()
+
+	if producer.err != nil {
+		return nil, nil, producer.err
+	}
+	return producer.Fake.Produce()
+}
+
+func TestReadGManifestListsParallel(t *testing.T) {
+	const (
+		fakeRegName reg.RegistryName = "gcr.io/foo"
+		numLists                     = 100
+		threads                      = 8
+	)
+
+	sc := reg.SyncContext{
+		Threads:          threads,
+		RegistryContexts: []reg.RegistryContext{{Name: fakeRegName}},
+		Inv:              reg.MasterInventory{fakeRegName: reg.RegInvImage{}},
+		DigestMediaType:  reg.DigestMediaType{},
+		DigestImageSize:  make(reg.DigestImageSize),
+		ParentDigest:     make(reg.ParentDigest),
+	}
+
+	// Every manifest list has a single child; a few of them cannot be read.
+	parentOf := func(i int) reg.Digest {
+		return reg.Digest(fmt.Sprintf("sha256:%064x", i))
+	}
+	childOf := func(i int) reg.Digest {
+		return reg.Digest(fmt.Sprintf("sha256:%064x", numLists+i))
+	}
+	failing := func(i int) bool {
+		return i%25 == 7
+	}
+
+	bodies := make(map[reg.Digest]string)
+	expected := make(reg.ParentDigest)
+	expectedFailures := make([]string, 0)
+	notFound := &stream.StatusError{StatusCode: http.StatusNotFound}
+	for i := 0; i < numLists; i++ {
+		imageName := reg.ImageName(fmt.Sprintf("img-%03d", i))
+		sc.Inv[fakeRegName][imageName] = reg.DigestTags{parentOf(i): {"1.0"}}
+		sc.DigestMediaType[parentOf(i)] = cr.DockerManifestList
+
+		if failing(i) {
+			expectedFailures = append(
+				expectedFailures,
+				fmt.Sprintf(
+					"%s/%s@%s: %v",
+					fakeRegName,
+					imageName,
+					parentOf(i),
+					notFound))
+			continue
+		}
+
+		bodies[parentOf(i)] = fmt.Sprintf(`{
+   "schemaVersion": 2,
+   "mediaType": "application/vnd.docker.distribution.manifest.list.v2+json",
+   "manifests": [
+      {
+         "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+         "size": 739,
+         "digest": "%s"
+      }
+   ]
+}`, childOf(i))
+		expected[childOf(i)] = parentOf(i)
+	}
+
+	mutex := &sync.Mutex{}
+	running, maxRunning := 0, 0
+	mkFakeStream := func(
+		sc *reg.SyncContext,
+		gmlc *reg.GCRManifestListContext,
+	) stream.Producer {
+		producer := &concurrencyFake{
+			mutex:      mutex,
+			running:    &running,
+			maxRunning: &maxRunning,
+		}
+
+		body, ok := bodies[gmlc.Digest]
+		if !ok {
+			// Not found is not retried.
+			producer.err = notFound
+		}
+		producer.Bytes = []byte(body)
+
+		return producer
+	}
+
+	err := sc.ReadGCRManifestLists(mkFakeStream)
+
+	// All manifest lists that could be read are recorded, and all that could
+	// not be read are reported together.
+	require.Equal(t, expected, sc.ParentDigest)
+	require.NotNil(t, err)
+	require.Equal(
+		t,
+		fmt.Sprintf(
+			"could not read %d manifest list(s):\n  %s",
+			len(expectedFailures),
+			strings.Join(expectedFailures, "\n  ")),
+		err.Error())
+
+	// The reads never exceed the threads.
+	require.LessOrEqual(t, maxRunning, threads)
+}
+
+func TestGetTokenKeyDomainRepoPath(t *testing.T) {
+	type TokenKeyDomainRepoPath [3]string
+
+	tests := []struct {
+		name     string
+		input    reg.RegistryName
+		expected TokenKeyDomainRepoPath
+	}{
+		{
+			"basic",
+			"gcr.io/foo/bar",
+			[3]string{"gcr.io/foo", "gcr.io", "foo/bar"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(
+			test.name,
+			func(t *testing.T) {
+				tokenKey, domain, repoPath := reg.GetTokenKeyDomainRepoPath(test.input)
+
+				require.Equal(t, tokenKey, test.expected[0])
+				require.Equal(t, domain, test.expected[1])
+				require.Equal(t, repoPath, test.expected[2])
+			},
+		)
+	}
+}
+
+func TestRegistryMirrors(t *testing.T) {
+	srcRC := reg.RegistryContext{
+		Name:           "gcr.io/foo-staging",
+		ServiceAccount: "robot",
+		Src:            true,
+	}
+	// The destination is on the same host as the source, but must not be read
+	// or written through the mirror.
+	destRC := reg.RegistryContext{
+		Name:           "gcr.io/foo",
+		ServiceAccount: "robot",
+	}
+
+	sc := reg.SyncContext{
+		RegistryContexts: []reg.RegistryContext{srcRC, destRC},
+		Inv: reg.MasterInventory{
+			srcRC.Name:  nil,
+			destRC.Name: nil,
+		},
+		DigestMediaType: make(reg.DigestMediaType),
+		DigestImageSize: make(reg.DigestImageSize),
+		RegistryMirrors: map[string]string{"gcr.io": "mirror.example.com"},
+	}
+
+	// Registry names.
+	require.Equal(t,
+		reg.RegistryName("mirror.example.com/foo-staging"),
+		sc.MirroredRegistry(srcRC.Name))
+	require.Equal(t,
+		reg.RegistryName("us.gcr.io/foo"),
+		sc.MirroredRegistry("us.gcr.io/foo"))
+
+	// Reading repositories, including the child repos of the source registry.
+	fakeHTTPBodies := map[reg.RegistryName]string{
+		"gcr.io/foo-staging":     `{"child": ["bar"], "manifest": {}}`,
+		"gcr.io/foo-staging/bar": `{"child": [], "manifest": {}}`,
+		"gcr.io/foo":             `{"child": ["bar"], "manifest": {}}`,
+		"gcr.io/foo/bar":         `{"child": [], "manifest": {}}`,
+	}
+	var mutex sync.Mutex
+	readURLs := []string{}
+	mkStream := func(sc *reg.SyncContext, rc reg.RegistryContext) stream.Producer {
+		httpReq := reg.MkReadRepositoryCmdReal(sc, rc).(*stream.HTTP).Req
+		mutex.Lock()
+		readURLs = append(readURLs, httpReq.URL.String())
+		mutex.Unlock()
+
+		return &stream.Fake{Bytes: []byte(fakeHTTPBodies[rc.Name])}
+	}
+	sc.ReadRegistries(sc.RegistryContexts, true, mkStream)
+	require.ElementsMatch(t,
+		[]string{
+			"https://mirror.example.com/v2/foo-staging/tags/list",
+			"https://mirror.example.com/v2/foo-staging/bar/tags/list",
+			"https://gcr.io/v2/foo/tags/list",
+			"https://gcr.io/v2/foo/bar/tags/list",
+		},
+		readURLs)
+	// The inventory is still keyed by the logical registry names.
+	require.Contains(t, sc.Inv, srcRC.Name)
+	require.NotContains(t, sc.Inv, reg.RegistryName("mirror.example.com/foo-staging"))
+
+	// Reading manifest lists.
+	for _, test := range []struct {
+		rc       reg.RegistryContext
+		expected string
+	}{
+		{srcRC, "https://mirror.example.com/v2/foo-staging/bar/manifests/sha256:000"},
+		{destRC, "https://gcr.io/v2/foo/bar/manifests/sha256:000"},
+	} {
+		sp := reg.MkReadManifestListCmdReal(&sc, &reg.GCRManifestListContext{
+			RegistryContext: test.rc,
+			ImageName:       "bar",
+			Digest:          "sha256:000",
+		})
+		require.Equal(t, test.expected, sp.(*stream.HTTP).Req.URL.String())
+	}
+
+	// Promotion pulls from the mirror, but writes to the real destination.
+	edges, err := reg.ToPromotionEdges([]reg.Manifest{
+		{
+			Registries: []reg.RegistryContext{srcRC, destRC},
+			Images: []reg.Image{
+				{
+					ImageName: "bar",
+					Dmap: reg.DigestTags{
+						"sha256:000": {"1.0"},
+					},
+				},
+			},
+			SrcRegistry: &srcRC,
+		},
+	})
+	require.Nil(t, err)
+
+	sc.CopyTool, err = reg.MkCopyTool(reg.CopyToolCrane)
+	require.Nil(t, err)
+	var copyCmd []string
+	mkProducer := func(
+		srcRegistry reg.RegistryName,
+		srcImageName reg.ImageName,
+		rc reg.RegistryContext,
+		destImageName reg.ImageName,
+		digest reg.Digest,
+		tag reg.Tag,
+		tp reg.TagOp,
+	) stream.Producer {
+		copyCmd = reg.GetWriteCmd(
+			rc,
+			false,
+			srcRegistry,
+			srcImageName,
+			destImageName,
+			digest,
+			tag,
+			tp,
+			sc.CopyTool)
+		return nil
+	}
+
+	reqs := make(chan stream.ExternalRequest, len(edges))
+	reg.MKPopulateRequestsForPromotionEdges(edges, mkProducer)(
+		&sc, reqs, new(sync.WaitGroup))
+	close(reqs)
+
+	require.Equal(t,
+		[]string{
+			"crane",
+			"copy",
+			"mirror.example.com/foo-staging/bar@sha256:000",
+			"gcr.io/foo/bar:1.0",
+		},
+		copyCmd)
+	req := <-reqs
+	pr := req.RequestParams.(reg.PromotionRequest)
+	require.Equal(t, srcRC.Name, pr.RegistrySrc)
+	require.Equal(t, destRC.Name, pr.RegistryDest)
+}
+
+func TestUserAgent(t *testing.T) {
+	rc := reg.RegistryContext{Name: "gcr.io/foo"}
+	gmlc := reg.GCRManifestListContext{
+		RegistryContext: rc,
+		ImageName:       "bar",
+		Digest:          "sha256:0000000000000000000000000000000000000000000000000000000000000000",
+	}
+
+	var tests = []struct {
+		name      string
+		userAgent string
+		expected  string
+	}{
+		{
+			"Default (with the cip version)",
+			"",
+			version.Get().UserAgent(),
+		},
+		{
+			"Override",
+			"my-bot/1.0",
+			"my-bot/1.0",
+		},
+	}
+
+	for _, test := range tests {
+		sc := reg.SyncContext{UserAgent: test.userAgent}
+
+		repoReq := reg.MkReadRepositoryCmdReal(&sc, rc).(*stream.HTTP).Req
+		require.Equal(t, test.expected, repoReq.UserAgent(), test.name)
+
+		mlReq := reg.MkReadManifestListCmdReal(&sc, &gmlc).(*stream.HTTP).Req
+		require.Equal(t, test.expected, mlReq.UserAgent(), test.name)
+	}
+
+	require.Contains(t,
+		version.Get().UserAgent(),
+		"cip/"+version.Get().GitVersion)
+}
+
+func TestSetManipulationsRegistryInventories(t *testing.T) {
+	tests := []struct {
+		name           string
+		input1         reg.RegInvImage
+		input2         reg.RegInvImage
+		op             func(a, b reg.RegInvImage) reg.RegInvImage
+		expectedOutput reg.RegInvImage
+	}{
+		{
+			"Set Minus",
+			reg.RegInvImage{
+				"foo": {
+					"sha256:abc": {"1.0", "latest"},
+				},
+				"bar": {
+					"sha256:def": {"0.9"},
+				},
+			},
+			reg.RegInvImage{
+				"foo": {
+					"sha256:abc": {"1.0", "latest"},
+				},
+				"bar": {
+					"sha256:def": {"0.9"},
+				},
+			},
+			reg.RegInvImage.Minus,
+			reg.RegInvImage{},
+		},
+		{
+			"Set Union",
+			reg.RegInvImage{
+				"foo": {
+					"sha256:abc": {"1.0", "latest"},
+				},
+				"bar": {
+					"sha256:def": {"0.9"},
+				},
+			},
+			reg.RegInvImage{
+				"apple": {
+					"sha256:abc": {"1.0", "latest"},
+				},
+				"banana": {
+					"sha256:def": {"0.9"},
+				},
+			},
+			reg.RegInvImage.Union,
+			reg.RegInvImage{
+				"foo": {
+					"sha256:abc": {"1.0", "latest"},
+				},
+				"bar": {
+					"sha256:def": {"0.9"},
+				},
+				"apple": {
+					"sha256:abc": {"1.0", "latest"},
+				},
+				"banana": {
+					"sha256:def": {"0.9"},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		got := test.op(test.input1, test.input2)
+		require.Equal(t, got, test.expectedOutput)
+	}
+}
+
+func TestSetManipulationsTags(t *testing.T) {
+	tests := []struct {
+		name           string
+		input1         reg.TagSlice
+		input2         reg.TagSlice
+		op             func(a, b reg.TagSlice) reg.TagSet
+		expectedOutput reg.TagSet
+	}{
+		{
+			"Set Minus (both blank)",
+			reg.TagSlice{},
+			reg.TagSlice{},
+			reg.TagSlice.Minus,
+			reg.TagSet{},
+		},
+		{
+			"Set Minus (first blank)",
+			reg.TagSlice{},
+			reg.TagSlice{"a"},
+			reg.TagSlice.Minus,
+			reg.TagSet{},
+		},
+		{
+			"Set Minus (second blank)",
+			reg.TagSlice{"a", "b"},
+			reg.TagSlice{},
+			reg.TagSlice.Minus,
+			reg.TagSet{"a": nil, "b": nil},
+		},
+		{
+			"Set Minus",
+			reg.TagSlice{"a", "b"},
+			reg.TagSlice{"b"},
+			reg.TagSlice.Minus,
+			reg.TagSet{"a": nil},
+		},
+		{
+			"Set Union (both blank)",
+			reg.TagSlice{},
+			reg.TagSlice{},
+			reg.TagSlice.Union,
+			reg.TagSet{},
+		},
+		{
+			"Set Union (first blank)",
+			reg.TagSlice{},
+			reg.TagSlice{"a"},
+			reg.TagSlice.Union,
+			reg.TagSet{"a": nil},
+		},
+		{
+			"Set Union (second blank)",
+			reg.TagSlice{"a"},
+			reg.TagSlice{},
+			reg.TagSlice.Union,
+			reg.TagSet{"a": nil},
+		},
+		{
+			"Set Union",
+			reg.TagSlice{"a", "c"},
+			reg.TagSlice{"b", "d"},
+			reg.TagSlice.Union,
+			reg.TagSet{"a": nil, "b": nil, "c": nil, "d": nil},
+		},
+		{
+			"Set Intersection (no intersection)",
+			reg.TagSlice{"a"},
+			reg.TagSlice{"b"},
+			reg.TagSlice.Intersection,
+			reg.TagSet{},
+		},
+		{
+			"Set Intersection (some intersection)",
+			reg.TagSlice{"a", "b"},
+			reg.TagSlice{"b", "c"},
+			reg.TagSlice.Intersection,
+			reg.TagSet{"b": nil},
+		},
+	}
+
+	for _, test := range tests {
+		got := test.op(test.input1, test.input2)
+		require.Equal(t, got, test.expectedOutput)
+	}
+}
+
+func TestSetManipulationsRegInvImageTag(t *testing.T) {
+	tests := []struct {
+		name           string
+		input1         reg.RegInvImageTag
+		input2         reg.RegInvImageTag
+		op             func(a, b reg.RegInvImageTag) reg.RegInvImageTag
+		expectedOutput reg.RegInvImageTag
+	}{
+		{
+			"Set Minus (both blank)",
+			reg.RegInvImageTag{},
+			reg.RegInvImageTag{},
+			reg.RegInvImageTag.Minus,
+			reg.RegInvImageTag{},
+		},
+		{
+			"Set Minus (first blank)",
+			reg.RegInvImageTag{},
+			reg.RegInvImageTag{
+				reg.ImageTag{ImageName: "pear", Tag: "latest"}: "123",
+			},
+			reg.RegInvImageTag.Minus,
+			reg.RegInvImageTag{},
+		},
+		{
+			"Set Minus (second blank)",
+			reg.RegInvImageTag{
+				reg.ImageTag{
+					ImageName: "pear",
+					Tag:       "latest",
+				}: "123",
+			},
+			reg.RegInvImageTag{},
+			reg.RegInvImageTag.Minus,
+			reg.RegInvImageTag{
+				reg.ImageTag{
+					ImageName: "pear",
+					Tag:       "latest",
+				}: "123",
+			},
+		},
+		{
+			"Set Intersection (both blank)",
+			reg.RegInvImageTag{},
+			reg.RegInvImageTag{},
+			reg.RegInvImageTag.Intersection,
+			reg.RegInvImageTag{},
+		},
+		{
+			"Set Intersection (first blank)",
+			reg.RegInvImageTag{},
+			reg.RegInvImageTag{
+				reg.ImageTag{ImageName: "pear", Tag: "latest"}: "123",
+			},
+			reg.RegInvImageTag.Intersection,
+			reg.RegInvImageTag{},
+		},
+		{
+			"Set Intersection (second blank)",
+			reg.RegInvImageTag{
+				reg.ImageTag{ImageName: "pear", Tag: "latest"}: "123",
+			},
+			reg.RegInvImageTag{},
+			reg.RegInvImageTag.Intersection,
+			reg.RegInvImageTag{},
+		},
+		{
+			"Set Intersection (no intersection)",
+			reg.RegInvImageTag{
+				reg.ImageTag{ImageName: "pear", Tag: "latest"}: "123",
+			},
+			reg.RegInvImageTag{
+				reg.ImageTag{ImageName: "pear", Tag: "1.0"}: "123",
+			},
+			reg.RegInvImageTag.Intersection,
+			reg.RegInvImageTag{},
+		},
+		{
+			"Set Intersection (some intersection)",
+			reg.RegInvImageTag{
+				reg.ImageTag{ImageName: "pear", Tag: "latest"}: "this-is-kept",
+				reg.ImageTag{ImageName: "pear", Tag: "1.0"}:    "123",
+			},
+			reg.RegInvImageTag{
+				reg.ImageTag{ImageName: "pear", Tag: "latest"}: "this-is-lost",
+				reg.ImageTag{ImageName: "foo", Tag: "2.0"}:     "def",
+			},
+			// The intersection code throws out the second value, because it
+			// treats a Map as a Set (and doesn't care about preserving
+			// information for the key's value).
+			reg.RegInvImageTag.Intersection,
+			reg.RegInvImageTag{
+				reg.ImageTag{ImageName: "pear", Tag: "latest"}: "this-is-kept",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		got := test.op(test.input1, test.input2)
+		require.Equal(t, got, test.expectedOutput)
+	}
+}
+
+func TestToPromotionEdges(t *testing.T) {
+	srcRegName := reg.RegistryName("gcr.io/foo")
+	destRegName := reg.RegistryName("gcr.io/bar")
+	destRegName2 := reg.RegistryName("gcr.io/cat")
+	destRC := reg.RegistryContext{
+		Name:           destRegName,
+		ServiceAccount: "robot",
+	}
+	destRC2 := reg.RegistryContext{
+		Name:           destRegName2,
+		ServiceAccount: "robot",
+	}
+	srcRC := reg.RegistryContext{
+		Name:           srcRegName,
+		ServiceAccount: "robot",
+		Src:            true,
+	}
+	registries1 := []reg.RegistryContext{destRC, srcRC}
+	registries2 := []reg.RegistryContext{destRC, srcRC, destRC2}
+
+	sc := reg.SyncContext{
+		Inv: reg.MasterInventory{
+			"gcr.io/foo": reg.RegInvImage{
+				"a": {
+					"sha256:000": {"0.9"},
+				},
+				"c": {
+					"sha256:222": {"2.0"},
+					"sha256:333": {"3.0"},
+				},
 			},
-			reg.RegInvImage{
-				"addon-resizer": {
-					"sha256:b5b2d91319f049143806baeacc886f82f621e9a2550df856b11b5c22db4570a7": {"latest"},
-					"sha256:0519a83e8f217e33dd06fe7a7347444cfda5e2e29cf52aaa24755999cb104a4d": {"1.0"},
+			"gcr.io/bar": {
+				"a": {
+					"sha256:000": {"0.9"},
 				},
-				"pause": {
-					"sha256:06fdf10aae2eeeac5a82c213e4693f82ab05b3b09b820fce95a7cac0bbdad534": {"v1.2.3"},
+				"b": {
+					"sha256:111": {},
 				},
-				"pause/childLevel1": {
-					"sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa": {"aaa"},
+				"c": {
+					"sha256:222": {"2.0"},
+					"sha256:333": {"3.0"},
 				},
-				"pause/childLevel1/childLevel2": reg.DigestTags{
-					"sha256:ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff": {"fff"},
+			},
+			"gcr.io/cat": {
+				"a": {
+					"sha256:000": {"0.9"},
+				},
+				"c": {
+					"sha256:222": {"2.0"},
+					"sha256:333": {"3.0"},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name                  string
+		input                 []reg.Manifest
+		expectedInitial       map[reg.PromotionEdge]interface{}
+		expectedInitialErr    error
+		expectedFiltered      map[reg.PromotionEdge]interface{}
+		expectedFilteredClean bool
+	}{
+		{
+			"Basic case (1 new edge; already promoted)",
+			[]reg.Manifest{
+				{
+					Registries: registries1,
+					Images: []reg.Image{
+						{
+							ImageName: "a",
+							Dmap: reg.DigestTags{
+								"sha256:000": {"0.9"},
+							},
+						},
+					},
+					SrcRegistry: &srcRC,
+				},
+			},
+			map[reg.PromotionEdge]interface{}{
+				{
+					SrcRegistry: srcRC,
+					SrcImageTag: reg.ImageTag{
+						ImageName: "a",
+						Tag:       "0.9",
+					},
+					Digest:      "sha256:000",
+					DstRegistry: destRC,
+					DstImageTag: reg.ImageTag{
+						ImageName: "a",
+						Tag:       "0.9",
+					},
+				}: nil,
+			},
+			nil,
+			make(map[reg.PromotionEdge]interface{}),
+			true,
+		},
+		{
+			"Basic case (2 new edges; already promoted)",
+			[]reg.Manifest{
+				{
+					Registries: registries2,
+					Images: []reg.Image{
+						{
+							ImageName: "a",
+							Dmap: reg.DigestTags{
+								"sha256:000": {"0.9"},
+							},
+						},
+					},
+					SrcRegistry: &srcRC,
+				},
+			},
+			map[reg.PromotionEdge]interface{}{
+				{
+					SrcRegistry: srcRC,
+					SrcImageTag: reg.ImageTag{
+						ImageName: "a",
+						Tag:       "0.9",
+					},
+					Digest:      "sha256:000",
+					DstRegistry: destRC,
+					DstImageTag: reg.ImageTag{
+						ImageName: "a",
+						Tag:       "0.9",
+					},
+				}: nil,
+				{
+					SrcRegistry: srcRC,
+					SrcImageTag: reg.ImageTag{
+						ImageName: "a",
+						Tag:       "0.9",
+					},
+					Digest:      "sha256:000",
+					DstRegistry: destRC2,
+					DstImageTag: reg.ImageTag{
+						ImageName: "a",
+						Tag:       "0.9",
+					},
+				}: nil,
+			},
+			nil,
+			make(map[reg.PromotionEdge]interface{}),
+			true,
+		},
+		{
+			"Tag move (tag swap image c:2.0 and c:3.0)",
+			[]reg.Manifest{
+				{
+					Registries: registries2,
+					Images: []reg.Image{
+						{
+							ImageName: "c",
+							Dmap: reg.DigestTags{
+								"sha256:222": {"3.0"},
+								"sha256:333": {"2.0"},
+							},
+						},
+					},
+					SrcRegistry: &srcRC,
+				},
+			},
+			map[reg.PromotionEdge]interface{}{
+				{
+					SrcRegistry: srcRC,
+					SrcImageTag: reg.ImageTag{
+						ImageName: "c",
+						Tag:       "2.0",
+					},
+					Digest:      "sha256:333",
+					DstRegistry: destRC,
+					DstImageTag: reg.ImageTag{
+						ImageName: "c",
+						Tag:       "2.0",
+					},
+				}: nil,
+				{
+					SrcRegistry: srcRC,
+					SrcImageTag: reg.ImageTag{
+						ImageName: "c",
+						Tag:       "3.0",
+					},
+					Digest:      "sha256:222",
+					DstRegistry: destRC,
+					DstImageTag: reg.ImageTag{
+						ImageName: "c",
+						Tag:       "3.0",
+					},
+				}: nil,
+				{
+					SrcRegistry: srcRC,
+					SrcImageTag: reg.ImageTag{
+						ImageName: "c",
+						Tag:       "2.0",
+					},
+					Digest:      "sha256:333",
+					DstRegistry: destRC2,
+					DstImageTag: reg.ImageTag{
+						ImageName: "c",
+						Tag:       "2.0",
+					},
+				}: nil,
+				{
+					SrcRegistry: srcRC,
+					SrcImageTag: reg.ImageTag{
+						ImageName: "c",
+						Tag:       "3.0",
+					},
+					Digest:      "sha256:222",
+					DstRegistry: destRC2,
+					DstImageTag: reg.ImageTag{
+						ImageName: "c",
+						Tag:       "3.0",
+					},
+				}: nil,
+			},
+			nil,
+			make(map[reg.PromotionEdge]interface{}),
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		// Finalize Manifests.
+		for i := range test.input {
+			require.Nil(t, test.input[i].Finalize())
+		}
+
+		got, gotErr := reg.ToPromotionEdges(test.input)
+		if test.expectedInitialErr != nil {
+			require.NotNil(t, gotErr)
+			require.Error(t, gotErr, test.expectedInitialErr)
+		}
+		require.Equal(t, got, test.expectedInitial)
+
+		got, gotClean := sc.GetPromotionCandidates(got)
+		require.Equal(t, got, test.expectedFiltered)
+		require.Equal(t, gotClean, test.expectedFilteredClean)
+	}
+}
+
+func TestToPromotionEdgesSelfPromotion(t *testing.T) {
+	srcRC := reg.RegistryContext{
+		Name:           "gcr.io/foo",
+		ServiceAccount: "robot",
+		Src:            true,
+	}
+	// The source registry, listed again as a destination.
+	selfRC := reg.RegistryContext{
+		Name:           "gcr.io/foo",
+		ServiceAccount: "other-robot",
+	}
+	destRC := reg.RegistryContext{
+		Name:           "gcr.io/bar",
+		ServiceAccount: "robot",
+	}
+
+	mkManifest := func(registries ...reg.RegistryContext) reg.Manifest {
+		return reg.Manifest{
+			Registries: registries,
+			Images: []reg.Image{
+				{
+					ImageName: "a",
+					Dmap: reg.DigestTags{
+						"sha256:000": {"0.9"},
+					},
 				},
 			},
+			SrcRegistry: &srcRC,
+			Filepath:    "manifests/a/promoter-manifest.yaml",
+		}
+	}
+
+	mkEdge := func(dst reg.RegistryContext) reg.PromotionEdge {
+		return reg.PromotionEdge{
+			SrcRegistry: srcRC,
+			SrcImageTag: reg.ImageTag{ImageName: "a", Tag: "0.9"},
+			Digest:      "sha256:000",
+			DstRegistry: dst,
+			DstImageTag: reg.ImageTag{ImageName: "a", Tag: "0.9"},
+		}
+	}
+
+	tests := []struct {
+		name          string
+		input         reg.Manifest
+		allowSelf     bool
+		expected      map[reg.PromotionEdge]interface{}
+		expectedError string
+	}{
+		{
+			"No self-promotion",
+			mkManifest(srcRC, destRC),
+			false,
+			map[reg.PromotionEdge]interface{}{
+				mkEdge(destRC): nil,
+			},
+			"",
+		},
+		{
+			"Source registry listed as destination",
+			mkManifest(srcRC, destRC, selfRC),
+			false,
+			nil,
+			`manifest "manifests/a/promoter-manifest.yaml": registry "gcr.io/foo" is both the source and a destination of image "a" (self-promotion)`,
+		},
+		{
+			"Source registry listed as destination (allowed)",
+			mkManifest(srcRC, destRC, selfRC),
+			true,
+			map[reg.PromotionEdge]interface{}{
+				mkEdge(destRC): nil,
+				mkEdge(selfRC): nil,
+			},
+			"",
 		},
 	}
 
 	for _, test := range tests {
-		// Destination registry is a placeholder, because ReadImageNames acts on
-		// 2 registries (src and dest) at once.
-		rcs := []reg.RegistryContext{
-			{
-				Name:           fakeRegName,
-				ServiceAccount: "robot",
-			},
+		toPromotionEdges := reg.ToPromotionEdges
+		if test.allowSelf {
+			toPromotionEdges = reg.ToPromotionEdgesAllowSelfPromotion
 		}
 
-		sc := reg.SyncContext{
-			RegistryContexts: rcs,
-			Inv:              map[reg.RegistryName]reg.RegInvImage{fakeRegName: nil},
-			DigestMediaType:  make(reg.DigestMediaType),
-			DigestImageSize:  make(reg.DigestImageSize),
+		got, err := toPromotionEdges([]reg.Manifest{test.input})
+		if test.expectedError != "" {
+			require.NotNil(t, err, test.name)
+			require.Equal(t, test.expectedError, err.Error(), test.name)
+			continue
 		}
 
-		// test is used to pin the "test" variable from the outer "range"
-		// scope (see scopelint).
-		test := test
-		mkFakeStream1 := func(sc *reg.SyncContext, rc reg.RegistryContext) stream.Producer {
-			var sr stream.Fake
-
-			_, domain, repoPath := reg.GetTokenKeyDomainRepoPath(rc.Name)
-			fakeHTTPBody, ok := test.input[domain+"/"+repoPath]
-			if !ok {
-				require.False(t, ok)
-			}
+		require.Nil(t, err, test.name)
+		require.Equal(t, test.expected, got, test.name)
+	}
+}
 
-			sr.Bytes = []byte(fakeHTTPBody)
-			return &sr
+func TestToPromotionEdgesCycles(t *testing.T) {
+	mkRC := func(name reg.RegistryName) reg.RegistryContext {
+		return reg.RegistryContext{
+			Name:           name,
+			ServiceAccount: "robot",
 		}
-
-		sc.ReadRegistries(rcs, true, mkFakeStream1)
-		got := sc.Inv[fakeRegName]
-		require.Equal(t, got, test.expectedOutput)
 	}
-}
 
-// TestReadGManifestLists tests reading ManifestList information from GCR.
-func TestReadGManifestLists(t *testing.T) {
-	const fakeRegName reg.RegistryName = "gcr.io/foo"
+	// mkManifest promotes image "a" from src to dest.
+	mkManifest := func(src, dest reg.RegistryName) reg.Manifest {
+		srcRC := mkRC(src)
+		srcRC.Src = true
+
+		return reg.Manifest{
+			Registries: []reg.RegistryContext{srcRC, mkRC(dest)},
+			Images: []reg.Image{
+				{
+					ImageName: "a",
+					Dmap: reg.DigestTags{
+						"sha256:000": {"0.9"},
+					},
+				},
+			},
+			SrcRegistry: &srcRC,
+		}
+	}
 
 	tests := []struct {
-		name           string
-		input          map[string]string
-		expectedOutput reg.ParentDigest
+		name          string
+		input         []reg.Manifest
+		expectedEdges int
+		expectedError string
 	}{
 		{
-			"Basic example",
-			map[string]string{
-				"gcr.io/foo/someImage": `{
-   "schemaVersion": 2,
-   "mediaType": "application/vnd.docker.distribution.manifest.list.v2+json",
-   "manifests": [
-      {
-         "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
-         "size": 739,
-         "digest": "sha256:0bd88bcba94f800715fca33ffc4bde430646a7c797237313cbccdcdef9f80f2d",
-         "platform": {
-            "architecture": "amd64",
-            "os": "linux"
-         }
-      },
-      {
-         "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
-         "size": 739,
-         "digest": "sha256:0ad4f92011b2fa5de88a6e6a2d8b97f38371246021c974760e5fc54b9b7069e5",
-         "platform": {
-            "architecture": "s390x",
-            "os": "linux"
-         }
-      }
-   ]
-}`,
+			"Linear chain",
+			[]reg.Manifest{
+				mkManifest("gcr.io/a", "gcr.io/b"),
+				mkManifest("gcr.io/b", "gcr.io/c"),
 			},
-			reg.ParentDigest{
-				"sha256:0bd88bcba94f800715fca33ffc4bde430646a7c797237313cbccdcdef9f80f2d": "sha256:0000000000000000000000000000000000000000000000000000000000000000",
-				"sha256:0ad4f92011b2fa5de88a6e6a2d8b97f38371246021c974760e5fc54b9b7069e5": "sha256:0000000000000000000000000000000000000000000000000000000000000000",
+			2,
+			"",
+		},
+		{
+			"Two-registry cycle",
+			[]reg.Manifest{
+				mkManifest("gcr.io/a", "gcr.io/b"),
+				mkManifest("gcr.io/b", "gcr.io/a"),
 			},
+			0,
+			`promotion cycle for image "a": gcr.io/a -> gcr.io/b -> gcr.io/a`,
+		},
+		{
+			"Three-registry cycle",
+			[]reg.Manifest{
+				mkManifest("gcr.io/c", "gcr.io/a"),
+				mkManifest("gcr.io/a", "gcr.io/b"),
+				mkManifest("gcr.io/b", "gcr.io/c"),
+			},
+			0,
+			`promotion cycle for image "a": gcr.io/a -> gcr.io/b -> gcr.io/c -> gcr.io/a`,
 		},
 	}
 
 	for _, test := range tests {
-		// Destination registry is a placeholder, because ReadImageNames acts on
-		// 2 registries (src and dest) at once.
-		rcs := []reg.RegistryContext{
+		got, err := reg.ToPromotionEdges(test.input)
+		if test.expectedError != "" {
+			require.NotNil(t, err, test.name)
+			require.Equal(t, test.expectedError, err.Error(), test.name)
+			continue
+		}
+
+		require.Nil(t, err, test.name)
+		require.Len(t, got, test.expectedEdges, test.name)
+	}
+}
+
+func TestToPromotionEdgesGroups(t *testing.T) {
+	srcRC := reg.RegistryContext{
+		Name:           "gcr.io/foo",
+		ServiceAccount: "robot",
+		Src:            true,
+	}
+	destRC := reg.RegistryContext{
+		Name:           "gcr.io/bar",
+		ServiceAccount: "robot",
+	}
+
+	mfest := reg.Manifest{
+		Registries: []reg.RegistryContext{srcRC, destRC},
+		Images: []reg.Image{
 			{
-				Name:           fakeRegName,
-				ServiceAccount: "robot",
+				ImageName: "a",
+				Dmap: reg.DigestTags{
+					"sha256:000": {"0.9"},
+				},
+				Group: "foo",
 			},
-		}
-		sc := reg.SyncContext{
-			RegistryContexts: rcs,
-			Inv: map[reg.RegistryName]reg.RegInvImage{
-				"gcr.io/foo": {
-					"someImage": reg.DigestTags{
-						"sha256:0000000000000000000000000000000000000000000000000000000000000000": {"1.0"},
-					},
+			{
+				ImageName: "b",
+				Dmap: reg.DigestTags{
+					"sha256:111": {"1.0"},
 				},
+				Group: "bar",
 			},
-			DigestMediaType: reg.DigestMediaType{
-				"sha256:0000000000000000000000000000000000000000000000000000000000000000": cr.DockerManifestList,
+			{
+				ImageName: "c",
+				Dmap: reg.DigestTags{
+					"sha256:222": {"2.0"},
+				},
 			},
-			DigestImageSize: make(reg.DigestImageSize),
-			ParentDigest:    make(reg.ParentDigest),
-		}
-
-		// test is used to pin the "test" variable from the outer "range"
-		// scope (see scopelint).
-		test := test
-		mkFakeStream1 := func(sc *reg.SyncContext, gmlc *reg.GCRManifestListContext) stream.Producer {
-			var sr stream.Fake
-
-			_, domain, repoPath := reg.GetTokenKeyDomainRepoPath(gmlc.RegistryContext.Name)
-			fakeHTTPBody, ok := test.input[domain+"/"+repoPath+"/"+string(gmlc.ImageName)]
-			if !ok {
-				require.False(t, ok)
-			}
+		},
+		SrcRegistry: &srcRC,
+	}
 
-			sr.Bytes = []byte(fakeHTTPBody)
-			return &sr
+	mkEdge := func(
+		image reg.ImageName,
+		digest reg.Digest,
+		tag reg.Tag,
+	) reg.PromotionEdge {
+		return reg.PromotionEdge{
+			SrcRegistry: srcRC,
+			SrcImageTag: reg.ImageTag{ImageName: image, Tag: tag},
+			Digest:      digest,
+			DstRegistry: destRC,
+			DstImageTag: reg.ImageTag{ImageName: image, Tag: tag},
 		}
-
-		sc.ReadGCRManifestLists(mkFakeStream1)
-		got := sc.ParentDigest
-		require.Equal(t, got, test.expectedOutput)
 	}
-}
-
-func TestGetTokenKeyDomainRepoPath(t *testing.T) {
-	type TokenKeyDomainRepoPath [3]string
 
 	tests := []struct {
 		name     string
-		input    reg.RegistryName
-		expected TokenKeyDomainRepoPath
+		groups   []string
+		expected map[reg.PromotionEdge]interface{}
 	}{
 		{
-			"basic",
-			"gcr.io/foo/bar",
-			[3]string{"gcr.io/foo", "gcr.io", "foo/bar"},
+			"Single group",
+			[]string{"foo"},
+			map[reg.PromotionEdge]interface{}{
+				mkEdge("a", "sha256:000", "0.9"): nil,
+			},
+		},
+		{
+			"Default group",
+			[]string{reg.DefaultImageGroup},
+			map[reg.PromotionEdge]interface{}{
+				mkEdge("c", "sha256:222", "2.0"): nil,
+			},
+		},
+		{
+			"Multiple groups",
+			[]string{"foo", "bar"},
+			map[reg.PromotionEdge]interface{}{
+				mkEdge("a", "sha256:000", "0.9"): nil,
+				mkEdge("b", "sha256:111", "1.0"): nil,
+			},
+		},
+		{
+			"Unknown group",
+			[]string{"baz"},
+			map[reg.PromotionEdge]interface{}{},
 		},
 	}
 
 	for _, test := range tests {
-		test := test
-		t.Run(
-			test.name,
-			func(t *testing.T) {
-				tokenKey, domain, repoPath := reg.GetTokenKeyDomainRepoPath(test.input)
-
-				require.Equal(t, tokenKey, test.expected[0])
-				require.Equal(t, domain, test.expected[1])
-				require.Equal(t, repoPath, test.expected[2])
-			},
-		)
+		filtered := reg.FilterManifestsByGroup([]reg.Manifest{mfest}, test.groups)
+		got, err := reg.ToPromotionEdges(filtered)
+		require.Nil(t, err, test.name)
+		require.Equal(t, test.expected, got, test.name)
 	}
+
+	// The input manifest must be left untouched.
+	require.Len(t, mfest.Images, 3)
 }
 
-func TestSetManipulationsRegistryInventories(t *testing.T) {
-	tests := []struct {
-		name           string
-		input1         reg.RegInvImage
-		input2         reg.RegInvImage
-		op             func(a, b reg.RegInvImage) reg.RegInvImage
-		expectedOutput reg.RegInvImage
-	}{
-		{
-			"Set Minus",
-			reg.RegInvImage{
-				"foo": {
-					"sha256:abc": {"1.0", "latest"},
-				},
-				"bar": {
-					"sha256:def": {"0.9"},
-				},
-			},
-			reg.RegInvImage{
-				"foo": {
-					"sha256:abc": {"1.0", "latest"},
-				},
-				"bar": {
-					"sha256:def": {"0.9"},
-				},
-			},
-			reg.RegInvImage.Minus,
-			reg.RegInvImage{},
-		},
-		{
-			"Set Union",
-			reg.RegInvImage{
-				"foo": {
-					"sha256:abc": {"1.0", "latest"},
-				},
-				"bar": {
-					"sha256:def": {"0.9"},
-				},
-			},
-			reg.RegInvImage{
-				"apple": {
-					"sha256:abc": {"1.0", "latest"},
-				},
-				"banana": {
-					"sha256:def": {"0.9"},
-				},
-			},
-			reg.RegInvImage.Union,
-			reg.RegInvImage{
-				"foo": {
-					"sha256:abc": {"1.0", "latest"},
-				},
-				"bar": {
-					"sha256:def": {"0.9"},
-				},
-				"apple": {
-					"sha256:abc": {"1.0", "latest"},
+func TestToDestNamespace(t *testing.T) {
+	srcRC := reg.RegistryContext{
+		Name:           "gcr.io/foo",
+		ServiceAccount: "robot",
+		Src:            true,
+	}
+	destRC := reg.RegistryContext{
+		Name:           "gcr.io/bar",
+		ServiceAccount: "robot",
+	}
+
+	mfest := reg.Manifest{
+		Registries: []reg.RegistryContext{srcRC, destRC},
+		Images: []reg.Image{
+			{
+				ImageName: "a",
+				Dmap: reg.DigestTags{
+					"sha256:000": {"0.9"},
 				},
-				"banana": {
-					"sha256:def": {"0.9"},
+			},
+			{
+				ImageName: "b/c",
+				Dmap: reg.DigestTags{
+					"sha256:111": {"1.0"},
 				},
 			},
 		},
+		SrcRegistry: &srcRC,
 	}
 
-	for _, test := range tests {
-		got := test.op(test.input1, test.input2)
-		require.Equal(t, got, test.expectedOutput)
+	mkEdge := func(
+		image reg.ImageName,
+		dstImage reg.ImageName,
+		digest reg.Digest,
+		tag reg.Tag,
+	) reg.PromotionEdge {
+		return reg.PromotionEdge{
+			SrcRegistry: srcRC,
+			SrcImageTag: reg.ImageTag{ImageName: image, Tag: tag},
+			Digest:      digest,
+			DstRegistry: destRC,
+			DstImageTag: reg.ImageTag{ImageName: dstImage, Tag: tag},
+		}
 	}
-}
 
-func TestSetManipulationsTags(t *testing.T) {
 	tests := []struct {
-		name           string
-		input1         reg.TagSlice
-		input2         reg.TagSlice
-		op             func(a, b reg.TagSlice) reg.TagSet
-		expectedOutput reg.TagSet
+		name          string
+		namespace     string
+		expected      map[reg.PromotionEdge]interface{}
+		expectedError string
 	}{
 		{
-			"Set Minus (both blank)",
-			reg.TagSlice{},
-			reg.TagSlice{},
-			reg.TagSlice.Minus,
-			reg.TagSet{},
+			"No namespace",
+			"",
+			map[reg.PromotionEdge]interface{}{
+				mkEdge("a", "a", "sha256:000", "0.9"):     nil,
+				mkEdge("b/c", "b/c", "sha256:111", "1.0"): nil,
+			},
+			"",
 		},
 		{
-			"Set Minus (first blank)",
-			reg.TagSlice{},
-			reg.TagSlice{"a"},
-			reg.TagSlice.Minus,
-			reg.TagSet{},
+			"Single component",
+			"mirror",
+			map[reg.PromotionEdge]interface{}{
+				mkEdge("a", "mirror/a", "sha256:000", "0.9"):     nil,
+				mkEdge("b/c", "mirror/b/c", "sha256:111", "1.0"): nil,
+			},
+			"",
 		},
 		{
-			"Set Minus (second blank)",
-			reg.TagSlice{"a", "b"},
-			reg.TagSlice{},
-			reg.TagSlice.Minus,
-			reg.TagSet{"a": nil, "b": nil},
+			"Nested path with trailing slash",
+			"teams/foo-bar_1/",
+			map[reg.PromotionEdge]interface{}{
+				mkEdge("a", "teams/foo-bar_1/a", "sha256:000", "0.9"):     nil,
+				mkEdge("b/c", "teams/foo-bar_1/b/c", "sha256:111", "1.0"): nil,
+			},
+			"",
 		},
 		{
-			"Set Minus",
-			reg.TagSlice{"a", "b"},
-			reg.TagSlice{"b"},
-			reg.TagSlice.Minus,
-			reg.TagSet{"a": nil},
+			"Uppercase",
+			"Mirror",
+			nil,
+			`invalid destination namespace: "Mirror"`,
 		},
 		{
-			"Set Union (both blank)",
-			reg.TagSlice{},
-			reg.TagSlice{},
-			reg.TagSlice.Union,
-			reg.TagSet{},
+			"Empty component",
+			"teams//foo",
+			nil,
+			`invalid destination namespace: "teams//foo"`,
 		},
 		{
-			"Set Union (first blank)",
-			reg.TagSlice{},
-			reg.TagSlice{"a"},
-			reg.TagSlice.Union,
-			reg.TagSet{"a": nil},
+			"Leading separator",
+			"-mirror",
+			nil,
+			`invalid destination namespace: "-mirror"`,
+		},
+	}
+
+	for _, test := range tests {
+		edges, err := reg.ToPromotionEdges([]reg.Manifest{mfest})
+		require.Nil(t, err, test.name)
+
+		got, err := reg.ToDestNamespace(edges, test.namespace)
+		if test.expectedError != "" {
+			require.NotNil(t, err, test.name)
+			require.Equal(t, test.expectedError, err.Error(), test.name)
+			continue
+		}
+
+		require.Nil(t, err, test.name)
+		require.Equal(t, test.expected, got, test.name)
+	}
+}
+
+func TestGetPromotionCandidatesMaxTags(t *testing.T) {
+	srcRC := reg.RegistryContext{
+		Name:           "gcr.io/foo",
+		ServiceAccount: "robot",
+		Src:            true,
+	}
+	destRC := reg.RegistryContext{
+		Name:           "gcr.io/bar",
+		ServiceAccount: "robot",
+	}
+
+	sc := reg.SyncContext{
+		Inv: reg.MasterInventory{
+			"gcr.io/foo": reg.RegInvImage{
+				"c": {
+					"sha256:222": {"2.0"},
+					"sha256:333": {"3.0"},
+					"sha256:444": {"4.0"},
+					"sha256:555": {"5.0"},
+				},
+			},
+			"gcr.io/bar": {
+				"c": {
+					"sha256:222": {"2.0"},
+					"sha256:333": {"3.0"},
+				},
+			},
 		},
+	}
+
+	mkEdge := func(digest reg.Digest, tag reg.Tag, maxTags int) reg.PromotionEdge {
+		return reg.PromotionEdge{
+			SrcRegistry: srcRC,
+			SrcImageTag: reg.ImageTag{
+				ImageName: "c",
+				Tag:       tag,
+			},
+			Digest:      digest,
+			DstRegistry: destRC,
+			DstImageTag: reg.ImageTag{
+				ImageName: "c",
+				Tag:       tag,
+			},
+			MaxTags: maxTags,
+		}
+	}
+
+	tests := []struct {
+		name                  string
+		input                 reg.Image
+		expectedFiltered      map[reg.PromotionEdge]interface{}
+		expectedFilteredClean bool
+	}{
 		{
-			"Set Union (second blank)",
-			reg.TagSlice{"a"},
-			reg.TagSlice{},
-			reg.TagSlice.Union,
-			reg.TagSet{"a": nil},
+			"No cap",
+			reg.Image{
+				ImageName: "c",
+				Dmap: reg.DigestTags{
+					"sha256:444": {"4.0"},
+					"sha256:555": {"5.0"},
+				},
+			},
+			map[reg.PromotionEdge]interface{}{
+				mkEdge("sha256:444", "4.0", 0): nil,
+				mkEdge("sha256:555", "5.0", 0): nil,
+			},
+			true,
 		},
 		{
-			"Set Union",
-			reg.TagSlice{"a", "c"},
-			reg.TagSlice{"b", "d"},
-			reg.TagSlice.Union,
-			reg.TagSet{"a": nil, "b": nil, "c": nil, "d": nil},
+			"Below cap",
+			reg.Image{
+				ImageName: "c",
+				Dmap: reg.DigestTags{
+					"sha256:444": {"4.0"},
+				},
+				MaxTags: 4,
+			},
+			map[reg.PromotionEdge]interface{}{
+				mkEdge("sha256:444", "4.0", 4): nil,
+			},
+			true,
 		},
 		{
-			"Set Intersection (no intersection)",
-			reg.TagSlice{"a"},
-			reg.TagSlice{"b"},
-			reg.TagSlice.Intersection,
-			reg.TagSet{},
+			"At cap",
+			reg.Image{
+				ImageName: "c",
+				Dmap: reg.DigestTags{
+					"sha256:222": {"2.0"},
+					"sha256:444": {"4.0"},
+				},
+				MaxTags: 3,
+			},
+			map[reg.PromotionEdge]interface{}{
+				mkEdge("sha256:444", "4.0", 3): nil,
+			},
+			true,
 		},
 		{
-			"Set Intersection (some intersection)",
-			reg.TagSlice{"a", "b"},
-			reg.TagSlice{"b", "c"},
-			reg.TagSlice.Intersection,
-			reg.TagSet{"b": nil},
+			"Above cap",
+			reg.Image{
+				ImageName: "c",
+				Dmap: reg.DigestTags{
+					"sha256:444": {"4.0"},
+					"sha256:555": {"5.0"},
+				},
+				MaxTags: 3,
+			},
+			make(map[reg.PromotionEdge]interface{}),
+			false,
+		},
+		{
+			"Already above cap, but no new tags",
+			reg.Image{
+				ImageName: "c",
+				Dmap: reg.DigestTags{
+					"sha256:222": {"2.0"},
+				},
+				MaxTags: 1,
+			},
+			make(map[reg.PromotionEdge]interface{}),
+			true,
 		},
 	}
 
 	for _, test := range tests {
-		got := test.op(test.input1, test.input2)
-		require.Equal(t, got, test.expectedOutput)
+		mfest := reg.Manifest{
+			Registries:  []reg.RegistryContext{destRC, srcRC},
+			Images:      []reg.Image{test.input},
+			SrcRegistry: &srcRC,
+		}
+		require.Nil(t, mfest.Finalize())
+
+		edges, err := reg.ToPromotionEdges([]reg.Manifest{mfest})
+		require.Nil(t, err)
+
+		got, gotClean := sc.GetPromotionCandidates(edges)
+		require.Equal(t, test.expectedFiltered, got, test.name)
+		require.Equal(t, test.expectedFilteredClean, gotClean, test.name)
 	}
 }
 
-func TestSetManipulationsRegInvImageTag(t *testing.T) {
+func TestToPromotionEdgesConflictingPolicies(t *testing.T) {
+	srcRC := reg.RegistryContext{
+		Name:           "gcr.io/foo",
+		ServiceAccount: "robot",
+		Src:            true,
+	}
+	destRC := reg.RegistryContext{
+		Name:           "gcr.io/bar",
+		ServiceAccount: "robot",
+	}
+
+	mkManifest := func(image reg.Image) reg.Manifest {
+		image.ImageName = "c"
+		image.Dmap = reg.DigestTags{"sha256:111": {"1.0"}}
+
+		mfest := reg.Manifest{
+			Registries:  []reg.RegistryContext{destRC, srcRC},
+			Images:      []reg.Image{image},
+			SrcRegistry: &srcRC,
+		}
+		require.Nil(t, mfest.Finalize())
+		return mfest
+	}
+
 	tests := []struct {
-		name           string
-		input1         reg.RegInvImageTag
-		input2         reg.RegInvImageTag
-		op             func(a, b reg.RegInvImageTag) reg.RegInvImageTag
-		expectedOutput reg.RegInvImageTag
+		name        string
+		images      []reg.Image
+		expectedErr string
 	}{
 		{
-			"Set Minus (both blank)",
-			reg.RegInvImageTag{},
-			reg.RegInvImageTag{},
-			reg.RegInvImageTag.Minus,
-			reg.RegInvImageTag{},
-		},
-		{
-			"Set Minus (first blank)",
-			reg.RegInvImageTag{},
-			reg.RegInvImageTag{
-				reg.ImageTag{ImageName: "pear", Tag: "latest"}: "123",
+			"Same policies",
+			[]reg.Image{
+				{MaxTags: 3, MinSignatures: 2, Window: "Mon 09:00-17:00"},
+				{MaxTags: 3, MinSignatures: 2, Window: "Mon 09:00-17:00"},
 			},
-			reg.RegInvImageTag.Minus,
-			reg.RegInvImageTag{},
+			"",
 		},
 		{
-			"Set Minus (second blank)",
-			reg.RegInvImageTag{
-				reg.ImageTag{
-					ImageName: "pear",
-					Tag:       "latest",
-				}: "123",
-			},
-			reg.RegInvImageTag{},
-			reg.RegInvImageTag.Minus,
-			reg.RegInvImageTag{
-				reg.ImageTag{
-					ImageName: "pear",
-					Tag:       "latest",
-				}: "123",
+			"Conflicting maxTags",
+			[]reg.Image{
+				{MaxTags: 5},
+				{MaxTags: 3},
+				{},
 			},
+			`1 image(s) are promoted with conflicting policies:
+  gcr.io/bar/c: conflicting maxTags (0, 3, 5)`,
 		},
 		{
-			"Set Intersection (both blank)",
-			reg.RegInvImageTag{},
-			reg.RegInvImageTag{},
-			reg.RegInvImageTag.Intersection,
-			reg.RegInvImageTag{},
+			"Conflicting minSignatures",
+			[]reg.Image{
+				{MaxTags: 3, MinSignatures: 2},
+				{MaxTags: 3},
+			},
+			`1 image(s) are promoted with conflicting policies:
+  gcr.io/bar/c: conflicting minSignatures (0, 2)`,
 		},
 		{
-			"Set Intersection (first blank)",
-			reg.RegInvImageTag{},
-			reg.RegInvImageTag{
-				reg.ImageTag{ImageName: "pear", Tag: "latest"}: "123",
+			"Conflicting windows",
+			[]reg.Image{
+				{MaxTags: 3, Window: "Mon-Fri 09:00-17:00"},
+				{MaxTags: 4, Window: "Sat 00:00-23:59"},
+			},
+			`2 image(s) are promoted with conflicting policies:
+  gcr.io/bar/c: conflicting maxTags (3, 4)
+  gcr.io/bar/c: conflicting window ("Mon-Fri 09:00-17:00", "Sat 00:00-23:59")`,
+		},
+	}
+
+	for _, test := range tests {
+		mfests := make([]reg.Manifest, 0, len(test.images))
+		for _, image := range test.images {
+			mfests = append(mfests, mkManifest(image))
+		}
+
+		edges, err := reg.ToPromotionEdges(mfests)
+		if test.expectedErr == "" {
+			require.Nil(t, err, test.name)
+			require.Len(t, edges, 1, test.name)
+			continue
+		}
+		require.NotNil(t, err, test.name)
+		require.Equal(t, test.expectedErr, err.Error(), test.name)
+	}
+}
+
+func TestGetPromotionCandidatesReadOnly(t *testing.T) {
+	srcRC := reg.RegistryContext{
+		Name:           "gcr.io/foo",
+		ServiceAccount: "robot",
+		Src:            true,
+		ReadOnly:       true,
+	}
+	destRC := reg.RegistryContext{
+		Name:           "gcr.io/bar",
+		ServiceAccount: "robot",
+	}
+	readOnlyRC := reg.RegistryContext{
+		Name:           "gcr.io/prod",
+		ServiceAccount: "robot",
+		ReadOnly:       true,
+	}
+
+	mkEdge := func(
+		dst reg.RegistryContext,
+		digest reg.Digest,
+		tag reg.Tag,
+	) reg.PromotionEdge {
+		return reg.PromotionEdge{
+			SrcRegistry: srcRC,
+			SrcImageTag: reg.ImageTag{ImageName: "a", Tag: tag},
+			Digest:      digest,
+			DstRegistry: dst,
+			DstImageTag: reg.ImageTag{ImageName: "a", Tag: tag},
+		}
+	}
+
+	inv := reg.MasterInventory{
+		"gcr.io/foo": {
+			"a": {
+				"sha256:000": {"0.9"},
+				"sha256:111": {"1.0"},
 			},
-			reg.RegInvImageTag.Intersection,
-			reg.RegInvImageTag{},
 		},
-		{
-			"Set Intersection (second blank)",
-			reg.RegInvImageTag{
-				reg.ImageTag{ImageName: "pear", Tag: "latest"}: "123",
+		"gcr.io/prod": {
+			"a": {
+				"sha256:000": {"0.9"},
 			},
-			reg.RegInvImageTag{},
-			reg.RegInvImageTag.Intersection,
-			reg.RegInvImageTag{},
 		},
+	}
+
+	tests := []struct {
+		name          string
+		edges         map[reg.PromotionEdge]interface{}
+		expected      map[reg.PromotionEdge]interface{}
+		expectedClean bool
+	}{
 		{
-			"Set Intersection (no intersection)",
-			reg.RegInvImageTag{
-				reg.ImageTag{ImageName: "pear", Tag: "latest"}: "123",
+			"Read-only source registry",
+			map[reg.PromotionEdge]interface{}{
+				mkEdge(destRC, "sha256:111", "1.0"): nil,
 			},
-			reg.RegInvImageTag{
-				reg.ImageTag{ImageName: "pear", Tag: "1.0"}: "123",
+			map[reg.PromotionEdge]interface{}{
+				mkEdge(destRC, "sha256:111", "1.0"): nil,
 			},
-			reg.RegInvImageTag.Intersection,
-			reg.RegInvImageTag{},
+			true,
 		},
 		{
-			"Set Intersection (some intersection)",
-			reg.RegInvImageTag{
-				reg.ImageTag{ImageName: "pear", Tag: "latest"}: "this-is-kept",
-				reg.ImageTag{ImageName: "pear", Tag: "1.0"}:    "123",
+			"Read-only destination registry",
+			map[reg.PromotionEdge]interface{}{
+				mkEdge(destRC, "sha256:111", "1.0"):     nil,
+				mkEdge(readOnlyRC, "sha256:111", "1.0"): nil,
 			},
-			reg.RegInvImageTag{
-				reg.ImageTag{ImageName: "pear", Tag: "latest"}: "this-is-lost",
-				reg.ImageTag{ImageName: "foo", Tag: "2.0"}:     "def",
+			map[reg.PromotionEdge]interface{}{
+				mkEdge(destRC, "sha256:111", "1.0"): nil,
 			},
-			// The intersection code throws out the second value, because it
-			// treats a Map as a Set (and doesn't care about preserving
-			// information for the key's value).
-			reg.RegInvImageTag.Intersection,
-			reg.RegInvImageTag{
-				reg.ImageTag{ImageName: "pear", Tag: "latest"}: "this-is-kept",
+			false,
+		},
+		{
+			"Read-only destination registry, already promoted",
+			map[reg.PromotionEdge]interface{}{
+				mkEdge(readOnlyRC, "sha256:000", "0.9"): nil,
 			},
+			map[reg.PromotionEdge]interface{}{},
+			true,
 		},
 	}
 
 	for _, test := range tests {
-		got := test.op(test.input1, test.input2)
-		require.Equal(t, got, test.expectedOutput)
+		sc := reg.SyncContext{Inv: inv}
+
+		got, gotClean := sc.GetPromotionCandidates(test.edges)
+		require.Equal(t, test.expected, got, test.name)
+		require.Equal(t, test.expectedClean, gotClean, test.name)
 	}
 }
 
-func TestToPromotionEdges(t *testing.T) {
-	srcRegName := reg.RegistryName("gcr.io/foo")
-	destRegName := reg.RegistryName("gcr.io/bar")
-	destRegName2 := reg.RegistryName("gcr.io/cat")
-	destRC := reg.RegistryContext{
-		Name:           destRegName,
+func TestGetPromotionCandidatesDestPathPolicy(t *testing.T) {
+	srcRC := reg.RegistryContext{
+		Name:           "gcr.io/foo",
 		ServiceAccount: "robot",
+		Src:            true,
 	}
-	destRC2 := reg.RegistryContext{
-		Name:           destRegName2,
+	teamRC := reg.RegistryContext{
+		Name:           "us-docker.pkg.dev/shared/images/team-a",
 		ServiceAccount: "robot",
 	}
-	srcRC := reg.RegistryContext{
-		Name:           srcRegName,
+	sharedRC := reg.RegistryContext{
+		Name:           "us-docker.pkg.dev/shared/images",
 		ServiceAccount: "robot",
-		Src:            true,
 	}
-	registries1 := []reg.RegistryContext{destRC, srcRC}
-	registries2 := []reg.RegistryContext{destRC, srcRC, destRC2}
 
-	sc := reg.SyncContext{
-		Inv: reg.MasterInventory{
-			"gcr.io/foo": reg.RegInvImage{
-				"a": {
-					"sha256:000": {"0.9"},
-				},
-				"c": {
-					"sha256:222": {"2.0"},
-					"sha256:333": {"3.0"},
-				},
-			},
-			"gcr.io/bar": {
-				"a": {
-					"sha256:000": {"0.9"},
-				},
-				"b": {
-					"sha256:111": {},
-				},
-				"c": {
-					"sha256:222": {"2.0"},
-					"sha256:333": {"3.0"},
-				},
-			},
-			"gcr.io/cat": {
-				"a": {
-					"sha256:000": {"0.9"},
-				},
-				"c": {
-					"sha256:222": {"2.0"},
-					"sha256:333": {"3.0"},
-				},
-			},
+	mkEdge := func(
+		dst reg.RegistryContext,
+		imageName reg.ImageName,
+	) reg.PromotionEdge {
+		return reg.PromotionEdge{
+			SrcRegistry: srcRC,
+			SrcImageTag: reg.ImageTag{ImageName: imageName, Tag: "1.0"},
+			Digest:      "sha256:111",
+			DstRegistry: dst,
+			DstImageTag: reg.ImageTag{ImageName: imageName, Tag: "1.0"},
+		}
+	}
+
+	inv := reg.MasterInventory{
+		"gcr.io/foo": {
+			"a":        {"sha256:111": {"1.0"}},
+			"team-a/b": {"sha256:111": {"1.0"}},
+			"team-b/c": {"sha256:111": {"1.0"}},
 		},
 	}
 
+	policy, err := reg.MkDestPathPolicy(
+		`us-docker\.pkg\.dev/shared/images/team-a/.+`)
+	require.Nil(t, err)
+
 	tests := []struct {
-		name                  string
-		input                 []reg.Manifest
-		expectedInitial       map[reg.PromotionEdge]interface{}
-		expectedInitialErr    error
-		expectedFiltered      map[reg.PromotionEdge]interface{}
-		expectedFilteredClean bool
+		name          string
+		edges         map[reg.PromotionEdge]interface{}
+		expected      map[reg.PromotionEdge]interface{}
+		expectedClean bool
 	}{
 		{
-			"Basic case (1 new edge; already promoted)",
-			[]reg.Manifest{
-				{
-					Registries: registries1,
-					Images: []reg.Image{
-						{
-							ImageName: "a",
-							Dmap: reg.DigestTags{
-								"sha256:000": {"0.9"},
-							},
-						},
-					},
-					SrcRegistry: &srcRC,
-				},
+			"Compliant destination names",
+			map[reg.PromotionEdge]interface{}{
+				mkEdge(teamRC, "a"):          nil,
+				mkEdge(sharedRC, "team-a/b"): nil,
 			},
 			map[reg.PromotionEdge]interface{}{
-				{
-					SrcRegistry: srcRC,
-					SrcImageTag: reg.ImageTag{
-						ImageName: "a",
-						Tag:       "0.9",
-					},
-					Digest:      "sha256:000",
-					DstRegistry: destRC,
-					DstImageTag: reg.ImageTag{
-						ImageName: "a",
-						Tag:       "0.9",
-					},
-				}: nil,
+				mkEdge(teamRC, "a"):          nil,
+				mkEdge(sharedRC, "team-a/b"): nil,
 			},
-			nil,
-			make(map[reg.PromotionEdge]interface{}),
 			true,
 		},
 		{
-			"Basic case (2 new edges; already promoted)",
-			[]reg.Manifest{
-				{
-					Registries: registries2,
-					Images: []reg.Image{
-						{
-							ImageName: "a",
-							Dmap: reg.DigestTags{
-								"sha256:000": {"0.9"},
-							},
-						},
-					},
-					SrcRegistry: &srcRC,
-				},
+			"Non-compliant destination names",
+			map[reg.PromotionEdge]interface{}{
+				mkEdge(teamRC, "a"):          nil,
+				mkEdge(sharedRC, "a"):        nil,
+				mkEdge(sharedRC, "team-b/c"): nil,
 			},
 			map[reg.PromotionEdge]interface{}{
-				{
-					SrcRegistry: srcRC,
-					SrcImageTag: reg.ImageTag{
-						ImageName: "a",
-						Tag:       "0.9",
-					},
-					Digest:      "sha256:000",
-					DstRegistry: destRC,
-					DstImageTag: reg.ImageTag{
-						ImageName: "a",
-						Tag:       "0.9",
-					},
-				}: nil,
-				{
-					SrcRegistry: srcRC,
-					SrcImageTag: reg.ImageTag{
-						ImageName: "a",
-						Tag:       "0.9",
-					},
-					Digest:      "sha256:000",
-					DstRegistry: destRC2,
-					DstImageTag: reg.ImageTag{
-						ImageName: "a",
-						Tag:       "0.9",
-					},
-				}: nil,
+				mkEdge(teamRC, "a"): nil,
 			},
-			nil,
-			make(map[reg.PromotionEdge]interface{}),
-			true,
+			false,
 		},
-		{
-			"Tag move (tag swap image c:2.0 and c:3.0)",
-			[]reg.Manifest{
-				{
-					Registries: registries2,
-					Images: []reg.Image{
-						{
-							ImageName: "c",
-							Dmap: reg.DigestTags{
-								"sha256:222": {"3.0"},
-								"sha256:333": {"2.0"},
-							},
-						},
-					},
-					SrcRegistry: &srcRC,
-				},
-			},
-			map[reg.PromotionEdge]interface{}{
-				{
-					SrcRegistry: srcRC,
-					SrcImageTag: reg.ImageTag{
-						ImageName: "c",
-						Tag:       "2.0",
-					},
-					Digest:      "sha256:333",
-					DstRegistry: destRC,
-					DstImageTag: reg.ImageTag{
-						ImageName: "c",
-						Tag:       "2.0",
-					},
-				}: nil,
-				{
-					SrcRegistry: srcRC,
-					SrcImageTag: reg.ImageTag{
-						ImageName: "c",
-						Tag:       "3.0",
-					},
-					Digest:      "sha256:222",
-					DstRegistry: destRC,
-					DstImageTag: reg.ImageTag{
-						ImageName: "c",
-						Tag:       "3.0",
-					},
-				}: nil,
-				{
-					SrcRegistry: srcRC,
-					SrcImageTag: reg.ImageTag{
-						ImageName: "c",
-						Tag:       "2.0",
-					},
-					Digest:      "sha256:333",
-					DstRegistry: destRC2,
-					DstImageTag: reg.ImageTag{
-						ImageName: "c",
-						Tag:       "2.0",
-					},
-				}: nil,
-				{
-					SrcRegistry: srcRC,
-					SrcImageTag: reg.ImageTag{
-						ImageName: "c",
-						Tag:       "3.0",
-					},
-					Digest:      "sha256:222",
-					DstRegistry: destRC2,
-					DstImageTag: reg.ImageTag{
-						ImageName: "c",
-						Tag:       "3.0",
-					},
-				}: nil,
+	}
+
+	for _, test := range tests {
+		sc := reg.SyncContext{Inv: inv, DestPathPolicy: policy}
+
+		got, gotClean := sc.GetPromotionCandidates(test.edges)
+		require.Equal(t, test.expected, got, test.name)
+		require.Equal(t, test.expectedClean, gotClean, test.name)
+	}
+}
+
+func TestMkDestPathPolicy(t *testing.T) {
+	// The policy must match the whole destination name.
+	policy, err := reg.MkDestPathPolicy(`gcr\.io/shared/team-a|gcr\.io/shared/team-b/.*`)
+	require.Nil(t, err)
+	require.True(t, policy.MatchString("gcr.io/shared/team-a"))
+	require.True(t, policy.MatchString("gcr.io/shared/team-b/foo"))
+	require.False(t, policy.MatchString("gcr.io/shared/team-a/foo"))
+	require.False(t, policy.MatchString("mirror.gcr.io/shared/team-b/foo"))
+
+	_, err = reg.MkDestPathPolicy("")
+	require.NotNil(t, err)
+
+	_, err = reg.MkDestPathPolicy("team-a/(")
+	require.NotNil(t, err)
+	require.Equal(
+		t,
+		"error parsing regexp: missing closing ): `team-a/(`",
+		err.Error())
+}
+
+func TestGetPromotionCandidatesSkipExistingQuietly(t *testing.T) {
+	srcRC := reg.RegistryContext{
+		Name:           "gcr.io/foo",
+		ServiceAccount: "robot",
+		Src:            true,
+	}
+	destRC := reg.RegistryContext{
+		Name:           "gcr.io/bar",
+		ServiceAccount: "robot",
+	}
+
+	mkEdge := func(digest reg.Digest, tag reg.Tag) reg.PromotionEdge {
+		return reg.PromotionEdge{
+			SrcRegistry: srcRC,
+			SrcImageTag: reg.ImageTag{
+				ImageName: "a",
+				Tag:       tag,
 			},
-			nil,
-			make(map[reg.PromotionEdge]interface{}),
+			Digest:      digest,
+			DstRegistry: destRC,
+			DstImageTag: reg.ImageTag{
+				ImageName: "a",
+				Tag:       tag,
+			},
+		}
+	}
+
+	existing := mkEdge("sha256:000", "0.9")
+	existingTagless := mkEdge("sha256:111", "")
+	fresh := mkEdge("sha256:222", "1.0")
+
+	edges := map[reg.PromotionEdge]interface{}{
+		existing:        nil,
+		existingTagless: nil,
+		fresh:           nil,
+	}
+
+	tests := []struct {
+		name                string
+		skipExistingQuietly bool
+		expectedLogged      []reg.PromotionEdge
+		expectedNotLogged   []reg.PromotionEdge
+		expectedSummary     bool
+	}{
+		{
+			"Default (log every edge)",
 			false,
+			[]reg.PromotionEdge{existing, fresh},
+			[]reg.PromotionEdge{},
+			false,
+		},
+		{
+			"Quiet (only log new edges)",
+			true,
+			[]reg.PromotionEdge{fresh},
+			[]reg.PromotionEdge{existing, existingTagless},
+			true,
 		},
 	}
 
 	for _, test := range tests {
-		// Finalize Manifests.
-		for i := range test.input {
-			require.Nil(t, test.input[i].Finalize())
+		sc := reg.SyncContext{
+			Inv: reg.MasterInventory{
+				"gcr.io/foo": reg.RegInvImage{
+					"a": {
+						"sha256:000": {"0.9"},
+						"sha256:111": {},
+						"sha256:222": {"1.0"},
+					},
+				},
+				"gcr.io/bar": {
+					"a": {
+						"sha256:000": {"0.9"},
+						"sha256:111": {},
+					},
+				},
+			},
+			SkipExistingQuietly: test.skipExistingQuietly,
 		}
 
-		got, gotErr := reg.ToPromotionEdges(test.input)
-		if test.expectedInitialErr != nil {
-			require.NotNil(t, gotErr)
-			require.Error(t, gotErr, test.expectedInitialErr)
+		hook := logtest.NewGlobal()
+		got, gotClean := sc.GetPromotionCandidates(edges)
+		logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+
+		// The same edges are promoted either way.
+		require.Equal(
+			t,
+			map[reg.PromotionEdge]interface{}{fresh: nil},
+			got,
+			test.name)
+		require.True(t, gotClean, test.name)
+
+		loggedEdge := func(edge reg.PromotionEdge) bool {
+			for _, entry := range hook.AllEntries() {
+				if strings.HasPrefix(entry.Message, fmt.Sprintf("edge %v:", edge)) {
+					return true
+				}
+			}
+			return false
 		}
-		require.Equal(t, got, test.expectedInitial)
 
-		got, gotClean := sc.GetPromotionCandidates(got)
-		require.Equal(t, got, test.expectedFiltered)
-		require.Equal(t, gotClean, test.expectedFilteredClean)
+		for _, edge := range test.expectedLogged {
+			require.True(t, loggedEdge(edge), test.name)
+		}
+		for _, edge := range test.expectedNotLogged {
+			require.False(t, loggedEdge(edge), test.name)
+		}
+
+		summary := false
+		for _, entry := range hook.AllEntries() {
+			if entry.Message == "skipped 2 edges because they were already promoted" {
+				summary = true
+			}
+		}
+		require.Equal(t, test.expectedSummary, summary, test.name)
 	}
 }
 
@@ -2525,7 +5069,7 @@ func TestPromotion(t *testing.T) {
 			true,
 		},
 		{
-			"Add 1 tag for 2 registries",
+			"Add 1 tag for 2 registries (digest already in dest, so retag only)",
 			reg.Manifest{
 				Registries: registries,
 				Images: []reg.Image{
@@ -2560,7 +5104,7 @@ func TestPromotion(t *testing.T) {
 			nil,
 			reg.CapturedRequests{
 				reg.PromotionRequest{
-					TagOp:          reg.Add,
+					TagOp:          reg.Retag,
 					RegistrySrc:    srcRegName,
 					RegistryDest:   registries[0].Name,
 					ServiceAccount: registries[0].ServiceAccount,
@@ -2570,7 +5114,7 @@ func TestPromotion(t *testing.T) {
 					Tag:            "1.0",
 				}: 1,
 				reg.PromotionRequest{
-					TagOp:          reg.Add,
+					TagOp:          reg.Retag,
 					RegistrySrc:    srcRegName,
 					RegistryDest:   registries[2].Name,
 					ServiceAccount: registries[2].ServiceAccount,
@@ -2583,61 +5127,319 @@ func TestPromotion(t *testing.T) {
 			true,
 		},
 		{
-			"Add 1 tag for 1 registry",
+			"Add 1 tag for 1 registry (digest already in dest, so retag only)",
 			reg.Manifest{
 				Registries: registries,
 				Images: []reg.Image{
 					{
-						ImageName: "a",
+						ImageName: "a",
+						Dmap: reg.DigestTags{
+							"sha256:000": {"0.9", "1.0"},
+						},
+					},
+				},
+				SrcRegistry: &srcRC,
+			},
+			reg.SyncContext{
+				Inv: reg.MasterInventory{
+					"gcr.io/foo": {
+						"a": {
+							"sha256:000": {"0.9"},
+						},
+					},
+					"gcr.io/bar": {
+						"a": {
+							"sha256:000": {"0.9"},
+						},
+					},
+					"gcr.io/cat": {
+						"a": {
+							"sha256:000": {
+								"0.9", "1.0", "extra-tag",
+							},
+						},
+					},
+				},
+			},
+			nil,
+			reg.CapturedRequests{
+				reg.PromotionRequest{
+					TagOp:          reg.Retag,
+					RegistrySrc:    srcRegName,
+					RegistryDest:   registries[0].Name,
+					ServiceAccount: registries[0].ServiceAccount,
+					ImageNameSrc:   "a",
+					ImageNameDest:  "a",
+					Digest:         "sha256:000",
+					Tag:            "1.0",
+				}: 1,
+			},
+			true,
+		},
+	}
+
+	// captured is sort of a "global variable" because processRequestFake
+	// closes over it.
+	captured := make(reg.CapturedRequests)
+	processRequestFake := reg.MkRequestCapturer(&captured)
+
+	nopStream := func(
+		srcRegistry reg.RegistryName,
+		srcImageName reg.ImageName,
+		rc reg.RegistryContext,
+		destImageName reg.ImageName,
+		digest reg.Digest,
+		tag reg.Tag,
+		tp reg.TagOp,
+	) stream.Producer {
+		// We don't even need a stream producer, because we are not creating
+		// subprocesses that generate JSON or any other output; the vanilla
+		// "mkReq" in Promote() already stores all the info we need to check.
+		return nil
+	}
+
+	for _, test := range tests {
+		// Reset captured for each test.
+		captured = make(reg.CapturedRequests)
+		srcReg, err := reg.GetSrcRegistry(registries)
+		require.Nil(t, err)
+
+		test.inputSc.SrcRegistry = srcReg
+
+		// Simulate bad network conditions.
+		if test.badReads != nil {
+			for _, badRead := range test.badReads {
+				test.inputSc.IgnoreFromPromotion(badRead)
+			}
+		}
+
+		edges, err := reg.ToPromotionEdges([]reg.Manifest{test.inputM})
+		require.Nil(t, err)
+
+		filteredEdges, gotClean := test.inputSc.FilterPromotionEdges(
+			edges,
+			false)
+		require.Equal(t, gotClean, test.expectedFilteredClean)
+
+		require.Nil(t, test.inputSc.Promote(
+			filteredEdges,
+			nopStream,
+			&processRequestFake,
+		),
+		)
+
+		require.Equal(t, captured, test.expectedReqs)
+	}
+}
+
+func TestPromotionForceOverwrite(t *testing.T) {
+	srcRC := reg.RegistryContext{
+		Name:           "gcr.io/foo",
+		ServiceAccount: "robot",
+		Src:            true,
+	}
+	destRC := reg.RegistryContext{
+		Name:           "gcr.io/bar",
+		ServiceAccount: "robot",
+	}
+
+	mfest := func(tags ...reg.Tag) reg.Manifest {
+		return reg.Manifest{
+			Registries: []reg.RegistryContext{srcRC, destRC},
+			Images: []reg.Image{
+				{
+					ImageName: "a",
+					Dmap: reg.DigestTags{
+						"sha256:new": tags,
+					},
+				},
+			},
+			SrcRegistry: &srcRC,
+		}
+	}
+
+	request := func(
+		tagOp reg.TagOp,
+		oldDigest reg.Digest,
+		tag reg.Tag,
+	) reg.PromotionRequest {
+		return reg.PromotionRequest{
+			TagOp:          tagOp,
+			RegistrySrc:    srcRC.Name,
+			RegistryDest:   destRC.Name,
+			ServiceAccount: destRC.ServiceAccount,
+			ImageNameSrc:   "a",
+			ImageNameDest:  "a",
+			Digest:         "sha256:new",
+			DigestOld:      oldDigest,
+			Tag:            tag,
+		}
+	}
+
+	// The new digest is already in the destination (under another tag), so
+	// moving the tags only needs a retag.
+	digestInDest := reg.MasterInventory{
+		"gcr.io/foo": {"a": {"sha256:new": {"other"}}},
+		"gcr.io/bar": {
+			"a": {
+				"sha256:new": {"other"},
+				"sha256:old": {"good", "stable"},
+			},
+		},
+	}
+
+	// The new digest is not in the destination yet, so it has to be copied
+	// over the tag.
+	digestNotInDest := reg.MasterInventory{
+		"gcr.io/foo": {"a": {"sha256:new": {"other"}}},
+		"gcr.io/bar": {"a": {"sha256:old": {"good"}}},
+	}
+
+	tests := []struct {
+		name                  string
+		inputM                reg.Manifest
+		inv                   reg.MasterInventory
+		forceOverwrite        map[string]bool
+		expectedReqs          reg.CapturedRequests
+		expectedFilteredClean bool
+	}{
+		{
+			"Tag move is rejected by default",
+			mfest("good"),
+			digestInDest,
+			nil,
+			reg.CapturedRequests{},
+			false,
+		},
+		{
+			"Forced tag is moved with a retag",
+			mfest("good"),
+			digestInDest,
+			map[string]bool{"a:good": true},
+			reg.CapturedRequests{
+				request(reg.Retag, "sha256:old", "good"): 1,
+			},
+			true,
+		},
+		{
+			"Only the forced tag is moved",
+			mfest("good", "stable"),
+			digestInDest,
+			map[string]bool{"a:good": true},
+			reg.CapturedRequests{
+				request(reg.Retag, "sha256:old", "good"): 1,
+			},
+			false,
+		},
+		{
+			"Forced tag of another registry is not moved",
+			mfest("good"),
+			digestInDest,
+			map[string]bool{"gcr.io/cat/a:good": true},
+			reg.CapturedRequests{},
+			false,
+		},
+		{
+			"Tag move is skipped by default",
+			mfest("good"),
+			digestNotInDest,
+			nil,
+			reg.CapturedRequests{},
+			true,
+		},
+		{
+			"Forced tag is overwritten with a copy",
+			mfest("good"),
+			digestNotInDest,
+			map[string]bool{"gcr.io/bar/a:good": true},
+			reg.CapturedRequests{
+				request(reg.Add, "sha256:old", "good"): 1,
+			},
+			true,
+		},
+	}
+
+	captured := make(reg.CapturedRequests)
+	processRequestFake := reg.MkRequestCapturer(&captured)
+
+	logger := logrus.StandardLogger()
+	defer logger.ReplaceHooks(logger.ReplaceHooks(make(logrus.LevelHooks)))
+	hook := logtest.NewLocal(logger)
+
+	for _, test := range tests {
+		captured = make(reg.CapturedRequests)
+		hook.Reset()
+
+		sc := reg.SyncContext{
+			Inv:            test.inv,
+			SrcRegistry:    &srcRC,
+			ForceOverwrite: test.forceOverwrite,
+		}
+
+		edges, err := reg.ToPromotionEdges([]reg.Manifest{test.inputM})
+		require.Nil(t, err, test.name)
+
+		filteredEdges, gotClean := sc.FilterPromotionEdges(edges, false)
+		require.Equal(t, test.expectedFilteredClean, gotClean, test.name)
+
+		require.Nil(t, sc.Promote(
+			filteredEdges,
+			nil,
+			&processRequestFake,
+		), test.name)
+
+		require.Equal(t, test.expectedReqs, captured, test.name)
+
+		// Each forced overwrite is logged once, when it is decided.
+		forced := 0
+		for _, entry := range hook.AllEntries() {
+			if strings.Contains(entry.Message, "FORCED OVERWRITE") {
+				forced++
+			}
+		}
+		require.Equal(t, len(test.expectedReqs), forced, test.name)
+	}
+}
+
+func TestPromotionDispatchOrder(t *testing.T) {
+	srcRC := reg.RegistryContext{
+		Name:           "gcr.io/foo",
+		ServiceAccount: "robot",
+		Src:            true,
+	}
+	destRC := reg.RegistryContext{
+		Name:           "gcr.io/bar",
+		ServiceAccount: "robot",
+	}
+	destRC2 := reg.RegistryContext{
+		Name:           "gcr.io/cat",
+		ServiceAccount: "robot",
+	}
+
+	edges, err := reg.ToPromotionEdges(
+		[]reg.Manifest{
+			{
+				Registries: []reg.RegistryContext{destRC2, srcRC, destRC},
+				Images: []reg.Image{
+					{
+						ImageName: "b",
 						Dmap: reg.DigestTags{
-							"sha256:000": {"0.9", "1.0"},
-						},
-					},
-				},
-				SrcRegistry: &srcRC,
-			},
-			reg.SyncContext{
-				Inv: reg.MasterInventory{
-					"gcr.io/foo": {
-						"a": {
-							"sha256:000": {"0.9"},
-						},
-					},
-					"gcr.io/bar": {
-						"a": {
-							"sha256:000": {"0.9"},
+							"sha256:111": {"1.0", "latest"},
+							"sha256:000": {},
 						},
 					},
-					"gcr.io/cat": {
-						"a": {
-							"sha256:000": {
-								"0.9", "1.0", "extra-tag",
-							},
+					{
+						ImageName: "a",
+						Dmap: reg.DigestTags{
+							"sha256:222": {"2.0"},
 						},
 					},
 				},
+				SrcRegistry: &srcRC,
 			},
-			nil,
-			reg.CapturedRequests{
-				reg.PromotionRequest{
-					TagOp:          reg.Add,
-					RegistrySrc:    srcRegName,
-					RegistryDest:   registries[0].Name,
-					ServiceAccount: registries[0].ServiceAccount,
-					ImageNameSrc:   "a",
-					ImageNameDest:  "a",
-					Digest:         "sha256:000",
-					Tag:            "1.0",
-				}: 1,
-			},
-			true,
 		},
-	}
-
-	// captured is sort of a "global variable" because processRequestFake
-	// closes over it.
-	captured := make(reg.CapturedRequests)
-	processRequestFake := reg.MkRequestCapturer(&captured)
+	)
+	require.Nil(t, err)
 
 	nopStream := func(
 		srcRegistry reg.RegistryName,
@@ -2648,43 +5450,35 @@ func TestPromotion(t *testing.T) {
 		tag reg.Tag,
 		tp reg.TagOp,
 	) stream.Producer {
-		// We don't even need a stream producer, because we are not creating
-		// subprocesses that generate JSON or any other output; the vanilla
-		// "mkReq" in Promote() already stores all the info we need to check.
 		return nil
 	}
 
-	for _, test := range tests {
-		// Reset captured for each test.
-		captured = make(reg.CapturedRequests)
-		srcReg, err := reg.GetSrcRegistry(registries)
-		require.Nil(t, err)
-
-		test.inputSc.SrcRegistry = srcReg
-
-		// Simulate bad network conditions.
-		if test.badReads != nil {
-			for _, badRead := range test.badReads {
-				test.inputSc.IgnoreFromPromotion(badRead)
-			}
-		}
-
-		edges, err := reg.ToPromotionEdges([]reg.Manifest{test.inputM})
-		require.Nil(t, err)
+	expected := []string{}
+	for _, dst := range []string{"gcr.io/bar", "gcr.io/cat"} {
+		expected = append(expected,
+			dst+"/a:2.0@sha256:222",
+			dst+"/b:@sha256:000",
+			dst+"/b:1.0@sha256:111",
+			dst+"/b:latest@sha256:111",
+		)
+	}
 
-		filteredEdges, gotClean := test.inputSc.FilterPromotionEdges(
-			edges,
-			false)
-		require.Equal(t, gotClean, test.expectedFilteredClean)
+	// Dispatch the same edges several times; the order must never change.
+	for i := 0; i < 10; i++ {
+		sc := reg.SyncContext{}
+		reqs := make(chan stream.ExternalRequest, len(edges))
+		wg := new(sync.WaitGroup)
 
-		require.Nil(t, test.inputSc.Promote(
-			filteredEdges,
-			nopStream,
-			&processRequestFake,
-		),
-		)
+		reg.MKPopulateRequestsForPromotionEdges(edges, nopStream)(&sc, reqs, wg)
+		close(reqs)
 
-		require.Equal(t, captured, test.expectedReqs)
+		got := []string{}
+		for req := range reqs {
+			pr := req.RequestParams.(reg.PromotionRequest)
+			got = append(got, fmt.Sprintf("%s/%s:%s@%s",
+				pr.RegistryDest, pr.ImageNameDest, pr.Tag, pr.Digest))
+		}
+		require.Equal(t, expected, got)
 	}
 }
 
@@ -2706,94 +5500,348 @@ func TestExecRequests(t *testing.T) {
 		ServiceAccount: "robot",
 		Src:            true,
 	}
-
-	registries := []reg.RegistryContext{destRC, srcRC, destRC2}
-
-	nopStream := func(
-		srcRegistry reg.RegistryName,
-		srcImageName reg.ImageName,
-		rc reg.RegistryContext,
-		destImageName reg.ImageName,
-		digest reg.Digest,
-		tag reg.Tag,
-		tp reg.TagOp,
-	) stream.Producer {
-		return nil
+
+	registries := []reg.RegistryContext{destRC, srcRC, destRC2}
+
+	nopStream := func(
+		srcRegistry reg.RegistryName,
+		srcImageName reg.ImageName,
+		rc reg.RegistryContext,
+		destImageName reg.ImageName,
+		digest reg.Digest,
+		tag reg.Tag,
+		tp reg.TagOp,
+	) stream.Producer {
+		return nil
+	}
+
+	edges, err := reg.ToPromotionEdges(
+		[]reg.Manifest{
+			{
+				Registries: registries,
+				Images: []reg.Image{
+					{
+						ImageName: "a",
+						Dmap: reg.DigestTags{
+							"sha256:000": {"0.9"},
+						},
+					},
+				},
+				SrcRegistry: &srcRC,
+			},
+		},
+	)
+	require.Nil(t, err)
+
+	populateRequests := reg.MKPopulateRequestsForPromotionEdges(
+		edges,
+		nopStream,
+	)
+
+	var processRequestSuccess reg.ProcessRequest = func(
+		sc *reg.SyncContext,
+		reqs chan stream.ExternalRequest,
+		requestResults chan<- reg.RequestResult,
+		wg *sync.WaitGroup,
+		mutex *sync.Mutex) {
+		for req := range reqs {
+			reqRes := reg.RequestResult{Context: req}
+			requestResults <- reqRes
+		}
+	}
+
+	var processRequestError reg.ProcessRequest = func(
+		sc *reg.SyncContext,
+		reqs chan stream.ExternalRequest,
+		requestResults chan<- reg.RequestResult,
+		wg *sync.WaitGroup,
+		mutex *sync.Mutex) {
+		for req := range reqs {
+			reqRes := reg.RequestResult{Context: req}
+			errors := make(reg.Errors, 0)
+			errors = append(errors, reg.Error{
+				Context: "Running TestExecRequests",
+				Error:   fmt.Errorf("This request results in an error"),
+			})
+			reqRes.Errors = errors
+			requestResults <- reqRes
+		}
+	}
+
+	tests := []struct {
+		name             string
+		processRequestFn reg.ProcessRequest
+		expected         error
+	}{
+		{
+			"Error tracking for successful promotion",
+			processRequestSuccess,
+			nil,
+		},
+		{
+			"Error tracking for promotion with errors",
+			processRequestError,
+			fmt.Errorf("Encountered an error while executing requests"),
+		},
+	}
+
+	for _, test := range tests {
+		got := sc.ExecRequests(populateRequests, test.processRequestFn)
+		require.Equal(t, got, test.expected)
+	}
+}
+
+// slowFake is a stream.Fake that takes a while to produce its stream.
+type slowFake struct {
+	stream.Fake
+	delay time.Duration
+	mutex *sync.Mutex
+	reads *int
+}
+
+func (producer *slowFake) Produce() (io.Reader, io.Reader, error) {
+	producer.mutex.Lock()
+	*producer.reads++
+	producer.mutex.Unlock()
+
+	time.Sleep(producer.delay)
+	return producer.Fake.Produce()
+}
+
+func TestReadRegistriesTimeout(t *testing.T) {
+	const fakeRegName reg.RegistryName = "gcr.io/foo"
+
+	// The toplevel repo has many children, but we run out of time while
+	// reading the toplevel repo itself.
+	children := make([]string, 0)
+	for i := 0; i < 20; i++ {
+		children = append(children, fmt.Sprintf("child%d", i))
+	}
+	rootBody := fmt.Sprintf(`{
+  "child": ["%s"],
+  "manifest": {},
+  "name": "foo",
+  "tags": []
+}`, strings.Join(children, `", "`))
+	childBody := `{
+  "child": [],
+  "manifest": {
+    "sha256:b5b2d91319f049143806baeacc886f82f621e9a2550df856b11b5c22db4570a7": {
+      "imageSizeBytes": "12875324",
+      "layerId": "",
+      "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+      "tag": [
+        "latest"
+      ],
+      "timeCreatedMs": "1501774217070",
+      "timeUploadedMs": "1552917295327"
+    }
+  },
+  "name": "foo/child",
+  "tags": [
+    "latest"
+  ]
+}`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	rcs := []reg.RegistryContext{
+		{
+			Name:           fakeRegName,
+			ServiceAccount: "robot",
+		},
+	}
+	sc := reg.SyncContext{
+		RegistryContexts: rcs,
+		Inv:              map[reg.RegistryName]reg.RegInvImage{fakeRegName: nil},
+		DigestMediaType:  make(reg.DigestMediaType),
+		DigestImageSize:  make(reg.DigestImageSize),
+		Context:          ctx,
+	}
+
+	var mutex sync.Mutex
+	reads := 0
+	mkFakeStream := func(sc *reg.SyncContext, rc reg.RegistryContext) stream.Producer {
+		body := childBody
+		if rc.Name == fakeRegName {
+			body = rootBody
+		}
+
+		return &slowFake{
+			Fake:  stream.Fake{Bytes: []byte(body)},
+			delay: 100 * time.Millisecond,
+			mutex: &mutex,
+			reads: &reads,
+		}
+	}
+
+	sc.ReadRegistries(rcs, true, mkFakeStream)
+
+	// Only the toplevel repo was read; all of its children were cancelled.
+	require.Equal(t, 1, reads)
+	require.Empty(t, sc.Inv[fakeRegName])
+	require.Len(t, sc.Logs.Errors, len(children))
+	for _, e := range sc.Logs.Errors {
+		require.True(t, errors.Is(e.Error, context.DeadlineExceeded))
+	}
+}
+
+func TestPromoteTimeout(t *testing.T) {
+	srcRC := reg.RegistryContext{
+		Name:           "gcr.io/foo",
+		ServiceAccount: "robot",
+		Src:            true,
+	}
+	destRC := reg.RegistryContext{
+		Name:           "gcr.io/bar",
+		ServiceAccount: "robot",
+	}
+
+	edges, err := reg.ToPromotionEdges([]reg.Manifest{
+		{
+			Registries: []reg.RegistryContext{srcRC, destRC},
+			Images: []reg.Image{
+				{
+					ImageName: "a",
+					Dmap: reg.DigestTags{
+						"sha256:000": {"0.9"},
+						"sha256:111": {"1.0"},
+					},
+				},
+			},
+			SrcRegistry: &srcRC,
+		},
+	})
+	require.Nil(t, err)
+
+	// The deadline has already passed, so nothing may be copied.
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+
+	sc := reg.SyncContext{
+		Inv:     reg.MasterInventory{},
+		Context: ctx,
+	}
+
+	nopStream := func(
+		reg.RegistryName,
+		reg.ImageName,
+		reg.RegistryContext,
+		reg.ImageName,
+		reg.Digest,
+		reg.Tag,
+		reg.TagOp,
+	) stream.Producer {
+		return nil
+	}
+
+	err = sc.Promote(edges, nopStream, nil)
+	require.NotNil(t, err)
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+	require.Len(t, sc.Logs.Errors, len(edges))
+}
+
+func TestPromotionSummary(t *testing.T) {
+	srcRC := reg.RegistryContext{
+		Name:           "gcr.io/foo",
+		ServiceAccount: "robot",
+		Src:            true,
+	}
+	destRC := reg.RegistryContext{
+		Name:           "gcr.io/bar",
+		ServiceAccount: "robot",
 	}
 
-	edges, err := reg.ToPromotionEdges(
-		[]reg.Manifest{
-			{
-				Registries: registries,
-				Images: []reg.Image{
-					{
-						ImageName: "a",
-						Dmap: reg.DigestTags{
-							"sha256:000": {"0.9"},
-						},
+	edges, err := reg.ToPromotionEdges([]reg.Manifest{
+		{
+			Registries: []reg.RegistryContext{srcRC, destRC},
+			Images: []reg.Image{
+				{
+					ImageName: "a",
+					Dmap: reg.DigestTags{
+						"sha256:000": {"0.9"},
+						"sha256:111": {"1.0"},
+						"sha256:222": {"1.1"},
 					},
 				},
-				SrcRegistry: &srcRC,
 			},
+			SrcRegistry: &srcRC,
 		},
-	)
+	})
 	require.Nil(t, err)
 
-	populateRequests := reg.MKPopulateRequestsForPromotionEdges(
-		edges,
-		nopStream,
-	)
-
-	var processRequestSuccess reg.ProcessRequest = func(
-		sc *reg.SyncContext,
-		reqs chan stream.ExternalRequest,
-		requestResults chan<- reg.RequestResult,
-		wg *sync.WaitGroup,
-		mutex *sync.Mutex) {
-		for req := range reqs {
-			reqRes := reg.RequestResult{Context: req}
-			requestResults <- reqRes
-		}
+	nopStream := func(
+		reg.RegistryName,
+		reg.ImageName,
+		reg.RegistryContext,
+		reg.ImageName,
+		reg.Digest,
+		reg.Tag,
+		reg.TagOp,
+	) stream.Producer {
+		return nil
 	}
 
-	var processRequestError reg.ProcessRequest = func(
-		sc *reg.SyncContext,
-		reqs chan stream.ExternalRequest,
-		requestResults chan<- reg.RequestResult,
-		wg *sync.WaitGroup,
-		mutex *sync.Mutex) {
-		for req := range reqs {
-			reqRes := reg.RequestResult{Context: req}
-			errors := make(reg.Errors, 0)
-			errors = append(errors, reg.Error{
-				Context: "Running TestExecRequests",
-				Error:   fmt.Errorf("This request results in an error"),
-			})
-			reqRes.Errors = errors
-			requestResults <- reqRes
+	// mkProcessRequest fails the requests for the given tags, and lets all
+	// others succeed.
+	mkProcessRequest := func(failTags ...reg.Tag) reg.ProcessRequest {
+		return func(
+			sc *reg.SyncContext,
+			reqs chan stream.ExternalRequest,
+			requestResults chan<- reg.RequestResult,
+			wg *sync.WaitGroup,
+			mutex *sync.Mutex) {
+			for req := range reqs {
+				reqRes := reg.RequestResult{Context: req}
+				pr := req.RequestParams.(reg.PromotionRequest)
+				for _, tag := range failTags {
+					if pr.Tag == tag {
+						reqRes.Errors = reg.Errors{{
+							Context: "Running TestPromotionSummary",
+							Error:   fmt.Errorf("cannot copy %v", tag),
+						}}
+					}
+				}
+				requestResults <- reqRes
+			}
 		}
 	}
 
-	tests := []struct {
-		name             string
-		processRequestFn reg.ProcessRequest
-		expected         error
+	var tests = []struct {
+		name        string
+		failTags    []reg.Tag
+		expected    reg.RequestSummary
+		expectedErr bool
 	}{
 		{
-			"Error tracking for successful promotion",
-			processRequestSuccess,
+			"All requests succeed",
 			nil,
+			reg.RequestSummary{Succeeded: 3},
+			false,
 		},
 		{
-			"Error tracking for promotion with errors",
-			processRequestError,
-			fmt.Errorf("Encountered an error while executing requests"),
+			"Some requests fail",
+			[]reg.Tag{"1.0"},
+			reg.RequestSummary{Succeeded: 2, Failed: 1},
+			true,
+		},
+		{
+			"All requests fail",
+			[]reg.Tag{"0.9", "1.0", "1.1"},
+			reg.RequestSummary{Failed: 3},
+			true,
 		},
 	}
 
 	for _, test := range tests {
-		got := sc.ExecRequests(populateRequests, test.processRequestFn)
-		require.Equal(t, got, test.expected)
+		sc := reg.SyncContext{Inv: reg.MasterInventory{}}
+		processRequest := mkProcessRequest(test.failTags...)
+
+		err := sc.Promote(edges, nopStream, &processRequest)
+		require.Equal(t, test.expectedErr, err != nil, test.name)
+		require.Equal(t, test.expected, sc.Logs.Promotions, test.name)
+		require.Len(t, sc.Logs.Errors, test.expected.Failed, test.name)
 	}
 }
 
@@ -3090,44 +6138,322 @@ func TestGarbageCollectionMulti(t *testing.T) {
 		},
 	}
 
-	captured := make(reg.CapturedRequests)
-
-	var processRequestFake reg.ProcessRequest = func(
-		sc *reg.SyncContext,
-		reqs chan stream.ExternalRequest,
-		requestResults chan<- reg.RequestResult,
-		wg *sync.WaitGroup,
-		mutex *sync.Mutex,
-	) {
-		for req := range reqs {
-			// TODO: Why are we not checking errors here?
-			// nolint: errcheck
-			pr := req.RequestParams.(reg.PromotionRequest)
-			mutex.Lock()
-			captured[pr]++
-			mutex.Unlock()
-			requestResults <- reg.RequestResult{}
-		}
-	}
-
+	captured := make(reg.CapturedRequests)
+
+	var processRequestFake reg.ProcessRequest = func(
+		sc *reg.SyncContext,
+		reqs chan stream.ExternalRequest,
+		requestResults chan<- reg.RequestResult,
+		wg *sync.WaitGroup,
+		mutex *sync.Mutex,
+	) {
+		for req := range reqs {
+			// TODO: Why are we not checking errors here?
+			// nolint: errcheck
+			pr := req.RequestParams.(reg.PromotionRequest)
+			mutex.Lock()
+			captured[pr]++
+			mutex.Unlock()
+			requestResults <- reg.RequestResult{}
+		}
+	}
+
+	for _, test := range tests {
+		// Reset captured for each test.
+		captured = make(reg.CapturedRequests)
+		nopStream := func(
+			destRC reg.RegistryContext,
+			imageName reg.ImageName,
+			digest reg.Digest,
+		) stream.Producer {
+			return nil
+		}
+
+		srcReg, err := reg.GetSrcRegistry(registries)
+		require.Nil(t, err)
+
+		test.inputSc.SrcRegistry = srcReg
+		test.inputSc.GarbageCollect(test.inputM, nopStream, &processRequestFake)
+
+		require.Equal(t, captured, test.expectedReqs)
+	}
+}
+
+func TestProjectInventory(t *testing.T) {
+	mkEdge := func(imageName reg.ImageName, digest reg.Digest, tag reg.Tag) reg.PromotionEdge {
+		return reg.PromotionEdge{
+			SrcRegistry: reg.RegistryContext{Name: "gcr.io/foo-staging"},
+			SrcImageTag: reg.ImageTag{ImageName: imageName, Tag: tag},
+			Digest:      digest,
+			DstRegistry: reg.RegistryContext{Name: "gcr.io/foo"},
+			DstImageTag: reg.ImageTag{ImageName: imageName, Tag: tag},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		current  reg.RegInvImage
+		edges    []reg.PromotionEdge
+		expected reg.RegInvImage
+	}{
+		{
+			"No edges",
+			reg.RegInvImage{
+				"a": {"sha256:000": {"1.0"}},
+			},
+			[]reg.PromotionEdge{},
+			reg.RegInvImage{
+				"a": {"sha256:000": {"1.0"}},
+			},
+		},
+		{
+			"New image into empty registry",
+			nil,
+			[]reg.PromotionEdge{
+				mkEdge("a", "sha256:000", "1.0"),
+				mkEdge("a", "sha256:000", "latest"),
+			},
+			reg.RegInvImage{
+				"a": {"sha256:000": {"1.0", "latest"}},
+			},
+		},
+		{
+			"New digest and tag for existing image",
+			reg.RegInvImage{
+				"a": {"sha256:000": {"1.0"}},
+				"b": {"sha256:bbb": {"2.0"}},
+			},
+			[]reg.PromotionEdge{
+				mkEdge("a", "sha256:111", "1.1"),
+			},
+			reg.RegInvImage{
+				"a": {
+					"sha256:000": {"1.0"},
+					"sha256:111": {"1.1"},
+				},
+				"b": {"sha256:bbb": {"2.0"}},
+			},
+		},
+		{
+			"Tagless edge",
+			reg.RegInvImage{
+				"a": {"sha256:000": {"1.0"}},
+			},
+			[]reg.PromotionEdge{
+				mkEdge("a", "sha256:111", ""),
+			},
+			reg.RegInvImage{
+				"a": {
+					"sha256:000": {"1.0"},
+					"sha256:111": {},
+				},
+			},
+		},
+		{
+			"New tag for existing digest",
+			reg.RegInvImage{
+				"a": {"sha256:000": {"1.0"}},
+			},
+			[]reg.PromotionEdge{
+				mkEdge("a", "sha256:000", "stable"),
+			},
+			reg.RegInvImage{
+				"a": {"sha256:000": {"1.0", "stable"}},
+			},
+		},
+		{
+			"Tag move",
+			reg.RegInvImage{
+				"a": {"sha256:000": {"1.0", "latest"}},
+			},
+			[]reg.PromotionEdge{
+				mkEdge("a", "sha256:111", "latest"),
+			},
+			reg.RegInvImage{
+				"a": {
+					"sha256:000": {"1.0"},
+					"sha256:111": {"latest"},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		var before reg.RegInvImage
+		if test.current != nil {
+			before = reg.ProjectInventory(test.current, nil)
+		}
+
+		got := reg.ProjectInventory(test.current, test.edges)
+		require.Equal(t, test.expected, got, test.name)
+
+		// The current inventory must be left alone.
+		if test.current != nil {
+			require.Equal(t, before, test.current, test.name)
+		}
+	}
+}
+
+func TestFilterByDigest(t *testing.T) {
+	rii := reg.RegInvImage{
+		"foo": {
+			"sha256:000": {"1.0", "latest"},
+			"sha256:111": {"0.9"},
+		},
+		"bar": {
+			"sha256:000": {"bar-1.0"},
+			"sha256:222": {},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		digests  []reg.Digest
+		expected reg.RegInvImage
+	}{
+		{
+			"Digest shared by multiple images",
+			[]reg.Digest{"sha256:000"},
+			reg.RegInvImage{
+				"foo": {"sha256:000": {"1.0", "latest"}},
+				"bar": {"sha256:000": {"bar-1.0"}},
+			},
+		},
+		{
+			"Tagless digest",
+			[]reg.Digest{"sha256:222"},
+			reg.RegInvImage{
+				"bar": {"sha256:222": {}},
+			},
+		},
+		{
+			"Requested digest absent from inventory",
+			[]reg.Digest{"sha256:111", "sha256:fff"},
+			reg.RegInvImage{
+				"foo": {"sha256:111": {"0.9"}},
+			},
+		},
+		{
+			"Only absent digests",
+			[]reg.Digest{"sha256:fff"},
+			reg.RegInvImage{},
+		},
+		{
+			"No digests",
+			[]reg.Digest{},
+			reg.RegInvImage{},
+		},
+	}
+
+	for _, test := range tests {
+		got := reg.FilterByDigest(rii, test.digests)
+		require.Equal(t, test.expected, got, test.name)
+	}
+}
+
+func TestFilterManifestLists(t *testing.T) {
+	rii := reg.RegInvImage{
+		"multi-arch": {
+			"sha256:000": {"1.0"},
+			// The children of the manifest list.
+			"sha256:111": {},
+			"sha256:222": {},
+		},
+		"single-arch": {
+			"sha256:333": {"1.0", "latest"},
+		},
+		"lists-only": {
+			"sha256:444": {"2.0"},
+		},
+	}
+	sc := reg.SyncContext{
+		DigestMediaType: reg.DigestMediaType{
+			"sha256:000": cr.DockerManifestList,
+			"sha256:111": cr.DockerManifestSchema2,
+			"sha256:222": cr.DockerManifestSchema2,
+			"sha256:333": cr.DockerManifestSchema2,
+			"sha256:444": cr.DockerManifestList,
+		},
+	}
+
+	require.Equal(
+		t,
+		reg.RegInvImage{
+			"multi-arch": {"sha256:000": {"1.0"}},
+			"lists-only": {"sha256:444": {"2.0"}},
+		},
+		sc.FilterManifestLists(rii, true))
+
+	require.Equal(
+		t,
+		reg.RegInvImage{
+			"multi-arch": {
+				"sha256:111": {},
+				"sha256:222": {},
+			},
+			"single-arch": {"sha256:333": {"1.0", "latest"}},
+		},
+		sc.FilterManifestLists(rii, false))
+}
+
+func TestExcludeDigests(t *testing.T) {
+	rii := reg.RegInvImage{
+		"foo": {
+			"sha256:000": {"1.0", "latest"},
+			"sha256:111": {"0.9"},
+		},
+		"bar": {
+			"sha256:000": {"bar-1.0"},
+			"sha256:222": {},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		digests  []reg.Digest
+		expected reg.RegInvImage
+	}{
+		{
+			"Digest with multiple tags, shared by multiple images",
+			[]reg.Digest{"sha256:000"},
+			reg.RegInvImage{
+				"foo": {"sha256:111": {"0.9"}},
+				"bar": {"sha256:222": {}},
+			},
+		},
+		{
+			"Tagless digest",
+			[]reg.Digest{"sha256:222"},
+			reg.RegInvImage{
+				"foo": {
+					"sha256:000": {"1.0", "latest"},
+					"sha256:111": {"0.9"},
+				},
+				"bar": {"sha256:000": {"bar-1.0"}},
+			},
+		},
+		{
+			"All digests of an image",
+			[]reg.Digest{"sha256:000", "sha256:222"},
+			reg.RegInvImage{
+				"foo": {"sha256:111": {"0.9"}},
+			},
+		},
+		{
+			"Excluded digest absent from inventory",
+			[]reg.Digest{"sha256:fff"},
+			rii,
+		},
+		{
+			"No digests",
+			[]reg.Digest{},
+			rii,
+		},
+	}
+
 	for _, test := range tests {
-		// Reset captured for each test.
-		captured = make(reg.CapturedRequests)
-		nopStream := func(
-			destRC reg.RegistryContext,
-			imageName reg.ImageName,
-			digest reg.Digest,
-		) stream.Producer {
-			return nil
-		}
-
-		srcReg, err := reg.GetSrcRegistry(registries)
-		require.Nil(t, err)
-
-		test.inputSc.SrcRegistry = srcReg
-		test.inputSc.GarbageCollect(test.inputM, nopStream, &processRequestFake)
-
-		require.Equal(t, captured, test.expectedReqs)
+		got := reg.ExcludeDigests(rii, test.digests)
+		require.Equal(t, test.expected, got, test.name)
 	}
 }
 
@@ -3167,6 +6493,288 @@ func TestSnapshot(t *testing.T) {
 	}
 }
 
+func TestToTable(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  reg.RegInvImage
+		golden string
+	}{
+		{
+			"Multiple tags per digest",
+			reg.RegInvImage{
+				"foo": {
+					"sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa": {"latest", "1.0"},
+					"sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb": {},
+				},
+				"a-much-longer-image-name/bar": {
+					"sha256:cccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccc": {"v0.1.0"},
+				},
+			},
+			"multiple-tags.txt",
+		},
+		{
+			"Empty",
+			reg.RegInvImage{},
+			"empty.txt",
+		},
+	}
+
+	for _, test := range tests {
+		expected, err := os.ReadFile(getTestPath("TestToTable", test.golden))
+		require.Nil(t, err)
+
+		got := test.input.ToTable()
+		require.Equal(t, string(expected), got, test.name)
+	}
+}
+
+func TestToTemplate(t *testing.T) {
+	input := reg.RegInvImage{
+		"foo": {
+			"sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa": {"latest", "1.0"},
+			"sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb": {},
+		},
+		"bar": {
+			"sha256:cccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccc": {"v0.1.0"},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		template    string
+		expected    string
+		expectedErr bool
+	}{
+		{
+			"Image and digest counts",
+			`{{ .Stats.Images }} images, {{ .Stats.Digests }} digests
+{{ range $image, $digests := . }}{{ $image }}: {{ len $digests }}
+{{ end }}`,
+			`2 images, 3 digests
+bar: 1
+foo: 2
+`,
+			false,
+		},
+		{
+			"Functions",
+			`{{ range $digest, $tags := .foo }}{{ shortDigest $digest }} [{{ join (sortedTags $tags) "," }}]
+{{ end }}`,
+			`sha256:aaaaaaaaaaaa [1.0,latest]
+sha256:bbbbbbbbbbbb []
+`,
+			false,
+		},
+		{
+			"Missing image",
+			`{{ .baz }}`,
+			"",
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		tmpl, err := reg.ParseRegInvTemplate(test.name, test.template)
+		require.Nil(t, err, test.name)
+
+		got, err := input.ToTemplate(tmpl)
+		if test.expectedErr {
+			require.NotNil(t, err, test.name)
+			continue
+		}
+
+		require.Nil(t, err, test.name)
+		require.Equal(t, test.expected, got, test.name)
+	}
+}
+
+func TestParseRegInvTemplateInvalid(t *testing.T) {
+	_, err := reg.ParseRegInvTemplate("invalid", "{{ .Stats.Images ")
+	require.NotNil(t, err)
+}
+
+func TestStats(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    reg.RegInvImage
+		expected reg.RegInvStats
+	}{
+		{
+			"Empty",
+			reg.RegInvImage{},
+			reg.RegInvStats{},
+		},
+		{
+			"Tagged and tagless digests",
+			reg.RegInvImage{
+				"foo": {
+					"sha256:000": {"latest", "1.0"},
+					"sha256:111": {},
+				},
+				"bar": {
+					"sha256:222": {"0.1"},
+				},
+				"baz": {
+					// Shared with "foo".
+					"sha256:000": {"2.0"},
+					"sha256:333": nil,
+				},
+				"empty": {},
+			},
+			reg.RegInvStats{
+				Images:         4,
+				Digests:        5,
+				Tags:           4,
+				TaglessDigests: 2,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.expected, test.input.Stats(), test.name)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         reg.RegInvImage
+		expectedError string
+	}{
+		{
+			"Empty",
+			reg.RegInvImage{},
+			"",
+		},
+		{
+			"Consistent snapshot",
+			reg.RegInvImage{
+				"foo": {
+					"sha256:000": {"latest", "1.0"},
+					"sha256:111": {},
+				},
+				"bar": {
+					// The same tag in a different image is fine.
+					"sha256:222": {"latest"},
+				},
+			},
+			"",
+		},
+		{
+			"Duplicate conflicting tags",
+			reg.RegInvImage{
+				"foo": {
+					"sha256:000": {"latest", "1.0"},
+					"sha256:111": {"latest"},
+					"sha256:222": {"1.0", "2.0"},
+				},
+			},
+			`invalid snapshot: 2 problem(s) found:
+  image "foo" has tag "1.0" pointing at multiple digests: sha256:000, sha256:222
+  image "foo" has tag "latest" pointing at multiple digests: sha256:000, sha256:111`,
+		},
+		{
+			"Empty names, digests and tags",
+			reg.RegInvImage{
+				"": {
+					"sha256:000": {"1.0"},
+				},
+				"foo": {
+					"":           {"latest"},
+					"sha256:111": {""},
+				},
+			},
+			`invalid snapshot: 3 problem(s) found:
+  image "foo" has an empty digest
+  image "foo" has an empty tag for digest "sha256:111"
+  image with an empty name`,
+		},
+	}
+
+	for _, test := range tests {
+		err := test.input.Validate()
+		if test.expectedError == "" {
+			require.Nil(t, err, test.name)
+			continue
+		}
+
+		require.NotNil(t, err, test.name)
+		require.Equal(t, test.expectedError, err.Error(), test.name)
+	}
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	mfest, err := reg.ParseManifestFromFile(
+		getTestPath("TestDiffSnapshots", "promoter-manifest.yaml"))
+	require.Nil(t, err)
+
+	edges, err := reg.ToPromotionEdges([]reg.Manifest{mfest})
+	require.Nil(t, err)
+
+	got := reg.EdgesToRegInvImage(edges, "us.gcr.io/foo")
+
+	tests := []struct {
+		name     string
+		expected string
+		diff     string
+	}{
+		{
+			"Matching snapshot",
+			"expected.yaml",
+			"",
+		},
+		{
+			"Mismatching snapshot",
+			"expected-mismatch.yaml",
+			` - name: bar
+   dmap:
+-    "sha256:0000000000000000000000000000000000000000000000000000000000000000": ["1.0"]
++    "sha256:0000000000000000000000000000000000000000000000000000000000000000": ["1.0", "latest"]
+     "sha256:1111111111111111111111111111111111111111111111111111111111111111": []
+ - name: baz
+   dmap:
+     "sha256:2222222222222222222222222222222222222222222222222222222222222222": ["0.1"]
+-- name: qux
+-  dmap:
+-    "sha256:3333333333333333333333333333333333333333333333333333333333333333": []
+`,
+		},
+	}
+
+	for _, test := range tests {
+		images, err := reg.ParseImagesFromFile(
+			getTestPath("TestDiffSnapshots", test.expected))
+		require.Nil(t, err)
+
+		expected := (&reg.Manifest{Images: images}).ToRegInvImage()
+		require.Equal(t, test.diff, reg.DiffSnapshots(expected, got), test.name)
+	}
+}
+
+func TestShortDigest(t *testing.T) {
+	tests := []struct {
+		input    reg.Digest
+		expected string
+	}{
+		{
+			"sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+			"sha256:0123456789ab",
+		},
+		{
+			"sha256:111",
+			"sha256:111",
+		},
+		{
+			"not-a-digest",
+			"not-a-digest",
+		},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.expected, reg.ShortDigest(test.input))
+	}
+}
+
 func TestParseContainerParts(t *testing.T) {
 	type ContainerParts struct {
 		registry   string
@@ -3518,6 +7126,293 @@ func TestPopulateExtraFields(t *testing.T) {
 
 // Helper functions.
 
+// mkLargeManifest creates a manifest that promotes the given number of images
+// (each with a few tagged and tagless digests) to two registries.
+func mkLargeManifest(images int) reg.Manifest {
+	srcRC := reg.RegistryContext{
+		Name: "gcr.io/staging",
+		Src:  true,
+	}
+
+	mfest := reg.Manifest{
+		Registries: []reg.RegistryContext{
+			srcRC,
+			{Name: "us.gcr.io/prod"},
+			{Name: "eu.gcr.io/prod"},
+		},
+		SrcRegistry: &srcRC,
+	}
+
+	for i := 0; i < images; i++ {
+		dmap := make(reg.DigestTags)
+		for j := 0; j < 5; j++ {
+			digest := reg.Digest(fmt.Sprintf("sha256:%064x", i*5+j))
+			if j%2 == 0 {
+				dmap[digest] = reg.TagSlice{
+					reg.Tag(fmt.Sprintf("v%d.0", j)),
+					reg.Tag(fmt.Sprintf("v%d.0.0", j)),
+				}
+			} else {
+				dmap[digest] = reg.TagSlice{}
+			}
+		}
+
+		mfest.Images = append(mfest.Images, reg.Image{
+			ImageName: reg.ImageName(fmt.Sprintf("image-%d", i)),
+			Dmap:      dmap,
+		})
+	}
+
+	return mfest
+}
+
+func TestWriteManifestBasedSnapshot(t *testing.T) {
+	mfests := []reg.Manifest{mkLargeManifest(20)}
+
+	edges, err := reg.ToPromotionEdges(mfests)
+	require.Nil(t, err)
+	rii := reg.EdgesToRegInvImage(edges, "us.gcr.io/prod")
+
+	var b strings.Builder
+	err = reg.WriteManifestBasedSnapshot(&b, mfests, "us.gcr.io/prod", "", false)
+	require.Nil(t, err)
+
+	// Only the order of the lines may differ.
+	expected := strings.Split(rii.ToCSV(), "\n")
+	got := strings.Split(b.String(), "\n")
+	sort.Strings(expected)
+	sort.Strings(got)
+	require.Equal(t, expected, got)
+
+	// An image that is listed in several manifests is written once.
+	b.Reset()
+	err = reg.WriteManifestBasedSnapshot(
+		&b,
+		[]reg.Manifest{mfests[0], mkLargeManifest(20)},
+		"us.gcr.io/prod",
+		"",
+		false)
+	require.Nil(t, err)
+
+	got = strings.Split(b.String(), "\n")
+	sort.Strings(got)
+	require.Equal(t, expected, got)
+
+	// Manifests that promote different digests to the same tag overlap.
+	moved := mkLargeManifest(1)
+	moved.Images[0].Dmap = reg.DigestTags{
+		reg.Digest(fmt.Sprintf("sha256:%064x", 999)): {"v0.0"},
+	}
+	err = reg.WriteManifestBasedSnapshot(
+		io.Discard,
+		[]reg.Manifest{mfests[0], moved},
+		"us.gcr.io/prod",
+		"",
+		false)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "overlapping edges")
+
+	// Manifests that promote an image in a cycle are rejected.
+	rcA := reg.RegistryContext{Name: "gcr.io/a", Src: true}
+	rcB := reg.RegistryContext{Name: "gcr.io/b", Src: true}
+	images := []reg.Image{{
+		ImageName: "x",
+		Dmap:      reg.DigestTags{"sha256:000": {"1.0"}},
+	}}
+	err = reg.WriteManifestBasedSnapshot(
+		io.Discard,
+		[]reg.Manifest{
+			{
+				Registries:  []reg.RegistryContext{rcA, {Name: rcB.Name}},
+				Images:      images,
+				SrcRegistry: &rcA,
+			},
+			{
+				Registries:  []reg.RegistryContext{rcB, {Name: rcA.Name}},
+				Images:      images,
+				SrcRegistry: &rcB,
+			},
+		},
+		"gcr.io/a",
+		"",
+		false)
+	require.EqualError(
+		t,
+		err,
+		`promotion cycle for image "x": gcr.io/a -> gcr.io/b -> gcr.io/a`)
+
+	// The images are moved into the destination namespace, like the edges of
+	// '--dest-namespace' are.
+	edges, err = reg.ToDestNamespace(edges, "mirror")
+	require.Nil(t, err)
+	rii = reg.EdgesToRegInvImage(edges, "us.gcr.io/prod")
+
+	b.Reset()
+	err = reg.WriteManifestBasedSnapshot(&b, mfests, "us.gcr.io/prod", "mirror", false)
+	require.Nil(t, err)
+
+	expected = strings.Split(rii.ToCSV(), "\n")
+	got = strings.Split(b.String(), "\n")
+	sort.Strings(expected)
+	sort.Strings(got)
+	require.Equal(t, expected, got)
+
+	// Errors of single images are still reported.
+	srcRC := *mfests[0].SrcRegistry
+	selfRC := reg.RegistryContext{Name: srcRC.Name, ServiceAccount: "other"}
+	mfests[0].Registries = append(mfests[0].Registries, selfRC)
+	err = reg.WriteManifestBasedSnapshot(io.Discard, mfests, "us.gcr.io/prod", "", false)
+	require.NotNil(t, err)
+}
+
+// heapSamplingWriter discards what is written to it, but records the largest
+// heap size seen on any write.
+type heapSamplingWriter struct {
+	peak uint64
+}
+
+func (w *heapSamplingWriter) Write(p []byte) (int, error) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	if stats.HeapAlloc > w.peak {
+		w.peak = stats.HeapAlloc
+	}
+
+	return len(p), nil
+}
+
+// BenchmarkManifestBasedSnapshot compares the memory that a manifest-based
+// snapshot of a large manifest takes when all promotion edges are converted up
+// front, with when they are converted one image at a time. The "peak-heap-B"
+// metric is the largest heap size seen while writing the snapshot (at which
+// point all edges are still held in memory by the former).
+func BenchmarkManifestBasedSnapshot(b *testing.B) {
+	mfests := []reg.Manifest{mkLargeManifest(5000)}
+
+	b.Run("Edges", func(b *testing.B) {
+		b.ReportAllocs()
+		w := &heapSamplingWriter{}
+		for i := 0; i < b.N; i++ {
+			runtime.GC()
+			edges, err := reg.ToPromotionEdges(mfests)
+			require.Nil(b, err)
+
+			rii := reg.EdgesToRegInvImage(edges, "us.gcr.io/prod")
+			_, err = io.WriteString(w, rii.ToCSV())
+			require.Nil(b, err)
+			runtime.KeepAlive(edges)
+		}
+		b.ReportMetric(float64(w.peak), "peak-heap-B")
+	})
+
+	b.Run("Streaming", func(b *testing.B) {
+		b.ReportAllocs()
+		w := &heapSamplingWriter{}
+		for i := 0; i < b.N; i++ {
+			runtime.GC()
+			err := reg.WriteManifestBasedSnapshot(w, mfests, "us.gcr.io/prod", "", false)
+			require.Nil(b, err)
+		}
+		b.ReportMetric(float64(w.peak), "peak-heap-B")
+	})
+}
+
+// writeLargeThinManifestDir writes a thin manifest directory to a temporary
+// directory, with the given number of manifests (each from its own staging
+// registry) of mkLargeManifest(images).
+func writeLargeThinManifestDir(tb testing.TB, manifests, images int) string {
+	dir := tb.TempDir()
+	rii := mkLargeManifest(images).ToRegInvImage()
+	imagesYAML := rii.ToYAML(reg.YamlMarshalingOpts{})
+
+	for i := 0; i < manifests; i++ {
+		name := fmt.Sprintf("project-%d", i)
+		manifestYAML := fmt.Sprintf(`registries:
+- name: gcr.io/k8s-staging-%d
+  service-account: sa@robot.com
+  src: true
+- name: us.gcr.io/prod/%s
+  service-account: sa@robot.com
+- name: eu.gcr.io/prod/%s
+  service-account: sa@robot.com
+`, i, name, name)
+
+		for file, content := range map[string]string{
+			filepath.Join("manifests", name, "promoter-manifest.yaml"): manifestYAML,
+			filepath.Join("images", name, "images.yaml"):               imagesYAML,
+		} {
+			path := filepath.Join(dir, file)
+			require.Nil(tb, os.MkdirAll(filepath.Dir(path), 0o755))
+			require.Nil(tb, os.WriteFile(path, []byte(content), 0o644))
+		}
+	}
+
+	return dir
+}
+
+func TestToPromotionEdgesManyManifests(t *testing.T) {
+	mfests, err := reg.ParseThinManifestsFromDir(
+		writeLargeThinManifestDir(t, 20, 10))
+	require.Nil(t, err)
+	require.Len(t, mfests, 20)
+
+	// The merged edges are those of every single manifest.
+	expected := make(map[reg.PromotionEdge]interface{})
+	for _, mfest := range mfests {
+		edges, err := reg.ToPromotionEdges([]reg.Manifest{mfest})
+		require.Nil(t, err)
+		for edge := range edges {
+			expected[edge] = nil
+		}
+	}
+	// 20 manifests with 10 images of 3 tagged and 2 tagless digests each,
+	// promoted to 2 registries.
+	require.Len(t, expected, 20*10*(3*2+2)*2)
+
+	for i := 0; i < 3; i++ {
+		got, err := reg.ToPromotionEdges(mfests)
+		require.Nil(t, err)
+		require.Equal(t, expected, got)
+	}
+
+	// The errors of all manifests are reported, in the order of the
+	// manifests.
+	for _, i := range []int{12, 3} {
+		selfRC := reg.RegistryContext{
+			Name:           mfests[i].SrcRegistry.Name,
+			ServiceAccount: "other@robot.com",
+		}
+		mfests[i].Registries = append(mfests[i].Registries, selfRC)
+	}
+	_, err = reg.ToPromotionEdges(mfests)
+	require.NotNil(t, err)
+	require.Equal(
+		t,
+		fmt.Sprintf(`2 manifest(s) could not be converted to promotion edges:
+  manifest %q: registry %q is both the source and a destination of image "image-0" (self-promotion)
+  manifest %q: registry %q is both the source and a destination of image "image-0" (self-promotion)`,
+			mfests[3].Filepath,
+			mfests[3].SrcRegistry.Name,
+			mfests[12].Filepath,
+			mfests[12].SrcRegistry.Name),
+		err.Error())
+}
+
+// BenchmarkToPromotionEdges converts the manifests of a large thin manifest
+// directory to promotion edges.
+func BenchmarkToPromotionEdges(b *testing.B) {
+	mfests, err := reg.ParseThinManifestsFromDir(
+		writeLargeThinManifestDir(b, 200, 50))
+	require.Nil(b, err)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := reg.ToPromotionEdges(mfests)
+		require.Nil(b, err)
+	}
+}
+
 func getTestPath(testName string, paths ...string) string {
 	prefix := []string{
 		os.Getenv("PWD"),
@@ -0,0 +1,229 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// k8sContainerFields are the fields of a Kubernetes pod spec that hold
+// containers. Sidecars are just additional entries in them.
+var k8sContainerFields = []string{
+	"containers",
+	"initContainers",
+	"ephemeralContainers",
+}
+
+// K8sImageRef is a container image reference (as found in the 'image' field of
+// a Kubernetes container), split into its parts. At least one of Tag and
+// Digest is set.
+type K8sImageRef struct {
+	Path   RegistryImagePath
+	Tag    Tag
+	Digest Digest
+}
+
+// TagResolver returns the digest that the given image tag (a "<path>:<tag>"
+// reference) currently points at.
+type TagResolver func(ref string) (Digest, error)
+
+// ParseK8sImageRefs returns the sorted, unique image references of all
+// containers, init containers and ephemeral containers in the given Kubernetes
+// YAML. The YAML may hold multiple documents, such as the output of
+// 'kustomize build'. Pod specs are found wherever they are nested (e.g., in the
+// template of a Deployment, or the job template of a CronJob).
+func ParseK8sImageRefs(r io.Reader) ([]string, error) {
+	found := make(map[string]bool)
+	decoder := yaml.NewDecoder(r)
+	for {
+		var doc interface{}
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		collectK8sImageRefs(doc, found)
+	}
+
+	refs := make([]string, 0, len(found))
+	for ref := range found {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+
+	return refs, nil
+}
+
+// collectK8sImageRefs adds the images of all containers found within node to
+// found.
+func collectK8sImageRefs(node interface{}, found map[string]bool) {
+	switch node := node.(type) {
+	case map[interface{}]interface{}:
+		for _, field := range k8sContainerFields {
+			containers, ok := node[field].([]interface{})
+			if !ok {
+				continue
+			}
+
+			for _, container := range containers {
+				container, ok := container.(map[interface{}]interface{})
+				if !ok {
+					continue
+				}
+
+				if image, ok := container["image"].(string); ok && image != "" {
+					found[image] = true
+				}
+			}
+		}
+
+		for _, value := range node {
+			collectK8sImageRefs(value, found)
+		}
+	case []interface{}:
+		for _, value := range node {
+			collectK8sImageRefs(value, found)
+		}
+	}
+}
+
+// ParseK8sImageRef splits an image reference such as "gcr.io/foo/bar:1.0",
+// "gcr.io/foo/bar@sha256:..." or "gcr.io/foo/bar:1.0@sha256:...". Like
+// Kubernetes, it assumes the "latest" tag for references with neither a tag
+// nor a digest.
+func ParseK8sImageRef(ref string) (K8sImageRef, error) {
+	var parsed K8sImageRef
+
+	path := ref
+	if i := strings.Index(path, "@"); i >= 0 {
+		parsed.Digest = Digest(path[i+1:])
+		if err := ValidateDigest(parsed.Digest); err != nil {
+			return K8sImageRef{}, fmt.Errorf("image %q: %v", ref, err)
+		}
+		path = path[:i]
+	}
+
+	// A colon after the last slash separates the tag; one before it belongs to
+	// the port of the registry host.
+	if i := strings.LastIndex(path, ":"); i > strings.LastIndex(path, "/") {
+		parsed.Tag = Tag(path[i+1:])
+		if err := ValidateTag(parsed.Tag); err != nil {
+			return K8sImageRef{}, fmt.Errorf("image %q: %v", ref, err)
+		}
+		path = path[:i]
+	}
+
+	if path == "" {
+		return K8sImageRef{}, fmt.Errorf("image %q: missing image name", ref)
+	}
+
+	if parsed.Tag == "" && parsed.Digest == "" {
+		parsed.Tag = "latest"
+	}
+	parsed.Path = RegistryImagePath(path)
+
+	return parsed, nil
+}
+
+// K8sImagesToManifest builds a manifest that promotes the given image
+// references (see ParseK8sImageRefs) from the src registry to the dest
+// registry. References that are not in the src registry are skipped. Tags
+// without a digest are resolved to the digest they currently point at with
+// resolve. It is an error if no image is in the src registry, or if a tag
+// points at different digests in different references.
+func K8sImagesToManifest(
+	refs []string,
+	src RegistryContext,
+	dest RegistryContext,
+	resolve TagResolver,
+) (Manifest, error) {
+	rii := make(RegInvImage)
+	for _, ref := range refs {
+		parsed, err := ParseK8sImageRef(ref)
+		if err != nil {
+			return Manifest{}, err
+		}
+
+		prefix := string(src.Name) + "/"
+		if !strings.HasPrefix(string(parsed.Path), prefix) {
+			logrus.Infof("skipping image %q: not in %s", ref, src.Name)
+			continue
+		}
+		imageName := ImageName(strings.TrimPrefix(string(parsed.Path), prefix))
+
+		digest := parsed.Digest
+		if digest == "" {
+			digest, err = resolve(ref)
+			if err != nil {
+				return Manifest{}, fmt.Errorf(
+					"resolving the digest of image %q: %v", ref, err)
+			}
+		}
+
+		if rii[imageName] == nil {
+			rii[imageName] = make(DigestTags)
+		}
+		tags := rii[imageName][digest]
+		if tags == nil {
+			tags = TagSlice{}
+		}
+		if parsed.Tag != "" && !hasTag(tags, parsed.Tag) {
+			tags = append(tags, parsed.Tag)
+		}
+		rii[imageName][digest] = tags
+	}
+
+	if len(rii) == 0 {
+		return Manifest{}, fmt.Errorf("no images of %s found", src.Name)
+	}
+
+	return SnapshotToManifest(rii, src, dest)
+}
+
+// hasTag returns true if tags contains tag.
+func hasTag(tags TagSlice, tag Tag) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MkTagResolverReal resolves tags by asking the registry that holds them.
+func MkTagResolverReal(sc *SyncContext) TagResolver {
+	return func(ref string) (Digest, error) {
+		digest, err := crane.Digest(ref, sc.craneOptions()...)
+		if err != nil {
+			return "", err
+		}
+
+		return Digest(digest), nil
+	}
+}
@@ -0,0 +1,269 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+var (
+	k8sDigestA = reg.Digest("sha256:" + strings.Repeat("a", 64))
+	k8sDigestB = reg.Digest("sha256:" + strings.Repeat("b", 64))
+	k8sDigestC = reg.Digest("sha256:" + strings.Repeat("c", 64))
+	k8sDigestD = reg.Digest("sha256:" + strings.Repeat("d", 64))
+)
+
+func TestParseK8sImageRefs(t *testing.T) {
+	f, err := os.Open(getTestPath("TestParseK8sImageRefs", "rendered.yaml"))
+	require.Nil(t, err)
+	defer f.Close()
+
+	got, err := reg.ParseK8sImageRefs(f)
+	require.Nil(t, err)
+	require.Equal(
+		t,
+		[]string{
+			"docker.io/library/fluentd:v1.14",
+			"gcr.io/staging/agent",
+			"gcr.io/staging/foo/migrate:v1.0",
+			"gcr.io/staging/foo@" + string(k8sDigestA),
+			"gcr.io/staging/proxy:v2.1@" + string(k8sDigestB),
+			"localhost:5000/cleanup:v3",
+		},
+		got,
+	)
+
+	_, err = reg.ParseK8sImageRefs(strings.NewReader("kind: [unterminated"))
+	require.NotNil(t, err)
+}
+
+func TestParseK8sImageRef(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expected      reg.K8sImageRef
+		expectedError bool
+	}{
+		{
+			"Tag",
+			"gcr.io/foo/bar:1.0",
+			reg.K8sImageRef{Path: "gcr.io/foo/bar", Tag: "1.0"},
+			false,
+		},
+		{
+			"Digest",
+			"gcr.io/foo/bar@" + string(k8sDigestA),
+			reg.K8sImageRef{Path: "gcr.io/foo/bar", Digest: k8sDigestA},
+			false,
+		},
+		{
+			"Tag and digest",
+			"gcr.io/foo/bar:1.0@" + string(k8sDigestA),
+			reg.K8sImageRef{
+				Path:   "gcr.io/foo/bar",
+				Tag:    "1.0",
+				Digest: k8sDigestA,
+			},
+			false,
+		},
+		{
+			"Neither tag nor digest",
+			"gcr.io/foo/bar",
+			reg.K8sImageRef{Path: "gcr.io/foo/bar", Tag: "latest"},
+			false,
+		},
+		{
+			"Registry with a port",
+			"localhost:5000/bar",
+			reg.K8sImageRef{Path: "localhost:5000/bar", Tag: "latest"},
+			false,
+		},
+		{
+			"Invalid digest",
+			"gcr.io/foo/bar@sha256:123",
+			reg.K8sImageRef{},
+			true,
+		},
+		{
+			"Invalid tag",
+			"gcr.io/foo/bar:-1.0",
+			reg.K8sImageRef{},
+			true,
+		},
+		{
+			"Missing image name",
+			":1.0",
+			reg.K8sImageRef{},
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		got, err := reg.ParseK8sImageRef(test.input)
+		if test.expectedError {
+			require.NotNil(t, err, test.name)
+			continue
+		}
+		require.Nil(t, err, test.name)
+		require.Equal(t, test.expected, got, test.name)
+	}
+}
+
+func TestK8sImagesToManifest(t *testing.T) {
+	src := reg.RegistryContext{
+		Name:           "gcr.io/staging",
+		ServiceAccount: "src@robot.com",
+	}
+	dest := reg.RegistryContext{
+		Name:           "us.gcr.io/prod",
+		ServiceAccount: "dest@robot.com",
+	}
+
+	// The digests that the tags without a digest point at.
+	resolved := map[string]reg.Digest{
+		"gcr.io/staging/foo/migrate:v1.0": k8sDigestC,
+		"gcr.io/staging/agent":            k8sDigestD,
+		"gcr.io/staging/proxy:v2.1":       k8sDigestC,
+	}
+	resolve := func(ref string) (reg.Digest, error) {
+		if digest, ok := resolved[ref]; ok {
+			return digest, nil
+		}
+		return "", fmt.Errorf("MANIFEST_UNKNOWN")
+	}
+
+	tests := []struct {
+		name          string
+		refs          []string
+		expected      []reg.Image
+		expectedEdges int
+		expectedError string
+	}{
+		{
+			"Deployment and DaemonSet",
+			[]string{
+				"docker.io/library/fluentd:v1.14",
+				"gcr.io/staging/agent",
+				"gcr.io/staging/foo/migrate:v1.0",
+				"gcr.io/staging/foo@" + string(k8sDigestA),
+				"gcr.io/staging/proxy:v2.1@" + string(k8sDigestB),
+			},
+			[]reg.Image{
+				{
+					ImageName: "agent",
+					Dmap:      reg.DigestTags{k8sDigestD: {"latest"}},
+				},
+				{
+					ImageName: "foo",
+					Dmap:      reg.DigestTags{k8sDigestA: {}},
+				},
+				{
+					ImageName: "foo/migrate",
+					Dmap:      reg.DigestTags{k8sDigestC: {"v1.0"}},
+				},
+				{
+					ImageName: "proxy",
+					Dmap:      reg.DigestTags{k8sDigestB: {"v2.1"}},
+				},
+			},
+			// The untagged digest of "foo" is promoted without a tag.
+			4,
+			"",
+		},
+		{
+			"Several tags of the same digest",
+			[]string{
+				"gcr.io/staging/proxy:v2.1",
+				"gcr.io/staging/proxy:v2@" + string(k8sDigestC),
+			},
+			[]reg.Image{
+				{
+					ImageName: "proxy",
+					Dmap:      reg.DigestTags{k8sDigestC: {"v2", "v2.1"}},
+				},
+			},
+			2,
+			"",
+		},
+		{
+			"Tag pointing at different digests",
+			[]string{
+				"gcr.io/staging/proxy:v2.1",
+				"gcr.io/staging/proxy:v2.1@" + string(k8sDigestB),
+			},
+			nil,
+			0,
+			`tag "v2.1" pointing at multiple digests`,
+		},
+		{
+			"Tag that cannot be resolved",
+			[]string{"gcr.io/staging/unknown:v1"},
+			nil,
+			0,
+			"MANIFEST_UNKNOWN",
+		},
+		{
+			"No images of the source registry",
+			[]string{"docker.io/library/fluentd:v1.14"},
+			nil,
+			0,
+			"no images of gcr.io/staging found",
+		},
+	}
+
+	for _, test := range tests {
+		got, err := reg.K8sImagesToManifest(test.refs, src, dest, resolve)
+		if test.expectedError != "" {
+			require.NotNil(t, err, test.name)
+			require.Contains(t, err.Error(), test.expectedError, test.name)
+			continue
+		}
+		require.Nil(t, err, test.name)
+
+		require.Equal(t, test.expected, got.Images, test.name)
+		require.Equal(
+			t,
+			[]reg.RegistryContext{
+				{
+					Name:           src.Name,
+					ServiceAccount: src.ServiceAccount,
+					Src:            true,
+				},
+				dest,
+			},
+			got.Registries,
+			test.name,
+		)
+		require.NotNil(t, got.SrcRegistry, test.name)
+		require.Equal(t, src.Name, got.SrcRegistry.Name, test.name)
+
+		edges, err := reg.ToPromotionEdges([]reg.Manifest{got})
+		require.Nil(t, err, test.name)
+		require.Len(t, edges, test.expectedEdges, test.name)
+		for edge := range edges {
+			require.Equal(t, src.Name, edge.SrcRegistry.Name, test.name)
+			require.Equal(t, dest.Name, edge.DstRegistry.Name, test.name)
+		}
+	}
+}
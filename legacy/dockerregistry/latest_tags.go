@@ -0,0 +1,227 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FilterLatestTags keeps only the n newest tags of each image in rii, and
+// drops all digests that are left without tags (including the ones that had
+// no tags to begin with). If n is not positive, rii is returned as is.
+//
+// Tags are ranked as follows:
+//
+//  1. Tags that are semantic versions (e.g., "v1.2.3" or "1.2.3-rc.1") are
+//     newer than all other tags, and are ordered by their version.
+//  2. All other tags (e.g., "latest" or "20210101-abcdef") are ordered by the
+//     upload time of their digest, as given by uploaded. Digests without an
+//     upload time are the oldest.
+//  3. Ties (equal versions such as "1.0.0" and "v1.0.0", equal upload times,
+//     or several tags of the same digest) are broken by the upload time (for
+//     semantic versions), and then by the tag name, in lexicographic order.
+func FilterLatestTags(
+	rii RegInvImage,
+	n int,
+	uploaded DigestUploadTime,
+) RegInvImage {
+	if n <= 0 {
+		return rii
+	}
+
+	filtered := make(RegInvImage)
+	for imageName, digestTags := range rii {
+		type rankedTag struct {
+			tag     Tag
+			digest  Digest
+			version *semver
+		}
+
+		ranked := []rankedTag{}
+		for digest, tags := range digestTags {
+			for _, tag := range tags {
+				rt := rankedTag{tag: tag, digest: digest}
+				if v, ok := parseSemver(tag); ok {
+					rt.version = &v
+				}
+				ranked = append(ranked, rt)
+			}
+		}
+
+		sort.Slice(ranked, func(i, j int) bool {
+			a, b := ranked[i], ranked[j]
+			if (a.version != nil) != (b.version != nil) {
+				return a.version != nil
+			}
+
+			if a.version != nil {
+				if c := a.version.compare(*b.version); c != 0 {
+					return c > 0
+				}
+			}
+
+			ta, tb := uploaded[a.digest], uploaded[b.digest]
+			if !ta.Equal(tb) {
+				return ta.After(tb)
+			}
+
+			return a.tag < b.tag
+		})
+
+		if len(ranked) > n {
+			ranked = ranked[:n]
+		}
+
+		for _, rt := range ranked {
+			if filtered[imageName] == nil {
+				filtered[imageName] = make(DigestTags)
+			}
+			filtered[imageName][rt.digest] = append(
+				filtered[imageName][rt.digest],
+				rt.tag,
+			)
+		}
+	}
+
+	for _, digestTags := range filtered {
+		for _, tags := range digestTags {
+			sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+		}
+	}
+
+	return filtered
+}
+
+// semver is a semantic version (see https://semver.org). Build metadata is not
+// supported, as "+" cannot appear in a tag.
+type semver struct {
+	major, minor, patch uint64
+	prerelease          []string
+}
+
+// parseSemver parses a tag of the form "[v]MAJOR.MINOR.PATCH[-PRERELEASE]".
+func parseSemver(tag Tag) (semver, bool) {
+	s := strings.TrimPrefix(string(tag), "v")
+
+	var prerelease []string
+	if i := strings.Index(s, "-"); i >= 0 {
+		prerelease = strings.Split(s[i+1:], ".")
+		for _, id := range prerelease {
+			if id == "" || (isNumeric(id) && len(id) > 1 && id[0] == '0') {
+				return semver{}, false
+			}
+		}
+		s = s[:i]
+	}
+
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return semver{}, false
+	}
+
+	var nums [3]uint64
+	for i, part := range parts {
+		if !isNumeric(part) || (len(part) > 1 && part[0] == '0') {
+			return semver{}, false
+		}
+
+		num, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return semver{}, false
+		}
+		nums[i] = num
+	}
+
+	return semver{
+		major:      nums[0],
+		minor:      nums[1],
+		patch:      nums[2],
+		prerelease: prerelease,
+	}, true
+}
+
+// compare returns a negative number if v is lower than other, a positive
+// number if it is higher, and 0 if both are equal, following the precedence
+// rules of semantic versioning (e.g., 1.0.0-rc.1 < 1.0.0 < 1.0.1).
+func (v semver) compare(other semver) int {
+	for _, c := range [][2]uint64{
+		{v.major, other.major},
+		{v.minor, other.minor},
+		{v.patch, other.patch},
+	} {
+		if c[0] != c[1] {
+			if c[0] < c[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	// A release is higher than any of its pre-releases.
+	switch {
+	case len(v.prerelease) == 0 && len(other.prerelease) == 0:
+		return 0
+	case len(v.prerelease) == 0:
+		return 1
+	case len(other.prerelease) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(v.prerelease) && i < len(other.prerelease); i++ {
+		if c := comparePrereleaseID(v.prerelease[i], other.prerelease[i]); c != 0 {
+			return c
+		}
+	}
+
+	return len(v.prerelease) - len(other.prerelease)
+}
+
+// comparePrereleaseID compares two pre-release identifiers: numeric ones are
+// compared numerically, and are lower than alphanumeric ones, which are
+// compared lexicographically.
+func comparePrereleaseID(a, b string) int {
+	aNum, bNum := isNumeric(a), isNumeric(b)
+	switch {
+	case aNum && bNum:
+		if len(a) != len(b) {
+			return len(a) - len(b)
+		}
+		return strings.Compare(a, b)
+	case aNum:
+		return -1
+	case bNum:
+		return 1
+	}
+
+	return strings.Compare(a, b)
+}
+
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
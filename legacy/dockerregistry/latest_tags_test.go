@@ -0,0 +1,230 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+func TestFilterLatestTags(t *testing.T) {
+	t0 := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	uploaded := reg.DigestUploadTime{
+		"sha256:000": t0,
+		"sha256:111": t0.Add(time.Hour),
+		"sha256:222": t0.Add(2 * time.Hour),
+		"sha256:333": t0.Add(3 * time.Hour),
+	}
+
+	tests := []struct {
+		name     string
+		input    reg.RegInvImage
+		n        int
+		expected reg.RegInvImage
+	}{
+		{
+			"Semantic versions are ordered by version, not upload time",
+			reg.RegInvImage{
+				"foo": {
+					"sha256:000": {"v1.10.0"},
+					"sha256:111": {"v1.9.0"},
+					"sha256:222": {"v1.2.0"},
+					"sha256:333": {"v1.10.0-rc.1"},
+				},
+			},
+			2,
+			reg.RegInvImage{
+				"foo": {
+					"sha256:000": {"v1.10.0"},
+					"sha256:333": {"v1.10.0-rc.1"},
+				},
+			},
+		},
+		{
+			"Pre-release precedence",
+			reg.RegInvImage{
+				"foo": {
+					"sha256:000": {"1.0.0-alpha", "1.0.0-alpha.1"},
+					"sha256:111": {"1.0.0-beta.2", "1.0.0-beta.11"},
+					"sha256:222": {"1.0.0-rc.1"},
+				},
+			},
+			3,
+			reg.RegInvImage{
+				"foo": {
+					"sha256:111": {"1.0.0-beta.11", "1.0.0-beta.2"},
+					"sha256:222": {"1.0.0-rc.1"},
+				},
+			},
+		},
+		{
+			"Other tags are ordered by upload time",
+			reg.RegInvImage{
+				"foo": {
+					"sha256:000": {"20210101-aaaaaaa"},
+					"sha256:111": {"20210102-bbbbbbb"},
+					"sha256:222": {"latest"},
+				},
+			},
+			2,
+			reg.RegInvImage{
+				"foo": {
+					"sha256:111": {"20210102-bbbbbbb"},
+					"sha256:222": {"latest"},
+				},
+			},
+		},
+		{
+			"Semantic versions are newer than other tags",
+			reg.RegInvImage{
+				"foo": {
+					"sha256:000": {"v0.1.0"},
+					"sha256:333": {"latest"},
+				},
+			},
+			1,
+			reg.RegInvImage{
+				"foo": {
+					"sha256:000": {"v0.1.0"},
+				},
+			},
+		},
+		{
+			"Equal versions are ordered by upload time",
+			reg.RegInvImage{
+				"foo": {
+					"sha256:000": {"v1.0.0"},
+					"sha256:111": {"1.0.0"},
+				},
+			},
+			1,
+			reg.RegInvImage{
+				"foo": {
+					"sha256:111": {"1.0.0"},
+				},
+			},
+		},
+		{
+			"Tags of the same digest are ordered by name",
+			reg.RegInvImage{
+				"foo": {
+					"sha256:000": {"c", "a", "b"},
+				},
+			},
+			2,
+			reg.RegInvImage{
+				"foo": {
+					"sha256:000": {"a", "b"},
+				},
+			},
+		},
+		{
+			"Digests without tags or without an upload time are dropped",
+			reg.RegInvImage{
+				"foo": {
+					"sha256:000": {"old"},
+					"sha256:111": {},
+					"sha256:444": {"unknown"},
+				},
+			},
+			1,
+			reg.RegInvImage{
+				"foo": {
+					"sha256:000": {"old"},
+				},
+			},
+		},
+		{
+			"Each image is filtered separately",
+			reg.RegInvImage{
+				"foo": {
+					"sha256:000": {"1.0.0"},
+					"sha256:111": {"1.1.0"},
+				},
+				"bar": {
+					"sha256:222": {"2.0.0"},
+				},
+			},
+			1,
+			reg.RegInvImage{
+				"foo": {
+					"sha256:111": {"1.1.0"},
+				},
+				"bar": {
+					"sha256:222": {"2.0.0"},
+				},
+			},
+		},
+		{
+			"Invalid semantic versions are other tags",
+			reg.RegInvImage{
+				"foo": {
+					"sha256:000": {"1.02.0"},
+					"sha256:111": {"1.2"},
+					"sha256:222": {"v1.0.0-rc.01"},
+					"sha256:333": {"0.0.1"},
+				},
+			},
+			2,
+			reg.RegInvImage{
+				"foo": {
+					"sha256:222": {"v1.0.0-rc.01"},
+					"sha256:333": {"0.0.1"},
+				},
+			},
+		},
+		{
+			"Fewer tags than N",
+			reg.RegInvImage{
+				"foo": {
+					"sha256:000": {"1.0.0", "latest"},
+				},
+			},
+			5,
+			reg.RegInvImage{
+				"foo": {
+					"sha256:000": {"1.0.0", "latest"},
+				},
+			},
+		},
+		{
+			"Filter disabled",
+			reg.RegInvImage{
+				"foo": {
+					"sha256:000": {"1.0.0"},
+					"sha256:111": {},
+				},
+			},
+			0,
+			reg.RegInvImage{
+				"foo": {
+					"sha256:000": {"1.0.0"},
+					"sha256:111": {},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		got := reg.FilterLatestTags(test.input, test.n, uploaded)
+		require.Equal(t, test.expected, got, test.name)
+	}
+}
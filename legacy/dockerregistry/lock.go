@@ -0,0 +1,563 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/googleapi"
+	storage "google.golang.org/api/storage/v1"
+)
+
+const (
+	// DefaultPromotionLockTTL is how long a promotion lock is held past its
+	// last renewal (see PromotionLock.Acquire). It bounds how long a crashed
+	// run can block other runs.
+	DefaultPromotionLockTTL = time.Hour
+	// DefaultPromotionLockPollInterval is how often a run that waits for a
+	// promotion lock checks whether the lock was released.
+	DefaultPromotionLockPollInterval = 10 * time.Second
+)
+
+var (
+	// ErrLockHeld is returned by LockBackend.Create if the lock already
+	// exists.
+	ErrLockHeld = errors.New("lock is held")
+	// ErrLockNotFound is returned by LockBackend.Get, LockBackend.Renew and
+	// LockBackend.Delete if the lock (in the given generation) does not exist.
+	ErrLockNotFound = errors.New("lock not found")
+)
+
+// LockRecord is the content of a held lock.
+type LockRecord struct {
+	// Holder identifies the run that holds the lock (for debugging).
+	Holder string
+	// Expires is the time after which the lock may be taken over by others.
+	Expires time.Time
+}
+
+// LockBackend stores locks. Every lock has a generation, which changes
+// whenever the lock is re-created; this prevents a run from deleting a lock
+// that was taken over by another run.
+type LockBackend interface {
+	// Create creates the named lock, unless it exists already (in which case
+	// ErrLockHeld is returned). It returns the generation of the new lock.
+	Create(ctx context.Context, name string, record LockRecord) (int64, error)
+	// Get returns the named lock and its generation, or ErrLockNotFound.
+	Get(ctx context.Context, name string) (LockRecord, int64, error)
+	// Renew replaces the record of the named lock, but only if it is still
+	// in the given generation (which it keeps). Otherwise, ErrLockNotFound is
+	// returned.
+	Renew(
+		ctx context.Context,
+		name string,
+		generation int64,
+		record LockRecord,
+	) error
+	// Delete deletes the named lock, but only if it is still in the given
+	// generation. Otherwise, ErrLockNotFound is returned.
+	Delete(ctx context.Context, name string, generation int64) error
+}
+
+// PromotionLock serializes promotions into the same destination registry
+// across concurrent runs of the promoter (e.g., from overlapping CI
+// triggers). The lock of a registry is named after the hash of its name.
+type PromotionLock struct {
+	Backend LockBackend
+	// Holder identifies this run in the locks it holds.
+	Holder string
+	// TTL is how long a lock is held past its last renewal; the locks are
+	// renewed every third of it. If zero, DefaultPromotionLockTTL is used.
+	TTL time.Duration
+	// PollInterval is how often a held lock is checked while waiting for it.
+	// If zero, DefaultPromotionLockPollInterval is used.
+	PollInterval time.Duration
+	// Clock decides when locks expire, and paces the polling and renewals.
+	// If nil, RealClock is used.
+	Clock Clock
+}
+
+// ttl returns the TTL of l, or DefaultPromotionLockTTL if it has none.
+func (l *PromotionLock) ttl() time.Duration {
+	if l.TTL == 0 {
+		return DefaultPromotionLockTTL
+	}
+
+	return l.TTL
+}
+
+// clock returns the Clock of l, or RealClock if it has none.
+func (l *PromotionLock) clock() Clock {
+	if l.Clock == nil {
+		return RealClock{}
+	}
+
+	return l.Clock
+}
+
+// LockName returns the (content-addressed) name of the lock of the given
+// destination registry.
+func LockName(registry RegistryName) string {
+	return fmt.Sprintf("%x.lock", sha256.Sum256([]byte(registry)))
+}
+
+// Acquire acquires the locks of the given registries, waiting for as long as
+// they are held by others (or until ctx is done). The locks are acquired in a
+// fixed order, so that runs that need several of the same locks cannot
+// deadlock. The locks are renewed in the background until they are released
+// by the returned function. The returned context is derived from ctx, and is
+// cancelled if any of the locks is lost (i.e., taken over by another run, or
+// expired because it could not be renewed in time); the writes to the
+// registries must be made with it.
+func (l *PromotionLock) Acquire(
+	ctx context.Context,
+	registries []RegistryName,
+) (context.Context, func(), error) {
+	sorted := make([]RegistryName, len(registries))
+	copy(sorted, registries)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	lockCtx, cancel := context.WithCancel(ctx)
+	releases := make([]func(), 0, len(sorted))
+	releaseAll := func() {
+		for i := len(releases) - 1; i >= 0; i-- {
+			releases[i]()
+		}
+		cancel()
+	}
+
+	for i, registry := range sorted {
+		// Skip duplicates.
+		if i > 0 && registry == sorted[i-1] {
+			continue
+		}
+
+		release, err := l.acquire(lockCtx, cancel, registry)
+		if err != nil {
+			releaseAll()
+			return nil, nil, err
+		}
+		releases = append(releases, release)
+	}
+
+	return lockCtx, releaseAll, nil
+}
+
+// acquire acquires the lock of a single registry, and renews it until it is
+// released. If the lock is lost in the meantime, lost is called.
+func (l *PromotionLock) acquire(
+	ctx context.Context,
+	lost func(),
+	registry RegistryName,
+) (func(), error) {
+	name := LockName(registry)
+	clock := l.clock()
+	pollInterval := l.PollInterval
+	if pollInterval == 0 {
+		pollInterval = DefaultPromotionLockPollInterval
+	}
+
+	for {
+		expires := clock.Now().Add(l.ttl())
+		generation, err := l.Backend.Create(
+			ctx,
+			name,
+			LockRecord{Holder: l.Holder, Expires: expires})
+		if err == nil {
+			logrus.Infof("Acquired promotion lock %s for %s", name, registry)
+
+			stop := make(chan struct{})
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				l.renew(ctx, lost, registry, name, generation, expires, stop)
+			}()
+
+			return func() {
+				close(stop)
+				<-stopped
+				l.release(registry, name, generation)
+			}, nil
+		}
+		if !errors.Is(err, ErrLockHeld) {
+			return nil, fmt.Errorf(
+				"acquiring promotion lock for %s: %w", registry, err)
+		}
+
+		record, heldGeneration, err := l.Backend.Get(ctx, name)
+		switch {
+		case errors.Is(err, ErrLockNotFound):
+			// Released in the meantime; try again right away.
+			continue
+		case err != nil:
+			return nil, fmt.Errorf(
+				"reading promotion lock for %s: %w", registry, err)
+		case clock.Now().After(record.Expires):
+			// The holder did not release the lock in time (e.g., it
+			// crashed). Take it over; if another run is faster, the
+			// deletion fails and we simply try again.
+			logrus.Warnf(
+				"Promotion lock for %s held by %q expired at %v; taking it over",
+				registry,
+				record.Holder,
+				record.Expires)
+			err = l.Backend.Delete(ctx, name, heldGeneration)
+			if err != nil && !errors.Is(err, ErrLockNotFound) {
+				return nil, fmt.Errorf(
+					"taking over promotion lock for %s: %w", registry, err)
+			}
+			continue
+		}
+
+		logrus.Infof(
+			"Waiting for promotion lock for %s (held by %q until %v)",
+			registry,
+			record.Holder,
+			record.Expires)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf(
+				"waiting for promotion lock for %s: %w", registry, ctx.Err())
+		case <-clock.After(pollInterval):
+		}
+	}
+}
+
+// renew moves the expiry of a lock acquired by acquire ahead every third of
+// the TTL, until stop is closed (or ctx is done). If the lock was taken over
+// by another run, or could not be renewed before it expired, lost is called
+// and the renewals stop.
+func (l *PromotionLock) renew(
+	ctx context.Context,
+	lost func(),
+	registry RegistryName,
+	name string,
+	generation int64,
+	expires time.Time,
+	stop <-chan struct{},
+) {
+	clock := l.clock()
+	ttl := l.ttl()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		case <-clock.After(ttl / 3):
+		}
+
+		next := clock.Now().Add(ttl)
+		err := l.Backend.Renew(
+			ctx,
+			name,
+			generation,
+			LockRecord{Holder: l.Holder, Expires: next})
+		switch {
+		case err == nil:
+			expires = next
+		case errors.Is(err, ErrLockNotFound):
+			logrus.Errorf(
+				"Promotion lock for %s was taken over by another run; aborting the promotion",
+				registry)
+			lost()
+			return
+		case !clock.Now().Before(expires):
+			logrus.Errorf(
+				"Promotion lock for %s expired at %v, as it could not be renewed (%v); aborting the promotion",
+				registry,
+				expires,
+				err)
+			lost()
+			return
+		default:
+			logrus.Warnf(
+				"Could not renew promotion lock for %s (retrying): %v",
+				registry,
+				err)
+		}
+	}
+}
+
+// release releases a lock acquired by acquire.
+func (l *PromotionLock) release(
+	registry RegistryName,
+	name string,
+	generation int64,
+) {
+	// Release the lock even if the context of the run is done already.
+	err := l.Backend.Delete(context.Background(), name, generation)
+	switch {
+	case errors.Is(err, ErrLockNotFound):
+		logrus.Warnf(
+			"Promotion lock for %s expired and was taken over before it was released",
+			registry)
+	case err != nil:
+		logrus.Errorf(
+			"Could not release promotion lock for %s (it expires by itself): %v",
+			registry,
+			err)
+	default:
+		logrus.Infof("Released promotion lock %s for %s", name, registry)
+	}
+}
+
+// FakeLockBackend is an in-memory LockBackend, for testing.
+type FakeLockBackend struct {
+	mutex       sync.Mutex
+	locks       map[string]LockRecord
+	generations map[string]int64
+	generation  int64
+}
+
+// Create implements LockBackend.
+func (b *FakeLockBackend) Create(
+	ctx context.Context,
+	name string,
+	record LockRecord,
+) (int64, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.locks == nil {
+		b.locks = make(map[string]LockRecord)
+		b.generations = make(map[string]int64)
+	}
+
+	if _, ok := b.locks[name]; ok {
+		return 0, ErrLockHeld
+	}
+
+	b.generation++
+	b.locks[name] = record
+	b.generations[name] = b.generation
+
+	return b.generation, nil
+}
+
+// Get implements LockBackend.
+func (b *FakeLockBackend) Get(
+	ctx context.Context,
+	name string,
+) (LockRecord, int64, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	record, ok := b.locks[name]
+	if !ok {
+		return LockRecord{}, 0, ErrLockNotFound
+	}
+
+	return record, b.generations[name], nil
+}
+
+// Renew implements LockBackend.
+func (b *FakeLockBackend) Renew(
+	ctx context.Context,
+	name string,
+	generation int64,
+	record LockRecord,
+) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, ok := b.locks[name]; !ok || b.generations[name] != generation {
+		return ErrLockNotFound
+	}
+
+	b.locks[name] = record
+
+	return nil
+}
+
+// Delete implements LockBackend.
+func (b *FakeLockBackend) Delete(
+	ctx context.Context,
+	name string,
+	generation int64,
+) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, ok := b.locks[name]; !ok || b.generations[name] != generation {
+		return ErrLockNotFound
+	}
+
+	delete(b.locks, name)
+	delete(b.generations, name)
+
+	return nil
+}
+
+// GCSLockBackend stores locks as objects in a GCS bucket, using GCS object
+// generations for the preconditions.
+type GCSLockBackend struct {
+	Service *storage.Service
+	Bucket  string
+	// Prefix is prepended to all lock names (e.g., "locks/").
+	Prefix string
+}
+
+// Metadata keys of the lock objects in GCS.
+const (
+	gcsLockHolderKey  = "holder"
+	gcsLockExpiresKey = "expires"
+)
+
+// MkGCSLockBackend creates a GCSLockBackend for the given "gs://bucket/prefix"
+// URL, using the application default credentials.
+func MkGCSLockBackend(
+	ctx context.Context,
+	gcsURL string,
+) (*GCSLockBackend, error) {
+	u, err := url.Parse(gcsURL)
+	if err != nil || u.Scheme != "gs" || u.Host == "" {
+		return nil, fmt.Errorf(
+			"invalid GCS URL %q (expected gs://<bucket>[/<prefix>])", gcsURL)
+	}
+
+	service, err := storage.NewService(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("creating GCS client: %w", err)
+	}
+
+	prefix := strings.TrimPrefix(u.Path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	return &GCSLockBackend{
+		Service: service,
+		Bucket:  u.Host,
+		Prefix:  prefix,
+	}, nil
+}
+
+// Create implements LockBackend.
+func (b *GCSLockBackend) Create(
+	ctx context.Context,
+	name string,
+	record LockRecord,
+) (int64, error) {
+	obj := storage.Object{
+		Name: b.Prefix + name,
+		Metadata: map[string]string{
+			gcsLockHolderKey:  record.Holder,
+			gcsLockExpiresKey: record.Expires.UTC().Format(time.RFC3339Nano),
+		},
+	}
+
+	// A generation precondition of 0 means that the object must not exist.
+	created, err := b.Service.Objects.Insert(b.Bucket, &obj).
+		Media(strings.NewReader(record.Holder)).
+		IfGenerationMatch(0).
+		Context(ctx).
+		Do()
+	if isGCSError(err, http.StatusPreconditionFailed) {
+		return 0, ErrLockHeld
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return created.Generation, nil
+}
+
+// Get implements LockBackend.
+func (b *GCSLockBackend) Get(
+	ctx context.Context,
+	name string,
+) (LockRecord, int64, error) {
+	obj, err := b.Service.Objects.Get(b.Bucket, b.Prefix+name).
+		Context(ctx).
+		Do()
+	if isGCSError(err, http.StatusNotFound) {
+		return LockRecord{}, 0, ErrLockNotFound
+	}
+	if err != nil {
+		return LockRecord{}, 0, err
+	}
+
+	// A lock without a valid expiry is treated as expired.
+	expires, _ := time.Parse(time.RFC3339Nano, obj.Metadata[gcsLockExpiresKey])
+
+	return LockRecord{
+		Holder:  obj.Metadata[gcsLockHolderKey],
+		Expires: expires,
+	}, obj.Generation, nil
+}
+
+// Renew implements LockBackend. Only the metadata of the lock object is
+// replaced, which keeps its generation.
+func (b *GCSLockBackend) Renew(
+	ctx context.Context,
+	name string,
+	generation int64,
+	record LockRecord,
+) error {
+	obj := storage.Object{
+		Metadata: map[string]string{
+			gcsLockHolderKey:  record.Holder,
+			gcsLockExpiresKey: record.Expires.UTC().Format(time.RFC3339Nano),
+		},
+	}
+
+	_, err := b.Service.Objects.Patch(b.Bucket, b.Prefix+name, &obj).
+		IfGenerationMatch(generation).
+		Context(ctx).
+		Do()
+	if isGCSError(err, http.StatusNotFound) ||
+		isGCSError(err, http.StatusPreconditionFailed) {
+		return ErrLockNotFound
+	}
+
+	return err
+}
+
+// Delete implements LockBackend.
+func (b *GCSLockBackend) Delete(
+	ctx context.Context,
+	name string,
+	generation int64,
+) error {
+	err := b.Service.Objects.Delete(b.Bucket, b.Prefix+name).
+		IfGenerationMatch(generation).
+		Context(ctx).
+		Do()
+	if isGCSError(err, http.StatusNotFound) ||
+		isGCSError(err, http.StatusPreconditionFailed) {
+		return ErrLockNotFound
+	}
+
+	return err
+}
+
+// isGCSError checks whether err is a GCS API error with the given HTTP status.
+func isGCSError(err error, code int) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
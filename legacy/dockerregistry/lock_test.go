@@ -0,0 +1,415 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/stream"
+)
+
+func TestLockName(t *testing.T) {
+	name := reg.LockName("gcr.io/foo")
+	require.Regexp(t, "^[0-9a-f]{64}\\.lock$", name)
+	require.Equal(t, name, reg.LockName("gcr.io/foo"))
+	require.NotEqual(t, name, reg.LockName("gcr.io/bar"))
+}
+
+func TestPromotionLockMutualExclusion(t *testing.T) {
+	backend := &reg.FakeLockBackend{}
+
+	const runs = 5
+	var (
+		mutex     sync.Mutex
+		active    int
+		maxActive int
+		wg        sync.WaitGroup
+	)
+
+	for i := 0; i < runs; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			lock := reg.PromotionLock{
+				Backend:      backend,
+				Holder:       fmt.Sprintf("run-%d", i),
+				PollInterval: time.Millisecond,
+			}
+			// Every run needs "gcr.io/foo", in different orders.
+			registries := []reg.RegistryName{"gcr.io/foo", "gcr.io/bar"}
+			if i%2 == 0 {
+				registries = []reg.RegistryName{"gcr.io/bar", "gcr.io/foo"}
+			}
+
+			_, release, err := lock.Acquire(context.Background(), registries)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			mutex.Lock()
+			active++
+			if active > maxActive {
+				maxActive = active
+			}
+			mutex.Unlock()
+
+			time.Sleep(5 * time.Millisecond)
+
+			mutex.Lock()
+			active--
+			mutex.Unlock()
+
+			release()
+		}(i)
+	}
+	wg.Wait()
+
+	require.Equal(t, 1, maxActive)
+
+	// All locks were released.
+	for _, registry := range []reg.RegistryName{"gcr.io/foo", "gcr.io/bar"} {
+		_, _, err := backend.Get(context.Background(), reg.LockName(registry))
+		require.True(t, errors.Is(err, reg.ErrLockNotFound))
+	}
+}
+
+func TestPromotionLockWait(t *testing.T) {
+	backend := &reg.FakeLockBackend{}
+	mkLock := func(holder string, ttl time.Duration) *reg.PromotionLock {
+		return &reg.PromotionLock{
+			Backend:      backend,
+			Holder:       holder,
+			TTL:          ttl,
+			PollInterval: time.Millisecond,
+		}
+	}
+
+	_, releaseA, err := mkLock("a", time.Hour).Acquire(
+		context.Background(),
+		[]reg.RegistryName{"gcr.io/foo"})
+	require.Nil(t, err)
+
+	// A held lock blocks others until their context is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, _, err = mkLock("b", time.Hour).Acquire(ctx, []reg.RegistryName{"gcr.io/foo"})
+	require.NotNil(t, err)
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// Locks of other registries are independent.
+	_, releaseB, err := mkLock("b", time.Hour).Acquire(
+		context.Background(),
+		[]reg.RegistryName{"gcr.io/bar"})
+	require.Nil(t, err)
+	releaseB()
+
+	// Once released, the lock can be acquired by others.
+	releaseA()
+	_, releaseB, err = mkLock("b", time.Hour).Acquire(
+		context.Background(),
+		[]reg.RegistryName{"gcr.io/foo"})
+	require.Nil(t, err)
+	releaseB()
+}
+
+// steppingClock is a Clock that moves ahead by step whenever it is read. Like
+// FakeClock, it does not wait for anything.
+type steppingClock struct {
+	mutex sync.Mutex
+	now   time.Time
+	step  time.Duration
+}
+
+func (c *steppingClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.now = c.now.Add(c.step)
+	return c.now
+}
+
+func (c *steppingClock) After(d time.Duration) <-chan time.Time {
+	after := make(chan time.Time, 1)
+	after <- c.Now()
+	return after
+}
+
+// unrenewableLockBackend is a FakeLockBackend whose locks cannot be renewed.
+type unrenewableLockBackend struct {
+	reg.FakeLockBackend
+}
+
+func (b *unrenewableLockBackend) Renew(
+	ctx context.Context,
+	name string,
+	generation int64,
+	record reg.LockRecord,
+) error {
+	return fmt.Errorf("backend unavailable")
+}
+
+// waitDone waits (for a while) for ctx to be done.
+func waitDone(ctx context.Context) bool {
+	select {
+	case <-ctx.Done():
+		return true
+	case <-time.After(5 * time.Second):
+		return false
+	}
+}
+
+func TestPromotionLockTTL(t *testing.T) {
+	backend := &reg.FakeLockBackend{}
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	mkLock := func(holder string, now time.Time) *reg.PromotionLock {
+		return &reg.PromotionLock{
+			Backend: backend,
+			Holder:  holder,
+			TTL:     time.Hour,
+			Clock:   reg.FakeClock{Time: now},
+		}
+	}
+
+	// The clock of "a" is stopped, so it cannot renew its lock past now+TTL
+	// (as if it had crashed).
+	ctxA, releaseA, err := mkLock("a", now).Acquire(
+		context.Background(),
+		[]reg.RegistryName{"gcr.io/foo"})
+	require.Nil(t, err)
+
+	// The lock is not expired yet.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, _, err = mkLock("b", now.Add(30*time.Minute)).Acquire(
+		ctx,
+		[]reg.RegistryName{"gcr.io/foo"})
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+	require.Nil(t, ctxA.Err())
+
+	// "b" takes over the expired lock.
+	_, releaseB, err := mkLock("b", now.Add(2*time.Hour)).Acquire(
+		context.Background(),
+		[]reg.RegistryName{"gcr.io/foo"})
+	require.Nil(t, err)
+
+	// "a" notices that its lock was taken over, and must stop writing.
+	require.True(t, waitDone(ctxA))
+
+	// A late release by "a" must not release the lock of "b".
+	releaseA()
+	record, _, err := backend.Get(context.Background(), reg.LockName("gcr.io/foo"))
+	require.Nil(t, err)
+	require.Equal(t, "b", record.Holder)
+
+	releaseB()
+	_, _, err = backend.Get(context.Background(), reg.LockName("gcr.io/foo"))
+	require.True(t, errors.Is(err, reg.ErrLockNotFound))
+}
+
+func TestPromotionLockRenewal(t *testing.T) {
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	// A held lock is renewed, so that it does not expire while the promotion
+	// runs.
+	backend := &reg.FakeLockBackend{}
+	lock := reg.PromotionLock{
+		Backend: backend,
+		Holder:  "a",
+		TTL:     time.Hour,
+		Clock:   &steppingClock{now: now, step: time.Minute},
+	}
+	ctx, release, err := lock.Acquire(
+		context.Background(),
+		[]reg.RegistryName{"gcr.io/foo"})
+	require.Nil(t, err)
+
+	require.Eventually(t, func() bool {
+		record, _, err := backend.Get(
+			context.Background(),
+			reg.LockName("gcr.io/foo"))
+		return err == nil && record.Expires.After(now.Add(2*time.Hour))
+	}, 5*time.Second, time.Millisecond)
+	require.Nil(t, ctx.Err())
+	release()
+
+	// A lock that cannot be renewed is lost once it expires.
+	unrenewable := &unrenewableLockBackend{}
+	lock = reg.PromotionLock{
+		Backend: unrenewable,
+		Holder:  "a",
+		TTL:     time.Hour,
+		Clock:   &steppingClock{now: now, step: time.Minute},
+	}
+	ctx, release, err = lock.Acquire(
+		context.Background(),
+		[]reg.RegistryName{"gcr.io/foo"})
+	require.Nil(t, err)
+	require.True(t, waitDone(ctx))
+	release()
+
+	_, _, err = unrenewable.Get(context.Background(), reg.LockName("gcr.io/foo"))
+	require.True(t, errors.Is(err, reg.ErrLockNotFound))
+}
+
+func TestPromoteWithPromotionLock(t *testing.T) {
+	srcRC := reg.RegistryContext{
+		Name:           "gcr.io/foo",
+		ServiceAccount: "robot",
+		Src:            true,
+	}
+	destRC := reg.RegistryContext{
+		Name:           "gcr.io/bar",
+		ServiceAccount: "robot",
+	}
+
+	edges, err := reg.ToPromotionEdges([]reg.Manifest{
+		{
+			Registries: []reg.RegistryContext{srcRC, destRC},
+			Images: []reg.Image{
+				{
+					ImageName: "a",
+					Dmap: reg.DigestTags{
+						"sha256:000": {"0.9"},
+					},
+				},
+			},
+			SrcRegistry: &srcRC,
+		},
+	})
+	require.Nil(t, err)
+
+	nopStream := func(
+		reg.RegistryName,
+		reg.ImageName,
+		reg.RegistryContext,
+		reg.ImageName,
+		reg.Digest,
+		reg.Tag,
+		reg.TagOp,
+	) stream.Producer {
+		return nil
+	}
+
+	backend := &reg.FakeLockBackend{}
+
+	// The destination is locked by another run while the requests run.
+	var processRequest reg.ProcessRequest = func(
+		sc *reg.SyncContext,
+		reqs chan stream.ExternalRequest,
+		requestResults chan<- reg.RequestResult,
+		wg *sync.WaitGroup,
+		mutex *sync.Mutex,
+	) {
+		for req := range reqs {
+			record, _, err := backend.Get(
+				context.Background(),
+				reg.LockName(destRC.Name))
+			require.Nil(t, err)
+			require.Equal(t, "test", record.Holder)
+
+			requestResults <- reg.RequestResult{Context: req}
+		}
+	}
+
+	// The destination is read again once the lock is held.
+	srcInv := reg.RegInvImage{"a": reg.DigestTags{"sha256:000": {"0.9"}}}
+	dstInv := reg.MasterInventory{}
+	sc := reg.SyncContext{
+		RegistryContexts: []reg.RegistryContext{srcRC, destRC},
+		Inv:              reg.MasterInventory{srcRC.Name: srcInv},
+		DigestMediaType:  make(reg.DigestMediaType),
+		DigestImageSize:  make(reg.DigestImageSize),
+		PromotionLock: &reg.PromotionLock{
+			Backend:      backend,
+			Holder:       "test",
+			PollInterval: time.Millisecond,
+		},
+		MkReadRepositoryCmd: func(
+			sc *reg.SyncContext,
+			rc reg.RegistryContext,
+		) stream.Producer {
+			return reg.MkReadRepositoryCmdFromInventory(dstInv)(sc, rc)
+		},
+	}
+	require.Nil(t, sc.Promote(edges, nopStream, &processRequest))
+
+	// The lock is released afterwards.
+	_, _, err = backend.Get(context.Background(), reg.LockName(destRC.Name))
+	require.True(t, errors.Is(err, reg.ErrLockNotFound))
+
+	// If another run holds the lock, nothing is promoted until it is
+	// released.
+	otherGeneration, err := backend.Create(
+		context.Background(),
+		reg.LockName(destRC.Name),
+		reg.LockRecord{Holder: "other", Expires: time.Now().Add(time.Hour)})
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	sc.Context = ctx
+	processed := false
+	var processRequestFail reg.ProcessRequest = func(
+		sc *reg.SyncContext,
+		reqs chan stream.ExternalRequest,
+		requestResults chan<- reg.RequestResult,
+		wg *sync.WaitGroup,
+		mutex *sync.Mutex,
+	) {
+		for req := range reqs {
+			processed = true
+			requestResults <- reg.RequestResult{Context: req}
+		}
+	}
+	err = sc.Promote(edges, nopStream, &processRequestFail)
+	require.NotNil(t, err)
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+	require.False(t, processed)
+
+	// If another run promoted the image while we waited for the lock, there
+	// is nothing left to promote.
+	require.Nil(t, backend.Delete(
+		context.Background(),
+		reg.LockName(destRC.Name),
+		otherGeneration))
+	sc.Context = nil
+	dstInv[destRC.Name] = srcInv
+	require.Nil(t, sc.Promote(edges, nopStream, &processRequestFail))
+	require.False(t, processed)
+
+	// If another run moved the tag in the meantime, the promotion fails.
+	dstInv[destRC.Name] = reg.RegInvImage{
+		"a": reg.DigestTags{"sha256:000": {}, "sha256:111": {"0.9"}},
+	}
+	require.NotNil(t, sc.Promote(edges, nopStream, &processRequestFail))
+	require.False(t, processed)
+
+	// Dry runs do not take the lock.
+	sc.DryRun = true
+	require.Nil(t, sc.Promote(edges, nopStream, &processRequestFail))
+	require.True(t, processed)
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/sirupsen/logrus"
+)
+
+// MediaTypes are the media types of an image (or manifest list) that a copy
+// has to preserve. Artifacts (e.g., Helm charts) are stored like images, but
+// with their own config media type, and are unusable if they are converted to
+// a Docker image.
+type MediaTypes struct {
+	Manifest types.MediaType
+	// Config is empty for manifest lists.
+	Config types.MediaType
+}
+
+// mediaTypesOf returns the media types of the image (or manifest list) that
+// desc describes.
+func mediaTypesOf(desc *remote.Descriptor) MediaTypes {
+	mediaTypes := MediaTypes{Manifest: desc.MediaType}
+	if desc.MediaType.IsIndex() {
+		return mediaTypes
+	}
+
+	if m, err := v1.ParseManifest(bytes.NewReader(desc.Manifest)); err == nil {
+		mediaTypes.Config = m.Config.MediaType
+	}
+
+	return mediaTypes
+}
+
+// MediaTypeChanges describes how the media types of a copy (dst) differ from
+// those of the original (src), if they do.
+func MediaTypeChanges(src, dst MediaTypes) []string {
+	changes := make([]string, 0)
+	if src.Manifest != dst.Manifest {
+		changes = append(changes, fmt.Sprintf(
+			"manifest media type changed from %q to %q",
+			src.Manifest,
+			dst.Manifest))
+	}
+	if src.Config != dst.Config {
+		changes = append(changes, fmt.Sprintf(
+			"config media type changed from %q to %q",
+			src.Config,
+			dst.Config))
+	}
+
+	return changes
+}
+
+// checkMediaTypes warns if the media types of the copy dst differ from those
+// of the original that src describes (see MediaTypeChanges()).
+func (sc *SyncContext) checkMediaTypes(
+	src *remote.Descriptor,
+	dst name.Reference,
+	opts []remote.Option,
+) {
+	desc, err := remote.Get(dst, opts...)
+	if err != nil {
+		logrus.Warnf("%s: could not check the media types: %v", dst, err)
+		return
+	}
+
+	for _, change := range MediaTypeChanges(mediaTypesOf(src), mediaTypesOf(desc)) {
+		logrus.Warnf("%s: %s", dst, change)
+	}
+}
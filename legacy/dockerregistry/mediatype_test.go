@@ -0,0 +1,150 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/testregistry"
+)
+
+const helmConfigMediaType types.MediaType = "application/vnd.cncf.helm.config.v1+json"
+
+// artifact is an OCI image with a custom config media type, like the
+// artifacts (e.g., Helm charts) that are stored in registries.
+type artifact struct {
+	v1.Image
+	configMediaType types.MediaType
+}
+
+func (a *artifact) MediaType() (types.MediaType, error) {
+	return types.OCIManifestSchema1, nil
+}
+
+func (a *artifact) Manifest() (*v1.Manifest, error) {
+	m, err := a.Image.Manifest()
+	if err != nil {
+		return nil, err
+	}
+
+	mCopy := *m
+	mCopy.MediaType = types.OCIManifestSchema1
+	mCopy.Config.MediaType = a.configMediaType
+	return &mCopy, nil
+}
+
+func (a *artifact) RawManifest() ([]byte, error) {
+	m, err := a.Manifest()
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(m)
+}
+
+func (a *artifact) Digest() (v1.Hash, error) {
+	return partial.Digest(a)
+}
+
+func TestPromotePreservesMediaTypes(t *testing.T) {
+	r := testregistry.New()
+	defer r.Close()
+
+	img, err := random.Image(1024, 2)
+	require.Nil(t, err)
+	chart := &artifact{Image: img, configMediaType: helmConfigMediaType}
+
+	digest, err := r.Push("staging/chart", chart, "1.0")
+	require.Nil(t, err)
+
+	promoteFromStaging(t, r, []reg.Image{
+		{ImageName: "chart", Dmap: reg.DigestTags{digest: {"1.0"}}},
+	})
+
+	require.Equal(
+		t,
+		reg.DigestTags{digest: {"1.0"}},
+		r.Inventory("prod")["chart"])
+
+	// The copy keeps the raw manifest, and so all of its media types.
+	ref, err := name.ParseReference(string(r.Name("prod")) + "/chart:1.0")
+	require.Nil(t, err)
+	desc, err := remote.Get(ref)
+	require.Nil(t, err)
+	require.Equal(t, types.OCIManifestSchema1, desc.MediaType)
+	require.Equal(t, string(digest), desc.Digest.String())
+
+	m, err := v1.ParseManifest(bytes.NewReader(desc.Manifest))
+	require.Nil(t, err)
+	require.Equal(t, helmConfigMediaType, m.Config.MediaType)
+}
+
+func TestMediaTypeChanges(t *testing.T) {
+	chart := reg.MediaTypes{
+		Manifest: types.OCIManifestSchema1,
+		Config:   helmConfigMediaType,
+	}
+
+	var tests = []struct {
+		name     string
+		dst      reg.MediaTypes
+		expected []string
+	}{
+		{
+			"Unchanged",
+			chart,
+			[]string{},
+		},
+		{
+			"Converted to a Docker image",
+			reg.MediaTypes{
+				Manifest: types.DockerManifestSchema2,
+				Config:   types.DockerConfigJSON,
+			},
+			[]string{
+				`manifest media type changed from "application/vnd.oci.image.manifest.v1+json" to "application/vnd.docker.distribution.manifest.v2+json"`,
+				`config media type changed from "application/vnd.cncf.helm.config.v1+json" to "application/vnd.docker.container.image.v1+json"`,
+			},
+		},
+		{
+			"Only the config media type changed",
+			reg.MediaTypes{
+				Manifest: types.OCIManifestSchema1,
+				Config:   types.OCIConfigJSON,
+			},
+			[]string{
+				`config media type changed from "application/vnd.cncf.helm.config.v1+json" to "application/vnd.oci.image.config.v1+json"`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		got := reg.MediaTypeChanges(chart, test.dst)
+		require.Equal(t, test.expected, got, test.name)
+	}
+}
@@ -0,0 +1,297 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ConflictStrategy decides how MergeSnapshots() and DiffSnapshotsWith()
+// handle a tag that points at one digest in one snapshot, and at another
+// digest in the other one.
+type ConflictStrategy string
+
+// The supported conflict strategies.
+const (
+	// ConflictError fails on any conflict.
+	ConflictError ConflictStrategy = "error"
+	// ConflictPreferA keeps the digest of the first snapshot.
+	ConflictPreferA ConflictStrategy = "prefer-a"
+	// ConflictPreferB keeps the digest of the second snapshot.
+	ConflictPreferB ConflictStrategy = "prefer-b"
+	// ConflictPreferNewest keeps the digest that was uploaded last. Conflicts
+	// between digests with the same (or unknown) upload times still fail.
+	ConflictPreferNewest ConflictStrategy = "prefer-newest"
+)
+
+// ConflictStrategies returns the names of all conflict strategies.
+func ConflictStrategies() []string {
+	return []string{
+		string(ConflictError),
+		string(ConflictPreferA),
+		string(ConflictPreferB),
+		string(ConflictPreferNewest),
+	}
+}
+
+// ParseConflictStrategy returns the ConflictStrategy with the given name.
+func ParseConflictStrategy(name string) (ConflictStrategy, error) {
+	for _, strategy := range ConflictStrategies() {
+		if strings.EqualFold(name, strategy) {
+			return ConflictStrategy(strategy), nil
+		}
+	}
+
+	return "", fmt.Errorf(
+		"unknown conflict strategy %q (supported strategies: %q)",
+		name,
+		ConflictStrategies())
+}
+
+// TagConflict is a tag of an image that points at different digests in two
+// snapshots.
+type TagConflict struct {
+	ImageName ImageName
+	Tag       Tag
+	DigestA   Digest
+	DigestB   Digest
+}
+
+func (c TagConflict) String() string {
+	return fmt.Sprintf(
+		"%s:%s points at %s in a, but at %s in b",
+		c.ImageName,
+		c.Tag,
+		c.DigestA,
+		c.DigestB)
+}
+
+// FindTagConflicts returns the tags that point at different digests in a and
+// b, sorted by image and tag.
+func FindTagConflicts(a, b RegInvImage) []TagConflict {
+	conflicts := make([]TagConflict, 0)
+	for imageName, digestTagsB := range b {
+		digestTagsA, ok := a[imageName]
+		if !ok {
+			continue
+		}
+
+		tagDigestsA := tagDigests(digestTagsA)
+		for tag, digestB := range tagDigests(digestTagsB) {
+			digestA, ok := tagDigestsA[tag]
+			if ok && digestA != digestB {
+				conflicts = append(conflicts, TagConflict{
+					ImageName: imageName,
+					Tag:       tag,
+					DigestA:   digestA,
+					DigestB:   digestB,
+				})
+			}
+		}
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool {
+		if conflicts[i].ImageName != conflicts[j].ImageName {
+			return conflicts[i].ImageName < conflicts[j].ImageName
+		}
+		return conflicts[i].Tag < conflicts[j].Tag
+	})
+
+	return conflicts
+}
+
+// tagDigests maps each tag of the image to its digest.
+func tagDigests(digestTags DigestTags) map[Tag]Digest {
+	digests := make(map[Tag]Digest)
+	for digest, tags := range digestTags {
+		for _, tag := range tags {
+			digests[tag] = digest
+		}
+	}
+
+	return digests
+}
+
+// resolve returns the digest that the tag of the conflict should point at,
+// according to strategy. The upload times are only used by
+// ConflictPreferNewest.
+func (c TagConflict) resolve(
+	strategy ConflictStrategy,
+	uploaded DigestUploadTime,
+) (Digest, error) {
+	switch strategy {
+	case ConflictPreferA:
+		return c.DigestA, nil
+	case ConflictPreferB:
+		return c.DigestB, nil
+	case ConflictPreferNewest:
+		timeA, timeB := uploaded[c.DigestA], uploaded[c.DigestB]
+		switch {
+		case timeA.After(timeB):
+			return c.DigestA, nil
+		case timeB.After(timeA):
+			return c.DigestB, nil
+		}
+
+		return "", fmt.Errorf("%v (and neither was uploaded later)", c)
+	case ConflictError:
+		return "", fmt.Errorf("%v", c)
+	default:
+		return "", fmt.Errorf("unknown conflict strategy %q", strategy)
+	}
+}
+
+// resolveConflicts resolves the tag conflicts between a and b with strategy,
+// returning the digest that each conflicting tag (by image) should point at.
+// All conflicts that cannot be resolved are reported together.
+func resolveConflicts(
+	a, b RegInvImage,
+	strategy ConflictStrategy,
+	uploaded DigestUploadTime,
+) (map[ImageName]map[Tag]Digest, error) {
+	resolved := make(map[ImageName]map[Tag]Digest)
+	problems := make([]string, 0)
+	for _, conflict := range FindTagConflicts(a, b) {
+		digest, err := conflict.resolve(strategy, uploaded)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+
+		if resolved[conflict.ImageName] == nil {
+			resolved[conflict.ImageName] = make(map[Tag]Digest)
+		}
+		resolved[conflict.ImageName][conflict.Tag] = digest
+	}
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf(
+			"%d conflicting tag(s):\n  %s",
+			len(problems),
+			strings.Join(problems, "\n  "))
+	}
+
+	return resolved, nil
+}
+
+// MergeSnapshots returns a new snapshot with all digests and tags of a and b.
+// A tag that points at different digests in a and b only stays with the
+// digest picked by strategy (see ConflictStrategy); the other digest is kept,
+// without that tag. The upload times are only needed for
+// ConflictPreferNewest (see SyncContext.DigestUploadTime).
+func MergeSnapshots(
+	a, b RegInvImage,
+	strategy ConflictStrategy,
+	uploaded DigestUploadTime,
+) (RegInvImage, error) {
+	resolved, err := resolveConflicts(a, b, strategy, uploaded)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := make(RegInvImage)
+	for _, rii := range []RegInvImage{a, b} {
+		for imageName, digestTags := range rii {
+			if merged[imageName] == nil {
+				merged[imageName] = make(DigestTags)
+			}
+
+			for digest, tags := range digestTags {
+				tagSet := merged[imageName][digest].ToTagSet()
+				for _, tag := range tags {
+					winner, ok := resolved[imageName][tag]
+					if ok && winner != digest {
+						continue
+					}
+					tagSet[tag] = nil
+				}
+
+				merged[imageName][digest] = sortedTags(tagSet)
+			}
+		}
+	}
+
+	return merged, nil
+}
+
+// DiffSnapshotsWith is like DiffSnapshots(), but first resolves the tags
+// that point at different digests in expected and got with strategy: with
+// ConflictError, such a tag fails the diff; otherwise, the tag is taken to
+// point at the picked digest in both snapshots, so it is not reported as a
+// difference.
+func DiffSnapshotsWith(
+	expected, got RegInvImage,
+	strategy ConflictStrategy,
+	uploaded DigestUploadTime,
+) (string, error) {
+	resolved, err := resolveConflicts(expected, got, strategy, uploaded)
+	if err != nil {
+		return "", err
+	}
+
+	return DiffSnapshots(
+		applyResolved(expected, resolved),
+		applyResolved(got, resolved)), nil
+}
+
+// applyResolved returns a copy of rii, where the resolved tags that it has
+// point at the picked digests.
+func applyResolved(
+	rii RegInvImage,
+	resolved map[ImageName]map[Tag]Digest,
+) RegInvImage {
+	applied := make(RegInvImage)
+	for imageName, digestTags := range rii {
+		tagSets := make(map[Digest]TagSet)
+		for digest, tags := range digestTags {
+			if tagSets[digest] == nil {
+				tagSets[digest] = make(TagSet)
+			}
+
+			for _, tag := range tags {
+				target := digest
+				if winner, ok := resolved[imageName][tag]; ok {
+					target = winner
+				}
+				if tagSets[target] == nil {
+					tagSets[target] = make(TagSet)
+				}
+				tagSets[target][tag] = nil
+			}
+		}
+
+		applied[imageName] = make(DigestTags)
+		for digest, tagSet := range tagSets {
+			applied[imageName][digest] = sortedTags(tagSet)
+		}
+	}
+
+	return applied
+}
+
+// sortedTags returns the tags of the set, sorted.
+func sortedTags(tagSet TagSet) TagSlice {
+	tags := make(TagSlice, 0, len(tagSet))
+	for tag := range tagSet {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+
+	return tags
+}
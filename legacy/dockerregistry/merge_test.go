@@ -0,0 +1,183 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+func TestMergeSnapshots(t *testing.T) {
+	// "foo:latest" points at sha256:aaa in a, but at sha256:bbb in b.
+	a := reg.RegInvImage{
+		"foo": {
+			"sha256:aaa": {"1.0", "latest"},
+			"sha256:ccc": {"0.9"},
+		},
+	}
+	b := reg.RegInvImage{
+		"foo": {
+			"sha256:bbb": {"2.0", "latest"},
+		},
+		"bar": {
+			"sha256:ddd": {"1.0"},
+		},
+	}
+
+	older := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+
+	preferA := reg.RegInvImage{
+		"foo": {
+			"sha256:aaa": {"1.0", "latest"},
+			"sha256:bbb": {"2.0"},
+			"sha256:ccc": {"0.9"},
+		},
+		"bar": {
+			"sha256:ddd": {"1.0"},
+		},
+	}
+	preferB := reg.RegInvImage{
+		"foo": {
+			"sha256:aaa": {"1.0"},
+			"sha256:bbb": {"2.0", "latest"},
+			"sha256:ccc": {"0.9"},
+		},
+		"bar": {
+			"sha256:ddd": {"1.0"},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		strategy      reg.ConflictStrategy
+		uploaded      reg.DigestUploadTime
+		expected      reg.RegInvImage
+		expectedError string
+	}{
+		{
+			"Error",
+			reg.ConflictError,
+			nil,
+			nil,
+			"1 conflicting tag(s):\n  foo:latest points at sha256:aaa in a, but at sha256:bbb in b",
+		},
+		{
+			"Prefer a",
+			reg.ConflictPreferA,
+			nil,
+			preferA,
+			"",
+		},
+		{
+			"Prefer b",
+			reg.ConflictPreferB,
+			nil,
+			preferB,
+			"",
+		},
+		{
+			"Prefer newest (b)",
+			reg.ConflictPreferNewest,
+			reg.DigestUploadTime{"sha256:aaa": older, "sha256:bbb": newer},
+			preferB,
+			"",
+		},
+		{
+			"Prefer newest (a)",
+			reg.ConflictPreferNewest,
+			reg.DigestUploadTime{"sha256:aaa": newer, "sha256:bbb": older},
+			preferA,
+			"",
+		},
+		{
+			"Prefer newest without upload times",
+			reg.ConflictPreferNewest,
+			nil,
+			nil,
+			"1 conflicting tag(s):\n  foo:latest points at sha256:aaa in a, but at sha256:bbb in b (and neither was uploaded later)",
+		},
+	}
+
+	for _, test := range tests {
+		got, err := reg.MergeSnapshots(a, b, test.strategy, test.uploaded)
+		if test.expectedError != "" {
+			require.NotNil(t, err, test.name)
+			require.Equal(t, test.expectedError, err.Error(), test.name)
+			continue
+		}
+
+		require.Nil(t, err, test.name)
+		require.Equal(t, test.expected, got, test.name)
+	}
+
+	// The inputs must be left untouched.
+	require.Equal(t, reg.TagSlice{"1.0", "latest"}, a["foo"]["sha256:aaa"])
+	require.Len(t, b, 2)
+}
+
+func TestDiffSnapshotsWith(t *testing.T) {
+	// Only "foo:latest" differs.
+	expected := reg.RegInvImage{
+		"foo": {
+			"sha256:aaa": {"1.0", "latest"},
+			"sha256:bbb": {"2.0"},
+		},
+	}
+	got := reg.RegInvImage{
+		"foo": {
+			"sha256:aaa": {"1.0"},
+			"sha256:bbb": {"2.0", "latest"},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		strategy      reg.ConflictStrategy
+		expectedError string
+	}{
+		{"Error", reg.ConflictError, "1 conflicting tag(s)"},
+		{"Prefer a", reg.ConflictPreferA, ""},
+		{"Prefer b", reg.ConflictPreferB, ""},
+	}
+
+	for _, test := range tests {
+		diff, err := reg.DiffSnapshotsWith(expected, got, test.strategy, nil)
+		if test.expectedError != "" {
+			require.NotNil(t, err, test.name)
+			require.Contains(t, err.Error(), test.expectedError, test.name)
+			continue
+		}
+
+		// The conflicting tag is resolved the same way on both sides.
+		require.Nil(t, err, test.name)
+		require.Empty(t, diff, test.name)
+	}
+}
+
+func TestParseConflictStrategy(t *testing.T) {
+	strategy, err := reg.ParseConflictStrategy("Prefer-Newest")
+	require.Nil(t, err)
+	require.Equal(t, reg.ConflictPreferNewest, strategy)
+
+	_, err = reg.ParseConflictStrategy("prefer-oldest")
+	require.NotNil(t, err)
+}
@@ -0,0 +1,350 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	monitoring "google.golang.org/api/monitoring/v3"
+)
+
+// MetricsRecorder records metrics about the promotions run by Promote(), and
+// exports them to a metrics backend.
+type MetricsRecorder interface {
+	// RecordPromotion records the outcome and latency of a single promotion
+	// request.
+	RecordPromotion(pr PromotionRequest, succeeded bool, latency time.Duration)
+	// Flush exports the metrics recorded so far.
+	Flush(ctx context.Context) error
+}
+
+// recordPromotion records the given promotion with the MetricsRecorder of sc,
+// if any.
+func (sc *SyncContext) recordPromotion(
+	pr PromotionRequest,
+	succeeded bool,
+	latency time.Duration,
+) {
+	if sc.MetricsRecorder == nil {
+		return
+	}
+
+	sc.MetricsRecorder.RecordPromotion(pr, succeeded, latency)
+}
+
+// flushMetrics exports the metrics recorded by the MetricsRecorder of sc, if
+// any. Failures are only logged, as they do not affect the promotion itself.
+func (sc *SyncContext) flushMetrics() {
+	if sc.MetricsRecorder == nil {
+		return
+	}
+
+	if err := sc.MetricsRecorder.Flush(sc.ctx()); err != nil {
+		logrus.Warnf("could not export promotion metrics: %v", err)
+	}
+}
+
+// DefaultCloudMonitoringPrefix is the prefix of the types of the metrics
+// written by CloudMonitoringRecorder, unless another one is given.
+const DefaultCloudMonitoringPrefix = "custom.googleapis.com/cip"
+
+// The metrics written by CloudMonitoringRecorder, below its prefix.
+const (
+	// CloudMonitoringPromotionsMetric counts the promotion requests, by
+	// operation, destination registry and result.
+	CloudMonitoringPromotionsMetric = "promotions"
+	// CloudMonitoringLatencyMetric is the distribution of the latencies (in
+	// seconds) of the promotion requests, by operation, destination registry
+	// and result.
+	CloudMonitoringLatencyMetric = "promotion_latencies"
+)
+
+// cloudMonitoringMaxTimeSeries is the maximum number of time series that a
+// single request to Cloud Monitoring may write.
+const cloudMonitoringMaxTimeSeries = 200
+
+// The exponential buckets of the latency distribution: the first finite bucket
+// starts at 100ms, and the last one ends after about 55 minutes.
+const (
+	latencyBucketScale  = 0.1
+	latencyBucketGrowth = 2
+	latencyBucketCount  = 15
+)
+
+// TimeSeriesWriter writes time series to the Cloud Monitoring project with the
+// given ID.
+type TimeSeriesWriter func(
+	ctx context.Context,
+	project string,
+	series []*monitoring.TimeSeries,
+) error
+
+// promotionMetricKey identifies the time series of a promotion metric.
+type promotionMetricKey struct {
+	operation string
+	registry  RegistryName
+	result    string
+}
+
+// latencyDistribution accumulates the latencies (in seconds) of a time series.
+type latencyDistribution struct {
+	count        int64
+	sum          float64
+	sumOfSquares float64
+	buckets      []int64
+}
+
+// CloudMonitoringRecorder is a MetricsRecorder that writes the promotion
+// metrics as custom metrics of a Google Cloud Monitoring (formerly Stackdriver)
+// project. Both metrics are cumulative, starting when the recorder is created
+// (i.e., once per run).
+type CloudMonitoringRecorder struct {
+	// Project is the ID of the project that the metrics are written to.
+	Project string
+	// Prefix is prepended (with a "/") to the names of the metrics to get
+	// their types, e.g. "custom.googleapis.com/cip/promotions".
+	Prefix string
+
+	write     TimeSeriesWriter
+	start     time.Time
+	mutex     sync.Mutex
+	counts    map[promotionMetricKey]int64
+	latencies map[promotionMetricKey]*latencyDistribution
+}
+
+// MkCloudMonitoringRecorder creates a CloudMonitoringRecorder that writes the
+// metrics of the given project (with the given metric type prefix) with write
+// (normally MkTimeSeriesWriterReal()).
+func MkCloudMonitoringRecorder(
+	project string,
+	prefix string,
+	write TimeSeriesWriter,
+) (*CloudMonitoringRecorder, error) {
+	if project == "" {
+		return nil, fmt.Errorf("missing Cloud Monitoring project")
+	}
+
+	if !strings.HasPrefix(prefix, "custom.googleapis.com/") {
+		return nil, fmt.Errorf(
+			"invalid metric prefix %q (expected custom.googleapis.com/<path>)",
+			prefix)
+	}
+
+	return &CloudMonitoringRecorder{
+		Project:   project,
+		Prefix:    strings.TrimSuffix(prefix, "/"),
+		write:     write,
+		start:     time.Now(),
+		counts:    make(map[promotionMetricKey]int64),
+		latencies: make(map[promotionMetricKey]*latencyDistribution),
+	}, nil
+}
+
+// MkTimeSeriesWriterReal writes time series with the Cloud Monitoring API,
+// using the application default credentials.
+func MkTimeSeriesWriterReal(ctx context.Context) (TimeSeriesWriter, error) {
+	service, err := monitoring.NewService(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("creating Cloud Monitoring client: %w", err)
+	}
+
+	return func(
+		ctx context.Context,
+		project string,
+		series []*monitoring.TimeSeries,
+	) error {
+		req := monitoring.CreateTimeSeriesRequest{TimeSeries: series}
+		_, err := service.Projects.TimeSeries.Create("projects/"+project, &req).
+			Context(ctx).
+			Do()
+
+		return err
+	}, nil
+}
+
+// RecordPromotion implements MetricsRecorder.
+func (r *CloudMonitoringRecorder) RecordPromotion(
+	pr PromotionRequest,
+	succeeded bool,
+	latency time.Duration,
+) {
+	key := promotionMetricKey{
+		operation: pr.TagOp.PrettyValue(),
+		registry:  pr.RegistryDest,
+		result:    "failure",
+	}
+	if succeeded {
+		key.result = "success"
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.counts[key]++
+
+	dist := r.latencies[key]
+	if dist == nil {
+		dist = &latencyDistribution{
+			buckets: make([]int64, latencyBucketCount+2),
+		}
+		r.latencies[key] = dist
+	}
+	seconds := latency.Seconds()
+	dist.count++
+	dist.sum += seconds
+	dist.sumOfSquares += seconds * seconds
+	dist.buckets[latencyBucket(seconds)]++
+}
+
+// latencyBucket returns the index of the bucket that the given latency falls
+// into. Bucket 0 is the underflow bucket, and the last one is the overflow
+// bucket.
+func latencyBucket(seconds float64) int {
+	if seconds < latencyBucketScale {
+		return 0
+	}
+
+	i := 1 + int(math.Floor(
+		math.Log(seconds/latencyBucketScale)/math.Log(latencyBucketGrowth)))
+	if i > latencyBucketCount+1 {
+		return latencyBucketCount + 1
+	}
+
+	return i
+}
+
+// Flush implements MetricsRecorder. It writes a point for every time series
+// recorded so far (sorted by their labels), spanning from the creation of the
+// recorder until now. Nothing is written if nothing was recorded.
+func (r *CloudMonitoringRecorder) Flush(ctx context.Context) error {
+	series := r.timeSeries(time.Now())
+
+	for len(series) > 0 {
+		n := len(series)
+		if n > cloudMonitoringMaxTimeSeries {
+			n = cloudMonitoringMaxTimeSeries
+		}
+
+		if err := r.write(ctx, r.Project, series[:n]); err != nil {
+			return fmt.Errorf(
+				"writing time series to Cloud Monitoring project %s: %w",
+				r.Project,
+				err)
+		}
+		series = series[n:]
+	}
+
+	return nil
+}
+
+// timeSeries returns the time series of the metrics recorded so far, with
+// points that end at the given time.
+func (r *CloudMonitoringRecorder) timeSeries(
+	end time.Time,
+) []*monitoring.TimeSeries {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	keys := make([]promotionMetricKey, 0, len(r.counts))
+	for key := range r.counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].operation != keys[j].operation {
+			return keys[i].operation < keys[j].operation
+		}
+		if keys[i].registry != keys[j].registry {
+			return keys[i].registry < keys[j].registry
+		}
+		return keys[i].result < keys[j].result
+	})
+
+	interval := monitoring.TimeInterval{
+		StartTime: r.start.UTC().Format(time.RFC3339Nano),
+		EndTime:   end.UTC().Format(time.RFC3339Nano),
+	}
+	resource := monitoring.MonitoredResource{
+		Type:   "global",
+		Labels: map[string]string{"project_id": r.Project},
+	}
+
+	series := make([]*monitoring.TimeSeries, 0, 2*len(keys))
+	for _, key := range keys {
+		labels := map[string]string{
+			"operation": key.operation,
+			"registry":  string(key.registry),
+			"result":    key.result,
+		}
+
+		count := r.counts[key]
+		series = append(series, &monitoring.TimeSeries{
+			Metric: &monitoring.Metric{
+				Type:   r.Prefix + "/" + CloudMonitoringPromotionsMetric,
+				Labels: labels,
+			},
+			Resource:   &resource,
+			MetricKind: "CUMULATIVE",
+			ValueType:  "INT64",
+			Points: []*monitoring.Point{{
+				Interval: &interval,
+				Value:    &monitoring.TypedValue{Int64Value: &count},
+			}},
+		})
+
+		dist := r.latencies[key]
+		mean := dist.sum / float64(dist.count)
+		series = append(series, &monitoring.TimeSeries{
+			Metric: &monitoring.Metric{
+				Type:   r.Prefix + "/" + CloudMonitoringLatencyMetric,
+				Labels: labels,
+			},
+			Resource:   &resource,
+			MetricKind: "CUMULATIVE",
+			ValueType:  "DISTRIBUTION",
+			Unit:       "s",
+			Points: []*monitoring.Point{{
+				Interval: &interval,
+				Value: &monitoring.TypedValue{
+					DistributionValue: &monitoring.Distribution{
+						Count: dist.count,
+						Mean:  mean,
+						SumOfSquaredDeviation: math.Max(
+							0,
+							dist.sumOfSquares-float64(dist.count)*mean*mean),
+						BucketOptions: &monitoring.BucketOptions{
+							ExponentialBuckets: &monitoring.Exponential{
+								NumFiniteBuckets: latencyBucketCount,
+								GrowthFactor:     latencyBucketGrowth,
+								Scale:            latencyBucketScale,
+							},
+						},
+						BucketCounts: append([]int64(nil), dist.buckets...),
+					},
+				},
+			}},
+		})
+	}
+
+	return series
+}
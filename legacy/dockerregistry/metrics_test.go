@@ -0,0 +1,293 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	monitoring "google.golang.org/api/monitoring/v3"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+// fakeMonitoring records the time series written to it, as a fake Cloud
+// Monitoring client.
+type fakeMonitoring struct {
+	mutex    sync.Mutex
+	projects []string
+	series   []*monitoring.TimeSeries
+	err      error
+}
+
+func (m *fakeMonitoring) write(
+	ctx context.Context,
+	project string,
+	series []*monitoring.TimeSeries,
+) error {
+	if m.err != nil {
+		return m.err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.projects = append(m.projects, project)
+	m.series = append(m.series, series...)
+
+	return nil
+}
+
+// writtenSeries describes a written time series, without its times.
+type writtenSeries struct {
+	metricType string
+	labels     map[string]string
+	value      int64
+}
+
+func TestCloudMonitoringRecorder(t *testing.T) {
+	srcRC := reg.RegistryContext{
+		Name:           "gcr.io/foo",
+		ServiceAccount: "robot",
+		Src:            true,
+	}
+	destRC := reg.RegistryContext{
+		Name:           "gcr.io/bar",
+		ServiceAccount: "robot",
+	}
+
+	edges, err := reg.ToPromotionEdges([]reg.Manifest{
+		{
+			Registries: []reg.RegistryContext{srcRC, destRC},
+			Images: []reg.Image{
+				{
+					ImageName: "a",
+					Dmap: reg.DigestTags{
+						"sha256:000": {"0.9", "1.0"},
+						"sha256:111": {},
+					},
+				},
+			},
+			SrcRegistry: &srcRC,
+		},
+	})
+	require.Nil(t, err)
+
+	labels := func(result string) map[string]string {
+		return map[string]string{
+			"operation": "ADD",
+			"registry":  "gcr.io/bar",
+			"result":    result,
+		}
+	}
+
+	var tests = []struct {
+		name           string
+		dryRun         bool
+		failTag        reg.Tag
+		writeErr       error
+		expectedErr    bool
+		expectedSeries []writtenSeries
+	}{
+		{
+			"Promotions are counted",
+			false,
+			"",
+			nil,
+			false,
+			[]writtenSeries{
+				{"custom.googleapis.com/cip/promotions", labels("success"), 3},
+				{"custom.googleapis.com/cip/promotion_latencies", labels("success"), 3},
+			},
+		},
+		{
+			"Failed promotions are counted separately",
+			false,
+			"1.0",
+			nil,
+			true,
+			[]writtenSeries{
+				{"custom.googleapis.com/cip/promotions", labels("failure"), 1},
+				{"custom.googleapis.com/cip/promotion_latencies", labels("failure"), 1},
+				{"custom.googleapis.com/cip/promotions", labels("success"), 2},
+				{"custom.googleapis.com/cip/promotion_latencies", labels("success"), 2},
+			},
+		},
+		{
+			"No metrics in a dry run",
+			true,
+			"",
+			nil,
+			false,
+			[]writtenSeries{},
+		},
+		{
+			"Export failures are only logged",
+			false,
+			"",
+			fmt.Errorf("permission denied"),
+			false,
+			[]writtenSeries{},
+		},
+	}
+
+	for _, test := range tests {
+		fake := &fakeMonitoring{err: test.writeErr}
+		recorder, err := reg.MkCloudMonitoringRecorder(
+			"my-project",
+			reg.DefaultCloudMonitoringPrefix,
+			fake.write)
+		require.Nil(t, err, test.name)
+
+		sc := reg.SyncContext{
+			Inv:             reg.MasterInventory{},
+			DryRun:          test.dryRun,
+			CopyTool:        reg.CraneCopyTool{},
+			MetricsRecorder: recorder,
+		}
+
+		err = sc.Promote(edges, mkFailingTagProducer(test.failTag), nil)
+		require.Equal(t, test.expectedErr, err != nil, test.name)
+
+		got := make([]writtenSeries, 0)
+		for _, series := range fake.series {
+			require.Equal(t, "global", series.Resource.Type, test.name)
+			require.Equal(
+				t,
+				map[string]string{"project_id": "my-project"},
+				series.Resource.Labels,
+				test.name)
+			require.Equal(t, "CUMULATIVE", series.MetricKind, test.name)
+			require.Len(t, series.Points, 1, test.name)
+
+			interval := series.Points[0].Interval
+			start, err := time.Parse(time.RFC3339Nano, interval.StartTime)
+			require.Nil(t, err, test.name)
+			end, err := time.Parse(time.RFC3339Nano, interval.EndTime)
+			require.Nil(t, err, test.name)
+			require.True(t, start.Before(end), test.name)
+
+			value := series.Points[0].Value
+			var n int64
+			switch series.ValueType {
+			case "INT64":
+				n = *value.Int64Value
+			case "DISTRIBUTION":
+				n = value.DistributionValue.Count
+				var bucketed int64
+				for _, count := range value.DistributionValue.BucketCounts {
+					bucketed += count
+				}
+				require.Equal(t, n, bucketed, test.name)
+			default:
+				require.Fail(t, "unexpected value type", series.ValueType)
+			}
+
+			got = append(got, writtenSeries{
+				series.Metric.Type,
+				series.Metric.Labels,
+				n,
+			})
+		}
+		require.Equal(t, test.expectedSeries, got, test.name)
+
+		for _, project := range fake.projects {
+			require.Equal(t, "my-project", project, test.name)
+		}
+	}
+}
+
+func TestCloudMonitoringRecorderLatencies(t *testing.T) {
+	fake := &fakeMonitoring{}
+	recorder, err := reg.MkCloudMonitoringRecorder(
+		"my-project",
+		"custom.googleapis.com/promoter/",
+		fake.write)
+	require.Nil(t, err)
+
+	pr := reg.PromotionRequest{
+		TagOp:        reg.Add,
+		RegistryDest: "gcr.io/bar",
+	}
+	for _, latency := range []time.Duration{
+		50 * time.Millisecond,
+		100 * time.Millisecond,
+		150 * time.Millisecond,
+		time.Second,
+		3 * time.Hour,
+	} {
+		recorder.RecordPromotion(pr, true, latency)
+	}
+
+	require.Nil(t, recorder.Flush(context.Background()))
+	require.Len(t, fake.series, 2)
+
+	series := fake.series[1]
+	require.Equal(
+		t,
+		"custom.googleapis.com/promoter/promotion_latencies",
+		series.Metric.Type)
+	require.Equal(t, "s", series.Unit)
+
+	dist := series.Points[0].Value.DistributionValue
+	require.Equal(t, int64(5), dist.Count)
+	require.InDelta(t, 2160.26, dist.Mean, 0.01)
+
+	// The underflow bucket (below 100ms), [100ms, 200ms), [800ms, 1.6s), and
+	// the overflow bucket.
+	expected := make([]int64, 17)
+	expected[0] = 1
+	expected[1] = 2
+	expected[4] = 1
+	expected[16] = 1
+	require.Equal(t, expected, []int64(dist.BucketCounts))
+}
+
+func TestMkCloudMonitoringRecorder(t *testing.T) {
+	fake := &fakeMonitoring{}
+
+	var tests = []struct {
+		project string
+		prefix  string
+	}{
+		{"", reg.DefaultCloudMonitoringPrefix},
+		{"my-project", ""},
+		{"my-project", "cip"},
+		{"my-project", "external.googleapis.com/cip"},
+	}
+
+	for _, test := range tests {
+		_, err := reg.MkCloudMonitoringRecorder(
+			test.project,
+			test.prefix,
+			fake.write)
+		require.NotNil(t, err, test.prefix)
+	}
+
+	// Nothing is written if nothing was recorded.
+	recorder, err := reg.MkCloudMonitoringRecorder(
+		"my-project",
+		reg.DefaultCloudMonitoringPrefix,
+		fake.write)
+	require.Nil(t, err)
+	require.Nil(t, recorder.Flush(context.Background()))
+	require.Empty(t, fake.projects)
+}
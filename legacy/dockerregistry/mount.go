@@ -0,0 +1,288 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/sirupsen/logrus"
+)
+
+// layerMounts records which repositories of the destination registries hold
+// which layers, so that copies into other repositories of the same registry
+// can mount the layers from there (a cross-repository blob mount) instead of
+// uploading them again.
+type layerMounts struct {
+	mutex sync.Mutex
+	// repos maps each registry host to the repository (path) that holds each
+	// layer.
+	repos map[string]map[v1.Hash]string
+}
+
+func newLayerMounts() *layerMounts {
+	return &layerMounts{repos: make(map[string]map[v1.Hash]string)}
+}
+
+// record notes that repo holds the given layers.
+func (m *layerMounts) record(repo name.Repository, layers []v1.Hash) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	host := repo.RegistryStr()
+	if m.repos[host] == nil {
+		m.repos[host] = make(map[v1.Hash]string)
+	}
+	for _, layer := range layers {
+		m.repos[host][layer] = repo.RepositoryStr()
+	}
+}
+
+// find returns another repository of the registry of repo that holds the
+// layer, if one is known.
+func (m *layerMounts) find(repo name.Repository, layer v1.Hash) (string, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	from, ok := m.repos[repo.RegistryStr()][layer]
+	if !ok || from == repo.RepositoryStr() {
+		return "", false
+	}
+
+	return from, true
+}
+
+// copyImage copies the image (or manifest list) src to dst in-process, like
+// crane.Copy() does. Layers that the destination registry is known to hold in
+// another repository are mounted from there instead of being uploaded: either
+// because holders (repositories of the destination registry) hold the whole
+// image, or because an earlier copy of this run wrote the layer.
+func (sc *SyncContext) copyImage(src, dst string, holders []string) error {
+	srcRef, err := name.ParseReference(src)
+	if err != nil {
+		return fmt.Errorf("parsing reference %q: %w", src, err)
+	}
+
+	dstRef, err := name.ParseReference(dst)
+	if err != nil {
+		return fmt.Errorf("parsing reference %q: %w", dst, err)
+	}
+
+	opts := sc.remoteOptions()
+	desc, err := remote.Get(srcRef, opts...)
+	if err != nil {
+		return fmt.Errorf("fetching %q: %w", src, err)
+	}
+
+	if err := sc.writeDescriptor(desc, src, dstRef, holders, opts); err != nil {
+		return err
+	}
+
+	// The manifest is written as it is, so its media type (and that of its
+	// config) should be unchanged; anything else breaks artifacts with
+	// uncommon media types.
+	sc.checkMediaTypes(desc, dstRef, opts)
+
+	return nil
+}
+
+// writeDescriptor writes the image (or manifest list) that desc describes,
+// which was fetched from src, to ref.
+func (sc *SyncContext) writeDescriptor(
+	desc *remote.Descriptor,
+	src string,
+	ref name.Reference,
+	holders []string,
+	opts []remote.Option,
+) error {
+	switch desc.MediaType {
+	case types.OCIImageIndex, types.DockerManifestList:
+		idx, err := desc.ImageIndex()
+		if err != nil {
+			return err
+		}
+
+		return sc.writeIndex(ref, idx, holders, opts)
+	case types.DockerManifestSchema1, types.DockerManifestSchema1Signed:
+		// Legacy images cannot be written layer by layer.
+		return crane.Copy(src, ref.String(), sc.craneOptions()...)
+	default:
+		// Images, and artifacts with other media types, keep their raw
+		// manifest (and config), so their media types are preserved.
+		img, err := desc.Image()
+		if err != nil {
+			return err
+		}
+
+		return sc.writeImage(ref, img, holders, opts)
+	}
+}
+
+// writeIndex writes the images of the manifest list (each by its digest, with
+// writeImage()), and then the manifest list itself.
+func (sc *SyncContext) writeIndex(
+	ref name.Reference,
+	idx v1.ImageIndex,
+	holders []string,
+	opts []remote.Option,
+) error {
+	im, err := idx.IndexManifest()
+	if err != nil {
+		return err
+	}
+
+	for _, child := range im.Manifests {
+		childRef := ref.Context().Digest(child.Digest.String())
+		switch {
+		case child.MediaType.IsIndex():
+			childIdx, err := idx.ImageIndex(child.Digest)
+			if err != nil {
+				return err
+			}
+			if err := sc.writeIndex(childRef, childIdx, holders, opts); err != nil {
+				return err
+			}
+		case child.MediaType.IsImage():
+			img, err := idx.Image(child.Digest)
+			if err != nil {
+				return err
+			}
+			if err := sc.writeImage(childRef, img, holders, opts); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The images are already in place, so only the manifest list is written.
+	return remote.WriteIndex(ref, idx, opts...)
+}
+
+// writeImage writes img to ref, mounting its layers from other repositories of
+// the destination registry where possible (see copyImage()).
+func (sc *SyncContext) writeImage(
+	ref name.Reference,
+	img v1.Image,
+	holders []string,
+	opts []remote.Option,
+) error {
+	layers, err := img.Layers()
+	if err != nil {
+		return err
+	}
+
+	digests := make([]v1.Hash, 0, len(layers))
+	mountable := make([]v1.Layer, 0, len(layers))
+	mounts := 0
+	for _, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil {
+			return err
+		}
+		digests = append(digests, digest)
+
+		from, ok := sc.layerMounts.find(ref.Context(), digest)
+		if len(holders) > 0 {
+			from, ok = holders[0], true
+		}
+		if ok {
+			fromRepo, err := name.NewRepository(ref.Context().RegistryStr() + "/" + from)
+			if err != nil {
+				return err
+			}
+			layer = &remote.MountableLayer{
+				Layer:     layer,
+				Reference: fromRepo.Digest(digest.String()),
+			}
+			mounts++
+		}
+		mountable = append(mountable, layer)
+	}
+
+	if mounts > 0 {
+		logrus.Debugf(
+			"%s: mounting %d of %d layers from other repositories",
+			ref,
+			mounts,
+			len(layers))
+		img = &mountingImage{Image: img, layers: mountable}
+	}
+
+	if err := remote.Write(ref, img, opts...); err != nil {
+		return err
+	}
+
+	sc.layerMounts.record(ref.Context(), digests)
+
+	return nil
+}
+
+// mountingImage is an image whose layers are replaced with ones that are
+// mounted from other repositories (see remote.MountableLayer).
+type mountingImage struct {
+	v1.Image
+	layers []v1.Layer
+}
+
+// Layers implements v1.Image.
+func (img *mountingImage) Layers() ([]v1.Layer, error) {
+	return img.layers, nil
+}
+
+// holders returns the repositories (paths) of the destination registry of the
+// promotion request that already hold its digest under another image name.
+func (sc *SyncContext) holders(rpr *PromotionRequest) []string {
+	holders := []string{}
+	for imageName, digestTags := range sc.Inv[rpr.RegistryDest] {
+		if imageName == rpr.ImageNameDest {
+			continue
+		}
+		if _, ok := digestTags[rpr.Digest]; ok {
+			_, _, repoPath := GetTokenKeyDomainRepoPath(
+				RegistryName(string(rpr.RegistryDest) + "/" + string(imageName)))
+			holders = append(holders, repoPath)
+		}
+	}
+	sort.Strings(holders)
+
+	return holders
+}
+
+// remoteOptions returns the options for the in-process writes of images made
+// with sc, like craneOptions() does for crane.
+func (sc *SyncContext) remoteOptions() []remote.Option {
+	var keychain authn.Keychain = authn.DefaultKeychain
+	if sc.UseServiceAccount {
+		keychain = sc.Keychain()
+	}
+
+	opts := []remote.Option{
+		remote.WithAuthFromKeychain(keychain),
+		remote.WithUserAgent(sc.userAgent()),
+	}
+	if transport := sc.transport(); transport != nil {
+		opts = append(opts, remote.WithTransport(transport))
+	}
+
+	return opts
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/testregistry"
+)
+
+// promoteFromStaging promotes the given images from the "staging" to the
+// "prod" registry of r, one request at a time.
+func promoteFromStaging(
+	t *testing.T,
+	r *testregistry.Registry,
+	images []reg.Image,
+) {
+	mfest := reg.Manifest{
+		Registries: []reg.RegistryContext{
+			{Name: r.Name("staging"), Src: true},
+			{Name: r.Name("prod")},
+		},
+		Images: images,
+	}
+	mfest.SrcRegistry = &mfest.Registries[0]
+
+	sc, err := reg.MakeSyncContext([]reg.Manifest{mfest}, 1, false, false)
+	require.Nil(t, err)
+
+	edges, err := reg.ToPromotionEdges([]reg.Manifest{mfest})
+	require.Nil(t, err)
+
+	sc.ReadRegistries(sc.RegistryContexts, true, r.MkReadRepositoryCmd)
+	require.Empty(t, sc.Logs.Errors)
+
+	edges, ok := sc.FilterPromotionEdges(edges, false)
+	require.True(t, ok)
+	require.Nil(t, sc.Promote(edges, nil, nil))
+	require.Zero(t, sc.Logs.Promotions.Failed)
+}
+
+// mountsFrom returns the digests of the blobs mounted into repo from the given
+// repository.
+func mountsFrom(r *testregistry.Registry, repo, from string) []reg.Digest {
+	digests := []reg.Digest{}
+	for _, mount := range r.Mounts() {
+		if mount.Repo == repo && mount.From == from {
+			digests = append(digests, mount.Digest)
+		}
+	}
+
+	return digests
+}
+
+func layerDigests(t *testing.T, img v1.Image) []reg.Digest {
+	layers, err := img.Layers()
+	require.Nil(t, err)
+
+	digests := []reg.Digest{}
+	for _, layer := range layers {
+		digest, err := layer.Digest()
+		require.Nil(t, err)
+		digests = append(digests, reg.Digest(digest.String()))
+	}
+
+	return digests
+}
+
+func TestPromoteMountsSharedLayers(t *testing.T) {
+	r := testregistry.New()
+	defer r.Close()
+
+	// Two images that share their base layer.
+	base, err := random.Image(1024, 1)
+	require.Nil(t, err)
+	shared := layerDigests(t, base)[0]
+
+	mkImage := func() v1.Image {
+		layer, err := random.Layer(1024, types.DockerLayer)
+		require.Nil(t, err)
+		img, err := mutate.AppendLayers(base, layer)
+		require.Nil(t, err)
+		return img
+	}
+
+	bar, err := r.Push("staging/bar", mkImage(), "1.0")
+	require.Nil(t, err)
+	foo, err := r.Push("staging/foo", mkImage(), "1.0")
+	require.Nil(t, err)
+
+	promoteFromStaging(t, r, []reg.Image{
+		{ImageName: "bar", Dmap: reg.DigestTags{bar: {"1.0"}}},
+		{ImageName: "foo", Dmap: reg.DigestTags{foo: {"1.0"}}},
+	})
+
+	// "bar" is promoted first, so "foo" mounts the shared layer from it.
+	require.Equal(t, []reg.Digest{shared}, mountsFrom(r, "prod/foo", "prod/bar"))
+	require.Equal(
+		t,
+		reg.DigestTags{foo: {"1.0"}},
+		r.Inventory("prod")["foo"])
+}
+
+func TestPromoteMountsImageUnderOtherName(t *testing.T) {
+	r := testregistry.New()
+	defer r.Close()
+
+	img, err := random.Image(1024, 3)
+	require.Nil(t, err)
+
+	// The image is already in prod, but under another name.
+	digest, err := r.Push("staging/foo", img, "1.0")
+	require.Nil(t, err)
+	_, err = r.Push("prod/bar", img, "1.0")
+	require.Nil(t, err)
+
+	promoteFromStaging(t, r, []reg.Image{
+		{ImageName: "foo", Dmap: reg.DigestTags{digest: {"1.0"}}},
+	})
+
+	require.ElementsMatch(
+		t,
+		layerDigests(t, img),
+		mountsFrom(r, "prod/foo", "prod/bar"))
+	require.Equal(
+		t,
+		reg.DigestTags{digest: {"1.0"}},
+		r.Inventory("prod")["foo"])
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/stream"
+)
+
+// CheckDestinationsReachable pings every destination registry of the edges
+// (with the producers made by mkProducer, e.g. MkPingCmdReal()), so that a
+// run can fail before doing any work if one of them cannot be reached. The
+// returned error reports every unreachable registry.
+func (sc *SyncContext) CheckDestinationsReachable(
+	edges map[PromotionEdge]interface{},
+	mkProducer func(*SyncContext, RegistryContext) stream.Producer,
+) error {
+	dsts := make(map[RegistryName]RegistryContext)
+	for edge := range edges {
+		dsts[edge.DstRegistry.Name] = edge.DstRegistry
+	}
+
+	var (
+		mutex       sync.Mutex
+		wg          sync.WaitGroup
+		unreachable = make(map[RegistryName]error)
+	)
+	for _, rc := range dsts {
+		wg.Add(1)
+		go func(rc RegistryContext) {
+			defer wg.Done()
+
+			err := Ping(mkProducer(sc, rc))
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				unreachable[rc.Name] = err
+			}
+		}(rc)
+	}
+	wg.Wait()
+
+	names := make([]string, 0, len(dsts))
+	for name := range dsts {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+
+	report := make([]string, 0, len(unreachable))
+	for _, name := range names {
+		err, ok := unreachable[RegistryName(name)]
+		if !ok {
+			logrus.Infof("destination registry %s: reachable", name)
+			continue
+		}
+
+		logrus.Errorf("destination registry %s: unreachable: %v", name, err)
+		report = append(report, fmt.Sprintf("%s: %v", name, err))
+	}
+
+	if len(report) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf(
+		"%d of %d destination registries are unreachable:\n  %s",
+		len(report),
+		len(names),
+		strings.Join(report, "\n  "))
+}
+
+// Ping is nil if the registry behind producer answered. Any response short of
+// a server error counts, as the registry may well require authentication
+// (e.g., with "401 Unauthorized").
+func Ping(producer stream.Producer) error {
+	_, _, err := producer.Produce()
+
+	var statusErr *stream.StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode < 500 {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// nolint[errcheck]
+	producer.Close()
+
+	return nil
+}
+
+// MkPingCmdReal creates a stream.Producer which requests the "/v2/" endpoint
+// (the API version check) of the host of the registry over the network.
+func MkPingCmdReal(sc *SyncContext, rc RegistryContext) stream.Producer {
+	var sh stream.HTTP
+
+	_, domain, _ := GetTokenKeyDomainRepoPath(rc.Name)
+
+	httpReq, err := http.NewRequestWithContext(
+		sc.ctx(),
+		"GET",
+		fmt.Sprintf("https://%s/v2/", domain),
+		nil,
+	)
+	if err != nil {
+		logrus.Fatalf("could not create HTTP request for '%s'", domain)
+	}
+	httpReq.Header.Set("User-Agent", sc.userAgent())
+
+	sh.Req = httpReq
+	sh.Proxy = sc.Proxy
+	sh.TLSConfig = sc.tlsConfig(domain)
+	return &sh
+}
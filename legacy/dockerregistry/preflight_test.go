@@ -0,0 +1,121 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/stream"
+)
+
+func TestCheckDestinationsReachable(t *testing.T) {
+	// A registry that wants authentication is reachable all the same.
+	reachable := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "/v2/", r.URL.Path)
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+	defer reachable.Close()
+
+	failing := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+	defer failing.Close()
+
+	// Nothing listens at the address of a closed server.
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	urls := map[reg.RegistryName]string{
+		"gcr.io/ok":      reachable.URL,
+		"gcr.io/ok-too":  reachable.URL,
+		"gcr.io/failing": failing.URL,
+		"gcr.io/closed":  closed.URL,
+	}
+	mkProducer := func(
+		sc *reg.SyncContext,
+		rc reg.RegistryContext,
+	) stream.Producer {
+		req, err := http.NewRequest(http.MethodGet, urls[rc.Name]+"/v2/", nil)
+		require.Nil(t, err)
+		return &stream.HTTP{Req: req}
+	}
+
+	srcRC := reg.RegistryContext{Name: "gcr.io/src", Src: true}
+	mkEdges := func(
+		dsts ...reg.RegistryName,
+	) map[reg.PromotionEdge]interface{} {
+		edges := make(map[reg.PromotionEdge]interface{})
+		for _, dst := range dsts {
+			edges[reg.PromotionEdge{
+				SrcRegistry: srcRC,
+				SrcImageTag: reg.ImageTag{ImageName: "foo", Tag: "1.0"},
+				Digest:      "sha256:000",
+				DstRegistry: reg.RegistryContext{Name: dst},
+				DstImageTag: reg.ImageTag{ImageName: "foo", Tag: "1.0"},
+			}] = nil
+		}
+		return edges
+	}
+
+	tests := []struct {
+		name          string
+		edges         map[reg.PromotionEdge]interface{}
+		expectedError []string
+	}{
+		{
+			"All reachable",
+			mkEdges("gcr.io/ok", "gcr.io/ok-too"),
+			nil,
+		},
+		{
+			"No edges",
+			mkEdges(),
+			nil,
+		},
+		{
+			"Some unreachable",
+			mkEdges("gcr.io/ok", "gcr.io/failing", "gcr.io/closed"),
+			[]string{
+				"2 of 3 destination registries are unreachable:",
+				"\n  gcr.io/closed: ",
+				"\n  gcr.io/failing: problems encountered: unexpected response code 503",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		sc := reg.SyncContext{}
+		err := sc.CheckDestinationsReachable(test.edges, mkProducer)
+		if test.expectedError == nil {
+			require.Nil(t, err, test.name)
+			continue
+		}
+
+		require.NotNil(t, err, test.name)
+		for _, expected := range test.expectedError {
+			require.Contains(t, err.Error(), expected, test.name)
+		}
+		require.NotContains(t, err.Error(), "gcr.io/ok", test.name)
+	}
+}
@@ -0,0 +1,411 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+
+	"sigs.k8s.io/k8s-container-image-promoter/internal/version"
+)
+
+const (
+	// InTotoStatementType is the type of the in-toto statements that record
+	// the provenance of promoted images.
+	InTotoStatementType = "https://in-toto.io/Statement/v0.1"
+	// SLSAProvenancePredicateType is the type of their predicate.
+	SLSAProvenancePredicateType = "https://slsa.dev/provenance/v0.2"
+	// ProvenanceBuildType is the build type of a promotion, i.e. the copy of
+	// an image from a source to a destination registry.
+	ProvenanceBuildType = "https://github.com/kubernetes-sigs/k8s-container-image-promoter/promotion@v1"
+	// ProvenanceDefaultBuilderID identifies the promoter as the builder.
+	ProvenanceDefaultBuilderID = "https://github.com/kubernetes-sigs/k8s-container-image-promoter"
+	// ProvenanceMediaType is the media type (and artifact type) of the
+	// provenance attached to promoted images.
+	ProvenanceMediaType types.MediaType = "application/vnd.in-toto+json"
+
+	// ociEmptyMediaType is the media type of the empty ("{}") config of
+	// artifacts.
+	ociEmptyMediaType types.MediaType = "application/vnd.oci.empty.v1+json"
+	// ociCreatedAnnotation records when an artifact was created.
+	ociCreatedAnnotation = "org.opencontainers.image.created"
+)
+
+// ProvenanceStatement is an in-toto statement with a SLSA provenance
+// predicate, which records what was promoted, from where, by whom and when.
+type ProvenanceStatement struct {
+	Type          string              `json:"_type"`
+	Subject       []ProvenanceSubject `json:"subject"`
+	PredicateType string              `json:"predicateType"`
+	Predicate     SLSAProvenance      `json:"predicate"`
+}
+
+// ProvenanceSubject is an artifact that a ProvenanceStatement is about (the
+// promoted image), or one that it was made from (the source image).
+type ProvenanceSubject struct {
+	Name string `json:"name,omitempty"`
+	URI  string `json:"uri,omitempty"`
+	// Digest maps the digest algorithm (e.g., "sha256") to the digest.
+	Digest map[string]string `json:"digest"`
+}
+
+// SLSAProvenance is a SLSA provenance (v0.2) predicate.
+type SLSAProvenance struct {
+	Builder struct {
+		ID string `json:"id"`
+	} `json:"builder"`
+	BuildType  string `json:"buildType"`
+	Invocation struct {
+		// Parameters are the source image and the destination of the
+		// promotion.
+		Parameters map[string]string `json:"parameters"`
+		// Environment records who promoted the image (the service account
+		// of the destination registry, if any) and with which promoter.
+		Environment map[string]string `json:"environment"`
+	} `json:"invocation"`
+	Metadata struct {
+		BuildFinishedOn time.Time `json:"buildFinishedOn"`
+	} `json:"metadata"`
+	Materials []ProvenanceSubject `json:"materials"`
+}
+
+// MkProvenanceStatement creates the provenance of the given (successful)
+// promotion, which was finished at promotedAt by the given builder.
+func MkProvenanceStatement(
+	pr PromotionRequest,
+	builderID string,
+	promotedAt time.Time,
+) ProvenanceStatement {
+	source := ToFQIN(pr.RegistrySrc, pr.ImageNameSrc, pr.Digest)
+
+	statement := ProvenanceStatement{
+		Type: InTotoStatementType,
+		Subject: []ProvenanceSubject{{
+			Name:   ToLQIN(pr.RegistryDest, pr.ImageNameDest),
+			Digest: digestSet(pr.Digest),
+		}},
+		PredicateType: SLSAProvenancePredicateType,
+	}
+
+	predicate := &statement.Predicate
+	predicate.Builder.ID = builderID
+	predicate.BuildType = ProvenanceBuildType
+	predicate.Invocation.Parameters = map[string]string{
+		"source":   source,
+		"registry": string(pr.RegistryDest),
+		"image":    string(pr.ImageNameDest),
+	}
+	if pr.Tag != "" {
+		predicate.Invocation.Parameters["tag"] = string(pr.Tag)
+	}
+	predicate.Invocation.Environment = map[string]string{
+		"promoterVersion": version.Get().GitVersion,
+	}
+	if pr.ServiceAccount != "" {
+		predicate.Invocation.Environment["serviceAccount"] = pr.ServiceAccount
+	}
+	predicate.Metadata.BuildFinishedOn = promotedAt.UTC()
+	predicate.Materials = []ProvenanceSubject{{
+		URI:    source,
+		Digest: digestSet(pr.Digest),
+	}}
+
+	return statement
+}
+
+// digestSet returns the digest in the form of in-toto, e.g.
+// {"sha256": "<hex>"} for "sha256:<hex>".
+func digestSet(digest Digest) map[string]string {
+	parts := strings.SplitN(string(digest), ":", 2)
+	if len(parts) != 2 {
+		return map[string]string{}
+	}
+
+	return map[string]string{parts[0]: parts[1]}
+}
+
+// ProvenanceGenerator records the provenance of promoted images.
+type ProvenanceGenerator interface {
+	// Generate records the provenance of pr, which was promoted at
+	// promotedAt.
+	Generate(
+		ctx context.Context,
+		pr PromotionRequest,
+		promotedAt time.Time,
+	) error
+}
+
+// generateProvenance records the provenance of the given (successful)
+// promotion with the ProvenanceGenerator of sc, if any.
+func (sc *SyncContext) generateProvenance(pr PromotionRequest) Errors {
+	if sc.ProvenanceGenerator == nil {
+		return nil
+	}
+
+	err := sc.withTokenRefresh(
+		sc.promotionRegistries(&pr),
+		func() error {
+			return sc.ProvenanceGenerator.Generate(
+				sc.ctx(),
+				pr,
+				sc.clock().Now())
+		})
+	if err == nil {
+		return nil
+	}
+
+	return Errors{{
+		Context: "generating provenance",
+		Error: fmt.Errorf(
+			"%s: %w",
+			ToFQIN(pr.RegistryDest, pr.ImageNameDest, pr.Digest),
+			err),
+	}}
+}
+
+// OCIProvenanceGenerator attaches the provenance of every promoted image to
+// it, as an OCI referrer: an artifact (of type ProvenanceMediaType) whose
+// subject is the promoted image, and whose only layer is the
+// ProvenanceStatement. It is pushed by its digest, next to the image.
+type OCIProvenanceGenerator struct {
+	// BuilderID identifies who promoted the images (see
+	// ProvenanceDefaultBuilderID).
+	BuilderID string
+	// Options are used for all registry requests.
+	Options []remote.Option
+}
+
+// MkOCIProvenanceGenerator creates an OCIProvenanceGenerator that accesses
+// the registries like the in-process copies of sc do.
+func (sc *SyncContext) MkOCIProvenanceGenerator(
+	builderID string,
+) *OCIProvenanceGenerator {
+	return &OCIProvenanceGenerator{
+		BuilderID: builderID,
+		Options:   sc.remoteOptions(),
+	}
+}
+
+// Generate implements ProvenanceGenerator.
+func (g *OCIProvenanceGenerator) Generate(
+	ctx context.Context,
+	pr PromotionRequest,
+	promotedAt time.Time,
+) error {
+	statement := MkProvenanceStatement(pr, g.BuilderID, promotedAt)
+
+	subjectRef, err := name.NewDigest(
+		ToFQIN(pr.RegistryDest, pr.ImageNameDest, pr.Digest))
+	if err != nil {
+		return err
+	}
+
+	opts := append([]remote.Option{remote.WithContext(ctx)}, g.Options...)
+	subject, err := remote.Head(subjectRef, opts...)
+	if err != nil {
+		return fmt.Errorf("fetching %s: %w", subjectRef, err)
+	}
+
+	return attachReferrer(
+		subjectRef.Context(),
+		subject,
+		statement,
+		promotedAt,
+		opts)
+}
+
+// referrerManifest is the manifest of an artifact that refers to (is about)
+// its subject.
+type referrerManifest struct {
+	SchemaVersion int64             `json:"schemaVersion"`
+	MediaType     types.MediaType   `json:"mediaType"`
+	ArtifactType  types.MediaType   `json:"artifactType"`
+	Config        v1.Descriptor     `json:"config"`
+	Layers        []v1.Descriptor   `json:"layers"`
+	Subject       *v1.Descriptor    `json:"subject"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// attachReferrer pushes the statement to repo as an artifact whose subject
+// is the given image.
+func attachReferrer(
+	repo name.Repository,
+	subject *v1.Descriptor,
+	statement ProvenanceStatement,
+	created time.Time,
+	opts []remote.Option,
+) error {
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return err
+	}
+
+	config := newBlobLayer([]byte("{}"), ociEmptyMediaType)
+	layer := newBlobLayer(payload, ProvenanceMediaType)
+	for _, blob := range []*blobLayer{config, layer} {
+		if err := remote.WriteLayer(repo, blob, opts...); err != nil {
+			return fmt.Errorf("uploading provenance to %s: %w", repo, err)
+		}
+	}
+
+	raw, err := json.Marshal(referrerManifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIManifestSchema1,
+		ArtifactType:  ProvenanceMediaType,
+		Config:        config.descriptor(),
+		Layers:        []v1.Descriptor{layer.descriptor()},
+		Subject: &v1.Descriptor{
+			MediaType: subject.MediaType,
+			Size:      subject.Size,
+			Digest:    subject.Digest,
+		},
+		Annotations: map[string]string{
+			ociCreatedAnnotation: created.UTC().Format(time.RFC3339),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	manifest := &rawManifest{raw: raw, mediaType: types.OCIManifestSchema1}
+	ref := repo.Digest(manifest.digest().String())
+	if err := remote.Put(ref, manifest, opts...); err != nil {
+		return fmt.Errorf("pushing provenance %s: %w", ref, err)
+	}
+
+	return nil
+}
+
+// blobLayer is a v1.Layer that is held in memory, as is (uncompressed).
+type blobLayer struct {
+	content   []byte
+	mediaType types.MediaType
+	hash      v1.Hash
+}
+
+func newBlobLayer(content []byte, mediaType types.MediaType) *blobLayer {
+	return &blobLayer{
+		content:   content,
+		mediaType: mediaType,
+		hash:      sha256Hash(content),
+	}
+}
+
+func (l *blobLayer) descriptor() v1.Descriptor {
+	return v1.Descriptor{
+		MediaType: l.mediaType,
+		Size:      int64(len(l.content)),
+		Digest:    l.hash,
+	}
+}
+
+// Digest implements v1.Layer.
+func (l *blobLayer) Digest() (v1.Hash, error) { return l.hash, nil }
+
+// DiffID implements v1.Layer.
+func (l *blobLayer) DiffID() (v1.Hash, error) { return l.hash, nil }
+
+// Compressed implements v1.Layer.
+func (l *blobLayer) Compressed() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(l.content)), nil
+}
+
+// Uncompressed implements v1.Layer.
+func (l *blobLayer) Uncompressed() (io.ReadCloser, error) {
+	return l.Compressed()
+}
+
+// Size implements v1.Layer.
+func (l *blobLayer) Size() (int64, error) { return int64(len(l.content)), nil }
+
+// MediaType implements v1.Layer.
+func (l *blobLayer) MediaType() (types.MediaType, error) { return l.mediaType, nil }
+
+// rawManifest is a manifest to be written as is (see remote.Taggable).
+type rawManifest struct {
+	raw       []byte
+	mediaType types.MediaType
+}
+
+func (m *rawManifest) digest() v1.Hash { return sha256Hash(m.raw) }
+
+// RawManifest implements remote.Taggable.
+func (m *rawManifest) RawManifest() ([]byte, error) { return m.raw, nil }
+
+// MediaType sets the Content-Type that the manifest is written with.
+func (m *rawManifest) MediaType() (types.MediaType, error) {
+	return m.mediaType, nil
+}
+
+func sha256Hash(b []byte) v1.Hash {
+	sum := sha256.Sum256(b)
+	return v1.Hash{Algorithm: "sha256", Hex: hex.EncodeToString(sum[:])}
+}
+
+// FakeProvenanceGenerator is an in-memory ProvenanceGenerator, for testing.
+type FakeProvenanceGenerator struct {
+	mutex      sync.Mutex
+	statements []ProvenanceStatement
+	// Err, if set, is returned by every call to Generate (and nothing is
+	// recorded).
+	Err error
+}
+
+// Generate implements ProvenanceGenerator.
+func (g *FakeProvenanceGenerator) Generate(
+	ctx context.Context,
+	pr PromotionRequest,
+	promotedAt time.Time,
+) error {
+	if g.Err != nil {
+		return g.Err
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.statements = append(
+		g.statements,
+		MkProvenanceStatement(pr, ProvenanceDefaultBuilderID, promotedAt))
+
+	return nil
+}
+
+// Statements returns the provenance that was generated so far.
+func (g *FakeProvenanceGenerator) Statements() []ProvenanceStatement {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	statements := make([]ProvenanceStatement, len(g.statements))
+	copy(statements, g.statements)
+
+	return statements
+}
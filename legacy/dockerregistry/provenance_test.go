@@ -0,0 +1,256 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/testregistry"
+)
+
+func TestPromotionProvenance(t *testing.T) {
+	srcRC := reg.RegistryContext{
+		Name:           "gcr.io/foo",
+		ServiceAccount: "robot",
+		Src:            true,
+	}
+	destRC := reg.RegistryContext{
+		Name:           "gcr.io/bar",
+		ServiceAccount: "robot",
+	}
+
+	edges, err := reg.ToPromotionEdges([]reg.Manifest{
+		{
+			Registries: []reg.RegistryContext{srcRC, destRC},
+			Images: []reg.Image{
+				{
+					ImageName: "a",
+					Dmap: reg.DigestTags{
+						"sha256:000": {"0.9", "1.0"},
+						"sha256:111": {},
+					},
+				},
+			},
+			SrcRegistry: &srcRC,
+		},
+	})
+	require.Nil(t, err)
+
+	promotedAt := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	provenance := func(digest reg.Digest, tag reg.Tag) reg.ProvenanceStatement {
+		return reg.MkProvenanceStatement(
+			reg.PromotionRequest{
+				TagOp:          reg.Add,
+				RegistrySrc:    "gcr.io/foo",
+				RegistryDest:   "gcr.io/bar",
+				ServiceAccount: "robot",
+				ImageNameSrc:   "a",
+				ImageNameDest:  "a",
+				Digest:         digest,
+				Tag:            tag,
+			},
+			reg.ProvenanceDefaultBuilderID,
+			promotedAt)
+	}
+
+	var tests = []struct {
+		name               string
+		dryRun             bool
+		failTag            reg.Tag
+		generateErr        error
+		expectedErr        bool
+		expectedProvenance []reg.ProvenanceStatement
+	}{
+		{
+			"Provenance for every promoted edge",
+			false,
+			"",
+			nil,
+			false,
+			[]reg.ProvenanceStatement{
+				provenance("sha256:000", "0.9"),
+				provenance("sha256:000", "1.0"),
+				provenance("sha256:111", ""),
+			},
+		},
+		{
+			"No provenance for a failed promotion",
+			false,
+			"1.0",
+			nil,
+			true,
+			[]reg.ProvenanceStatement{
+				provenance("sha256:000", "0.9"),
+				provenance("sha256:111", ""),
+			},
+		},
+		{
+			"No provenance in a dry run",
+			true,
+			"",
+			nil,
+			false,
+			[]reg.ProvenanceStatement{},
+		},
+		{
+			"Failures to record the provenance fail the promotion",
+			false,
+			"",
+			fmt.Errorf("referrers not supported"),
+			true,
+			[]reg.ProvenanceStatement{},
+		},
+	}
+
+	for _, test := range tests {
+		generator := &reg.FakeProvenanceGenerator{
+			Err: test.generateErr,
+		}
+		sc := reg.SyncContext{
+			Inv:                 reg.MasterInventory{},
+			Clock:               reg.FakeClock{Time: promotedAt},
+			DryRun:              test.dryRun,
+			CopyTool:            reg.CraneCopyTool{},
+			ProvenanceGenerator: generator,
+		}
+
+		err := sc.Promote(edges, mkFailingTagProducer(test.failTag), nil)
+		require.Equal(t, test.expectedErr, err != nil, test.name)
+		require.ElementsMatch(t,
+			test.expectedProvenance,
+			generator.Statements(),
+			test.name)
+	}
+}
+
+func TestMkProvenanceStatement(t *testing.T) {
+	statement := reg.MkProvenanceStatement(
+		reg.PromotionRequest{
+			TagOp:          reg.Add,
+			RegistrySrc:    "gcr.io/foo",
+			RegistryDest:   "gcr.io/bar",
+			ServiceAccount: "robot",
+			ImageNameSrc:   "a",
+			ImageNameDest:  "b",
+			Digest:         "sha256:000",
+			Tag:            "1.0",
+		},
+		"https://example.com/builder",
+		time.Date(2021, 6, 1, 14, 0, 0, 0, time.FixedZone("CEST", 2*3600)))
+
+	// The version of the promoter is not known in tests.
+	environment := statement.Predicate.Invocation.Environment
+	require.Contains(t, environment, "promoterVersion")
+	delete(environment, "promoterVersion")
+
+	got, err := json.Marshal(statement)
+	require.Nil(t, err)
+
+	require.JSONEq(t, `{
+		"_type": "https://in-toto.io/Statement/v0.1",
+		"subject": [{"name": "gcr.io/bar/b", "digest": {"sha256": "000"}}],
+		"predicateType": "https://slsa.dev/provenance/v0.2",
+		"predicate": {
+			"builder": {"id": "https://example.com/builder"},
+			"buildType": "https://github.com/kubernetes-sigs/k8s-container-image-promoter/promotion@v1",
+			"invocation": {
+				"parameters": {
+					"source": "gcr.io/foo/a@sha256:000",
+					"registry": "gcr.io/bar",
+					"image": "b",
+					"tag": "1.0"
+				},
+				"environment": {"serviceAccount": "robot"}
+			},
+			"metadata": {"buildFinishedOn": "2021-06-01T12:00:00Z"},
+			"materials": [{"uri": "gcr.io/foo/a@sha256:000", "digest": {"sha256": "000"}}]
+		}
+	}`, string(got))
+}
+
+func TestOCIProvenanceGenerator(t *testing.T) {
+	r := testregistry.New()
+	defer r.Close()
+
+	digest, err := r.PushRandom("prod/foo", "1.0")
+	require.Nil(t, err)
+
+	promotedAt := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	generator := &reg.OCIProvenanceGenerator{
+		BuilderID: reg.ProvenanceDefaultBuilderID,
+	}
+	pr := reg.PromotionRequest{
+		TagOp:         reg.Add,
+		RegistrySrc:   r.Name("staging"),
+		RegistryDest:  r.Name("prod"),
+		ImageNameSrc:  "foo",
+		ImageNameDest: "foo",
+		Digest:        digest,
+		Tag:           "1.0",
+	}
+	require.Nil(t, generator.Generate(context.Background(), pr, promotedAt))
+
+	// The provenance is pushed next to the image, by its digest.
+	var referrers []reg.Digest
+	for d, tags := range r.Inventory("prod")["foo"] {
+		if d != digest {
+			require.Empty(t, tags)
+			referrers = append(referrers, d)
+		}
+	}
+	require.Len(t, referrers, 1)
+
+	ref, err := name.NewDigest(
+		reg.ToFQIN(r.Name("prod"), "foo", referrers[0]))
+	require.Nil(t, err)
+	desc, err := remote.Get(ref)
+	require.Nil(t, err)
+
+	var manifest struct {
+		ArtifactType string          `json:"artifactType"`
+		Layers       []v1.Descriptor `json:"layers"`
+		Subject      v1.Descriptor   `json:"subject"`
+	}
+	require.Nil(t, json.Unmarshal(desc.Manifest, &manifest))
+	require.Equal(t, string(reg.ProvenanceMediaType), manifest.ArtifactType)
+	require.Equal(t, string(digest), manifest.Subject.Digest.String())
+	require.Len(t, manifest.Layers, 1)
+
+	layer, err := remote.Layer(ref.Context().Digest(manifest.Layers[0].Digest.String()))
+	require.Nil(t, err)
+	rc, err := layer.Compressed()
+	require.Nil(t, err)
+	defer rc.Close()
+	payload, err := ioutil.ReadAll(rc)
+	require.Nil(t, err)
+
+	var statement reg.ProvenanceStatement
+	require.Nil(t, json.NewDecoder(bytes.NewReader(payload)).Decode(&statement))
+	require.Equal(t, reg.MkProvenanceStatement(pr, reg.ProvenanceDefaultBuilderID, promotedAt), statement)
+}
@@ -0,0 +1,230 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/sirupsen/logrus"
+
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/stream"
+)
+
+// DefaultQuarantineCleanLabel is the label (or annotation) that marks an image
+// in a quarantine registry as scanned and clean, if its value is "true".
+const DefaultQuarantineCleanLabel = "scanned-clean"
+
+// ToQuarantineReleaseEdges converts a list of manifests to the edges that
+// release their images from the quarantine registry into the other destination
+// registries. Manifests without a quarantine registry have no such edges.
+func ToQuarantineReleaseEdges(
+	mfests []Manifest,
+) (map[PromotionEdge]interface{}, error) {
+	edges := make(map[PromotionEdge]interface{})
+	for _, mfest := range mfests {
+		quarantine := mfest.QuarantineRegistry()
+		if quarantine == nil {
+			continue
+		}
+
+		for i := range mfest.Images {
+			for _, destRC := range mfest.Registries {
+				if destRC.Src || destRC.Quarantine {
+					continue
+				}
+
+				addPromotionEdges(edges, *quarantine, destRC, &mfest.Images[i])
+			}
+		}
+	}
+
+	if err := checkDestImagePolicies(edges); err != nil {
+		return nil, err
+	}
+
+	return CheckOverlappingEdges(edges)
+}
+
+// FilterCleanEdges returns those edges whose source image carries the given
+// label (in its image config) or annotation (in its manifest) with the value
+// "true", such as images in a quarantine registry that were scanned and found
+// clean. The images that are held back are logged. It is an error if the
+// labels of any of the images cannot be read.
+func (sc *SyncContext) FilterCleanEdges(
+	edges map[PromotionEdge]interface{},
+	label string,
+	fakeLabelsProducer ImageLabelsProducer,
+) (map[PromotionEdge]interface{}, error) {
+	// Only read the labels of each source image once, no matter how many
+	// tags and destinations it has.
+	type srcImage struct {
+		registry  RegistryName
+		imageName ImageName
+		digest    Digest
+	}
+	srcEdges := make(map[srcImage]PromotionEdge)
+	for edge := range edges {
+		key := srcImage{
+			edge.SrcRegistry.Name,
+			edge.SrcImageTag.ImageName,
+			edge.Digest,
+		}
+		srcEdges[key] = edge
+	}
+
+	var populateRequests PopulateRequests = func(
+		sc *SyncContext,
+		reqs chan<- stream.ExternalRequest,
+		wg *sync.WaitGroup) {
+		for key := range srcEdges {
+			var req stream.ExternalRequest
+			req.RequestParams = key
+			wg.Add(1)
+			reqs <- req
+		}
+	}
+
+	labelsProducer := fakeLabelsProducer
+	if labelsProducer == nil {
+		labelsProducer = mkRealImageLabelsProducer(sc)
+	}
+
+	clean := make(map[srcImage]bool)
+	var processRequest ProcessRequest = func(
+		sc *SyncContext,
+		reqs chan stream.ExternalRequest,
+		requestResults chan<- RequestResult,
+		wg *sync.WaitGroup,
+		mutex *sync.Mutex) {
+		for req := range reqs {
+			reqRes := RequestResult{Context: req}
+			key := req.RequestParams.(srcImage)
+
+			labels, err := labelsProducer(srcEdges[key])
+			if err != nil {
+				reqRes.Errors = Errors{{
+					Context: fmt.Sprintf(
+						"reading labels of %v",
+						ToFQIN(key.registry, key.imageName, key.digest)),
+					Error: err}}
+			} else if labels[label] == "true" {
+				mutex.Lock()
+				clean[key] = true
+				mutex.Unlock()
+			}
+
+			requestResults <- reqRes
+		}
+	}
+
+	if err := sc.ExecRequests(populateRequests, processRequest); err != nil {
+		return nil, fmt.Errorf("reading the labels of the images: %v", err)
+	}
+
+	held := make([]string, 0)
+	for key := range srcEdges {
+		if !clean[key] {
+			held = append(held, ToFQIN(key.registry, key.imageName, key.digest))
+		}
+	}
+
+	cleanEdges := make(map[PromotionEdge]interface{})
+	for edge := range edges {
+		key := srcImage{
+			edge.SrcRegistry.Name,
+			edge.SrcImageTag.ImageName,
+			edge.Digest,
+		}
+		if clean[key] {
+			cleanEdges[edge] = nil
+		}
+	}
+
+	if len(held) > 0 {
+		sort.Strings(held)
+		logrus.Warnf(
+			"holding back %d image(s) without %s=true:\n  %s",
+			len(held),
+			label,
+			strings.Join(held, "\n  "))
+	}
+
+	return cleanEdges, nil
+}
+
+// mkRealImageLabelsProducer returns an ImageLabelsProducer that reads the
+// annotations of the manifest of the source image of an edge, and the labels
+// of its image config (which take precedence). Manifest lists only have
+// annotations.
+func mkRealImageLabelsProducer(sc *SyncContext) ImageLabelsProducer {
+	return func(edge PromotionEdge) (map[string]string, error) {
+		ref := ToFQIN(
+			edge.SrcRegistry.Name,
+			edge.SrcImageTag.ImageName,
+			edge.Digest)
+		opts := sc.craneOptions()
+
+		b, err := crane.Manifest(ref, opts...)
+		if err != nil {
+			return nil, err
+		}
+
+		var manifest struct {
+			Annotations map[string]string `json:"annotations"`
+			Config      struct {
+				Digest string `json:"digest"`
+			} `json:"config"`
+		}
+		if err := json.Unmarshal(b, &manifest); err != nil {
+			return nil, fmt.Errorf("parsing manifest: %v", err)
+		}
+
+		labels := make(map[string]string)
+		for k, v := range manifest.Annotations {
+			labels[k] = v
+		}
+
+		if manifest.Config.Digest == "" {
+			return labels, nil
+		}
+
+		b, err = crane.Config(ref, opts...)
+		if err != nil {
+			return nil, err
+		}
+
+		var config struct {
+			Config struct {
+				Labels map[string]string `json:"Labels"`
+			} `json:"config"`
+		}
+		if err := json.Unmarshal(b, &config); err != nil {
+			return nil, fmt.Errorf("parsing image config: %v", err)
+		}
+
+		for k, v := range config.Config.Labels {
+			labels[k] = v
+		}
+
+		return labels, nil
+	}
+}
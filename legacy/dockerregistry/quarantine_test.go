@@ -0,0 +1,389 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+func TestQuarantineEdges(t *testing.T) {
+	srcRC := reg.RegistryContext{
+		Name: "gcr.io/staging",
+		Src:  true,
+	}
+	quarantineRC := reg.RegistryContext{
+		Name:           "gcr.io/quarantine",
+		ServiceAccount: "robot",
+		Quarantine:     true,
+	}
+	prodRC := reg.RegistryContext{
+		Name:           "gcr.io/prod",
+		ServiceAccount: "robot",
+	}
+	images := []reg.Image{
+		{
+			ImageName: "a",
+			Dmap: reg.DigestTags{
+				"sha256:000": {"1.0"},
+				"sha256:111": {},
+			},
+		},
+	}
+
+	edge := func(
+		src, dst reg.RegistryContext,
+		digest reg.Digest,
+		tag reg.Tag,
+	) reg.PromotionEdge {
+		return reg.PromotionEdge{
+			SrcRegistry: src,
+			SrcImageTag: reg.ImageTag{ImageName: "a", Tag: tag},
+			Digest:      digest,
+			DstRegistry: dst,
+			DstImageTag: reg.ImageTag{ImageName: "a", Tag: tag},
+		}
+	}
+
+	var tests = []struct {
+		name                 string
+		registries           []reg.RegistryContext
+		expectedEdges        map[reg.PromotionEdge]interface{}
+		expectedReleaseEdges map[reg.PromotionEdge]interface{}
+	}{
+		{
+			"Images go to prod through quarantine",
+			[]reg.RegistryContext{srcRC, quarantineRC, prodRC},
+			map[reg.PromotionEdge]interface{}{
+				edge(srcRC, quarantineRC, "sha256:000", "1.0"): nil,
+				edge(srcRC, quarantineRC, "sha256:111", ""):    nil,
+			},
+			map[reg.PromotionEdge]interface{}{
+				edge(quarantineRC, prodRC, "sha256:000", "1.0"): nil,
+				edge(quarantineRC, prodRC, "sha256:111", ""):    nil,
+			},
+		},
+		{
+			"Without a quarantine registry, nothing is released",
+			[]reg.RegistryContext{srcRC, prodRC},
+			map[reg.PromotionEdge]interface{}{
+				edge(srcRC, prodRC, "sha256:000", "1.0"): nil,
+				edge(srcRC, prodRC, "sha256:111", ""):    nil,
+			},
+			map[reg.PromotionEdge]interface{}{},
+		},
+	}
+
+	for _, test := range tests {
+		mfests := []reg.Manifest{
+			{
+				Registries:  test.registries,
+				Images:      images,
+				SrcRegistry: &srcRC,
+			},
+		}
+
+		edges, err := reg.ToPromotionEdges(mfests)
+		require.Nil(t, err, test.name)
+		require.Equal(t, test.expectedEdges, edges, test.name)
+
+		edges, err = reg.ToQuarantineReleaseEdges(mfests)
+		require.Nil(t, err, test.name)
+		require.Equal(t, test.expectedReleaseEdges, edges, test.name)
+	}
+}
+
+func TestValidateQuarantineRegistry(t *testing.T) {
+	images := []reg.Image{
+		{
+			ImageName: "a",
+			Dmap: reg.DigestTags{
+				"sha256:0000000000000000000000000000000000000000000000000000000000000000": {"1.0"},
+			},
+		},
+	}
+
+	var tests = []struct {
+		name        string
+		registries  []reg.RegistryContext
+		expectedErr string
+	}{
+		{
+			"One quarantine registry",
+			[]reg.RegistryContext{
+				{Name: "gcr.io/staging", Src: true},
+				{Name: "gcr.io/quarantine", Quarantine: true},
+				{Name: "gcr.io/prod"},
+			},
+			"",
+		},
+		{
+			"Two quarantine registries",
+			[]reg.RegistryContext{
+				{Name: "gcr.io/staging", Src: true},
+				{Name: "gcr.io/quarantine", Quarantine: true},
+				{Name: "gcr.io/quarantine2", Quarantine: true},
+			},
+			"cannot have more than 1 quarantine registry",
+		},
+		{
+			"Source registry in quarantine",
+			[]reg.RegistryContext{
+				{Name: "gcr.io/staging", Src: true, Quarantine: true},
+				{Name: "gcr.io/prod"},
+			},
+			"registries: gcr.io/staging cannot be both the source and the quarantine registry",
+		},
+	}
+
+	for _, test := range tests {
+		err := reg.Manifest{
+			Registries: test.registries,
+			Images:     images,
+		}.Validate()
+		if test.expectedErr == "" {
+			require.Nil(t, err, test.name)
+		} else {
+			require.NotNil(t, err, test.name)
+			require.Equal(t, test.expectedErr, err.Error(), test.name)
+		}
+	}
+}
+
+func TestFilterCleanEdges(t *testing.T) {
+	quarantineRC := reg.RegistryContext{
+		Name:       "gcr.io/quarantine",
+		Quarantine: true,
+	}
+	prodRC := reg.RegistryContext{Name: "gcr.io/prod"}
+	prod2RC := reg.RegistryContext{Name: "gcr.io/prod2"}
+
+	edge := func(
+		dst reg.RegistryContext,
+		digest reg.Digest,
+		tag reg.Tag,
+	) reg.PromotionEdge {
+		return reg.PromotionEdge{
+			SrcRegistry: quarantineRC,
+			SrcImageTag: reg.ImageTag{ImageName: "a", Tag: tag},
+			Digest:      digest,
+			DstRegistry: dst,
+			DstImageTag: reg.ImageTag{ImageName: "a", Tag: tag},
+		}
+	}
+
+	edges := map[reg.PromotionEdge]interface{}{
+		edge(prodRC, "sha256:clean", "1.0"):     nil,
+		edge(prodRC, "sha256:clean", "latest"):  nil,
+		edge(prod2RC, "sha256:clean", "1.0"):    nil,
+		edge(prodRC, "sha256:dirty", "1.1"):     nil,
+		edge(prodRC, "sha256:unscanned", "2.0"): nil,
+	}
+
+	labels := map[reg.Digest]map[string]string{
+		"sha256:clean":     {"scanned-clean": "true", "other": "x"},
+		"sha256:dirty":     {"scanned-clean": "false"},
+		"sha256:unscanned": {"other": "true"},
+	}
+
+	var tests = []struct {
+		name          string
+		label         string
+		readErr       error
+		expectedEdges map[reg.PromotionEdge]interface{}
+		expectedErr   bool
+	}{
+		{
+			"Only clean images are released",
+			"scanned-clean",
+			nil,
+			map[reg.PromotionEdge]interface{}{
+				edge(prodRC, "sha256:clean", "1.0"):    nil,
+				edge(prodRC, "sha256:clean", "latest"): nil,
+				edge(prod2RC, "sha256:clean", "1.0"):   nil,
+			},
+			false,
+		},
+		{
+			"Custom label",
+			"other",
+			nil,
+			map[reg.PromotionEdge]interface{}{
+				edge(prodRC, "sha256:unscanned", "2.0"): nil,
+			},
+			false,
+		},
+		{
+			"Unreadable labels",
+			"scanned-clean",
+			fmt.Errorf("not found"),
+			nil,
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		reads := make(chan reg.Digest, len(edges))
+		sc := reg.SyncContext{}
+		got, err := sc.FilterCleanEdges(
+			edges,
+			test.label,
+			func(edge reg.PromotionEdge) (map[string]string, error) {
+				reads <- edge.Digest
+				return labels[edge.Digest], test.readErr
+			})
+		require.Equal(t, test.expectedErr, err != nil, test.name)
+		require.Equal(t, test.expectedEdges, got, test.name)
+
+		// The labels of each image are only read once.
+		close(reads)
+		read := make([]reg.Digest, 0)
+		for digest := range reads {
+			read = append(read, digest)
+		}
+		require.ElementsMatch(t,
+			[]reg.Digest{"sha256:clean", "sha256:dirty", "sha256:unscanned"},
+			read,
+			test.name)
+	}
+}
+
+// annotatedImage is an image whose manifest has annotations.
+type annotatedImage struct {
+	v1.Image
+	annotations map[string]string
+}
+
+func (img *annotatedImage) Manifest() (*v1.Manifest, error) {
+	manifest, err := img.Image.Manifest()
+	if err != nil {
+		return nil, err
+	}
+
+	manifest = manifest.DeepCopy()
+	manifest.Annotations = img.annotations
+	return manifest, nil
+}
+
+func (img *annotatedImage) RawManifest() ([]byte, error) {
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(manifest)
+}
+
+func (img *annotatedImage) Digest() (v1.Hash, error) {
+	return partial.Digest(img)
+}
+
+func TestFilterCleanEdgesReadsLabels(t *testing.T) {
+	srv := httptest.NewServer(
+		registry.New(registry.Logger(log.New(ioutil.Discard, "", 0))))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	quarantineRC := reg.RegistryContext{
+		Name:       reg.RegistryName(host + "/quarantine"),
+		Quarantine: true,
+	}
+	prodRC := reg.RegistryContext{Name: reg.RegistryName(host + "/prod")}
+
+	// push writes an image to the quarantine registry, and returns the edge
+	// that releases it.
+	push := func(
+		tag reg.Tag,
+		labels map[string]string,
+		annotations map[string]string,
+	) reg.PromotionEdge {
+		img, err := random.Image(64, 1)
+		require.Nil(t, err)
+		if labels != nil {
+			img, err = mutate.Config(img, v1.Config{Labels: labels})
+			require.Nil(t, err)
+		}
+		if annotations != nil {
+			img = &annotatedImage{img, annotations}
+		}
+
+		ref, err := name.ParseReference(
+			fmt.Sprintf("%s/quarantine/a:%s", host, tag))
+		require.Nil(t, err)
+		require.Nil(t, remote.Write(ref, img))
+
+		digest, err := img.Digest()
+		require.Nil(t, err)
+
+		return reg.PromotionEdge{
+			SrcRegistry: quarantineRC,
+			SrcImageTag: reg.ImageTag{ImageName: "a", Tag: tag},
+			Digest:      reg.Digest(digest.String()),
+			DstRegistry: prodRC,
+			DstImageTag: reg.ImageTag{ImageName: "a", Tag: tag},
+		}
+	}
+
+	cleanLabel := push("1.0", map[string]string{"scanned-clean": "true"}, nil)
+	cleanAnnotation := push("1.1", nil, map[string]string{"scanned-clean": "true"})
+	dirty := push("1.2", map[string]string{"scanned-clean": "false"}, nil)
+	unscanned := push("1.3", nil, nil)
+
+	sc := reg.SyncContext{}
+	got, err := sc.FilterCleanEdges(
+		map[reg.PromotionEdge]interface{}{
+			cleanLabel:      nil,
+			cleanAnnotation: nil,
+			dirty:           nil,
+			unscanned:       nil,
+		},
+		reg.DefaultQuarantineCleanLabel,
+		nil)
+	require.Nil(t, err)
+	require.Equal(t,
+		map[reg.PromotionEdge]interface{}{
+			cleanLabel:      nil,
+			cleanAnnotation: nil,
+		},
+		got)
+
+	// Images that are not in quarantine cannot be checked.
+	missing := unscanned
+	missing.Digest = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	_, err = sc.FilterCleanEdges(
+		map[reg.PromotionEdge]interface{}{missing: nil},
+		reg.DefaultQuarantineCleanLabel,
+		nil)
+	require.NotNil(t, err)
+}
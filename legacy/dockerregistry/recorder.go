@@ -0,0 +1,117 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// CommandRecorder records the commands that the promotion requests of a dry
+// run would run (see SyncContext.CommandRecorder), instead of running them.
+// Images that would be copied in-process are recorded as the equivalent
+// "crane" commands.
+type CommandRecorder struct {
+	mutex    sync.Mutex
+	commands [][]string
+}
+
+// Record records the command (one argument per element).
+func (r *CommandRecorder) Record(cmd []string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.commands = append(r.commands, cmd)
+}
+
+// Commands returns the recorded commands, in the order they were recorded.
+func (r *CommandRecorder) Commands() [][]string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	commands := make([][]string, len(r.commands))
+	copy(commands, r.commands)
+
+	return commands
+}
+
+// Script returns the recorded commands as a shell script that stops at the
+// first command that fails.
+func (r *CommandRecorder) Script() string {
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\nset -e\n")
+
+	for _, cmd := range r.Commands() {
+		quoted := make([]string, 0, len(cmd))
+		for _, arg := range cmd {
+			quoted = append(quoted, shellQuote(arg))
+		}
+		b.WriteString(strings.Join(quoted, " "))
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+// WriteScript writes Script() to an executable file at path.
+func (r *CommandRecorder) WriteScript(path string) error {
+	return ioutil.WriteFile(path, []byte(r.Script()), 0o755)
+}
+
+// shellSafe matches the arguments that need no quoting in a shell.
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9@%+=:,./_-]+$`)
+
+// shellQuote quotes arg for a POSIX shell, if needed.
+func shellQuote(arg string) string {
+	if shellSafe.MatchString(arg) {
+		return arg
+	}
+
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// recordCommands records the commands of the captured requests of a dry run
+// to sc.CommandRecorder (if set), in the order they are printed in.
+func (sc *SyncContext) recordCommands(capReqs *CapturedRequests) {
+	if sc.CommandRecorder == nil {
+		return
+	}
+
+	// nolint: gocritic
+	for _, pr := range sortedCapturedRequests(capReqs) {
+		if pr.TagOp != Add && pr.TagOp != Retag {
+			continue
+		}
+
+		sc.CommandRecorder.Record(GetWriteCmd(
+			RegistryContext{
+				Name:           pr.RegistryDest,
+				ServiceAccount: pr.ServiceAccount,
+			},
+			sc.UseServiceAccount,
+			sc.MirroredRegistry(pr.RegistrySrc),
+			pr.ImageNameSrc,
+			pr.ImageNameDest,
+			pr.Digest,
+			pr.Tag,
+			pr.TagOp,
+			sc.CopyTool,
+		))
+	}
+}
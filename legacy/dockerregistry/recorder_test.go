@@ -0,0 +1,145 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/stream"
+)
+
+func TestCommandRecorder(t *testing.T) {
+	srcRC := reg.RegistryContext{
+		Name: "gcr.io/foo",
+		Src:  true,
+	}
+	destRC := reg.RegistryContext{
+		Name: "gcr.io/bar",
+	}
+
+	mfest := reg.Manifest{
+		Registries: []reg.RegistryContext{srcRC, destRC},
+		Images: []reg.Image{
+			{
+				ImageName: "a",
+				Dmap: reg.DigestTags{
+					"sha256:000": {"1.0", "stable"},
+				},
+			},
+			{
+				ImageName: "b",
+				Dmap: reg.DigestTags{
+					"sha256:111": {"2.0"},
+				},
+			},
+		},
+		SrcRegistry: &srcRC,
+	}
+
+	tests := []struct {
+		name     string
+		copyTool reg.CopyTool
+		expected [][]string
+	}{
+		{
+			"In-process copies",
+			nil,
+			[][]string{
+				{"crane", "tag", "gcr.io/bar/a@sha256:000", "stable"},
+				{"crane", "copy", "gcr.io/foo/b@sha256:111", "gcr.io/bar/b:2.0"},
+			},
+		},
+		{
+			"Skopeo",
+			reg.SkopeoCopyTool{},
+			[][]string{
+				{
+					"skopeo",
+					"copy",
+					"--all",
+					"docker://gcr.io/bar/a@sha256:000",
+					"docker://gcr.io/bar/a:stable",
+				},
+				{
+					"skopeo",
+					"copy",
+					"--all",
+					"docker://gcr.io/foo/b@sha256:111",
+					"docker://gcr.io/bar/b:2.0",
+				},
+			},
+		},
+	}
+
+	// The copy tool processes are never started in a dry run.
+	mkProducer := func(
+		reg.RegistryName,
+		reg.ImageName,
+		reg.RegistryContext,
+		reg.ImageName,
+		reg.Digest,
+		reg.Tag,
+		reg.TagOp,
+	) stream.Producer {
+		return &stream.Fake{}
+	}
+
+	for _, test := range tests {
+		sc, err := reg.MakeSyncContext([]reg.Manifest{mfest}, 1, true, false)
+		require.Nil(t, err, test.name)
+		sc.CopyTool = test.copyTool
+		sc.CommandRecorder = &reg.CommandRecorder{}
+
+		// "a:1.0" is already promoted, so only "a:stable" has to be added
+		// (as a tag).
+		sc.Inv = reg.MasterInventory{
+			srcRC.Name: {
+				"a": {"sha256:000": {"1.0", "stable"}},
+				"b": {"sha256:111": {"2.0"}},
+			},
+			destRC.Name: {
+				"a": {"sha256:000": {"1.0"}},
+			},
+		}
+
+		edges, err := reg.ToPromotionEdges([]reg.Manifest{mfest})
+		require.Nil(t, err, test.name)
+		edges, ok := sc.FilterPromotionEdges(edges, false)
+		require.True(t, ok, test.name)
+
+		require.Nil(t, sc.Promote(edges, mkProducer, nil), test.name)
+		require.Equal(t, test.expected, sc.CommandRecorder.Commands(), test.name)
+	}
+}
+
+func TestCommandRecorderScript(t *testing.T) {
+	var r reg.CommandRecorder
+	r.Record([]string{"crane", "copy", "gcr.io/foo/a@sha256:000", "gcr.io/bar/a:1.0"})
+	r.Record([]string{"echo", "it's", "a b", ""})
+
+	require.Equal(
+		t,
+		`#!/bin/sh
+set -e
+crane copy gcr.io/foo/a@sha256:000 gcr.io/bar/a:1.0
+echo 'it'\''s' 'a b' ''
+`,
+		r.Script())
+}
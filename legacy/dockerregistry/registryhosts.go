@@ -0,0 +1,100 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ValidateRegistryHostPatterns checks that every pattern is a valid glob for
+// CheckRegistryHosts() (see path.Match()).
+func ValidateRegistryHostPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if pattern == "" || strings.Contains(pattern, "/") {
+			return fmt.Errorf(
+				"invalid registry host pattern %q (expected a hostname glob, e.g. '*.pkg.dev')",
+				pattern)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid registry host pattern %q: %w", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// RegistryHost returns the host (with the port, if any) of a registry name,
+// e.g. "gcr.io" for "gcr.io/foo".
+func RegistryHost(registryName RegistryName) string {
+	return strings.SplitN(string(registryName), "/", 2)[0]
+}
+
+// CheckRegistryHosts fails unless the host of every registry of the manifests
+// matches one of the glob patterns (e.g., "gcr.io" or "*.pkg.dev"; see
+// path.Match()). All registries that are not allowed are reported together.
+func CheckRegistryHosts(mfests []Manifest, patterns []string) error {
+	if err := ValidateRegistryHostPatterns(patterns); err != nil {
+		return err
+	}
+
+	rejected := make(map[string]interface{})
+	for _, mfest := range mfests {
+		for _, rc := range mfest.Registries {
+			if registryHostAllowed(rc.Name, patterns) {
+				continue
+			}
+
+			if mfest.Filepath == "" {
+				rejected[string(rc.Name)] = nil
+			} else {
+				rejected[fmt.Sprintf("%s: %s", mfest.Filepath, rc.Name)] = nil
+			}
+		}
+	}
+
+	if len(rejected) == 0 {
+		return nil
+	}
+
+	lines := make([]string, 0, len(rejected))
+	for line := range rejected {
+		lines = append(lines, line)
+	}
+	sort.Strings(lines)
+
+	return fmt.Errorf(
+		"%d registry(ies) are not on an allowed host (%s):\n  %s",
+		len(lines),
+		strings.Join(patterns, ", "),
+		strings.Join(lines, "\n  "))
+}
+
+// registryHostAllowed returns true if the host of registryName matches any of
+// the (valid) patterns.
+func registryHostAllowed(registryName RegistryName, patterns []string) bool {
+	host := RegistryHost(registryName)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, host); ok {
+			return true
+		}
+	}
+
+	return false
+}
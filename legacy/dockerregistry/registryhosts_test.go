@@ -0,0 +1,92 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+func TestCheckRegistryHosts(t *testing.T) {
+	mkManifest := func(
+		filepath string,
+		registries ...reg.RegistryName,
+	) reg.Manifest {
+		mfest := reg.Manifest{Filepath: filepath}
+		for _, registry := range registries {
+			mfest.Registries = append(
+				mfest.Registries,
+				reg.RegistryContext{Name: registry})
+		}
+		return mfest
+	}
+
+	patterns := []string{"gcr.io", "*.pkg.dev", "localhost:*"}
+
+	tests := []struct {
+		name        string
+		mfests      []reg.Manifest
+		expectedErr string
+	}{
+		{
+			"Allowed hosts",
+			[]reg.Manifest{
+				mkManifest("a.yaml", "gcr.io/foo", "us-docker.pkg.dev/bar/images"),
+				mkManifest("b.yaml", "localhost:5000"),
+			},
+			"",
+		},
+		{
+			"Disallowed hosts",
+			[]reg.Manifest{
+				mkManifest("a.yaml", "gcr.io/foo", "docker.io/foo"),
+				mkManifest("b.yaml", "eu.gcr.io/foo", "pkg.dev/bar"),
+				mkManifest("", "gcr.io.example.com/foo"),
+			},
+			`4 registry(ies) are not on an allowed host (gcr.io, *.pkg.dev, localhost:*):
+  a.yaml: docker.io/foo
+  b.yaml: eu.gcr.io/foo
+  b.yaml: pkg.dev/bar
+  gcr.io.example.com/foo`,
+		},
+	}
+
+	for _, test := range tests {
+		err := reg.CheckRegistryHosts(test.mfests, patterns)
+		if test.expectedErr == "" {
+			require.Nil(t, err, test.name)
+			continue
+		}
+		require.NotNil(t, err, test.name)
+		require.Equal(t, test.expectedErr, err.Error(), test.name)
+	}
+}
+
+func TestValidateRegistryHostPatterns(t *testing.T) {
+	require.Nil(t, reg.ValidateRegistryHostPatterns(nil))
+	require.Nil(t, reg.ValidateRegistryHostPatterns([]string{"gcr.io", "*.pkg.dev"}))
+
+	for _, pattern := range []string{"", "gcr.io/foo", "[gcr.io"} {
+		require.NotNil(
+			t,
+			reg.ValidateRegistryHostPatterns([]string{pattern}),
+			pattern)
+	}
+}
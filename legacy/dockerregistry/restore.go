@@ -0,0 +1,162 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ParseRegInvImageFromCSV parses a snapshot in the CSV format written by
+// RegInvImage.ToCSV(), i.e., lines of "<image>@<digest>,<image>:<tag>", or
+// "<image>@<digest>,-" for digests without tags. Blank lines are ignored.
+func ParseRegInvImageFromCSV(r io.Reader) (RegInvImage, error) {
+	rii := make(RegInvImage)
+
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		imageName, digest, tag, err := parseSnapshotCSVLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+
+		if rii[imageName] == nil {
+			rii[imageName] = make(DigestTags)
+		}
+		tags := rii[imageName][digest]
+		if tags == nil {
+			tags = TagSlice{}
+		}
+		if tag != "" && !hasTag(tags, tag) {
+			tags = append(tags, tag)
+		}
+		rii[imageName][digest] = tags
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return rii, nil
+}
+
+// parseSnapshotCSVLine splits a line of a CSV snapshot into its image name,
+// digest and tag (empty for "-").
+func parseSnapshotCSVLine(line string) (ImageName, Digest, Tag, error) {
+	fields := strings.Split(line, ",")
+	if len(fields) != 2 {
+		return "", "", "", fmt.Errorf(
+			"want '<image>@<digest>,<image>:<tag>' or '<image>@<digest>,-', got %q",
+			line)
+	}
+
+	at := strings.LastIndex(fields[0], "@")
+	if at <= 0 {
+		return "", "", "", fmt.Errorf("missing image digest in %q", fields[0])
+	}
+	imageName := ImageName(fields[0][:at])
+	digest := Digest(fields[0][at+1:])
+	if err := ValidateDigest(digest); err != nil {
+		return "", "", "", err
+	}
+
+	if fields[1] == "-" {
+		return imageName, digest, "", nil
+	}
+
+	prefix := string(imageName) + ":"
+	if !strings.HasPrefix(fields[1], prefix) {
+		return "", "", "", fmt.Errorf(
+			"tagged image %q is not image %q", fields[1], imageName)
+	}
+	tag := Tag(strings.TrimPrefix(fields[1], prefix))
+	if err := ValidateTag(tag); err != nil {
+		return "", "", "", err
+	}
+
+	return imageName, digest, tag, nil
+}
+
+// ParseRegInvImageFromYAML parses a snapshot in the default YAML format
+// written by RegInvImage.ToYAML() (which is also the format of the images of
+// thin manifests).
+func ParseRegInvImageFromYAML(r io.Reader) (RegInvImage, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	images, err := ParseImagesYAML(b)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateImages(images); err != nil {
+		return nil, err
+	}
+
+	rii := make(RegInvImage)
+	for _, image := range images {
+		rii[image.ImageName] = image.Dmap
+	}
+
+	return rii, nil
+}
+
+// SnapshotToManifest builds a manifest that promotes every digest and tag of
+// the snapshot from the src registry to the dest registry, under the same image
+// names. This restores a registry from a snapshot of it (see
+// ParseRegInvImageFromCSV), given a registry that still has its images.
+func SnapshotToManifest(
+	rii RegInvImage,
+	src RegistryContext,
+	dest RegistryContext,
+) (Manifest, error) {
+	src.Src = true
+	dest.Src = false
+
+	if err := rii.Validate(); err != nil {
+		return Manifest{}, err
+	}
+
+	images := make([]Image, 0, len(rii))
+	for imageName, dmap := range rii {
+		for _, tags := range dmap {
+			sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+		}
+		images = append(images, Image{ImageName: imageName, Dmap: dmap})
+	}
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].ImageName < images[j].ImageName
+	})
+
+	mfest := Manifest{
+		Registries: []RegistryContext{src, dest},
+		Images:     images,
+	}
+	if err := mfest.Finalize(); err != nil {
+		return Manifest{}, err
+	}
+
+	return mfest, nil
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+var (
+	restoreDigestA = reg.Digest("sha256:" + strings.Repeat("a", 64))
+	restoreDigestB = reg.Digest("sha256:" + strings.Repeat("b", 64))
+)
+
+func TestRestoreFromCSV(t *testing.T) {
+	rii := reg.RegInvImage{
+		"foo/a": {
+			restoreDigestA: {"1.0", "latest"},
+			restoreDigestB: {},
+		},
+		"b": {
+			restoreDigestB: {"2.0"},
+		},
+	}
+
+	// CSV -> RegInvImage.
+	got, err := reg.ParseRegInvImageFromCSV(strings.NewReader(rii.ToCSV()))
+	require.Nil(t, err)
+	require.Equal(t, rii, got)
+	require.Equal(t, rii.ToCSV(), got.ToCSV())
+
+	// RegInvImage -> edges.
+	srcRC := reg.RegistryContext{Name: "gcr.io/backup", Src: true}
+	destRC := reg.RegistryContext{Name: "gcr.io/restored"}
+	mfest, err := reg.SnapshotToManifest(
+		got,
+		reg.RegistryContext{Name: "gcr.io/backup"},
+		reg.RegistryContext{Name: "gcr.io/restored"},
+	)
+	require.Nil(t, err)
+	require.Equal(t, srcRC, mfest.SrcRegistry)
+
+	edges, err := reg.ToPromotionEdges([]reg.Manifest{mfest})
+	require.Nil(t, err)
+
+	mkEdge := func(
+		imageName reg.ImageName,
+		digest reg.Digest,
+		tag reg.Tag,
+	) reg.PromotionEdge {
+		return reg.PromotionEdge{
+			SrcRegistry: srcRC,
+			SrcImageTag: reg.ImageTag{ImageName: imageName, Tag: tag},
+			Digest:      digest,
+			DstRegistry: destRC,
+			DstImageTag: reg.ImageTag{ImageName: imageName, Tag: tag},
+		}
+	}
+	require.Equal(
+		t,
+		map[reg.PromotionEdge]interface{}{
+			mkEdge("b", restoreDigestB, "2.0"):        nil,
+			mkEdge("foo/a", restoreDigestA, "1.0"):    nil,
+			mkEdge("foo/a", restoreDigestA, "latest"): nil,
+			mkEdge("foo/a", restoreDigestB, ""):       nil,
+		},
+		edges)
+
+	// The edges restore the snapshot.
+	restoredEdges := make([]reg.PromotionEdge, 0, len(edges))
+	for edge := range edges {
+		restoredEdges = append(restoredEdges, edge)
+	}
+	restored := reg.ProjectInventory(nil, restoredEdges)
+	require.Equal(t, rii.ToCSV(), restored.ToCSV())
+}
+
+func TestParseRegInvImageFromCSVErrors(t *testing.T) {
+	tests := []struct {
+		csv         string
+		expectedErr string
+	}{
+		{
+			"a@" + string(restoreDigestA),
+			"line 1: want '<image>@<digest>,<image>:<tag>' or '<image>@<digest>,-', got \"a@" + string(restoreDigestA) + "\"",
+		},
+		{
+			"\na,a:1.0",
+			`line 2: missing image digest in "a"`,
+		},
+		{
+			"a@sha256:123,-",
+			"line 1: invalid digest: sha256:123",
+		},
+		{
+			"a@" + string(restoreDigestA) + ",b:1.0",
+			`line 1: tagged image "b:1.0" is not image "a"`,
+		},
+		{
+			"a@" + string(restoreDigestA) + ",a:-bad",
+			"line 1: invalid tag: -bad",
+		},
+	}
+
+	for _, test := range tests {
+		_, err := reg.ParseRegInvImageFromCSV(strings.NewReader(test.csv))
+		require.NotNil(t, err, test.csv)
+		require.Equal(t, test.expectedErr, err.Error(), test.csv)
+	}
+}
+
+func TestParseRegInvImageFromYAML(t *testing.T) {
+	rii := reg.RegInvImage{
+		"a": {
+			restoreDigestA: {"1.0", "latest"},
+			restoreDigestB: {},
+		},
+	}
+
+	got, err := reg.ParseRegInvImageFromYAML(
+		strings.NewReader(rii.ToYAML(reg.YamlMarshalingOpts{})))
+	require.Nil(t, err)
+	require.Equal(t, rii, got)
+
+	_, err = reg.ParseRegInvImageFromYAML(strings.NewReader(`- name: a
+  dmap:
+    "sha256:123": ["1.0"]
+`))
+	require.NotNil(t, err)
+	require.Equal(t, "invalid digest: sha256:123", err.Error())
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/stream"
+)
+
+// SignatureFileSuffix is appended to the path of a signed file to get the path
+// of its signature.
+const SignatureFileSuffix = ".sig"
+
+// BlobSigner signs the contents of the file at the given path, and returns the
+// signature in the form that 'cosign sign-blob' prints it (base64-encoded).
+type BlobSigner func(path string) ([]byte, error)
+
+// SignFile signs the file at the given path with sign, and writes the
+// signature next to it (with SignatureFileSuffix appended to its path). It
+// returns the path of the signature. Consumers can then check the file with
+// 'cosign verify-blob --key <public key> --signature <file>.sig <file>'.
+func SignFile(path string, sign BlobSigner) (string, error) {
+	signature, err := sign(path)
+	if err != nil {
+		return "", fmt.Errorf("signing %s: %w", path, err)
+	}
+
+	signature = bytes.TrimSpace(signature)
+	if len(signature) == 0 {
+		return "", fmt.Errorf("signing %s: got an empty signature", path)
+	}
+
+	sigPath := path + SignatureFileSuffix
+	if err := ioutil.WriteFile(sigPath, append(signature, '\n'), 0o644); err != nil {
+		return "", fmt.Errorf("writing signature of %s: %w", path, err)
+	}
+
+	return sigPath, nil
+}
+
+// MkCosignBlobSignerReal signs files with 'cosign sign-blob', using the given
+// key (a path or a KMS URI, as understood by cosign). The password of the key,
+// if any, is read by cosign from the COSIGN_PASSWORD environment variable.
+func MkCosignBlobSignerReal(key string) BlobSigner {
+	return func(path string) ([]byte, error) {
+		var sp stream.Subprocess
+		sp.CmdInvocation = []string{
+			"cosign",
+			"sign-blob",
+			"--key",
+			key,
+			path,
+		}
+
+		return readProducer(&sp)
+	}
+}
+
+// SignatureVerifier checks the detached signature at signaturePath against the
+// given data, and returns an error unless it is valid.
+type SignatureVerifier func(data []byte, signaturePath string) error
+
+// MkGPGVerifierReal verifies detached GPG signatures with 'gpgv', against the
+// public keys in the given keyring (as exported by 'gpg --export').
+func MkGPGVerifierReal(keyring string) SignatureVerifier {
+	return func(data []byte, signaturePath string) error {
+		// gpgv needs the signed data in a file of its own.
+		f, err := ioutil.TempFile("", "cip-signed-")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+
+		if _, err := f.Write(data); err != nil {
+			f.Close()
+			return err
+		}
+
+		if err := f.Close(); err != nil {
+			return err
+		}
+
+		var sp stream.Subprocess
+		sp.CmdInvocation = []string{
+			"gpgv",
+			"--keyring",
+			keyring,
+			signaturePath,
+			f.Name(),
+		}
+
+		_, err = readProducer(&sp)
+		return err
+	}
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+func TestSignFile(t *testing.T) {
+	tests := []struct {
+		name              string
+		signature         string
+		signErr           error
+		expectedSignature string
+		expectedError     string
+	}{
+		{
+			"Signature is written next to the file",
+			"TUVVQ0lRRHNpZ25hdHVyZQ==\n",
+			nil,
+			"TUVVQ0lRRHNpZ25hdHVyZQ==\n",
+			"",
+		},
+		{
+			"Signing fails",
+			"",
+			fmt.Errorf("no such key"),
+			"",
+			"no such key",
+		},
+		{
+			"Empty signature",
+			"\n",
+			nil,
+			"",
+			"got an empty signature",
+		},
+	}
+
+	for _, test := range tests {
+		snapshot := filepath.Join(t.TempDir(), "snapshot.yaml")
+		require.Nil(t, ioutil.WriteFile(snapshot, []byte("- name: foo\n"), 0o644))
+
+		var signed []string
+		sign := func(path string) ([]byte, error) {
+			signed = append(signed, path)
+			return []byte(test.signature), test.signErr
+		}
+
+		sigPath, err := reg.SignFile(snapshot, sign)
+		require.Equal(t, []string{snapshot}, signed, test.name)
+		if test.expectedError != "" {
+			require.NotNil(t, err, test.name)
+			require.Contains(t, err.Error(), test.expectedError, test.name)
+
+			_, err := os.Stat(snapshot + reg.SignatureFileSuffix)
+			require.True(t, os.IsNotExist(err), test.name)
+			continue
+		}
+		require.Nil(t, err, test.name)
+		require.Equal(t, snapshot+".sig", sigPath, test.name)
+
+		got, err := ioutil.ReadFile(sigPath)
+		require.Nil(t, err, test.name)
+		require.Equal(t, test.expectedSignature, string(got), test.name)
+	}
+}
+
+func TestParseSignedManifestFromFile(t *testing.T) {
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "promoter-manifest.yaml")
+	require.Nil(t, ioutil.WriteFile(manifest, []byte(`registries:
+- name: gcr.io/foo
+  src: true
+- name: gcr.io/bar
+  service-account: robot
+images:
+- name: a
+  dmap:
+    "sha256:0000000000000000000000000000000000000000000000000000000000000000": ["1.0"]
+`), 0o644))
+	signature := manifest + ".sig"
+
+	// validSignature stands in for a signature that gpgv would accept.
+	validSignature := []byte("valid")
+
+	// verify accepts the signature if it is the valid one, and was made for
+	// the contents of the manifest.
+	verify := func(data []byte, signaturePath string) error {
+		got, err := ioutil.ReadFile(signaturePath)
+		if err != nil {
+			return err
+		}
+
+		want, err := ioutil.ReadFile(manifest)
+		if err != nil {
+			return err
+		}
+
+		if string(got) != string(validSignature) || string(data) != string(want) {
+			return fmt.Errorf("BAD signature")
+		}
+
+		return nil
+	}
+
+	tests := []struct {
+		name          string
+		signature     []byte
+		expectedError string
+	}{
+		{
+			"Valid signature",
+			validSignature,
+			"",
+		},
+		{
+			"Invalid signature",
+			[]byte("forged"),
+			"BAD signature",
+		},
+	}
+
+	for _, test := range tests {
+		require.Nil(t, ioutil.WriteFile(signature, test.signature, 0o644))
+
+		got, err := reg.ParseSignedManifestFromFile(manifest, signature, verify)
+		if test.expectedError != "" {
+			require.NotNil(t, err, test.name)
+			require.Contains(t, err.Error(), test.expectedError, test.name)
+			continue
+		}
+
+		require.Nil(t, err, test.name)
+		require.Equal(t, manifest, got.Filepath, test.name)
+		require.Len(t, got.Images, 1, test.name)
+	}
+
+	// Missing signatures are rejected as well.
+	_, err := reg.ParseSignedManifestFromFile(
+		manifest,
+		filepath.Join(dir, "missing.sig"),
+		verify,
+	)
+	require.NotNil(t, err)
+}
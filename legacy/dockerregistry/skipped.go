@@ -0,0 +1,105 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"sort"
+)
+
+// SkipReason is the reason code of an edge that GetPromotionCandidates() left
+// out of the promotion.
+type SkipReason string
+
+// The reasons for which edges are skipped. Only some of them (e.g.,
+// SkipTagMove) also fail the run.
+const (
+	// SkipUnreadableSource is used if the source image could not be read.
+	SkipUnreadableSource SkipReason = "unreadable-source"
+	// SkipAlreadyPromoted is used if the destination already has the image.
+	SkipAlreadyPromoted SkipReason = "already-promoted"
+	// SkipLost is used if the digest is missing from the source registry.
+	SkipLost SkipReason = "lost"
+	// SkipTagMove is used if the destination tag points to another digest,
+	// and may not be moved.
+	SkipTagMove SkipReason = "tag-move"
+	// SkipReadOnly is used if the destination registry is read-only.
+	SkipReadOnly SkipReason = "read-only"
+	// SkipDestPathPolicy is used if the destination image does not match the
+	// destination path policy.
+	SkipDestPathPolicy SkipReason = "dest-path-policy"
+	// SkipInvalidWindow is used if the promotion window could not be checked.
+	SkipInvalidWindow SkipReason = "invalid-window"
+	// SkipOutsideWindow is used if the edge is outside of the promotion window
+	// of its image.
+	SkipOutsideWindow SkipReason = "outside-window"
+	// SkipMaxTags is used if the destination image would exceed its MaxTags.
+	SkipMaxTags SkipReason = "max-tags"
+)
+
+// SkippedEdge is the machine-readable form of an edge that was skipped.
+type SkippedEdge struct {
+	Source RegistryName `json:"source"`
+	Dest   RegistryName `json:"dest"`
+	Image  ImageName    `json:"image"`
+	Digest Digest       `json:"digest"`
+	// Tag is empty for tagless promotions.
+	Tag Tag `json:"tag"`
+}
+
+// SkippedEdges holds the skipped edges of each SkipReason.
+type SkippedEdges map[SkipReason][]SkippedEdge
+
+// recordSkipped records that edge was skipped for the given reason in
+// sc.Logs.Skipped, if sc.LogSkippedEdges is set.
+func (sc *SyncContext) recordSkipped(edge PromotionEdge, reason SkipReason) {
+	if !sc.LogSkippedEdges {
+		return
+	}
+
+	if sc.Logs.Skipped == nil {
+		sc.Logs.Skipped = make(SkippedEdges)
+	}
+	sc.Logs.Skipped[reason] = append(sc.Logs.Skipped[reason], SkippedEdge{
+		Source: edge.SrcRegistry.Name,
+		Dest:   edge.DstRegistry.Name,
+		Image:  edge.DstImageTag.ImageName,
+		Digest: edge.Digest,
+		Tag:    edge.DstImageTag.Tag,
+	})
+}
+
+// sort sorts the edges of every reason, so that the summary does not depend on
+// the order in which the edges were checked.
+func (skipped SkippedEdges) sort() {
+	for _, edges := range skipped {
+		sort.Slice(edges, func(i, j int) bool {
+			a, b := edges[i], edges[j]
+			switch {
+			case a.Dest != b.Dest:
+				return a.Dest < b.Dest
+			case a.Image != b.Image:
+				return a.Image < b.Image
+			case a.Tag != b.Tag:
+				return a.Tag < b.Tag
+			case a.Digest != b.Digest:
+				return a.Digest < b.Digest
+			default:
+				return a.Source < b.Source
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+func TestGetPromotionCandidatesLogSkippedEdges(t *testing.T) {
+	srcRC := reg.RegistryContext{
+		Name:           "gcr.io/foo",
+		ServiceAccount: "robot",
+		Src:            true,
+	}
+	destRC := reg.RegistryContext{
+		Name:           "gcr.io/bar",
+		ServiceAccount: "robot",
+	}
+	readOnlyRC := reg.RegistryContext{
+		Name:     "gcr.io/archive",
+		ReadOnly: true,
+	}
+
+	mkEdge := func(
+		dest reg.RegistryContext,
+		imageName reg.ImageName,
+		digest reg.Digest,
+		tag reg.Tag,
+	) reg.PromotionEdge {
+		return reg.PromotionEdge{
+			SrcRegistry: srcRC,
+			SrcImageTag: reg.ImageTag{ImageName: imageName, Tag: tag},
+			Digest:      digest,
+			DstRegistry: dest,
+			DstImageTag: reg.ImageTag{ImageName: imageName, Tag: tag},
+		}
+	}
+	mkSkipped := func(
+		dest reg.RegistryContext,
+		imageName reg.ImageName,
+		digest reg.Digest,
+		tag reg.Tag,
+	) reg.SkippedEdge {
+		return reg.SkippedEdge{
+			Source: srcRC.Name,
+			Dest:   dest.Name,
+			Image:  imageName,
+			Digest: digest,
+			Tag:    tag,
+		}
+	}
+
+	outsideWindow := mkEdge(destRC, "window", "sha256:111", "1.0")
+	outsideWindow.Window = "Mon-Fri 09:00-17:00 America/New_York"
+	invalidWindow := mkEdge(destRC, "badwindow", "sha256:111", "1.0")
+	invalidWindow.Window = "someday"
+	capped := mkEdge(destRC, "capped", "sha256:222", "2.0")
+	capped.MaxTags = 1
+	fresh := mkEdge(destRC, "fresh", "sha256:111", "1.0")
+
+	edges := map[reg.PromotionEdge]interface{}{
+		mkEdge(destRC, "ignored", "sha256:111", "1.0"):      nil,
+		mkEdge(destRC, "existing", "sha256:111", "1.0"):     nil,
+		mkEdge(destRC, "existing", "sha256:222", ""):        nil,
+		mkEdge(destRC, "lost", "sha256:999", "1.0"):         nil,
+		mkEdge(destRC, "moved", "sha256:111", "1.0"):        nil,
+		mkEdge(readOnlyRC, "archived", "sha256:111", "1.0"): nil,
+		mkEdge(destRC, "nested/image", "sha256:111", "1.0"): nil,
+		outsideWindow: nil,
+		invalidWindow: nil,
+		capped:        nil,
+		fresh:         nil,
+	}
+
+	srcImage := reg.DigestTags{
+		"sha256:111": {"1.0"},
+		"sha256:222": {"2.0"},
+	}
+	inv := reg.MasterInventory{
+		"gcr.io/foo": {
+			"ignored":      srcImage,
+			"existing":     srcImage,
+			"lost":         srcImage,
+			"moved":        srcImage,
+			"archived":     srcImage,
+			"nested/image": srcImage,
+			"window":       srcImage,
+			"badwindow":    srcImage,
+			"capped":       srcImage,
+			"fresh":        srcImage,
+		},
+		"gcr.io/bar": {
+			"existing": {
+				"sha256:111": {"1.0"},
+				"sha256:222": {},
+			},
+			"moved": {
+				"sha256:111": {"0.9"},
+				"sha256:333": {"1.0"},
+			},
+			"capped": {
+				"sha256:111": {"1.0"},
+			},
+		},
+	}
+
+	policy, err := reg.MkDestPathPolicy(`gcr.io/[a-z]+/[a-z]+`)
+	require.Nil(t, err)
+
+	// A Saturday.
+	now := time.Date(2021, time.June, 19, 15, 0, 0, 0, time.UTC)
+
+	for _, logSkippedEdges := range []bool{false, true} {
+		sc := reg.SyncContext{
+			Inv:             inv,
+			InvIgnore:       []reg.ImageName{"ignored"},
+			DestPathPolicy:  policy,
+			Clock:           reg.FakeClock{Time: now},
+			LogSkippedEdges: logSkippedEdges,
+		}
+
+		got, gotClean := sc.GetPromotionCandidates(edges)
+		require.Equal(
+			t,
+			map[reg.PromotionEdge]interface{}{fresh: nil},
+			got)
+		require.False(t, gotClean)
+
+		if !logSkippedEdges {
+			require.Nil(t, sc.Logs.Skipped)
+			continue
+		}
+
+		require.Equal(
+			t,
+			reg.SkippedEdges{
+				reg.SkipUnreadableSource: {
+					mkSkipped(destRC, "ignored", "sha256:111", "1.0"),
+				},
+				reg.SkipAlreadyPromoted: {
+					mkSkipped(destRC, "existing", "sha256:222", ""),
+					mkSkipped(destRC, "existing", "sha256:111", "1.0"),
+				},
+				reg.SkipLost: {
+					mkSkipped(destRC, "lost", "sha256:999", "1.0"),
+				},
+				reg.SkipTagMove: {
+					mkSkipped(destRC, "moved", "sha256:111", "1.0"),
+				},
+				reg.SkipReadOnly: {
+					mkSkipped(readOnlyRC, "archived", "sha256:111", "1.0"),
+				},
+				reg.SkipDestPathPolicy: {
+					mkSkipped(destRC, "nested/image", "sha256:111", "1.0"),
+				},
+				reg.SkipInvalidWindow: {
+					mkSkipped(destRC, "badwindow", "sha256:111", "1.0"),
+				},
+				reg.SkipOutsideWindow: {
+					mkSkipped(destRC, "window", "sha256:111", "1.0"),
+				},
+				reg.SkipMaxTags: {
+					mkSkipped(destRC, "capped", "sha256:222", "2.0"),
+				},
+			},
+			sc.Logs.Skipped)
+
+		// Every run only reports its own skipped edges.
+		_, _ = sc.GetPromotionCandidates(map[reg.PromotionEdge]interface{}{
+			fresh: nil,
+		})
+		require.Nil(t, sc.Logs.Skipped)
+	}
+}
+
+func TestCollectedLogsSkippedJSON(t *testing.T) {
+	logs := reg.CollectedLogs{}
+	marshalled, err := json.Marshal(logs)
+	require.Nil(t, err)
+	require.NotContains(t, string(marshalled), "Skipped")
+
+	logs.Skipped = reg.SkippedEdges{
+		reg.SkipAlreadyPromoted: {
+			{
+				Source: "gcr.io/foo",
+				Dest:   "gcr.io/bar",
+				Image:  "a",
+				Digest: "sha256:111",
+				Tag:    "1.0",
+			},
+		},
+	}
+	marshalled, err = json.Marshal(logs)
+	require.Nil(t, err)
+	require.Contains(
+		t,
+		string(marshalled),
+		`"Skipped":{"already-promoted":[{"source":"gcr.io/foo","dest":"gcr.io/bar","image":"a","digest":"sha256:111","tag":"1.0"}]}`)
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// SmokePull pulls the manifest of the image ref (by tag or by digest) the way
+// a client would, and checks that it is the manifest of digest, and that it
+// can be parsed. It tells whether a promoted image is actually servable by the
+// destination registry, rather than just accepted by it.
+func SmokePull(ref string, digest Digest, opts ...remote.Option) error {
+	parsedRef, err := name.ParseReference(ref)
+	if err != nil {
+		return err
+	}
+
+	desc, err := remote.Get(parsedRef, opts...)
+	if err != nil {
+		return fmt.Errorf("pulling the manifest: %w", err)
+	}
+
+	if desc.Digest.String() != string(digest) {
+		return fmt.Errorf(
+			"pulled manifest has digest %s, expected %s",
+			desc.Digest,
+			digest)
+	}
+
+	switch {
+	case desc.MediaType.IsIndex():
+		_, err = v1.ParseIndexManifest(bytes.NewReader(desc.Manifest))
+	case desc.MediaType.IsImage():
+		_, err = v1.ParseManifest(bytes.NewReader(desc.Manifest))
+	}
+	if err != nil {
+		return fmt.Errorf("parsing the pulled manifest: %w", err)
+	}
+
+	return nil
+}
+
+// smokeVerify smoke-pulls the image of the given (successful) promotion from
+// the destination (see SmokePull()), under its tag if it has one, if
+// sc.SmokeVerify is set.
+func (sc *SyncContext) smokeVerify(pr PromotionRequest) Errors {
+	if !sc.SmokeVerify {
+		return nil
+	}
+
+	ref := ToFQIN(pr.RegistryDest, pr.ImageNameDest, pr.Digest)
+	if pr.Tag != "" {
+		ref = ToPQIN(pr.RegistryDest, pr.ImageNameDest, pr.Tag)
+	}
+
+	err := sc.withTokenRefresh(
+		sc.promotionRegistries(&pr),
+		func() error {
+			return SmokePull(
+				ref,
+				pr.Digest,
+				append(sc.remoteOptions(), remote.WithContext(sc.ctx()))...)
+		})
+	if err == nil {
+		return nil
+	}
+
+	return Errors{{
+		Context: "smoke-verifying promotion",
+		Error:   fmt.Errorf("%s: %w", ref, err),
+	}}
+}
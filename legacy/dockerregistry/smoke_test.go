@@ -0,0 +1,185 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/stream"
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/testregistry"
+)
+
+func TestSmokePull(t *testing.T) {
+	r := testregistry.New()
+	defer r.Close()
+
+	image, err := r.PushRandom("prod/foo", "1.0")
+	require.Nil(t, err)
+	list, err := r.PushRandomIndex("prod/bar", 2, "2.0")
+	require.Nil(t, err)
+
+	// By tag and by digest.
+	for _, ref := range []string{
+		reg.ToPQIN(r.Name("prod"), "foo", "1.0"),
+		reg.ToFQIN(r.Name("prod"), "foo", image),
+	} {
+		require.Nil(t, reg.SmokePull(ref, image), ref)
+	}
+	require.Nil(t, reg.SmokePull(reg.ToPQIN(r.Name("prod"), "bar", "2.0"), list))
+
+	// The tag points at another digest.
+	err = reg.SmokePull(reg.ToPQIN(r.Name("prod"), "foo", "1.0"), list)
+	require.NotNil(t, err)
+	require.Equal(
+		t,
+		fmt.Sprintf("pulled manifest has digest %s, expected %s", image, list),
+		err.Error())
+
+	// The image is not there.
+	require.NotNil(t, reg.SmokePull(reg.ToPQIN(r.Name("prod"), "foo", "2.0"), image))
+}
+
+// mkManifestServer serves manifest as the manifest of every image, with the
+// given media type.
+func mkManifestServer(manifest []byte, mediaType types.MediaType) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if !strings.Contains(r.URL.Path, "/manifests/") {
+				// The API version check.
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+
+			w.Header().Set("Content-Type", string(mediaType))
+			// nolint[errcheck]
+			w.Write(manifest)
+		},
+	))
+}
+
+func TestPromoteSmokeVerify(t *testing.T) {
+	img, err := random.Image(1024, 1)
+	require.Nil(t, err)
+	manifest, err := img.RawManifest()
+	require.Nil(t, err)
+	hash, err := img.Digest()
+	require.Nil(t, err)
+	digest := reg.Digest(hash.String())
+
+	broken := []byte(`{"schemaVersion": 2, "layers": [`)
+	brokenHash := reg.Digest(fmt.Sprintf("sha256:%x", sha256.Sum256(broken)))
+
+	tests := []struct {
+		name        string
+		manifest    []byte
+		digest      reg.Digest
+		smokeVerify bool
+		expectedErr bool
+	}{
+		{
+			"Servable image",
+			manifest,
+			digest,
+			true,
+			false,
+		},
+		{
+			"Broken manifest",
+			broken,
+			digest,
+			true,
+			true,
+		},
+		{
+			// The registry serves exactly what was promoted, but it cannot
+			// be parsed.
+			"Broken manifest of the promoted digest",
+			broken,
+			brokenHash,
+			true,
+			true,
+		},
+		{
+			"Broken manifest without smoke verification",
+			broken,
+			digest,
+			false,
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		server := mkManifestServer(test.manifest, types.DockerManifestSchema2)
+
+		srcRC := reg.RegistryContext{Name: "gcr.io/foo", Src: true}
+		destRC := reg.RegistryContext{
+			Name: reg.RegistryName(strings.TrimPrefix(server.URL, "http://")),
+		}
+		edges, err := reg.ToPromotionEdges([]reg.Manifest{
+			{
+				Registries: []reg.RegistryContext{srcRC, destRC},
+				Images: []reg.Image{
+					{
+						ImageName: "a",
+						Dmap:      reg.DigestTags{test.digest: {"1.0"}},
+					},
+				},
+				SrcRegistry: &srcRC,
+			},
+		})
+		require.Nil(t, err, test.name)
+
+		sc := reg.SyncContext{
+			Inv:         reg.MasterInventory{},
+			CopyTool:    reg.CraneCopyTool{},
+			SmokeVerify: test.smokeVerify,
+		}
+
+		// The copies are faked, so the image is only "promoted" if the
+		// destination serves it.
+		err = sc.Promote(
+			edges,
+			func(
+				srcRegistry reg.RegistryName,
+				srcImageName reg.ImageName,
+				destRC reg.RegistryContext,
+				destImageName reg.ImageName,
+				digest reg.Digest,
+				tag reg.Tag,
+				tp reg.TagOp,
+			) stream.Producer {
+				return &stream.Fake{}
+			},
+			nil)
+		server.Close()
+
+		require.Equal(t, test.expectedErr, err != nil, test.name)
+		if test.expectedErr {
+			require.Equal(t, 1, sc.Logs.Promotions.Failed, test.name)
+		}
+	}
+}
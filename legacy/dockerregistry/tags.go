@@ -0,0 +1,86 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"fmt"
+
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/stream"
+)
+
+// ReadImageTags reads the tags of the single image (repository) of rc, e.g.
+// "gcr.io/foo/bar", without reading its child repositories. The tagged digests
+// are returned as a RegInvImage with a single image, named like in a snapshot
+// of the repository it is in (i.e., "bar"); tagless digests are left out. It
+// is an error if the image cannot be read, or if it has no tags.
+func (sc *SyncContext) ReadImageTags(
+	rc RegistryContext,
+	mkProducer func(*SyncContext, RegistryContext) stream.Producer,
+) (RegInvImage, error) {
+	err := ValidateRegistryImagePath(RegistryImagePath(rc.Name))
+	if err != nil {
+		return nil, err
+	}
+
+	rootReg, imageName, err := SplitByKnownRegistries(
+		rc.Name,
+		[]RegistryContext{rc})
+	if err != nil {
+		return nil, err
+	}
+
+	// ReadRegistries() files what it reads under the known registries.
+	known := false
+	for _, registry := range sc.RegistryContexts {
+		if registry.Name == rc.Name {
+			known = true
+		}
+	}
+	if !known {
+		sc.RegistryContexts = append(sc.RegistryContexts, rc)
+	}
+	if sc.Inv == nil {
+		sc.Inv = make(MasterInventory)
+	}
+	if sc.DigestMediaType == nil {
+		sc.DigestMediaType = make(DigestMediaType)
+	}
+	if sc.DigestImageSize == nil {
+		sc.DigestImageSize = make(DigestImageSize)
+	}
+
+	sc.ReadRegistries([]RegistryContext{rc}, false, mkProducer)
+	if len(sc.Logs.Errors) > 0 {
+		return nil, fmt.Errorf(
+			"reading %s: %v",
+			rc.Name,
+			sc.Logs.Errors[0].Error)
+	}
+
+	tagged := make(DigestTags)
+	for digest, tags := range sc.Inv[rootReg][imageName] {
+		if len(tags) > 0 {
+			tagged[digest] = tags
+		}
+	}
+
+	if len(tagged) == 0 {
+		return nil, fmt.Errorf("no tags found for %s", rc.Name)
+	}
+
+	return RegInvImage{imageName: tagged}, nil
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/stream"
+)
+
+func TestReadImageTags(t *testing.T) {
+	// A single repository, which also has a child repository (that must not be
+	// read).
+	const barRepo = `{
+  "child": [
+    "baz"
+  ],
+  "manifest": {
+    "sha256:0000000000000000000000000000000000000000000000000000000000000000": {
+      "imageSizeBytes": "12875324",
+      "layerId": "",
+      "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+      "tag": [
+        "latest",
+        "1.0"
+      ],
+      "timeCreatedMs": "1501774217070",
+      "timeUploadedMs": "1552917295327"
+    },
+    "sha256:1111111111111111111111111111111111111111111111111111111111111111": {
+      "imageSizeBytes": "12875324",
+      "layerId": "",
+      "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+      "tag": [
+        "0.9"
+      ],
+      "timeCreatedMs": "1501774217070",
+      "timeUploadedMs": "1552917295327"
+    },
+    "sha256:2222222222222222222222222222222222222222222222222222222222222222": {
+      "imageSizeBytes": "12875324",
+      "layerId": "",
+      "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+      "tag": [],
+      "timeCreatedMs": "1501774217070",
+      "timeUploadedMs": "1552917295327"
+    }
+  },
+  "name": "foo/bar",
+  "tags": [
+    "latest",
+    "1.0",
+    "0.9"
+  ]
+}`
+	const taglessRepo = `{
+  "child": [],
+  "manifest": {
+    "sha256:2222222222222222222222222222222222222222222222222222222222222222": {
+      "imageSizeBytes": "12875324",
+      "layerId": "",
+      "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+      "tag": [],
+      "timeCreatedMs": "1501774217070",
+      "timeUploadedMs": "1552917295327"
+    }
+  },
+  "name": "foo/bar",
+  "tags": []
+}`
+
+	tests := []struct {
+		name             string
+		image            reg.RegistryName
+		input            string
+		cancelled        bool
+		expectedOutput   reg.RegInvImage
+		expectedErrorMsg string
+	}{
+		{
+			"Tagged digests of the image",
+			"gcr.io/foo/bar",
+			barRepo,
+			false,
+			reg.RegInvImage{
+				"bar": {
+					"sha256:0000000000000000000000000000000000000000000000000000000000000000": {"latest", "1.0"},
+					"sha256:1111111111111111111111111111111111111111111111111111111111111111": {"0.9"},
+				},
+			},
+			"",
+		},
+		{
+			"Image without tags",
+			"gcr.io/foo/bar",
+			taglessRepo,
+			false,
+			nil,
+			"no tags found for gcr.io/foo/bar",
+		},
+		{
+			"Image that cannot be read",
+			"gcr.io/foo/bar",
+			barRepo,
+			true,
+			nil,
+			"reading gcr.io/foo/bar",
+		},
+		{
+			"Invalid image",
+			"bar",
+			barRepo,
+			false,
+			nil,
+			"invalid registry image path",
+		},
+	}
+
+	for _, test := range tests {
+		sc := reg.SyncContext{}
+		if test.cancelled {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			sc.Context = ctx
+		}
+
+		// Record the repositories that were read, to check that only the
+		// image itself was.
+		var mutex sync.Mutex
+		read := make([]reg.RegistryName, 0)
+		input := test.input
+		mkFakeStream := func(
+			sc *reg.SyncContext,
+			rc reg.RegistryContext,
+		) stream.Producer {
+			mutex.Lock()
+			read = append(read, rc.Name)
+			mutex.Unlock()
+
+			return &stream.Fake{Bytes: []byte(input)}
+		}
+
+		got, err := sc.ReadImageTags(
+			reg.RegistryContext{Name: test.image, Src: true},
+			mkFakeStream)
+		if test.expectedErrorMsg != "" {
+			require.NotNil(t, err, test.name)
+			require.Contains(t, err.Error(), test.expectedErrorMsg, test.name)
+			continue
+		}
+
+		require.Nil(t, err, test.name)
+		require.Equal(t, test.expectedOutput, got, test.name)
+		require.Equal(t, []reg.RegistryName{test.image}, read, test.name)
+	}
+}
@@ -0,0 +1,260 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/stream"
+)
+
+// RegistryTLS holds the TLS settings for the connections to a registry host
+// that requires client certificates (mutual TLS), or whose certificate is
+// signed by a private CA.
+type RegistryTLS struct {
+	// ClientCert and ClientKey are the paths of the PEM-encoded client
+	// certificate and its private key. They are either both set or both empty.
+	ClientCert string
+	ClientKey  string
+	// CABundle is the path of a PEM bundle of CA certificates that the
+	// certificate of the registry is verified against, in addition to the
+	// system roots.
+	CABundle string
+	// Config is loaded from the files above.
+	Config *tls.Config
+
+	// transport is shared by all in-process copies to or from the host.
+	transport *http.Transport
+}
+
+// MkRegistryTLS loads the given client certificate and key and CA bundle (any
+// of which may be empty, but the certificate only together with its key).
+func MkRegistryTLS(clientCert, clientKey, caBundle string) (*RegistryTLS, error) {
+	if (clientCert == "") != (clientKey == "") {
+		return nil, fmt.Errorf(
+			"a client certificate needs a key and vice versa (got certificate %q and key %q)",
+			clientCert,
+			clientKey)
+	}
+
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if clientCert != "" {
+		cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if caBundle != "" {
+		pem, err := ioutil.ReadFile(caBundle)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA bundle: %v", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %q", caBundle)
+		}
+		config.RootCAs = pool
+	}
+
+	return &RegistryTLS{
+		ClientCert: clientCert,
+		ClientKey:  clientKey,
+		CABundle:   caBundle,
+		Config:     config,
+		transport:  mkTLSTransport(config),
+	}, nil
+}
+
+// mkTLSTransport returns a copy of http.DefaultTransport that uses the given
+// TLS configuration.
+func mkTLSTransport(config *tls.Config) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+
+	return transport
+}
+
+// MkRegistryTLSMap loads the TLS settings of every registry host that has a
+// client certificate, key or CA bundle in the given maps (all of which are
+// keyed by registry host, e.g. "registry.example.com:5000").
+func MkRegistryTLSMap(
+	clientCerts map[string]string,
+	clientKeys map[string]string,
+	caBundles map[string]string,
+) (map[string]*RegistryTLS, error) {
+	hosts := make(map[string]bool)
+	for _, m := range []map[string]string{clientCerts, clientKeys, caBundles} {
+		for host := range m {
+			hosts[host] = true
+		}
+	}
+
+	registryTLS := make(map[string]*RegistryTLS)
+	for host := range hosts {
+		rt, err := MkRegistryTLS(
+			clientCerts[host],
+			clientKeys[host],
+			caBundles[host])
+		if err != nil {
+			return nil, fmt.Errorf("registry %s: %v", host, err)
+		}
+		registryTLS[host] = rt
+	}
+
+	return registryTLS, nil
+}
+
+// CertDir returns the directory that "skopeo --src-cert-dir/--dest-cert-dir"
+// can read the files from. Skopeo only takes a directory, in which it uses
+// all "*.crt" files as CAs and all "*.cert" files as client certificates
+// (with the key in the ".key" file of the same name), so the files must all
+// be in the same directory and be named that way.
+func (rt *RegistryTLS) CertDir() (string, error) {
+	var dir string
+	for _, file := range []struct {
+		path string
+		ext  string
+	}{
+		{rt.ClientCert, ".cert"},
+		{rt.ClientKey, ".key"},
+		{rt.CABundle, ".crt"},
+	} {
+		if file.path == "" {
+			continue
+		}
+
+		if filepath.Ext(file.path) != file.ext {
+			return "", fmt.Errorf(
+				"skopeo only reads %q files, but got %q",
+				"*"+file.ext,
+				file.path)
+		}
+
+		if dir == "" {
+			dir = filepath.Dir(file.path)
+		} else if filepath.Dir(file.path) != dir {
+			return "", fmt.Errorf(
+				"skopeo reads all files from a single directory, but %q is not in %q",
+				file.path,
+				dir)
+		}
+	}
+
+	if rt.ClientCert != "" &&
+		strings.TrimSuffix(rt.ClientCert, ".cert") !=
+			strings.TrimSuffix(rt.ClientKey, ".key") {
+		return "", fmt.Errorf(
+			"skopeo expects the key of %q to be named %q, not %q",
+			rt.ClientCert,
+			strings.TrimSuffix(rt.ClientCert, ".cert")+".key",
+			rt.ClientKey)
+	}
+
+	return dir, nil
+}
+
+// SkopeoCertDirs returns the certificate directories (see CertDir()) of the
+// given registry hosts.
+func SkopeoCertDirs(
+	registryTLS map[string]*RegistryTLS,
+) (map[string]string, error) {
+	hosts := make([]string, 0, len(registryTLS))
+	for host := range registryTLS {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	certDirs := make(map[string]string)
+	for _, host := range hosts {
+		dir, err := registryTLS[host].CertDir()
+		if err != nil {
+			return nil, fmt.Errorf("registry %s: %v", host, err)
+		}
+		certDirs[host] = dir
+	}
+
+	return certDirs, nil
+}
+
+// tlsConfig returns the TLS configuration for connections to the given
+// registry host, or nil if it has none.
+func (sc *SyncContext) tlsConfig(host string) *tls.Config {
+	if rt, ok := sc.RegistryTLS[host]; ok {
+		return rt.Config
+	}
+
+	return nil
+}
+
+// tlsTransport is an http.RoundTripper that connects to the registry hosts
+// with TLS settings through their own transports, and to all other hosts
+// through base.
+type tlsTransport struct {
+	transports map[string]*http.Transport
+	base       http.RoundTripper
+}
+
+// mkRegistryTLSTransport returns a tlsTransport for the registry hosts with the
+// given TLS settings. The hosts share the transports of their RegistryTLS,
+// unless a proxy is configured explicitly; then they get transports that go
+// through the proxy, like base.
+func mkRegistryTLSTransport(
+	registryTLS map[string]*RegistryTLS,
+	proxy stream.Proxy,
+	base http.RoundTripper,
+) *tlsTransport {
+	transports := make(map[string]*http.Transport, len(registryTLS))
+	for host, rt := range registryTLS {
+		transport := rt.transport
+		// Not made with MkRegistryTLS(), or it does not know the proxy.
+		if transport == nil || !proxy.IsZero() {
+			transport = mkTLSTransport(rt.Config)
+			transport.Proxy = proxy.ProxyFunc()
+		}
+		transports[host] = transport
+	}
+
+	return &tlsTransport{
+		transports: transports,
+		base:       base,
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *tlsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if transport, ok := t.transports[req.URL.Host]; ok {
+		return transport.RoundTrip(req)
+	}
+
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,562 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/stream"
+)
+
+// testPKI is a CA with a server certificate (for 127.0.0.1) and a client
+// certificate, all written to a directory in the layout that skopeo expects.
+type testPKI struct {
+	dir        string
+	caBundle   string
+	clientCert string
+	clientKey  string
+	serverCert tls.Certificate
+	caPool     *x509.CertPool
+}
+
+func mkTestPKI(t *testing.T) *testPKI {
+	dir := t.TempDir()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(
+		rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.Nil(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	require.Nil(t, err)
+
+	// issue returns a certificate and key signed by the CA, PEM-encoded.
+	issue := func(
+		serial int64,
+		usage x509.ExtKeyUsage,
+		ips []net.IP,
+	) (certPEM, keyPEM []byte) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.Nil(t, err)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "test"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  ips,
+		}
+		der, err := x509.CreateCertificate(
+			rand.Reader, template, ca, &key.PublicKey, caKey)
+		require.Nil(t, err)
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		require.Nil(t, err)
+
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	}
+
+	pki := &testPKI{
+		dir:        dir,
+		caBundle:   filepath.Join(dir, "ca.crt"),
+		clientCert: filepath.Join(dir, "client.cert"),
+		clientKey:  filepath.Join(dir, "client.key"),
+		caPool:     x509.NewCertPool(),
+	}
+	pki.caPool.AddCert(ca)
+
+	write := func(path string, b []byte) {
+		require.Nil(t, ioutil.WriteFile(path, b, 0600))
+	}
+	write(pki.caBundle,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}))
+	clientCert, clientKey := issue(2, x509.ExtKeyUsageClientAuth, nil)
+	write(pki.clientCert, clientCert)
+	write(pki.clientKey, clientKey)
+
+	serverCert, serverKey := issue(
+		3,
+		x509.ExtKeyUsageServerAuth,
+		[]net.IP{net.ParseIP("127.0.0.1")})
+	pki.serverCert, err = tls.X509KeyPair(serverCert, serverKey)
+	require.Nil(t, err)
+
+	return pki
+}
+
+// mkMutualTLSRegistry starts an in-memory registry that only accepts clients
+// with a certificate of the PKI's CA.
+func mkMutualTLSRegistry(pki *testPKI) *httptest.Server {
+	srv := httptest.NewUnstartedServer(
+		registry.New(registry.Logger(log.New(ioutil.Discard, "", 0))))
+	// The handshakes of the clients without a certificate fail on purpose.
+	srv.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{pki.serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pki.caPool,
+		MinVersion:   tls.VersionTLS12,
+	}
+	srv.StartTLS()
+
+	return srv
+}
+
+func TestMkRegistryTLS(t *testing.T) {
+	pki := mkTestPKI(t)
+
+	var tests = []struct {
+		name          string
+		clientCert    string
+		clientKey     string
+		caBundle      string
+		expectedCerts int
+		expectedCA    bool
+		expectedErr   bool
+	}{
+		{
+			"Client certificate and CA bundle",
+			pki.clientCert,
+			pki.clientKey,
+			pki.caBundle,
+			1,
+			true,
+			false,
+		},
+		{
+			"CA bundle only",
+			"",
+			"",
+			pki.caBundle,
+			0,
+			true,
+			false,
+		},
+		{
+			"Client certificate without key",
+			pki.clientCert,
+			"",
+			"",
+			0,
+			false,
+			true,
+		},
+		{
+			"Key that does not match the certificate",
+			pki.clientCert,
+			pki.caBundle,
+			"",
+			0,
+			false,
+			true,
+		},
+		{
+			"Missing CA bundle",
+			"",
+			"",
+			filepath.Join(pki.dir, "missing.crt"),
+			0,
+			false,
+			true,
+		},
+		{
+			"CA bundle without certificates",
+			"",
+			"",
+			pki.clientKey,
+			0,
+			false,
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		rt, err := reg.MkRegistryTLS(
+			test.clientCert,
+			test.clientKey,
+			test.caBundle)
+		require.Equal(t, test.expectedErr, err != nil, test.name)
+		if err != nil {
+			continue
+		}
+
+		require.Len(t, rt.Config.Certificates, test.expectedCerts, test.name)
+		require.Equal(t, test.expectedCA, rt.Config.RootCAs != nil, test.name)
+	}
+}
+
+func TestMkRegistryTLSMap(t *testing.T) {
+	pki := mkTestPKI(t)
+
+	registryTLS, err := reg.MkRegistryTLSMap(
+		map[string]string{"a.example.com": pki.clientCert},
+		map[string]string{"a.example.com": pki.clientKey},
+		map[string]string{"b.example.com:5000": pki.caBundle},
+	)
+	require.Nil(t, err)
+	require.Len(t, registryTLS, 2)
+	require.Equal(t, pki.clientCert, registryTLS["a.example.com"].ClientCert)
+	require.Equal(t, "", registryTLS["a.example.com"].CABundle)
+	require.Equal(t, "", registryTLS["b.example.com:5000"].ClientCert)
+	require.Equal(t, pki.caBundle, registryTLS["b.example.com:5000"].CABundle)
+
+	// The key of a certificate must be given for the same registry.
+	_, err = reg.MkRegistryTLSMap(
+		map[string]string{"a.example.com": pki.clientCert},
+		map[string]string{"b.example.com": pki.clientKey},
+		nil,
+	)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "registry a.example.com: ")
+}
+
+func TestRegistryTLSCertDir(t *testing.T) {
+	var tests = []struct {
+		name        string
+		rt          reg.RegistryTLS
+		expectedDir string
+		expectedErr bool
+	}{
+		{
+			"Skopeo layout",
+			reg.RegistryTLS{
+				ClientCert: "/certs/client.cert",
+				ClientKey:  "/certs/client.key",
+				CABundle:   "/certs/ca.crt",
+			},
+			"/certs",
+			false,
+		},
+		{
+			"CA bundle only",
+			reg.RegistryTLS{CABundle: "/certs/ca.crt"},
+			"/certs",
+			false,
+		},
+		{
+			"Wrong extension",
+			reg.RegistryTLS{
+				ClientCert: "/certs/client.pem",
+				ClientKey:  "/certs/client.key",
+			},
+			"",
+			true,
+		},
+		{
+			"Key not named after the certificate",
+			reg.RegistryTLS{
+				ClientCert: "/certs/client.cert",
+				ClientKey:  "/certs/other.key",
+			},
+			"",
+			true,
+		},
+		{
+			"Files in different directories",
+			reg.RegistryTLS{
+				ClientCert: "/certs/client.cert",
+				ClientKey:  "/certs/client.key",
+				CABundle:   "/etc/ca.crt",
+			},
+			"",
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		dir, err := test.rt.CertDir()
+		require.Equal(t, test.expectedErr, err != nil, test.name)
+		require.Equal(t, test.expectedDir, dir, test.name)
+	}
+}
+
+func TestSkopeoCopyToolCertDirs(t *testing.T) {
+	tool := reg.SkopeoCopyTool{
+		CertDirs: map[string]string{
+			"src.example.com":      "/certs/src",
+			"dest.example.com:443": "/certs/dest",
+		},
+	}
+	dest := reg.RegistryContext{Name: "dest.example.com:443/prod"}
+
+	require.Equal(t,
+		[]string{
+			"skopeo",
+			"copy",
+			"--all",
+			"--src-cert-dir",
+			"/certs/src",
+			"--dest-cert-dir",
+			"/certs/dest",
+			"docker://src.example.com/staging/a@sha256:000",
+			"docker://dest.example.com:443/prod/a:1.0",
+		},
+		tool.CopyCmd(
+			dest,
+			false,
+			"src.example.com/staging/a@sha256:000",
+			"dest.example.com:443/prod/a:1.0"))
+
+	// Retagging stays within the destination registry.
+	require.Equal(t,
+		[]string{
+			"skopeo",
+			"copy",
+			"--all",
+			"--src-cert-dir",
+			"/certs/dest",
+			"--dest-cert-dir",
+			"/certs/dest",
+			"docker://dest.example.com:443/prod/a@sha256:000",
+			"docker://dest.example.com:443/prod/a:1.0",
+		},
+		tool.TagCmd(dest, false, "a", "sha256:000", "1.0"))
+
+	// Registries without TLS settings get no flags.
+	require.Equal(t,
+		[]string{
+			"skopeo",
+			"copy",
+			"--all",
+			"docker://gcr.io/foo/a@sha256:000",
+			"docker://gcr.io/bar/a:1.0",
+		},
+		tool.CopyCmd(
+			reg.RegistryContext{Name: "gcr.io/bar"},
+			false,
+			"gcr.io/foo/a@sha256:000",
+			"gcr.io/bar/a:1.0"))
+}
+
+func TestRegistryTLSIsApplied(t *testing.T) {
+	pki := mkTestPKI(t)
+	srv := mkMutualTLSRegistry(pki)
+	defer srv.Close()
+	host := srv.Listener.Addr().String()
+
+	rt, err := reg.MkRegistryTLS(pki.clientCert, pki.clientKey, pki.caBundle)
+	require.Nil(t, err)
+
+	// Seed the source repository.
+	img, err := random.Image(1024, 1)
+	require.Nil(t, err)
+	digest, err := img.Digest()
+	require.Nil(t, err)
+	ref, err := name.ParseReference(host + "/foo/a:1.0")
+	require.Nil(t, err)
+	require.Nil(t, remote.Write(
+		ref,
+		img,
+		remote.WithTransport(&http.Transport{TLSClientConfig: rt.Config})))
+
+	srcRC := reg.RegistryContext{
+		Name: reg.RegistryName(host + "/foo"),
+		Src:  true,
+	}
+	destRC := reg.RegistryContext{
+		Name: reg.RegistryName(host + "/bar"),
+	}
+
+	var tests = []struct {
+		name        string
+		registryTLS map[string]*reg.RegistryTLS
+		expectedErr bool
+	}{
+		{
+			"TLS settings of the registry",
+			map[string]*reg.RegistryTLS{host: rt},
+			false,
+		},
+		{
+			"No TLS settings",
+			nil,
+			true,
+		},
+		{
+			"TLS settings of another registry",
+			map[string]*reg.RegistryTLS{"registry.example.com": rt},
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		sc := reg.SyncContext{
+			Inv:         reg.MasterInventory{},
+			RegistryTLS: test.registryTLS,
+		}
+
+		// Reads.
+		producer := reg.MkReadRepositoryCmdReal(
+			&sc,
+			reg.RegistryContext{Name: srcRC.Name + "/a", Src: true})
+		stdout, _, err := producer.Produce()
+		require.Equal(t, test.expectedErr, err != nil, test.name)
+		if err == nil {
+			tags, err := ioutil.ReadAll(stdout)
+			require.Nil(t, err, test.name)
+			require.Contains(t, string(tags), `"1.0"`, test.name)
+			require.Nil(t, producer.Close(), test.name)
+		}
+
+		// In-process copies.
+		edges, err := reg.ToPromotionEdges([]reg.Manifest{
+			{
+				Registries: []reg.RegistryContext{srcRC, destRC},
+				Images: []reg.Image{
+					{
+						ImageName: "a",
+						Dmap: reg.DigestTags{
+							reg.Digest(digest.String()): {"1.0"},
+						},
+					},
+				},
+				SrcRegistry: &srcRC,
+			},
+		})
+		require.Nil(t, err, test.name)
+		err = sc.Promote(edges, nil, nil)
+		require.Equal(t, test.expectedErr, err != nil, test.name)
+	}
+
+	// The successful promotion copied the image.
+	ref, err = name.ParseReference(host + "/bar/a:1.0")
+	require.Nil(t, err)
+	desc, err := remote.Get(
+		ref,
+		remote.WithTransport(&http.Transport{TLSClientConfig: rt.Config}))
+	require.Nil(t, err)
+	require.Equal(t, digest, desc.Digest)
+}
+
+func TestProxyIsApplied(t *testing.T) {
+	pki := mkTestPKI(t)
+	rt, err := reg.MkRegistryTLS(pki.clientCert, pki.clientKey, pki.caBundle)
+	require.Nil(t, err)
+
+	// The registry does not exist (".invalid" never resolves), so the copy
+	// fails either way; what matters is whether it went through the proxy.
+	srcRC := reg.RegistryContext{
+		Name: "registry.invalid/foo",
+		Src:  true,
+	}
+	destRC := reg.RegistryContext{
+		Name: "registry.invalid/bar",
+	}
+	edges, err := reg.ToPromotionEdges([]reg.Manifest{
+		{
+			Registries: []reg.RegistryContext{srcRC, destRC},
+			Images: []reg.Image{
+				{
+					ImageName: "a",
+					Dmap: reg.DigestTags{
+						"sha256:0000000000000000000000000000000000000000000000000000000000000000": {"1.0"},
+					},
+				},
+			},
+			SrcRegistry: &srcRC,
+		},
+	})
+	require.Nil(t, err)
+
+	var tests = []struct {
+		name          string
+		noProxy       string
+		registryTLS   map[string]*reg.RegistryTLS
+		expectedHosts map[string]bool
+	}{
+		{
+			"Proxy",
+			"",
+			nil,
+			map[string]bool{"registry.invalid:443": true},
+		},
+		{
+			"Proxy for a registry with TLS settings",
+			"",
+			map[string]*reg.RegistryTLS{"registry.invalid": rt},
+			map[string]bool{"registry.invalid:443": true},
+		},
+		{
+			"Registry excluded from the proxy",
+			"registry.invalid",
+			nil,
+			map[string]bool{},
+		},
+	}
+
+	for _, test := range tests {
+		var mutex sync.Mutex
+		hosts := make(map[string]bool)
+		proxy := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodConnect {
+					mutex.Lock()
+					hosts[r.Host] = true
+					mutex.Unlock()
+				}
+				http.Error(w, "no", http.StatusBadGateway)
+			}))
+
+		sc := reg.SyncContext{
+			Inv: reg.MasterInventory{},
+			Proxy: stream.Proxy{
+				HTTPSProxy: proxy.URL,
+				NoProxy:    test.noProxy,
+			},
+			RegistryTLS: test.registryTLS,
+		}
+
+		require.NotNil(t, sc.Promote(edges, nil, nil), test.name)
+		proxy.Close()
+
+		require.Equal(t, test.expectedHosts, hosts, test.name)
+	}
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/sirupsen/logrus"
+
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/gcloud"
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/stream"
+)
+
+// token returns the access token of the registry (or repository) with the
+// given token key (see GetTokenKeyDomainRepoPath()).
+func (sc *SyncContext) token(key RootRepo) (gcloud.Token, bool) {
+	if sc.tokensLock != nil {
+		sc.tokensLock.RLock()
+		defer sc.tokensLock.RUnlock()
+	}
+
+	token, ok := sc.Tokens[key]
+	return token, ok
+}
+
+// refreshToken replaces the rejected access token of the registry with a new
+// one. If the token was already replaced (e.g., by another request that was
+// rejected at the same time), the new token is kept as it is.
+func (sc *SyncContext) refreshToken(
+	rc RegistryContext,
+	rejected gcloud.Token,
+) error {
+	if sc.tokensLock != nil {
+		sc.tokensLock.Lock()
+		defer sc.tokensLock.Unlock()
+	}
+
+	tokenKey, _, _ := GetTokenKeyDomainRepoPath(rc.Name)
+	if current, ok := sc.Tokens[RootRepo(tokenKey)]; ok && current != rejected {
+		return nil
+	}
+
+	var token gcloud.Token
+	var err error
+	if sc.RefreshToken != nil {
+		token, err = sc.RefreshToken(rc)
+	} else {
+		token, err = gcloud.GetServiceAccountToken(
+			rc.ServiceAccount,
+			sc.UseServiceAccount)
+	}
+	if err != nil {
+		return fmt.Errorf(
+			"refreshing the access token for %s: %w",
+			tokenKey,
+			err)
+	}
+
+	if sc.Tokens == nil {
+		sc.Tokens = make(map[RootRepo]gcloud.Token)
+	}
+	sc.Tokens[RootRepo(tokenKey)] = token
+	logrus.Infof("refreshed the access token for %s", tokenKey)
+
+	return nil
+}
+
+// withTokenRefresh calls fn, which accesses the registries with their
+// service account tokens. If a registry rejects its token (e.g., because it
+// expired during a long run), the tokens of the registries are refreshed and
+// fn is called once more.
+func (sc *SyncContext) withTokenRefresh(
+	rcs []RegistryContext,
+	fn func() error,
+) error {
+	used := make([]gcloud.Token, len(rcs))
+	for i, rc := range rcs {
+		tokenKey, _, _ := GetTokenKeyDomainRepoPath(rc.Name)
+		used[i], _ = sc.token(RootRepo(tokenKey))
+	}
+
+	err := fn()
+	if err == nil || !sc.UseServiceAccount || !tokenRejected(err) {
+		return err
+	}
+
+	logrus.Warnf("access token rejected (%v); refreshing it", err)
+	for i, rc := range rcs {
+		if refreshErr := sc.refreshToken(rc, used[i]); refreshErr != nil {
+			return fmt.Errorf("%w; %v", err, refreshErr)
+		}
+	}
+
+	return fn()
+}
+
+// tokenRejected is whether a registry rejected the credentials of a request
+// (with "401 Unauthorized").
+func tokenRejected(err error) bool {
+	var statusErr *stream.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusUnauthorized
+	}
+
+	var transportErr *transport.Error
+	if errors.As(err, &transportErr) {
+		return transportErr.StatusCode == http.StatusUnauthorized
+	}
+
+	return false
+}
+
+// promotionRegistries returns the registries that the promotion request
+// reads from and writes to, with their service accounts.
+func (sc *SyncContext) promotionRegistries(
+	rpr *PromotionRequest,
+) []RegistryContext {
+	src := RegistryContext{Name: rpr.RegistrySrc, Src: true}
+	for _, rc := range sc.RegistryContexts {
+		if rc.Name == rpr.RegistrySrc {
+			src = rc
+			break
+		}
+	}
+
+	return []RegistryContext{
+		src,
+		{Name: rpr.RegistryDest, ServiceAccount: rpr.ServiceAccount},
+	}
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/gcloud"
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/stream"
+)
+
+func TestReadRegistriesRefreshToken(t *testing.T) {
+	// The registry only accepts the "fresh" token.
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			if r.Header.Get("Authorization") != "Bearer fresh" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			_, err := w.Write([]byte(`{
+  "child": [],
+  "manifest": {
+    "sha256:000": {
+      "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+      "tag": ["1.0"]
+    }
+  },
+  "name": "foo",
+  "tags": ["1.0"]
+}`))
+			require.Nil(t, err)
+		}))
+	defer server.Close()
+
+	rc := reg.RegistryContext{
+		Name:           "gcr.io/foo",
+		ServiceAccount: "robot@example.com",
+		Src:            true,
+	}
+
+	// The request carries the token that the registry has at the time.
+	mkProducer := func(
+		sc *reg.SyncContext,
+		rc reg.RegistryContext,
+	) stream.Producer {
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		require.Nil(t, err)
+		req.Header.Set("Authorization", "Bearer "+string(sc.Tokens["gcr.io/foo"]))
+		return &stream.HTTP{Req: req}
+	}
+
+	tests := []struct {
+		name             string
+		refreshed        gcloud.Token
+		refreshErr       error
+		expectedRequests int32
+		expectedToken    gcloud.Token
+		expectedErrors   int
+	}{
+		{
+			"Expired token is refreshed",
+			"fresh",
+			nil,
+			2,
+			"fresh",
+			0,
+		},
+		{
+			"Refreshed token is rejected as well",
+			"also-stale",
+			nil,
+			2,
+			"also-stale",
+			1,
+		},
+		{
+			"Token cannot be refreshed",
+			"",
+			errors.New("gcloud is gone"),
+			1,
+			"stale",
+			1,
+		},
+	}
+
+	for _, test := range tests {
+		atomic.StoreInt32(&requests, 0)
+
+		sc, err := reg.MakeSyncContext(
+			[]reg.Manifest{{Registries: []reg.RegistryContext{rc}}},
+			1,
+			false,
+			false)
+		require.Nil(t, err, test.name)
+		sc.UseServiceAccount = true
+		sc.Tokens["gcr.io/foo"] = "stale"
+
+		refreshes := 0
+		sc.RefreshToken = func(
+			got reg.RegistryContext,
+		) (gcloud.Token, error) {
+			require.Equal(t, rc, got, test.name)
+			refreshes++
+			return test.refreshed, test.refreshErr
+		}
+
+		sc.ReadRegistries([]reg.RegistryContext{rc}, false, mkProducer)
+
+		require.Equal(t, 1, refreshes, test.name)
+		require.Equal(t, test.expectedRequests, atomic.LoadInt32(&requests), test.name)
+		require.Equal(t, test.expectedToken, sc.Tokens["gcr.io/foo"], test.name)
+		require.Len(t, sc.Logs.Errors, test.expectedErrors, test.name)
+		if test.expectedErrors == 0 {
+			// The images of the root of a registry belong to its parent.
+			require.NotEmpty(t, sc.Inv["gcr.io"]["foo"], test.name)
+		}
+	}
+}
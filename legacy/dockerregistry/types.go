@@ -17,7 +17,11 @@ limitations under the License.
 package inventory
 
 import (
+	"context"
+	"crypto"
+	"regexp"
 	"sync"
+	"time"
 
 	cr "github.com/google/go-containerregistry/pkg/v1/types"
 	grafeaspb "google.golang.org/genproto/googleapis/grafeas/v1"
@@ -58,6 +62,23 @@ type ImageVulnError struct {
 	Digest         Digest
 	OccurrenceName string
 	Vulnerability  *grafeaspb.VulnerabilityOccurrence
+	// The fields below summarize the occurrence for the report (see
+	// MkImageVulnError()).
+
+	// NoteName names the vulnerability, e.g.
+	// "projects/goog-vulnz/notes/CVE-2021-3711".
+	NoteName string
+	// Severity is the severity that is compared to the threshold, e.g.
+	// "HIGH"; EffectiveSeverity is the one that the distribution of the
+	// affected packages gives it (if any).
+	Severity          string
+	EffectiveSeverity string `json:",omitempty"`
+	// CVSSScore is 0 if the score is unknown.
+	CVSSScore    float32
+	FixAvailable bool
+	// FixedPackages lists the packages that fix the vulnerability, as
+	// "<package> <version>".
+	FixedPackages []string `json:",omitempty"`
 }
 
 // ImageVulnProducer is used by ImageVulnCheck to get the vulnerabilities for
@@ -66,13 +87,34 @@ type ImageVulnProducer func(
 	edge PromotionEdge,
 ) ([]*grafeaspb.Occurrence, error)
 
+// ScanStatusProducer is used by ImageVulnCheck to get the status of the
+// vulnerability scan of an image (see ImageVulnCheck.WaitTimeout) and allows
+// for custom scan status producers for testing.
+type ScanStatusProducer func(
+	edge PromotionEdge,
+) (grafeaspb.DiscoveryOccurrence_AnalysisStatus, error)
+
 // CapturedRequests holds a map of all PromotionRequests that were generated. It
 // is used for both -dry-run and testing.
 type CapturedRequests map[PromotionRequest]int
 
 // CollectedLogs holds all the Errors that are generated as the promoter runs.
+// Warnings are problems that were found, but which did not fail the run.
 type CollectedLogs struct {
-	Errors Errors
+	Errors   Errors
+	Warnings Errors
+	// Promotions counts how the promotion requests went.
+	Promotions RequestSummary
+	// Skipped holds the edges that the last GetPromotionCandidates() left
+	// out, by reason (only if SyncContext.LogSkippedEdges is set).
+	Skipped SkippedEdges `json:",omitempty"`
+}
+
+// RequestSummary counts the outcomes of the requests run by ExecRequests().
+type RequestSummary struct {
+	Succeeded int
+	Failed    int
+	Cancelled int
 }
 
 // SyncContext is the main data structure for performing the promotion.
@@ -87,8 +129,136 @@ type SyncContext struct {
 	Tokens            map[RootRepo]gcloud.Token
 	DigestMediaType   DigestMediaType
 	DigestImageSize   DigestImageSize
+	DigestUploadTime  DigestUploadTime
 	ParentDigest      ParentDigest
 	Logs              CollectedLogs
+	ReadCheckpoint    *ReadCheckpoint
+	Proxy             stream.Proxy
+	// SkipExistingQuietly suppresses the per-edge logging of edges that were
+	// already promoted; only a summary count is logged instead.
+	SkipExistingQuietly bool
+	// CopyTool is the external CLI used to copy images during promotion. If
+	// nil, images are copied in-process.
+	CopyTool CopyTool
+	// RegistryMirrors maps registry hosts (e.g., "gcr.io") to the host of a
+	// mirror (pull-through cache). Reads of source registries on those hosts,
+	// including the image pulls during promotion, go through the mirror.
+	// Registry names are not rewritten, so manifest matching is unaffected,
+	// and writes to destination registries never go through a mirror.
+	RegistryMirrors map[string]string
+	// RegistryTLS maps registry hosts (e.g., "registry.example.com:5000") to
+	// the TLS settings of the connections to them, for registries that
+	// require client certificates or have a certificate signed by a private
+	// CA. Mirrors are looked up by their own host.
+	RegistryTLS map[string]*RegistryTLS
+	// PromotionLock, if set, is acquired for every destination registry before
+	// Promote() writes to it, and released afterwards. It is not used in dry
+	// runs.
+	PromotionLock *PromotionLock
+	// EventPublisher, if set, is used to publish an event for every image
+	// that Promote() promoted successfully. It is not used in dry runs.
+	EventPublisher EventPublisher
+	// FailOnPublishError makes promotions whose event could not be published
+	// fail; otherwise, publishing failures are only logged.
+	FailOnPublishError bool
+	// ProvenanceGenerator, if set, records the provenance of every image that
+	// Promote() promoted successfully. Promotions whose provenance could not
+	// be recorded fail. It is not used in dry runs.
+	ProvenanceGenerator ProvenanceGenerator
+	// SmokeVerify, if set, pulls the manifest of every image that Promote()
+	// promoted successfully back from the destination (see SmokePull()).
+	// Promotions whose image cannot be pulled, or is not the promoted digest,
+	// fail. It is not used in dry runs.
+	SmokeVerify bool
+	// MetricsRecorder, if set, records the outcome and latency of every
+	// promotion request that Promote() runs, and exports them once Promote()
+	// is done. It is not used in dry runs.
+	MetricsRecorder MetricsRecorder
+	// UserAgent is sent as the User-Agent header of every registry HTTP
+	// request. If empty, the default one (with the cip version) is used.
+	UserAgent string
+	// BandwidthLimiter, if set, caps the combined bandwidth of all images
+	// copied by the promoter itself (i.e., without a CopyTool).
+	BandwidthLimiter *BandwidthLimiter
+	// ForceOverwrite holds the destination tags that may be moved to another
+	// digest, as "<image>:<tag>" (in every destination registry) or
+	// "<registry>/<image>:<tag>". All other tag moves are rejected.
+	ForceOverwrite map[string]bool
+	// DestPathPolicy, if set, must match the whole name of the destination
+	// image of every edge, as "<registry>/<image>" (see MkDestPathPolicy()).
+	// Edges that would write any other image are rejected by
+	// GetPromotionCandidates().
+	DestPathPolicy *regexp.Regexp
+	// Clock tells the time for the promotion windows (see Image.Window), the
+	// image ages and the provenance, and waits out BatchPause. RealClock is
+	// used if it is not set.
+	Clock Clock
+	// FailOutsideWindow makes edges outside of the promotion window of their
+	// image errors; otherwise, they are skipped.
+	FailOutsideWindow bool
+	// LogSkippedEdges makes GetPromotionCandidates() record the edges that it
+	// leaves out, with the reason, in Logs.Skipped.
+	LogSkippedEdges bool
+	// CommandRecorder, if set, records the commands that the promotion
+	// requests of a dry run would run (see Promote()).
+	CommandRecorder *CommandRecorder
+	// MkReadRepositoryCmd makes the reads of the registries that
+	// FilterPromotionEdges() needs. If nil, MkReadRepositoryCmdReal() is used.
+	MkReadRepositoryCmd func(*SyncContext, RegistryContext) stream.Producer
+	// BatchSize, if positive, makes Promote() dispatch its requests in batches
+	// of this many, each one after the previous batch is done.
+	BatchSize int
+	// BatchPause is how long Promote() waits between batches (see BatchSize),
+	// e.g. to give downstream systems time to react.
+	BatchPause time.Duration
+	// GroupThreads caps the number of concurrent promotion requests per
+	// image group (see Manifest.GroupThreads). MakeSyncContext() takes the
+	// lowest cap of each group across the manifests.
+	GroupThreads map[string]int
+	// RefreshToken gets a new access token for a registry whose token was
+	// rejected (with "401 Unauthorized"), e.g. because it expired during a
+	// long run. If nil, the token is taken from gcloud again (as in
+	// PopulateTokens()).
+	RefreshToken func(RegistryContext) (gcloud.Token, error)
+	// tokensLock guards Tokens, which may be refreshed while requests run.
+	tokensLock *sync.RWMutex
+	// imageGroups maps each "<source registry>/<image>" of the manifests to
+	// the group of the image.
+	imageGroups map[RegistryImagePath]string
+	// RetryPolicy decides which failed registry reads and writes are retried
+	// (by default, those that failed with the stream.DefaultRetryStatusCodes).
+	RetryPolicy stream.RetryPolicy
+	// WriteRetryPolicy decides how many times the in-process writes of
+	// Promote() are retried (see RetryWrite()). The zero value retries
+	// nothing.
+	WriteRetryPolicy WriteRetryPolicy
+	// Context bounds the requests run by ExecRequests(). Once it is done,
+	// requests that are in flight are cancelled, and the remaining ones are
+	// skipped. If nil, requests are not bounded.
+	Context context.Context
+
+	// layerMounts records the layers written by Promote(), so that later
+	// copies into the same registry can mount them.
+	layerMounts *layerMounts
+}
+
+// ReadCheckpoint records the progress of ReadRegistries(), so that an
+// interrupted read of a (large) registry can be resumed later without
+// re-reading the repositories that were already read.
+type ReadCheckpoint struct {
+	// Path is the file that the checkpoint is persisted to.
+	Path string `json:"-"`
+	// Repos holds every repository that was read completely, along with the
+	// names of its child repositories (which may or may not have been read
+	// yet).
+	Repos map[RegistryName][]string `json:"repos"`
+
+	// The partial inventory that was read so far.
+	Inv             MasterInventory `json:"inventory"`
+	DigestMediaType DigestMediaType `json:"mediaTypes"`
+	DigestImageSize DigestImageSize `json:"imageSizes"`
+	// DigestUploadTime is omitted from checkpoints written by older versions.
+	DigestUploadTime DigestUploadTime `json:"uploadTimes,omitempty"`
 }
 
 // PreCheck represents a check function to run against a pull request that
@@ -100,6 +270,136 @@ type PreCheck interface {
 	Run() error
 }
 
+// CopyTool renders the command that copies an image to a destination registry
+// with a particular CLI tool.
+//
+// Name returns the name of the tool, as accepted by MkCopyTool(). CopyCmd
+// returns the command that copies srcImage to dstImage (both fully qualified
+// image references); dest is the registry context of dstImage. TagCmd returns
+// the command that points tag at the given digest of an image that is already
+// in dest.
+type CopyTool interface {
+	Name() string
+	CopyCmd(
+		dest RegistryContext,
+		useServiceAccount bool,
+		srcImage string,
+		dstImage string,
+	) []string
+	TagCmd(
+		dest RegistryContext,
+		useServiceAccount bool,
+		imageName ImageName,
+		digest Digest,
+		tag Tag,
+	) []string
+}
+
+// PreCheckFactory creates a named PreCheck (see RegisterPreCheck()) for the
+// given promotion edges. It is called after the edges have been filtered
+// against the registries, so the SyncContext holds their current state.
+type PreCheckFactory func(
+	sc *SyncContext,
+	edges map[PromotionEdge]interface{},
+	opts PreCheckOptions,
+) (PreCheck, error)
+
+// PreCheckOptions holds the settings that PreCheckFactories may use to
+// configure their checks.
+type PreCheckOptions struct {
+	MaxImageSize      int
+	SeverityThreshold int
+	VulnMode          VulnMode
+	// VulnThreads is the number of images that the vulnerability check scans
+	// concurrently; if it is not positive, the SyncContext's Threads are used.
+	VulnThreads int
+	// VulnWaitTimeout, if positive, is how long the vulnerability check waits
+	// for the scan of each image to finish (see ImageVulnCheck.WaitTimeout).
+	VulnWaitTimeout time.Duration
+	// MinSignatures is the minimum number of valid signatures required of
+	// every image (on top of the per-image minimum in the manifests).
+	MinSignatures int
+	// SignaturePublicKeys are the keys that signatures are verified with.
+	SignaturePublicKeys []crypto.PublicKey
+	// MaxImageAge is the maximum age of the images to promote, as given by the
+	// creation time in their image configs.
+	MaxImageAge  time.Duration
+	ImageAgeMode ImageAgeMode
+	// DigestAllowlist holds the only digests that may be promoted.
+	DigestAllowlist []Digest
+}
+
+// ImageAgeCheck implements the PreCheck interface and checks against images
+// that were created more than MaxAge ago (stale images).
+type ImageAgeCheck struct {
+	// SyncContext also tells the time that the image ages are measured at
+	// (see SyncContext.Clock).
+	SyncContext              SyncContext
+	PullEdges                map[PromotionEdge]interface{}
+	MaxAge                   time.Duration
+	FakeCreationTimeProducer CreationTimeProducer
+	// Mode decides whether stale images fail the check (ImageAgeModeEnforce),
+	// or are only reported (ImageAgeModeWarn).
+	Mode ImageAgeMode
+	// Findings holds the stale images that were found during Run().
+	Findings Errors
+}
+
+// CreationTimeProducer is used by ImageAgeCheck to get the creation time of
+// the source image of an edge, and allows for custom producers for testing. A
+// zero time means that the image has no (meaningful) creation time.
+type CreationTimeProducer func(edge PromotionEdge) (time.Time, error)
+
+// ImageSignatureCheck implements the PreCheck interface and checks that the
+// images to be promoted have enough valid (cosign) signatures.
+type ImageSignatureCheck struct {
+	SyncContext SyncContext
+	PullEdges   map[PromotionEdge]interface{}
+	// MinSignatures applies to all images; an image may require more with
+	// its own MinSignatures.
+	MinSignatures         int
+	PublicKeys            []crypto.PublicKey
+	FakeSignatureProducer SignatureProducer
+}
+
+// ImageSourceExistsCheck implements the PreCheck interface and checks that the
+// source images to be promoted still exist in their source registries.
+type ImageSourceExistsCheck struct {
+	SyncContext              SyncContext
+	PullEdges                map[PromotionEdge]interface{}
+	FakeSourceExistsProducer SourceExistsProducer
+}
+
+// SourceExistsProducer is used by ImageSourceExistsCheck to find out whether
+// the source image of an edge exists, and allows for custom producers for
+// testing.
+type SourceExistsProducer func(edge PromotionEdge) (bool, error)
+
+// DigestAllowlistCheck implements the PreCheck interface and checks against
+// images whose digests are not on an allowlist.
+type DigestAllowlistCheck struct {
+	PullEdges map[PromotionEdge]interface{}
+	Allowlist map[Digest]bool
+}
+
+// CosignSignature is a signature of an image, as stored by cosign: Signature
+// is the (decoded) signature of Payload, which is a "simple signing" JSON
+// document that names the digest of the signed image.
+type CosignSignature struct {
+	Payload   []byte
+	Signature []byte
+}
+
+// SignatureProducer is used by ImageSignatureCheck to get the signatures of
+// the source image of an edge, and allows for custom signature producers for
+// testing.
+type SignatureProducer func(edge PromotionEdge) ([]CosignSignature, error)
+
+// ImageLabelsProducer is used by FilterCleanEdges() to get the labels and
+// annotations of the source image of an edge, and allows for custom label
+// producers for testing.
+type ImageLabelsProducer func(edge PromotionEdge) (map[string]string, error)
+
 // ImageVulnCheck implements the PreCheck interface and checks against
 // images that have known vulnerabilities.
 type ImageVulnCheck struct {
@@ -107,8 +407,49 @@ type ImageVulnCheck struct {
 	PullEdges         map[PromotionEdge]interface{}
 	SeverityThreshold int
 	FakeVulnProducer  ImageVulnProducer
+	Mode              VulnMode
+	// Threads is the number of images that are scanned concurrently. If it is
+	// not positive, the Threads of the SyncContext are used.
+	Threads int
+	// Findings holds all fixable vulnerabilities at or above the
+	// SeverityThreshold that were found during Run().
+	Findings Errors
+	// WaitTimeout, if positive, is how long Run() waits for the
+	// vulnerability scan of each image to finish before reading its
+	// vulnerabilities, so that images that were pushed just now are not
+	// passed on incomplete data. Images whose scan is not done by then (or
+	// failed) fail the check, in either Mode.
+	WaitTimeout time.Duration
+	// PollInterval is how often the scan status is read while waiting. If
+	// zero, DefaultVulnScanPollInterval is used.
+	PollInterval           time.Duration
+	FakeScanStatusProducer ScanStatusProducer
 }
 
+// VulnMode is an enum that describes what ImageVulnCheck should do when it
+// finds vulnerabilities at or above its severity threshold.
+type VulnMode int
+
+const (
+	// VulnModeEnforce fails the check if any such vulnerabilities are found.
+	VulnModeEnforce VulnMode = iota
+	// VulnModeWarn only logs and records such vulnerabilities; the check
+	// itself does not fail because of them.
+	VulnModeWarn
+)
+
+// ImageAgeMode is an enum that describes what ImageAgeCheck should do when it
+// finds images that are older than its maximum age.
+type ImageAgeMode int
+
+const (
+	// ImageAgeModeEnforce fails the check if any such images are found.
+	ImageAgeModeEnforce ImageAgeMode = iota
+	// ImageAgeModeWarn only logs and records such images; the check itself
+	// does not fail because of them.
+	ImageAgeModeWarn
+)
+
 // ImageSizeCheck implements the PreCheck interface and checks against
 // images that are larger than a size threshold (controlled by the
 // max-image-size flag).
@@ -137,6 +478,23 @@ type PromotionEdge struct {
 
 	DstRegistry RegistryContext
 	DstImageTag ImageTag
+
+	// MaxTags is the maximum number of tags allowed for the destination image
+	// (0 means no cap).
+	MaxTags int
+
+	// MinSignatures is the minimum number of valid signatures the source
+	// image must have to be promoted (0 means none are required).
+	MinSignatures int
+
+	// Window is the promotion window of the image (empty if it may be
+	// promoted at any time).
+	Window string
+
+	// OldDigest is the digest that the destination tag is moved away from, if
+	// GetPromotionCandidates() decided to force the overwrite (see
+	// SyncContext.ForceOverwrite). It is empty for all other edges.
+	OldDigest Digest
 }
 
 // VertexProperty describes the properties of an Edge, with respect to the state
@@ -168,6 +526,18 @@ type MasterInventory map[RegistryName]RegInvImage
 // the image name is the string after the last slash (in this case, "baz").
 type RegInvImage map[ImageName]DigestTags
 
+// RegInvStats holds summary counts of a RegInvImage.
+type RegInvStats struct {
+	// Images is the number of images.
+	Images int
+	// Digests is the number of digests, summed over all images.
+	Digests int
+	// Tags is the number of tags, summed over all images.
+	Tags int
+	// TaglessDigests is the number of digests that have no tags.
+	TaglessDigests int
+}
+
 // Registry is another way to look at a Docker Registry; it is used during
 // Promotion.
 type Registry struct {
@@ -206,8 +576,8 @@ type ImageDigest struct {
 }
 
 // TagOp is an enum that describes the various types of tag-modifying
-// operations. These actions are a bit more low-level, and currently support 3
-// operations: adding, moving, and deleting.
+// operations. These actions are a bit more low-level, and currently support 4
+// operations: adding, moving, deleting, and retagging.
 type TagOp int
 
 const (
@@ -222,6 +592,10 @@ const (
 	// Delete represents those tags that are not in the manifest and should thus
 	// be removed and deleted. This is a kind of "demotion".
 	Delete = iota
+	// Retag represents those tags that are added to a digest that already
+	// exists in the destination. Only the tag is written; the image itself is
+	// not copied again.
+	Retag = iota
 )
 
 const (
@@ -235,6 +609,16 @@ const (
 	// . This is a result of some path handling/parsing logic in
 	// ValidateThinManifestDirectoryStructure().
 	ThinManifestDepth = 4
+
+	// ThinManifestDefaultsFile is the name of the optional file, placed at the
+	// root of the folder given to -thin-manifest-dir, which holds the
+	// ThinManifestDefaults shared by every thin manifest in that folder.
+	ThinManifestDefaultsFile = "defaults.yaml"
+
+	// ThinManifestDefaultFilename is the name that the thin manifests within
+	// the folder given to -thin-manifest-dir have, unless
+	// ThinManifestOptions.Filename says otherwise.
+	ThinManifestDefaultFilename = "promoter-manifest.yaml"
 )
 
 // PromotionRequest contains all the information required for any type of
@@ -257,14 +641,34 @@ type Manifest struct {
 	// Registries contains the source and destination (Src/Dest) registry names.
 	// There must be at least 2 registries: 1 source registry and 1 or more
 	// destination registries.
-	Registries []RegistryContext `yaml:"registries,omitempty"`
-	Images     []Image           `yaml:"images,omitempty"`
+	Registries []RegistryContext `yaml:"registries,omitempty" json:"registries,omitempty"`
+	Images     []Image           `yaml:"images,omitempty" json:"images,omitempty"`
+	// StrictDigests rejects any image entry that does not pin a digest (see
+	// ValidateStrictDigests), instead of failing on the first invalid digest.
+	StrictDigests bool `yaml:"strictDigests,omitempty" json:"strictDigests,omitempty"`
+	// GroupThreads caps the number of concurrent promotion requests for the
+	// images of each group (see Image.Group), e.g. to copy large images with
+	// fewer threads. Groups without an entry are only limited by the number
+	// of threads of the run.
+	GroupThreads map[string]int `yaml:"groupThreads,omitempty" json:"groupThreads,omitempty"`
 
 	// Hidden fields; these are data structure optimizations that are populated
 	// from the fields above. As they are redundant, there is no point in
-	// storing this information in YAML.
-	SrcRegistry *RegistryContext
-	Filepath    string
+	// storing this information in YAML (or JSON).
+	SrcRegistry *RegistryContext `json:"-"`
+	Filepath    string           `json:"-"`
+}
+
+// ManifestError is an error found in a specific manifest (or images) file.
+// It records the location of the error so that it can be reported next to the
+// offending file (e.g., as a GitHub Actions annotation). The error message is
+// that of the underlying error.
+type ManifestError struct {
+	Filepath string
+	// Line is the 1-based line of the error, or 0 if it is not known (e.g.,
+	// for semantic errors found after parsing the YAML).
+	Line int
+	Err  error
 }
 
 // ThinManifest is a more secure Manifest because it does not define the
@@ -307,16 +711,81 @@ type ThinManifest struct {
 	// separately.
 
 	ImagesPath string `yaml:"imagesPath,omitempty"`
+	// StrictDigests rejects the manifest if any entry of its images file
+	// does not pin a digest (see ValidateStrictDigests).
+	StrictDigests bool `yaml:"strictDigests,omitempty"`
+	// GroupThreads is Manifest.GroupThreads for the images of the manifest.
+	GroupThreads map[string]int `yaml:"groupThreads,omitempty"`
+}
+
+// ThinManifestDefaults holds the fields that every ThinManifest within a thin
+// manifest directory inherits. It is read from the ThinManifestDefaultsFile at
+// the root of the directory.
+//
+// A ThinManifest inherits every default registry whose name it does not list
+// itself. If a ThinManifest lists a registry with the same name as a default
+// registry, the ThinManifest's entry wins wholesale (its service account and
+// source flag are used, and nothing is taken from the default entry). It is
+// an error for the defaults to declare a source registry if the ThinManifest
+// also declares a (different) source registry.
+type ThinManifestDefaults struct {
+	Registries []RegistryContext `yaml:"registries,omitempty"`
+}
+
+// ThinManifestOptions changes how thin manifests are parsed.
+type ThinManifestOptions struct {
+	// ExpandEnv substitutes references to environment variables (see
+	// ExpandEnv()) in the thin manifests and their defaults file before they
+	// are parsed. The images files are never expanded.
+	ExpandEnv bool
+	// SkipInvalid skips the thin manifests that cannot be parsed instead of
+	// failing, as long as any valid ones remain. The skipped manifests are
+	// reported with an InvalidManifestsError, returned along with the valid
+	// manifests.
+	SkipInvalid bool
+	// StrictDigests requires every entry of the images files to pin a digest,
+	// as if all thin manifests set 'strictDigests'.
+	StrictDigests bool
+	// Filename is the name of the thin manifest files (within
+	// "manifests/<dir>"); other files are ignored. If empty, it is
+	// ThinManifestDefaultFilename.
+	Filename string
+}
+
+// InvalidManifestsError lists the thin manifests that were skipped because
+// they could not be parsed (see ThinManifestOptions.SkipInvalid).
+type InvalidManifestsError struct {
+	Skipped []*ManifestError
 }
 
 // Image holds information about an image. It's like an "Object" in the OOP
 // sense, and holds all the information relating to a particular image that we
 // care about.
 type Image struct {
-	ImageName ImageName  `yaml:"name"`
-	Dmap      DigestTags `yaml:"dmap,omitempty"`
+	ImageName ImageName  `yaml:"name" json:"name"`
+	Dmap      DigestTags `yaml:"dmap,omitempty" json:"dmap,omitempty"`
+	// MaxTags caps the number of tags the image may carry in each destination
+	// registry. Promotions that would exceed the cap are rejected. A value of
+	// 0 means no cap.
+	MaxTags int `yaml:"maxTags,omitempty" json:"maxTags,omitempty"`
+	// Group is the name of the set of images this image belongs to. Groups
+	// allow promoting a subset of a (large) manifest. Images without a group
+	// belong to DefaultImageGroup.
+	Group string `yaml:"group,omitempty" json:"group,omitempty"`
+	// MinSignatures is the minimum number of valid (cosign) signatures each
+	// digest of the image must have before it may be promoted. A value of 0
+	// means no signatures are required (unless '--min-signatures' says so).
+	MinSignatures int `yaml:"minSignatures,omitempty" json:"minSignatures,omitempty"`
+	// Window, if set, is the time of the week during which the image may be
+	// promoted (see PromotionWindow), e.g. "Mon-Fri 09:00-17:00
+	// America/New_York". Promotions outside of it are skipped (or fail, see
+	// SyncContext.FailOutsideWindow).
+	Window string `yaml:"window,omitempty" json:"window,omitempty"`
 }
 
+// DefaultImageGroup is the group of images that do not name a group.
+const DefaultImageGroup = "default"
+
 // Images is a slice of Image types.
 type Images []Image
 
@@ -333,10 +802,20 @@ type DigestTags map[Digest]TagSlice
 // RegistryContext holds information about a registry, to be written in a
 // manifest file.
 type RegistryContext struct {
-	Name           RegistryName `yaml:"name,omitempty"`
-	ServiceAccount string       `yaml:"service-account,omitempty"`
-	Token          gcloud.Token `yaml:"-"`
-	Src            bool         `yaml:"src,omitempty"`
+	Name           RegistryName `yaml:"name,omitempty" json:"name,omitempty"`
+	ServiceAccount string       `yaml:"service-account,omitempty" json:"service-account,omitempty"`
+	Token          gcloud.Token `yaml:"-" json:"-"`
+	Src            bool         `yaml:"src,omitempty" json:"src,omitempty"`
+	// Quarantine marks the registry that images are promoted to first. The
+	// other destination registries only receive images released from it (see
+	// ToQuarantineReleaseEdges()).
+	Quarantine bool `yaml:"quarantine,omitempty" json:"quarantine,omitempty"`
+	// RequiresApproval marks a destination registry that images may only be
+	// promoted to once an ApprovalClient approves (see FilterApprovedEdges()).
+	RequiresApproval bool `yaml:"requiresApproval,omitempty" json:"requiresApproval,omitempty"`
+	// ReadOnly marks a registry that is only ever read from. Edges that would
+	// write to it are rejected by GetPromotionCandidates().
+	ReadOnly bool `yaml:"readOnly,omitempty" json:"readOnly,omitempty"`
 }
 
 // GCRManifestListContext is used only for reading GCRManifestList information
@@ -364,6 +843,10 @@ type DigestMediaType map[Digest]cr.MediaType
 // DigestImageSize holds information about the size of an image in bytes.
 type DigestImageSize map[Digest]int
 
+// DigestUploadTime holds the time at which an image was uploaded to the
+// registry it was read from.
+type DigestUploadTime map[Digest]time.Time
+
 // ParentDigest holds a map of the digests of children to parent digests. It is
 // a reverse mapping of ManifestLists, which point to all the child manifests.
 type ParentDigest map[Digest]Digest
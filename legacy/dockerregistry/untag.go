@@ -0,0 +1,105 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/stream"
+)
+
+// ParseTaggedImage splits up a tagged image reference, such as
+// "gcr.io/foo/bar:1.0", into its registry, image name and tag (see
+// ParseContainerParts() for how the registry is told apart from the image).
+func ParseTaggedImage(s string) (RegistryName, ImageName, Tag, error) {
+	i := strings.LastIndex(s, ":")
+	if i < 0 || i < strings.LastIndex(s, "/") || strings.Contains(s, "@") {
+		return "", "", "", fmt.Errorf(
+			"expected a tagged image such as gcr.io/foo/bar:1.0, got %q",
+			s,
+		)
+	}
+
+	tag := Tag(s[i+1:])
+	if err := ValidateTag(tag); err != nil {
+		return "", "", "", err
+	}
+
+	registry, imageName, err := ParseContainerParts(s[:i])
+	if err != nil {
+		return "", "", "", err
+	}
+
+	return RegistryName(registry), ImageName(imageName), tag, nil
+}
+
+// Untag removes a single tag from an image, leaving the digest it points at
+// (and the other tags of the image) intact. The command that removes the tag
+// (see GetWriteCmd()) is run with the producer returned by mkProducer; in
+// dry-run mode, it is only printed.
+func (sc *SyncContext) Untag(
+	rc RegistryContext,
+	imageName ImageName,
+	tag Tag,
+	mkProducer func(cmd []string) stream.Producer,
+) error {
+	cmd := GetWriteCmd(
+		rc,
+		sc.UseServiceAccount,
+		"",
+		"",
+		imageName,
+		"",
+		tag,
+		Delete,
+		nil,
+	)
+
+	pqin := ToPQIN(rc.Name, imageName, tag)
+	if sc.DryRun {
+		logrus.Infof("dry run: would untag %s with: %s", pqin, strings.Join(cmd, " "))
+		return nil
+	}
+
+	producer := mkProducer(cmd)
+	_, stderr, err := producer.Produce()
+	if err != nil {
+		return fmt.Errorf("untagging %s: %w", pqin, err)
+	}
+
+	be, err := ioutil.ReadAll(stderr)
+	if err != nil {
+		return fmt.Errorf("untagging %s: reading stderr: %w", pqin, err)
+	}
+
+	if err := producer.Close(); err != nil {
+		return fmt.Errorf(
+			"untagging %s: %w: %s",
+			pqin,
+			err,
+			strings.TrimSpace(string(be)),
+		)
+	}
+
+	logrus.Infof("untagged %s", pqin)
+
+	return nil
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/stream"
+)
+
+func TestParseTaggedImage(t *testing.T) {
+	tests := []struct {
+		name              string
+		input             string
+		expectedRegistry  reg.RegistryName
+		expectedImageName reg.ImageName
+		expectedTag       reg.Tag
+		expectedErr       bool
+	}{
+		{
+			"GCR image",
+			"gcr.io/foo/bar:1.0",
+			"gcr.io/foo",
+			"bar",
+			"1.0",
+			false,
+		},
+		{
+			"Nested image",
+			"gcr.io/foo/a/b:latest",
+			"gcr.io/foo",
+			"a/b",
+			"latest",
+			false,
+		},
+		{
+			"Registry with port",
+			"localhost:5000/bar:1.0",
+			"localhost:5000",
+			"bar",
+			"1.0",
+			false,
+		},
+		{
+			"Untagged image",
+			"localhost:5000/bar",
+			"",
+			"",
+			"",
+			true,
+		},
+		{
+			"Image by digest",
+			"gcr.io/foo/bar@sha256:0000000000000000000000000000000000000000000000000000000000000000",
+			"",
+			"",
+			"",
+			true,
+		},
+		{
+			"Missing image",
+			"gcr.io/foo:1.0",
+			"",
+			"",
+			"",
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		registry, imageName, tag, err := reg.ParseTaggedImage(test.input)
+		if test.expectedErr {
+			require.NotNil(t, err, test.name)
+			continue
+		}
+
+		require.Nil(t, err, test.name)
+		require.Equal(t, test.expectedRegistry, registry, test.name)
+		require.Equal(t, test.expectedImageName, imageName, test.name)
+		require.Equal(t, test.expectedTag, tag, test.name)
+	}
+}
+
+func TestUntag(t *testing.T) {
+	rc := reg.RegistryContext{
+		Name:           "gcr.io/foo",
+		ServiceAccount: "robot",
+	}
+
+	tests := []struct {
+		name              string
+		dryRun            bool
+		useServiceAccount bool
+		expectedCmd       []string
+	}{
+		{
+			"Untag",
+			false,
+			false,
+			[]string{
+				"gcloud",
+				"--quiet",
+				"container",
+				"images",
+				"untag",
+				"gcr.io/foo/bar:bad",
+			},
+		},
+		{
+			"Untag with service account",
+			false,
+			true,
+			[]string{
+				"gcloud",
+				"--account=robot",
+				"--quiet",
+				"container",
+				"images",
+				"untag",
+				"gcr.io/foo/bar:bad",
+			},
+		},
+		{
+			"Dry run",
+			true,
+			false,
+			nil,
+		},
+	}
+
+	for _, test := range tests {
+		sc := reg.SyncContext{
+			DryRun:            test.dryRun,
+			UseServiceAccount: test.useServiceAccount,
+		}
+
+		var gotCmd []string
+		mkProducer := func(cmd []string) stream.Producer {
+			gotCmd = cmd
+			return &stream.Fake{}
+		}
+
+		err := sc.Untag(rc, "bar", "bad", mkProducer)
+		require.Nil(t, err, test.name)
+		require.Equal(t, test.expectedCmd, gotCmd, test.name)
+	}
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// weekdays maps the (lowercase) names of the days in promotion windows to
+// their time.Weekday.
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// minutesPerDay is the number of minutes in a day, which is also the end of
+// a window that lasts until midnight ("24:00").
+const minutesPerDay = 24 * 60
+
+// PromotionWindow is the time of the week during which the digests of an
+// image may be promoted (see Image.Window). It is written as
+//
+//	<days> <HH:MM>-<HH:MM> [<time zone>]
+//
+// e.g., "Mon-Fri 09:00-17:00 America/New_York". The days are a comma-separated
+// list of days ("Mon,Wed") and ranges of days ("Mon-Fri", or "Fri-Mon" across
+// the weekend). The window starts at the first time, and ends (exclusively) at
+// the second time; if that is not later than the first, the window lasts
+// until the second time of the next day. The time zone is an IANA time zone
+// name, and defaults to UTC.
+type PromotionWindow struct {
+	days     [7]bool
+	start    int
+	end      int
+	location *time.Location
+}
+
+// ParsePromotionWindow parses a PromotionWindow.
+func ParsePromotionWindow(window string) (PromotionWindow, error) {
+	var w PromotionWindow
+
+	fields := strings.Fields(window)
+	if len(fields) != 2 && len(fields) != 3 {
+		return w, fmt.Errorf(
+			"invalid promotion window %q: want '<days> <HH:MM>-<HH:MM> [<time zone>]'",
+			window)
+	}
+
+	if err := w.parseDays(fields[0]); err != nil {
+		return w, fmt.Errorf("invalid promotion window %q: %v", window, err)
+	}
+
+	times := strings.Split(fields[1], "-")
+	if len(times) != 2 {
+		return w, fmt.Errorf(
+			"invalid promotion window %q: times must be '<HH:MM>-<HH:MM>'",
+			window)
+	}
+	var err error
+	if w.start, err = parseTimeOfDay(times[0]); err != nil {
+		return w, fmt.Errorf("invalid promotion window %q: %v", window, err)
+	}
+	if w.end, err = parseTimeOfDay(times[1]); err != nil {
+		return w, fmt.Errorf("invalid promotion window %q: %v", window, err)
+	}
+	if w.start == minutesPerDay {
+		return w, fmt.Errorf(
+			"invalid promotion window %q: cannot start at 24:00",
+			window)
+	}
+
+	w.location = time.UTC
+	if len(fields) == 3 {
+		w.location, err = time.LoadLocation(fields[2])
+		if err != nil {
+			return w, fmt.Errorf("invalid promotion window %q: %v", window, err)
+		}
+	}
+
+	return w, nil
+}
+
+// parseDays sets the days of the window from a list like "Mon-Wed,Fri".
+func (w *PromotionWindow) parseDays(days string) error {
+	for _, dayRange := range strings.Split(days, ",") {
+		bounds := strings.Split(dayRange, "-")
+		if len(bounds) > 2 {
+			return fmt.Errorf("invalid range of days %q", dayRange)
+		}
+
+		first, ok := weekdays[strings.ToLower(bounds[0])]
+		if !ok {
+			return fmt.Errorf("unknown day %q", bounds[0])
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, ok = weekdays[strings.ToLower(bounds[1])]; !ok {
+				return fmt.Errorf("unknown day %q", bounds[1])
+			}
+		}
+
+		for day := first; ; day = (day + 1) % 7 {
+			w.days[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseTimeOfDay parses a time of day, given as "HH:MM" (up to "24:00"), into
+// minutes since midnight.
+func parseTimeOfDay(timeOfDay string) (int, error) {
+	if timeOfDay == "24:00" {
+		return minutesPerDay, nil
+	}
+
+	t, err := time.Parse("15:04", timeOfDay)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q: want HH:MM", timeOfDay)
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Contains returns true if t is within the window.
+func (w PromotionWindow) Contains(t time.Time) bool {
+	t = t.In(w.location)
+	minute := t.Hour()*60 + t.Minute()
+
+	if w.start < w.end {
+		return w.days[t.Weekday()] && minute >= w.start && minute < w.end
+	}
+
+	// The window lasts past midnight, into the next day.
+	if minute >= w.start {
+		return w.days[t.Weekday()]
+	}
+	if minute < w.end {
+		return w.days[(t.Weekday()+6)%7]
+	}
+
+	return false
+}
+
+// inPromotionWindow returns true if the edge may be promoted now, i.e. if its
+// image has no promotion window or the window contains the current time (see
+// SyncContext.Clock).
+func (sc *SyncContext) inPromotionWindow(edge PromotionEdge) (bool, error) {
+	if edge.Window == "" {
+		return true, nil
+	}
+
+	window, err := ParsePromotionWindow(edge.Window)
+	if err != nil {
+		return false, err
+	}
+
+	return window.Contains(sc.clock().Now()), nil
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+func TestPromotionWindowContains(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	require.Nil(t, err)
+
+	// 2021-06-14 is a Monday.
+	at := func(day, hour, minute int, loc *time.Location) time.Time {
+		return time.Date(2021, time.June, 14+day, hour, minute, 0, 0, loc)
+	}
+
+	tests := []struct {
+		window      string
+		inWindow    []time.Time
+		outOfWindow []time.Time
+	}{
+		{
+			"Mon-Fri 09:00-17:00 America/New_York",
+			[]time.Time{
+				at(0, 9, 0, newYork),
+				at(4, 16, 59, newYork),
+				// 14:00 UTC is 10:00 in New York (EDT).
+				at(2, 14, 0, time.UTC),
+			},
+			[]time.Time{
+				at(0, 8, 59, newYork),
+				at(0, 17, 0, newYork),
+				at(5, 12, 0, newYork),
+				// 22:00 UTC is 18:00 in New York.
+				at(2, 22, 0, time.UTC),
+			},
+		},
+		{
+			// Without a time zone, UTC is used.
+			"mon,WED 09:00-24:00",
+			[]time.Time{
+				at(0, 9, 0, time.UTC),
+				at(2, 23, 59, time.UTC),
+			},
+			[]time.Time{
+				at(1, 12, 0, time.UTC),
+				at(3, 0, 0, time.UTC),
+			},
+		},
+		{
+			// The window of Friday lasts until Saturday morning, and the one
+			// of Sunday until Monday morning.
+			"Fri-Sun 22:00-06:00",
+			[]time.Time{
+				at(4, 22, 0, time.UTC),
+				at(5, 5, 59, time.UTC),
+				at(7, 5, 59, time.UTC),
+			},
+			[]time.Time{
+				at(4, 21, 59, time.UTC),
+				at(4, 5, 0, time.UTC),
+				at(5, 6, 0, time.UTC),
+				at(7, 22, 0, time.UTC),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		window, err := reg.ParsePromotionWindow(test.window)
+		require.Nil(t, err, test.window)
+
+		for _, now := range test.inWindow {
+			require.True(t, window.Contains(now), "%s: %s", test.window, now)
+		}
+		for _, now := range test.outOfWindow {
+			require.False(t, window.Contains(now), "%s: %s", test.window, now)
+		}
+	}
+}
+
+func TestParsePromotionWindowErrors(t *testing.T) {
+	tests := []struct {
+		window      string
+		expectedErr string
+	}{
+		{
+			"Mon-Fri",
+			`invalid promotion window "Mon-Fri": want '<days> <HH:MM>-<HH:MM> [<time zone>]'`,
+		},
+		{
+			"Mon-Fry 09:00-17:00",
+			`invalid promotion window "Mon-Fry 09:00-17:00": unknown day "Fry"`,
+		},
+		{
+			"Mon 09:00",
+			`invalid promotion window "Mon 09:00": times must be '<HH:MM>-<HH:MM>'`,
+		},
+		{
+			"Mon 09:00-25:00",
+			`invalid promotion window "Mon 09:00-25:00": invalid time "25:00": want HH:MM`,
+		},
+		{
+			"Mon 24:00-06:00",
+			`invalid promotion window "Mon 24:00-06:00": cannot start at 24:00`,
+		},
+		{
+			"Mon 09:00-17:00 Mars/Olympus_Mons",
+			`invalid promotion window "Mon 09:00-17:00 Mars/Olympus_Mons": unknown time zone Mars/Olympus_Mons`,
+		},
+	}
+
+	for _, test := range tests {
+		_, err := reg.ParsePromotionWindow(test.window)
+		require.NotNil(t, err, test.window)
+		require.Equal(t, test.expectedErr, err.Error(), test.window)
+	}
+}
+
+func TestGetPromotionCandidatesWindow(t *testing.T) {
+	srcRC := reg.RegistryContext{
+		Name:           "gcr.io/foo",
+		ServiceAccount: "robot",
+		Src:            true,
+	}
+	destRC := reg.RegistryContext{
+		Name:           "gcr.io/bar",
+		ServiceAccount: "robot",
+	}
+
+	mkEdge := func(imageName reg.ImageName, window string) reg.PromotionEdge {
+		return reg.PromotionEdge{
+			SrcRegistry: srcRC,
+			SrcImageTag: reg.ImageTag{ImageName: imageName, Tag: "1.0"},
+			Digest:      "sha256:111",
+			DstRegistry: destRC,
+			DstImageTag: reg.ImageTag{ImageName: imageName, Tag: "1.0"},
+			Window:      window,
+		}
+	}
+
+	inv := reg.MasterInventory{
+		"gcr.io/foo": {
+			"a": {"sha256:111": {"1.0"}},
+			"b": {"sha256:111": {"1.0"}},
+		},
+	}
+	edges := map[reg.PromotionEdge]interface{}{
+		mkEdge("a", ""): nil,
+		mkEdge("b", "Mon-Fri 09:00-17:00 America/New_York"): nil,
+	}
+
+	newYork, err := time.LoadLocation("America/New_York")
+	require.Nil(t, err)
+	// A Monday.
+	inWindow := time.Date(2021, time.June, 14, 10, 0, 0, 0, newYork)
+	// A Saturday.
+	outOfWindow := time.Date(2021, time.June, 19, 10, 0, 0, 0, newYork)
+
+	tests := []struct {
+		name              string
+		now               time.Time
+		failOutsideWindow bool
+		expected          map[reg.PromotionEdge]interface{}
+		expectedClean     bool
+	}{
+		{
+			"In the window",
+			inWindow,
+			true,
+			edges,
+			true,
+		},
+		{
+			"Out of the window (skipped)",
+			outOfWindow,
+			false,
+			map[reg.PromotionEdge]interface{}{
+				mkEdge("a", ""): nil,
+			},
+			true,
+		},
+		{
+			"Out of the window (failed)",
+			outOfWindow,
+			true,
+			map[reg.PromotionEdge]interface{}{
+				mkEdge("a", ""): nil,
+			},
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		sc := reg.SyncContext{
+			Inv:               inv,
+			Clock:             reg.FakeClock{Time: test.now},
+			FailOutsideWindow: test.failOutsideWindow,
+		}
+
+		got, gotClean := sc.GetPromotionCandidates(edges)
+		require.Equal(t, test.expected, got, test.name)
+		require.Equal(t, test.expectedClean, gotClean, test.name)
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/sirupsen/logrus"
+
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/stream"
+)
+
+// DefaultTagRetries is the number of times that a failed tag write is retried
+// by default.
+const DefaultTagRetries = 5
+
+// WriteRetryPolicy decides how many times the writes of Promote() are retried,
+// depending on their TagOp. A tag write (Retag) only points a tag at a digest
+// that the destination already has, so it is idempotent and cheap to retry; a
+// copy (Add) uploads the whole image, and is not retried unless asked to.
+// Which errors are retried at all is up to SyncContext.RetryPolicy.
+type WriteRetryPolicy struct {
+	// CopyRetries is the number of times that a failed copy is retried.
+	CopyRetries int
+	// TagRetries is the number of times that a failed tag write is retried.
+	TagRetries int
+	// NewBackOff makes the backoff between the retries of a write;
+	// stream.BackoffDefault() is used if it is not set.
+	NewBackOff func() backoff.BackOff
+}
+
+// Retries returns the number of times that a failed write of the TagOp is
+// retried.
+func (p WriteRetryPolicy) Retries(op TagOp) int {
+	switch op {
+	case Add:
+		return p.CopyRetries
+	case Retag:
+		return p.TagRetries
+	default:
+		return 0
+	}
+}
+
+// RetryWrite calls fn, which writes to a registry for a request of the TagOp,
+// and retries it as often as sc.WriteRetryPolicy allows for the TagOp, as long
+// as it fails with an error that sc.RetryPolicy retries.
+func (sc *SyncContext) RetryWrite(op TagOp, fn func() error) error {
+	var b backoff.BackOff = stream.BackoffDefault()
+	if sc.WriteRetryPolicy.NewBackOff != nil {
+		b = sc.WriteRetryPolicy.NewBackOff()
+	}
+	b = backoff.WithContext(
+		backoff.WithMaxRetries(b, uint64(sc.WriteRetryPolicy.Retries(op))),
+		sc.ctx())
+
+	notify := func(err error, t time.Duration) {
+		logrus.Warnf("write failed (%v); retrying in %v", err, t)
+	}
+
+	return backoff.RetryNotify(
+		func() error {
+			err := fn()
+			if err != nil && !sc.writeRetryable(err) {
+				return backoff.Permanent(err)
+			}
+
+			return err
+		},
+		b,
+		notify)
+}
+
+// writeRetryable returns whether a write that failed with err should be
+// retried. Unlike the reads, the writes fail with the errors of
+// go-containerregistry, whose status codes are checked the same way.
+func (sc *SyncContext) writeRetryable(err error) bool {
+	var transportErr *transport.Error
+	if errors.As(err, &transportErr) && transportErr.StatusCode != 0 {
+		return sc.RetryPolicy.Retryable(
+			&stream.StatusError{StatusCode: transportErr.StatusCode})
+	}
+
+	return sc.RetryPolicy.Retryable(err)
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/stream"
+)
+
+func TestWriteRetryPolicyRetries(t *testing.T) {
+	policy := reg.WriteRetryPolicy{CopyRetries: 1, TagRetries: 5}
+	require.Equal(t, 1, policy.Retries(reg.Add))
+	require.Equal(t, 5, policy.Retries(reg.Retag))
+	require.Equal(t, 0, policy.Retries(reg.Move))
+	require.Equal(t, 0, policy.Retries(reg.Delete))
+}
+
+func TestRetryWrite(t *testing.T) {
+	unavailable := &stream.StatusError{StatusCode: http.StatusServiceUnavailable}
+
+	tests := []struct {
+		name          string
+		op            reg.TagOp
+		err           error
+		failures      int
+		expectedCalls int
+		expectedErr   error
+	}{
+		{
+			"Tag writes get the tag retries",
+			reg.Retag,
+			unavailable,
+			3,
+			4,
+			nil,
+		},
+		{
+			"Tag writes give up after the tag retries",
+			reg.Retag,
+			unavailable,
+			10,
+			6,
+			unavailable,
+		},
+		{
+			"Copies get the copy retries",
+			reg.Add,
+			unavailable,
+			3,
+			2,
+			unavailable,
+		},
+		{
+			"Errors of go-containerregistry are retried by status code",
+			reg.Retag,
+			&transport.Error{StatusCode: http.StatusBadGateway},
+			1,
+			2,
+			nil,
+		},
+		{
+			"Errors that the retry policy does not retry",
+			reg.Retag,
+			&transport.Error{StatusCode: http.StatusNotFound},
+			1,
+			1,
+			&transport.Error{StatusCode: http.StatusNotFound},
+		},
+		{
+			"Successful writes",
+			reg.Add,
+			nil,
+			0,
+			1,
+			nil,
+		},
+	}
+
+	for _, test := range tests {
+		sc := reg.SyncContext{
+			WriteRetryPolicy: reg.WriteRetryPolicy{
+				CopyRetries: 1,
+				TagRetries:  5,
+				NewBackOff: func() backoff.BackOff {
+					return &backoff.ZeroBackOff{}
+				},
+			},
+		}
+
+		calls := 0
+		err := sc.RetryWrite(test.op, func() error {
+			calls++
+			if calls <= test.failures {
+				return test.err
+			}
+			return nil
+		})
+
+		require.Equal(t, test.expectedCalls, calls, test.name)
+		if test.expectedErr == nil {
+			require.Nil(t, err, test.name)
+			continue
+		}
+		require.NotNil(t, err, test.name)
+		require.Equal(t, test.expectedErr.Error(), err.Error(), test.name)
+	}
+
+	// The zero policy retries nothing.
+	sc := reg.SyncContext{}
+	calls := 0
+	err := sc.RetryWrite(reg.Retag, func() error {
+		calls++
+		return unavailable
+	})
+	require.Equal(t, 1, calls)
+	require.Equal(t, unavailable, err)
+}
@@ -18,6 +18,7 @@ package stream
 
 import (
 	"bytes"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net/http"
@@ -30,6 +31,11 @@ import (
 type HTTP struct {
 	Req *http.Request
 	Res *http.Response
+	// Proxy is used for the request.
+	Proxy Proxy
+	// TLSConfig, if set, is used for the connection to the server, e.g. to
+	// present a client certificate.
+	TLSConfig *tls.Config
 }
 
 const (
@@ -42,6 +48,12 @@ func (h *HTTP) Produce() (stdOut, stdErr io.Reader, err error) {
 	client := http.Client{
 		Timeout: time.Second * requestTimeoutSeconds,
 	}
+	if !h.Proxy.IsZero() || h.TLSConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = h.Proxy.ProxyFunc()
+		transport.TLSClientConfig = h.TLSConfig
+		client.Transport = transport
+	}
 
 	// We close the response body in Close().
 	// nolint[bodyclose]
@@ -61,16 +73,34 @@ func (h *HTTP) Produce() (stdOut, stdErr io.Reader, err error) {
 	_, err = buf.ReadFrom(h.Res.Body)
 	if err != nil {
 		logrus.Errorf("could not read from HTTP response body")
-		return nil, nil, fmt.Errorf(
+		return nil, nil, &StatusError{StatusCode: h.Res.StatusCode}
+	}
+
+	return nil, nil, &StatusError{
+		StatusCode: h.Res.StatusCode,
+		Body:       buf.String(),
+	}
+}
+
+// StatusError is returned by HTTP.Produce() for responses other than "200 OK".
+type StatusError struct {
+	StatusCode int
+	// Body is the body of the response, if it could be read.
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf(
 			"problems encountered: unexpected response code %d",
-			h.Res.StatusCode,
+			e.StatusCode,
 		)
 	}
 
-	return nil, nil, fmt.Errorf(
+	return fmt.Sprintf(
 		"problems encountered: unexpected response code %d; body: %s",
-		h.Res.StatusCode,
-		buf.String(),
+		e.StatusCode,
+		e.Body,
 	)
 }
 
@@ -0,0 +1,163 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stream
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Proxy is the HTTP proxy configuration used for all communication with
+// registries, both by subprocesses (gcloud, crane) and by HTTP requests made
+// directly by the promoter.
+//
+// Explicitly configured values take precedence over the HTTPS_PROXY and
+// NO_PROXY environment variables. Values that are left empty fall back to
+// those environment variables.
+type Proxy struct {
+	HTTPSProxy string
+	NoProxy    string
+}
+
+// The environment variables (in order of precedence) that are consulted for
+// each setting, when it is not explicitly configured.
+var (
+	httpsProxyEnvVars = []string{"HTTPS_PROXY", "https_proxy"}
+	noProxyEnvVars    = []string{"NO_PROXY", "no_proxy"}
+)
+
+// IsZero is true if no proxy was configured explicitly.
+func (p Proxy) IsZero() bool {
+	return p.HTTPSProxy == "" && p.NoProxy == ""
+}
+
+// httpsProxy returns the effective HTTPS proxy.
+func (p Proxy) httpsProxy() string {
+	if p.HTTPSProxy != "" {
+		return p.HTTPSProxy
+	}
+
+	return getenvAny(httpsProxyEnvVars)
+}
+
+// noProxy returns the effective list of hosts to exclude from proxying.
+func (p Proxy) noProxy() string {
+	if p.NoProxy != "" {
+		return p.NoProxy
+	}
+
+	return getenvAny(noProxyEnvVars)
+}
+
+// Env returns the given environment (a list of "key=value" strings, as
+// returned by os.Environ()) with the explicitly configured proxy settings
+// injected into it, overriding any existing values.
+func (p Proxy) Env(environ []string) []string {
+	overrides := make(map[string]string)
+	if p.HTTPSProxy != "" {
+		for _, key := range httpsProxyEnvVars {
+			overrides[key] = p.HTTPSProxy
+		}
+	}
+	if p.NoProxy != "" {
+		for _, key := range noProxyEnvVars {
+			overrides[key] = p.NoProxy
+		}
+	}
+
+	env := make([]string, 0, len(environ)+len(overrides))
+	for _, kv := range environ {
+		key := strings.SplitN(kv, "=", 2)[0]
+		if _, ok := overrides[key]; ok {
+			continue
+		}
+		env = append(env, kv)
+	}
+
+	// Append in a deterministic order.
+	for _, keys := range [][]string{httpsProxyEnvVars, noProxyEnvVars} {
+		for _, key := range keys {
+			if val, ok := overrides[key]; ok {
+				env = append(env, key+"="+val)
+			}
+		}
+	}
+
+	return env
+}
+
+// ProxyFunc returns a function suitable for http.Transport's Proxy field. If no
+// proxy was configured explicitly, it defers to http.ProxyFromEnvironment.
+func (p Proxy) ProxyFunc() func(*http.Request) (*url.URL, error) {
+	if p.IsZero() {
+		return http.ProxyFromEnvironment
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		httpsProxy := p.httpsProxy()
+		if httpsProxy == "" || req.URL.Scheme != "https" {
+			return nil, nil
+		}
+
+		if p.excludes(req.URL.Hostname()) {
+			return nil, nil
+		}
+
+		return url.Parse(httpsProxy)
+	}
+}
+
+// excludes is true if the host matches any entry in the effective no-proxy
+// list. Entries match the host itself and all of its subdomains; a "*" entry
+// matches all hosts.
+func (p Proxy) excludes(host string) bool {
+	host = strings.ToLower(host)
+	for _, entry := range strings.Split(p.noProxy(), ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+
+		// Drop any port, and the leading dot of domain entries.
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		entry = strings.TrimPrefix(entry, ".")
+
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func getenvAny(keys []string) string {
+	for _, key := range keys {
+		if val := os.Getenv(key); val != "" {
+			return val
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stream_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/stream"
+)
+
+func TestProxyFunc(t *testing.T) {
+	proxy := stream.Proxy{
+		HTTPSProxy: "http://proxy.example.com:3128",
+		NoProxy:    "internal.example.com, .corp.example.com,*.ignored",
+	}
+
+	tests := []struct {
+		name     string
+		url      string
+		expected string
+	}{
+		{
+			"https request is proxied",
+			"https://gcr.io/v2/foo/tags/list",
+			"http://proxy.example.com:3128",
+		},
+		{
+			"plain http request is not proxied",
+			"http://gcr.io/v2/foo/tags/list",
+			"",
+		},
+		{
+			"excluded host",
+			"https://internal.example.com/v2/",
+			"",
+		},
+		{
+			"subdomain of excluded host",
+			"https://registry.internal.example.com/v2/",
+			"",
+		},
+		{
+			"subdomain of excluded domain",
+			"https://registry.corp.example.com/v2/",
+			"",
+		},
+		{
+			"similarly named host is not excluded",
+			"https://notinternal.example.com/v2/",
+			"http://proxy.example.com:3128",
+		},
+	}
+
+	proxyFunc := proxy.ProxyFunc()
+	for _, test := range tests {
+		req, err := http.NewRequest("GET", test.url, nil)
+		require.Nil(t, err)
+
+		got, err := proxyFunc(req)
+		require.Nil(t, err)
+		if test.expected == "" {
+			require.Nil(t, got, test.name)
+		} else {
+			require.NotNil(t, got, test.name)
+			require.Equal(t, test.expected, got.String(), test.name)
+		}
+	}
+}
+
+func TestProxyEnv(t *testing.T) {
+	environ := []string{
+		"PATH=/usr/bin",
+		"HTTPS_PROXY=http://env-proxy:3128",
+		"NO_PROXY=env.example.com",
+	}
+
+	// Nothing configured explicitly leaves the environment as-is.
+	require.Equal(t, environ, stream.Proxy{}.Env(environ))
+
+	// Only the configured settings are overridden.
+	require.Equal(
+		t,
+		[]string{
+			"PATH=/usr/bin",
+			"NO_PROXY=env.example.com",
+			"HTTPS_PROXY=http://proxy.example.com:3128",
+			"https_proxy=http://proxy.example.com:3128",
+		},
+		stream.Proxy{HTTPSProxy: "http://proxy.example.com:3128"}.Env(environ),
+	)
+}
@@ -17,7 +17,9 @@ limitations under the License.
 package stream
 
 import (
+	"context"
 	"io"
+	"os"
 	"os/exec"
 )
 
@@ -25,14 +27,26 @@ import (
 // from an io.Reader that produces JSON, or whatever else.
 type Subprocess struct {
 	CmdInvocation []string
-	cmd           *exec.Cmd
+	// Proxy is injected into the environment of the subprocess.
+	Proxy Proxy
+	// Context, if set, kills the subprocess when it is done.
+	Context context.Context
+	cmd     *exec.Cmd
 }
 
 // Produce runs the external process and returns two io.Readers (to stdout and
 // stderr).
 func (sp *Subprocess) Produce() (stdOut, stdErr io.Reader, err error) {
 	invocation := sp.CmdInvocation
-	cmd := exec.Command(invocation[0], invocation[1:]...)
+	var cmd *exec.Cmd
+	if sp.Context != nil {
+		cmd = exec.CommandContext(sp.Context, invocation[0], invocation[1:]...)
+	} else {
+		cmd = exec.Command(invocation[0], invocation[1:]...)
+	}
+	if !sp.Proxy.IsZero() {
+		cmd.Env = sp.Proxy.Env(os.Environ())
+	}
 	stdoutReader, err := cmd.StdoutPipe()
 	if err != nil {
 		return nil, nil, err
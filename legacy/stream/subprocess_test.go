@@ -0,0 +1,78 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stream_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/stream"
+)
+
+func TestSubprocessProxyEnv(t *testing.T) {
+	// The explicit configuration must take precedence over the environment.
+	require.Nil(t, os.Setenv("HTTPS_PROXY", "http://env-proxy:3128"))
+	// nolint[errcheck]
+	defer os.Unsetenv("HTTPS_PROXY")
+
+	sp := stream.Subprocess{
+		CmdInvocation: []string{"env"},
+		Proxy: stream.Proxy{
+			HTTPSProxy: "http://proxy.example.com:3128",
+			NoProxy:    "metadata.google.internal",
+		},
+	}
+
+	stdout, _, err := sp.Produce()
+	require.Nil(t, err)
+	out, err := ioutil.ReadAll(stdout)
+	require.Nil(t, err)
+	require.Nil(t, sp.Close())
+
+	env := strings.Split(string(out), "\n")
+	require.Contains(t, env, "HTTPS_PROXY=http://proxy.example.com:3128")
+	require.Contains(t, env, "https_proxy=http://proxy.example.com:3128")
+	require.Contains(t, env, "NO_PROXY=metadata.google.internal")
+	require.Contains(t, env, "no_proxy=metadata.google.internal")
+	require.NotContains(t, env, "HTTPS_PROXY=http://env-proxy:3128")
+}
+
+func TestSubprocessContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	sp := stream.Subprocess{
+		CmdInvocation: []string{"sleep", "10"},
+		Context:       ctx,
+	}
+
+	start := time.Now()
+	stdout, _, err := sp.Produce()
+	require.Nil(t, err)
+	_, err = ioutil.ReadAll(stdout)
+	require.Nil(t, err)
+
+	// The subprocess is killed once the context is done.
+	require.NotNil(t, sp.Close())
+	require.Less(t, time.Since(start), 5*time.Second)
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package stream
 
 import (
+	"errors"
 	"io"
 	"time"
 
@@ -64,3 +65,58 @@ func BackoffDefault() *backoff.ExponentialBackOff {
 
 	return b
 }
+
+// DefaultRetryStatusCodes are the HTTP status codes of the responses that are
+// retried by default, as they usually mean that the error is transient.
+var DefaultRetryStatusCodes = []int{429, 500, 502, 503, 504}
+
+// RetryPolicy decides which failed requests are retried.
+type RetryPolicy struct {
+	// StatusCodes are the HTTP status codes (see StatusError) that are
+	// retried. If it is nil, DefaultRetryStatusCodes are retried.
+	StatusCodes []int
+}
+
+// Retryable returns whether a request that failed with err should be retried.
+// Errors that did not come with an HTTP response (e.g., connection errors) are
+// always retried.
+func (p RetryPolicy) Retryable(err error) bool {
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		return true
+	}
+
+	codes := p.StatusCodes
+	if codes == nil {
+		codes = DefaultRetryStatusCodes
+	}
+
+	for _, code := range codes {
+		if code == statusErr.StatusCode {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Retry calls fn until it succeeds, fails with an error that is not Retryable,
+// or b stops the retries (see backoff.RetryNotify()).
+func (p RetryPolicy) Retry(
+	fn func() error,
+	b backoff.BackOff,
+	notify backoff.Notify,
+) error {
+	return backoff.RetryNotify(
+		func() error {
+			err := fn()
+			if err != nil && !p.Retryable(err) {
+				return backoff.Permanent(err)
+			}
+
+			return err
+		},
+		b,
+		notify,
+	)
+}
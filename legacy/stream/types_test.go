@@ -0,0 +1,129 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stream_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/stream"
+)
+
+func TestRetryPolicyRetryable(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   stream.RetryPolicy
+		err      error
+		expected bool
+	}{
+		{
+			"Default status code",
+			stream.RetryPolicy{},
+			&stream.StatusError{StatusCode: http.StatusServiceUnavailable},
+			true,
+		},
+		{
+			"Non-default status code",
+			stream.RetryPolicy{},
+			&stream.StatusError{StatusCode: http.StatusNotFound},
+			false,
+		},
+		{
+			"Configured status code",
+			stream.RetryPolicy{StatusCodes: []int{http.StatusNotFound}},
+			&stream.StatusError{StatusCode: http.StatusNotFound},
+			true,
+		},
+		{
+			"Status code that is no longer configured",
+			stream.RetryPolicy{StatusCodes: []int{http.StatusNotFound}},
+			&stream.StatusError{StatusCode: http.StatusServiceUnavailable},
+			false,
+		},
+		{
+			"Connection error",
+			stream.RetryPolicy{StatusCodes: []int{}},
+			errors.New("connection refused"),
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		got := test.policy.Retryable(test.err)
+		require.Equal(t, test.expected, got, test.name)
+	}
+}
+
+func TestRetryPolicyRetry(t *testing.T) {
+	tests := []struct {
+		name             string
+		statusCodes      []int
+		expectedRequests int
+	}{
+		{
+			"Configured status code is retried",
+			[]int{http.StatusBadGateway},
+			3,
+		},
+		{
+			"Other status code is not retried",
+			[]int{http.StatusServiceUnavailable},
+			1,
+		},
+	}
+
+	for _, test := range tests {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				http.Error(w, "try again", http.StatusBadGateway)
+			},
+		))
+
+		policy := stream.RetryPolicy{StatusCodes: test.statusCodes}
+		err := policy.Retry(
+			func() error {
+				req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+				if err != nil {
+					return err
+				}
+
+				h := stream.HTTP{Req: req}
+				if _, _, err := h.Produce(); err != nil {
+					return err
+				}
+
+				return h.Close()
+			},
+			backoff.WithMaxRetries(&backoff.ZeroBackOff{}, 2),
+			func(error, time.Duration) {},
+		)
+		server.Close()
+
+		var statusErr *stream.StatusError
+		require.True(t, errors.As(err, &statusErr), test.name)
+		require.Equal(t, http.StatusBadGateway, statusErr.StatusCode, test.name)
+		require.Equal(t, test.expectedRequests, requests, test.name)
+	}
+}
@@ -0,0 +1,511 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package testregistry provides an in-process container registry, so that
+// reading and promoting images can be tested end-to-end without access to a
+// real registry.
+package testregistry
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/google"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/stream"
+)
+
+// Registry is an in-process registry, served over plain HTTP on the loopback
+// interface. It implements the registry API with the registry of
+// go-containerregistry, and in addition answers "tags/list" requests the way
+// GCR does (listing the digests, tags and child repositories of each
+// repository), so that it can be read with ReadRegistries().
+//
+// Unlike the registry of go-containerregistry, blobs belong to repositories,
+// like in real registries: a blob that was written to one repository has to be
+// uploaded to (or mounted into) another one before it can be used there. Blob
+// mounts are recorded (see Mounts()).
+//
+// Images can be written to it with the helpers below, or by promoting them
+// in-process (see SyncContext.Promote()), which treats loopback registries as
+// insecure.
+type Registry struct {
+	// Host is the "<ip>:<port>" the registry is served at.
+	Host string
+
+	server *httptest.Server
+	inner  http.Handler
+	mutex  sync.Mutex
+	// repos holds the manifests of every repository by digest, as they are
+	// listed by "tags/list".
+	repos map[string]map[reg.Digest]*google.ManifestInfo
+	// blobs holds the blobs of every repository.
+	blobs  map[string]map[reg.Digest]bool
+	mounts []Mount
+}
+
+// Mount is a blob that was mounted into a repository from another one.
+type Mount struct {
+	Repo   string
+	From   string
+	Digest reg.Digest
+}
+
+// New starts an empty Registry. It must be closed with Close().
+func New() *Registry {
+	r := &Registry{
+		inner: registry.New(registry.Logger(log.New(ioutil.Discard, "", 0))),
+		repos: make(map[string]map[reg.Digest]*google.ManifestInfo),
+		blobs: make(map[string]map[reg.Digest]bool),
+	}
+	r.server = httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+	r.Host = strings.TrimPrefix(r.server.URL, "http://")
+
+	return r
+}
+
+// Close shuts down the Registry.
+func (r *Registry) Close() {
+	r.server.Close()
+}
+
+// Name returns the name of the registry (in the promoter's sense) with the
+// given root repository, e.g. "127.0.0.1:1234/staging" for "staging".
+func (r *Registry) Name(root string) reg.RegistryName {
+	return reg.RegistryName(r.Host + "/" + root)
+}
+
+// Push writes img to the given repository (e.g., "staging/foo") under each of
+// the given tags, or only by its digest if there are none, and returns its
+// digest.
+func (r *Registry) Push(
+	repo string,
+	img v1.Image,
+	tags ...reg.Tag,
+) (reg.Digest, error) {
+	return r.push(repo, img, tags, func(ref name.Reference) error {
+		return remote.Write(ref, img)
+	})
+}
+
+// PushIndex writes the manifest list idx (along with its images, which are
+// only written by their digests) to the given repository under each of the
+// given tags, or only by its digest if there are none, and returns its digest.
+func (r *Registry) PushIndex(
+	repo string,
+	idx v1.ImageIndex,
+	tags ...reg.Tag,
+) (reg.Digest, error) {
+	return r.push(repo, idx, tags, func(ref name.Reference) error {
+		return remote.WriteIndex(ref, idx)
+	})
+}
+
+// PushRandom writes a new random image to the given repository, like Push().
+func (r *Registry) PushRandom(repo string, tags ...reg.Tag) (reg.Digest, error) {
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		return "", err
+	}
+
+	return r.Push(repo, img, tags...)
+}
+
+// PushRandomIndex writes a new manifest list of n random images to the given
+// repository, like PushIndex().
+func (r *Registry) PushRandomIndex(
+	repo string,
+	n int64,
+	tags ...reg.Tag,
+) (reg.Digest, error) {
+	idx, err := random.Index(1024, 1, n)
+	if err != nil {
+		return "", err
+	}
+
+	// The promoter only knows Docker manifest lists.
+	return r.PushIndex(
+		repo,
+		mutate.IndexMediaType(idx, types.DockerManifestList),
+		tags...)
+}
+
+func (r *Registry) push(
+	repo string,
+	t remote.Taggable,
+	tags []reg.Tag,
+	write func(ref name.Reference) error,
+) (reg.Digest, error) {
+	raw, err := t.RawManifest()
+	if err != nil {
+		return "", err
+	}
+	digest := digestOf(raw)
+
+	ref, err := name.NewDigest(r.Host + "/" + repo + "@" + string(digest))
+	if err != nil {
+		return "", err
+	}
+	if err := write(ref); err != nil {
+		return "", fmt.Errorf("pushing %s: %w", ref, err)
+	}
+
+	for _, tag := range tags {
+		tagRef, err := name.NewTag(r.Host + "/" + repo + ":" + string(tag))
+		if err != nil {
+			return "", err
+		}
+		if err := remote.Tag(tagRef, t); err != nil {
+			return "", fmt.Errorf("tagging %s: %w", tagRef, err)
+		}
+	}
+
+	return digest, nil
+}
+
+// Inventory returns the images below the given root repository, by their
+// names relative to it, in the same form as ReadRegistries() reads them into
+// SyncContext.Inv. Tags are sorted.
+func (r *Registry) Inventory(root string) reg.RegInvImage {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	rii := make(reg.RegInvImage)
+	for repo, manifests := range r.repos {
+		if !strings.HasPrefix(repo, root+"/") || len(manifests) == 0 {
+			continue
+		}
+
+		digestTags := make(reg.DigestTags)
+		for digest, info := range manifests {
+			tags := reg.TagSlice{}
+			for _, tag := range info.Tags {
+				tags = append(tags, reg.Tag(tag))
+			}
+			digestTags[digest] = tags
+		}
+		rii[reg.ImageName(strings.TrimPrefix(repo, root+"/"))] = digestTags
+	}
+
+	return rii
+}
+
+// Mounts returns the blob mounts made so far, in order.
+func (r *Registry) Mounts() []Mount {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	mounts := make([]Mount, len(r.mounts))
+	copy(mounts, r.mounts)
+
+	return mounts
+}
+
+// MkReadRepositoryCmd is like MkReadRepositoryCmdReal(), but reads the
+// repository from the Registry over plain HTTP.
+func (r *Registry) MkReadRepositoryCmd(
+	sc *reg.SyncContext,
+	rc reg.RegistryContext,
+) stream.Producer {
+	_, _, repoPath := reg.GetTokenKeyDomainRepoPath(rc.Name)
+
+	req, err := http.NewRequest(
+		http.MethodGet,
+		fmt.Sprintf("%s/v2/%s/tags/list", r.server.URL, repoPath),
+		nil,
+	)
+	if err != nil {
+		// The URL is always valid.
+		panic(err)
+	}
+
+	return &stream.HTTP{Req: req}
+}
+
+func (r *Registry) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	if repo, target, ok := splitPath(req.URL.Path, "tags"); ok &&
+		target == "list" && req.Method == http.MethodGet {
+		r.listTags(w, repo)
+		return
+	}
+
+	if repo, target, ok := splitBlobPath(req.URL.Path); ok {
+		r.serveBlob(w, req, repo, target)
+		return
+	}
+
+	repo, target, ok := splitPath(req.URL.Path, "manifests")
+	if !ok || (req.Method != http.MethodPut && req.Method != http.MethodDelete) {
+		r.inner.ServeHTTP(w, req)
+		return
+	}
+
+	// Hold the lock while the manifest is written, so that the listing
+	// follows the writes in the order the registry makes them.
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if req.Method == http.MethodDelete {
+		rec := &statusRecorder{ResponseWriter: w}
+		r.inner.ServeHTTP(rec, req)
+		if rec.status == http.StatusAccepted {
+			r.deleted(repo, target)
+		}
+		return
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	rec := &statusRecorder{ResponseWriter: w}
+	r.inner.ServeHTTP(rec, req)
+	if rec.status == http.StatusCreated {
+		r.written(repo, target, body, req.Header.Get("Content-Type"))
+	}
+}
+
+// serveBlob serves the blob requests of a repository, keeping track of the
+// blobs of each repository, and answering mount requests for the blobs of
+// other repositories.
+func (r *Registry) serveBlob(
+	w http.ResponseWriter,
+	req *http.Request,
+	repo, target string,
+) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	switch {
+	case req.Method == http.MethodHead && !r.blobs[repo][reg.Digest(target)]:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case req.Method == http.MethodPost && target == "uploads/":
+		query := req.URL.Query()
+		digest := reg.Digest(query.Get("mount"))
+		from := query.Get("from")
+		if digest != "" && r.blobs[from][digest] {
+			r.addBlob(repo, digest)
+			r.mounts = append(r.mounts, Mount{Repo: repo, From: from, Digest: digest})
+
+			w.Header().Set("Location", "/v2/"+repo+"/blobs/"+string(digest))
+			w.Header().Set("Docker-Content-Digest", string(digest))
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+	}
+
+	rec := &statusRecorder{ResponseWriter: w}
+	r.inner.ServeHTTP(rec, req)
+
+	// Uploads are completed with the digest of the blob.
+	digest := req.URL.Query().Get("digest")
+	if rec.status == http.StatusCreated && digest != "" {
+		r.addBlob(repo, reg.Digest(digest))
+	}
+}
+
+func (r *Registry) addBlob(repo string, digest reg.Digest) {
+	if r.blobs[repo] == nil {
+		r.blobs[repo] = make(map[reg.Digest]bool)
+	}
+	r.blobs[repo][digest] = true
+}
+
+// written records a manifest that was written to repo, under the given target
+// (a tag or a digest). It must be called with the mutex held.
+func (r *Registry) written(repo, target string, body []byte, mediaType string) {
+	manifests, ok := r.repos[repo]
+	if !ok {
+		manifests = make(map[reg.Digest]*google.ManifestInfo)
+		r.repos[repo] = manifests
+	}
+
+	digest := digestOf(body)
+	info, ok := manifests[digest]
+	if !ok {
+		now := time.Now()
+		info = &google.ManifestInfo{
+			Size:      imageSize(body, mediaType),
+			MediaType: mediaType,
+			Created:   now,
+			Uploaded:  now,
+			Tags:      []string{},
+		}
+		manifests[digest] = info
+	}
+
+	if isDigest(target) {
+		return
+	}
+
+	// A tag points to a single digest, so writing it moves it.
+	r.untag(repo, target)
+	info.Tags = append(info.Tags, target)
+	sort.Strings(info.Tags)
+}
+
+// deleted records the deletion of the given target (a tag or a digest) from
+// repo. It must be called with the mutex held.
+func (r *Registry) deleted(repo, target string) {
+	if isDigest(target) {
+		delete(r.repos[repo], reg.Digest(target))
+	} else {
+		r.untag(repo, target)
+	}
+
+	if len(r.repos[repo]) == 0 {
+		delete(r.repos, repo)
+	}
+}
+
+func (r *Registry) untag(repo, tag string) {
+	for _, info := range r.repos[repo] {
+		for i, t := range info.Tags {
+			if t == tag {
+				info.Tags = append(info.Tags[:i], info.Tags[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// listTags answers a "tags/list" request like GCR does.
+func (r *Registry) listTags(w http.ResponseWriter, repo string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	tags := google.Tags{
+		Children:  []string{},
+		Manifests: make(map[string]google.ManifestInfo),
+		Name:      repo,
+		Tags:      []string{},
+	}
+
+	for digest, info := range r.repos[repo] {
+		tags.Manifests[string(digest)] = *info
+		tags.Tags = append(tags.Tags, info.Tags...)
+	}
+	sort.Strings(tags.Tags)
+
+	children := make(map[string]bool)
+	for other := range r.repos {
+		if strings.HasPrefix(other, repo+"/") {
+			child := strings.SplitN(strings.TrimPrefix(other, repo+"/"), "/", 2)[0]
+			children[child] = true
+		}
+	}
+	for child := range children {
+		tags.Children = append(tags.Children, child)
+	}
+	sort.Strings(tags.Children)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tags); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// statusRecorder records the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// splitPath splits a registry API path such as "/v2/foo/bar/manifests/1.0"
+// into its repository ("foo/bar") and target ("1.0"), if the path is of the
+// given kind (e.g., "manifests").
+func splitPath(path, kind string) (repo, target string, ok bool) {
+	if !strings.HasPrefix(path, "/v2/") {
+		return "", "", false
+	}
+
+	elems := strings.Split(strings.TrimPrefix(path, "/v2/"), "/")
+	if len(elems) < 3 || elems[len(elems)-2] != kind {
+		return "", "", false
+	}
+
+	return strings.Join(elems[:len(elems)-2], "/"), elems[len(elems)-1], true
+}
+
+// splitBlobPath splits a blob API path such as
+// "/v2/foo/bar/blobs/uploads/<id>" into its repository ("foo/bar") and the
+// rest ("uploads/<id>").
+func splitBlobPath(path string) (repo, rest string, ok bool) {
+	i := strings.LastIndex(path, "/blobs/")
+	if !strings.HasPrefix(path, "/v2/") || i < len("/v2/") {
+		return "", "", false
+	}
+
+	return path[len("/v2/"):i], path[i+len("/blobs/"):], true
+}
+
+// imageSize returns the size of the image with the given manifest, i.e. the
+// size of its config and layers, like GCR reports it. Manifest lists have no
+// size of their own.
+func imageSize(manifest []byte, mediaType string) uint64 {
+	if !types.MediaType(mediaType).IsImage() {
+		return 0
+	}
+
+	m, err := v1.ParseManifest(bytes.NewReader(manifest))
+	if err != nil {
+		return 0
+	}
+
+	size := m.Config.Size
+	for _, layer := range m.Layers {
+		size += layer.Size
+	}
+
+	return uint64(size)
+}
+
+func digestOf(b []byte) reg.Digest {
+	sum := sha256.Sum256(b)
+	return reg.Digest("sha256:" + hex.EncodeToString(sum[:]))
+}
+
+func isDigest(target string) bool {
+	return strings.HasPrefix(target, "sha256:")
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testregistry_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/testregistry"
+)
+
+func TestReadRegistries(t *testing.T) {
+	r := testregistry.New()
+	defer r.Close()
+
+	image, err := r.PushRandom("staging/foo", "1.0", "latest")
+	require.Nil(t, err)
+	untagged, err := r.PushRandom("staging/foo")
+	require.Nil(t, err)
+	list, err := r.PushRandomIndex("staging/bar/baz", 2, "2.0")
+	require.Nil(t, err)
+
+	// Writing a tag again moves it.
+	moved, err := r.PushRandom("staging/foo", "latest")
+	require.Nil(t, err)
+
+	got := r.Inventory("staging")
+	require.Len(t, got, 2)
+	require.Equal(
+		t,
+		reg.DigestTags{
+			image:    {"1.0"},
+			untagged: {},
+			moved:    {"latest"},
+		},
+		got["foo"])
+	// The images of the manifest list are listed without tags.
+	require.Len(t, got["bar/baz"], 3)
+	require.Equal(t, reg.TagSlice{"2.0"}, got["bar/baz"][list])
+
+	rc := reg.RegistryContext{Name: r.Name("staging"), Src: true}
+	sc := reg.SyncContext{
+		RegistryContexts: []reg.RegistryContext{rc},
+		Inv:              reg.MasterInventory{},
+		DigestMediaType:  make(reg.DigestMediaType),
+		DigestImageSize:  make(reg.DigestImageSize),
+	}
+	sc.ReadRegistries([]reg.RegistryContext{rc}, true, r.MkReadRepositoryCmd)
+	require.Empty(t, sc.Logs.Errors)
+	require.Equal(t, got, sc.Inv[rc.Name])
+	require.NotZero(t, sc.DigestImageSize[image])
+	require.NotZero(t, sc.DigestUploadTime[image])
+}
+
+func TestPromote(t *testing.T) {
+	r := testregistry.New()
+	defer r.Close()
+
+	digest, err := r.PushRandom("staging/foo", "1.0")
+	require.Nil(t, err)
+	list, err := r.PushRandomIndex("staging/bar", 2, "2.0")
+	require.Nil(t, err)
+
+	mfest := reg.Manifest{
+		Registries: []reg.RegistryContext{
+			{Name: r.Name("staging"), Src: true},
+			{Name: r.Name("prod")},
+		},
+		Images: []reg.Image{
+			{
+				ImageName: "foo",
+				Dmap:      reg.DigestTags{digest: {"1.0", "stable"}},
+			},
+			{
+				ImageName: "bar",
+				Dmap:      reg.DigestTags{list: {"2.0"}},
+			},
+		},
+	}
+	mfest.SrcRegistry = &mfest.Registries[0]
+
+	promote := func() reg.SyncContext {
+		sc, err := reg.MakeSyncContext([]reg.Manifest{mfest}, 2, false, false)
+		require.Nil(t, err)
+
+		edges, err := reg.ToPromotionEdges([]reg.Manifest{mfest})
+		require.Nil(t, err)
+
+		sc.ReadRegistries(sc.RegistryContexts, true, r.MkReadRepositoryCmd)
+		require.Empty(t, sc.Logs.Errors)
+
+		edges, ok := sc.FilterPromotionEdges(edges, false)
+		require.True(t, ok)
+		require.Nil(t, sc.Promote(edges, nil, nil))
+
+		return sc
+	}
+
+	sc := promote()
+	require.Equal(t, 3, sc.Logs.Promotions.Succeeded)
+
+	got := r.Inventory("prod")
+	require.Equal(t, reg.DigestTags{digest: {"1.0", "stable"}}, got["foo"])
+	// The images of the manifest list are copied along with it.
+	require.Len(t, got["bar"], 3)
+	require.Equal(t, reg.TagSlice{"2.0"}, got["bar"][list])
+
+	// Promoting again finds everything in place.
+	sc = promote()
+	require.Zero(t, sc.Logs.Promotions.Succeeded)
+	require.Equal(t, got, r.Inventory("prod"))
+}
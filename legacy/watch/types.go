@@ -0,0 +1,52 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package watch
+
+import (
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+// PromotionFacility promotes the given edges. The edges have not been filtered
+// against the state of the registries yet. It returns a RejectedError if the
+// edges can never be promoted.
+type PromotionFacility func(edges map[reg.PromotionEdge]interface{}) error
+
+// RejectedError is returned by a PromotionFacility if the edges can never be
+// promoted as the manifests ask for, e.g. because that would move a tag. The
+// message is acknowledged rather than retried, because redelivering it cannot
+// change that.
+type RejectedError struct {
+	Err error
+}
+
+func (e *RejectedError) Error() string { return e.Err.Error() }
+func (e *RejectedError) Unwrap() error { return e.Err }
+
+// ServerContext holds all of the initialization data for the watch server to
+// start up.
+type ServerContext struct {
+	ID        string
+	Manifests []reg.Manifest
+	// Edges are the promotion edges of the Manifests, as the
+	// PromotionFacility promotes them. If nil, they are made with
+	// reg.ToPromotionEdges().
+	Edges             map[reg.PromotionEdge]interface{}
+	PromotionFacility PromotionFacility
+	// CanApprove is true if the PromotionFacility asks for the approval of
+	// promotions to registries that require it (see Validate()).
+	CanApprove bool
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/audit"
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+)
+
+// RunWatcher runs an HTTP server that promotes images as GCR Pub/Sub push
+// events announce that they were pushed to a source registry.
+func (s *ServerContext) RunWatcher() {
+	logrus.Info("Starting Watcher")
+
+	http.HandleFunc(
+		"/",
+		func(w http.ResponseWriter, r *http.Request) {
+			s.Watch(w, r)
+		},
+	)
+
+	// Determine port for HTTP service.
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+		logrus.Infof("Defaulting to port %s", port)
+	}
+	// Start HTTP server.
+	logrus.Infof("Listening on port %s", port)
+	logrus.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", port), nil))
+}
+
+// Validate checks that the watcher can promote the images of its manifests. A
+// watcher promotes without anyone looking, so it must not start if a
+// destination requires approval but the PromotionFacility cannot ask for it.
+func (s *ServerContext) Validate() error {
+	for _, mfest := range s.Manifests {
+		for _, rc := range mfest.Registries {
+			if rc.RequiresApproval && !s.CanApprove {
+				return fmt.Errorf(
+					"registry %q requires approval, which the watcher cannot ask for",
+					rc.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Watch receives and processes a Pub/Sub push message. If the message announces
+// an image that the manifests want to promote from a source registry, that
+// image is promoted.
+//
+// Messages that can never result in a promotion (malformed messages, deletions,
+// images not in any manifest, or edges that the PromotionFacility rejects) are
+// acknowledged with a 200 OK, so that they are not redelivered. Failed
+// promotions are answered with an HTTP error, so that Pub/Sub retries them.
+func (s *ServerContext) Watch(w http.ResponseWriter, r *http.Request) {
+	gcrPayload, err := audit.ParsePubSubMessage(r.Body)
+	if err != nil {
+		s.ignore(w, "parse failure: %v", err)
+		return
+	}
+
+	if err := gcrPayload.PopulateExtraFields(); err != nil {
+		s.ignore(w, "validation failure: %v", err)
+		return
+	}
+
+	if gcrPayload.Action != "INSERT" {
+		s.ignore(w, "%v: not an insertion", gcrPayload)
+		return
+	}
+
+	allEdges, err := s.edges()
+	if err != nil {
+		s.ignore(w, "%v: %v", gcrPayload, err)
+		return
+	}
+
+	edges := MatchingEdges(allEdges, gcrPayload)
+	if len(edges) == 0 {
+		s.ignore(w, "%v: not in any manifest", gcrPayload)
+		return
+	}
+
+	logrus.Infof(
+		"(%s) PROMOTING: %v (%d edges)", s.ID, gcrPayload, len(edges))
+
+	if err := s.PromotionFacility(edges); err != nil {
+		var rejected *RejectedError
+		if errors.As(err, &rejected) {
+			msg := fmt.Sprintf(
+				"(%s) PROMOTION REJECTED: %v: %v\n", s.ID, gcrPayload, err)
+			logrus.Error(msg)
+			_, _ = w.Write([]byte(msg))
+			return
+		}
+
+		logrus.Errorf(
+			"(%s) PROMOTION FAILED: %v: %v", s.ID, gcrPayload, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	msg := fmt.Sprintf("(%s) PROMOTED: %v\n", s.ID, gcrPayload)
+	logrus.Info(msg)
+	_, _ = w.Write([]byte(msg))
+}
+
+// ignore acknowledges a message that will not result in a promotion.
+func (s *ServerContext) ignore(
+	w http.ResponseWriter,
+	format string,
+	args ...interface{},
+) {
+	msg := fmt.Sprintf("(%s) IGNORED: %s\n", s.ID, fmt.Sprintf(format, args...))
+	logrus.Info(msg)
+	_, _ = w.Write([]byte(msg))
+}
+
+// edges returns the promotion edges of the manifests (see ServerContext.Edges).
+func (s *ServerContext) edges() (map[reg.PromotionEdge]interface{}, error) {
+	if s.Edges != nil {
+		return s.Edges, nil
+	}
+
+	return reg.ToPromotionEdges(s.Manifests)
+}
+
+// MatchingEdges returns the promotion edges that promote the image described by
+// the payload from its source registry.
+func MatchingEdges(
+	edges map[reg.PromotionEdge]interface{},
+	gcrPayload *reg.GCRPubSubPayload,
+) map[reg.PromotionEdge]interface{} {
+	matching := make(map[reg.PromotionEdge]interface{})
+
+	// Without a digest, we cannot tell which image the manifests refer to.
+	if gcrPayload.Digest == "" {
+		return matching
+	}
+
+	for edge := range edges {
+		srcPath := string(edge.SrcRegistry.Name) +
+			"/" +
+			string(edge.SrcImageTag.ImageName)
+
+		if srcPath == gcrPayload.Path && edge.Digest == gcrPayload.Digest {
+			matching[edge] = nil
+		}
+	}
+
+	return matching
+}
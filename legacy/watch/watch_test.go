@@ -0,0 +1,283 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package watch_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/audit"
+	reg "sigs.k8s.io/k8s-container-image-promoter/legacy/dockerregistry"
+	"sigs.k8s.io/k8s-container-image-promoter/legacy/watch"
+)
+
+const (
+	digestA = "sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	digestB = "sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+)
+
+func mkPubSubBody(t *testing.T, payload reg.GCRPubSubPayload) string {
+	data, err := json.Marshal(payload)
+	require.Nil(t, err)
+
+	body, err := json.Marshal(audit.PubSubMessage{
+		Message: audit.PubSubMessageInner{
+			Data: data,
+			ID:   "1",
+		},
+		Subscription: "projects/foo/subscriptions/cip-watch",
+	})
+	require.Nil(t, err)
+
+	return string(body)
+}
+
+func TestWatch(t *testing.T) {
+	srcRC := reg.RegistryContext{
+		Name: "gcr.io/foo-staging",
+		Src:  true,
+	}
+	destRC := reg.RegistryContext{
+		Name:           "gcr.io/foo",
+		ServiceAccount: "robot",
+	}
+
+	mfest := reg.Manifest{
+		Registries: []reg.RegistryContext{srcRC, destRC},
+		Images: []reg.Image{
+			{
+				ImageName: "a",
+				Dmap: reg.DigestTags{
+					digestA: {"1.0"},
+				},
+			},
+		},
+	}
+	require.Nil(t, mfest.Finalize())
+
+	expectedEdge := reg.PromotionEdge{
+		SrcRegistry: srcRC,
+		SrcImageTag: reg.ImageTag{
+			ImageName: "a",
+			Tag:       "1.0",
+		},
+		Digest:      digestA,
+		DstRegistry: destRC,
+		DstImageTag: reg.ImageTag{
+			ImageName: "a",
+			Tag:       "1.0",
+		},
+	}
+
+	namespacedEdge := expectedEdge
+	namespacedEdge.DstImageTag.ImageName = "mirror/a"
+
+	tests := []struct {
+		name           string
+		body           string
+		edges          map[reg.PromotionEdge]interface{}
+		promotionErr   error
+		expectedEdges  map[reg.PromotionEdge]interface{}
+		expectedStatus int
+	}{
+		{
+			"Push of an image in the manifest is promoted",
+			mkPubSubBody(t, reg.GCRPubSubPayload{
+				Action: "INSERT",
+				FQIN:   "gcr.io/foo-staging/a@" + digestA,
+				PQIN:   "gcr.io/foo-staging/a:1.0",
+			}),
+			nil,
+			nil,
+			map[reg.PromotionEdge]interface{}{expectedEdge: nil},
+			http.StatusOK,
+		},
+		{
+			"Push is promoted along the given edges",
+			mkPubSubBody(t, reg.GCRPubSubPayload{
+				Action: "INSERT",
+				FQIN:   "gcr.io/foo-staging/a@" + digestA,
+			}),
+			map[reg.PromotionEdge]interface{}{namespacedEdge: nil},
+			nil,
+			map[reg.PromotionEdge]interface{}{namespacedEdge: nil},
+			http.StatusOK,
+		},
+		{
+			"Failed promotion is retried",
+			mkPubSubBody(t, reg.GCRPubSubPayload{
+				Action: "INSERT",
+				FQIN:   "gcr.io/foo-staging/a@" + digestA,
+			}),
+			nil,
+			errors.New("could not copy image"),
+			map[reg.PromotionEdge]interface{}{expectedEdge: nil},
+			http.StatusInternalServerError,
+		},
+		{
+			"Rejected promotion is not retried",
+			mkPubSubBody(t, reg.GCRPubSubPayload{
+				Action: "INSERT",
+				FQIN:   "gcr.io/foo-staging/a@" + digestA,
+			}),
+			nil,
+			fmt.Errorf(
+				"promoting: %w",
+				&watch.RejectedError{Err: errors.New("tag move detected")}),
+			map[reg.PromotionEdge]interface{}{expectedEdge: nil},
+			http.StatusOK,
+		},
+		{
+			"Push of an unknown digest is ignored",
+			mkPubSubBody(t, reg.GCRPubSubPayload{
+				Action: "INSERT",
+				FQIN:   "gcr.io/foo-staging/a@" + digestB,
+			}),
+			nil,
+			nil,
+			nil,
+			http.StatusOK,
+		},
+		{
+			"Push to the destination registry is ignored",
+			mkPubSubBody(t, reg.GCRPubSubPayload{
+				Action: "INSERT",
+				FQIN:   "gcr.io/foo/a@" + digestA,
+			}),
+			nil,
+			nil,
+			nil,
+			http.StatusOK,
+		},
+		{
+			"Deletion is ignored",
+			mkPubSubBody(t, reg.GCRPubSubPayload{
+				Action: "DELETE",
+				FQIN:   "gcr.io/foo-staging/a@" + digestA,
+			}),
+			nil,
+			nil,
+			nil,
+			http.StatusOK,
+		},
+		{
+			"Malformed message is ignored",
+			`{"message": "not an object"}`,
+			nil,
+			nil,
+			nil,
+			http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		var gotEdges map[reg.PromotionEdge]interface{}
+		test := test
+		s := watch.ServerContext{
+			ID:        "test",
+			Manifests: []reg.Manifest{mfest},
+			Edges:     test.edges,
+			PromotionFacility: func(
+				edges map[reg.PromotionEdge]interface{},
+			) error {
+				gotEdges = edges
+				return test.promotionErr
+			},
+		}
+
+		req := httptest.NewRequest(
+			http.MethodPost, "/", strings.NewReader(test.body))
+		rec := httptest.NewRecorder()
+		s.Watch(rec, req)
+
+		require.Equal(t, test.expectedStatus, rec.Code, test.name)
+		require.Equal(t, test.expectedEdges, gotEdges, test.name)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	srcRC := reg.RegistryContext{
+		Name: "gcr.io/foo-staging",
+		Src:  true,
+	}
+	destRC := reg.RegistryContext{
+		Name:           "gcr.io/foo",
+		ServiceAccount: "robot",
+	}
+	approvalRC := reg.RegistryContext{
+		Name:             "gcr.io/foo-approved",
+		ServiceAccount:   "robot",
+		RequiresApproval: true,
+	}
+
+	tests := []struct {
+		name        string
+		registries  []reg.RegistryContext
+		canApprove  bool
+		expectedErr bool
+	}{
+		{
+			"No destination requires approval",
+			[]reg.RegistryContext{srcRC, destRC},
+			false,
+			false,
+		},
+		{
+			"A destination requires approval, which cannot be asked for",
+			[]reg.RegistryContext{srcRC, destRC, approvalRC},
+			false,
+			true,
+		},
+		{
+			"A destination requires approval, which can be asked for",
+			[]reg.RegistryContext{srcRC, destRC, approvalRC},
+			true,
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		mfest := reg.Manifest{
+			Registries: test.registries,
+			Images: []reg.Image{
+				{
+					ImageName: "a",
+					Dmap: reg.DigestTags{
+						digestA: {"1.0"},
+					},
+				},
+			},
+		}
+		require.Nil(t, mfest.Finalize(), test.name)
+
+		s := watch.ServerContext{
+			ID:         "test",
+			Manifests:  []reg.Manifest{mfest},
+			CanApprove: test.canApprove,
+		}
+
+		err := s.Validate()
+		require.Equal(t, test.expectedErr, err != nil, test.name)
+	}
+}